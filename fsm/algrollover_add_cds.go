@@ -0,0 +1,226 @@
+package fsm
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/miekg/dns"
+)
+
+var FsmAlgRolloverAddCDS = music.FSMTransition{
+	Description: "Wait for the DNSKEY RRset with both algorithms to propagate (criteria), then publish CDS/CDNSKEYs for the new algorithm KSKs only in all signers (action)",
+
+	MermaidPreCondDesc:  "Wait for the new DNSKEY RRset to propagate",
+	MermaidActionDesc:   "Publish CDS/CDNSKEY RRsets for the new algorithm KSKs on all signers",
+	MermaidPostCondDesc: "Verify that only CDS/CDNSKEYs for the new algorithm KSKs are published",
+
+	PreCondition:  AlgRolloverWaitDnskeyPreCondition,
+	Action:        AlgRolloverAddCdsAction,
+	PostCondition: AlgRolloverVerifyCdsPublished,
+}
+
+// rolloverKSKs returns the KSKs of the new algorithm published by the signers in the
+// signergroup.
+func rolloverKSKs(z *music.Zone, newalg uint8) ([]*dns.DNSKEY, bool) {
+	var ksks []*dns.DNSKEY
+	seen := map[string]bool{}
+	for _, s := range z.SGroup.SignerMap {
+		updater := music.GetUpdater(s.Method)
+		err, rrs := updater.FetchRRset(s, z.Name, z.Name, dns.TypeDNSKEY)
+		if err != nil {
+			z.SetStopReason(fmt.Sprintf("Unable to fetch DNSKEYs from %s: %v", s.Name, err))
+			return nil, false
+		}
+		for _, a := range rrs {
+			dnskey, ok := a.(*dns.DNSKEY)
+			if !ok || dnskey.Algorithm != newalg || dnskey.Flags&0x101 != 257 || seen[dnskey.PublicKey] {
+				continue
+			}
+			seen[dnskey.PublicKey] = true
+			ksks = append(ksks, dnskey)
+		}
+	}
+	if len(ksks) == 0 {
+		z.SetStopReason(fmt.Sprintf("No %s KSKs published by the signers", dns.AlgorithmToString[newalg]))
+		return nil, false
+	}
+	return ksks, true
+}
+
+// AlgRolloverWaitDnskeyPreCondition waits for the DNSKEY RRset with the keys of the new
+// algorithm to propagate before the DS RRset in the parent is changed. The wait is given
+// by the DS hold down of the policy, or twice the largest DNSKEY TTL.
+func AlgRolloverWaitDnskeyPreCondition(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("AlgRolloverWaitDnskeyPreCondition: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+
+	if until, ok := z.Waiting("wait-dnskey"); ok {
+		z.Explain("wait-dnskey ended", "", 0, !time.Now().Before(until), nil,
+			"waiting until "+until.Format(time.RFC3339))
+		if time.Now().Before(until) {
+			z.SetStopReason(fmt.Sprintf("Waiting until %s (%s)", until.String(),
+				time.Until(until).String()))
+			return false
+		}
+		log.Printf("%s: Waited enough for DNSKEY, pre-condition fullfilled", z.Name)
+		z.EndWait("wait-dnskey")
+		return true
+	}
+
+	var ttl uint32
+	for _, s := range z.SGroup.SignerMap {
+		updater := music.GetUpdater(s.Method)
+		err, rrs := updater.FetchRRset(s, z.Name, z.Name, dns.TypeDNSKEY)
+		if err != nil {
+			z.Explain("fetch RRset", s.Name, dns.TypeDNSKEY, false, nil, err.Error())
+			z.SetStopReason(fmt.Sprintf("Unable to fetch DNSKEYs from %s: %v", s.Name, err))
+			return false
+		}
+		z.Explain("fetch RRset", s.Name, dns.TypeDNSKEY, true, rrs, "")
+		for _, rr := range rrs {
+			if rr.Header().Ttl > ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+
+	until := time.Now().Add(music.HoldDown(z.Policy().DsHoldDown, ttl))
+	z.WaitUntil("wait-dnskey", until)
+	z.SetStopReason(fmt.Sprintf("Largest DNSKEY TTL found was %d, waiting until %s (%s)", ttl,
+		until.String(), time.Until(until).String()))
+	return false
+}
+
+// AlgRolloverAddCdsAction publishes CDS/CDNSKEY RRsets for the KSKs of the new algorithm
+// in all signers, replacing any other CDS/CDNSKEYs.
+func AlgRolloverAddCdsAction(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("AlgRolloverAddCdsAction: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+	if !z.Type().ParentSignals {
+		log.Printf("AlgRolloverAddCdsAction: zone %s (%s) does not signal the parent, no CDS/CDNSKEY", z.Name, z.ZoneType)
+		return true
+	}
+
+	newalg, _, ok := rolloverAlgorithms(z)
+	if !ok {
+		return false
+	}
+	ksks, ok := rolloverKSKs(z, newalg)
+	if !ok {
+		return false
+	}
+
+	policy := z.Policy()
+	cdsttl := policy.CdsTTL
+	if ttl, ok := z.ProcessParamInt("cds-ttl"); ok {
+		cdsttl = uint32(ttl)
+	}
+	var cdses, cdnskeys []dns.RR
+	for _, dnskey := range ksks {
+		for _, dt := range policy.CdsDigests(dns.SHA256, dns.SHA384) {
+			cds := dnskey.ToDS(dt).ToCDS()
+			if cdsttl != 0 {
+				cds.Hdr.Ttl = cdsttl
+			}
+			cdses = append(cdses, cds)
+		}
+		cdnskey := dnskey.ToCDNSKEY()
+		if cdsttl != 0 {
+			cdnskey.Hdr.Ttl = cdsttl
+		}
+		cdnskeys = append(cdnskeys, cdnskey)
+	}
+	for rrtype, rrset := range map[uint16][]dns.RR{dns.TypeCDS: cdses, dns.TypeCDNSKEY: cdnskeys} {
+		if err := music.CheckResponseSize(z.Name, rrtype, rrset, ksks); err != nil {
+			z.SetStopReason(err.Error())
+			return false
+		}
+	}
+
+	if !updatesSupported(z, groupSigners(z), dns.TypeCDS, dns.TypeCDNSKEY) {
+		return false
+	}
+	for _, signer := range z.SGroup.SignerMap {
+		// CDS/CDNSKEYs for the old algorithm, if any, are removed
+		if _, _, err := music.ApplyRRsetChanges(signer, z.Name, []music.RRsetChange{
+			{Owner: z.Name, RRtype: dns.TypeCDS, Present: cdses, Exact: true},
+			{Owner: z.Name, RRtype: dns.TypeCDNSKEY, Present: cdnskeys, Exact: true},
+		}); err != nil {
+			z.SetStopReason(fmt.Sprintf("Unable to update %s with CDS/CDNSKEY record sets: %s",
+				signer.Name, err))
+			return false
+		}
+		log.Printf("%s: Updated %s successfully with %s CDS/CDNSKEY record sets", z.Name,
+			signer.Name, dns.AlgorithmToString[newalg])
+	}
+	return true
+}
+
+// AlgRolloverVerifyCdsPublished verifies that all the signers in the signergroup publish
+// CDS/CDNSKEYs for the KSKs of the new algorithm, and for no other keys.
+func AlgRolloverVerifyCdsPublished(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("AlgRolloverVerifyCdsPublished: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+	if !z.Type().ParentSignals {
+		return true
+	}
+
+	newalg, _, ok := rolloverAlgorithms(z)
+	if !ok {
+		return false
+	}
+	ksks, ok := rolloverKSKs(z, newalg)
+	if !ok {
+		return false
+	}
+	want := map[uint16]bool{}
+	for _, k := range ksks {
+		want[k.KeyTag()] = true
+	}
+
+	for _, signer := range z.SGroup.SignerMap {
+		updater := music.GetUpdater(signer.Method)
+		for _, rrtype := range []uint16{dns.TypeCDS, dns.TypeCDNSKEY} {
+			err, rrs := updater.FetchRRset(signer, z.Name, z.Name, rrtype)
+			if err != nil {
+				z.SetStopReason(fmt.Sprintf("Unable to fetch %s RRset from %s: %v",
+					dns.TypeToString[rrtype], signer.Name, err))
+				return false
+			}
+			have := map[uint16]bool{}
+			for _, rr := range rrs {
+				var keytag uint16
+				switch r := rr.(type) {
+				case *dns.CDS:
+					keytag = r.KeyTag
+				case *dns.CDNSKEY:
+					keytag = r.KeyTag()
+				default:
+					continue
+				}
+				if !want[keytag] {
+					z.SetStopReason(fmt.Sprintf("%s RR with keyid=%d published by %s should not exist",
+						dns.TypeToString[rrtype], keytag, signer.Name))
+					return false
+				}
+				have[keytag] = true
+			}
+			for keytag := range want {
+				if !have[keytag] {
+					z.SetStopReason(fmt.Sprintf("%s RR with keyid=%d should be published by %s, but is not",
+						dns.TypeToString[rrtype], keytag, signer.Name))
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
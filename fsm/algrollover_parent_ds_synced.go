@@ -0,0 +1,93 @@
+package fsm
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/miekg/dns"
+)
+
+var FsmAlgRolloverParentDsSynced = music.FSMTransition{
+	Description: "Wait for parent to replace the DS RRset with DSes for the new algorithm KSKs (criteria), then remove CDS/CDNSKEYs from all signers (action)",
+
+	MermaidPreCondDesc:  "Verify that the parent DS RRset only has DSes for the new algorithm",
+	MermaidActionDesc:   "Remove all CDS/CDNSKEYs",
+	MermaidPostCondDesc: "Verify that all CDS/CDNSKEYs are removed",
+
+	PreCondition:  AlgRolloverParentDsSyncedPreCondition,
+	Action:        JoinParentDsSyncedAction,
+	PostCondition: VerifyCdsRemoved,
+	Propagation:   true,
+}
+
+// AlgRolloverParentDsSyncedPreCondition verifies that the DS RRset in the parent has a DS
+// for every KSK of the new algorithm and no DS for any other key.
+func AlgRolloverParentDsSyncedPreCondition(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("AlgRolloverParentDsSyncedPreCondition: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+
+	newalg, _, ok := rolloverAlgorithms(z)
+	if !ok {
+		return false
+	}
+	ksks, ok := rolloverKSKs(z, newalg)
+	if !ok {
+		return false
+	}
+	missing := map[uint16]bool{}
+	for _, k := range ksks {
+		missing[k.KeyTag()] = true
+	}
+
+	parentAddress, err := z.GetParentAddressOrStop()
+	if err != nil {
+		return false // stop-reason set in GetParentAddressOrStop()
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(z.Name, dns.TypeDS)
+	c := new(dns.Client)
+	r, _, err := music.DnsExchange(c, m, parentAddress)
+	explainQuery(z, "parent", dns.TypeDS, r, err, true)
+	if err != nil {
+		z.SetStopReason(fmt.Sprintf("Unable to fetch DSes from parent: %s", err))
+		return false
+	}
+
+	parent_up_to_date := true
+	for _, a := range r.Answer {
+		ds, ok := a.(*dns.DS)
+		if !ok {
+			continue
+		}
+		if ds.Algorithm != newalg {
+			z.Explain("DS in parent for the new algorithm", "parent", dns.TypeDS, false, []dns.RR{ds},
+				"DS for the old algorithm")
+			z.SetStopReason(fmt.Sprintf("Parent still has DS for %s key %d",
+				dns.AlgorithmToString[ds.Algorithm], ds.KeyTag))
+			parent_up_to_date = false
+			continue
+		}
+		delete(missing, ds.KeyTag)
+	}
+	for keytag := range missing {
+		z.SetStopReason(fmt.Sprintf("Missing DS for %s KSK %d", dns.AlgorithmToString[newalg], keytag))
+		parent_up_to_date = false
+	}
+
+	z.Explain("parent DS RRset up to date", "parent", dns.TypeDS, parent_up_to_date, nil, "")
+	if !parent_up_to_date {
+		if pp := z.ParentProfile(); pp != nil && pp.CdsProbed && !pp.ScansCds {
+			z.SetStopReason(fmt.Sprintf("Parent %s does not scan for CDS, DS must be updated manually",
+				pp.Parent))
+		}
+		return false // stop-reason defined above
+	}
+
+	log.Printf("%s: DS records in parent are for %s only", z.Name, dns.AlgorithmToString[newalg])
+	z.EmitMetric("ds_seen_at_parent_seconds", z.StateSeconds(), nil)
+	return true
+}
@@ -0,0 +1,169 @@
+package fsm
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/miekg/dns"
+)
+
+var FsmAlgRolloverRemoveOld = music.FSMTransition{
+	Description: "Wait for the old DS RRset to expire from caches (criteria), then remove the DNSKEYs of the old algorithm from all signers (action)",
+
+	MermaidPreCondDesc:  "Wait for the old DS RRset to expire",
+	MermaidActionDesc:   "Remove old algorithm DNSKEYs from all signers",
+	MermaidPostCondDesc: "Verify that no signer publishes old algorithm DNSKEYs",
+
+	PreCondition:  AlgRolloverWaitDsPreCondition,
+	Action:        AlgRolloverRemoveOldDnskeys,
+	PostCondition: AlgRolloverVerifyOldRemoved,
+}
+
+// AlgRolloverWaitDsPreCondition waits for the DS RRset with DSes for the old algorithm to
+// expire from caches. The wait is given by the DS hold down of the policy, or twice the
+// DS TTL in the parent.
+func AlgRolloverWaitDsPreCondition(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("AlgRolloverWaitDsPreCondition: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+
+	if until, ok := z.Waiting("wait-ds"); ok {
+		z.Explain("wait-ds ended", "", 0, !time.Now().Before(until), nil,
+			"waiting until "+until.Format(time.RFC3339))
+		if time.Now().Before(until) {
+			z.SetStopReason(fmt.Sprintf("Waiting until %s (%s)", until.String(),
+				time.Until(until).String()))
+			return false
+		}
+		log.Printf("%s: Waited enough for DS, pre-condition fullfilled", z.Name)
+		z.EndWait("wait-ds")
+		return true
+	}
+
+	parentAddress, err := z.GetParentAddressOrStop()
+	if err != nil {
+		return false // stop-reason defined in GetParentAddressOrStop()
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(z.Name, dns.TypeDS)
+	c := new(dns.Client)
+	r, _, err := music.DnsExchange(c, m, parentAddress)
+	explainQuery(z, "parent", dns.TypeDS, r, err, true)
+	if err != nil {
+		z.SetStopReason(fmt.Sprintf("Unable to fetch DSes from parent: %s", err))
+		return false
+	}
+
+	var ttl uint32
+	for _, a := range r.Answer {
+		if ds, ok := a.(*dns.DS); ok && ds.Header().Ttl > ttl {
+			ttl = ds.Header().Ttl
+		}
+	}
+
+	until := time.Now().Add(music.HoldDown(z.Policy().DsHoldDown, ttl))
+	z.WaitUntil("wait-ds", until)
+	z.SetStopReason(fmt.Sprintf("Largest DS TTL found was %d, waiting until %s (%s)", ttl,
+		until.String(), time.Until(until).String()))
+	return false
+}
+
+// AlgRolloverRemoveOldDnskeys removes the DNSKEYs of the old algorithm from the DNSKEY
+// RRsets of all the signers in the signergroup.
+func AlgRolloverRemoveOldDnskeys(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("AlgRolloverRemoveOldDnskeys: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+
+	newalg, oldalg, ok := rolloverAlgorithms(z)
+	if !ok {
+		return false
+	}
+
+	var oldkeys []dns.RR
+	seen := map[string]bool{}
+	for _, s := range z.SGroup.SignerMap {
+		updater := music.GetUpdater(s.Method)
+		err, rrs := updater.FetchRRset(s, z.Name, z.Name, dns.TypeDNSKEY)
+		if err != nil {
+			z.SetStopReason(fmt.Sprintf("Unable to fetch DNSKEYs from %s: %v", s.Name, err))
+			return false
+		}
+		for _, a := range rrs {
+			dnskey, ok := a.(*dns.DNSKEY)
+			if !ok || !isOldAlgorithm(dnskey.Algorithm, newalg, oldalg) || seen[dnskey.PublicKey] {
+				continue
+			}
+			seen[dnskey.PublicKey] = true
+			oldkeys = append(oldkeys, dnskey)
+		}
+	}
+	if len(oldkeys) == 0 {
+		return true
+	}
+
+	if !updatesSupported(z, groupSigners(z), dns.TypeDNSKEY) {
+		return false
+	}
+	for _, s := range z.SGroup.SignerMap {
+		if _, _, err := music.ApplyRRsetChanges(s, z.Name, []music.RRsetChange{
+			{Owner: z.Name, RRtype: dns.TypeDNSKEY, Absent: oldkeys},
+		}); err != nil {
+			z.SetStopReason(fmt.Sprintf("Unable to remove %s DNSKEYs from %s: %s",
+				oldAlgorithmName(oldalg), s.Name, err))
+			return false
+		}
+		log.Printf("%s: Removed %s DNSKEYs from %s successfully", z.Name, oldAlgorithmName(oldalg), s.Name)
+	}
+
+	// the keys are gone, and so is their origin
+	const sqlq = "DELETE FROM zone_dnskeys WHERE zone = ? AND dnskey = ?"
+	for _, rr := range oldkeys {
+		dnskey := rr.(*dns.DNSKEY)
+		if _, err := z.MusicDB.Exec(sqlq, z.Name, fmt.Sprintf("%d-%d-%s", dnskey.Protocol,
+			dnskey.Algorithm, dnskey.PublicKey)); err != nil {
+			log.Printf("AlgRolloverRemoveOldDnskeys: %s: Statement execute failed: %s", z.Name, err)
+		}
+	}
+	return true
+}
+
+// AlgRolloverVerifyOldRemoved confirms that no signer in the signergroup publishes DNSKEYs
+// of the old algorithm. A signer that keeps publishing its own old keys must have them
+// retired in its own configuration.
+func AlgRolloverVerifyOldRemoved(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("AlgRolloverVerifyOldRemoved: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+
+	newalg, oldalg, ok := rolloverAlgorithms(z)
+	if !ok {
+		return false
+	}
+
+	for _, s := range z.SGroup.SignerMap {
+		updater := music.GetUpdater(s.Method)
+		err, rrs := updater.FetchRRset(s, z.Name, z.Name, dns.TypeDNSKEY)
+		if err != nil {
+			z.Explain("fetch RRset", s.Name, dns.TypeDNSKEY, false, nil, err.Error())
+			z.SetStopReason(fmt.Sprintf("Unable to fetch DNSKEYs from %s: %v", s.Name, err))
+			return false
+		}
+		z.Explain("fetch RRset", s.Name, dns.TypeDNSKEY, true, rrs, "")
+		for _, a := range rrs {
+			if dnskey, ok := a.(*dns.DNSKEY); ok && isOldAlgorithm(dnskey.Algorithm, newalg, oldalg) {
+				z.SetStopReason(fmt.Sprintf("Signer %s still publishes %s DNSKEY %d", s.Name,
+					dns.AlgorithmToString[dnskey.Algorithm], dnskey.KeyTag()))
+				return false
+			}
+		}
+	}
+	log.Printf("%s: No signer publishes %s DNSKEYs", z.Name, oldAlgorithmName(oldalg))
+	return true
+}
@@ -0,0 +1,218 @@
+package fsm
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/miekg/dns"
+)
+
+// ALGORITHM-ROLLOVER (RFC 8901 and RFC 6781 section 4.1.4): every signer introduces DNSKEYs
+// and RRSIGs of the new algorithm on its own. MuSiC then syncs the new DNSKEYs between
+// the signers, replaces the DS RRset in the parent with DSes for the new KSKs (via
+// CDS/CDNSKEY) and, once the old DSes have expired from caches, removes the DNSKEYs of
+// the old algorithm from all signers. The signers stop signing with the old algorithm
+// after that, which is outside of the process.
+
+var FsmAlgRolloverSyncDnskeys = music.FSMTransition{
+	Description: "Once all signers publish and sign with DNSKEYs of the new algorithm (criteria), sync the new DNSKEYs between all signers (action)",
+
+	MermaidPreCondDesc:  "Verify that all signers sign with the new algorithm",
+	MermaidActionDesc:   "Update all signer DNSKEY RRsets with the new algorithm DNSKEYs",
+	MermaidPostCondDesc: "Verify that all DNSKEYs are published in signer DNSKEY RRsets",
+
+	PreCondition:  AlgRolloverNewKeysPreCondition,
+	Action:        AlgRolloverSyncDnskeys,
+	PostCondition: VerifyDnskeysSynched,
+}
+
+// rolloverAlgorithms returns the algorithm to roll to and the algorithm to roll from (0 =
+// all other algorithms), as given by the process parameters.
+func rolloverAlgorithms(z *music.Zone) (uint8, uint8, bool) {
+	newalg, ok := z.ProcessParamInt("new-algorithm")
+	if !ok {
+		z.SetStopReason("Process parameter new-algorithm is not set")
+		return 0, 0, false
+	}
+	oldalg, _ := z.ProcessParamInt("old-algorithm")
+	if _, known := dns.AlgorithmToString[uint8(newalg)]; !known || newalg < 1 || newalg > 255 {
+		z.SetStopReason(fmt.Sprintf("Unknown new DNSKEY algorithm %d", newalg))
+		return 0, 0, false
+	}
+	if oldalg == newalg || oldalg < 0 || oldalg > 255 {
+		z.SetStopReason(fmt.Sprintf("Old DNSKEY algorithm %d is not valid", oldalg))
+		return 0, 0, false
+	}
+	if policy := z.Policy(); !policy.AlgorithmAllowed(uint8(newalg)) {
+		z.SetStopReason(fmt.Sprintf("Algorithm %s is not allowed by policy %s",
+			dns.AlgorithmToString[uint8(newalg)], policy.Name))
+		return 0, 0, false
+	}
+	return uint8(newalg), uint8(oldalg), true
+}
+
+// isOldAlgorithm returns true if keys with algorithm alg are to be retired.
+func isOldAlgorithm(alg, newalg, oldalg uint8) bool {
+	return alg != newalg && (oldalg == 0 || alg == oldalg)
+}
+
+// signedWith returns true if the rrtype RRset of the zone at the signer is signed with alg.
+func signedWith(z *music.Zone, s *music.Signer, rrtype uint16, alg uint8) (bool, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(z.Name, rrtype)
+	m.SetEdns0(4096, true)
+	c := s.NewDnsClient()
+	r, _, err := s.Exchange(c, m)
+	if err == nil && r.Truncated {
+		c.Net = "tcp"
+		r, _, err = s.Exchange(c, m)
+	}
+	explainQuery(z, s.Name, rrtype, r, err, false)
+	if err != nil {
+		return false, err
+	}
+	for _, rr := range r.Answer {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == rrtype && sig.Algorithm == alg {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AlgRolloverNewKeysPreCondition confirms that every signer in the signergroup publishes a
+// KSK of the new algorithm and signs the zone with it.
+func AlgRolloverNewKeysPreCondition(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("AlgRolloverNewKeysPreCondition: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+
+	newalg, _, ok := rolloverAlgorithms(z)
+	if !ok {
+		return false
+	}
+	algname := dns.AlgorithmToString[newalg]
+
+	for _, s := range z.SGroup.SignerMap {
+		updater := music.GetUpdater(s.Method)
+		err, rrs := updater.FetchRRset(s, z.Name, z.Name, dns.TypeDNSKEY)
+		if err != nil {
+			z.Explain("fetch RRset", s.Name, dns.TypeDNSKEY, false, nil, err.Error())
+			z.SetStopReason(fmt.Sprintf("Unable to fetch DNSKEYs from %s: %v", s.Name, err))
+			return false
+		}
+		z.Explain("fetch RRset", s.Name, dns.TypeDNSKEY, true, rrs, "")
+
+		ksk := false
+		for _, a := range rrs {
+			if dnskey, ok := a.(*dns.DNSKEY); ok && dnskey.Algorithm == newalg &&
+				dnskey.Flags&0x101 == 257 {
+				ksk = true
+				break
+			}
+		}
+		z.Explain(algname+" KSK published", s.Name, dns.TypeDNSKEY, ksk, nil, "")
+		if !ksk {
+			z.SetStopReason(fmt.Sprintf("Signer %s publishes no %s KSK", s.Name, algname))
+			return false
+		}
+
+		for _, rrtype := range []uint16{dns.TypeDNSKEY, dns.TypeSOA} {
+			signed, err := signedWith(z, s, rrtype, newalg)
+			if err != nil {
+				z.SetStopReason(fmt.Sprintf("Unable to fetch %s RRSIGs from %s: %v",
+					dns.TypeToString[rrtype], s.Name, err))
+				return false
+			}
+			z.Explain(dns.TypeToString[rrtype]+" signed with "+algname, s.Name, rrtype, signed, nil, "")
+			if !signed {
+				z.SetStopReason(fmt.Sprintf("Signer %s does not sign the %s RRset with %s",
+					s.Name, dns.TypeToString[rrtype], algname))
+				return false
+			}
+		}
+	}
+	log.Printf("%s: All signers sign with %s", z.Name, algname)
+	return true
+}
+
+// AlgRolloverSyncDnskeys adds the DNSKEYs of the new algorithm of every signer to the
+// DNSKEY RRsets of all the other signers in the signergroup.
+func AlgRolloverSyncDnskeys(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("AlgRolloverSyncDnskeys: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+
+	newalg, oldalg, ok := rolloverAlgorithms(z)
+	if !ok {
+		return false
+	}
+
+	const sqlq = "INSERT OR IGNORE INTO zone_dnskeys (zone, dnskey, signer) VALUES (?, ?, ?)"
+
+	var allkeys []*dns.DNSKEY
+	var allrrs, newkeys []dns.RR
+	seen := map[string]bool{}
+	for _, s := range z.SGroup.SignerMap {
+		updater := music.GetUpdater(s.Method)
+		err, rrs := updater.FetchRRset(s, z.Name, z.Name, dns.TypeDNSKEY)
+		if err != nil {
+			z.SetStopReason(err.Error())
+			return false
+		}
+		for _, a := range rrs {
+			dnskey, ok := a.(*dns.DNSKEY)
+			if !ok || seen[dnskey.PublicKey] {
+				continue
+			}
+			seen[dnskey.PublicKey] = true
+			allkeys = append(allkeys, dnskey)
+			allrrs = append(allrrs, dnskey)
+			if dnskey.Algorithm != newalg {
+				continue
+			}
+			newkeys = append(newkeys, dnskey)
+
+			// the origin of the key, as recorded by JoinSyncDnskeys
+			if f := dnskey.Flags & 0x101; f == 256 || f == 257 {
+				_, err := z.MusicDB.Exec(sqlq, z.Name, fmt.Sprintf("%d-%d-%s",
+					dnskey.Protocol, dnskey.Algorithm, dnskey.PublicKey), s.Name)
+				if err != nil {
+					log.Printf("AlgRolloverSyncDnskeys: %s: Statement execute failed: %s", z.Name, err)
+					return false
+				}
+			}
+		}
+	}
+
+	// during the rollover the DNSKEY RRset holds the keys of both algorithms
+	if err := music.CheckResponseSize(z.Name, dns.TypeDNSKEY, allrrs, allkeys); err != nil {
+		z.SetStopReason(err.Error())
+		return false
+	}
+
+	if !updatesSupported(z, groupSigners(z), dns.TypeDNSKEY) {
+		return false
+	}
+	for _, s := range z.SGroup.SignerMap {
+		if _, _, err := music.ApplyRRsetChanges(s, z.Name, []music.RRsetChange{
+			{Owner: z.Name, RRtype: dns.TypeDNSKEY, Present: newkeys},
+		}); err != nil {
+			z.SetStopReason(fmt.Sprintf("Unable to update %s with %s DNSKEYs: %s", s.Name,
+				dns.AlgorithmToString[newalg], err))
+			return false
+		}
+	}
+	log.Printf("%s: %d %s DNSKEYs synced between all signers (retiring %s)", z.Name, len(newkeys),
+		dns.AlgorithmToString[newalg], oldAlgorithmName(oldalg))
+	return true
+}
+
+func oldAlgorithmName(oldalg uint8) string {
+	if oldalg == 0 {
+		return "all other algorithms"
+	}
+	return dns.AlgorithmToString[oldalg]
+}
@@ -15,10 +15,13 @@ const (
 	FsmStateCsyncAdded     = "csync-added"
 	FsmStateParentNsSynced = "parent-ns-synced"
 	FsmStateNsesSynced     = "nses-synced"
+	FsmStateNsTTLNormal    = "ns-ttl-normalized"
 	//	FsmStateStop             = "stop"		// XXX: This state is defined in music package
 
 	FsmStateSignersUnknown = "signers-unknown" // Only used in the VERIFY-ZONE-SYNC proc
 
+	FsmStateAlgUnsynced   = "algorithm-unsynced" // Only used in the ALGORITHM-ROLLOVER proc
+	FsmStateOldAlgRemoved = "old-algorithm-removed"
 )
 
 var FsmGenericStop = music.FsmTransitionStopFactory(music.FsmStateStop)
@@ -64,6 +67,16 @@ ADD-SIGNER is the process that all zones attached to a signer group
 must execute when a new signer is added to the group. It contains
 steps for synching DNSKEYs among signers as well as updating the
 DS and NS RRsets in the parent.`,
+		Params: map[string]music.FSMParam{
+			"cds-ttl": music.FSMParam{Type: "int",
+				Desc: "TTL of the CDS/CDNSKEY RRsets (overrides the zone policy)"},
+			"joining-signer": music.FSMParam{Type: "string",
+				Desc: "name of the signer joining the group (overrides the signer recorded when the process started)"},
+			"skip-csync": music.FSMParam{Type: "bool", Default: "false",
+				Desc: "do not publish CSYNC, the parent NS RRset is updated by other means"},
+			"ns-ttl": music.FSMParam{Type: "int",
+				Desc: "TTL to normalize the NS RRset to before CSYNC, 0 = as is (overrides the zone policy)"},
+		},
 		States: map[string]music.FSMState{
 			FsmStateSignerUnsynced: music.FSMState{
 				Next: map[string]music.FSMTransition{
@@ -86,6 +99,11 @@ DS and NS RRsets in the parent.`,
 				},
 			},
 			FsmStateNsesSynced: music.FSMState{
+				Next: map[string]music.FSMTransition{
+					FsmStateNsTTLNormal: FsmNormalizeNsTTL,
+				},
+			},
+			FsmStateNsTTLNormal: music.FSMState{
 				Next: map[string]music.FSMTransition{
 					FsmStateCsyncAdded: FsmJoinAddCsync,
 				},
@@ -123,11 +141,18 @@ updating the DS and NS RRsets in the parent.
 
 Note that it is not possible to remove the last signer in a group,
 as that would cause the attached zones to have to go unsigned.`,
+		Params: map[string]music.FSMParam{
+			"ns-ttl": music.FSMParam{Type: "int",
+				Desc: "TTL to normalize the NS RRset to before CSYNC, 0 = as is (overrides the zone policy)"},
+		},
 		States: map[string]music.FSMState{
 			FsmStateSignerUnsynced: music.FSMState{
 				Next: map[string]music.FSMTransition{FsmStateNsesSynced: FsmLeaveSyncNses},
 			},
 			FsmStateNsesSynced: music.FSMState{
+				Next: map[string]music.FSMTransition{FsmStateNsTTLNormal: FsmNormalizeNsTTL},
+			},
+			FsmStateNsTTLNormal: music.FSMState{
 				Next: map[string]music.FSMTransition{FsmStateCsyncAdded: FsmLeaveAddCsync},
 			},
 			FsmStateCsyncAdded: music.FSMState{
@@ -151,6 +176,50 @@ as that would cause the attached zones to have to go unsigned.`,
 		},
 	},
 
+	// PROCESS: ALGORITHM-ROLLOVER: This is a real process, from RFC 8901.
+	// defined in fsm/algrollover*.go
+
+	"algorithm-rollover": music.FSM{
+		Name:         "algorithm-rollover",
+		Type:         "single-run",
+		InitialState: FsmStateAlgUnsynced,
+		Desc: `
+ALGORITHM-ROLLOVER is the process that a zone executes to change
+the DNSSEC algorithm used by all the signers in its signer group.
+Each signer must first publish and sign with keys of the new
+algorithm. The process then syncs the new DNSKEYs among the signers,
+replaces the DS RRset in the parent with DSes for the new KSKs and
+finally removes the DNSKEYs of the old algorithm.`,
+		Params: map[string]music.FSMParam{
+			"new-algorithm": music.FSMParam{Type: "int",
+				Desc: "number of the DNSKEY algorithm to roll to (required)"},
+			"old-algorithm": music.FSMParam{Type: "int", Default: "0",
+				Desc: "number of the DNSKEY algorithm to retire, 0 = all other algorithms"},
+			"cds-ttl": music.FSMParam{Type: "int",
+				Desc: "TTL of the CDS/CDNSKEY RRsets (overrides the zone policy)"},
+		},
+		States: map[string]music.FSMState{
+			FsmStateAlgUnsynced: music.FSMState{
+				Next: map[string]music.FSMTransition{FsmStateDnskeysSynced: FsmAlgRolloverSyncDnskeys},
+			},
+			FsmStateDnskeysSynced: music.FSMState{
+				Next: map[string]music.FSMTransition{FsmStateCDSAdded: FsmAlgRolloverAddCDS},
+			},
+			FsmStateCDSAdded: music.FSMState{
+				Next: map[string]music.FSMTransition{FsmStateParentDsSynced: FsmAlgRolloverParentDsSynced},
+			},
+			FsmStateParentDsSynced: music.FSMState{
+				Next: map[string]music.FSMTransition{FsmStateOldAlgRemoved: FsmAlgRolloverRemoveOld},
+			},
+			FsmStateOldAlgRemoved: music.FSMState{
+				Next: map[string]music.FSMTransition{music.FsmStateStop: music.FsmTransitionStopFactory(FsmStateOldAlgRemoved)},
+			},
+			music.FsmStateStop: music.FSMState{
+				Next: map[string]music.FSMTransition{music.FsmStateStop: FsmGenericStop},
+			},
+		},
+	},
+
 	// PROCESS: ZSK-ROLLOVER: This is a real process
 	"zsk-rollover": music.FSM{
 		Name:         "zsk-rollover",
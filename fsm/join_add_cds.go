@@ -22,11 +22,15 @@ var FsmJoinAddCDS = music.FSMTransition{
 
 // JoinAddCdsPreCondition collects DNSKEYS from all signers and verifies that the RRsets match.
 func JoinAddCdsPreCondition(zone *music.Zone) bool {
-	if zone.ZoneType == "debug" {
-		log.Printf("JoinAddCdsPreCondition: zone %s (DEBUG) is automatically ok", zone.Name)
+	if zone.Type().Simulated {
+		log.Printf("JoinAddCdsPreCondition: zone %s (simulated) is automatically ok", zone.Name)
 		return true
 	}
 
+	if !joiningSignerOk(zone, "JoinAddCdsPreCondition") {
+		return false
+	}
+
 	if music.SignerRRsetEqual(zone, dns.TypeDNSKEY) {
 		log.Printf("[JoinAddCdsPreCondition] All DNSKEYS synced.")
 		return true
@@ -41,8 +45,12 @@ func JoinAddCdsAction(zone *music.Zone) bool {
 	log.Printf("[JoinAddCDSAction] zone struct: \n %v \n", zone)
 	log.Printf("%s: Creating CDS/CDNSKEY record sets", zone.Name)
 
-	if zone.ZoneType == "debug" {
-		log.Printf("JoinAddCdsAction: zone %s (DEBUG) is automatically ok", zone.Name)
+	if zone.Type().Simulated {
+		log.Printf("JoinAddCdsAction: zone %s (simulated) is automatically ok", zone.Name)
+		return true
+	}
+	if !zone.Type().ParentSignals {
+		log.Printf("JoinAddCdsAction: zone %s (%s) does not signal the parent, no CDS/CDNSKEY", zone.Name, zone.ZoneType)
 		return true
 	}
 
@@ -69,18 +77,52 @@ func JoinAddCdsAction(zone *music.Zone) bool {
 		}
 	}
 
+	policy := zone.Policy()
+	cdsttl := policy.CdsTTL
+	if ttl, ok := zone.ProcessParamInt("cds-ttl"); ok {
+		cdsttl = uint32(ttl)
+	}
 	var cdses, cdnskeys []dns.RR
 	for _, dnskey := range dnskeyMap {
-		cdses = append(cdses, dnskey.ToDS(dns.SHA256).ToCDS())
-		cdses = append(cdses, dnskey.ToDS(dns.SHA384).ToCDS())
-		cdnskeys = append(cdnskeys, dnskey.ToCDNSKEY())
+		if !policy.AlgorithmAllowed(dnskey.Algorithm) {
+			zone.SetStopReason(fmt.Sprintf("KSK %d uses algorithm %s, which is not allowed by policy %s",
+				dnskey.KeyTag(), dns.AlgorithmToString[dnskey.Algorithm], policy.Name))
+			return false
+		}
+		for _, dt := range policy.CdsDigests(dns.SHA256, dns.SHA384) {
+			cds := dnskey.ToDS(dt).ToCDS()
+			if cdsttl != 0 {
+				cds.Hdr.Ttl = cdsttl
+			}
+			cdses = append(cdses, cds)
+		}
+		cdnskey := dnskey.ToCDNSKEY()
+		if cdsttl != 0 {
+			cdnskey.Hdr.Ttl = cdsttl
+		}
+		cdnskeys = append(cdnskeys, cdnskey)
+	}
+
+	var ksks []*dns.DNSKEY
+	for _, dnskey := range dnskeyMap {
+		ksks = append(ksks, dnskey)
+	}
+	for rrtype, rrset := range map[uint16][]dns.RR{dns.TypeCDS: cdses, dns.TypeCDNSKEY: cdnskeys} {
+		if err := music.CheckResponseSize(zone.Name, rrtype, rrset, ksks); err != nil {
+			zone.SetStopReason(err.Error())
+			return false
+		}
 	}
 
 	// Publish CDS/CDNSKEY RRsets
+	if !updatesSupported(zone, groupSigners(zone), dns.TypeCDS, dns.TypeCDNSKEY) {
+		return false
+	}
 	for _, signer := range zone.SGroup.SignerMap {
-		updater := music.GetUpdater(signer.Method)
-		if err := updater.Update(signer, zone.Name, zone.Name,
-			&[][]dns.RR{cdses, cdnskeys}, nil); err != nil {
+		if _, _, err := music.ApplyRRsetChanges(signer, zone.Name, []music.RRsetChange{
+			{Owner: zone.Name, RRtype: dns.TypeCDS, Present: cdses},
+			{Owner: zone.Name, RRtype: dns.TypeCDNSKEY, Present: cdnskeys},
+		}); err != nil {
 			err, _ := zone.SetStopReason(fmt.Sprintf("Unable to update %s with CDS/CDNSKEY record sets: %s",
 				signer.Name, err))
 			if err != nil {
@@ -99,8 +141,12 @@ func JoinAddCdsAction(zone *music.Zone) bool {
 func VerifyCdsPublished(zone *music.Zone) bool {
 	log.Printf("Verifying Publication of CDS/CDNSKEY record sets for %s", zone.Name)
 
-	if zone.ZoneType == "debug" {
-		log.Printf("VerifyCdsPublished: zone %s (DEBUG) is automatically ok", zone.Name)
+	if zone.Type().Simulated {
+		log.Printf("VerifyCdsPublished: zone %s (simulated) is automatically ok", zone.Name)
+		return true
+	}
+	if !zone.Type().ParentSignals {
+		log.Printf("VerifyCdsPublished: zone %s (%s) does not signal the parent, no CDS/CDNSKEY", zone.Name, zone.ZoneType)
 		return true
 	}
 
@@ -110,6 +156,8 @@ func VerifyCdsPublished(zone *music.Zone) bool {
 	cdnskeyFromKSK := map[uint16]*dns.CDNSKEY{}
 	cdnskeyFromSigners := map[uint16]*dns.CDNSKEY{}
 
+	digest := zone.Policy().CdsDigests(dns.SHA256, dns.SHA384)[0]
+
 	// Fetch DNSKEYS
 	for _, signer := range zone.SGroup.SignerMap {
 		updater := music.GetUpdater(signer.Method)
@@ -127,7 +175,7 @@ func VerifyCdsPublished(zone *music.Zone) bool {
 			}
 
 			if f := dnskey.Flags & 0x101; f == 257 {
-				cdsFromKSK[dnskey.KeyTag()] = dnskey.ToDS(dns.SHA256).ToCDS()
+				cdsFromKSK[dnskey.KeyTag()] = dnskey.ToDS(digest).ToCDS()
 				cdnskeyFromKSK[dnskey.KeyTag()] = dnskey.ToCDNSKEY()
 			}
 		}
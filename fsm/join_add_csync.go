@@ -26,17 +26,24 @@ func JoinAddCsyncPreCondition(z *music.Zone) bool {
 
 	log.Printf("%s: Verifying that NSes are in sync in group %s", z.Name, z.SGroup.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("JoinAddCsyncPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("JoinAddCsyncPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
+	if !joiningSignerOk(z, "JoinAddCsyncPreCondition") {
+		return false
+	}
+
 	for _, s := range z.SGroup.SignerMap {
 		updater := music.GetUpdater(s.Method)
 		err, rrs := updater.FetchRRset(s, z.Name, z.Name, dns.TypeNS)
 		if err != nil {
 			log.Printf("Error from updater.FetchRRset: %v\n", err)
 			// XXX: johani: is it meaningful to continue here? why not just return false?
+			z.Explain("fetch RRset", s.Name, dns.TypeNS, false, nil, err.Error())
+		} else {
+			z.Explain("fetch RRset", s.Name, dns.TypeNS, true, rrs, "")
 		}
 
 		nses[s.Name] = []*dns.NS{}
@@ -77,6 +84,7 @@ func JoinAddCsyncPreCondition(z *music.Zone) bool {
 				z.SetStopReason(fmt.Sprintf("NS %s is missing in signer %s", ns.Ns, signer))
 				group_nses_synced = false
 			}
+			z.Explain("NS "+ns.Ns+" published", signer, dns.TypeNS, found, nil, "")
 		}
 	}
 
@@ -91,40 +99,31 @@ func JoinAddCsyncPreCondition(z *music.Zone) bool {
 // JoinAddCsyncAction creates CSYNC RR and adds it to the signers in the signergroup.
 func JoinAddCsyncAction(z *music.Zone) bool {
 	log.Printf("JoinAddCSYNC: Using FetchRRset interface:\n")
-	if z.ZoneType == "debug" {
-		log.Printf("JoinAddCsyncAction: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("JoinAddCsyncAction: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+	if z.SkipCsync() {
+		log.Printf("JoinAddCsyncAction: zone %s: not publishing CSYNC, parent NS is updated by other means", z.Name)
 		return true
 	}
 
-	ttl := 300
+	ttl := z.Policy().CsyncTTL
 	z.CSYNC = new(dns.CSYNC)
 	z.CSYNC.Hdr = dns.RR_Header{Name: z.Name, Rrtype: dns.TypeCSYNC, Class: dns.ClassINET, Ttl: uint32(ttl), Rdlength: uint16(12)}
 	z.CSYNC.Serial = 1
 	z.CSYNC.Flags = 1
 	z.CSYNC.TypeBitMap = []uint16{dns.TypeA, dns.TypeNS, dns.TypeAAAA}
 
+	if !updatesSupported(z, groupSigners(z), dns.TypeCSYNC) {
+		return false
+	}
 	for _, signer := range z.SGroup.SignerMap {
-		// check if there is any CSYNC records if there are remove them before adding a csync record
-		updater := music.GetUpdater(signer.Method)
-		err, csyncrrs := updater.FetchRRset(signer, z.Name, z.Name, dns.TypeCSYNC)
-		if err != nil {
-			err, _ = z.SetStopReason(fmt.Sprintf("Unable to fetch CSYNC RRset from %s: %v", signer.Name, err))
-			return false
-		}
-		if len(csyncrrs) != 0 {
-			if err := updater.RemoveRRset(signer, z.Name, z.Name,
-				[][]dns.RR{[]dns.RR{z.CSYNC}}); err != nil {
-				z.SetStopReason(fmt.Sprintf("Unable to remove CSYNC record sets from %s: %s",
-					signer.Name, err))
-				return false
-			}
-			log.Printf("%s: Removed CSYNC record sets from %s successfully", z.Name, signer.Name)
-		}
-
+		// any other CSYNC records are removed
 		log.Printf("%s: Creating CSYNC record sets", z.Name)
-
-		if err := updater.Update(signer, z.Name, z.Name,
-			&[][]dns.RR{[]dns.RR{z.CSYNC}}, nil); err != nil {
+		if _, _, err := music.ApplyRRsetChanges(signer, z.Name, []music.RRsetChange{
+			{Owner: z.Name, RRtype: dns.TypeCSYNC, Present: []dns.RR{z.CSYNC}, Exact: true},
+		}); err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to update %s with CSYNC record sets: %s",
 				signer.Name, err))
 			return false
@@ -138,8 +137,11 @@ func JoinAddCsyncAction(z *music.Zone) bool {
 func VerifyCsyncPublished(z *music.Zone) bool {
 	log.Printf("Verifying Publication of CSYNC record sets for %s", z.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("VerifyCsyncPublished: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("VerifyCsyncPublished: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+	if z.SkipCsync() {
 		return true
 	}
 
@@ -9,12 +9,6 @@ import (
 	"github.com/miekg/dns"
 )
 
-var zoneWaitDs map[string]time.Time // Issue #34: using local store for now
-
-func init() {
-	zoneWaitDs = make(map[string]time.Time)
-}
-
 var FsmJoinNsSynced = music.FSMTransition{
 	Description: "Wait enough time for parent DS records to propagate (criteria), then sync NS records between all signers (action)",
 
@@ -25,23 +19,36 @@ var FsmJoinNsSynced = music.FSMTransition{
 	PreCondition:  JoinWaitDsPreCondition,
 	Action:        JoinSyncNs,
 	PostCondition: JoinSyncNSPostCondition, // XXX TODO: This is also the precondition for the next state. Consolidate
+	Propagation:   true,
 }
 
 // JoinWaitDsPreCondition calculates a waiting period for DS propagation and then waits.
 func JoinWaitDsPreCondition(z *music.Zone) bool {
-	if z.ZoneType == "debug" {
-		log.Printf("JoinWaitDsPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("JoinWaitDsPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
-	if until, ok := zoneWaitDs[z.Name]; ok {
+	if !joiningSignerOk(z, "JoinWaitDsPreCondition") {
+		return false
+	}
+
+	if until, ok := z.Waiting("wait-ds"); ok {
+		z.Explain("wait-ds ended", "", 0, !time.Now().Before(until), nil,
+			"waiting until "+until.Format(time.RFC3339))
 		if time.Now().Before(until) {
 			z.SetStopReason(fmt.Sprintf("Waiting until %s (%s)", until.String(),
 				time.Until(until).String()))
 			return false
 		}
+		ok, reason := z.AtlasConfirmed(dns.TypeDS)
+		z.Explain("DS propagation confirmed by RIPE Atlas", "parent", dns.TypeDS, ok, nil, reason)
+		if !ok {
+			z.SetStopReason(reason)
+			return false
+		}
 		log.Printf("%s: Waited enough for DS, pre-condition fullfilled", z.Name)
-		delete(zoneWaitDs, z.Name)
+		z.EndWait("wait-ds")
 		return true
 	}
 
@@ -55,6 +62,9 @@ func JoinWaitDsPreCondition(z *music.Zone) bool {
 		err, rrs := updater.FetchRRset(signer, z.Name, z.Name, dns.TypeDNSKEY)
 		if err != nil {
 			log.Printf("JoinWaitDsPreCondition: Error from updater.FetchRRset: %v\n", err)
+			z.Explain("fetch RRset", signer.Name, dns.TypeDNSKEY, false, nil, err.Error())
+		} else {
+			z.Explain("fetch RRset", signer.Name, dns.TypeDNSKEY, true, rrs, "")
 		}
 
 		for _, a := range rrs {
@@ -77,7 +87,8 @@ func JoinWaitDsPreCondition(z *music.Zone) bool {
 	m := new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeDS)
 	c := new(dns.Client)
-	r, _, err := c.Exchange(m, parentAddress)
+	r, _, err := music.DnsExchange(c, m, parentAddress)
+	explainQuery(z, "parent", dns.TypeDS, r, err, true)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch DSes from parent: %s", err))
 		return false
@@ -94,12 +105,9 @@ func JoinWaitDsPreCondition(z *music.Zone) bool {
 		}
 	}
 
-	// until := time.Now().Add((time.Duration(ttl*2) * time.Second))
-	// TODO: static wait time to enable faster testing
-	until := time.Now().Add((time.Duration(5) * time.Second))
+	until := time.Now().Add(music.HoldDown(z.Policy().DsHoldDown, ttl))
 
-	zoneWaitDs[z.Name] = until
-	// XXX: this rather needs a z.SetDelayReasonAndTime(...)
+	z.WaitUntil("wait-ds", until)
 	z.SetStopReason(fmt.Sprintf("Largest TTL found was %d, waiting until %s (%s)", ttl,
 		until.String(), time.Until(until).String()))
 	return false
@@ -109,13 +117,19 @@ func JoinWaitDsPreCondition(z *music.Zone) bool {
 func JoinSyncNs(z *music.Zone) bool {
 	log.Printf("JoinSyncNs: %s: Fetch all NS records from all signers", z.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("JoinSyncNs: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("JoinSyncNs: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
 	nses := make(map[string][]*dns.NS)
 
+	external, err := z.MusicDB.ExternalNSes(nil, z.Name)
+	if err != nil {
+		z.SetStopReason(err.Error())
+		return false
+	}
+
 	for _, signer := range z.SGroup.SignerMap {
 		updater := music.GetUpdater(signer.Method)
 		log.Printf("JoinSyncNs: Using FetchRRset interface:\n")
@@ -137,6 +151,11 @@ func JoinSyncNs(z *music.Zone) bool {
 
 			nses[signer.Name] = append(nses[signer.Name], ns)
 
+			// external NSes are synced, but never attributed to a signer
+			if music.IsExternalNS(external, ns.Ns) {
+				continue
+			}
+
 			// XXX: Should wrap this in a transaction
 			res, err := z.MusicDB.Exec(sqlq, z.Name, ns.Ns, signer.Name)
 			if err != nil {
@@ -182,8 +201,9 @@ func JoinSyncNs(z *music.Zone) bool {
 	// }
 
 	for _, signer := range z.SGroup.SignerMap {
-		updater := music.GetUpdater(signer.Method)
-		if err := updater.Update(signer, z.Name, z.Name, &[][]dns.RR{nsset}, nil); err != nil {
+		if _, _, err := music.ApplyRRsetChanges(signer, z.Name, []music.RRsetChange{
+			{Owner: z.Name, RRtype: dns.TypeNS, Present: nsset},
+		}); err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to update %s with NS record sets: %s", signer.Name, err))
 			return false
 		}
@@ -194,7 +214,7 @@ func JoinSyncNs(z *music.Zone) bool {
 	//      then the delete is wrong.
 	// z.StateTransition(FsmStateParentDsSynced, FsmStateDsPropagated)
 	// The delete has moved to the true-branch of the PreCondition.
-	// delete(zoneWaitDs, z.Name)
+	// z.EndWait("wait-ds")
 	return true
 }
 
@@ -204,8 +224,8 @@ func JoinSyncNSPostCondition(z *music.Zone) bool {
 
 	log.Printf("%s: Verifying that NSes are in sync in group %s", z.Name, z.SGroup.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("JoinAddCsyncPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("JoinAddCsyncPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
@@ -18,6 +18,7 @@ var FsmJoinParentDsSynced = music.FSMTransition{
 	PreCondition:  JoinParentDsSyncedPreCondition,
 	Action:        JoinParentDsSyncedAction,
 	PostCondition: VerifyCdsRemoved,
+	Propagation:   true,
 }
 
 // JoinParentDsSyncedPreCondition compares the DS RRs in the parent zone to the signers CDS RRs.
@@ -26,17 +27,22 @@ func JoinParentDsSyncedPreCondition(z *music.Zone) bool {
 
 	log.Printf("%s: Verifying that DSes in parent are up to date compared to signers CDSes", z.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("JoinParentDsSyncedPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("JoinParentDsSyncedPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
+	if !joiningSignerOk(z, "JoinParentDsSyncedPreCondition") {
+		return false
+	}
+
 	for _, s := range z.SGroup.SignerMap {
 		m := new(dns.Msg)
 		m.SetQuestion(z.Name, dns.TypeCDS)
 
 		c := new(dns.Client)
-		r, _, err := c.Exchange(m, s.Address+":"+s.Port) // TODO: add DnsAddress or solve this in a better way
+		r, _, err := s.Exchange(c, m)
+		explainQuery(z, s.Name, dns.TypeCDS, r, err, false)
 
 		if err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to fetch CDSes from %s: %s",
@@ -65,7 +71,8 @@ func JoinParentDsSyncedPreCondition(z *music.Zone) bool {
 	m := new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeDS)
 	c := new(dns.Client)
-	r, _, err := c.Exchange(m, parentAddress)
+	r, _, err := music.DnsExchange(c, m, parentAddress)
+	explainQuery(z, "parent", dns.TypeDS, r, err, true)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch DSes from parent: %s", err))
 		return false
@@ -98,20 +105,28 @@ func JoinParentDsSyncedPreCondition(z *music.Zone) bool {
 	}
 	for _, cds := range cdsmap {
 		// log.Printf("%s: Missing DS for CDS: %d %d %d %s", z.Name, cds.KeyTag, cds.Algorithm, cds.DigestType, cds.Digest)
+		z.Explain("DS in parent for CDS", "parent", dns.TypeDS, false, []dns.RR{cds}, "no matching DS")
 		z.SetStopReason(fmt.Sprintf("Missing DS for CDS: %d", cds.KeyTag))
 		parent_up_to_date = false
 	}
 	for _, ds := range removedses {
 		// log.Printf("%s: Unknown DS: %d %d %d %s", z.Name, ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)
+		z.Explain("DS in parent matches a CDS", "parent", dns.TypeDS, false, []dns.RR{ds}, "unknown DS")
 		z.SetStopReason(fmt.Sprintf("Unknown DS: %d", ds.KeyTag))
 		parent_up_to_date = false // TODO: should unknown DS be allowed?
 	}
 
+	z.Explain("parent DS RRset up to date", "parent", dns.TypeDS, parent_up_to_date, nil, "")
 	if !parent_up_to_date {
+		if pp := z.ParentProfile(); pp != nil && pp.CdsProbed && !pp.ScansCds {
+			z.SetStopReason(fmt.Sprintf("Parent %s does not scan for CDS, DS must be updated manually",
+				pp.Parent))
+		}
 		return false // stop-reason defined above
 	}
 
 	log.Printf("%s: DS records in parent are up-to-date", z.Name)
+	z.EmitMetric("ds_seen_at_parent_seconds", z.StateSeconds(), nil)
 	return true
 }
 
@@ -119,8 +134,8 @@ func JoinParentDsSyncedPreCondition(z *music.Zone) bool {
 func JoinParentDsSyncedAction(z *music.Zone) bool {
 	log.Printf("%s: Removing CDS/CDNSKEY record sets", z.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("JoinParentDsSyncedAction: zone %s (DEBUG) is automatically ok",
+	if z.Type().Simulated {
+		log.Printf("JoinParentDsSyncedAction: zone %s (simulated) is automatically ok",
 			z.Name)
 		return true
 	}
@@ -147,8 +162,8 @@ func JoinParentDsSyncedAction(z *music.Zone) bool {
 func VerifyCdsRemoved(z *music.Zone) bool {
 	log.Printf("%s: Verify that CDS/CDNSKEY RRsets have been removed", z.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("VerifyCdsRemoved: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("VerifyCdsRemoved: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
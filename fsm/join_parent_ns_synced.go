@@ -18,6 +18,7 @@ var FsmJoinParentNsSynced = music.FSMTransition{
 	PreCondition:  JoinParentNsSyncedPreCondition,
 	Action:        JoinParentNsSyncedAction,
 	PostCondition: JoinParentNsSyncedPostCondition, // XXX TODO: is the same as LeaveParentNsSyncedConfirmCsyncRemoval. Consolidate
+	Propagation:   true,
 }
 
 // JoinParentNsSyncedPreCondition confirms that the NS RRs for the signergroup have been synced to the parent.
@@ -26,16 +27,21 @@ func JoinParentNsSyncedPreCondition(z *music.Zone) bool {
 
 	log.Printf("%s: Verifying that NSes are in sync in the parent", z.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("JoinParentNsSyncedPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("JoinParentNsSyncedPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
+	if !joiningSignerOk(z, "JoinParentNsSyncedPreCondition") {
+		return false
+	}
+
 	for _, s := range z.SGroup.SignerMap {
 		m := new(dns.Msg)
 		m.SetQuestion(z.Name, dns.TypeNS)
 		c := new(dns.Client)
-		r, _, err := c.Exchange(m, s.Address+":"+s.Port)
+		r, _, err := s.Exchange(c, m)
+		explainQuery(z, s.Name, dns.TypeNS, r, err, false)
 		if err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from %s: %s",
 				s.Name, err))
@@ -70,7 +76,8 @@ func JoinParentNsSyncedPreCondition(z *music.Zone) bool {
 	m := new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeNS)
 	c := new(dns.Client)
-	r, _, err := c.Exchange(m, parentAddress)
+	r, _, err := music.DnsExchange(c, m, parentAddress)
+	explainQuery(z, "parent", dns.TypeNS, r, err, true)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from parent: %s", err))
 		return false
@@ -89,12 +96,14 @@ func JoinParentNsSyncedPreCondition(z *music.Zone) bool {
 		missing_ns := []string{}
 		for ns, _ := range nsmap {
 			missing_ns = append(missing_ns, ns)
+			z.Explain("NS "+ns+" in parent", "parent", dns.TypeNS, false, nil, "")
 		}
 		z.SetStopReason(fmt.Sprintf("Missing NS in parent: %v", missing_ns))
 		return false
 	}
 
 	log.Printf("%s: Parent NSes are up-to-date", z.Name)
+	z.EmitMetric("ns_seen_at_parent_seconds", z.StateSeconds(), nil)
 	return true
 }
 
@@ -102,8 +111,8 @@ func JoinParentNsSyncedPreCondition(z *music.Zone) bool {
 func JoinParentNsSyncedAction(z *music.Zone) bool {
 	log.Printf("%s: Removing CSYNC record sets", z.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("JoinParentNsSyncedAction: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("JoinParentNsSyncedAction: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
@@ -126,8 +135,8 @@ func JoinParentNsSyncedAction(z *music.Zone) bool {
 
 // JoinParentNsSyncedPostCondition confirms that the CSYNC records have been removed from the signers in the signergroup.
 func JoinParentNsSyncedPostCondition(zone *music.Zone) bool {
-	if zone.ZoneType == "debug" {
-		log.Printf("JoinParentNsSyncedPostCondition: zone %s (DEBUG) is automatically ok", zone.Name)
+	if zone.Type().Simulated {
+		log.Printf("JoinParentNsSyncedPostCondition: zone %s (simulated) is automatically ok", zone.Name)
 		return true
 	}
 
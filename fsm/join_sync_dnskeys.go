@@ -29,17 +29,75 @@ var FsmJoinSyncDnskeys = music.FSMTransition{
 //      extremely similar to the JoinAddCdsPreCondition function that is the PreCondition for
 //      the next step (adding CDS/CDNSKEYs).
 
+// joiningSignerOk verifies that the joining signer recorded for the process is still a
+// member of the signer group of the zone. All join transitions check this, so that the
+// process stops rather than continues with the wrong set of signers.
+func joiningSignerOk(z *music.Zone, caller string) bool {
+	joining, err := z.JoiningSigner()
+	if err != nil {
+		z.Explain("joining signer in the signer group", "", 0, false, nil, err.Error())
+		z.SetStopReason(err.Error())
+		return false
+	}
+	z.Explain("joining signer in the signer group", joining.Name, 0, true, nil, "")
+	log.Printf("%s: %s: joining signer is %s", caller, z.Name, joining.Name)
+	return true
+}
+
+// explainQuery records the RRs returned by a query to a signer (or the parent) for "zone
+// explain". On error, err is recorded instead.
+func explainQuery(z *music.Zone, server string, rrtype uint16, r *dns.Msg, err error, parent bool) {
+	if !z.Explaining() {
+		return
+	}
+	if err != nil {
+		z.Explain("query", server, rrtype, false, nil, err.Error())
+		return
+	}
+	rrs := r.Answer
+	if parent && len(rrs) == 0 {
+		rrs = r.Ns // the NS RRset of the child is in the authority section of a referral
+	}
+	z.Explain("query", server, rrtype, true, rrs, "")
+}
+
+// updatesSupported verifies that the backends of all the signers can update the RR
+// types (see music.UpdaterCapabilities), so that an action stops before updating any
+// signer rather than halfway through.
+func updatesSupported(z *music.Zone, signers []*music.Signer, rrtypes ...uint16) bool {
+	for _, s := range signers {
+		if err := s.CheckUpdateSupport(rrtypes...); err != nil {
+			z.SetStopReason(err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// groupSigners returns the signers in the signer group of the zone.
+func groupSigners(z *music.Zone) []*music.Signer {
+	var signers []*music.Signer
+	for _, s := range z.SGroup.SignerMap {
+		signers = append(signers, s)
+	}
+	return signers
+}
+
 // JoinSyncDnskeys synchronizes all DNSKEY RRs between the signers in the signergroup.
 func JoinSyncDnskeys(z *music.Zone) bool {
 	dnskeys := make(map[string][]*dns.DNSKEY)
 
 	log.Printf("JoinSyncDnskeys: %s: Syncing DNSKEYs in group %s", z.Name, z.SGroup.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("JoinSyncDnskeys: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("JoinSyncDnskeys: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
+	if !joiningSignerOk(z, "JoinSyncDnskeys") {
+		return false
+	}
+
 	for _, s := range z.SGroup.SignerMap {
 		log.Printf("JoinSyncDnskeys: signer: %s\n", s.Name)
 		updater := music.GetUpdater(s.Method)
@@ -140,11 +198,29 @@ func JoinSyncDnskeys(z *music.Zone) bool {
 		}
 	}
 
+	// the DNSKEY RRset will contain the keys of all signers
+	var allkeys []*dns.DNSKEY
+	var allrrs []dns.RR
+	seen := map[string]bool{}
+	for _, keys := range dnskeys {
+		for _, key := range keys {
+			if !seen[key.PublicKey] {
+				seen[key.PublicKey] = true
+				allkeys = append(allkeys, key)
+				allrrs = append(allrrs, key)
+			}
+		}
+	}
+	if err := music.CheckResponseSize(z.Name, dns.TypeDNSKEY, allrrs, allkeys); err != nil {
+		z.SetStopReason(err.Error())
+		return false
+	}
+
 	for signer, keys := range keysToSync {
 		s := z.SGroup.SignerMap[signer]
-		updater := music.GetUpdater(s.Method)
-		if err := updater.Update(s, z.Name, z.Name,
-			&[][]dns.RR{keys}, nil); err != nil {
+		if _, _, err := music.ApplyRRsetChanges(s, z.Name, []music.RRsetChange{
+			{Owner: z.Name, RRtype: dns.TypeDNSKEY, Present: keys},
+		}); err != nil {
 			// TODO: use stringtojoin on keysToSync
 			//log.Printf("%s: Unable to update %s with new DNSKEYs %v: %s", z.Name, signer, keysToSync, err)
 			z.SetStopReason(fmt.Sprintf("%s: Unable to update %s with new DNSKEYs %v: %s", z.Name, signer, keysToSync, err))
@@ -152,21 +228,31 @@ func JoinSyncDnskeys(z *music.Zone) bool {
 		}
 	}
 
+	// the records below the apex that the signers must agree on, if any
+	if ok, reason := z.SyncManagedNames(); !ok {
+		z.SetStopReason(reason)
+		return false
+	}
+
 	return true
 }
 
 // VerifyDnskeysSynched confirms that all the DNSKEY RR's are synced across all signers in the signergroup.
 func VerifyDnskeysSynched(zone *music.Zone) bool {
-	if zone.ZoneType == "debug" {
-		log.Printf("JoinSyncDnskeysPostCondition: zone %s (DEBUG) is automatically ok", zone.Name)
+	if zone.Type().Simulated {
+		log.Printf("JoinSyncDnskeysPostCondition: zone %s (simulated) is automatically ok", zone.Name)
 		return true
 	}
 
-	if music.SignerRRsetEqual(zone, dns.TypeDNSKEY) {
-		log.Printf("[JoinSyncDnskeysPostCondition] All DNSKEYS synced")
-		return true
-	} else {
+	if !music.SignerRRsetEqual(zone, dns.TypeDNSKEY) {
 		log.Printf("[JoinSyncDnskeysPostCondition] All DNSKEYS not synced")
 		return false
 	}
+	if ok, reason := zone.ManagedNamesInSync(); !ok {
+		log.Printf("[JoinSyncDnskeysPostCondition] Managed names not synced: %s", reason)
+		zone.SetStopReason(reason)
+		return false
+	}
+	log.Printf("[JoinSyncDnskeysPostCondition] All DNSKEYS synced")
+	return true
 }
@@ -22,8 +22,8 @@ var FsmLeaveAddCDS = music.FSMTransition{
 
 // LeaveAddCDSPreCondition calculate the relevant DNSKEYS for the signergroup and verify that the signers are correct.
 func LeaveAddCDSPreCondition(z *music.Zone) bool {
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveAddCdsPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveAddCdsPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
@@ -51,7 +51,7 @@ func LeaveAddCDSPreCondition(z *music.Zone) bool {
 	log.Printf("remove %v from SignerMap %v: for %v", leavingSignerName, sg.SignerMap, sg.Name)
 	delete(z.SGroup.SignerMap, leavingSignerName)
 	if _, member := z.SGroup.SignerMap[leavingSignerName]; member {
-		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.SignerMap)
+		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.Name)
 	}
 
 	log.Printf("%s: Verifying that leaving signer %s DNSKEYs has been removed from all signers",
@@ -81,7 +81,8 @@ func LeaveAddCDSPreCondition(z *music.Zone) bool {
 		m := new(dns.Msg)
 		m.SetQuestion(z.Name, dns.TypeDNSKEY)
 		c := new(dns.Client)
-		r, _, err := c.Exchange(m, s.Address+":"+s.Port)
+		r, _, err := s.Exchange(c, m)
+		explainQuery(z, s.Name, dns.TypeDNSKEY, r, err, false)
 		if err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to fetch DNSKEYs from %s: %s", s.Name, err))
 			return false
@@ -94,11 +95,15 @@ func LeaveAddCDSPreCondition(z *music.Zone) bool {
 			}
 
 			if _, ok := dnskeys[fmt.Sprintf("%d-%d-%s", dnskey.Protocol, dnskey.Algorithm, dnskey.PublicKey)]; ok {
+				z.Explain("DNSKEYs of leaving signer "+leavingSigner.Name+" removed", s.Name,
+					dns.TypeDNSKEY, false, []dns.RR{dnskey}, "")
 				z.SetStopReason(fmt.Sprintf("DNSKEY %s still exists in signer %s",
 					dnskey.PublicKey, s.Name))
 				return false
 			}
 		}
+		z.Explain("DNSKEYs of leaving signer "+leavingSigner.Name+" removed", s.Name,
+			dns.TypeDNSKEY, true, nil, "")
 	}
 
 	return true
@@ -108,13 +113,18 @@ func LeaveAddCDSPreCondition(z *music.Zone) bool {
 func LeaveAddCDSAction(z *music.Zone) bool {
 	log.Printf("%s: Creating CDS/CDNSKEY record sets", z.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveAddCdsAction: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveAddCdsAction: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+	if !z.Type().ParentSignals {
+		log.Printf("LeaveAddCdsAction: zone %s (%s) does not signal the parent, no CDS/CDNSKEY", z.Name, z.ZoneType)
 		return true
 	}
 
 	cdses := []dns.RR{}
 	cdnskeys := []dns.RR{}
+	policy := z.Policy()
 
 	leavingSignerName := z.FSMSigner // Issue #34: Static leaving signer until metadata is in place
 	if leavingSignerName == "" {
@@ -127,7 +137,7 @@ func LeaveAddCDSAction(z *music.Zone) bool {
 	log.Printf("remove %v from SignerMap %v: for %v", leavingSignerName, z.SGroup.SignerMap, z.SGroup.Name)
 	delete(z.SGroup.SignerMap, leavingSignerName)
 	if _, member := z.SGroup.SignerMap[leavingSignerName]; member {
-		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.SignerMap)
+		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.Name)
 	}
 
 	for _, s := range z.SGroup.SignerMap {
@@ -136,7 +146,7 @@ func LeaveAddCDSAction(z *music.Zone) bool {
 		m.SetQuestion(z.Name, dns.TypeDNSKEY)
 
 		c := new(dns.Client)
-		r, _, err := c.Exchange(m, s.Address+":"+s.Port)
+		r, _, err := s.Exchange(c, m)
 
 		if err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to fetch DNSKEYs from %s: %s", s.Name, err))
@@ -151,18 +161,32 @@ func LeaveAddCDSAction(z *music.Zone) bool {
 			}
 
 			if f := dnskey.Flags & 0x101; f == 257 {
-				cdses = append(cdses, dnskey.ToDS(dns.SHA256).ToCDS())
-				cdnskeys = append(cdnskeys, dnskey.ToCDNSKEY())
+				for _, dt := range policy.CdsDigests(dns.SHA256) {
+					cds := dnskey.ToDS(dt).ToCDS()
+					if policy.CdsTTL != 0 {
+						cds.Hdr.Ttl = policy.CdsTTL
+					}
+					cdses = append(cdses, cds)
+				}
+				cdnskey := dnskey.ToCDNSKEY()
+				if policy.CdsTTL != 0 {
+					cdnskey.Hdr.Ttl = policy.CdsTTL
+				}
+				cdnskeys = append(cdnskeys, cdnskey)
 			}
 		}
 	}
 
 	// Create CDS/CDNSKEY records sets
 	log.Printf("leave_add_cds: %s SignerMap: %v\n", z.Name, z.SGroup.SignerMap)
+	if !updatesSupported(z, groupSigners(z), dns.TypeCDS, dns.TypeCDNSKEY) {
+		return false
+	}
 	for _, signer := range z.SGroup.SignerMap {
-		updater := music.GetUpdater(signer.Method)
-		if err := updater.Update(signer, z.Name, z.Name,
-			&[][]dns.RR{cdses, cdnskeys}, nil); err != nil {
+		if _, _, err := music.ApplyRRsetChanges(signer, z.Name, []music.RRsetChange{
+			{Owner: z.Name, RRtype: dns.TypeCDS, Present: cdses},
+			{Owner: z.Name, RRtype: dns.TypeCDNSKEY, Present: cdnskeys},
+		}); err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to update %s with CDS/CDNSKEY record sets: %s",
 				signer.Name, err))
 			return false
@@ -175,8 +199,12 @@ func LeaveAddCDSAction(z *music.Zone) bool {
 
 // LeaveCDSVerify Verifies that the CDS/CDNSKEY RRs are published and in sync on the remaining signers in the signergroup.
 func LeaveCDSVerify(zone *music.Zone) bool {
-	if zone.ZoneType == "debug" {
-		log.Printf("LeaveCDSVerify: zone %s (DEBUG) is automatically ok", zone.Name)
+	if zone.Type().Simulated {
+		log.Printf("LeaveCDSVerify: zone %s (simulated) is automatically ok", zone.Name)
+		return true
+	}
+	if !zone.Type().ParentSignals {
+		log.Printf("LeaveCDSVerify: zone %s (%s) does not signal the parent, no CDS/CDNSKEY", zone.Name, zone.ZoneType)
 		return true
 	}
 	matches := true
@@ -22,8 +22,8 @@ var FsmLeaveAddCsync = music.FSMTransition{
 
 // LeaveAddCsyncPreCondition confirms that the leaving signer NS RRs is not configured on the remaining signers in the signergroup.
 func LeaveAddCsyncPreCondition(z *music.Zone) bool {
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveAddCsyncPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveAddCsyncPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
@@ -52,11 +52,17 @@ func LeaveAddCsyncPreCondition(z *music.Zone) bool {
 	log.Printf("remove %v from SignerMap %v: for %v", leavingSignerName, sg.SignerMap, sg.Name)
 	delete(z.SGroup.SignerMap, leavingSignerName)
 	if _, member := z.SGroup.SignerMap[leavingSignerName]; member {
-		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.SignerMap)
+		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.Name)
 	}
 
 	nses := make(map[string]bool)
 
+	external, err := z.MusicDB.ExternalNSes(nil, z.Name)
+	if err != nil {
+		z.SetStopReason(err.Error())
+		return false
+	}
+
 	const sqlq = "SELECT ns FROM zone_nses WHERE zone = ? AND signer = ?"
 
 	// XXX: Should wrap this in a transaction
@@ -73,7 +79,9 @@ func LeaveAddCsyncPreCondition(z *music.Zone) bool {
 			return false
 		}
 
-		nses[ns] = true
+		if !music.IsExternalNS(external, ns) {
+			nses[ns] = true
+		}
 	}
 
 	log.Printf("%s: Verifying that leaving signer %s NSes has been removed from all signers", z.Name, leavingSigner.Name)
@@ -82,7 +90,8 @@ func LeaveAddCsyncPreCondition(z *music.Zone) bool {
 		m := new(dns.Msg)
 		m.SetQuestion(z.Name, dns.TypeNS)
 		c := new(dns.Client)
-		r, _, err := c.Exchange(m, s.Address+":"+s.Port)
+		r, _, err := s.Exchange(c, m)
+		explainQuery(z, s.Name, dns.TypeNS, r, err, false)
 		if err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from %s: %s", s.Name, err))
 			return false
@@ -95,6 +104,8 @@ func LeaveAddCsyncPreCondition(z *music.Zone) bool {
 			}
 
 			if _, ok := nses[ns.Ns]; ok {
+				z.Explain("NSes of leaving signer "+leavingSigner.Name+" removed", s.Name, dns.TypeNS,
+					false, []dns.RR{ns}, "")
 				z.SetStopReason(fmt.Sprintf("NS %s still exists in signer %s", ns.Ns, s.Name))
 				return false
 			}
@@ -104,7 +115,8 @@ func LeaveAddCsyncPreCondition(z *music.Zone) bool {
 	m := new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeNS)
 	c := new(dns.Client)
-	r, _, err := c.Exchange(m, leavingSigner.Address+":"+leavingSigner.Port)
+	r, _, err := leavingSigner.Exchange(c, m)
+	explainQuery(z, leavingSigner.Name, dns.TypeNS, r, err, false)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from %s: %s", leavingSigner.Name, err))
 		return false
@@ -117,6 +129,8 @@ func LeaveAddCsyncPreCondition(z *music.Zone) bool {
 		}
 
 		if _, ok := nses[ns.Ns]; ok {
+			z.Explain("NSes of leaving signer removed", leavingSigner.Name, dns.TypeNS,
+				false, []dns.RR{ns}, "")
 			z.SetStopReason(fmt.Sprintf("NS %s still exists in signer %s",
 				ns.Ns, leavingSigner.Name))
 			return false
@@ -129,8 +143,8 @@ func LeaveAddCsyncPreCondition(z *music.Zone) bool {
 
 // LeaveAddCsyncAction creates and adds the CSYNC record to the remaining signers in the signergroup.
 func LeaveAddCsyncAction(z *music.Zone) bool {
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveAddCsyncAction: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveAddCsyncAction: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
@@ -157,38 +171,25 @@ func LeaveAddCsyncAction(z *music.Zone) bool {
 	log.Printf("remove %v from SignerMap %v: for %v", leavingSignerName, sg.SignerMap, sg.Name)
 	delete(z.SGroup.SignerMap, leavingSignerName)
 	if _, member := z.SGroup.SignerMap[leavingSignerName]; member {
-		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.SignerMap)
+		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.Name)
 	}
 
-	ttl := 300
+	ttl := z.Policy().CsyncTTL
 	z.CSYNC = new(dns.CSYNC)
 	z.CSYNC.Hdr = dns.RR_Header{Name: z.Name, Rrtype: dns.TypeCSYNC, Class: dns.ClassINET, Ttl: uint32(ttl), Rdlength: uint16(12)}
 	z.CSYNC.Serial = 1
 	z.CSYNC.Flags = 1
 	z.CSYNC.TypeBitMap = []uint16{dns.TypeA, dns.TypeNS, dns.TypeAAAA}
 
+	if !updatesSupported(z, append(groupSigners(z), leavingSigner), dns.TypeCSYNC) {
+		return false
+	}
 	for _, signer := range z.SGroup.SignerMap {
-		// check if there is any CSYNC records if there are remove them before adding a csync record
-		updater := music.GetUpdater(signer.Method)
-		err, csyncrrs := updater.FetchRRset(signer, z.Name, z.Name, dns.TypeCSYNC)
-		if err != nil {
-			err, _ = z.SetStopReason(fmt.Sprintf("Unable to fetch CSYNC RRset from %s: %v", signer.Name, err))
-			return false
-		}
-		if len(csyncrrs) != 0 {
-			if err := updater.RemoveRRset(signer, z.Name, z.Name,
-				[][]dns.RR{[]dns.RR{z.CSYNC}}); err != nil {
-				z.SetStopReason(fmt.Sprintf("Unable to remove CSYNC record sets from %s: %s",
-					signer.Name, err))
-				return false
-			}
-			log.Printf("%s: Removed CSYNC record sets from %s successfully", z.Name, signer.Name)
-		}
-
+		// any other CSYNC records are removed
 		log.Printf("%s: Creating CSYNC record sets", z.Name)
-
-		if err := updater.Update(signer, z.Name, z.Name,
-			&[][]dns.RR{[]dns.RR{z.CSYNC}}, nil); err != nil {
+		if _, _, err := music.ApplyRRsetChanges(signer, z.Name, []music.RRsetChange{
+			{Owner: z.Name, RRtype: dns.TypeCSYNC, Present: []dns.RR{z.CSYNC}, Exact: true},
+		}); err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to update %s with CSYNC record sets: %s",
 				signer.Name, err))
 			return false
@@ -196,24 +197,9 @@ func LeaveAddCsyncAction(z *music.Zone) bool {
 		log.Printf("%s: Update %s successfully with CSYNC record sets", z.Name, signer.Name)
 	}
 
-	updater := music.GetUpdater(leavingSigner.Method)
-	err, csyncrrs := updater.FetchRRset(leavingSigner, z.Name, z.Name, dns.TypeCSYNC)
-	if err != nil {
-		err, _ = z.SetStopReason(fmt.Sprintf("Unable to fetch CSYNC RRset from %s: %v", leavingSigner.Name, err))
-		return false
-	}
-	if len(csyncrrs) != 0 {
-		if err := updater.RemoveRRset(leavingSigner, z.Name, z.Name,
-			[][]dns.RR{[]dns.RR{z.CSYNC}}); err != nil {
-			z.SetStopReason(fmt.Sprintf("Unable to remove CSYNC record sets from %s: %s",
-				leavingSigner.Name, err))
-			return false
-		}
-		log.Printf("%s: Removed CSYNC record sets from %s successfully", z.Name, leavingSigner.Name)
-	}
-
-	if err := updater.Update(leavingSigner, z.Name, z.Name,
-		&[][]dns.RR{[]dns.RR{z.CSYNC}}, nil); err != nil {
+	if _, _, err := music.ApplyRRsetChanges(leavingSigner, z.Name, []music.RRsetChange{
+		{Owner: z.Name, RRtype: dns.TypeCSYNC, Present: []dns.RR{z.CSYNC}, Exact: true},
+	}); err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to update %s with CSYNC record sets: %s",
 			leavingSigner.Name, err))
 		return false
@@ -227,8 +213,8 @@ func LeaveAddCsyncAction(z *music.Zone) bool {
 func LeaveVerifyCsyncPublished(z *music.Zone) bool {
 	log.Printf("Verifying Publication of CSYNC record sets for %s", z.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveVerifyCsyncPublished: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveVerifyCsyncPublished: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
@@ -18,6 +18,7 @@ var FsmLeaveParentDsSynced = music.FSMTransition{
 	PreCondition:  LeaveParentDsSyncedPreCondition,
 	Action:        LeaveParentDsSyncedAction,
 	PostCondition: LeaveVerifyCDSRemoval,
+	Propagation:   true,
 }
 
 // LeaveParentDsSyncedPreCondition verifies that the DS records on the parent match the CDS RRs on the remaining signers in the signergroup
@@ -26,8 +27,8 @@ func LeaveParentDsSyncedPreCondition(z *music.Zone) bool {
 
 	log.Printf("%s: Verifying that DSes in parent are up to date compared to signers CDSes", z.Name)
 
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveParentDsSyncedPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveParentDsSyncedPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
@@ -42,7 +43,7 @@ func LeaveParentDsSyncedPreCondition(z *music.Zone) bool {
 	log.Printf("remove %v from SignerMap %v: for %v", leavingSignerName, z.SGroup.SignerMap, z.SGroup.Name)
 	delete(z.SGroup.SignerMap, leavingSignerName)
 	if _, member := z.SGroup.SignerMap[leavingSignerName]; member {
-		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.SignerMap)
+		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.Name)
 	}
 
 	for _, s := range z.SGroup.SignerMap {
@@ -50,7 +51,8 @@ func LeaveParentDsSyncedPreCondition(z *music.Zone) bool {
 		m.SetQuestion(z.Name, dns.TypeCDS)
 
 		c := new(dns.Client)
-		r, _, err := c.Exchange(m, s.Address+":"+s.Port)
+		r, _, err := s.Exchange(c, m)
+		explainQuery(z, s.Name, dns.TypeCDS, r, err, false)
 
 		if err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to fetch CDSes from %s: %s", s.Name, err))
@@ -75,7 +77,8 @@ func LeaveParentDsSyncedPreCondition(z *music.Zone) bool {
 	m := new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeDS)
 	c := new(dns.Client)
-	r, _, err := c.Exchange(m, parentAddress)
+	r, _, err := music.DnsExchange(c, m, parentAddress)
+	explainQuery(z, "parent", dns.TypeDS, r, err, true)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch DSes from parent: %s", err))
 		return false
@@ -87,6 +90,8 @@ func LeaveParentDsSyncedPreCondition(z *music.Zone) bool {
 		}
 
 		if _, ok := cdsmap[fmt.Sprintf("%d %d %d %s", ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)]; !ok {
+			z.Explain("DS in parent matches a CDS of the remaining signers", "parent", dns.TypeDS,
+				false, []dns.RR{ds}, "")
 			z.SetStopReason(fmt.Sprintf("Parent DS found that is not in any signer: %d %d %d %s",
 				ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest))
 			return false
@@ -94,6 +99,7 @@ func LeaveParentDsSyncedPreCondition(z *music.Zone) bool {
 	}
 
 	log.Printf("%s: Parent is up-to-date with it's DS records", z.Name)
+	z.EmitMetric("ds_seen_at_parent_seconds", z.StateSeconds(), nil)
 	return true
 }
 
@@ -114,8 +120,8 @@ func LeaveVerifyCDSRemoval(zone *music.Zone) bool {
 func LeaveParentDsSyncedAction(z *music.Zone) bool {
 	log.Printf("%s: Removing CDS/CDNSKEY record sets", z.Name)
 
-	if z.ZoneType == "debug" {
-	   log.Printf("LeaveParentDsSyncedAction: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+	   log.Printf("LeaveParentDsSyncedAction: zone %s (simulated) is automatically ok", z.Name)
 	   return true
 	}
 
@@ -144,8 +150,8 @@ func LeaveParentDsSyncedAction(z *music.Zone) bool {
 
 // LeaveVerifyCDSRemoval Verifies that the CDS/CDNSKEY Records have been removed
 func LeaveVerifyCDSRemoval(zone *music.Zone) bool {
-	if zone.ZoneType == "debug" {
-		log.Printf("LeaveVerifyCDSRemoval: zone %s (DEBUG) is automatically ok", zone.Name)
+	if zone.Type().Simulated {
+		log.Printf("LeaveVerifyCDSRemoval: zone %s (simulated) is automatically ok", zone.Name)
 		return true
 	}
 
@@ -18,12 +18,13 @@ var FsmLeaveParentNsSynced = music.FSMTransition{
 	PreCondition:  LeaveParentNsSyncedPreCondition,
 	Action:        LeaveParentNsSyncedAction,
 	PostCondition: LeaveParentNsSyncedPostCondition,
+	Propagation:   true,
 }
 
 // LeaveParentNsSyncedPreCondition verifies that NS records in parent are in synced with the remaining signers in the signergroup.
 func LeaveParentNsSyncedPreCondition(z *music.Zone) bool {
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveParentNsSyncedPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveParentNsSyncedPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
@@ -50,7 +51,7 @@ func LeaveParentNsSyncedPreCondition(z *music.Zone) bool {
 	log.Printf("remove %v from SignerMap %v: for %v", leavingSignerName, sg.SignerMap, sg.Name)
 	delete(z.SGroup.SignerMap, leavingSignerName)
 	if _, member := z.SGroup.SignerMap[leavingSignerName]; member {
-		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.SignerMap)
+		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.Name)
 	}
 
 	nses := make(map[string][]*dns.NS)
@@ -61,7 +62,8 @@ func LeaveParentNsSyncedPreCondition(z *music.Zone) bool {
 		m := new(dns.Msg)
 		m.SetQuestion(z.Name, dns.TypeNS)
 		c := new(dns.Client)
-		r, _, err := c.Exchange(m, s.Address+":"+s.Port)
+		r, _, err := s.Exchange(c, m)
+		explainQuery(z, s.Name, dns.TypeNS, r, err, false)
 		if err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from %s: %s", s.Name, err))
 			return false
@@ -82,7 +84,8 @@ func LeaveParentNsSyncedPreCondition(z *music.Zone) bool {
 	m := new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeNS)
 	c := new(dns.Client)
-	r, _, err := c.Exchange(m, leavingSigner.Address+":"+leavingSigner.Port)
+	r, _, err := leavingSigner.Exchange(c, m)
+	explainQuery(z, leavingSigner.Name, dns.TypeNS, r, err, false)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from %s: %s", leavingSigner.Name, err))
 		return false
@@ -115,7 +118,8 @@ func LeaveParentNsSyncedPreCondition(z *music.Zone) bool {
 	m = new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeNS)
 	c = new(dns.Client)
-	r, _, err = c.Exchange(m, parentAddress)
+	r, _, err = music.DnsExchange(c, m, parentAddress)
+	explainQuery(z, "parent", dns.TypeNS, r, err, true)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from parent: %s", err))
 		return false
@@ -128,19 +132,22 @@ func LeaveParentNsSyncedPreCondition(z *music.Zone) bool {
 		}
 
 		if _, ok := nsmap[ns.Ns]; !ok {
+			z.Explain("NS "+ns.Ns+" in parent served by a signer", "parent", dns.TypeNS, false,
+				[]dns.RR{ns}, "")
 			z.SetStopReason(fmt.Sprintf("NS %s still exists in parent", ns.Ns))
 			return false
 		}
 	}
 
 	log.Printf("%s: Parent NSes are up-to-date", z.Name)
+	z.EmitMetric("ns_seen_at_parent_seconds", z.StateSeconds(), nil)
 	return true
 }
 
 // LeaveParentNsSyncedAction removes the CSYNC RRs from the remaining signers in the signergroup.
 func LeaveParentNsSyncedAction(z *music.Zone) bool {
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveParentNsSyncedAction: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveParentNsSyncedAction: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
@@ -169,7 +176,7 @@ func LeaveParentNsSyncedAction(z *music.Zone) bool {
 	log.Printf("remove %v from SignerMap %v: for %v", leavingSignerName, sg.SignerMap, sg.Name)
 	delete(z.SGroup.SignerMap, leavingSignerName)
 	if _, member := z.SGroup.SignerMap[leavingSignerName]; member {
-		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.SignerMap)
+		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.Name)
 	}
 
 	log.Printf("%s: Removing CSYNC record sets", z.Name)
@@ -201,8 +208,8 @@ func LeaveParentNsSyncedAction(z *music.Zone) bool {
 
 // LeaveParentNsSyncedPostCondition confirms there are no CSYNC records on the remaining signers in the signergroup.
 func LeaveParentNsSyncedPostCondition(zone *music.Zone) bool {
-	if zone.ZoneType == "debug" {
-		log.Printf("LeaveParentNsSyncedPostCondition: zone %s (DEBUG) is automatically ok", zone.Name)
+	if zone.Type().Simulated {
+		log.Printf("LeaveParentNsSyncedPostCondition: zone %s (simulated) is automatically ok", zone.Name)
 		return true
 	}
 
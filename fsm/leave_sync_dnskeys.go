@@ -23,20 +23,27 @@ var FsmLeaveSyncDnskeys = music.FSMTransition{
 
 // LeaveSyncDnskeysPreCondition calculates a waiting period for NS propagation and then waits.
 func LeaveSyncDnskeysPreCondition(z *music.Zone) bool {
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveSyncDnskeysPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveSyncDnskeysPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
-	if until, ok := zoneWaitNs[z.Name]; ok {
+	if until, ok := z.Waiting("wait-ns"); ok {
+		z.Explain("wait-ns ended", "", 0, !time.Now().Before(until), nil,
+			"waiting until "+until.Format(time.RFC3339))
 		if time.Now().Before(until) {
-			// XXX: Here we need z.SetDelayReason(reason, duration)
 			z.SetStopReason(fmt.Sprintf("%s: Waiting until %s (%s)", z.Name, until.String(), time.Until(until).String()))
 			log.Printf("%s: Waiting until %s (%s)", z.Name, until.String(), time.Until(until).String())
 			return false
 		}
+		ok, reason := z.AtlasConfirmed(dns.TypeNS)
+		z.Explain("NS propagation confirmed by RIPE Atlas", "parent", dns.TypeNS, ok, nil, reason)
+		if !ok {
+			z.SetStopReason(reason)
+			return false
+		}
 		log.Printf("%s: Waited enough for NS, critera fullfilled", z.Name)
-		delete(zoneWaitNs, z.Name)
+		z.EndWait("wait-ns")
 		return true
 	}
 
@@ -65,7 +72,8 @@ func LeaveSyncDnskeysPreCondition(z *music.Zone) bool {
 		m := new(dns.Msg)
 		m.SetQuestion(z.Name, dns.TypeNS)
 		c := new(dns.Client)
-		r, _, err := c.Exchange(m, s.Address+":"+s.Port)
+		r, _, err := s.Exchange(c, m)
+		explainQuery(z, s.Name, dns.TypeNS, r, err, false)
 		if err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from %s: %s", s.Name, err))
 			return false
@@ -86,7 +94,8 @@ func LeaveSyncDnskeysPreCondition(z *music.Zone) bool {
 	m := new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeNS)
 	c := new(dns.Client)
-	r, _, err := c.Exchange(m, leavingSigner.Address+":"+leavingSigner.Port)
+	r, _, err := leavingSigner.Exchange(c, m)
+	explainQuery(z, leavingSigner.Name, dns.TypeNS, r, err, false)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from %s: %s", leavingSigner.Name, err))
 		return false
@@ -111,7 +120,8 @@ func LeaveSyncDnskeysPreCondition(z *music.Zone) bool {
 	m = new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeNS)
 	c = new(dns.Client)
-	r, _, err = c.Exchange(m, parentAddress)
+	r, _, err = music.DnsExchange(c, m, parentAddress)
+	explainQuery(z, "parent", dns.TypeNS, r, err, true)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from parent: %s", err))
 		return false
@@ -128,22 +138,19 @@ func LeaveSyncDnskeysPreCondition(z *music.Zone) bool {
 		}
 	}
 
-	// until := time.Now().Add((time.Duration(ttl*2) * time.Second))
-	// TODO: static wait time to enable faster testing
-	until := time.Now().Add((time.Duration(5) * time.Second))
+	until := time.Now().Add(music.HoldDown(z.Policy().NsHoldDown, ttl))
 
 	log.Printf("%s: Largest TTL found was %d, waiting until %s (%s)", z.Name, ttl, until.String(), time.Until(until).String())
 
-	// XXX: Here we need the z.SetDelayReason()
-	zoneWaitNs[z.Name] = until
+	z.WaitUntil("wait-ns", until)
 	z.SetStopReason(fmt.Sprintf("%s: Waiting until %s (%s)", z.Name, until.String(), time.Until(until).String()))
 	return false
 }
 
 // LeaveSyncDnskeysAction synchronizes all DNSKEY RRs between the remaining signers in the signergroup.
 func LeaveSyncDnskeysAction(z *music.Zone) bool {
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveSyncDnskeysAction: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveSyncDnskeysAction: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
@@ -172,7 +179,7 @@ func LeaveSyncDnskeysAction(z *music.Zone) bool {
 	log.Printf("remove %v from SignerMap %v: for %v", leavingSignerName, sg.SignerMap, sg.Name)
 	delete(z.SGroup.SignerMap, leavingSignerName)
 	if _, member := z.SGroup.SignerMap[leavingSignerName]; member {
-		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.SignerMap)
+		log.Fatalf("Signer %s is still a member of group %s", leavingSignerName, z.SGroup.Name)
 	}
 
 	log.Printf("%s: Removing DNSKEYs originating from leaving signer %s", z.Name, leavingSigner.Name)
@@ -201,7 +208,7 @@ func LeaveSyncDnskeysAction(z *music.Zone) bool {
 		m := new(dns.Msg)
 		m.SetQuestion(z.Name, dns.TypeDNSKEY)
 		c := new(dns.Client)
-		r, _, err := c.Exchange(m, s.Address+":"+s.Port)
+		r, _, err := s.Exchange(c, m)
 		if err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to fetch DNSKEYs from %s: %s", s.Name, err))
 			return false
@@ -221,8 +228,9 @@ func LeaveSyncDnskeysAction(z *music.Zone) bool {
 		}
 
 		if len(rem) > 0 {
-			updater := music.GetUpdater(s.Method)
-			if err := updater.Update(s, z.Name, z.Name, nil, &[][]dns.RR{rem}); err != nil {
+			if _, _, err := music.ApplyRRsetChanges(s, z.Name, []music.RRsetChange{
+				{Owner: z.Name, RRtype: dns.TypeDNSKEY, Absent: rem},
+			}); err != nil {
 				z.SetStopReason(fmt.Sprintf("Unable to remove DNSKEYs from %s: %s",
 					s.Name, err))
 				return false
@@ -236,8 +244,8 @@ func LeaveSyncDnskeysAction(z *music.Zone) bool {
 
 // LeaveSyncDnskeysVerify confirms that all the DNSKEY RR's are synced across all signers in the signergroup.
 func LeaveSyncDnskeysVerify(zone *music.Zone) bool {
-	if zone.ZoneType == "debug" {
-		log.Printf("LeaveSyncDnskeysPostCondition: zone %s (DEBUG) is automatically ok", zone.Name)
+	if zone.Type().Simulated {
+		log.Printf("LeaveSyncDnskeysPostCondition: zone %s (simulated) is automatically ok", zone.Name)
 		return true
 	}
 
@@ -57,6 +57,12 @@ func LeaveSyncNsesAction(zone *music.Zone) bool {
 		log.Printf("zone signergroup signermap: %v", zone.SGroup.SignerMap)
 	}
 
+	external, err := zone.MusicDB.ExternalNSes(nil, zone.Name)
+	if err != nil {
+		zone.SetStopReason(err.Error())
+		return false
+	}
+
 	const sqlq = "SELECT ns FROM zone_nses WHERE zone = ? AND signer = ?"
 	rows, err := zone.MusicDB.Query(sqlq, zone.Name, leavingSignerName)
 	if err != nil {
@@ -70,6 +76,10 @@ func LeaveSyncNsesAction(zone *music.Zone) bool {
 			log.Printf("%s: Rows.Scan() failed: %s", zone.Name, err)
 			return false
 		}
+		if music.IsExternalNS(external, ns) {
+			log.Printf("%s: NS %s is external, not removed", zone.Name, ns)
+			continue
+		}
 
 		rr := new(dns.NS)
 		rr.Hdr = dns.RR_Header{Name: zone.Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 0}
@@ -77,10 +87,14 @@ func LeaveSyncNsesAction(zone *music.Zone) bool {
 		nsToRemove = append(nsToRemove, rr)
 	}
 	log.Printf("NSes to remove: %v", nsToRemove)
+	if len(nsToRemove) == 0 {
+		return true
+	}
 
 	for _, signer := range zone.SGroup.SignerMap {
-		updater := music.GetUpdater(signer.Method)
-		if err := updater.Update(signer, zone.Name, zone.Name, nil, &[][]dns.RR{nsToRemove}); err != nil {
+		if _, _, err := music.ApplyRRsetChanges(signer, zone.Name, []music.RRsetChange{
+			{Owner: zone.Name, RRtype: dns.TypeNS, Absent: nsToRemove},
+		}); err != nil {
 			zone.SetStopReason(fmt.Sprintf("Unable to remove NSes from %s: %s", signer.Name, err))
 			return false
 		}
@@ -93,8 +107,8 @@ func LeaveSyncNsesAction(zone *music.Zone) bool {
 // LeaveSyncNsesPostCondition checks that the NS RRs on the signers in the signergroup are in sync.
 func LeaveSyncNsesPostCondition(zone *music.Zone) bool {
 	log.Printf("Verify NSes verify that NSes are in sync")
-	if zone.ZoneType == "debug" {
-		log.Printf("LeaveSyncNsesPostCondition: zone %s (DEBUG) is automatically ok", zone.Name)
+	if zone.Type().Simulated {
+		log.Printf("LeaveSyncNsesPostCondition: zone %s (simulated) is automatically ok", zone.Name)
 		return true
 	}
 	return music.SignerRRsetEqual(zone, dns.TypeNS)
@@ -9,12 +9,6 @@ import (
 	"github.com/miekg/dns"
 )
 
-var zoneWaitNs map[string]time.Time // Issue #34: using local store for now
-
-func init() {
-	zoneWaitNs = make(map[string]time.Time)
-}
-
 var FsmLeaveWaitNs = music.FSMTransition{
 	Description: "Wait enough time for parent NS records to propagate (criteria), then continue (NO action)",
 
@@ -25,23 +19,31 @@ var FsmLeaveWaitNs = music.FSMTransition{
 	PreCondition:  LeaveWaitNsPreCondition,
 	Action:        LeaveWaitNsAction,
 	PostCondition: func(z *music.Zone) bool { return true },
+	Propagation:   true,
 }
 
 // LeaveWaitNsPreCondition calculates a waiting period for NS propegation and then waits.
 func LeaveWaitNsPreCondition(z *music.Zone) bool {
-	if z.ZoneType == "debug" {
-		log.Printf("LeaveWaitNsPreCondition: zone %s (DEBUG) is automatically ok", z.Name)
+	if z.Type().Simulated {
+		log.Printf("LeaveWaitNsPreCondition: zone %s (simulated) is automatically ok", z.Name)
 		return true
 	}
 
-	if until, ok := zoneWaitNs[z.Name]; ok {
+	if until, ok := z.Waiting("wait-ns"); ok {
+		z.Explain("wait-ns ended", "", 0, !time.Now().Before(until), nil,
+			"waiting until "+until.Format(time.RFC3339))
 		if time.Now().Before(until) {
-			// XXX: Here we need z.SetDelayReason(reason, duration)
 			log.Printf("%s: Waiting until %s (%s)", z.Name, until.String(), time.Until(until).String())
 			return false
 		}
+		ok, reason := z.AtlasConfirmed(dns.TypeNS)
+		z.Explain("NS propagation confirmed by RIPE Atlas", "parent", dns.TypeNS, ok, nil, reason)
+		if !ok {
+			z.SetStopReason(reason)
+			return false
+		}
 		log.Printf("%s: Waited enough for NS, critera fullfilled", z.Name)
-		delete(zoneWaitNs, z.Name)
+		z.EndWait("wait-ns")
 		return true
 	}
 
@@ -70,7 +72,8 @@ func LeaveWaitNsPreCondition(z *music.Zone) bool {
 		m := new(dns.Msg)
 		m.SetQuestion(z.Name, dns.TypeNS)
 		c := new(dns.Client)
-		r, _, err := c.Exchange(m, s.Address+":"+s.Port)
+		r, _, err := s.Exchange(c, m)
+		explainQuery(z, s.Name, dns.TypeNS, r, err, false)
 		if err != nil {
 			z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from %s: %s", s.Name, err))
 			return false
@@ -91,7 +94,8 @@ func LeaveWaitNsPreCondition(z *music.Zone) bool {
 	m := new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeNS)
 	c := new(dns.Client)
-	r, _, err := c.Exchange(m, leavingSigner.Address+":"+leavingSigner.Port)
+	r, _, err := leavingSigner.Exchange(c, m)
+	explainQuery(z, leavingSigner.Name, dns.TypeNS, r, err, false)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from %s: %s", leavingSigner.Name, err))
 		return false
@@ -116,7 +120,8 @@ func LeaveWaitNsPreCondition(z *music.Zone) bool {
 	m = new(dns.Msg)
 	m.SetQuestion(z.Name, dns.TypeNS)
 	c = new(dns.Client)
-	r, _, err = c.Exchange(m, parentAddress)
+	r, _, err = music.DnsExchange(c, m, parentAddress)
+	explainQuery(z, "parent", dns.TypeNS, r, err, true)
 	if err != nil {
 		z.SetStopReason(fmt.Sprintf("Unable to fetch NSes from parent: %s", err))
 		return false
@@ -133,14 +138,11 @@ func LeaveWaitNsPreCondition(z *music.Zone) bool {
 		}
 	}
 
-	// until := time.Now().Add((time.Duration(ttl*2) * time.Second))
-	// TODO: static wait time to enable faster testing
-	until := time.Now().Add((time.Duration(5) * time.Second))
+	until := time.Now().Add(music.HoldDown(z.Policy().NsHoldDown, ttl))
 
 	log.Printf("%s: Largest TTL found was %d, waiting until %s (%s)", z.Name, ttl, until.String(), time.Until(until).String())
 
-	// XXX: Here we need the z.SetDelayReason()
-	zoneWaitNs[z.Name] = until
+	z.WaitUntil("wait-ns", until)
 	return false
 }
 
@@ -148,6 +150,6 @@ func LeaveWaitNsAction(z *music.Zone) bool {
 	// XXX: What should we do about the delete() after the state transition?
 	// z.StateTransition(FsmStateParentNsSynced, FsmStateNsPropagated)
 	// The delete action has moved to the true-branch of the PreCondition
-	// delete(zoneWaitNs, z.Name)
+	// z.EndWait("wait-ns")
 	return true
 }
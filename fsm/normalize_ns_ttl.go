@@ -0,0 +1,82 @@
+package fsm
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/miekg/dns"
+)
+
+var FsmNormalizeNsTTL = music.FSMTransition{
+	Description: "Once the NS RRsets are in sync (criteria), set the TTL of the apex NS RRset at all signers to the NS TTL of the policy, if there is one (action)",
+
+	MermaidPreCondDesc:  "None",
+	MermaidActionDesc:   "Set the NS RRset TTL to the policy NS TTL in all signers",
+	MermaidPostCondDesc: "Verify that the NS RRset TTL is the same in all signers",
+
+	PreCondition:  NormalizeNsTTLPreCondition,
+	Action:        NormalizeNsTTLAction,
+	PostCondition: VerifyNsTTLNormalized,
+}
+
+// NormalizeNsTTLPreCondition is an automatic true, the NS RRsets were verified to be in
+// sync when the zone entered the state.
+func NormalizeNsTTLPreCondition(z *music.Zone) bool {
+	return true
+}
+
+// NormalizeNsTTLAction sets the TTL of the apex NS RRset at the signers in the signergroup
+// to the NS TTL of the zone. Without an NS TTL nothing is done.
+func NormalizeNsTTLAction(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("NormalizeNsTTLAction: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+	ttl := z.NsTTL()
+	if ttl == 0 {
+		log.Printf("NormalizeNsTTLAction: zone %s: no NS TTL in policy, NS TTLs left as is", z.Name)
+		return true
+	}
+
+	for _, signer := range z.SGroup.SignerMap {
+		if _, err := music.NormalizeRRsetTTL(signer, z.Name, z.Name, dns.TypeNS, ttl); err != nil {
+			z.SetStopReason(fmt.Sprintf("Unable to set NS TTL to %d in %s: %v", ttl, signer.Name, err))
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyNsTTLNormalized verifies that the apex NS RRset has the NS TTL of the zone at all
+// the signers in the signergroup.
+func VerifyNsTTLNormalized(z *music.Zone) bool {
+	if z.Type().Simulated {
+		log.Printf("VerifyNsTTLNormalized: zone %s (simulated) is automatically ok", z.Name)
+		return true
+	}
+	ttl := z.NsTTL()
+	if ttl == 0 {
+		return true
+	}
+
+	for _, signer := range z.SGroup.SignerMap {
+		updater := music.GetUpdater(signer.Method)
+		err, rrs := updater.FetchRRset(signer, z.Name, z.Name, dns.TypeNS)
+		if err != nil {
+			z.Explain("fetch RRset", signer.Name, dns.TypeNS, false, nil, err.Error())
+			z.SetStopReason(fmt.Sprintf("Unable to fetch NS RRset from %s: %v", signer.Name, err))
+			return false
+		}
+		z.Explain("fetch RRset", signer.Name, dns.TypeNS, true, rrs, "")
+		for _, rr := range rrs {
+			if rr.Header().Ttl != ttl {
+				z.SetStopReason(fmt.Sprintf("NS RRset in %s has TTL %d, not %d", signer.Name,
+					rr.Header().Ttl, ttl))
+				return false
+			}
+		}
+	}
+	log.Printf("%s: NS RRset has TTL %d in all signers", z.Name, ttl)
+	return true
+}
@@ -0,0 +1,14 @@
+/*
+ */
+package main
+
+const (
+	// This is not stable or robust:
+	DefaultCfgFile = "../etc/k8s-bridge.yaml"
+
+	// Kubernetes in-cluster service account files
+	ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	CRDGroup   = "music.dnssec-provisioning.org"
+	CRDVersion = "v1alpha1"
+)
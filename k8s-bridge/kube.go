@@ -0,0 +1,173 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A minimal client for the Kubernetes API, using the in-cluster service account. Only
+// what is needed to list the MuSiC custom resources and update their status is
+// implemented, to avoid pulling in client-go.
+
+type KubeClient struct {
+	BaseUrl   string
+	Token     string
+	Namespace string // empty = all namespaces
+	Client    *http.Client
+}
+
+type ObjectMeta struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Generation int64  `json:"generation"`
+}
+
+type ResourceStatus struct {
+	ObservedGeneration int64  `json:"observedGeneration"`
+	Ready              bool   `json:"ready"`
+	State              string `json:"state,omitempty"`
+	Message            string `json:"message,omitempty"`
+}
+
+type ZoneResource struct {
+	Metadata ObjectMeta     `json:"metadata"`
+	Spec     ZoneSpec       `json:"spec"`
+	Status   ResourceStatus `json:"status"`
+}
+
+type ZoneSpec struct {
+	Name        string   `json:"name"` // defaults to metadata.name
+	SignerGroup string   `json:"signerGroup"`
+	Type        string   `json:"type"`
+	FSMMode     string   `json:"fsmMode"`
+	Signers     []string `json:"signers"`
+}
+
+type SignerGroupResource struct {
+	Metadata ObjectMeta      `json:"metadata"`
+	Spec     SignerGroupSpec `json:"spec"`
+	Status   ResourceStatus  `json:"status"`
+}
+
+type SignerGroupSpec struct {
+	Name    string   `json:"name"`
+	Signers []string `json:"signers"`
+}
+
+func NewInClusterKubeClient(namespace string) (*KubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("Not running in a cluster (KUBERNETES_SERVICE_HOST/PORT not set).")
+	}
+
+	token, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	cacert, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(cacert) {
+		return nil, fmt.Errorf("Could not parse cluster CA certificate.")
+	}
+
+	return &KubeClient{
+		BaseUrl:   "https://" + net.JoinHostPort(host, port),
+		Token:     strings.TrimSpace(string(token)),
+		Namespace: namespace,
+		Client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+	}, nil
+}
+
+func (kc *KubeClient) resourcePath(resource, namespace, name string) string {
+	path := fmt.Sprintf("/apis/%s/%s", CRDGroup, CRDVersion)
+	if namespace != "" {
+		path += "/namespaces/" + namespace
+	}
+	path += "/" + resource
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+func (kc *KubeClient) do(method, path, ctype string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, kc.BaseUrl+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+kc.Token)
+	req.Header.Set("Accept", "application/json")
+	if ctype != "" {
+		req.Header.Set("Content-Type", ctype)
+	}
+
+	resp, err := kc.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: status %d: %s", method, path, resp.StatusCode, string(buf))
+	}
+	return buf, nil
+}
+
+func (kc *KubeClient) ListZones() ([]ZoneResource, error) {
+	var list struct {
+		Items []ZoneResource `json:"items"`
+	}
+	buf, err := kc.do("GET", kc.resourcePath("zones", kc.Namespace, ""), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &list)
+	return list.Items, err
+}
+
+func (kc *KubeClient) ListSignerGroups() ([]SignerGroupResource, error) {
+	var list struct {
+		Items []SignerGroupResource `json:"items"`
+	}
+	buf, err := kc.do("GET", kc.resourcePath("signergroups", kc.Namespace, ""), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &list)
+	return list.Items, err
+}
+
+// UpdateStatus merge-patches the status subresource of a custom resource.
+func (kc *KubeClient) UpdateStatus(resource string, meta ObjectMeta, status ResourceStatus) error {
+	patch, err := json.Marshal(map[string]ResourceStatus{"status": status})
+	if err != nil {
+		return err
+	}
+	path := kc.resourcePath(resource, meta.Namespace, meta.Name) + "/status"
+	_, err = kc.do("PATCH", path, "application/merge-patch+json", patch)
+	return err
+}
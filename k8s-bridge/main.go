@@ -0,0 +1,161 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+)
+
+// k8s-bridge is a small controller that maps Zone and SignerGroup custom resources
+// onto musicd. Every resource is sent to musicd as an idempotent upsert on each run
+// and the result (observedGeneration, readiness and a message) is written back to the
+// status of the resource. Signer groups are synced before zones, as zones refer to them.
+
+func main() {
+	cfgfile := DefaultCfgFile
+	if len(os.Args) > 1 {
+		cfgfile = os.Args[1]
+	}
+	viper.SetConfigFile(cfgfile)
+	viper.AutomaticEnv()
+	err := viper.ReadInConfig()
+	if err != nil {
+		log.Fatalf("viper: Could not load config (%s)", err)
+	}
+
+	kc, err := NewInClusterKubeClient(viper.GetString("bridge.namespace"))
+	if err != nil {
+		log.Fatalf("Error from NewInClusterKubeClient: %v", err)
+	}
+
+	api := music.NewClient("musicd", viper.GetString("musicd.baseurl"),
+		viper.GetString("musicd.apikey"), viper.GetString("musicd.authmethod"),
+		viper.GetString("musicd.rootCApem"), viper.GetBool("bridge.verbose"),
+		viper.GetBool("bridge.debug"))
+
+	interval := viper.GetInt("bridge.interval")
+	if interval < 10 {
+		interval = 30
+	}
+	log.Printf("k8s-bridge: will sync custom resources with %s once every %d seconds\n",
+		viper.GetString("musicd.baseurl"), interval)
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+
+	exit := make(chan os.Signal, 1)
+	signal.Notify(exit, syscall.SIGINT, syscall.SIGTERM)
+
+	SyncResources(kc, api)
+	for {
+		select {
+		case <-ticker.C:
+			SyncResources(kc, api)
+		case <-exit:
+			log.Println("k8s-bridge: exit signal received. Terminating.")
+			return
+		}
+	}
+}
+
+func SyncResources(kc *KubeClient, api *music.Api) {
+	sgs, err := kc.ListSignerGroups()
+	if err != nil {
+		log.Printf("SyncResources: Error listing SignerGroups: %v", err)
+		return
+	}
+	for _, sg := range sgs {
+		name := sg.Spec.Name
+		if name == "" {
+			name = sg.Metadata.Name
+		}
+		up := music.UpsertPost{
+			Kind:        "SignerGroup",
+			Name:        name,
+			Generation:  sg.Metadata.Generation,
+			SignerGroup: music.SignerGroupDef{Name: name, Signers: sg.Spec.Signers},
+		}
+		SyncResource(kc, api, "signergroups", sg.Metadata, sg.Status, up)
+	}
+
+	zones, err := kc.ListZones()
+	if err != nil {
+		log.Printf("SyncResources: Error listing Zones: %v", err)
+		return
+	}
+	for _, z := range zones {
+		name := z.Spec.Name
+		if name == "" {
+			name = z.Metadata.Name
+		}
+		up := music.UpsertPost{
+			Kind:       "Zone",
+			Name:       name,
+			Generation: z.Metadata.Generation,
+			Zone: music.ZoneDef{
+				Name:        name,
+				SignerGroup: z.Spec.SignerGroup,
+				ZoneType:    z.Spec.Type,
+				FSMMode:     z.Spec.FSMMode,
+				Signers:     z.Spec.Signers,
+			},
+		}
+		SyncResource(kc, api, "zones", z.Metadata, z.Status, up)
+	}
+}
+
+// SyncResource upserts one resource and updates its status if anything has changed.
+func SyncResource(kc *KubeClient, api *music.Api, resource string, meta ObjectMeta,
+	old ResourceStatus, up music.UpsertPost) {
+	ur, err := SendUpsert(api, up)
+	status := ResourceStatus{ObservedGeneration: old.ObservedGeneration}
+	if err != nil {
+		status.Message = err.Error()
+	} else {
+		status.ObservedGeneration = ur.ObservedGeneration
+		status.Ready = ur.Ready
+		status.State = ur.State
+		status.Message = ur.Msg
+		if ur.Error {
+			status.Message = ur.ErrorMsg
+		}
+		for _, c := range ur.Changes {
+			log.Printf("%s %s: %s", up.Kind, up.Name, c)
+		}
+	}
+
+	if status == old {
+		return
+	}
+	err = kc.UpdateStatus(resource, meta, status)
+	if err != nil {
+		log.Printf("SyncResource: Error updating status of %s %s: %v", up.Kind, meta.Name, err)
+	}
+}
+
+func SendUpsert(api *music.Api, up music.UpsertPost) (music.UpsertResponse, error) {
+	var ur music.UpsertResponse
+
+	bytebuf := new(bytes.Buffer)
+	json.NewEncoder(bytebuf).Encode(up)
+
+	status, buf, err := api.Post("/upsert", bytebuf.Bytes())
+	if err != nil {
+		return ur, err
+	}
+	if status != 200 {
+		return ur, fmt.Errorf("musicd returned status %d", status)
+	}
+	err = json.Unmarshal(buf, &ur)
+	return ur, err
+}
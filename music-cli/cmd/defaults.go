@@ -8,4 +8,5 @@ const (
  	// This is what we use for testing:
 	DefaultCfgFile   = "../etc/music-cli.yaml"
 	DefaultTokenFile = "../etc/music-cli.tokens.yaml"
+	DefaultOIDCTokenFile = "../etc/music-cli.oidc-token.json"
 )
@@ -0,0 +1,77 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+
+	"github.com/spf13/cobra"
+)
+
+var gitopsCmd = &cobra.Command{
+	Use:   "gitops",
+	Short: "Sync zones and signer groups from the definitions in the GitOps source",
+	Run: func(cmd *cobra.Command, args []string) {
+	},
+}
+
+var gitopsPlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the changes needed to make MuSiC match the definitions",
+	Run: func(cmd *cobra.Command, args []string) {
+		PrintGitOpsResponse(SendGitOpsCmd(music.GitOpsPost{Command: "plan"}))
+	},
+}
+
+var gitopsApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply the changes needed to make MuSiC match the definitions",
+	Run: func(cmd *cobra.Command, args []string) {
+		PrintGitOpsResponse(SendGitOpsCmd(music.GitOpsPost{Command: "apply"}))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gitopsCmd)
+	gitopsCmd.AddCommand(gitopsPlanCmd, gitopsApplyCmd)
+}
+
+func SendGitOpsCmd(data music.GitOpsPost) music.GitOpsResponse {
+	bytebuf := new(bytes.Buffer)
+	json.NewEncoder(bytebuf).Encode(data)
+
+	status, buf, err := api.Post("/gitops", bytebuf.Bytes())
+	if err != nil {
+		log.Fatalf("SendGitOpsCmd: Error from api.Post: %v", err)
+	}
+	if cliconf.Debug {
+		fmt.Printf("Status: %d\n", status)
+	}
+
+	var gr music.GitOpsResponse
+	err = json.Unmarshal(buf, &gr)
+	if err != nil {
+		log.Fatalf("SendGitOpsCmd: Error from json.Unmarshal: %v", err)
+	}
+	return gr
+}
+
+func PrintGitOpsResponse(gr music.GitOpsResponse) {
+	if gr.Error {
+		fmt.Printf("Error: %s\n", gr.ErrorMsg)
+		return
+	}
+	fmt.Printf("%s\n", gr.Msg)
+	if len(gr.Output) == 0 {
+		fmt.Printf("No changes.\n")
+	}
+	for _, line := range gr.Output {
+		fmt.Printf("  %s\n", line)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Login to musicd via OIDC (device flow) and cache the token for later commands",
+	Run: func(cmd *cobra.Command, args []string) {
+		issuer := viper.GetString("oidc.issuer")
+		if issuer == "" {
+			log.Fatalf("Error: oidc.issuer not configured. Terminating.\n")
+		}
+		p, err := music.OIDCDiscover(issuer)
+		if err != nil {
+			log.Fatalf("Error: %v\n", err)
+		}
+
+		scopes := viper.GetString("oidc.scopes")
+		if scopes == "" {
+			scopes = "openid offline_access"
+		}
+		tok, err := music.OIDCDeviceLogin(p, viper.GetString("oidc.clientid"), scopes,
+			func(verifyuri, usercode string) {
+				fmt.Printf("To login, visit %s and enter the code %s\n", verifyuri, usercode)
+				fmt.Printf("Waiting for login to complete...\n")
+			})
+		if err != nil {
+			log.Fatalf("Error: %v\n", err)
+		}
+
+		if err = music.SaveOIDCToken(oidcTokenFile(), tok); err != nil {
+			log.Fatalf("Error saving token: %v\n", err)
+		}
+		fmt.Printf("Login successful. Token valid until %s.\n",
+			tok.Expiry.Format("2006-01-02 15:04:05"))
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Remove the cached OIDC token",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := os.Remove(oidcTokenFile())
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Error: %v\n", err)
+		}
+		fmt.Printf("Logged out.\n")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd, logoutCmd)
+}
+
+func oidcTokenFile() string {
+	if tf := viper.GetString("oidc.tokenfile"); tf != "" {
+		return tf
+	}
+	return DefaultOIDCTokenFile
+}
+
+// oidcAccessToken returns a valid cached access token (refreshing it if needed), or ""
+// if there is none, in which case the API key is used.
+func oidcAccessToken() string {
+	issuer := viper.GetString("oidc.issuer")
+	if issuer == "" {
+		return ""
+	}
+	tok, err := music.LoadOIDCToken(oidcTokenFile())
+	if err != nil {
+		return ""
+	}
+	if tok.Valid() {
+		return tok.AccessToken
+	}
+
+	p, err := music.OIDCDiscover(issuer)
+	if err == nil {
+		tok, err = music.OIDCRefresh(p, viper.GetString("oidc.clientid"), tok)
+	}
+	if err != nil {
+		log.Printf("Cached OIDC token expired and could not be refreshed (%v). Please login again.",
+			err)
+		return ""
+	}
+	if err = music.SaveOIDCToken(oidcTokenFile(), tok); err != nil {
+		log.Printf("Error saving refreshed token: %v", err)
+	}
+	return tok.AccessToken
+}
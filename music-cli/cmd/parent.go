@@ -0,0 +1,132 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+
+	"github.com/miekg/dns"
+	"github.com/ryanuber/columnize"
+	"github.com/spf13/cobra"
+)
+
+var parentprobekind string
+
+var parentCmd = &cobra.Command{
+	Use:   "parent",
+	Short: "Parent commands (observed capabilities of parents)",
+	Run: func(cmd *cobra.Command, args []string) {
+	},
+}
+
+var listParentsCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the profiles of probed parents and the probes",
+	Run: func(cmd *cobra.Command, args []string) {
+		pr := SendParentCmd(music.ParentPost{Command: "list"})
+		PrintParentResponse(pr)
+		PrintParentProfiles(pr)
+	},
+}
+
+var probeParentCmd = &cobra.Command{
+	Use:   "probe",
+	Short: "Observe the parent of a test zone to learn whether (and how fast) it acts on CDS or CSYNC",
+	Long: `Start a probe of the parent of the test zone given with -z. The signers of
+the test zone must publish CDS (--kind cds) or CSYNC (--kind csync) that
+the parent has not yet acted on. musicd then observes the parent until it
+has updated the DS (or NS) RRset or until the probe times out.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if zonename == "" {
+			log.Fatalf("Error: test zone not specified. Terminating.\n")
+		}
+		pr := SendParentCmd(music.ParentPost{
+			Command: "probe",
+			Zone:    dns.Fqdn(zonename),
+			Kind:    parentprobekind,
+		})
+		PrintParentResponse(pr)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(parentCmd)
+	parentCmd.AddCommand(listParentsCmd, probeParentCmd)
+
+	probeParentCmd.Flags().StringVarP(&parentprobekind, "kind", "", "cds",
+		"what to probe for (cds|csync)")
+}
+
+func SendParentCmd(data music.ParentPost) music.ParentResponse {
+	bytebuf := new(bytes.Buffer)
+	json.NewEncoder(bytebuf).Encode(data)
+
+	status, buf, err := api.Post("/parent", bytebuf.Bytes())
+	if err != nil {
+		log.Fatalf("SendParentCmd: Error from api.Post: %v", err)
+	}
+	if cliconf.Debug {
+		fmt.Printf("Status: %d\n", status)
+	}
+
+	var pr music.ParentResponse
+	err = json.Unmarshal(buf, &pr)
+	if err != nil {
+		log.Fatalf("SendParentCmd: Error from json.Unmarshal: %v", err)
+	}
+	return pr
+}
+
+func PrintParentResponse(pr music.ParentResponse) {
+	if pr.Error {
+		fmt.Printf("Error: %s\n", pr.ErrorMsg)
+	}
+	if pr.Msg != "" {
+		fmt.Printf("%s\n", pr.Msg)
+	}
+}
+
+func PrintParentProfiles(pr music.ParentResponse) {
+	capability := func(probed, supported bool, delay int) string {
+		switch {
+		case !probed:
+			return "unknown"
+		case !supported:
+			return "no"
+		}
+		return fmt.Sprintf("yes (%v)", time.Duration(delay)*time.Second)
+	}
+
+	if len(pr.Profiles) > 0 {
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Parent|Scans CDS|Accepts CSYNC|Updated")
+		}
+		for _, pp := range pr.Profiles {
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s", pp.Parent,
+				capability(pp.CdsProbed, pp.ScansCds, pp.CdsDelay),
+				capability(pp.CsyncProbed, pp.AcceptsCsync, pp.CsyncDelay),
+				pp.Updated.Format("2006-01-02 15:04:05")))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	}
+
+	if len(pr.Probes) > 0 {
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Parent|Probe|Test zone|Started|State|Result")
+		}
+		for _, p := range pr.Probes {
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%s|%s", p.Parent, p.Kind, p.TestZone,
+				p.Started.Format("2006-01-02 15:04:05"), p.State, p.Result))
+		}
+		fmt.Printf("\n%s\n", columnize.SimpleFormat(out))
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+
+	"github.com/ryanuber/columnize"
+	"github.com/spf13/cobra"
+)
+
+var policyname, policyalgs, policydigests string
+var policycdsttl, policycsyncttl, policynsttl uint32
+var policydsholddown, policynsholddown int
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Policy commands (TTLs, timers and algorithms used by the processes)",
+	Run: func(cmd *cobra.Command, args []string) {
+	},
+}
+
+var addPolicyCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a new policy or replace an existing one",
+	Run: func(cmd *cobra.Command, args []string) {
+		algs, err := music.StringToUint8List(policyalgs)
+		if err != nil {
+			log.Fatalf("Error: --algorithms: %v. Terminating.\n", err)
+		}
+		digests, err := music.StringToUint8List(policydigests)
+		if err != nil {
+			log.Fatalf("Error: --digests: %v. Terminating.\n", err)
+		}
+
+		pr := SendPolicyCmd(music.PolicyPost{
+			Command: "add",
+			Policy: music.Policy{
+				Name:        policyname,
+				CdsTTL:      policycdsttl,
+				CsyncTTL:    policycsyncttl,
+				NsTTL:       policynsttl,
+				DsHoldDown:  policydsholddown,
+				NsHoldDown:  policynsholddown,
+				Algorithms:  algs,
+				DigestTypes: digests,
+			},
+		})
+		PrintPolicyResponse(pr)
+	},
+}
+
+var deletePolicyCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a policy that is not in use",
+	Run: func(cmd *cobra.Command, args []string) {
+		pr := SendPolicyCmd(music.PolicyPost{
+			Command: "delete",
+			Policy:  music.Policy{Name: policyname},
+		})
+		PrintPolicyResponse(pr)
+	},
+}
+
+var assignPolicyCmd = &cobra.Command{
+	Use:   "assign",
+	Short: "Assign a policy to a zone (--zone) or a signer group (--group)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if zonename == "" && sgroupname == "" {
+			log.Fatalf("Error: zone or signer group must be specified. Terminating.\n")
+		}
+
+		pr := SendPolicyCmd(music.PolicyPost{
+			Command:     "assign",
+			Policy:      music.Policy{Name: policyname},
+			Zone:        zonename,
+			SignerGroup: sgroupname,
+		})
+		PrintPolicyResponse(pr)
+	},
+}
+
+var listPoliciesCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all policies",
+	Run: func(cmd *cobra.Command, args []string) {
+		pr := SendPolicyCmd(music.PolicyPost{
+			Command: "list",
+		})
+		PrintPolicyResponse(pr)
+		PrintPolicies(pr.Policies)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyCmd.AddCommand(addPolicyCmd, deletePolicyCmd, assignPolicyCmd, listPoliciesCmd)
+
+	policyCmd.PersistentFlags().StringVarP(&policyname, "name", "n", "", "name of policy")
+	addPolicyCmd.Flags().Uint32VarP(&policycdsttl, "cdsttl", "", 0,
+		"TTL of CDS/CDNSKEY (0 = same as DNSKEY)")
+	addPolicyCmd.Flags().Uint32VarP(&policycsyncttl, "csyncttl", "", 300, "TTL of CSYNC")
+	addPolicyCmd.Flags().Uint32VarP(&policynsttl, "nsttl", "", 0,
+		"TTL to normalize the apex NS RRset to before CSYNC (0 = leave as is)")
+	addPolicyCmd.Flags().IntVarP(&policydsholddown, "dsholddown", "", 0,
+		"seconds to wait for DS propagation (0 = 2 * largest TTL)")
+	addPolicyCmd.Flags().IntVarP(&policynsholddown, "nsholddown", "", 0,
+		"seconds to wait for NS propagation (0 = 2 * largest TTL)")
+	addPolicyCmd.Flags().StringVarP(&policyalgs, "algorithms", "", "",
+		"allowed DNSKEY algorithms, e.g. '8,13' (empty = all)")
+	addPolicyCmd.Flags().StringVarP(&policydigests, "digests", "", "",
+		"CDS digest types, e.g. '2,4' (default 2,4 for joining and 2 for leaving signers)")
+	addPolicyCmd.MarkFlagRequired("name")
+	deletePolicyCmd.MarkFlagRequired("name")
+	assignPolicyCmd.MarkFlagRequired("name")
+}
+
+func SendPolicyCmd(data music.PolicyPost) music.PolicyResponse {
+	bytebuf := new(bytes.Buffer)
+	json.NewEncoder(bytebuf).Encode(data)
+
+	status, buf, err := api.Post("/policy", bytebuf.Bytes())
+	if err != nil {
+		log.Fatalf("SendPolicyCmd: Error from api.Post: %v", err)
+	}
+	if cliconf.Debug {
+		fmt.Printf("Status: %d\n", status)
+	}
+
+	var pr music.PolicyResponse
+	err = json.Unmarshal(buf, &pr)
+	if err != nil {
+		log.Fatalf("SendPolicyCmd: Error from json.Unmarshal: %v", err)
+	}
+	return pr
+}
+
+func PrintPolicyResponse(pr music.PolicyResponse) {
+	if pr.Error {
+		fmt.Printf("Error: %s\n", pr.ErrorMsg)
+	}
+	if pr.Msg != "" {
+		fmt.Printf("%s\n", pr.Msg)
+	}
+}
+
+func PrintPolicies(pl map[string]music.Policy) {
+	if len(pl) == 0 {
+		return
+	}
+
+	var out []string
+	if cliconf.Verbose || showheaders {
+		out = append(out, "Policy|CDS TTL|CSYNC TTL|NS TTL|DS hold down|NS hold down|Algorithms|Digests")
+	}
+
+	names := []string{}
+	for name := range pl {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := pl[name]
+		algs := music.Uint8ListToString(p.Algorithms)
+		if algs == "" {
+			algs = "all"
+		}
+		digests := music.Uint8ListToString(p.DigestTypes)
+		if digests == "" {
+			digests = "default"
+		}
+		out = append(out, fmt.Sprintf("%s|%d|%d|%d|%d|%d|%s|%s", p.Name, p.CdsTTL, p.CsyncTTL, p.NsTTL,
+			p.DsHoldDown, p.NsHoldDown, algs, digests))
+	}
+	fmt.Printf("%s\n", columnize.SimpleFormat(out))
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 
 	"github.com/ryanuber/columnize"
 	"github.com/spf13/cobra"
@@ -15,7 +16,7 @@ import (
 	"github.com/DNSSEC-Provisioning/music/music"
 )
 
-var processname string
+var processname, pausereason string
 
 // processCmd represents the process command
 var processCmd = &cobra.Command{
@@ -70,9 +71,88 @@ var processGraphCmd = &cobra.Command{
 	},
 }
 
+var processPauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Pause the FSM engine, one process (--process) or all actions at one signer (--signer)",
+	Long: `Pause the FSM engine, e.g. during an incident. Without --process or --signer no zone
+takes any step, with --process only the zones running that process are paused and with
+--signer the zones using that signer are paused and no updates are sent to the signer.
+The API and the checks of the pre-conditions keep running.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		scope, process, signer := pauseScope()
+		pr, err := SendProcess(music.ProcessPost{
+			Command: "pause",
+			Scope:   scope,
+			Process: process,
+			Signer:  signer,
+			Reason:  pausereason,
+		})
+		PrintProcessMsg(pr, err)
+	},
+}
+
+var processResumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume the FSM engine, one process (--process) or one signer (--signer)",
+	Run: func(cmd *cobra.Command, args []string) {
+		scope, process, signer := pauseScope()
+		pr, err := SendProcess(music.ProcessPost{
+			Command: "resume",
+			Scope:   scope,
+			Process: process,
+			Signer:  signer,
+		})
+		PrintProcessMsg(pr, err)
+	},
+}
+
+var processPausedCmd = &cobra.Command{
+	Use:   "paused",
+	Short: "List what is paused",
+	Run: func(cmd *cobra.Command, args []string) {
+		pr, err := SendProcess(music.ProcessPost{
+			Command: "paused",
+		})
+		PrintProcessMsg(pr, err)
+		if len(pr.Pauses) == 0 {
+			fmt.Printf("Nothing is paused.\n")
+			return
+		}
+		out := []string{"Scope|Target|Since|Reason"}
+		for _, ep := range pr.Pauses {
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s", ep.Scope, ep.Target,
+				ep.Since.Format("2006-01-02 15:04:05"), ep.Reason))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+func pauseScope() (string, string, string) {
+	switch {
+	case signername != "":
+		return music.PauseSigner, "", signername
+	case processname != "":
+		return music.PauseProcess, processname, ""
+	}
+	return music.PauseEngine, "", ""
+}
+
+func PrintProcessMsg(pr music.ProcessResponse, err error) {
+	if err != nil {
+		fmt.Printf("Error from SendProcess: %v\n", err)
+	}
+	if pr.Error {
+		fmt.Printf("%s\n", pr.ErrorMsg)
+	}
+	if pr.Msg != "" {
+		fmt.Printf("%s\n", pr.Msg)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(processCmd)
-	processCmd.AddCommand(processListCmd, processCheckCmd, processGraphCmd)
+	processCmd.AddCommand(processListCmd, processCheckCmd, processGraphCmd, processPauseCmd,
+		processResumeCmd, processPausedCmd)
 
 	// Cobra supports Persistent Flags which will work for this command
 	// and all subcommands, e.g.:
@@ -83,6 +163,11 @@ func init() {
 	// processCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	processGraphCmd.Flags().StringVarP(&processname, "process", "p", "", "name of process")
 	processGraphCmd.MarkFlagRequired("process")
+	for _, c := range []*cobra.Command{processPauseCmd, processResumeCmd} {
+		c.Flags().StringVarP(&processname, "process", "p", "", "name of process")
+	}
+	processPauseCmd.Flags().StringVarP(&pausereason, "reason", "", "",
+		"reason for the pause, e.g. 'incident 1234'")
 }
 
 func SendProcess(data music.ProcessPost) (music.ProcessResponse, error) {
@@ -115,6 +200,15 @@ func PrintProcesses(pr music.ProcessResponse) {
 		} else {
 			fmt.Printf("%s\n%s\n\n", p.Name, p.Desc)
 		}
+		if len(p.Params) > 0 {
+			var pout []string
+			for name, param := range p.Params {
+				pout = append(pout, fmt.Sprintf("  %s|%s|%s|%s", name, param.Type,
+					param.Default, param.Desc))
+			}
+			sort.Strings(pout)
+			fmt.Printf("Parameters of %s:\n%s\n\n", p.Name, columnize.SimpleFormat(pout))
+		}
 	}
 	if len(out) > 0 {
 		fmt.Printf("%s\n", columnize.SimpleFormat(out))
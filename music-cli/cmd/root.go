@@ -6,6 +6,7 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/DNSSEC-Provisioning/music/music"
 
@@ -16,6 +17,7 @@ import (
 )
 
 var cfgFile, zonename, signername string
+var identity string // two-person rule
 var showheaders bool
 
 var tokvip *viper.Viper
@@ -48,6 +50,8 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&zonename, "zone", "z", "", "name of zone")
 	rootCmd.PersistentFlags().StringVarP(&signername, "signer", "s", "", "name of signer")
 	rootCmd.PersistentFlags().StringVarP(&sgroupname, "group", "g", "", "name of signer group")
+	rootCmd.PersistentFlags().StringVarP(&identity, "identity", "", os.Getenv("USER"),
+		"identity for the two-person rule (ignored when logged in via OIDC)")
 
 }
 
@@ -99,6 +103,12 @@ func initApi() {
 	authmethod := viper.GetString("musicd.authmethod")
 	rootcafile := viper.GetString("musicd.rootCApem")
 
+	// A token from "music-cli login" takes precedence over the API key
+	if token := oidcAccessToken(); token != "" {
+		apikey = token
+		authmethod = "Bearer"
+	}
+
 	api = music.NewClient("musicd", baseurl, apikey, authmethod, rootcafile,
 		cliconf.Verbose, cliconf.Debug)
 }
@@ -8,8 +8,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/miekg/dns"
+	"github.com/ryanuber/columnize"
 	"github.com/spf13/cobra"
 )
 
@@ -20,14 +26,38 @@ var showCmd = &cobra.Command{
 
 var showUpdatersCmd = &cobra.Command{
 	Use:   "updaters",
-	Short: "List the updaters known to musicd",
+	Short: "List the updaters known to musicd and their capabilities",
 	Run: func(cmd *cobra.Command, args []string) {
 		sr := SendShowCommand(music.ShowPost{Command: "updaters"})
+		var names []string
 		for u, v := range sr.Updaters {
 			if v {
-				fmt.Printf("%s\n", u)
+				names = append(names, u)
 			}
 		}
+		sort.Strings(names)
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Updater|CDS|CSYNC|Batch|Max RRs/update|Rate limit|RR types")
+		}
+		yesno := map[bool]string{true: "yes", false: "no"}
+		for _, u := range names {
+			c := sr.Capabilities[u]
+			maxrrs, rate, rrtypes := "-", "-", "any"
+			if c.MaxRRsPerUpdate > 0 {
+				maxrrs = fmt.Sprintf("%d", c.MaxRRsPerUpdate)
+			}
+			if c.RateLimit > 0 {
+				rate = fmt.Sprintf("%g/s", c.RateLimit)
+			}
+			if len(c.RRtypes) > 0 {
+				sort.Strings(c.RRtypes)
+				rrtypes = strings.Join(c.RRtypes, " ")
+			}
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", u, yesno[c.CDS], yesno[c.CSYNC],
+				yesno[c.Batch], maxrrs, rate, rrtypes))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
 	},
 }
 
@@ -42,9 +72,412 @@ var showApiCmd = &cobra.Command{
 	},
 }
 
+var showBreakersCmd = &cobra.Command{
+	Use:   "breakers",
+	Short: "Show the error rate and circuit breaker state of each signer",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendShowCommand(music.ShowPost{Command: "breakers"})
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Signer|Ops|Failures|Error rate|Breaker|Latest error")
+		}
+		for _, sh := range sr.SignerHealth {
+			breaker := "closed"
+			if sh.Open {
+				breaker = "open until " + sh.OpenUntil.Format("2006-01-02 15:04:05")
+			}
+			out = append(out, fmt.Sprintf("%s|%d|%d|%.0f%%|%s|%s", sh.Signer, sh.Ops,
+				sh.Failures, 100*sh.ErrorRate, breaker, sh.LastError))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+var showBackpressureCmd = &cobra.Command{
+	Use:   "backpressure",
+	Short: "Show the aggregate error rate of signers and parents and whether the engine is slowed down",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendShowCommand(music.ShowPost{Command: "backpressure"})
+		fmt.Printf("%s\n", sr.Message)
+		bs := sr.Backpressure
+		if bs == nil {
+			return
+		}
+		since := ""
+		if !bs.Since.IsZero() {
+			since = " since " + bs.Since.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("Level: %s%s\n", bs.Level, since)
+		fmt.Printf("Operations: %d, failures: %d (%.0f%%)\n", bs.Ops, bs.Failures, 100*bs.ErrorRate)
+		if bs.LastError != "" {
+			fmt.Printf("Latest error: %s\n", bs.LastError)
+		}
+	},
+}
+
+var showDryRunCmd = &cobra.Command{
+	Use:   "dryrun",
+	Short: "Show the changes that were not made to the signers due to dry-run mode",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := ""
+		if zonename != "" {
+			zone = dns.Fqdn(zonename)
+		}
+		sr := SendShowCommand(music.ShowPost{Command: "dryrun", Zone: zone, Clear: dryrunclear})
+		for _, ch := range sr.DryRunChanges {
+			fmt.Printf("%s signer %s zone %s: %s\n", ch.Time.Local().Format("2006-01-02 15:04:05"),
+				ch.Signer, ch.Zone, ch.Op)
+			for _, rr := range ch.RRs {
+				fmt.Printf("\t%s\n", rr)
+			}
+		}
+		if dryrunclear {
+			fmt.Printf("%s\n", sr.Message)
+		}
+	},
+}
+
+var showObserverCmd = &cobra.Command{
+	Use:   "observer",
+	Short: "Show what musicd would do and the drift between signers, per zone (observer mode)",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := ""
+		if zonename != "" {
+			zone = dns.Fqdn(zonename)
+		}
+		sr := SendShowCommand(music.ShowPost{Command: "observer", Zone: zone})
+		if len(sr.Observations) == 0 {
+			fmt.Printf("%s\n", sr.Message)
+			return
+		}
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Zone|Process|State|Next|Would move|Stop reason|Drift")
+		}
+		for _, zo := range sr.Observations {
+			process := zo.Process
+			if process == "" {
+				process = "---"
+			}
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%v|%s|%s", zo.Zone, process, zo.State,
+				zo.NextState, zo.WouldMove, zo.StopReason, strings.Join(zo.Drift, "; ")))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+var showValidationCmd = &cobra.Command{
+	Use:   "validation",
+	Short: "Show whether the lab zones validate through the local validating resolver (lab mode)",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := ""
+		if zonename != "" {
+			zone = dns.Fqdn(zonename)
+		}
+		sr := SendShowCommand(music.ShowPost{Command: "validation", Zone: zone})
+		if len(sr.Validations) == 0 {
+			fmt.Printf("%s\n", sr.Message)
+			return
+		}
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Zone|Process|State|Status|Since|Detail")
+		}
+		for _, vr := range sr.Validations {
+			process := vr.Process
+			if process == "" {
+				process = "---"
+			}
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%s|%s", vr.Zone, process, vr.State,
+				vr.Status, vr.Since.Format("2006-01-02 15:04:05"), vr.Detail))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+var showpropperzone bool
+
+var showPropagationCmd = &cobra.Command{
+	Use:   "propagation",
+	Short: "Show how long it takes until updates are visible on the public nameservers of each signer",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := ""
+		if zonename != "" {
+			zone = dns.Fqdn(zonename)
+		}
+		sr := SendShowCommand(music.ShowPost{Command: "propagation", Zone: zone,
+			PerZone: showpropperzone})
+		if len(sr.Propagation) == 0 {
+			fmt.Printf("%s\n", sr.Message)
+			return
+		}
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Signer|Zone|Updates|Min|Median|90%|Max|Timeouts")
+		}
+		for _, ps := range sr.Propagation {
+			out = append(out, fmt.Sprintf("%s|%s|%d|%.0fs|%.0fs|%.0fs|%.0fs|%d", ps.Signer,
+				ps.Zone, ps.Count, ps.Min, ps.Median, ps.P90, ps.Max, ps.Timeouts))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+var showkeysalgorithm string
+var showkeysall, showkeysscan bool
+
+var showKeysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "List the DNSKEYs published per zone and signer, e.g. to find the zones with an old algorithm",
+	Run: func(cmd *cobra.Command, args []string) {
+		alg := 0
+		if showkeysalgorithm != "" {
+			alg = parseAlgorithm(showkeysalgorithm)
+		}
+		zone := ""
+		if zonename != "" {
+			zone = dns.Fqdn(zonename)
+		}
+		sr := SendShowCommand(music.ShowPost{Command: "keys", Zone: zone, Signer: signername,
+			Algorithm: alg, All: showkeysall, Scan: showkeysscan})
+		if len(sr.Keys) == 0 {
+			fmt.Printf("%s\n", sr.Message)
+			return
+		}
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Zone|Signer|Key tag|Algorithm|Flags|Role|First seen|Last seen|Published")
+		}
+		for _, k := range sr.Keys {
+			out = append(out, fmt.Sprintf("%s|%s|%d|%s|%d|%s|%s|%s|%v", k.Zone, k.Signer, k.KeyTag,
+				dns.AlgorithmToString[k.Algorithm], k.Flags, k.Role(),
+				k.FirstSeen.Format("2006-01-02 15:04:05"), k.LastSeen.Format("2006-01-02 15:04:05"),
+				k.Current))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+// parseAlgorithm accepts a DNSSEC algorithm as number or mnemonic (e.g. 5 or RSASHA1).
+func parseAlgorithm(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	if alg, ok := dns.StringToAlgorithm[strings.ToUpper(s)]; ok {
+		return int(alg)
+	}
+	log.Fatalf("Error: unknown DNSSEC algorithm: %s", s)
+	return 0
+}
+
+var showstateprobe bool
+var showstatefile string
+
+var showStateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Export the state of all zones and signers as JSON (to stdout or --file)",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendShowCommand(music.ShowPost{Command: "state", Probe: showstateprobe})
+		if sr.State == nil {
+			log.Fatalf("Error: %s", sr.Message)
+		}
+		if showstatefile != "" {
+			err := music.WriteStateExport(showstatefile, sr.State)
+			if err != nil {
+				log.Fatalf("Error writing %s: %v", showstatefile, err)
+			}
+			return
+		}
+		buf, _ := json.MarshalIndent(sr.State, "", "  ")
+		fmt.Printf("%s\n", buf)
+	},
+}
+
+var showTasksCmd = &cobra.Command{
+	Use:   "tasks",
+	Short: "Show the tasks scheduled for the zones (end of wait states, delays, ...)",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := ""
+		if zonename != "" {
+			zone = dns.Fqdn(zonename)
+		}
+		sr := SendShowCommand(music.ShowPost{Command: "tasks", Zone: zone})
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Zone|Task|Time|Done|Param")
+		}
+		for _, t := range sr.Tasks {
+			out = append(out, fmt.Sprintf("%s|%s|%s|%v|%s", t.Zone, t.Task,
+				t.RunAt.Local().Format("2006-01-02 15:04:05"), t.Done, t.Param))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+var showOpsCmd = &cobra.Command{
+	Use:   "ops",
+	Short: "Show the signer operations (fetches and updates) that have not completed yet",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendShowCommand(music.ShowPost{Command: "ops"})
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "ID|Queue|Command|Signer|Zone|Owner|Type|Priority|Throttle|State|Age|Attempts")
+		}
+		for _, op := range sr.Ops {
+			state := op.State
+			if op.State == "held" {
+				state = fmt.Sprintf("held (%ds)", op.Hold)
+			}
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%v|%d", op.ID, op.Queue,
+				op.Command, op.Signer, op.Zone, op.Owner, op.RRtype, op.Priority, op.Throttle, state,
+				time.Since(op.Queued).Round(time.Second), op.Attempts))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+var showQueuesCmd = &cobra.Command{
+	Use:   "queues",
+	Short: "Show the depth of the signer op queues and how long ops wait in them",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendShowCommand(music.ShowPost{Command: "queues"})
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Queue|Length|Interactive|Max|Spilled|Oldest|Dequeued|Avg wait|Max wait|Rejected|Expired")
+		}
+		for _, q := range sr.Queues {
+			var avg float64
+			if q.Dequeued > 0 {
+				avg = q.WaitTotal / float64(q.Dequeued)
+			}
+			out = append(out, fmt.Sprintf("%s|%d|%d|%d|%d|%.1fs|%d|%.1fs|%.1fs|%d|%d", q.Name,
+				q.Length, q.Interactive, q.MaxLen, q.Spilled, q.Oldest, q.Dequeued, avg, q.MaxWait, q.Rejected,
+				q.Expired))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+var showPreconditionsCmd = &cobra.Command{
+	Use:   "preconditions",
+	Short: "Show the failed pre-conditions that are not evaluated again until the data observed expires",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendShowCommand(music.ShowPost{Command: "preconditions"})
+		fmt.Printf("%s\n", sr.Message)
+		out := []string{"Zone|Process|State|Next|Queries|TTL|Recheck in|Stop reason"}
+		for _, c := range sr.Preconditions {
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%d|%ds|%v|%s", c.Zone, c.Process,
+				c.State, c.Next, c.Queries, c.TTL, time.Until(c.Recheck).Round(time.Second), c.Reason))
+		}
+		if len(sr.Preconditions) > 0 {
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		}
+	},
+}
+
+var showShardsCmd = &cobra.Command{
+	Use:   "shards",
+	Short: "Show the musicd instances sharing the DB and the number of zones each one runs",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendShowCommand(music.ShowPost{Command: "shards"})
+		fmt.Printf("%s\n", sr.Message)
+		out := []string{"Instance|Zones|Last heartbeat|"}
+		for _, m := range sr.Shards {
+			self := ""
+			if m.Self {
+				self = "(this instance)"
+			}
+			out = append(out, fmt.Sprintf("%s|%d|%s|%s", m.Name, m.Zones,
+				m.Heartbeat.Format("2006-01-02 15:04:05"), self))
+		}
+		if len(sr.Shards) > 0 {
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		}
+	},
+}
+
+var showreqbackend, showreqsince string
+var dryrunclear bool
+
+var showPendingOpsCmd = &cobra.Command{
+	Use:   "pending-ops",
+	Short: "Show the operations waiting for a second person under the two-person rule",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendShowCommand(music.ShowPost{Command: "pending-ops"})
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "ID|Operation|Target|Requested by|Requested|Expires")
+		}
+		for _, po := range sr.PendingOps {
+			out = append(out, fmt.Sprintf("%d|%s|%s|%s|%s|%s", po.ID, po.Op, po.Target, po.Requester,
+				po.Requested.Local().Format("2006-01-02 15:04:05"),
+				po.Expires.Local().Format("2006-01-02 15:04:05")))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+var showRequestsCmd = &cobra.Command{
+	Use:   "requests",
+	Short: "Show the latest requests musicd sent to the signer backends (-v: with the bodies)",
+	Run: func(cmd *cobra.Command, args []string) {
+		sp := music.ShowPost{Command: "requests", Backend: showreqbackend}
+		if showreqsince != "" {
+			since, err := time.ParseInLocation("2006-01-02 15:04:05", showreqsince, time.Local)
+			if err != nil {
+				log.Fatalf("Error: --since must be of the form \"2006-01-02 15:04:05\": %v", err)
+			}
+			sp.Since = since
+		}
+		sr := SendShowCommand(sp)
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Time|Backend|Method|Target|Status|RTT (ms)|Error")
+		}
+		for _, br := range sr.Requests {
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%s|%.1f|%s",
+				br.Time.Local().Format("2006-01-02 15:04:05"), br.Backend, br.Method, br.Target,
+				br.Status, br.RttMs, br.Error))
+			if cliconf.Verbose {
+				if br.Request != "" {
+					out = append(out, fmt.Sprintf("|  request:|%s", strings.ReplaceAll(br.Request, "\n", " ")))
+				}
+				if br.Response != "" {
+					out = append(out, fmt.Sprintf("|  response:|%s", strings.ReplaceAll(br.Response, "\n", " ")))
+				}
+			}
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(showCmd)
-	showCmd.AddCommand(showApiCmd, showUpdatersCmd)
+	showCmd.AddCommand(showApiCmd, showUpdatersCmd, showStateCmd, showBreakersCmd,
+		showBackpressureCmd, showDryRunCmd, showPropagationCmd, showObserverCmd, showKeysCmd,
+		showValidationCmd, showRequestsCmd, showTasksCmd, showOpsCmd,
+		showQueuesCmd, showShardsCmd, showPendingOpsCmd, showPreconditionsCmd)
+
+	showDryRunCmd.Flags().BoolVarP(&dryrunclear, "clear", "", false,
+		"forget the listed changes once reviewed")
+	showRequestsCmd.Flags().StringVarP(&showreqbackend, "backend", "b", "",
+		"only requests to this backend (API name or host, or DNS server address)")
+	showRequestsCmd.Flags().StringVarP(&showreqsince, "since", "", "",
+		"only requests made at or after this time (\"2006-01-02 15:04:05\", local time)")
+
+	showKeysCmd.Flags().StringVarP(&showkeysalgorithm, "algorithm", "a", "",
+		"only keys of this algorithm (number or mnemonic)")
+	showKeysCmd.Flags().BoolVarP(&showkeysall, "all", "", false,
+		"include keys that are no longer published")
+	showKeysCmd.Flags().BoolVarP(&showkeysscan, "scan", "", false,
+		"fetch the DNSKEY RRsets of all zones from all signers first")
+
+	showPropagationCmd.Flags().BoolVarP(&showpropperzone, "perzone", "", false,
+		"statistics per signer and zone")
+
+	showStateCmd.Flags().BoolVarP(&showstateprobe, "probe", "", false,
+		"check that each signer answers for its zones")
+	showStateCmd.Flags().StringVarP(&showstatefile, "file", "f", "", "write export to file")
 }
 
 func SendShowCommand(data music.ShowPost) music.ShowResponse {
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"strings"
 
@@ -16,8 +17,24 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var signermethod, signerauth, signeraddress, signerport string
-var signernotcp, signernotsig bool
+var signermethod, signerauth, signeraddress, signerport, signernewauth string
+var signernotcp, signernotsig, signernotify bool
+var signerview, signertestzone string
+var signermaxzones int
+var signerproxy, signersshkey string
+var signerincludepath, signerincludereload string
+var signertoken string
+var signeranycastoff bool
+var signertlsoff bool
+var signertlscafile, signertlsservername string
+var signertransport, signerdohurl string
+var signersig0keyfile string
+var signersig0off bool
+var signernotifytargets []string
+var signernotifyoff bool
+var signeraddresses []string
+var signertemplate string
+var signermatchmethod, signermatchaddress string
 
 // signerCmd represents the signer command
 var signerCmd = &cobra.Command{
@@ -31,17 +48,31 @@ var addSignerCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Add a new signer to MuSiC",
 	Run: func(cmd *cobra.Command, args []string) {
-		if signermethod == "" {
-			log.Fatalf("Error: signer method unspecified. Terminating.\n")
-		}
+		method, port := signermethod, signerport
+		if signertemplate != "" {
+			tmpl, err := music.GetSignerTemplate(signertemplate)
+			if err != nil {
+				log.Fatalf("Error: %v. Terminating.\n", err)
+			}
+			if method == "" {
+				method = tmpl.Method
+			}
+			if !cmd.Flags().Changed("port") {
+				port = "" // from the template
+			}
+		} else {
+			if signermethod == "" {
+				log.Fatalf("Error: signer method unspecified. Terminating.\n")
+			}
 
-		if signeraddress == "" {
-			log.Fatalf("Error: signer address unspecified. Terminating.\n")
+			if signeraddress == "" {
+				log.Fatalf("Error: signer address unspecified. Terminating.\n")
+			}
 		}
 
 		var authdata music.AuthData
 		if signerauth != "" {
-			authdata = music.ParseSignerAuth(signerauth, signermethod)
+			authdata = music.ParseSignerAuth(signerauth, method)
 		}
 
 		//		if signerport == "" {
@@ -51,15 +82,16 @@ var addSignerCmd = &cobra.Command{
 			Command: "add",
 			Signer: music.Signer{
 				Name:   signername,
-				Method: strings.ToLower(signermethod),
+				Method: strings.ToLower(method),
 				// Auth:    signerauth, // Issue #28: music.AuthDataTmp(signerauth),
 				Auth:    authdata,
 				Address: signeraddress,
-				Port:    signerport, // set to 53 if not specified
+				Port:    port, // set to 53 if not specified
 				UseTcp:  !signernotcp,
 				UseTSIG: !signernotsig,
 			},
 			SignerGroup: sgroupname, // may be unspecified
+			Template:    signertemplate,
 		})
 		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
 	},
@@ -139,6 +171,7 @@ var leaveGroupCmd = &cobra.Command{
 				Name:        signername,
 				SignerGroup: sgroupname,
 			},
+			Identity: identity,
 		})
 		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
 	},
@@ -170,6 +203,36 @@ var listSignersCmd = &cobra.Command{
 	},
 }
 
+var templatesSignerCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "List the signer templates that can be used with 'signer add --template'",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "templates",
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Template|Method|Port|Auth|Max zones|Description")
+		}
+		for _, t := range sr.Templates {
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%d|%s", t.Name, t.Method, t.Port,
+				t.AuthMethod, t.MaxZones, t.Description))
+		}
+		if len(out) > 0 {
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		}
+		if cliconf.Verbose {
+			for _, t := range sr.Templates {
+				for _, q := range t.Quirks {
+					fmt.Printf("%s: %s\n", t.Name, q)
+				}
+			}
+		}
+	},
+}
+
 var loginSignerCmd = &cobra.Command{
 	Use:   "login",
 	Short: "Request that musicd login to the specified signer (not relevant for method=ddns)",
@@ -198,15 +261,423 @@ var logoutSignerCmd = &cobra.Command{
 	},
 }
 
+var rotateTsigSignerCmd = &cobra.Command{
+	Use:   "rotate-tsig",
+	Short: "Rotate the TSIG key used for the specified DDNS signer",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		if signernewauth == "" {
+			log.Fatalf("Error: new TSIG key not specified. Terminating.\n")
+		}
+
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "rotate-tsig",
+			Signer: music.Signer{
+				Name: signername,
+			},
+			NewAuth: music.ParseSignerAuth(signernewauth, "ddns"),
+			Notify:  signernotify,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var retireTsigSignerCmd = &cobra.Command{
+	Use:   "retire-tsig",
+	Short: "Confirm that the old TSIG key of a rotation has been retired by the signer operator",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "retire-tsig",
+			Signer: music.Signer{
+				Name: signername,
+			},
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var tsigStatusSignerCmd = &cobra.Command{
+	Use:   "tsig-status",
+	Short: "Show the state of the latest TSIG rotation for the specified signer",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "tsig-status",
+			Signer: music.Signer{
+				Name: signername,
+			},
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var updateAuthSignerCmd = &cobra.Command{
+	Use:   "update-auth",
+	Short: "Replace the TSIG key of all DDNS signers matching a method and/or address pattern",
+	Long: `Replace the TSIG key of all DDNS signers matching a method and/or address pattern.
+The new key is verified against every matching signer first. If it does not work
+for all of them, no signer is updated.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if signermatchmethod == "" && signermatchaddress == "" {
+			log.Fatalf("Error: neither --match-method nor --match-address specified. Terminating.\n")
+		}
+		if signernewauth == "" {
+			log.Fatalf("Error: new TSIG key not specified. Terminating.\n")
+		}
+
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "update-auth",
+			Match: music.CredentialMatch{
+				Method:  signermatchmethod,
+				Address: signermatchaddress,
+			},
+			NewAuth: music.ParseSignerAuth(signernewauth, "ddns"),
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var addViewSignerCmd = &cobra.Command{
+	Use:   "add-view",
+	Short: "Add (or replace) a split-horizon view of a DDNS signer, with its own address and TSIG key",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+
+		view := music.SignerView{
+			Name:    signerview,
+			Address: signeraddress,
+		}
+		if cmd.Flags().Changed("port") {
+			view.Port = signerport
+		}
+		if signerauth != "" {
+			view.Auth = music.ParseSignerAuth(signerauth, "ddns")
+		}
+
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "add-view",
+			Signer:  music.Signer{Name: signername},
+			View:    view,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var deleteViewSignerCmd = &cobra.Command{
+	Use:   "delete-view",
+	Short: "Delete a split-horizon view of a DDNS signer",
+	Run: func(cmd *cobra.Command, args []string) {
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "delete-view",
+			Signer:  music.Signer{Name: signername},
+			View:    music.SignerView{Name: signerview},
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var verifySignerCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify that a signer works (fetch SOA/DNSKEY, no-op update) using a test zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command:  "verify",
+			Signer:   music.Signer{Name: signername},
+			TestZone: signertestzone,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+		if sr.Verification != nil {
+			for _, line := range sr.Verification.Report {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+	},
+}
+
+var setLimitSignerCmd = &cobra.Command{
+	Use:   "set-limit",
+	Short: "Set the max number of zones that may execute actions against the signer at the same time",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command:  "set-limit",
+			Signer:   music.Signer{Name: signername},
+			MaxZones: signermaxzones,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var setProxySignerCmd = &cobra.Command{
+	Use:   "set-proxy",
+	Short: "Reach the signer through a SOCKS5 proxy or SSH jump host (no --proxy = direct)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "set-proxy",
+			Signer:  music.Signer{Name: signername},
+			Proxy:   signerproxy,
+			SSHKey:  signersshkey,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var setIncludeSignerCmd = &cobra.Command{
+	Use:   "set-include",
+	Short: "Set the include file (and reload command) of a file-include signer",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "set-include",
+			Signer:  music.Signer{Name: signername},
+			Include: music.FileInclude{Path: signerincludepath, Reload: signerincludereload},
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var setTokenSignerCmd = &cobra.Command{
+	Use:   "set-token",
+	Short: "Set the API token the signer uses for one zone (no --token = use the signer token)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		if zonename == "" {
+			log.Fatalf("Error: zone not specified. Terminating.\n")
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "set-token",
+			Signer:  music.Signer{Name: signername},
+			Zone:    zonename,
+			Token:   signertoken,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var setAnycastSignerCmd = &cobra.Command{
+	Use:   "set-anycast",
+	Short: "Verify all fetches from the signer from every configured vantage point (--off = stop)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "set-anycast",
+			Signer:  music.Signer{Name: signername},
+			Anycast: !signeranycastoff,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var setTLSSignerCmd = &cobra.Command{
+	Use:   "set-tls",
+	Short: "Access a DDNS signer over TLS (DoT) rather than plain TCP (--off = stop)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "set-tls",
+			Signer:  music.Signer{Name: signername},
+			TLS: music.SignerTLS{
+				Active:     !signertlsoff,
+				CAFile:     signertlscafile,
+				ServerName: signertlsservername,
+			},
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var setTransportSignerCmd = &cobra.Command{
+	Use:   "set-transport",
+	Short: "Select the transport to a DDNS signer: udp, tcp, tls or https (DoH, fetches only)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command:   "set-transport",
+			Signer:    music.Signer{Name: signername},
+			Transport: signertransport,
+			DoHURL:    signerdohurl,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var setSIG0SignerCmd = &cobra.Command{
+	Use:   "set-sig0",
+	Short: "Authenticate to a DDNS signer with SIG(0) rather than TSIG (--off = back to TSIG)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		st := music.SignerSIG0{Active: !signersig0off}
+		if st.Active {
+			if signersig0keyfile == "" {
+				log.Fatalf("Error: SIG(0) key file not specified (--keyfile). Terminating.\n")
+			}
+			st.PublicKey, st.PrivateKey = readSIG0KeyFiles(signersig0keyfile)
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command: "set-sig0",
+			Signer:  music.Signer{Name: signername},
+			SIG0:    st,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var setNotifySignerCmd = &cobra.Command{
+	Use:   "set-notify",
+	Short: "Send NOTIFYs to secondaries after updates to a signer (--off = stop)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		if !signernotifyoff && len(signernotifytargets) == 0 {
+			log.Fatalf("Error: no notify targets specified (--targets). Terminating.\n")
+		}
+		var targets []string
+		if !signernotifyoff {
+			targets = signernotifytargets
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command:       "set-notify",
+			Signer:        music.Signer{Name: signername},
+			NotifyTargets: targets,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+var setAddressesSignerCmd = &cobra.Command{
+	Use:   "set-addresses",
+	Short: "Set the addresses of a signer, in order of preference (the first one is used unless it is down)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if signername == "" {
+			log.Fatalf("Error: signer not specified. Terminating.\n")
+		}
+		sr := SendSignerCmd(music.SignerPost{
+			Command:   "set-addresses",
+			Signer:    music.Signer{Name: signername},
+			Addresses: signeraddresses,
+		})
+		PrintSignerResponse(sr.Error, sr.ErrorMsg, sr.Msg)
+	},
+}
+
+// readSIG0KeyFiles reads a key pair generated by "dnssec-keygen -T KEY", given the name
+// of either the .key or the .private file.
+func readSIG0KeyFiles(keyfile string) (string, string) {
+	base := strings.TrimSuffix(strings.TrimSuffix(keyfile, ".key"), ".private")
+	pub, err := ioutil.ReadFile(base + ".key")
+	if err != nil {
+		log.Fatalf("Error reading SIG(0) public key: %v", err)
+	}
+	priv, err := ioutil.ReadFile(base + ".private")
+	if err != nil {
+		log.Fatalf("Error reading SIG(0) private key: %v", err)
+	}
+	// the .key file starts with comments
+	var rr []string
+	for _, line := range strings.Split(string(pub), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, ";") {
+			rr = append(rr, line)
+		}
+	}
+	return strings.Join(rr, " "), string(priv)
+}
+
 func init() {
 	rootCmd.AddCommand(signerCmd)
 	signerCmd.AddCommand(addSignerCmd, updateSignerCmd, deleteSignerCmd, listSignersCmd,
-		joinGroupCmd, leaveGroupCmd, loginSignerCmd, logoutSignerCmd)
+		joinGroupCmd, leaveGroupCmd, loginSignerCmd, logoutSignerCmd,
+		rotateTsigSignerCmd, retireTsigSignerCmd, tsigStatusSignerCmd,
+		updateAuthSignerCmd, addViewSignerCmd,
+		deleteViewSignerCmd, verifySignerCmd, setLimitSignerCmd, setProxySignerCmd,
+		setIncludeSignerCmd, setTokenSignerCmd, setAnycastSignerCmd, setTLSSignerCmd,
+		setTransportSignerCmd, setSIG0SignerCmd, setNotifySignerCmd,
+		setAddressesSignerCmd, templatesSignerCmd)
+
+	addSignerCmd.Flags().StringVarP(&signertemplate, "template", "", "",
+		"signer template (bind|knot|powerdns|desec|route53), see 'signer templates'")
+	rotateTsigSignerCmd.Flags().StringVarP(&signernewauth, "newauth", "", "",
+		"new TSIG key: algname:key.name:secret")
+	rotateTsigSignerCmd.Flags().BoolVarP(&signernotify, "notify", "", false,
+		"keep the old key until the signer operator has retired it, notify the zone contacts")
+	updateAuthSignerCmd.Flags().StringVarP(&signernewauth, "newauth", "", "",
+		"new TSIG key: algname:key.name:secret")
+	updateAuthSignerCmd.Flags().StringVarP(&signermatchmethod, "match-method", "", "",
+		"update the signers with this method (ddns|rlddns)")
+	updateAuthSignerCmd.Flags().StringVarP(&signermatchaddress, "match-address", "", "",
+		"update the signers with an address matching this pattern, e.g. \"192.0.2.*\"")
+
+	addViewSignerCmd.Flags().StringVarP(&signerview, "view", "", "", "name of view")
+	addViewSignerCmd.MarkFlagRequired("view")
+	deleteViewSignerCmd.Flags().StringVarP(&signerview, "view", "", "", "name of view")
+	deleteViewSignerCmd.MarkFlagRequired("view")
+	setLimitSignerCmd.Flags().IntVarP(&signermaxzones, "maxzones", "", 0,
+		"max number of concurrent zones (0 = default from musicd.yaml)")
+	setProxySignerCmd.Flags().StringVarP(&signerproxy, "proxy", "", "",
+		"socks5://[user:password@]host:port or ssh://user@host[:port]")
+	setProxySignerCmd.Flags().StringVarP(&signersshkey, "sshkey", "", "",
+		"private key file (on the musicd host) for an ssh jump host")
+	setIncludeSignerCmd.Flags().StringVarP(&signerincludepath, "path", "", "",
+		"include file on the musicd host, {zone} is replaced by the zone name")
+	setIncludeSignerCmd.MarkFlagRequired("path")
+	setIncludeSignerCmd.Flags().StringVarP(&signerincludereload, "reload", "", "",
+		"command to run after the file has been written, e.g. \"nsd-control reload {zone}\"")
+	setTokenSignerCmd.Flags().StringVarP(&signertoken, "token", "", "",
+		"API token scoped to the zone")
+	setAnycastSignerCmd.Flags().BoolVarP(&signeranycastoff, "off", "", false,
+		"stop verifying the signer from multiple vantage points")
+	setTLSSignerCmd.Flags().BoolVarP(&signertlsoff, "off", "", false,
+		"stop using TLS, go back to plain TCP")
+	setTLSSignerCmd.Flags().StringVarP(&signertlscafile, "cafile", "", "",
+		"CA certificate(s) of the signer (PEM file on the musicd host), default: system roots")
+	setTLSSignerCmd.Flags().StringVarP(&signertlsservername, "servername", "", "",
+		"name in the server certificate, default: the signer address")
+	setTransportSignerCmd.Flags().StringVarP(&signertransport, "transport", "", "",
+		"udp | tcp | tls | https")
+	setTransportSignerCmd.MarkFlagRequired("transport")
+	setTransportSignerCmd.Flags().StringVarP(&signerdohurl, "url", "", "",
+		"DoH URL for transport https, e.g. https://signer.example.net/dns-query")
+	setSIG0SignerCmd.Flags().StringVarP(&signersig0keyfile, "keyfile", "", "",
+		"key pair from 'dnssec-keygen -T KEY', e.g. Kmusic.+013+12345.key")
+	setSIG0SignerCmd.Flags().BoolVarP(&signersig0off, "off", "", false,
+		"stop using SIG(0), go back to TSIG")
+	setNotifySignerCmd.Flags().StringSliceVarP(&signernotifytargets, "targets", "", nil,
+		"host[:port] to notify, or \"secondaries\" for the name servers of the zone (comma separated)")
+	setNotifySignerCmd.Flags().BoolVarP(&signernotifyoff, "off", "", false,
+		"stop sending NOTIFYs")
+	setAddressesSignerCmd.Flags().StringSliceVarP(&signeraddresses, "addresses", "", nil,
+		"IPv4 and IPv6 addresses (or names) of the signer, comma separated")
+	setAddressesSignerCmd.MarkFlagRequired("addresses")
+	verifySignerCmd.Flags().StringVarP(&signertestzone, "testzone", "", "",
+		"zone to verify against (default signers.verification.testzone in musicd.yaml)")
 
 	signerCmd.PersistentFlags().StringVarP(&signermethod, "method", "m", "",
-		"update method (ddns|rlddns|desec-api|rldesec-api...)")
+		"update method (ddns|rlddns|desec-api|rldesec-api|file-include...)")
 	signerCmd.PersistentFlags().StringVarP(&signerauth, "auth", "", "",
-		fmt.Sprintf("authdata for signer:\nDDNS: algname:key.name:secret\ndeSEC: ?"))
+		fmt.Sprintf("authdata for signer:\nDDNS: algname:key.name:secret (algname: hmac-sha256 | hmac-sha512)\ndeSEC: ?"))
 	signerCmd.PersistentFlags().StringVarP(&signeraddress, "address", "", "",
 		"IP address of signer")
 	signerCmd.PersistentFlags().StringVarP(&signerport, "port", "p", "53",
@@ -251,7 +722,7 @@ func PrintSigners(sr music.SignerResponse) {
 	if len(sr.Signers) != 0 {
 		var out []string
 		if cliconf.Verbose || showheaders {
-			out = append(out, "Signer|Method|Address|Port|SignerGroups")
+			out = append(out, "Signer|Method|Address|Port|SignerGroups|Views|Proxy")
 		}
 
 		for _, v := range sr.Signers {
@@ -260,8 +731,24 @@ func PrintSigners(sr music.SignerResponse) {
 				groups = v.SignerGroups
 			}
 			gs := strings.Join(groups, ", ")
-			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%s", v.Name, v.Method,
-				v.Address, v.Port, gs))
+			views := []string{}
+			for _, sv := range v.Views {
+				views = append(views, fmt.Sprintf("%s (%s)", sv.Name, sv.Address))
+			}
+			addrs := []string{}
+			for _, a := range v.Addresses {
+				for _, d := range v.DownAddrs {
+					if a == d {
+						a += " (down)"
+					}
+				}
+				addrs = append(addrs, a)
+			}
+			if len(addrs) == 0 {
+				addrs = append(addrs, v.Address)
+			}
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", v.Name, v.Method,
+				strings.Join(addrs, ", "), v.Port, gs, strings.Join(views, ", "), v.Proxy))
 		}
 		fmt.Printf("%s\n", columnize.SimpleFormat(out))
 	}
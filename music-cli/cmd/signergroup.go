@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/DNSSEC-Provisioning/music/music"
 
@@ -43,11 +44,15 @@ var deleteSignerGroupCmd = &cobra.Command{
 	Short: "Delete a signer group from MuSiC",
 	Run: func(cmd *cobra.Command, args []string) {
 		data := music.SignerGroupPost{
-			Command: "delete",
-			Name:    sgroupname,
+			Command:  "delete",
+			Name:     sgroupname,
+			Identity: identity,
 		}
 
 		sgr := SendSignerGroupCmd(sgroupname, data)
+		if sgr.Error {
+			fmt.Printf("Error: %s\n", sgr.ErrorMsg)
+		}
 		if sgr.Message != "" {
 			fmt.Printf("%s\n", sgr.Message)
 		}
@@ -65,9 +70,91 @@ var listSignerGroupsCmd = &cobra.Command{
 	},
 }
 
+var sgsnapshot, sgsnapshotto int
+
+var snapshotsSignerGroupCmd = &cobra.Command{
+	Use:   "snapshots",
+	Short: "List the recorded configuration snapshots of a signer group",
+	Run: func(cmd *cobra.Command, args []string) {
+		sgr := SendSignerGroupCmd(sgroupname, music.SignerGroupPost{
+			Command: "snapshots",
+			Name:    sgroupname,
+		})
+		if sgr.Error {
+			fmt.Printf("Error: %s\n", sgr.ErrorMsg)
+			return
+		}
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Snapshot|Time|Signers|Reason")
+		}
+		for _, ss := range sgr.Snapshots {
+			var signers []string
+			for _, s := range ss.Signers {
+				signers = append(signers, s.Name)
+			}
+			out = append(out, fmt.Sprintf("%d|%s|%s|%s", ss.ID, ss.Time.Format("2006-01-02 15:04:05"),
+				strings.Join(signers, ", "), ss.Reason))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+var diffSignerGroupCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare a snapshot of a signer group with another snapshot or the current configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+		if sgsnapshot == 0 {
+			log.Fatalf("Error: snapshot not specified. Terminating.\n")
+		}
+		sgr := SendSignerGroupCmd(sgroupname, music.SignerGroupPost{
+			Command:  "diff",
+			Name:     sgroupname,
+			Snapshot: sgsnapshot,
+			To:       sgsnapshotto,
+		})
+		if sgr.Error {
+			fmt.Printf("Error: %s\n", sgr.ErrorMsg)
+			return
+		}
+		if len(sgr.Diff) == 0 {
+			fmt.Printf("No differences.\n")
+		}
+		for _, l := range sgr.Diff {
+			fmt.Printf("%s\n", l)
+		}
+	},
+}
+
+var rollbackSignerGroupCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Roll back the configuration of a signer group to a snapshot",
+	Run: func(cmd *cobra.Command, args []string) {
+		if sgsnapshot == 0 {
+			log.Fatalf("Error: snapshot not specified. Terminating.\n")
+		}
+		sgr := SendSignerGroupCmd(sgroupname, music.SignerGroupPost{
+			Command:  "rollback",
+			Name:     sgroupname,
+			Snapshot: sgsnapshot,
+		})
+		if sgr.Error {
+			fmt.Printf("Error: %s\n", sgr.ErrorMsg)
+			return
+		}
+		fmt.Printf("%s\n", sgr.Message)
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(signerGroupCmd)
-	signerGroupCmd.AddCommand(addSignerGroupCmd, deleteSignerGroupCmd, listSignerGroupsCmd)
+	signerGroupCmd.AddCommand(addSignerGroupCmd, deleteSignerGroupCmd, listSignerGroupsCmd,
+		snapshotsSignerGroupCmd, diffSignerGroupCmd, rollbackSignerGroupCmd)
+
+	diffSignerGroupCmd.Flags().IntVarP(&sgsnapshot, "snapshot", "", 0, "snapshot to compare from")
+	diffSignerGroupCmd.Flags().IntVarP(&sgsnapshotto, "to", "", 0,
+		"snapshot to compare with (default: the current configuration)")
+	rollbackSignerGroupCmd.Flags().IntVarP(&sgsnapshot, "snapshot", "", 0, "snapshot to roll back to")
 }
 
 func SendSignerGroupCmd(group string, data music.SignerGroupPost) music.SignerGroupResponse {
@@ -11,6 +11,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/ryanuber/columnize"
@@ -21,8 +22,23 @@ import (
 	"github.com/DNSSEC-Provisioning/music/music"
 )
 
-var fsmname, fsmnextstate, ownername, rrtype, fromsigner, tosigner, zonetype string
+var processparams []string
+var freezereason string
+var throttleclass string
+var fsmname, fsmnextstate, processstartat, ownername, rrtype, fromsigner, tosigner, zonetype string
 var metakey, metavalue, fsmmode string
+var contactemail, contactwebhook string
+var contactclear []string
+var desiredsigners []string
+var approvalid int
+var externalnses []string
+var discovercreate bool
+var evidencerun int
+var evidencefile, evidencepubkey string
+var approver string
+var annotationtext, annotationauthor string
+var annotationrun, annotationid int
+var annotationremove bool
 
 var zoneCmd = &cobra.Command{
 	Use:   "zone",
@@ -47,6 +63,13 @@ var statusZoneCmd = &cobra.Command{
 		if len(zr.Zones) > 0 {
 			PrintZones(zr.Zones, true, "")
 		}
+		if zr.Stuck != nil {
+			fmt.Printf("\nZone %s is stuck: %s\n", zonename, zr.Stuck)
+		}
+		if len(zr.Annotations) > 0 {
+			fmt.Printf("\nAnnotations:\n")
+			PrintAnnotations(zr.Annotations)
+		}
 	},
 }
 
@@ -123,6 +146,27 @@ var zoneJoinGroupCmd = &cobra.Command{
 	},
 }
 
+var zoneAdoptCmd = &cobra.Command{
+	Use:   "adopt",
+	Short: "Put a zone already served correctly by the signers of a group into the group, without a process",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zone == "." {
+			log.Fatalf("ZoneAdopt: zone not specified. Terminating.\n")
+		}
+		if sgroupname == "" {
+			log.Fatalf("ZoneAdopt: signer group not specified. Terminating.\n")
+		}
+
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command:     "adopt",
+			Zone:        music.Zone{Name: zone},
+			SignerGroup: sgroupname,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
 var zoneLeaveGroupCmd = &cobra.Command{
 	Use:   "leave",
 	Short: "Remove a zone from a signer group",
@@ -158,6 +202,7 @@ var deleteZoneCmd = &cobra.Command{
 			Zone: music.Zone{
 				Name: zonename,
 			},
+			Identity: identity,
 		}
 		zr := SendZoneCommand(zonename, data)
 		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
@@ -182,6 +227,11 @@ var zoneMetaCmd = &cobra.Command{
 			if err != nil {
 				log.Fatalf("ZoneMeta: Metadata value not a host:port: %v\n", err)
 			}
+
+		case music.DryRunKey, music.CriticalKey:
+			if metavalue != "true" && metavalue != "false" {
+				log.Fatalf("ZoneMeta: Metadata value for %s must be 'true' or 'false'\n", metakey)
+			}
 		}
 
 		data := music.ZonePost{
@@ -191,6 +241,7 @@ var zoneMetaCmd = &cobra.Command{
 			},
 			Metakey:   metakey,
 			Metavalue: metavalue,
+			Identity:  identity,
 		}
 		if zonetype != "" {
 			data.Zone.ZoneType = zonetype
@@ -203,6 +254,28 @@ var zoneMetaCmd = &cobra.Command{
 	},
 }
 
+var zoneContactCmd = &cobra.Command{
+	Use:   "contact",
+	Short: "Show or set the contact (email, webhook) that gets notifications about the zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		data := music.ZonePost{
+			Command: "contact",
+			Zone: music.Zone{
+				Name: zone,
+			},
+			Contact: music.ZoneContact{
+				Email:   contactemail,
+				Webhook: contactwebhook,
+			},
+			ContactClear: contactclear,
+		}
+
+		zr := SendZoneCommand(zone, data)
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
 var zoneFsmCmd = &cobra.Command{
 	Use:   "fsm",
 	Short: "Insert zone into an FSM",
@@ -239,6 +312,7 @@ reasons and will disappear.`)
 			},
 			FSM:       fsmname,
 			FSMSigner: signername,
+			Identity:  identity,
 		}
 		zr := SendZoneCommand(zone, data)
 		if zr.Error {
@@ -247,6 +321,696 @@ reasons and will disappear.`)
 	},
 }
 
+var zoneStartProcessCmd = &cobra.Command{
+	Use:   "startprocess",
+	Short: "Start a process for the zone, now or at a later time",
+	Long: `Start a process for the zone. With --at the zone is attached to the process
+immediately, but will not move forward until the specified time (RFC3339,
+e.g. "2024-06-01T02:00Z"), allowing changes to be executed in a maintenance
+window. Processes that accept parameters (see 'process list') are given
+them with --param name=value, which may be repeated.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zone == "." {
+			log.Fatalf("ZoneStartProcess: zone not specified. Terminating.\n")
+		}
+		if fsmname == "" {
+			log.Fatalf("ZoneStartProcess: process not specified. Terminating.\n")
+		}
+		if signername == "" {
+			log.Fatalf("ZoneStartProcess: process signer not specified. Terminating.\n")
+		}
+
+		var startat time.Time
+		if processstartat != "" {
+			var err error
+			startat, err = ParseStartTime(processstartat)
+			if err != nil {
+				log.Fatalf("ZoneStartProcess: %v. Terminating.\n", err)
+			}
+			if startat.Before(time.Now()) {
+				log.Fatalf("ZoneStartProcess: start time %s is in the past. Terminating.\n",
+					startat.Format(time.RFC3339))
+			}
+		}
+
+		params := map[string]string{}
+		for _, p := range processparams {
+			kv := strings.SplitN(p, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				log.Fatalf("ZoneStartProcess: parameter '%s' not of the form name=value. Terminating.\n", p)
+			}
+			params[kv[0]] = kv[1]
+		}
+
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command:   "startprocess",
+			Zone:      music.Zone{Name: zone},
+			FSM:       fsmname,
+			FSMSigner: signername,
+			StartAt:   startat,
+			Params:    params,
+			Identity:  identity,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+// ParseStartTime accepts RFC3339 with or without seconds.
+func ParseStartTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Cannot parse '%s' as a time (use e.g. 2024-06-01T02:00Z)", value)
+}
+
+var listDelayedZonesCmd = &cobra.Command{
+	Use:   "delayed",
+	Short: "List zones that are delayed, e.g. waiting for a scheduled process start",
+	Run: func(cmd *cobra.Command, args []string) {
+		if zonename == "" {
+			zonename = "zone-name-not-set.se." // must have something, not used
+		}
+		zr := SendZoneCommand(zonename, music.ZonePost{
+			Command: "list",
+			Zone:    music.Zone{Name: zonename},
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+		PrintZones(zr.Zones, false, "delayed")
+	},
+}
+
+var zonenewname string
+
+var zoneRenameCmd = &cobra.Command{
+	Use:   "rename",
+	Short: "Rename the zone, keeping its history, signer group and process state",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zonenewname == "" {
+			log.Fatalf("Error: new name of the zone (--newname) not specified. Terminating.\n")
+		}
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "rename",
+			Zone:    music.Zone{Name: zone},
+			NewName: dns.Fqdn(zonenewname),
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+var zoneFreezeCmd = &cobra.Command{
+	Use:   "freeze",
+	Short: "Freeze the zone: refuse all modifications of the zone data at the signers",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "freeze",
+			Zone:    music.Zone{Name: zone},
+			Reason:  freezereason,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+var zoneUnfreezeCmd = &cobra.Command{
+	Use:   "unfreeze",
+	Short: "Unfreeze the zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "unfreeze",
+			Zone:    music.Zone{Name: zone},
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+var zoneThrottleCmd = &cobra.Command{
+	Use:   "throttle",
+	Short: "Set the throttling class of the zone, e.g. 'bulk' during a migration (--class '' = default)",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command:  "throttle",
+			Zone:     music.Zone{Name: zone},
+			Throttle: throttleclass,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+var zoneApproveCmd = &cobra.Command{
+	Use:   "approve",
+	Short: "Approve a gated transition of the zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command:  "approve",
+			Zone:     music.Zone{Name: zone},
+			Approval: approvalid,
+			Approver: approver,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+var zoneDenyCmd = &cobra.Command{
+	Use:   "deny",
+	Short: "Deny a gated transition of the zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command:  "deny",
+			Zone:     music.Zone{Name: zone},
+			Approval: approvalid,
+			Approver: approver,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+var zoneApprovalsCmd = &cobra.Command{
+	Use:   "approvals",
+	Short: "List the approvals of the zone in its current process",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "approvals",
+			Zone:    music.Zone{Name: zone},
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "ID|Process|Transition|Status|Requested|Approver")
+		}
+		for _, a := range zr.Approvals {
+			out = append(out, fmt.Sprintf("%d|%s|%s -> %s|%s|%s|%s", a.ID, a.Process,
+				a.FromState, a.ToState, a.Status, a.Requested.Format(time.RFC3339), a.Approver))
+		}
+		if len(out) > 0 {
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		}
+	},
+}
+
+var zoneExternalNSCmd = &cobra.Command{
+	Use:   "external-ns",
+	Short: "Declare the NS records of the zone that do not belong to any signer (none = clear)",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "external-ns",
+			Zone:    music.Zone{Name: zone},
+			NSes:    externalnses,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+var zoneNSesCmd = &cobra.Command{
+	Use:   "nses",
+	Short: "List the managed (per signer) and external NS records of the zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "nses",
+			Zone:    music.Zone{Name: zone},
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "NS|Origin")
+		}
+		for _, zn := range zr.NSes {
+			origin := zn.Signer
+			if zn.External {
+				origin = "external"
+			}
+			out = append(out, fmt.Sprintf("%s|%s", zn.NS, origin))
+		}
+		if len(out) > 0 {
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		}
+	},
+}
+
+var zoneScorecardCmd = &cobra.Command{
+	Use:   "scorecard",
+	Short: "Show the multi-signer DNSSEC health of the zone (pass/warn/fail per check)",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zone == "." {
+			log.Fatalf("Error: zone not specified. Terminating.\n")
+		}
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "scorecard",
+			Zone:    music.Zone{Name: zone},
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+		if zr.Scorecard == nil {
+			return
+		}
+
+		fmt.Printf("Zone %s: %s\n", zr.Scorecard.Zone, strings.ToUpper(zr.Scorecard.Result))
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Check|Result|Detail")
+		}
+		for _, i := range zr.Scorecard.Items {
+			out = append(out, fmt.Sprintf("%s|%s|%s", i.Check, i.Result, i.Detail))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+	},
+}
+
+var zoneDiscoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Discover the name servers of a zone and match them against the known signers",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zone == "." {
+			log.Fatalf("Error: zone not specified. Terminating.\n")
+		}
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "discover",
+			Zone:    music.Zone{Name: zone},
+			Create:  discovercreate,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+		if zr.Discovery == nil {
+			return
+		}
+
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "NS|Addresses|Auth|Serial|Scheme|Signer|Note")
+		}
+		for _, dn := range zr.Discovery.NSes {
+			var note string
+			switch {
+			case dn.Error != "":
+				note = dn.Error
+			case dn.Created:
+				note = fmt.Sprintf("added as signer %s (%s)", dn.Suggested.Name, dn.Suggested.Method)
+			case dn.Suggested != nil:
+				note = fmt.Sprintf("suggested: signer %s (%s)", dn.Suggested.Name, dn.Suggested.Method)
+			}
+			signer := dn.Signer
+			if signer == "" {
+				signer = "---"
+			}
+			out = append(out, fmt.Sprintf("%s|%s|%v|%d|%s|%s|%s", dn.NS,
+				strings.Join(dn.Addresses, ", "), dn.Authoritative, dn.Serial, dn.SerialScheme,
+				signer, note))
+		}
+		fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		if !zr.Discovery.SerialsAgree {
+			fmt.Printf("Note: the name servers do not agree on the SOA serial.\n")
+		}
+	},
+}
+
+var managednameremove bool
+
+var zoneManagedNamesCmd = &cobra.Command{
+	Use:   "managed-names",
+	Short: "List, add (--owner, --rrtype) or remove (--remove) RRsets below the apex that all signers must serve",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zone == "." {
+			log.Fatalf("Error: zone not specified. Terminating.\n")
+		}
+		owner := ""
+		if ownername != "" {
+			owner = dns.Fqdn(ownername)
+			if rrtype == "" {
+				log.Fatalf("Error: RR type not specified. Terminating.\n")
+			}
+		}
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "managed-names",
+			Zone:    music.Zone{Name: zone},
+			Owner:   owner,
+			RRtype:  rrtype,
+			Remove:  managednameremove,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Owner|RRtype")
+		}
+		for _, mn := range zr.ManagedNames {
+			out = append(out, fmt.Sprintf("%s|%s", mn.Owner, mn.RRtype))
+		}
+		if len(out) > 0 {
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		}
+
+		if len(zr.SpecialNames) > 0 {
+			fmt.Printf("Not managed by MUSIC:\n")
+			out = []string{}
+			if cliconf.Verbose || showheaders {
+				out = append(out, "Owner|RRtype|Reason|Signers agree")
+			}
+			for _, sr := range zr.SpecialNames {
+				out = append(out, fmt.Sprintf("%s|%s|%s|%v", sr.Owner, sr.RRtype, sr.Reason, sr.InSync))
+			}
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+			if cliconf.Verbose {
+				for _, sr := range zr.SpecialNames {
+					PrintRRsets(sr.RRs)
+				}
+			}
+		}
+	},
+}
+
+var childremove, childscan bool
+
+var zoneChildrenCmd = &cobra.Command{
+	Use:   "children",
+	Short: "List, add (--owner) or remove (--remove) child zones whose DS RRsets are maintained from their CDS",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zone == "." {
+			log.Fatalf("Error: zone not specified. Terminating.\n")
+		}
+		child := ""
+		if ownername != "" {
+			child = dns.Fqdn(ownername)
+		}
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "children",
+			Zone:    music.Zone{Name: zone},
+			Owner:   child,
+			Remove:  childremove,
+			Scan:    childscan,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Child|Last scan|Status")
+		}
+		for _, cd := range zr.Children {
+			lastscan := "---"
+			if !cd.LastScan.IsZero() {
+				lastscan = cd.LastScan.Format("2006-01-02 15:04:05")
+			}
+			out = append(out, fmt.Sprintf("%s|%s|%s", cd.Child, lastscan, cd.Status))
+		}
+		if len(out) > 0 {
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		}
+	},
+}
+
+var zoneCleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Remove CDS, CDNSKEY and CSYNC records left at the signers by an aborted process or a removed zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zone == "." {
+			log.Fatalf("Error: zone not specified. Terminating.\n")
+		}
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "cleanup",
+			Zone:    music.Zone{Name: zone},
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+var zoneMeasurementsCmd = &cobra.Command{
+	Use:   "measurements",
+	Short: "List the RIPE Atlas propagation measurements of the zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "measurements",
+			Zone:    music.Zone{Name: zone},
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Measurement|RRtype|Started|Checked|Agreeing|Status")
+		}
+		for _, am := range zr.Measurements {
+			checked := "---"
+			if !am.Checked.IsZero() {
+				checked = am.Checked.Format(time.RFC3339)
+			}
+			out = append(out, fmt.Sprintf("%d|%s|%s|%s|%d/%d|%s", am.Measurement, am.RRtype,
+				am.Created.Format(time.RFC3339), checked, am.Agreeing, am.Responses, am.Status))
+		}
+		if len(out) > 0 {
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		}
+	},
+}
+
+var updateslimit int
+
+var zoneUpdatesCmd = &cobra.Command{
+	Use:   "updates",
+	Short: "List the latest updates made to the signers of the zone and whether they verified",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zone == "." {
+			log.Fatalf("Error: zone not specified. Terminating.\n")
+		}
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "updates",
+			Zone:    music.Zone{Name: zone},
+			Limit:   updateslimit,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Time|Signer|Owner|Op|Inserts|Removes|Verified|Detail")
+		}
+		for _, ur := range zr.Updates {
+			verified := ur.Verified
+			if verified == "" {
+				verified = "---"
+			}
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%d|%d|%s|%s", ur.Time.Format(time.RFC3339),
+				ur.Signer, ur.Owner, ur.Op, ur.Inserts, ur.Removes, verified, ur.Detail))
+		}
+		if len(out) > 0 {
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		}
+	},
+}
+
+var zoneMetricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "List the latest custom metrics emitted by the processes of the zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zone == "." {
+			log.Fatalf("Error: zone not specified. Terminating.\n")
+		}
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "metrics",
+			Zone:    music.Zone{Name: zone},
+			Limit:   updateslimit,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+
+		var out []string
+		if cliconf.Verbose || showheaders {
+			out = append(out, "Time|Process|State|Metric|Value|Labels")
+		}
+		for _, pm := range zr.Metrics {
+			var labels []string
+			for k, v := range pm.Labels {
+				labels = append(labels, k+"="+v)
+			}
+			sort.Strings(labels)
+			out = append(out, fmt.Sprintf("%s|%s|%s|%s|%g|%s", pm.Time.Format(time.RFC3339),
+				pm.Process, pm.State, pm.Name, pm.Value, strings.Join(labels, ",")))
+		}
+		if len(out) > 0 {
+			fmt.Printf("%s\n", columnize.SimpleFormat(out))
+		}
+	},
+}
+
+var zoneExplainCmd = &cobra.Command{
+	Use:   "explain",
+	Short: "Re-run the pre-conditions of the next transitions of the zone and show what they check and find",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if zone == "." {
+			log.Fatalf("Error: zone not specified. Terminating.\n")
+		}
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "explain",
+			Zone:    music.Zone{Name: zone},
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+		if zr.Explanation != nil {
+			PrintZoneExplanation(zr.Explanation)
+		}
+	},
+}
+
+func PrintZoneExplanation(ze *music.ZoneExplanation) {
+	fmt.Printf("Zone %s is in state '%s' of process '%s'.\n", ze.Zone, ze.State, ze.Process)
+	if ze.StopReason != "" {
+		fmt.Printf("Current stop reason: %s\n", ze.StopReason)
+	}
+	if ze.Delayed != "" {
+		fmt.Printf("%s\n", ze.Delayed)
+	}
+	for _, te := range ze.Transitions {
+		result := "true"
+		if !te.PreCondition {
+			result = "FALSE"
+		}
+		fmt.Printf("\nTransition '%s' --> '%s': pre-condition %s\n", te.From, te.To, result)
+		if cliconf.Verbose {
+			fmt.Printf("  (%s)\n", te.Description)
+		}
+		for _, c := range te.Checks {
+			status := "ok  "
+			if !c.Ok {
+				status = "FAIL"
+			}
+			where := strings.TrimSpace(strings.Join([]string{c.Signer, c.RRtype}, " "))
+			if where != "" {
+				where = " [" + where + "]"
+			}
+			fmt.Printf("  %s %s%s", status, c.Check, where)
+			if c.Detail != "" {
+				fmt.Printf(": %s", c.Detail)
+			}
+			fmt.Println()
+			for _, rr := range c.Observed {
+				fmt.Printf("         %s\n", rr)
+			}
+		}
+		for _, sr := range te.StopReasons {
+			fmt.Printf("  Stop reason: %s\n", sr)
+		}
+		for _, b := range te.Blocked {
+			fmt.Printf("  Blocked: %s\n", b)
+		}
+	}
+}
+
+var zoneEvidenceCmd = &cobra.Command{
+	Use:   "evidence",
+	Short: "List the process runs of the zone or download the signed evidence bundle of one run",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command: "evidence",
+			Zone:    music.Zone{Name: zone},
+			Run:     evidencerun,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+
+		if evidencerun == 0 {
+			var out []string
+			if cliconf.Verbose || showheaders {
+				out = append(out, "Run|Process|Status|Started|Completed|Signed")
+			}
+			for _, r := range zr.Evidence {
+				completed := "---"
+				if !r.Completed.IsZero() {
+					completed = r.Completed.Format(time.RFC3339)
+				}
+				out = append(out, fmt.Sprintf("%d|%s|%s|%s|%s|%v", r.ID, r.Process, r.Status,
+					r.Started.Format(time.RFC3339), completed, r.Signed))
+			}
+			if len(out) > 0 {
+				fmt.Printf("%s\n", columnize.SimpleFormat(out))
+			}
+			var runannotations []music.ZoneAnnotation
+			for _, a := range zr.Annotations {
+				if a.Run != 0 {
+					runannotations = append(runannotations, a)
+				}
+			}
+			if len(runannotations) > 0 {
+				fmt.Printf("\nAnnotations:\n")
+				PrintAnnotations(runannotations)
+			}
+			return
+		}
+		if zr.Bundle == "" {
+			return
+		}
+
+		if evidencepubkey != "" {
+			pub, err := music.LoadPublicKey(evidencepubkey)
+			if err != nil {
+				log.Fatalf("Error loading public key: %v", err)
+			}
+			if _, err = music.VerifyJWS(zr.Bundle, pub); err != nil {
+				log.Fatalf("Error verifying evidence bundle: %v", err)
+			}
+			fmt.Printf("Signature of evidence bundle for run %d verified.\n", evidencerun)
+		}
+		if evidencefile == "" {
+			fmt.Printf("%s\n", zr.Bundle)
+			return
+		}
+		if err := os.WriteFile(evidencefile, []byte(zr.Bundle+"\n"), 0644); err != nil {
+			log.Fatalf("Error writing evidence bundle: %v", err)
+		}
+		fmt.Printf("Evidence bundle for run %d written to %s.\n", evidencerun, evidencefile)
+	},
+}
+
+var zoneAnnotateCmd = &cobra.Command{
+	Use:   "annotate",
+	Short: "Add a note to the zone or to one of its process runs, e.g. 'waiting for customer to fix NS at registrar'",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command:    "annotate",
+			Zone:       music.Zone{Name: zone},
+			Run:        annotationrun,
+			Annotation: annotationtext,
+			Identity:   annotationauthor,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+var zoneAnnotationsCmd = &cobra.Command{
+	Use:   "annotations",
+	Short: "List the notes on the zone and its process runs, or remove one",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		if annotationremove && annotationid == 0 {
+			log.Fatalf("Error: the annotation to remove must be specified with --id.")
+		}
+		zr := SendZoneCommand(zone, music.ZonePost{
+			Command:      "annotations",
+			Zone:         music.Zone{Name: zone},
+			Run:          annotationrun,
+			Remove:       annotationremove,
+			AnnotationID: annotationid,
+		})
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+		if len(zr.Annotations) > 0 {
+			PrintAnnotations(zr.Annotations)
+		}
+	},
+}
+
 var zoneStepFsmCmd = &cobra.Command{
 	Use:   "step-fsm",
 	Short: "Try to make the zone transition from one state to the next in the FSM",
@@ -377,20 +1141,99 @@ var listBlockedZonesCmd = &cobra.Command{
 	},
 }
 
+var zoneDesiredSignersCmd = &cobra.Command{
+	Use:   "desired-signers",
+	Short: "Declare the set of signers the zone should have (no signers = remove declaration)",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		data := music.ZonePost{
+			Command: "desired-signers",
+			Zone: music.Zone{
+				Name: zone,
+			},
+			Signers: desiredsigners,
+		}
+
+		zr := SendZoneCommand(zone, data)
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
+var zoneReconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Take one step towards the desired signer set of the zone now",
+	Run: func(cmd *cobra.Command, args []string) {
+		zone := dns.Fqdn(zonename)
+		data := music.ZonePost{
+			Command: "reconcile",
+			Zone: music.Zone{
+				Name: zone,
+			},
+		}
+
+		zr := SendZoneCommand(zone, data)
+		PrintZoneResponse(zr.Error, zr.ErrorMsg, zr.Msg)
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(zoneCmd)
 	zoneCmd.AddCommand(addZoneCmd, updateZoneCmd, deleteZoneCmd, listZonesCmd,
-		zoneJoinGroupCmd, zoneLeaveGroupCmd, zoneFsmCmd,
+		zoneJoinGroupCmd, zoneLeaveGroupCmd, zoneFsmCmd, zoneStartProcessCmd,
 		zoneStepFsmCmd, zoneGetRRsetsCmd, zoneListRRsetCmd,
-		zoneCopyRRsetCmd, zoneMetaCmd, statusZoneCmd)
-	listZonesCmd.AddCommand(listBlockedZonesCmd)
+		zoneCopyRRsetCmd, zoneMetaCmd, statusZoneCmd, zoneContactCmd,
+		zoneDesiredSignersCmd, zoneReconcileCmd, zoneFreezeCmd, zoneUnfreezeCmd,
+		zoneApproveCmd, zoneDenyCmd, zoneApprovalsCmd, zoneExternalNSCmd, zoneNSesCmd,
+		zoneDiscoverCmd, zoneEvidenceCmd, zoneMeasurementsCmd, zoneCleanupCmd,
+		zoneManagedNamesCmd, zoneChildrenCmd, zoneUpdatesCmd, zoneMetricsCmd, zoneRenameCmd,
+		zoneScorecardCmd, zoneAdoptCmd, zoneExplainCmd, zoneThrottleCmd,
+		zoneAnnotateCmd, zoneAnnotationsCmd)
+	listZonesCmd.AddCommand(listBlockedZonesCmd, listDelayedZonesCmd)
 
 	zoneCmd.PersistentFlags().StringVarP(&zonetype, "type", "t", "",
-		"type of zone, 'normal' or 'debug'")
+		fmt.Sprintf("type of zone (%s)", strings.Join(music.ListZoneTypes(), ", ")))
 	zoneCmd.PersistentFlags().StringVarP(&fsmmode, "fsmmode", "", "manual",
 		"FSM mode ('auto' or 'manual')")
 	zoneFsmCmd.Flags().StringVarP(&fsmname, "fsm", "f", "",
 		"name of finite state machine to attach zone to")
+	zoneStartProcessCmd.Flags().StringVarP(&fsmname, "fsm", "f", "",
+		"name of process to start")
+	zoneStartProcessCmd.Flags().StringVarP(&processstartat, "at", "", "",
+		"start time (RFC3339, e.g. 2024-06-01T02:00Z), default now")
+	zoneStartProcessCmd.Flags().StringArrayVarP(&processparams, "param", "", []string{},
+		"process parameter (name=value)")
+	zoneRenameCmd.Flags().StringVarP(&zonenewname, "newname", "", "", "new name of the zone")
+	zoneFreezeCmd.Flags().StringVarP(&freezereason, "reason", "", "",
+		"reason for the freeze, e.g. 'change freeze until 2024-01-07'")
+	zoneThrottleCmd.Flags().StringVarP(&throttleclass, "class", "", "",
+		"throttling class (signers.throttling.classes in the musicd config)")
+	zoneThrottleCmd.MarkFlagRequired("class")
+	zoneAnnotateCmd.Flags().StringVarP(&annotationtext, "text", "", "", "the note")
+	zoneAnnotateCmd.MarkFlagRequired("text")
+	zoneAnnotateCmd.Flags().StringVarP(&annotationauthor, "author", "", os.Getenv("USER"),
+		"author of the note (ignored when logged in via OIDC)")
+	for _, c := range []*cobra.Command{zoneAnnotateCmd, zoneAnnotationsCmd} {
+		c.Flags().IntVarP(&annotationrun, "run", "", 0,
+			"process run (see 'zone evidence'); 0 = the zone itself (annotate) or all (annotations)")
+	}
+	zoneAnnotationsCmd.Flags().BoolVarP(&annotationremove, "remove", "", false, "remove the annotation --id")
+	zoneAnnotationsCmd.Flags().IntVarP(&annotationid, "id", "", 0, "annotation id")
+	for _, c := range []*cobra.Command{zoneApproveCmd, zoneDenyCmd} {
+		c.Flags().IntVarP(&approvalid, "id", "", 0, "approval id")
+		c.MarkFlagRequired("id")
+		c.Flags().StringVarP(&approver, "approver", "", os.Getenv("USER"),
+			"name of approver (ignored when logged in via OIDC)")
+	}
+	zoneExternalNSCmd.Flags().StringSliceVarP(&externalnses, "ns", "", []string{},
+		"comma-separated list of external NS names")
+	zoneDiscoverCmd.Flags().BoolVarP(&discovercreate, "create", "", false,
+		"add signers for the name servers that are not known signers")
+	zoneEvidenceCmd.Flags().IntVarP(&evidencerun, "run", "", 0,
+		"process run to download the evidence bundle of (0 = list runs)")
+	zoneEvidenceCmd.Flags().StringVarP(&evidencefile, "file", "", "",
+		"file to write the bundle to (default stdout)")
+	zoneEvidenceCmd.Flags().StringVarP(&evidencepubkey, "pubkey", "", "",
+		"PEM public key or certificate to verify the bundle signature with")
 	zoneStepFsmCmd.Flags().StringVarP(&fsmnextstate, "nextstate", "", "",
 		"name of next state in on-going FSM process")
 	zoneCopyRRsetCmd.Flags().StringVarP(&fromsigner, "from", "", "",
@@ -402,19 +1245,46 @@ func init() {
 	zoneCmd.PersistentFlags().StringVarP(&rrtype, "rrtype", "r", "",
 		"RRtype of RRset")
 	zoneMetaCmd.Flags().StringVarP(&metakey, "metakey", "", "",
-		"Metadata key (known keys:'parentaddr')")
+		"Metadata key (known keys:'parentaddr', 'dryrun')")
 	zoneMetaCmd.Flags().StringVarP(&metavalue, "metavalue", "", "",
 		"Metadata value")
 	zoneMetaCmd.MarkFlagRequired("zone")
 	zoneMetaCmd.MarkFlagRequired("metakey")
 	zoneMetaCmd.MarkFlagRequired("metavalue")
+	zoneContactCmd.Flags().StringVarP(&contactemail, "email", "", "",
+		"email address of zone contact")
+	zoneContactCmd.Flags().StringVarP(&contactwebhook, "webhook", "", "",
+		"webhook URL of zone contact")
+	zoneContactCmd.Flags().StringSliceVarP(&contactclear, "clear", "", []string{},
+		"contact fields to remove (email, webhook)")
+	zoneContactCmd.MarkFlagRequired("zone")
+	zoneDesiredSignersCmd.Flags().StringSliceVarP(&desiredsigners, "signers", "", []string{},
+		"comma-separated list of signers")
+	zoneDesiredSignersCmd.MarkFlagRequired("zone")
+	zoneReconcileCmd.MarkFlagRequired("zone")
+	zoneManagedNamesCmd.Flags().BoolVarP(&managednameremove, "remove", "", false,
+		"stop managing the RRset given by --owner and --rrtype")
+	zoneUpdatesCmd.Flags().IntVarP(&updateslimit, "limit", "", 25,
+		"max number of updates to list")
+	zoneMetricsCmd.Flags().IntVarP(&updateslimit, "limit", "", 25,
+		"max number of metrics to list")
+	zoneChildrenCmd.Flags().BoolVarP(&childremove, "remove", "", false,
+		"stop maintaining the DS RRset of the child given by --owner")
+	zoneChildrenCmd.Flags().BoolVarP(&childscan, "scan", "", false,
+		"update the DS RRsets of the children from their CDS now")
 }
 
 func SendZoneCommand(zonename string, data music.ZonePost) music.ZoneResponse {
 	// IsDomainName() is too liberal, we need a stricter test.
-	if _, ok := dns.IsDomainName(zonename); !ok {
-		log.Fatalf("SendZoneCommand: Error: '%s' is not a legal domain name. Terminating.",
-			zonename)
+	if _, err := music.CanonicalZoneName(zonename); err != nil {
+		log.Fatalf("SendZoneCommand: Error: %v Terminating.", err)
+	}
+	if data.Zone.Name != "" {
+		zname, err := music.CanonicalZoneName(data.Zone.Name)
+		if err != nil {
+			log.Fatalf("SendZoneCommand: Error: %v Terminating.", err)
+		}
+		data.Zone.Name = zname
 	}
 
 	bytebuf := new(bytes.Buffer)
@@ -562,6 +1432,22 @@ func ZoneCopyRRset(zone, owner, rrtype, signer string) (bool, string, []string)
 }
 
 // Is this actually exactly the same as PrintSignerResponse?
+func PrintAnnotations(annotations []music.ZoneAnnotation) {
+	var out []string
+	if cliconf.Verbose || showheaders {
+		out = append(out, "ID|Time|Run|Author|Annotation")
+	}
+	for _, a := range annotations {
+		run := "---"
+		if a.Run != 0 {
+			run = fmt.Sprintf("%d", a.Run)
+		}
+		out = append(out, fmt.Sprintf("%d|%s|%s|%s|%s", a.ID, a.Time.Format(time.RFC3339), run,
+			a.Author, a.Text))
+	}
+	fmt.Printf("%s\n", columnize.SimpleFormat(out))
+}
+
 func PrintZoneResponse(iserr bool, errormsg, msg string) {
 	if iserr {
 		fmt.Printf("%s\n", errormsg)
@@ -602,7 +1488,7 @@ func PrintZones(zm map[string]music.Zone, showall bool, fsmstatus string) {
 				modebits += "A"
 			}
 
-			if zone.ZoneType == "debug" {
+			if zone.Type().Simulated {
 				modebits += "D"
 			}
 			if len(modebits) != 0 {
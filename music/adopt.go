@@ -0,0 +1,219 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Adopting zones. A zone that is already served by a correct multi-signer setup (e.g.
+// when MUSIC is introduced for an existing deployment) does not need to go through the
+// join process, which would push everything again. "zone adopt" instead verifies the
+// live state with the checks of the zone scorecard, records which signer each DNSKEY
+// and NS originates from in zone_dnskeys and zone_nses (as the join process would
+// have), and puts the zone in the signer group without starting any process.
+//
+// The origin of a DNSKEY is the signer whose RRSIGs over the SOA and DNSKEY RRsets use
+// it. The origin of an NS is the signer it resolves to, or the signer with the backend
+// method the NS name belongs to. Keys and NSes whose origin can not be determined are
+// reported and left unattributed; NSes that belong to no signer should be declared
+// external.
+
+// adoptKeyOrigins returns the DNSKEYs used by each signer to sign the zone.
+func adoptKeyOrigins(zone string, signers []*Signer) (map[string][]*dns.DNSKEY, error) {
+	origins := map[string][]*dns.DNSKEY{}
+	for _, s := range signers {
+		keys := map[uint16]*dns.DNSKEY{}
+		used := map[uint16]bool{}
+		for _, qtype := range []uint16{dns.TypeDNSKEY, dns.TypeSOA} {
+			m := new(dns.Msg)
+			m.SetQuestion(zone, qtype)
+			m.SetEdns0(4096, true)
+			r, _, err := s.Exchange(&dns.Client{Net: "tcp", Timeout: 5 * time.Second}, m)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", s.Name, err)
+			}
+			for _, rr := range r.Answer {
+				switch rr := rr.(type) {
+				case *dns.DNSKEY:
+					keys[rr.KeyTag()] = rr
+				case *dns.RRSIG:
+					used[rr.KeyTag] = true
+				}
+			}
+		}
+		for tag := range used {
+			if k, exist := keys[tag]; exist {
+				origins[s.Name] = append(origins[s.Name], k)
+			}
+		}
+		if len(origins[s.Name]) == 0 {
+			return nil, fmt.Errorf("%s: no DNSKEY used for signing the zone found", s.Name)
+		}
+	}
+	return origins, nil
+}
+
+// adoptNSOrigin returns the signer that the NS name belongs to, or "".
+func adoptNSOrigin(ns string, signers []*Signer, addrs []string) string {
+	var byaddr, bymethod []string
+	for _, s := range signers {
+		for _, a := range s.addressList() {
+			match := strings.EqualFold(dns.Fqdn(a), ns)
+			for _, addr := range addrs {
+				match = match || a == addr
+			}
+			if match {
+				byaddr = append(byaddr, s.Name)
+				break
+			}
+		}
+		if method := discoveryBackend(ns); method != "" && method == s.Method {
+			bymethod = append(bymethod, s.Name)
+		}
+	}
+	switch {
+	case len(byaddr) == 1:
+		return byaddr[0]
+	case len(byaddr) == 0 && len(bymethod) == 1:
+		return bymethod[0]
+	}
+	return ""
+}
+
+// ZoneAdopt puts a zone that is already served correctly by the signers of the group
+// into the group, without running any process.
+func (mdb *MusicDB) ZoneAdopt(tx *sql.Tx, dbzone *Zone, g string) (string, error) {
+	if !dbzone.Exists {
+		return "", fmt.Errorf("Zone %s unknown", dbzone.Name)
+	}
+	if dbzone.FSM != "" && dbzone.FSM != "---" {
+		return "", fmt.Errorf("Zone %s is in process '%s' and can not be adopted.", dbzone.Name, dbzone.FSM)
+	}
+	if sg := dbzone.SignerGroup(); sg != nil && sg.Name != "" && sg.Name != g {
+		return "", fmt.Errorf("Zone %s already assigned to signer group %s", dbzone.Name, sg.Name)
+	}
+	group, err := mdb.GetSignerGroup(tx, g, false) // not apisafe
+	if err != nil {
+		return "", err
+	}
+	if group.Locked {
+		return "", fmt.Errorf("Signer group %s locked from zones joining or leaving due to ongoing '%s' process.",
+			group.Name, group.CurrentProcess)
+	}
+
+	// verify the live state
+	sc, err := mdb.groupScorecard(dbzone, group)
+	if err != nil {
+		return "", err
+	}
+	var failed []string
+	for _, i := range sc.Items {
+		if i.Result == ScoreFail {
+			failed = append(failed, fmt.Sprintf("%s: %s", i.Check, i.Detail))
+		}
+	}
+	if len(failed) > 0 {
+		return "", fmt.Errorf("Zone %s is not in a correct multi-signer state, use 'zone join' instead:\n%s",
+			dbzone.Name, strings.Join(failed, "\n"))
+	}
+
+	var signers []*Signer
+	for _, s := range group.SignerMap {
+		signers = append(signers, s)
+	}
+	sort.Slice(signers, func(i, j int) bool { return signers[i].Name < signers[j].Name })
+
+	keys, err := adoptKeyOrigins(dbzone.Name, signers)
+	if err != nil {
+		return "", fmt.Errorf("Zone %s: unable to determine the origin of the DNSKEYs: %v", dbzone.Name, err)
+	}
+	err, nsrrs := GetUpdater(signers[0].Method).FetchRRset(signers[0], dbzone.Name, dbzone.Name, dns.TypeNS)
+	if err != nil {
+		return "", err
+	}
+	external, err := mdb.ExternalNSes(tx, dbzone.Name)
+	if err != nil {
+		return "", err
+	}
+	nses := map[string]string{}
+	var unknown []string
+	for _, rr := range nsrrs {
+		ns, ok := rr.(*dns.NS)
+		if !ok || IsExternalNS(external, ns.Ns) {
+			continue
+		}
+		addrs, _ := net.LookupHost(strings.TrimSuffix(ns.Ns, "."))
+		if origin := adoptNSOrigin(canonicalNS(ns.Ns), signers, addrs); origin != "" {
+			nses[ns.Ns] = origin
+		} else {
+			unknown = append(unknown, ns.Ns)
+		}
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneAdopt: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "UPDATE zones SET sgroup=?, fsm='', fsmsigner='', state='' WHERE name=?"
+	_, err = tx.Exec(sqlq, g, dbzone.Name)
+	if CheckSQLError("ZoneAdopt", sqlq, err, false) {
+		return "", err
+	}
+	for _, dsql := range []string{"DELETE FROM zone_dnskeys WHERE zone=?", "DELETE FROM zone_nses WHERE zone=?"} {
+		_, err = tx.Exec(dsql, dbzone.Name)
+		if CheckSQLError("ZoneAdopt", dsql, err, false) {
+			return "", err
+		}
+	}
+
+	nkeys := 0
+	const ksql = "INSERT OR IGNORE INTO zone_dnskeys (zone, dnskey, signer) VALUES (?, ?, ?)"
+	for signer, dnskeys := range keys {
+		for _, k := range dnskeys {
+			_, err = tx.Exec(ksql, dbzone.Name, fmt.Sprintf("%d-%d-%s", k.Protocol, k.Algorithm, k.PublicKey),
+				signer)
+			if CheckSQLError("ZoneAdopt", ksql, err, false) {
+				return "", err
+			}
+			nkeys++
+		}
+	}
+	const nsql = "INSERT OR IGNORE INTO zone_nses (zone, ns, signer) VALUES (?, ?, ?)"
+	for ns, signer := range nses {
+		_, err = tx.Exec(nsql, dbzone.Name, ns, signer)
+		if CheckSQLError("ZoneAdopt", nsql, err, false) {
+			return "", err
+		}
+	}
+	if _, err = mdb.ZoneSetMeta(tx, dbzone, "adopted", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return "", err
+	}
+
+	msg := fmt.Sprintf("Zone %s has been adopted into signer group %s without running any process. "+
+		"Recorded the origin of %d DNSKEYs and %d NSes.", dbzone.Name, g, nkeys, len(nses))
+	for _, i := range sc.Items {
+		if i.Result == ScoreWarn {
+			msg += fmt.Sprintf("\nWarning: %s: %s", i.Check, i.Detail)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		msg += fmt.Sprintf("\nThe origin of these NSes is unknown, declare them external if they belong "+
+			"to no signer: %s", strings.Join(unknown, ", "))
+	}
+	return msg, nil
+}
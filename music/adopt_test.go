@@ -0,0 +1,25 @@
+package music
+
+import "testing"
+
+func TestAdoptNSOrigin(t *testing.T) {
+	signers := []*Signer{
+		{Name: "bind1", Method: "ddns", Address: "192.0.2.1", Addresses: []string{"192.0.2.1", "2001:db8::1"}},
+		{Name: "knot1", Method: "ddns", Address: "ns.knot.example."},
+		{Name: "desec", Method: "desec-api"},
+	}
+	for _, tc := range []struct {
+		ns    string
+		addrs []string
+		want  string
+	}{
+		{"ns1.example.com.", []string{"2001:db8::1"}, "bind1"},
+		{"ns.knot.example.", nil, "knot1"},
+		{"ns1.desec.io.", []string{"198.51.100.1"}, "desec"},
+		{"ns9.example.net.", []string{"198.51.100.9"}, ""},
+	} {
+		if got := adoptNSOrigin(tc.ns, signers, tc.addrs); got != tc.want {
+			t.Errorf("adoptNSOrigin(%s) = '%s', want '%s'", tc.ns, got, tc.want)
+		}
+	}
+}
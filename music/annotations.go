@@ -0,0 +1,145 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Operator annotations. Free-text notes on a zone ("waiting for customer to fix NS at
+// registrar") or on one of its process runs (see evidence.go), with author and time, so
+// that the operational context is kept with the zone. The annotations are shown in the
+// zone status and in the list of process runs, and those made on a run before it ended
+// are included in its evidence bundle. Annotations of the zone are removed with the
+// zone; those of its process runs are kept along with the runs.
+
+const maxAnnotationLength = 1000
+
+type ZoneAnnotation struct {
+	ID     int
+	Zone   string
+	Run    int // 0 = the zone itself
+	Author string
+	Time   time.Time
+	Text   string
+}
+
+// cleanAnnotation returns the text of an annotation on one line, or an error if it is
+// empty or too long.
+func cleanAnnotation(text string) (string, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	switch {
+	case text == "":
+		return "", fmt.Errorf("Annotation is empty.")
+	case len(text) > maxAnnotationLength:
+		return "", fmt.Errorf("Annotation is longer than %d characters.", maxAnnotationLength)
+	}
+	return text, nil
+}
+
+func (mdb *MusicDB) ZoneAnnotate(tx *sql.Tx, z *Zone, run int, author, text string) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+	text, err := cleanAnnotation(text)
+	if err != nil {
+		return "", err
+	}
+	if author == "" {
+		author = "unknown"
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneAnnotate: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if run != 0 {
+		var id int
+		const sqlq = "SELECT id FROM process_runs WHERE id=? AND zone=?"
+		err = tx.QueryRow(sqlq, run, z.Name).Scan(&id)
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("Zone %s has no process run %d.", z.Name, run)
+			return "", err
+		}
+		if CheckSQLError("ZoneAnnotate", sqlq, err, false) {
+			return "", err
+		}
+	}
+
+	const sqlq = `
+INSERT INTO zone_annotations(zone, run, author, time, text) VALUES (?, ?, ?, datetime('now'), ?)`
+	res, err := tx.Exec(sqlq, z.Name, run, author, text)
+	if CheckSQLError("ZoneAnnotate", sqlq, err, false) {
+		return "", err
+	}
+	id, _ := res.LastInsertId()
+	if run != 0 {
+		return fmt.Sprintf("Annotation %d added to process run %d of zone %s.", id, run, z.Name), nil
+	}
+	return fmt.Sprintf("Annotation %d added to zone %s.", id, z.Name), nil
+}
+
+func (mdb *MusicDB) ZoneRemoveAnnotation(tx *sql.Tx, z *Zone, id int) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneRemoveAnnotation: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "DELETE FROM zone_annotations WHERE id=? AND zone=?"
+	res, err := tx.Exec(sqlq, id, z.Name)
+	if CheckSQLError("ZoneRemoveAnnotation", sqlq, err, false) {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", fmt.Errorf("Zone %s has no annotation %d.", z.Name, id)
+	}
+	return fmt.Sprintf("Annotation %d removed from zone %s.", id, z.Name), nil
+}
+
+// ListZoneAnnotations returns the annotations of the zone and its process runs, oldest
+// first. If run is not 0 only the annotations of that run are returned.
+func (mdb *MusicDB) ListZoneAnnotations(tx *sql.Tx, zone string, run int) ([]ZoneAnnotation, error) {
+	var res []ZoneAnnotation
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListZoneAnnotations: Error from mdb.StartTransaction(): %v\n", err)
+		return res, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT id, zone, run, author, COALESCE(time, ''), text FROM zone_annotations
+WHERE zone=? AND (?=0 OR run=?) ORDER BY time, id`
+	rows, err := tx.Query(sqlq, zone, run, run)
+	if CheckSQLError("ListZoneAnnotations", sqlq, err, false) {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a ZoneAnnotation
+		var t string
+		if err := rows.Scan(&a.ID, &a.Zone, &a.Run, &a.Author, &t, &a.Text); err != nil {
+			log.Fatalf("ListZoneAnnotations: Error from rows.Scan(): %v", err)
+		}
+		a.Time, _ = time.Parse(layout, t)
+		res = append(res, a)
+	}
+	return res, nil
+}
@@ -0,0 +1,18 @@
+package music
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanAnnotation(t *testing.T) {
+	got, err := cleanAnnotation("  waiting for customer\n to fix\tNS at registrar ")
+	if err != nil || got != "waiting for customer to fix NS at registrar" {
+		t.Errorf("cleanAnnotation() = %q, %v", got, err)
+	}
+	for _, text := range []string{"", " \n\t", strings.Repeat("x", maxAnnotationLength+1)} {
+		if _, err := cleanAnnotation(text); err == nil {
+			t.Errorf("cleanAnnotation(%.20q...) accepted", text)
+		}
+	}
+}
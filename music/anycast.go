@@ -0,0 +1,135 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Anycast signers. When a signer is served from many anycast sites, one site answering
+// correctly does not mean that all of them do. For signers marked as anycast every
+// fetch is repeated from each of the vantage points in signers.anycast.vantagepoints
+// (probe hosts reached as jump hosts, see jumphost.go) and the RRset is only returned
+// if all vantage points see the same data. Since the pre- and post-conditions are
+// built on fetches, a zone does not move forward until all sites agree.
+
+type vantagePoint struct {
+	Name string
+	jh   jumpHost
+}
+
+func anycastVantagePoints() []vantagePoint {
+	var vps []vantagePoint
+	sshkey := viper.GetString("signers.anycast.sshkey")
+	for name, proxy := range viper.GetStringMapString("signers.anycast.vantagepoints") {
+		vps = append(vps, vantagePoint{Name: name, jh: jumpHost{proxy: proxy, sshkey: sshkey}})
+	}
+	sort.Slice(vps, func(i, j int) bool { return vps[i].Name < vps[j].Name })
+	return vps
+}
+
+func (mdb *MusicDB) SignerSetAnycast(tx *sql.Tx, dbsigner *Signer, anycast bool) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	if dbsigner.Method != "ddns" && dbsigner.Method != "rlddns" {
+		return "", fmt.Errorf("Signer %s has method %s. Anycast verification is only supported for DDNS signers.",
+			dbsigner.Name, dbsigner.Method)
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerSetAnycast: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if !anycast {
+		err = mdb.setSignerOption(tx, dbsigner.Name, signerOptAnycast, "")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Signer %s is no longer verified from multiple vantage points.",
+			dbsigner.Name), nil
+	}
+
+	err = mdb.setSignerOption(tx, dbsigner.Name, signerOptAnycast, "1")
+	if err != nil {
+		return "", err
+	}
+	msg := fmt.Sprintf("Signer %s is now verified from multiple vantage points.", dbsigner.Name)
+	if len(anycastVantagePoints()) == 0 {
+		msg += " Note: no vantage points are configured (signers.anycast.vantagepoints)."
+	}
+	return msg, nil
+}
+
+// fetchVia fetches the RRset from the signer with the query sent from a vantage point.
+func fetchVia(vp vantagePoint, signer *Signer, fqdn string, rrtype uint16) ([]dns.RR, error) {
+	addr := signer.DnsAddress()
+	c := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn, rrtype)
+	if err := signer.PrepareTSIGExchange(c, m); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	conn, err := dialJumpHost(vp.jh, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	r, err := dnsConnExchange(c, conn, m)
+	logQuery("tcp", vp.Name+"|"+addr, m, r, time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("Fetch of %s RRset failed, RCODE = %s", dns.TypeToString[rrtype],
+			dns.RcodeToString[r.Rcode])
+	}
+
+	var rrs []dns.RR
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == rrtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs, nil
+}
+
+// AnycastUpdater wraps a DDNS updater and verifies every fetch from anycast signers
+// against all vantage points.
+type AnycastUpdater struct {
+	Updater
+}
+
+func (u *AnycastUpdater) FetchRRset(signer *Signer, zone, fqdn string, rrtype uint16) (error, []dns.RR) {
+	err, rrs := u.Updater.FetchRRset(signer, zone, fqdn, rrtype)
+	if err != nil || !signer.Anycast {
+		return err, rrs
+	}
+
+	key := rrsetKey(rrs)
+	for _, vp := range anycastVantagePoints() {
+		vrrs, err := fetchVia(vp, signer, fqdn, rrtype)
+		if err != nil {
+			return fmt.Errorf("Signer %s: vantage point %s: %v", signer.Name, vp.Name, err), []dns.RR{}
+		}
+		if rrsetKey(vrrs) != key {
+			return fmt.Errorf("Signer %s: %s %s RRset differs between anycast sites (as seen from vantage point %s)",
+				signer.Name, fqdn, dns.TypeToString[rrtype], vp.Name), []dns.RR{}
+		}
+	}
+	return nil, rrs
+}
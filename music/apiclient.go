@@ -7,6 +7,7 @@ package music
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -15,11 +16,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/viper"
@@ -81,6 +85,13 @@ func GetAPIUrl(service, endpoint, key string, usetls, verbose bool) (string, str
 
 func GenericAPIget(apiurl, apikey, authmethod string, usetls, verbose, debug bool,
 	extclient *http.Client) (int, []byte, error) {
+	return GenericAPIgetContext(context.Background(), apiurl, apikey, authmethod, usetls, verbose, debug, extclient)
+}
+
+func GenericAPIgetContext(ctx context.Context, apiurl, apikey, authmethod string, usetls, verbose, debug bool,
+	extclient *http.Client) (int, []byte, error) {
+	ctx, cancel := apiContext(ctx)
+	defer cancel()
 
 	var client *http.Client
 
@@ -92,14 +103,15 @@ func GenericAPIget(apiurl, apikey, authmethod string, usetls, verbose, debug boo
 			if verbose {
 				fmt.Printf("GenericAPIget: apiurl: %s (using TLS)\n", apiurl)
 			}
+			tlsconf, err := GenericTLSConfig()
+			if err != nil {
+				return 501, nil, err
+			}
 			client = &http.Client{
 				// CheckRedirect: redirectPolicyFunc,
 				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-					},
+					TLSClientConfig: tlsconf,
 				},
-				Timeout: 1 * time.Second,
 			}
 		} else {
 			if verbose {
@@ -107,7 +119,6 @@ func GenericAPIget(apiurl, apikey, authmethod string, usetls, verbose, debug boo
 			}
 			client = &http.Client{
 				// CheckRedirect: redirectPolicyFunc,
-				Timeout: 1 * time.Second,
 			}
 		}
 
@@ -129,7 +140,7 @@ func GenericAPIget(apiurl, apikey, authmethod string, usetls, verbose, debug boo
 		}
 	}
 
-	req, err := http.NewRequest("GET", apiurl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiurl, nil)
 	if err != nil {
 		fmt.Printf("GenericAPIget: error in http.NewRequest: %v\n", err)
 	}
@@ -161,6 +172,14 @@ func GenericAPIget(apiurl, apikey, authmethod string, usetls, verbose, debug boo
 
 func GenericAPIpost(apiurl, apikey, authmethod string, data []byte,
 	usetls, verbose, debug bool, extclient *http.Client) (int, []byte, error) {
+	return GenericAPIpostContext(context.Background(), apiurl, apikey, authmethod, data, usetls, verbose,
+		debug, extclient)
+}
+
+func GenericAPIpostContext(ctx context.Context, apiurl, apikey, authmethod string, data []byte,
+	usetls, verbose, debug bool, extclient *http.Client) (int, []byte, error) {
+	ctx, cancel := apiContext(ctx)
+	defer cancel()
 
 	var client *http.Client
 
@@ -170,12 +189,14 @@ func GenericAPIpost(apiurl, apikey, authmethod string, data []byte,
 		}
 
 		if usetls {
+			tlsconf, err := GenericTLSConfig()
+			if err != nil {
+				return 501, nil, err
+			}
 			client = &http.Client{
 				// CheckRedirect: redirectPolicyFunc,
 				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-					},
+					TLSClientConfig: tlsconf,
 				},
 			}
 		} else {
@@ -201,7 +222,7 @@ func GenericAPIpost(apiurl, apikey, authmethod string, data []byte,
 		fmt.Printf("GenericAPIpost: posting %d bytes of data: %v\n",
 			len(data), string(data))
 	}
-	req, err := http.NewRequest(http.MethodPost, apiurl,
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiurl,
 		bytes.NewBuffer(data))
 	if err != nil {
 		log.Fatalf("Error from http.NewRequest: Error: %v", err)
@@ -238,6 +259,14 @@ func GenericAPIpost(apiurl, apikey, authmethod string, data []byte,
 
 func GenericAPIput(apiurl, apikey, authmethod string, data []byte,
 	usetls, verbose, debug bool, extclient *http.Client) (int, []byte, error) {
+	return GenericAPIputContext(context.Background(), apiurl, apikey, authmethod, data, usetls, verbose,
+		debug, extclient)
+}
+
+func GenericAPIputContext(ctx context.Context, apiurl, apikey, authmethod string, data []byte,
+	usetls, verbose, debug bool, extclient *http.Client) (int, []byte, error) {
+	ctx, cancel := apiContext(ctx)
+	defer cancel()
 
 	var client *http.Client
 
@@ -247,12 +276,14 @@ func GenericAPIput(apiurl, apikey, authmethod string, data []byte,
 		}
 
 		if usetls {
+			tlsconf, err := GenericTLSConfig()
+			if err != nil {
+				return 501, nil, err
+			}
 			client = &http.Client{
 				// CheckRedirect: redirectPolicyFunc,
 				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-					},
+					TLSClientConfig: tlsconf,
 				},
 			}
 		} else {
@@ -278,7 +309,7 @@ func GenericAPIput(apiurl, apikey, authmethod string, data []byte,
 		fmt.Printf("GenericAPIput: posting %d bytes of data: %v\n",
 			len(data), string(data))
 	}
-	req, err := http.NewRequest(http.MethodPut, apiurl,
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, apiurl,
 		bytes.NewBuffer(data))
 	if err != nil {
 		log.Fatalf("Error from http.NewRequest: Error: %v", err)
@@ -305,14 +336,9 @@ func GenericAPIput(apiurl, apikey, authmethod string, data []byte,
 	if err != nil {
 		return 501, nil, err
 	}
-	status := resp.StatusCode
 	defer resp.Body.Close()
 	buf, err := ioutil.ReadAll(resp.Body)
 
-	if status == 429 {
-		// hold := ExtractHoldPeriod(buf)
-	}
-
 	if debug {
 		fmt.Printf("GenericAPIput: response from api:\n%s\n\n", string(buf))
 	}
@@ -321,36 +347,77 @@ func GenericAPIput(apiurl, apikey, authmethod string, data []byte,
 	return resp.StatusCode, buf, err
 }
 
-func ExtractHoldPeriod(buf []byte) int {
+// DefaultHoldPeriod is used when a rate-limited response does not say for how long.
+const DefaultHoldPeriod = 10 // seconds
+
+var holdDetailRE = regexp.MustCompile(`available in (\d+) seconds?`)
+
+// ParseRetryAfter parses the value of a Retry-After header, which is either a number of
+// seconds or an HTTP date. The result is the hold period in seconds.
+func ParseRetryAfter(value string) (int, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return secs, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		secs := int(time.Until(t).Seconds() + 0.5)
+		if secs < 0 {
+			secs = 0
+		}
+		return secs, true
+	}
+	return 0, false
+}
+
+// ExtractHoldPeriod returns the number of seconds to wait after a rate-limited (429)
+// response. The Retry-After header is used if present (header may be nil), then a
+// structured "wait" field in the response, and last the deSEC error detail ("Request
+// was throttled. Expected available in 3 seconds."). If none of them works
+// DefaultHoldPeriod is returned together with an error.
+func ExtractHoldPeriod(header http.Header, buf []byte) (int, error) {
+	if hold, ok := ParseRetryAfter(header.Get("Retry-After")); ok {
+		return hold, nil
+	}
+
 	var de DesecError
 	err := json.Unmarshal(buf, &de)
 	if err != nil {
-		log.Fatalf("Error from unmarshal DesecError: %v\n", err)
-	}
-	// "Request was throttled. Expected available in 1 second."
-	fmt.Printf("deSEC error detail: '%s'\n", de.Detail)
-	de.Detail = strings.TrimLeft(de.Detail, "Request was throttled. Expected available in ")
-	fmt.Printf("deSEC error detail: '%s'\n", de.Detail)
-	de.Detail = strings.TrimRight(de.Detail, " second.")
-	fmt.Printf("deSEC error detail: '%s'\n", de.Detail)
-	de.Hold, err = strconv.Atoi(de.Detail)
-	if err != nil {
-		log.Printf("Error from Atoi: %v\n", err)
+		return DefaultHoldPeriod, fmt.Errorf("Error parsing rate-limit response '%s': %v",
+			string(buf), err)
 	}
-	fmt.Printf("Rate-limited. Hold period: %d\n", de.Hold)
-	return de.Hold
+	if de.Wait != nil && *de.Wait >= 0 {
+		return int(math.Ceil(*de.Wait)), nil // fractional seconds, never wait too short
+	}
+	m := holdDetailRE.FindStringSubmatch(de.Detail)
+	if m == nil {
+		return DefaultHoldPeriod, fmt.Errorf("No hold period in rate-limit response: '%s'",
+			de.Detail)
+	}
+	de.Hold, _ = strconv.Atoi(m[1]) // the RE guarantees digits
+	return de.Hold, nil
 }
 
 type DesecError struct {
 	Detail string
+	Wait   *float64 `json:"wait"` // seconds, if the throttle reports it
 	Hold   int
 }
 
 func GenericAPIdelete(apiurl, apikey, authmethod string, usetls, verbose, debug bool,
 	extclient *http.Client) (int, []byte, error) {
+	return GenericAPIdeleteContext(context.Background(), apiurl, apikey, authmethod, usetls, verbose, debug,
+		extclient)
+}
+
+func GenericAPIdeleteContext(ctx context.Context, apiurl, apikey, authmethod string, usetls, verbose, debug bool,
+	extclient *http.Client) (int, []byte, error) {
+	ctx, cancel := apiContext(ctx)
+	defer cancel()
 
 	var client *http.Client
-	//    var roots *x509.CertPool
 
 	if extclient == nil {
 		if debug {
@@ -358,24 +425,14 @@ func GenericAPIdelete(apiurl, apikey, authmethod string, usetls, verbose, debug
 		}
 
 		if usetls {
-			//            caCertPEM, err := ioutil.ReadFile("/etc/axfr.net/certs/axfrCA.crt")
-			//            if err != nil {
-			//                log.Printf("Error reading CA file: %v\n", err)
-			//            }
-			//
-			//            roots = x509.NewCertPool()
-			//            ok := roots.AppendCertsFromPEM(caCertPEM)
-			//            if !ok {
-			//                log.Printf("Error parsing root cert: %v\n", err)
-			//            }
-
+			tlsconf, err := GenericTLSConfig()
+			if err != nil {
+				return 501, nil, err
+			}
 			client = &http.Client{
 				// CheckRedirect: redirectPolicyFunc,
 				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-						// RootCAs: roots,
-					},
+					TLSClientConfig: tlsconf,
 				},
 			}
 		} else {
@@ -397,7 +454,10 @@ func GenericAPIdelete(apiurl, apikey, authmethod string, usetls, verbose, debug
 		}
 	}
 
-	req, err := http.NewRequest(http.MethodDelete, apiurl, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, apiurl, nil)
+	if err != nil {
+		return 501, nil, err
+	}
 
 	if authmethod == "X-API-Key" {
 		req.Header.Add("X-API-Key", apikey)
@@ -413,9 +473,9 @@ func GenericAPIdelete(apiurl, apikey, authmethod string, usetls, verbose, debug
 	resp, err := client.Do(req)
 
 	if err != nil {
-		// handle error
-		fmt.Fprintf(os.Stdout, "GenericAPIdelete blew up. Error: %s\n", err)
-		os.Exit(1)
+		// may be a cancelled request or an expired deadline
+		fmt.Fprintf(os.Stdout, "GenericAPIdelete received error: %s\n", err)
+		return 501, nil, err
 	}
 
 	buf, err := ioutil.ReadAll(resp.Body)
@@ -428,6 +488,82 @@ func GenericAPIdelete(apiurl, apikey, authmethod string, usetls, verbose, debug
 	return resp.StatusCode, buf, err
 }
 
+// NewApiTransport returns the transport shared by all requests of one API client.
+// HTTP/2 is used when the server supports it (apiclient.http2 = false disables it) and
+// the number of connections per host is limited by apiclient.maxconnsperhost (default
+// 8), to stay within the connection policies of the providers.
+func NewApiTransport(tlsconf *tls.Config) *http.Transport {
+	maxconns := viper.GetInt("apiclient.maxconnsperhost")
+	if maxconns <= 0 {
+		maxconns = 8
+	}
+	http2 := true
+	if viper.IsSet("apiclient.http2") {
+		http2 = viper.GetBool("apiclient.http2")
+	}
+
+	t := &http.Transport{
+		TLSClientConfig:     tlsconf,
+		ForceAttemptHTTP2:   http2,
+		MaxConnsPerHost:     maxconns,
+		MaxIdleConnsPerHost: maxconns,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if !http2 {
+		// a non-nil, empty map disables the automatic HTTP/2 upgrade
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+var genericTLS struct {
+	sync.Mutex
+	mode, cafile string
+	conf         *tls.Config
+}
+
+// GenericTLSConfig returns the TLS configuration used by the GenericAPI* helpers, as
+// given by apiclient.tls.mode: "system" (default) verifies servers against the system
+// roots, "cafile" against the CAs in apiclient.tls.cafile and "insecure" does not verify
+// them at all. The configuration (and the CA pool) is cached until the settings change.
+func GenericTLSConfig() (*tls.Config, error) {
+	mode := viper.GetString("apiclient.tls.mode")
+	if mode == "" {
+		mode = "system"
+	}
+	cafile := viper.GetString("apiclient.tls.cafile")
+
+	genericTLS.Lock()
+	defer genericTLS.Unlock()
+	if genericTLS.conf != nil && genericTLS.mode == mode && genericTLS.cafile == cafile {
+		return genericTLS.conf, nil
+	}
+
+	var conf *tls.Config
+	switch mode {
+	case "system":
+		conf = &tls.Config{}
+	case "cafile":
+		pem, err := ioutil.ReadFile(cafile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read apiclient.tls.cafile: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in apiclient.tls.cafile %s", cafile)
+		}
+		conf = &tls.Config{RootCAs: pool}
+	case "insecure":
+		log.Printf("GenericTLSConfig: apiclient.tls.mode is insecure, server certificates are not verified")
+		conf = &tls.Config{InsecureSkipVerify: true}
+	default:
+		return nil, fmt.Errorf("Unknown apiclient.tls.mode: %s (must be system, cafile or insecure)", mode)
+	}
+	genericTLS.mode, genericTLS.cafile, genericTLS.conf = mode, cafile, conf
+	return conf, nil
+}
+
 // api client
 func NewClient(name, baseurl, apikey, authmethod,
 	rootcafile string, verbose, debug bool) *Api {
@@ -440,11 +576,9 @@ func NewClient(name, baseurl, apikey, authmethod,
 
 	if rootcafile == "insecure" {
 		api.Client = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
-			},
+			Transport: NewApiTransport(&tls.Config{
+				InsecureSkipVerify: true,
+			}),
 		}
 	} else {
 		rootCAPool := x509.NewCertPool()
@@ -461,11 +595,9 @@ func NewClient(name, baseurl, apikey, authmethod,
 		rootCAPool.AppendCertsFromPEM(rootCA)
 
 		api.Client = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					RootCAs: rootCAPool,
-				},
-			},
+			Transport: NewApiTransport(&tls.Config{
+				RootCAs: rootCAPool,
+			}),
 		}
 	}
 	// api.Client = &http.Client{}
@@ -482,6 +614,22 @@ func NewClient(name, baseurl, apikey, authmethod,
 	return &api
 }
 
+// apiContext returns ctx with the default deadline (apiclient.timeout, default 30
+// seconds) unless it already has a deadline of its own.
+func apiContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	timeout := viper.GetInt("apiclient.timeout")
+	if timeout <= 0 {
+		timeout = 30
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+}
+
 // request helper function
 func (api *Api) requestHelper(req *http.Request) (int, []byte, error) {
 
@@ -493,6 +641,8 @@ func (api *Api) requestHelper(req *http.Request) (int, []byte, error) {
 		req.Header.Add("X-API-Key", api.apiKey)
 	} else if api.Authmethod == "Authorization" {
 		req.Header.Add("Authorization", fmt.Sprintf("token %s", api.apiKey))
+	} else if api.Authmethod == "Bearer" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", api.apiKey))
 	} else {
 		log.Printf("Error: Client API Post: unknown auth method: %s. Aborting.\n",
 			api.Authmethod)
@@ -509,14 +659,10 @@ func (api *Api) requestHelper(req *http.Request) (int, []byte, error) {
 		log.Fatalf("api.requestHelper: Error: apikey not set.\n")
 	}
 
-	resp, err := api.Client.Do(req)
-
+	status, buf, err := api.sendWithRetry(req)
 	if err != nil {
-		return 501, nil, err
+		return status, buf, err
 	}
-
-	defer resp.Body.Close()
-	buf, err := ioutil.ReadAll(resp.Body)
 	if api.Debug {
 		var prettyJSON bytes.Buffer
 		error := json.Indent(&prettyJSON, buf, "", "  ")
@@ -529,11 +675,33 @@ func (api *Api) requestHelper(req *http.Request) (int, []byte, error) {
 	}
 
 	//not bothering to copy buf, this is a one-off
+	return status, buf, err
+}
+
+// send sends the request once.
+func (api *Api) send(req *http.Request) (int, []byte, error) {
+	start := time.Now()
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		recordHTTPRequest(api, req, 0, nil, start, err)
+		return 501, nil, err
+	}
+
+	defer resp.Body.Close()
+	api.LastHeader = resp.Header
+	buf, err := ioutil.ReadAll(resp.Body)
+	recordHTTPRequest(api, req, resp.StatusCode, buf, start, err)
 	return resp.StatusCode, buf, err
 }
 
 // api Post
 func (api *Api) Post(endpoint string, data []byte) (int, []byte, error) {
+	return api.PostContext(context.Background(), endpoint, data)
+}
+
+func (api *Api) PostContext(ctx context.Context, endpoint string, data []byte) (int, []byte, error) {
+	ctx, cancel := apiContext(ctx)
+	defer cancel()
 
 	if api.Debug {
 		var prettyJSON bytes.Buffer
@@ -547,7 +715,7 @@ func (api *Api) Post(endpoint string, data []byte) (int, []byte, error) {
 		//api.BaseUrl+endpoint, len(data), string(data))
 	}
 
-	req, err := http.NewRequest(http.MethodPost, api.BaseUrl+endpoint,
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api.BaseUrl+endpoint,
 		bytes.NewBuffer(data))
 	if err != nil {
 		log.Fatalf("Error from http.NewRequest: Error: %v", err)
@@ -557,8 +725,14 @@ func (api *Api) Post(endpoint string, data []byte) (int, []byte, error) {
 
 // api NoAuthPost
 func (api *Api) NoAuthPost(endpoint string, data []byte) (int, []byte, error) {
+	return api.NoAuthPostContext(context.Background(), endpoint, data)
+}
+
+func (api *Api) NoAuthPostContext(ctx context.Context, endpoint string, data []byte) (int, []byte, error) {
+	ctx, cancel := apiContext(ctx)
+	defer cancel()
 
-	req, err := http.NewRequest(http.MethodPost, api.BaseUrl+endpoint,
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api.BaseUrl+endpoint,
 		bytes.NewBuffer(data))
 	if err != nil {
 		log.Fatalf("Error from http.NewRequest: Error: %v", err)
@@ -571,13 +745,16 @@ func (api *Api) NoAuthPost(endpoint string, data []byte) (int, []byte, error) {
 			api.BaseUrl+endpoint, len(data), string(data))
 	}
 
+	start := time.Now()
 	resp, err := api.Client.Do(req)
 	if err != nil {
+		recordHTTPRequest(api, req, 0, nil, start, err)
 		return 501, nil, err
 	}
 
 	defer resp.Body.Close()
 	buf, err := ioutil.ReadAll(resp.Body)
+	recordHTTPRequest(api, req, resp.StatusCode, buf, start, err)
 
 	if api.Debug {
 		fmt.Printf("api.NoAuthPost: received %d bytes of response data: %v\n",
@@ -592,13 +769,19 @@ func (api *Api) NoAuthPost(endpoint string, data []byte) (int, []byte, error) {
 // not tested
 // func (api *Api) Delete(endpoint string, data []byte, opts ...string) (int, []byte, error) {
 func (api *Api) Delete(endpoint string) (int, []byte, error) {
+	return api.DeleteContext(context.Background(), endpoint)
+}
+
+func (api *Api) DeleteContext(ctx context.Context, endpoint string) (int, []byte, error) {
+	ctx, cancel := apiContext(ctx)
+	defer cancel()
 
 	if api.Debug {
 		fmt.Printf("api.Delete: posting to URL '%s'\n",
 			api.BaseUrl+endpoint)
 	}
 
-	req, err := http.NewRequest(http.MethodDelete, api.BaseUrl+endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, api.BaseUrl+endpoint, nil)
 	if err != nil {
 		log.Fatalf("Error from http.NewRequest: Error: %v", err)
 	}
@@ -608,12 +791,18 @@ func (api *Api) Delete(endpoint string) (int, []byte, error) {
 // api Get
 // not tested
 func (api *Api) Get(endpoint string) (int, []byte, error) {
+	return api.GetContext(context.Background(), endpoint)
+}
+
+func (api *Api) GetContext(ctx context.Context, endpoint string) (int, []byte, error) {
+	ctx, cancel := apiContext(ctx)
+	defer cancel()
 
 	if api.Debug {
 		fmt.Printf("api.Get: GET URL '%s'\n", api.BaseUrl+endpoint)
 	}
 
-	req, err := http.NewRequest(http.MethodGet, api.BaseUrl+endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, api.BaseUrl+endpoint, nil)
 	if err != nil {
 		log.Fatalf("Error from http.NewRequest: Error: %v", err)
 	}
@@ -623,13 +812,19 @@ func (api *Api) Get(endpoint string) (int, []byte, error) {
 // api Put
 // coming soon to a code base nere you.
 func (api *Api) Put(endpoint string, data []byte) (int, []byte, error) {
+	return api.PutContext(context.Background(), endpoint, data)
+}
+
+func (api *Api) PutContext(ctx context.Context, endpoint string, data []byte) (int, []byte, error) {
+	ctx, cancel := apiContext(ctx)
+	defer cancel()
 
 	if api.Debug {
 		fmt.Printf("api.Put: posting to URL '%s' %d bytes of data: %v\n",
 			api.BaseUrl+endpoint, len(data), string(data))
 	}
 
-	req, err := http.NewRequest(http.MethodPut, api.BaseUrl+endpoint,
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, api.BaseUrl+endpoint,
 		bytes.NewBuffer(data))
 	if err != nil {
 		log.Fatalf("Error from http.NewRequest: Error: %v", err)
@@ -0,0 +1,126 @@
+package music
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestExtractHoldPeriod(t *testing.T) {
+	cases := []struct {
+		retryafter string
+		body       string
+		want       int
+		wanterr    bool
+	}{
+		{"7", `{"detail": "Request was throttled. Expected available in 3 seconds."}`, 7, false},
+		{"", `{"detail": "Request was throttled. Expected available in 3 seconds."}`, 3, false},
+		{"", `{"detail": "Request was throttled. Expected available in 1 second."}`, 1, false},
+		{"", `{"detail": "Request was throttled. Expected available in 3 seconds.", "wait": 2.2}`, 3, false},
+		{"", `{"detail": "Request was throttled.", "wait": 0}`, 0, false},
+		{"", `{"detail": "Request was throttled."}`, DefaultHoldPeriod, true},
+		{"", `<html>Too Many Requests</html>`, DefaultHoldPeriod, true},
+		{"soon", ``, DefaultHoldPeriod, true},
+	}
+
+	for _, c := range cases {
+		header := http.Header{}
+		if c.retryafter != "" {
+			header.Set("Retry-After", c.retryafter)
+		}
+		got, err := ExtractHoldPeriod(header, []byte(c.body))
+		if got != c.want || (err != nil) != c.wanterr {
+			t.Errorf("ExtractHoldPeriod(%q, %q): got %d, %v wanted %d (error: %v)",
+				c.retryafter, c.body, got, err, c.want, c.wanterr)
+		}
+	}
+}
+
+func TestApiContext(t *testing.T) {
+	ctx, cancel := apiContext(nil)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Errorf("apiContext(nil): no default deadline")
+	}
+
+	deadline := time.Now().Add(time.Minute)
+	parent, pcancel := context.WithDeadline(context.Background(), deadline)
+	defer pcancel()
+	ctx, cancel = apiContext(parent)
+	defer cancel()
+	if d, _ := ctx.Deadline(); !d.Equal(deadline) {
+		t.Errorf("apiContext: deadline of the caller not kept: got %v wanted %v", d, deadline)
+	}
+}
+
+func TestApiRetry(t *testing.T) {
+	viper.Set("apiclient.retry.basedelay", 1)
+	defer viper.Set("apiclient.retry.basedelay", 0)
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	api := &Api{Client: srv.Client(), BaseUrl: srv.URL, apiKey: "secret", Authmethod: "X-API-Key"}
+	status, _, err := api.Post("/test", []byte(`{"name": "example.se"}`))
+	if err != nil || status != http.StatusOK || calls != 3 {
+		t.Errorf("Post: got status %d, error %v after %d calls, wanted 200 after 3", status, err, calls)
+	}
+
+	calls = -10 // fails more often than there are attempts
+	status, _, _ = api.Get("/test")
+	if status != http.StatusServiceUnavailable || calls != -7 {
+		t.Errorf("Get: got status %d after %d calls, wanted 503 after 3", status, calls+10)
+	}
+}
+
+func TestApiRetryDelay(t *testing.T) {
+	p := apiRetryPolicy{basedelay: time.Second, maxdelay: 5 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second,
+		3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := p.delay(attempt); got != want {
+			t.Errorf("delay(%d): got %v wanted %v", attempt, got, want)
+		}
+	}
+}
+
+func TestGenericTLSConfig(t *testing.T) {
+	defer viper.Set("apiclient.tls.mode", "")
+
+	viper.Set("apiclient.tls.mode", "")
+	conf, err := GenericTLSConfig()
+	if err != nil || conf.InsecureSkipVerify || conf.RootCAs != nil {
+		t.Errorf("GenericTLSConfig: default mode does not use the system roots: %v", err)
+	}
+	if again, _ := GenericTLSConfig(); again != conf {
+		t.Errorf("GenericTLSConfig: configuration not cached")
+	}
+
+	viper.Set("apiclient.tls.mode", "insecure")
+	if conf, err = GenericTLSConfig(); err != nil || !conf.InsecureSkipVerify {
+		t.Errorf("GenericTLSConfig: insecure mode verifies certificates: %v", err)
+	}
+
+	viper.Set("apiclient.tls.mode", "cafile")
+	viper.Set("apiclient.tls.cafile", "/nonexistent/ca.pem")
+	defer viper.Set("apiclient.tls.cafile", "")
+	if _, err = GenericTLSConfig(); err == nil {
+		t.Errorf("GenericTLSConfig: missing CA file not reported")
+	}
+
+	viper.Set("apiclient.tls.mode", "bogus")
+	if _, err = GenericTLSConfig(); err == nil {
+		t.Errorf("GenericTLSConfig: unknown mode not reported")
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// API retries. Signer backends occasionally fail with a 502/503/504 or a network error
+// that is gone a moment later. Instead of turning such a failure straight into a stop
+// reason, Api.requestHelper retries the request according to the apiclient.retry
+// policy: at most attempts tries in total, with an exponentially growing delay
+// (basedelay, 2*basedelay, 4*basedelay, ... at most maxdelay) randomized by +/- jitter.
+// A Retry-After header in the response is honoured if it asks for a longer delay. No
+// retry outlives the deadline of the request. Rate limiting (429) is not retried here,
+// the updaters have their own hold-down for that.
+
+type apiRetryPolicy struct {
+	attempts  int
+	basedelay time.Duration
+	maxdelay  time.Duration
+	jitter    float64 // fraction of the delay
+	statuses  map[int]bool
+}
+
+func getApiRetryPolicy() apiRetryPolicy {
+	p := apiRetryPolicy{
+		attempts:  viper.GetInt("apiclient.retry.attempts"),
+		basedelay: time.Duration(viper.GetInt("apiclient.retry.basedelay")) * time.Millisecond,
+		maxdelay:  time.Duration(viper.GetInt("apiclient.retry.maxdelay")) * time.Millisecond,
+		jitter:    viper.GetFloat64("apiclient.retry.jitter"),
+		statuses:  map[int]bool{},
+	}
+	if p.attempts < 1 {
+		p.attempts = 3
+	}
+	if p.basedelay <= 0 {
+		p.basedelay = 500 * time.Millisecond
+	}
+	if p.maxdelay <= 0 {
+		p.maxdelay = 10 * time.Second
+	}
+	if p.jitter < 0 || p.jitter > 1 {
+		p.jitter = 0.2
+	}
+	statuses := viper.GetIntSlice("apiclient.retry.statuses")
+	if len(statuses) == 0 {
+		statuses = []int{502, 503, 504}
+	}
+	for _, s := range statuses {
+		p.statuses[s] = true
+	}
+	return p
+}
+
+// retryable returns true if a request that ended with status and err should be tried again.
+func (p apiRetryPolicy) retryable(status int, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return p.statuses[status]
+}
+
+// delay returns the time to wait after the attempt (1, 2, ...) failed.
+func (p apiRetryPolicy) delay(attempt int) time.Duration {
+	d := p.basedelay
+	for i := 1; i < attempt && d < p.maxdelay; i++ {
+		d *= 2
+	}
+	if d > p.maxdelay {
+		d = p.maxdelay
+	}
+	if p.jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.jitter * float64(d))
+	}
+	return d
+}
+
+func (api *Api) sendWithRetry(req *http.Request) (int, []byte, error) {
+	p := getApiRetryPolicy()
+	for attempt := 1; ; attempt++ {
+		status, buf, err := api.send(req)
+		if attempt >= p.attempts || !p.retryable(status, err) {
+			return status, buf, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return status, buf, err // the body cannot be sent again
+		}
+
+		delay := p.delay(attempt)
+		if err == nil {
+			if secs, ok := ParseRetryAfter(api.LastHeader.Get("Retry-After")); ok &&
+				time.Duration(secs)*time.Second > delay {
+				delay = time.Duration(secs) * time.Second
+			}
+		}
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return status, buf, err
+		}
+		reason := http.StatusText(status)
+		if err != nil {
+			reason = err.Error()
+		}
+		log.Printf("api.requestHelper: %s %s: %s. Retrying in %v (attempt %d of %d).",
+			req.Method, req.URL.String(), reason, delay.Round(time.Millisecond), attempt+1, p.attempts)
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return status, buf, err
+		}
+		if req.GetBody != nil {
+			body, gerr := req.GetBody()
+			if gerr != nil {
+				return status, buf, err
+			}
+			req.Body = body
+		}
+	}
+}
@@ -24,6 +24,16 @@ type APIresponse struct {
 
 type ShowPost struct {
 	Command	string
+	Probe	bool	// state: check signer health
+	Zone	string	// dryrun, propagation, observer, validation, tasks: only this zone
+	PerZone	bool	// propagation: statistics per signer and zone
+	Signer		string	// keys: only this signer
+	Algorithm	int	// keys: only this DNSSEC algorithm
+	All		bool	// keys: include keys no longer published
+	Scan		bool	// keys: fetch the DNSKEY RRsets of all zones first
+	Backend		string	// requests: only this backend
+	Since		time.Time	// requests: only requests made at or after this time
+	Clear		bool	// dryrun: forget the listed changes once reviewed
 }
 
 type ShowResponse struct {
@@ -31,6 +41,22 @@ type ShowResponse struct {
 	Message		string
 	ApiData		[]string
 	Updaters	map[string]bool
+	Capabilities	map[string]UpdaterCapabilities
+	State		*StateExport
+	SignerHealth	[]SignerHealth
+	DryRunChanges	[]DryRunChange
+	Propagation	[]PropagationStats
+	Observations	[]ZoneObservation
+	Backpressure	*BackpressureStatus
+	Keys		[]InventoryKey
+	Validations	[]ValidationResult
+	Requests	[]BackendRequest
+	Tasks		[]ScheduledTask
+	Ops		[]InFlightOp
+	Queues		[]OpQueueStats
+	Preconditions	[]PreconditionCheck
+	Shards		[]ShardMember
+	PendingOps	[]PendingOp
 }
 
 type ShowAPIresponse struct {
@@ -90,8 +116,27 @@ type ZonePost struct {
 	FSM          string
 	FSMSigner    string
 	FsmNextState string
+	StartAt      time.Time         // startprocess: zero = now
+	Params       map[string]string // startprocess: process parameters
+	Reason       string            // freeze
+	Throttle     string            // throttle: "" = default class
 	Metakey      string
 	Metavalue    string
+	Contact      ZoneContact
+	ContactClear []string // contact: fields to remove ("email", "webhook")
+	Signers      []string // desired signer set
+	Approval     int      // approve, deny
+	Approver     string   // approve, deny: ignored for OIDC users
+	NSes         []string // external-ns
+	Create       bool     // discover: add signers for unknown name servers
+	Run          int      // evidence: 0 = list runs; annotate: 0 = the zone; annotations: 0 = all
+	Annotation   string   // annotate
+	AnnotationID int      // annotations: with Remove
+	Remove       bool     // managed-names, children: remove Owner/RRtype (or child Owner); annotations
+	Scan         bool     // children: update the DS RRsets of the children now
+	Limit        int      // updates, metrics: max number of entries to list
+	NewName      string   // rename
+	Identity     string   // two-person rule: ignored for OIDC users
 }
 
 type DNSRecords []dns.RR
@@ -107,12 +152,47 @@ type ZoneResponse struct {
 	Zones  map[string]Zone
 	RRsets map[string][]string // map[signer][]DNSRecords
 	RRset  []string            // broken
+	Approvals []Approval
+	NSes      []ZoneNS
+	Discovery *DiscoveryResult
+	Evidence  []EvidenceRun
+	Bundle    string // evidence: JWS compact serialization
+	Measurements []AtlasMeasurement
+	ManagedNames []ManagedName
+	Children     []ChildDelegation
+	Updates      []UpdateRecord
+	Metrics      []ProcessMetric
+	Explanation  *ZoneExplanation
+	SpecialNames []SpecialRRset // managed-names: wildcard and special labels, not managed
+	Scorecard    *Scorecard
+	Annotations  []ZoneAnnotation // status, evidence, annotations
+	Stuck        *StuckTransition // status: the zone is stuck in a transition
 }
 
 type SignerPost struct {
 	Command         string
 	Signer		Signer
 	SignerGroup	string
+	NewAuth		AuthData // rotate-tsig, update-auth
+	Match		CredentialMatch // update-auth: the signers to update
+	Notify		bool     // rotate-tsig: wait for operator to retire old key
+	View		SignerView // add-view, delete-view
+	TestZone	string     // verify
+	MaxZones	int        // set-limit
+	Proxy		string     // set-proxy: socks5://... | ssh://... | "" (none)
+	SSHKey		string     // set-proxy: private key file for ssh jump hosts
+	Include		FileInclude // set-include
+	Zone		string      // set-token
+	Token		string      // set-token: "" = use the signer credentials for the zone
+	Anycast		bool        // set-anycast
+	TLS		SignerTLS   // set-tls
+	Transport	string      // set-transport: udp | tcp | tls | https
+	DoHURL		string      // set-transport https
+	SIG0		SignerSIG0  // set-sig0
+	NotifyTargets	[]string    // set-notify: host[:port] | secondaries, none = off
+	Addresses	[]string    // set-addresses, in order of preference
+	Template	string      // add: name of signer template, if any
+	Identity	string      // two-person rule: ignored for OIDC users
 }
 
 type SignerResponse struct {
@@ -123,11 +203,16 @@ type SignerResponse struct {
 	ErrorMsg string
 	Msg      string
 	Signers  map[string]Signer
+	Verification *SignerVerification
+	Templates    []SignerTemplate
 }
 
 type SignerGroupPost struct {
-	Command string
-	Name    string
+	Command  string
+	Name     string
+	Snapshot int    // rollback: snapshot to roll back to; diff: snapshot to compare from
+	To       int    // diff: snapshot to compare with, 0 = the current configuration
+	Identity string // two-person rule: ignored for OIDC users
 }
 
 type SignerGroupResponse struct {
@@ -136,6 +221,10 @@ type SignerGroupResponse struct {
 	Client       string
 	Message      string
 	SignerGroups map[string]SignerGroup
+	Error        bool
+	ErrorMsg     string
+	Snapshots    []SignerGroupSnapshot
+	Diff         []string
 }
 
 type Api struct {
@@ -146,6 +235,7 @@ type Api struct {
 	Authmethod string
 	Verbose    bool
 	Debug      bool
+	LastHeader http.Header // headers of the latest response (e.g. Retry-After)
 
 	// deSEC stuff
 	Email    string
@@ -153,9 +243,78 @@ type Api struct {
 	TokViper *viper.Viper
 }
 
+type PolicyPost struct {
+	Command     string
+	Policy      Policy
+	Zone        string
+	SignerGroup string
+}
+
+type PolicyResponse struct {
+	Time     time.Time
+	Status   int
+	Client   string
+	Error    bool
+	ErrorMsg string
+	Msg      string
+	Policies map[string]Policy
+}
+
+type ParentPost struct {
+	Command string // list | probe
+	Zone    string // probe: test zone
+	Kind    string // probe: cds | csync
+}
+
+type ParentResponse struct {
+	Time     time.Time
+	Client   string
+	Error    bool
+	ErrorMsg string
+	Msg      string
+	Profiles []ParentProfile
+	Probes   []ParentProbe
+}
+
+type GitOpsPost struct {
+	Command string // plan | apply
+}
+
+type GitOpsResponse struct {
+	Time     time.Time
+	Client   string
+	Error    bool
+	ErrorMsg string
+	Msg      string
+	Output   []string
+}
+
+type UpsertPost struct {
+	Kind        string // "Zone" | "SignerGroup"
+	Name        string
+	Generation  int64
+	Zone        ZoneDef
+	SignerGroup SignerGroupDef
+}
+
+type UpsertResponse struct {
+	Time               time.Time
+	Client             string
+	Error              bool
+	ErrorMsg           string
+	Msg                string
+	ObservedGeneration int64
+	Ready              bool   // true when the object has converged
+	State              string // zone: current process and state
+	Changes            []string
+}
+
 type ProcessPost struct {
 	Command string
 	Process string
+	Scope   string // pause, resume: engine, process or signer
+	Signer  string
+	Reason  string
 }
 
 type ProcessResponse struct {
@@ -167,9 +326,11 @@ type ProcessResponse struct {
 	Msg       string
 	Processes []Process
 	Graph     string
+	Pauses    []EnginePause
 }
 
 type Process struct {
-	Name string
-	Desc string
+	Name   string
+	Desc   string
+	Params map[string]FSMParam
 }
@@ -0,0 +1,288 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Approval gates. Transitions listed in approvals.gates ("process" for the first
+// transition of the process, "process:state" for the transition into state) are not
+// executed until an authorized approver has approved them. When a gated transition is
+// first attempted an approval request is recorded and routed to the zone contact (see
+// notifications.go), with a signed link if approvals.baseurl and approvals.secret are
+// configured. Approvals are given (or denied) via the API or via the signed link and
+// remain valid for as long as the zone stays in the process.
+
+const ZoneEventApproval = "approval-requested"
+
+type Approval struct {
+	ID        int
+	Zone      string
+	Process   string
+	FromState string
+	ToState   string
+	Status    string // "pending" | "approved" | "denied"
+	Requested time.Time
+	Approver  string
+	Decided   time.Time
+}
+
+// approvalGated returns true if the transition from -> to in process requires approval.
+func approvalGated(process FSM, from, to string) bool {
+	for _, g := range viper.GetStringSlice("approvals.gates") {
+		p := strings.SplitN(g, ":", 2)
+		if p[0] != process.Name {
+			continue
+		}
+		if len(p) == 1 && from == process.InitialState {
+			return true
+		}
+		if len(p) == 2 && p[1] == to {
+			return true
+		}
+	}
+	return false
+}
+
+func approvalToken(a Approval) string {
+	mac := hmac.New(sha256.New, []byte(viper.GetString("approvals.secret")))
+	fmt.Fprintf(mac, "%d|%s|%s|%s|%s|%d", a.ID, a.Zone, a.Process, a.FromState, a.ToState,
+		a.Requested.Unix())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ApprovalLink returns the signed link for the approval, or "" if links are not configured.
+func ApprovalLink(a Approval) string {
+	base := viper.GetString("approvals.baseurl")
+	if base == "" || viper.GetString("approvals.secret") == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/approve?id=%d&token=%s", strings.TrimSuffix(base, "/"), a.ID,
+		url.QueryEscape(approvalToken(a)))
+}
+
+func approvalLinkValidity() time.Duration {
+	hours := viper.GetInt("approvals.linkvalidity")
+	if hours <= 0 {
+		hours = 72
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func scanApproval(row interface{ Scan(...interface{}) error }) (Approval, error) {
+	var a Approval
+	var requested, decided string
+	err := row.Scan(&a.ID, &a.Zone, &a.Process, &a.FromState, &a.ToState, &a.Status,
+		&requested, &a.Approver, &decided)
+	if err != nil {
+		return a, err
+	}
+	a.Requested, _ = time.Parse(layout, requested)
+	if decided != "" {
+		a.Decided, _ = time.Parse(layout, decided)
+	}
+	return a, nil
+}
+
+const approvalColumns = `id, zone, process, fromstate, tostate, status,
+COALESCE(requested, datetime('now')), approver, COALESCE(decided, '')`
+
+// checkApproval returns true if the transition of z to nextstate may proceed. If not,
+// the returned string explains why (and an approval request is made, if needed).
+func (mdb *MusicDB) checkApproval(tx *sql.Tx, z *Zone, nextstate string) (bool, string, error) {
+	process, exist := mdb.FSMlist[z.FSM]
+	if !exist || !approvalGated(process, z.State, nextstate) {
+		return true, "", nil
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("checkApproval: Error from mdb.StartTransaction(): %v\n", err)
+		return false, "", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT " + approvalColumns +
+		" FROM approvals WHERE zone=? AND process=? AND fromstate=? AND tostate=?"
+	a, err := scanApproval(tx.QueryRow(sqlq, z.Name, z.FSM, z.State, nextstate))
+	switch {
+	case err == sql.ErrNoRows && z.Explaining():
+		return false, fmt.Sprintf("Transition to '%s' needs an approval, not yet requested", nextstate), nil
+
+	case err == sql.ErrNoRows:
+		a, err = mdb.requestApproval(tx, z, nextstate)
+		if err != nil {
+			return false, "", err
+		}
+		return false, fmt.Sprintf("Waiting for approval %d of transition to '%s'", a.ID, nextstate), nil
+
+	case CheckSQLError("checkApproval", sqlq, err, false):
+		return false, "", err
+	}
+
+	switch a.Status {
+	case "approved":
+		return true, "", nil
+	case "denied":
+		return false, fmt.Sprintf("Transition to '%s' denied by %s", nextstate, a.Approver), nil
+	default:
+		return false, fmt.Sprintf("Waiting for approval %d of transition to '%s'", a.ID, nextstate), nil
+	}
+}
+
+func (mdb *MusicDB) requestApproval(tx *sql.Tx, z *Zone, nextstate string) (Approval, error) {
+	a := Approval{
+		Zone:      z.Name,
+		Process:   z.FSM,
+		FromState: z.State,
+		ToState:   nextstate,
+		Status:    "pending",
+		Requested: time.Now().UTC().Truncate(time.Second),
+	}
+
+	const sqlq = `
+INSERT INTO approvals(zone, process, fromstate, tostate, status, requested, approver)
+VALUES (?, ?, ?, ?, ?, ?, '')`
+	res, err := tx.Exec(sqlq, a.Zone, a.Process, a.FromState, a.ToState, a.Status,
+		a.Requested.Format(layout))
+	if CheckSQLError("requestApproval", sqlq, err, false) {
+		return a, err
+	}
+	id, _ := res.LastInsertId()
+	a.ID = int(id)
+
+	msg := fmt.Sprintf("Approval %d requested for the transition from '%s' to '%s' in process '%s'.\n"+
+		"Approve with: music-cli zone approve -z %s --id %d", a.ID, a.FromState, a.ToState,
+		a.Process, a.Zone, a.ID)
+	if link := ApprovalLink(a); link != "" {
+		msg += fmt.Sprintf("\nor via: %s (valid for %v)", link, approvalLinkValidity())
+	}
+	log.Printf("Zone %s: %s", z.Name, msg)
+	mdb.NotifyZoneContact(z, ZoneEventApproval, msg)
+	return a, nil
+}
+
+// approverAllowed checks the approver against approvals.approvers (if configured).
+func approverAllowed(approver string) bool {
+	approvers := viper.GetStringSlice("approvals.approvers")
+	if len(approvers) == 0 {
+		return true
+	}
+	for _, a := range approvers {
+		if a == approver {
+			return true
+		}
+	}
+	return false
+}
+
+// ZoneDecideApproval approves (or denies) the pending approval id of the zone.
+func (mdb *MusicDB) ZoneDecideApproval(tx *sql.Tx, z *Zone, id int, approver string,
+	approve bool) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+	if approver == "" {
+		return "", fmt.Errorf("Approver not specified.")
+	}
+	if !approverAllowed(approver) {
+		return "", fmt.Errorf("%s is not an authorized approver.", approver)
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneDecideApproval: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	status := "denied"
+	if approve {
+		status = "approved"
+	}
+	const sqlq = `
+UPDATE approvals SET status=?, approver=?, decided=datetime('now')
+WHERE id=? AND zone=? AND status='pending'`
+	res, err := tx.Exec(sqlq, status, approver, id, z.Name)
+	if CheckSQLError("ZoneDecideApproval", sqlq, err, false) {
+		return "", err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return "", fmt.Errorf("Zone %s has no pending approval %d.", z.Name, id)
+	}
+	log.Printf("Zone %s: approval %d %s by %s", z.Name, id, status, approver)
+	return fmt.Sprintf("Zone %s: approval %d %s by %s.", z.Name, id, status, approver), nil
+}
+
+// DecideApprovalByLink approves (or denies) an approval via its signed link.
+func (mdb *MusicDB) DecideApprovalByLink(id int, token string, approve bool) (string, error) {
+	if viper.GetString("approvals.secret") == "" {
+		return "", fmt.Errorf("Approval links are not enabled.")
+	}
+
+	const sqlq = "SELECT " + approvalColumns + " FROM approvals WHERE id=?"
+	a, err := scanApproval(mdb.db.QueryRow(sqlq, id))
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("Unknown approval %d.", id)
+	}
+	if CheckSQLError("DecideApprovalByLink", sqlq, err, false) {
+		return "", err
+	}
+	if !hmac.Equal([]byte(token), []byte(approvalToken(a))) {
+		return "", fmt.Errorf("Invalid approval link.")
+	}
+	if time.Since(a.Requested) > approvalLinkValidity() {
+		return "", fmt.Errorf("The approval link has expired.")
+	}
+	return mdb.ZoneDecideApproval(nil, &Zone{Name: a.Zone, Exists: true}, id, "signed link",
+		approve)
+}
+
+func (mdb *MusicDB) ListZoneApprovals(tx *sql.Tx, z *Zone) ([]Approval, error) {
+	var al []Approval
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListZoneApprovals: Error from mdb.StartTransaction(): %v\n", err)
+		return al, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT " + approvalColumns + " FROM approvals WHERE zone=? ORDER BY id"
+	rows, err := tx.Query(sqlq, z.Name)
+	if CheckSQLError("ListZoneApprovals", sqlq, err, false) {
+		return al, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			log.Fatalf("ListZoneApprovals: Error from rows.Scan(): %v", err)
+		}
+		al = append(al, a)
+	}
+	return al, nil
+}
+
+// clearApprovals forgets all approvals of the zone, e.g. when it leaves its process.
+func (mdb *MusicDB) clearApprovals(tx *sql.Tx, zone string) error {
+	const sqlq = "DELETE FROM approvals WHERE zone=?"
+	_, err := tx.Exec(sqlq, zone)
+	if CheckSQLError("clearApprovals", sqlq, err, false) {
+		return err
+	}
+	return nil
+}
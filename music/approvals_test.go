@@ -0,0 +1,33 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestApprovalGated(t *testing.T) {
+	viper.Set("approvals.gates", []string{"add-signer", "remove-signer:parent-ns-synced"})
+	defer viper.Set("approvals.gates", nil)
+
+	add := FSM{Name: "add-signer", InitialState: "signers-unsynced"}
+	remove := FSM{Name: "remove-signer", InitialState: "signers-unsynced"}
+	other := FSM{Name: "zsk-rollover", InitialState: "signers-unsynced"}
+
+	for _, tc := range []struct {
+		process  FSM
+		from, to string
+		gated    bool
+	}{
+		{add, "signers-unsynced", "dnskeys-synced", true},
+		{add, "dnskeys-synced", "cds-added", false},
+		{remove, "signers-unsynced", "nses-synced", false},
+		{remove, "csync-added", "parent-ns-synced", true},
+		{other, "signers-unsynced", "dnskeys-synced", false},
+	} {
+		if got := approvalGated(tc.process, tc.from, tc.to); got != tc.gated {
+			t.Errorf("approvalGated(%s, %s, %s) = %v, want %v", tc.process.Name, tc.from, tc.to,
+				got, tc.gated)
+		}
+	}
+}
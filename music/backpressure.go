@@ -0,0 +1,201 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Daemon-wide backpressure. Where the circuit breaker (breaker.go) protects against a
+// single broken signer, backpressure protects against an upstream outage that affects
+// many of them, or the parents. The outcome of all signer operations and all queries
+// to parents is tracked over the latest backpressure.window seconds. When the
+// aggregate error rate exceeds backpressure.slowdown the FSM engine only runs every
+// backpressure.factor ticks, and when it exceeds backpressure.pause no new actions are
+// taken at all. Pre-conditions (i.e. the read checks) are still evaluated while paused,
+// so that the zones pick up where they left off once the errors go away.
+
+const (
+	BackpressureNormal = "normal"
+	BackpressureSlow   = "slow"
+	BackpressurePaused = "paused"
+)
+
+type BackpressureStatus struct {
+	Level     string
+	Since     time.Time
+	Ops       int // operations in the window
+	Failures  int
+	ErrorRate float64
+	LastError string
+}
+
+type bpResult struct {
+	t      time.Time
+	failed bool
+}
+
+var backpressure = struct {
+	sync.Mutex
+	results   []bpResult // oldest first
+	level     string
+	since     time.Time
+	lastError string
+	parents   map[string]bool // addresses of parents, see registerParent()
+}{level: BackpressureNormal, parents: map[string]bool{}}
+
+func backpressureConfig() (window time.Duration, minops int, slowdown, pause float64) {
+	window = time.Duration(viper.GetInt("backpressure.window")) * time.Second
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	minops = viper.GetInt("backpressure.minops")
+	if minops < 1 {
+		minops = 20
+	}
+	slowdown = viper.GetFloat64("backpressure.slowdown")
+	if slowdown <= 0 {
+		slowdown = 0.25
+	}
+	pause = viper.GetFloat64("backpressure.pause")
+	if pause <= 0 {
+		pause = 0.5
+	}
+	return
+}
+
+// registerParent marks addr as the address of a parent, so that the queries sent to
+// it are counted by DnsExchange.
+func registerParent(addr string) {
+	backpressure.Lock()
+	backpressure.parents[addr] = true
+	backpressure.Unlock()
+}
+
+func isParent(addr string) bool {
+	backpressure.Lock()
+	defer backpressure.Unlock()
+	return backpressure.parents[addr]
+}
+
+// backpressureQuery records the outcome of a query to a parent. SERVFAIL counts as an
+// error; other RCODEs are answers.
+func backpressureQuery(addr string, r *dns.Msg, err error) {
+	if !isParent(addr) {
+		return
+	}
+	if err == nil && r != nil && r.Rcode == dns.RcodeServerFailure {
+		err = fmt.Errorf("Parent %s: SERVFAIL", addr)
+	}
+	backpressureRecord(err)
+}
+
+func backpressureRecord(err error) {
+	if !viper.GetBool("backpressure.active") {
+		return
+	}
+
+	backpressure.Lock()
+	defer backpressure.Unlock()
+
+	backpressure.results = append(backpressure.results, bpResult{t: time.Now(), failed: err != nil})
+	if err != nil {
+		backpressure.lastError = err.Error()
+	}
+	backpressureEvaluate()
+}
+
+// backpressureEvaluate drops the results that have left the window and updates the
+// level. Must be called with the lock held.
+func backpressureEvaluate() {
+	window, minops, slowdown, pause := backpressureConfig()
+	now := time.Now()
+
+	i := 0
+	for i < len(backpressure.results) && now.Sub(backpressure.results[i].t) > window {
+		i++
+	}
+	backpressure.results = backpressure.results[i:]
+
+	ops, failures := backpressureCount()
+	level := BackpressureNormal
+	if ops >= minops {
+		rate := float64(failures) / float64(ops)
+		switch {
+		case rate > pause:
+			level = BackpressurePaused
+		case rate > slowdown:
+			level = BackpressureSlow
+		}
+	}
+	if level == backpressure.level {
+		return
+	}
+
+	old := backpressure.level
+	backpressure.level, backpressure.since = level, now
+	msg := fmt.Sprintf("Backpressure %s -> %s: %d of %d operations in the last %v failed (latest error: %s)",
+		old, level, failures, ops, window, backpressure.lastError)
+	log.Print(msg)
+	if level != BackpressureNormal {
+		go ReportError("backpressure", fmt.Errorf("%s", msg), map[string]string{"level": level})
+	}
+}
+
+// backpressureCount must be called with the lock held.
+func backpressureCount() (ops, failures int) {
+	for _, r := range backpressure.results {
+		if r.failed {
+			failures++
+		}
+	}
+	return len(backpressure.results), failures
+}
+
+func GetBackpressure() BackpressureStatus {
+	backpressure.Lock()
+	defer backpressure.Unlock()
+
+	backpressureEvaluate()
+	bs := BackpressureStatus{
+		Level:     backpressure.level,
+		Since:     backpressure.since,
+		LastError: backpressure.lastError,
+	}
+	bs.Ops, bs.Failures = backpressureCount()
+	if bs.Ops > 0 {
+		bs.ErrorRate = float64(bs.Failures) / float64(bs.Ops)
+	}
+	return bs
+}
+
+// BackpressureFactor returns how many engine ticks make up one run at the current
+// level: 1 normally, backpressure.factor when slowed down.
+func BackpressureFactor() int {
+	if GetBackpressure().Level != BackpressureSlow {
+		return 1
+	}
+	factor := viper.GetInt("backpressure.factor")
+	if factor < 2 {
+		factor = 4
+	}
+	return factor
+}
+
+// backpressurePaused returns a stop reason if no new actions may be taken.
+func backpressurePaused() (string, bool) {
+	bs := GetBackpressure()
+	if bs.Level != BackpressurePaused {
+		return "", false
+	}
+	return fmt.Sprintf("Paused: %.0f%% of the latest operations to signers and parents failed (since %s)",
+		100*bs.ErrorRate, bs.Since.Format(time.RFC3339)), true
+}
@@ -0,0 +1,183 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Per-signer circuit breaker. The outcome of every update and fetch is tracked per
+// signer over a sliding window of the latest operations. When the error rate in the
+// window exceeds signers.breaker.threshold the breaker opens and all operations to
+// the signer fail immediately until signers.breaker.cooldown has passed. While the
+// breaker is open the FSM engine leaves the zones that depend on the signer alone,
+// instead of retrying against a broken backend.
+
+type SignerHealth struct {
+	Signer    string
+	Ops       int // operations in the window
+	Failures  int
+	ErrorRate float64
+	Open      bool
+	OpenUntil time.Time
+	LastError string
+}
+
+type signerBreaker struct {
+	results   []bool // true = failure, oldest first
+	openUntil time.Time
+	lastError string
+}
+
+var breakers = struct {
+	sync.Mutex
+	m map[string]*signerBreaker
+}{m: map[string]*signerBreaker{}}
+
+func breakerConfig() (window, minops int, threshold float64, cooldown time.Duration) {
+	window = viper.GetInt("signers.breaker.window")
+	if window < 1 {
+		window = 20
+	}
+	minops = viper.GetInt("signers.breaker.minops")
+	if minops < 1 {
+		minops = 5
+	}
+	threshold = viper.GetFloat64("signers.breaker.threshold")
+	if threshold <= 0 {
+		threshold = 0.5
+	}
+	cooldown = time.Duration(viper.GetInt("signers.breaker.cooldown")) * time.Second
+	if cooldown <= 0 {
+		cooldown = 5 * time.Minute
+	}
+	// with sharding each instance sees its share of the operations to a signer
+	window, minops = shardShare(window), shardShare(minops)
+	return
+}
+
+// SignerBreakerOpen returns the time until which operations to the signer are paused.
+func SignerBreakerOpen(signer string) (time.Time, bool) {
+	breakers.Lock()
+	defer breakers.Unlock()
+	if b, ok := breakers.m[signer]; ok && time.Now().Before(b.openUntil) {
+		return b.openUntil, true
+	}
+	return time.Time{}, false
+}
+
+func breakerRecord(signer string, err error) {
+	if !viper.GetBool("signers.breaker.active") || errors.Is(err, ErrQueueFull) {
+		return // a full queue says nothing about the signer
+	}
+	window, minops, threshold, cooldown := breakerConfig()
+
+	breakers.Lock()
+	defer breakers.Unlock()
+	b, ok := breakers.m[signer]
+	if !ok {
+		b = &signerBreaker{}
+		breakers.m[signer] = b
+	}
+	b.results = append(b.results, err != nil)
+	if len(b.results) > window {
+		b.results = b.results[len(b.results)-window:]
+	}
+	if err != nil {
+		b.lastError = err.Error()
+	}
+
+	failures := 0
+	for _, failed := range b.results {
+		if failed {
+			failures++
+		}
+	}
+	if len(b.results) >= minops && float64(failures)/float64(len(b.results)) > threshold {
+		b.openUntil = time.Now().Add(cooldown)
+		log.Printf("Circuit breaker for signer %s OPEN until %s: %d of the latest %d operations failed (latest error: %s)",
+			signer, b.openUntil.Format(time.RFC3339), failures, len(b.results), b.lastError)
+		b.results = nil // after the cool-down the signer starts with a clean slate
+	}
+}
+
+// ListSignerHealth returns the error rate and breaker state of all signers that have
+// been used since musicd started.
+func ListSignerHealth() []SignerHealth {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	var shl []SignerHealth
+	for signer, b := range breakers.m {
+		sh := SignerHealth{
+			Signer:    signer,
+			Ops:       len(b.results),
+			LastError: b.lastError,
+		}
+		for _, failed := range b.results {
+			if failed {
+				sh.Failures++
+			}
+		}
+		if sh.Ops > 0 {
+			sh.ErrorRate = float64(sh.Failures) / float64(sh.Ops)
+		}
+		if time.Now().Before(b.openUntil) {
+			sh.Open, sh.OpenUntil = true, b.openUntil
+		}
+		shl = append(shl, sh)
+	}
+	sort.Slice(shl, func(i, j int) bool { return shl[i].Signer < shl[j].Signer })
+	return shl
+}
+
+func breakerError(signer string, until time.Time) error {
+	return fmt.Errorf("Signer %s: circuit breaker open until %s, operation not attempted",
+		signer, until.Format(time.RFC3339))
+}
+
+// BreakerUpdater wraps an updater and tracks (and, when the breaker is open, stops)
+// the operations to each signer.
+type BreakerUpdater struct {
+	Updater
+}
+
+func (u *BreakerUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	if until, open := SignerBreakerOpen(signer.Name); open {
+		return breakerError(signer.Name, until)
+	}
+	err := u.Updater.Update(signer, zone, fqdn, inserts, removes)
+	breakerRecord(signer.Name, err)
+	backpressureRecord(err)
+	return err
+}
+
+func (u *BreakerUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	if until, open := SignerBreakerOpen(signer.Name); open {
+		return breakerError(signer.Name, until)
+	}
+	err := u.Updater.RemoveRRset(signer, zone, fqdn, rrsets)
+	breakerRecord(signer.Name, err)
+	backpressureRecord(err)
+	return err
+}
+
+func (u *BreakerUpdater) FetchRRset(signer *Signer, zone, fqdn string, rrtype uint16) (error, []dns.RR) {
+	if until, open := SignerBreakerOpen(signer.Name); open {
+		return breakerError(signer.Name, until), []dns.RR{}
+	}
+	err, rrs := u.Updater.FetchRRset(signer, zone, fqdn, rrtype)
+	breakerRecord(signer.Name, err)
+	backpressureRecord(err)
+	return err, rrs
+}
@@ -0,0 +1,152 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Bulk credential updates. When a provider rotates the TSIG key used by many signers,
+// "signer update-auth" replaces the key of all DDNS signers that match an update method
+// and/or an address pattern in one go. The new key is verified against every matching
+// signer (as in step 2 of the TSIG rotation) before anything is changed: if it does not
+// work for all of them, no signer is updated. Each update is recorded as a completed TSIG
+// rotation.
+
+type CredentialMatch struct {
+	Method  string // update method, e.g. "ddns"
+	Address string // shell pattern, matched against all addresses of the signer
+}
+
+// matches returns true if the signer matches both the method and the address pattern.
+func (cm CredentialMatch) matches(s Signer) bool {
+	if cm.Method != "" && !strings.EqualFold(cm.Method, s.Method) {
+		return false
+	}
+	if cm.Address == "" {
+		return true
+	}
+	for _, a := range s.addressList() {
+		if ok, _ := path.Match(strings.ToLower(cm.Address), strings.ToLower(a)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SignerBulkUpdateAuth replaces the TSIG key of all DDNS signers that match cm, after
+// verifying the new key against each of them.
+func (mdb *MusicDB) SignerBulkUpdateAuth(tx *sql.Tx, cm CredentialMatch, newauth AuthData) (string, error) {
+	if cm.Method == "" && cm.Address == "" {
+		return "", fmt.Errorf("No update method or address pattern specified.")
+	}
+	if _, err := path.Match(cm.Address, ""); err != nil {
+		return "", fmt.Errorf("Invalid address pattern '%s': %v", cm.Address, err)
+	}
+	if newauth.TSIGKey == "" || newauth.TSIGName == "" {
+		return "", fmt.Errorf("New TSIG key not specified.")
+	}
+	alg, err := CanonicalTSIGAlg(newauth.TSIGAlg)
+	if err != nil {
+		return "", err
+	}
+	newauth.TSIGAlg = alg
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerBulkUpdateAuth: Error from mdb.StartTransaction(): %v\n", err)
+		return "", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	ss, err := mdb.ListSigners(tx)
+	if err != nil {
+		return "", err
+	}
+	var names, skipped []string
+	for name, s := range ss {
+		if !cm.matches(s) {
+			continue
+		}
+		if s.Method != "ddns" && s.Method != "rlddns" {
+			skipped = append(skipped, fmt.Sprintf("%s (method %s)", name, s.Method))
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sort.Strings(skipped)
+	if len(names) == 0 {
+		return "", fmt.Errorf("No DDNS signer matches the method '%s' and address pattern '%s'.",
+			cm.Method, cm.Address)
+	}
+
+	// verify the new key against all signers before anything is changed
+	var signers []*Signer
+	var failed []string
+	for _, name := range names {
+		s, err := mdb.GetSignerByName(tx, name, false) // not apisafe
+		if err != nil {
+			return "", err
+		}
+		tr, err := mdb.GetTsigRotation(tx, name)
+		if err != nil {
+			return "", err
+		}
+		if tr != nil && tr.State != TsigRotationComplete && tr.State != TsigRotationRolledBack {
+			failed = append(failed, fmt.Sprintf("%s: in a TSIG rotation (state: %s)", name, tr.State))
+			continue
+		}
+		zone, err := mdb.signerVerifyZone(tx, name)
+		if err != nil {
+			zone = viper.GetString("signers.verification.testzone")
+			if zone == "" {
+				failed = append(failed, fmt.Sprintf("%s: serves no zones and no test zone is set", name))
+				continue
+			}
+		}
+		news := *s
+		news.Auth = newauth
+		if verr := news.VerifyTSIG(zone); verr != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, verr))
+			continue
+		}
+		signers = append(signers, s)
+	}
+	if len(failed) > 0 {
+		return "", fmt.Errorf("New TSIG key %s not verified for all matching signers, no signer updated:\n%s",
+			newauth.TSIGName, strings.Join(failed, "\n"))
+	}
+
+	const sqlq = "UPDATE signers SET auth=? WHERE name=?"
+	const rsql = `
+INSERT OR REPLACE INTO tsig_rotations(signer, state, oldauth, newauth, started, statestamp, reason)
+VALUES (?, ?, '', ?, datetime('now'), datetime('now'), 'bulk update')`
+	for _, s := range signers {
+		_, err = tx.Exec(sqlq, newauth.TSIGString(), s.Name)
+		if CheckSQLError("SignerBulkUpdateAuth", sqlq, err, false) {
+			return "", err
+		}
+		_, err = tx.Exec(rsql, s.Name, TsigRotationComplete, newauth.TSIGString())
+		if CheckSQLError("SignerBulkUpdateAuth", rsql, err, false) {
+			return "", err
+		}
+		log.Printf("SignerBulkUpdateAuth: signer %s now uses TSIG key %s", s.Name, newauth.TSIGName)
+	}
+
+	msg := fmt.Sprintf("TSIG key %s verified and stored for %d signers: %s", newauth.TSIGName,
+		len(names), strings.Join(names, ", "))
+	if len(skipped) > 0 {
+		msg += fmt.Sprintf("\nSkipped (TSIG keys only apply to DDNS signers): %s", strings.Join(skipped, ", "))
+	}
+	return msg, nil
+}
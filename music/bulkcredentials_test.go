@@ -0,0 +1,25 @@
+package music
+
+import "testing"
+
+func TestCredentialMatch(t *testing.T) {
+	s := Signer{Name: "s1", Method: "ddns", Address: "192.0.2.1",
+		Addresses: []string{"192.0.2.1", "ns1.Provider.example"}}
+	for _, tc := range []struct {
+		cm   CredentialMatch
+		want bool
+	}{
+		{CredentialMatch{Method: "ddns"}, true},
+		{CredentialMatch{Method: "DDNS"}, true},
+		{CredentialMatch{Method: "rlddns"}, false},
+		{CredentialMatch{Address: "192.0.2.*"}, true},
+		{CredentialMatch{Address: "198.51.100.*"}, false},
+		{CredentialMatch{Address: "*.provider.example"}, true},
+		{CredentialMatch{Method: "ddns", Address: "*.provider.example"}, true},
+		{CredentialMatch{Method: "rlddns", Address: "*.provider.example"}, false},
+	} {
+		if got := tc.cm.matches(s); got != tc.want {
+			t.Errorf("%+v.matches(%s) = %v, want %v", tc.cm, s.Name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Updater capabilities. Not every backend can update every RR type (Route 53 can not
+// publish CDS, CDNSKEY or CSYNC) and some limit the size of an update. Each updater
+// reports what it can do via Capabilities(). ApplyRRsetChanges refuses updates of RR
+// types the backend does not support, with an error that says so, and splits updates
+// that are larger than the backend accepts. The FSM actions that publish CDS/CDNSKEY
+// or CSYNC check all signers before updating any of them, so that a process stops
+// before a partial publication rather than after it.
+
+type UpdaterCapabilities struct {
+	CDS             bool     // CDS and CDNSKEY can be published
+	CSYNC           bool     // CSYNC can be published
+	Batch           bool     // one update may change RRsets at several owner names
+	MaxRRsPerUpdate int      // max number of RRs inserted or removed per update, 0 = no limit
+	RateLimit       float64  // updates per second, 0 = no limit
+	RRtypes         []string // if set, the only RR types that can be updated
+}
+
+// Supports returns true if RRs of type rrtype can be updated via the updater.
+func (c UpdaterCapabilities) Supports(rrtype uint16) bool {
+	switch rrtype {
+	case dns.TypeCDS, dns.TypeCDNSKEY:
+		if !c.CDS {
+			return false
+		}
+	case dns.TypeCSYNC:
+		if !c.CSYNC {
+			return false
+		}
+	}
+	if len(c.RRtypes) == 0 {
+		return true
+	}
+	for _, t := range c.RRtypes {
+		if t == dns.TypeToString[rrtype] {
+			return true
+		}
+	}
+	return false
+}
+
+// rrtypeNames returns the names of the RR types in the set, for RRtypes.
+func rrtypeNames(types map[uint16]bool) []string {
+	var res []string
+	for t := range types {
+		res = append(res, dns.TypeToString[t])
+	}
+	return res
+}
+
+// ddnsMaxRRs is the configured max number of RRs per DNS UPDATE (signers.ddns.maxrrs).
+func ddnsMaxRRs() int {
+	return viper.GetInt("signers.ddns.maxrrs")
+}
+
+// CheckUpdateSupport returns an error if the backend of the signer can not update RRs of
+// any of the types.
+func (s *Signer) CheckUpdateSupport(rrtypes ...uint16) error {
+	caps := GetUpdater(s.Method).Capabilities()
+	var unsupported []string
+	for _, t := range rrtypes {
+		if !caps.Supports(t) {
+			unsupported = append(unsupported, dns.TypeToString[t])
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("Signer %s (method %s) does not support updates of %s records", s.Name,
+			s.Method, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+type updateBatch struct {
+	Inserts [][]dns.RR
+	Removes [][]dns.RR
+}
+
+// splitUpdate splits the inserts and removes into batches of at most max RRs. All
+// inserts are sent before the removes, so that an RRset is never left empty between
+// two batches.
+func splitUpdate(inserts, removes [][]dns.RR, max int) []updateBatch {
+	if max <= 0 || countRRs(inserts)+countRRs(removes) <= max {
+		return []updateBatch{{Inserts: inserts, Removes: removes}}
+	}
+	var batches []updateBatch
+	cur, n := updateBatch{}, 0
+	add := func(rrset []dns.RR, remove bool) {
+		for len(rrset) > 0 {
+			if n == max {
+				batches = append(batches, cur)
+				cur, n = updateBatch{}, 0
+			}
+			chunk := rrset
+			if len(chunk) > max-n {
+				chunk = rrset[:max-n]
+			}
+			if remove {
+				cur.Removes = append(cur.Removes, chunk)
+			} else {
+				cur.Inserts = append(cur.Inserts, chunk)
+			}
+			n += len(chunk)
+			rrset = rrset[len(chunk):]
+		}
+	}
+	for _, rrset := range inserts {
+		add(rrset, false)
+	}
+	for _, rrset := range removes {
+		add(rrset, true)
+	}
+	if n > 0 {
+		batches = append(batches, cur)
+	}
+	return batches
+}
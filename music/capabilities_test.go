@@ -0,0 +1,70 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCapabilitiesSupports(t *testing.T) {
+	ddns := UpdaterCapabilities{CDS: true, CSYNC: true}
+	r53 := UpdaterCapabilities{RRtypes: []string{"NS", "DS"}}
+	cases := []struct {
+		caps   UpdaterCapabilities
+		rrtype uint16
+		want   bool
+	}{
+		{ddns, dns.TypeCDS, true},
+		{ddns, dns.TypeCSYNC, true},
+		{ddns, dns.TypeDNSKEY, true},
+		{r53, dns.TypeCDNSKEY, false},
+		{r53, dns.TypeCSYNC, false},
+		{r53, dns.TypeNS, true},
+		{r53, dns.TypeDNSKEY, false},
+	}
+	for _, c := range cases {
+		if got := c.caps.Supports(c.rrtype); got != c.want {
+			t.Errorf("Supports(%s) = %v, want %v", dns.TypeToString[c.rrtype], got, c.want)
+		}
+	}
+}
+
+func TestSplitUpdate(t *testing.T) {
+	rrs := func(n int) []dns.RR {
+		var res []dns.RR
+		for i := 0; i < n; i++ {
+			rr, _ := dns.NewRR("example.com. 3600 IN NS ns.example.net.")
+			res = append(res, rr)
+		}
+		return res
+	}
+	inserts := [][]dns.RR{rrs(3), rrs(2)}
+	removes := [][]dns.RR{rrs(4)}
+
+	if b := splitUpdate(inserts, removes, 0); len(b) != 1 {
+		t.Fatalf("no limit: got %d batches, want 1", len(b))
+	}
+	if b := splitUpdate(inserts, removes, 9); len(b) != 1 {
+		t.Fatalf("limit 9: got %d batches, want 1", len(b))
+	}
+
+	b := splitUpdate(inserts, removes, 4)
+	if len(b) != 3 {
+		t.Fatalf("limit 4: got %d batches, want 3", len(b))
+	}
+	total := 0
+	for i, batch := range b {
+		n := countRRs(batch.Inserts) + countRRs(batch.Removes)
+		if n > 4 {
+			t.Errorf("batch %d has %d RRs", i, n)
+		}
+		total += n
+	}
+	if total != 9 {
+		t.Errorf("got %d RRs in all batches, want 9", total)
+	}
+	// all inserts go before the removes
+	if countRRs(b[0].Removes) != 0 || countRRs(b[1].Inserts) != 1 || countRRs(b[2].Inserts) != 0 {
+		t.Errorf("inserts not sent before removes: %+v", b)
+	}
+}
@@ -0,0 +1,358 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Child delegations. A zone managed by MUSIC may itself be the parent of other zones
+// (e.g. in a hosting hierarchy). For the children registered with such a zone MUSIC
+// acts as the parent side of RFC 7344: the CDS RRsets of the child are fetched from
+// all of its name servers and, if they agree, the DS RRset of the child is updated at
+// all signers of the parent zone. This is the same logic as in the scanner, which uses
+// ComputeDsUpdate as well.
+
+type ChildDelegation struct {
+	Parent   string
+	Child    string
+	LastScan time.Time
+	Status   string
+}
+
+// isCdsDelete returns true for the RFC 8078 "delete DS" CDS (0 0 0 00).
+func isCdsDelete(cds *dns.CDS) bool {
+	return cds.KeyTag == 0 && cds.Algorithm == 0 && cds.DigestType == 0 &&
+		strings.Trim(cds.Digest, "0") == ""
+}
+
+// ComputeDsUpdate compares the current DS RRset of a child with the CDS RRsets fetched
+// from each of the name servers of the child (cdsets, keyed by name server). The name
+// servers must agree. No CDS at all means no change; the RFC 8078 delete CDS removes
+// all DS records.
+func ComputeDsUpdate(child string, current []*dns.DS, cdsets map[string][]*dns.CDS) (adds, removes []dns.RR, err error) {
+	var servers []string
+	for ns := range cdsets {
+		servers = append(servers, ns)
+	}
+	sort.Strings(servers)
+	if len(servers) == 0 {
+		return nil, nil, fmt.Errorf("No CDS RRsets to compare for %s", child)
+	}
+
+	cdsmap := map[string]*dns.CDS{}
+	for i, ns := range servers {
+		m := map[string]*dns.CDS{}
+		for _, cds := range cdsets[ns] {
+			m[dsKey(cds.KeyTag, cds.Algorithm, cds.DigestType, cds.Digest)] = cds
+		}
+		if i == 0 {
+			cdsmap = m
+			continue
+		}
+		if len(m) != len(cdsmap) {
+			return nil, nil, fmt.Errorf("The CDS RRsets of %s at %s and %s differ", child,
+				servers[0], ns)
+		}
+		for k := range m {
+			if _, ok := cdsmap[k]; !ok {
+				return nil, nil, fmt.Errorf("The CDS RRsets of %s at %s and %s differ", child,
+					servers[0], ns)
+			}
+		}
+	}
+	if len(cdsmap) == 0 {
+		return nil, nil, nil
+	}
+
+	dsmap := map[string]*dns.DS{}
+	ttl := uint32(3600)
+	for _, ds := range current {
+		dsmap[dsKey(ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)] = ds
+		ttl = ds.Hdr.Ttl
+	}
+
+	if len(cdsmap) == 1 {
+		for _, cds := range cdsmap {
+			if isCdsDelete(cds) {
+				for _, ds := range current {
+					removes = append(removes, ds)
+				}
+				return nil, removes, nil
+			}
+		}
+	}
+
+	var keys []string
+	for k := range cdsmap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := dsmap[k]; ok {
+			continue
+		}
+		cds := cdsmap[k]
+		adds = append(adds, &dns.DS{
+			Hdr:        dns.RR_Header{Name: dns.Fqdn(child), Rrtype: dns.TypeDS, Class: dns.ClassINET, Ttl: ttl},
+			KeyTag:     cds.KeyTag,
+			Algorithm:  cds.Algorithm,
+			DigestType: cds.DigestType,
+			Digest:     cds.Digest,
+		})
+	}
+	for _, ds := range current {
+		if _, ok := cdsmap[dsKey(ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)]; !ok {
+			removes = append(removes, ds)
+		}
+	}
+	return adds, removes, nil
+}
+
+func (mdb *MusicDB) ZoneSetChild(tx *sql.Tx, z *Zone, child string, remove bool) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+	child, err := CanonicalZoneName(child)
+	if err != nil {
+		return "", err
+	}
+	if child == z.Name || !dns.IsSubDomain(z.Name, child) {
+		return "", fmt.Errorf("Zone %s is not a child of %s.", child, z.Name)
+	}
+	if !remove {
+		if err := CheckOwner(z.Name, child, dns.TypeDS); err != nil {
+			return "", err
+		}
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneSetChild: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if remove {
+		const sqlq = "DELETE FROM child_delegations WHERE parent=? AND child=?"
+		_, err = tx.Exec(sqlq, z.Name, child)
+		if CheckSQLError("ZoneSetChild", sqlq, err, false) {
+			return "", err
+		}
+		return fmt.Sprintf("Zone %s: the DS RRset of %s is no longer maintained.", z.Name, child), nil
+	}
+
+	const sqlq = "INSERT OR IGNORE INTO child_delegations(parent, child) VALUES (?, ?)"
+	_, err = tx.Exec(sqlq, z.Name, child)
+	if CheckSQLError("ZoneSetChild", sqlq, err, false) {
+		return "", err
+	}
+	return fmt.Sprintf("Zone %s: the DS RRset of %s is now maintained from its CDS.", z.Name, child), nil
+}
+
+func (mdb *MusicDB) ListChildren(tx *sql.Tx, parent string) ([]ChildDelegation, error) {
+	var cds []ChildDelegation
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListChildren: Error from mdb.StartTransaction(): %v\n", err)
+		return cds, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	sqlq := "SELECT parent, child, COALESCE(lastscan, ''), status FROM child_delegations"
+	var args []interface{}
+	if parent != "" {
+		sqlq += " WHERE parent=?"
+		args = append(args, parent)
+	}
+	sqlq += " ORDER BY parent, child"
+
+	rows, err := tx.Query(sqlq, args...)
+	if CheckSQLError("ListChildren", sqlq, err, false) {
+		return cds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cd ChildDelegation
+		var lastscan string
+		if err := rows.Scan(&cd.Parent, &cd.Child, &lastscan, &cd.Status); err != nil {
+			log.Fatalf("ListChildren: Error from rows.Scan(): %v", err)
+		}
+		cd.LastScan, _ = time.Parse(layout, lastscan)
+		cds = append(cds, cd)
+	}
+	return cds, nil
+}
+
+// childCdsets fetches the CDS RRset of the child from each address of each of its name
+// servers (as delegated by the parent).
+func childCdsets(child string, nsrrs []dns.RR) (map[string][]*dns.CDS, error) {
+	resolver, err := discoveryResolver()
+	if err != nil {
+		return nil, err
+	}
+	c := &dns.Client{Timeout: 5 * time.Second}
+	cdsets := map[string][]*dns.CDS{}
+	for _, rr := range nsrrs {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		var addrs []string
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			rrs, err := resolveQuestion(c, resolver, ns.Ns, qtype)
+			if err != nil {
+				continue
+			}
+			for _, a := range rrs {
+				switch a := a.(type) {
+				case *dns.A:
+					addrs = append(addrs, a.A.String())
+				case *dns.AAAA:
+					addrs = append(addrs, a.AAAA.String())
+				}
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("No addresses found for name server %s of %s", ns.Ns, child)
+		}
+		for _, addr := range addrs {
+			m := new(dns.Msg)
+			m.SetQuestion(child, dns.TypeCDS)
+			r, _, err := DnsExchange(c, m, net.JoinHostPort(addr, "53"))
+			if err != nil {
+				return nil, fmt.Errorf("%s (%s): %v", ns.Ns, addr, err)
+			}
+			if r.Rcode != dns.RcodeSuccess {
+				return nil, fmt.Errorf("%s (%s): RCODE = %s", ns.Ns, addr, dns.RcodeToString[r.Rcode])
+			}
+			key := ns.Ns + "/" + addr
+			cdsets[key] = []*dns.CDS{}
+			for _, a := range r.Answer {
+				if cds, ok := a.(*dns.CDS); ok {
+					cdsets[key] = append(cdsets[key], cds)
+				}
+			}
+		}
+	}
+	return cdsets, nil
+}
+
+// scanChild updates the DS RRset of the child at all signers of the parent zone z.
+func (z *Zone) scanChild(child string) (string, error) {
+	var names []string
+	for name := range z.SGroup.SignerMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return "", fmt.Errorf("Signer group %s has no signers", z.SGname)
+	}
+
+	first := z.SGroup.SignerMap[names[0]]
+	err, nsrrs := GetUpdater(first.Method).FetchRRset(first, z.Name, child, dns.TypeNS)
+	if err != nil {
+		return "", err
+	}
+	if len(nsrrs) == 0 {
+		return "", fmt.Errorf("%s is not delegated from %s", child, z.Name)
+	}
+	cdsets, err := childCdsets(child, nsrrs)
+	if err != nil {
+		return "", err
+	}
+
+	var changes []string
+	for _, name := range names {
+		s := z.SGroup.SignerMap[name]
+		updater := GetUpdater(s.Method)
+		err, rrs := updater.FetchRRset(s, z.Name, child, dns.TypeDS)
+		if err != nil {
+			return "", err
+		}
+		var current []*dns.DS
+		for _, rr := range rrs {
+			if ds, ok := rr.(*dns.DS); ok {
+				current = append(current, ds)
+			}
+		}
+		adds, removes, err := ComputeDsUpdate(child, current, cdsets)
+		if err != nil {
+			return "", err
+		}
+		if len(adds) == 0 && len(removes) == 0 {
+			continue
+		}
+		var ins, rems [][]dns.RR
+		if len(adds) > 0 {
+			ins = append(ins, adds)
+		}
+		if len(removes) > 0 {
+			rems = append(rems, removes)
+		}
+		if err := updater.Update(s, z.Name, child, &ins, &rems); err != nil {
+			return "", fmt.Errorf("Unable to update the DS RRset of %s at %s: %v", child, name, err)
+		}
+		changes = append(changes, fmt.Sprintf("%s: +%d -%d DS", name, len(adds), len(removes)))
+	}
+	if len(changes) == 0 {
+		return "DS in sync with CDS", nil
+	}
+	return "DS updated (" + strings.Join(changes, ", ") + ")", nil
+}
+
+// ScanChildren maintains the DS RRsets of the registered children of the zone parent,
+// or of all zones with children if parent is "". The returned messages describe the
+// outcome per child.
+func (mdb *MusicDB) ScanChildren(parent string) ([]string, error) {
+	var msgs []string
+	children, err := mdb.ListChildren(nil, parent)
+	if err != nil {
+		return msgs, err
+	}
+
+	zones := map[string]*Zone{}
+	for _, cd := range children {
+		z, ok := zones[cd.Parent]
+		if !ok {
+			z, _, err = mdb.GetZone(nil, cd.Parent)
+			if err != nil {
+				return msgs, err
+			}
+			zones[cd.Parent] = z
+		}
+
+		var status string
+		switch {
+		case !z.Exists || z.SGroup == nil:
+			status = fmt.Sprintf("Error: parent zone %s is not in a signer group", cd.Parent)
+		case z.FSM != "" && z.FSM != "---":
+			// the signers of the parent are being changed, wait until done
+			status = fmt.Sprintf("Parent zone %s is in process %s, not scanned", cd.Parent, z.FSM)
+		default:
+			status, err = z.scanChild(cd.Child)
+			if err != nil {
+				status = "Error: " + err.Error()
+			}
+		}
+
+		const sqlq = "UPDATE child_delegations SET lastscan=datetime('now'), status=? WHERE parent=? AND child=?"
+		if _, err := mdb.Exec(sqlq, status, cd.Parent, cd.Child); err != nil {
+			CheckSQLError("ScanChildren", sqlq, err, false)
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: %s", cd.Child, status))
+	}
+	return msgs, nil
+}
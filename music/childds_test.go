@@ -0,0 +1,60 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestComputeDsUpdate(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("dns.NewRR(%q): %v", s, err)
+		}
+		return r
+	}
+	ds1 := rr("child.example.com. 3600 IN DS 1 13 2 AAAA").(*dns.DS)
+	ds2 := rr("child.example.com. 3600 IN DS 2 13 2 BBBB").(*dns.DS)
+	cds2 := rr("child.example.com. 3600 IN CDS 2 13 2 bbbb").(*dns.CDS)
+	cds3 := rr("child.example.com. 3600 IN CDS 3 13 2 CCCC").(*dns.CDS)
+	cdsdel := rr("child.example.com. 3600 IN CDS 0 0 0 00").(*dns.CDS)
+	current := []*dns.DS{ds1, ds2}
+
+	adds, removes, err := ComputeDsUpdate("child.example.com.", current, map[string][]*dns.CDS{
+		"ns1.example.net.": {cds2, cds3},
+		"ns2.example.net.": {cds3, cds2},
+	})
+	if err != nil {
+		t.Fatalf("ComputeDsUpdate: %v", err)
+	}
+	if len(adds) != 1 || adds[0].(*dns.DS).KeyTag != 3 || adds[0].Header().Rrtype != dns.TypeDS {
+		t.Errorf("adds = %v, want DS 3", adds)
+	}
+	if len(removes) != 1 || removes[0].(*dns.DS).KeyTag != 1 {
+		t.Errorf("removes = %v, want DS 1", removes)
+	}
+
+	_, _, err = ComputeDsUpdate("child.example.com.", current, map[string][]*dns.CDS{
+		"ns1.example.net.": {cds2, cds3},
+		"ns2.example.net.": {cds2},
+	})
+	if err == nil {
+		t.Errorf("disagreeing name servers: expected an error")
+	}
+
+	adds, removes, err = ComputeDsUpdate("child.example.com.", current, map[string][]*dns.CDS{
+		"ns1.example.net.": {},
+		"ns2.example.net.": {},
+	})
+	if err != nil || len(adds) != 0 || len(removes) != 0 {
+		t.Errorf("no CDS: got %v, %v, %v, want no change", adds, removes, err)
+	}
+
+	adds, removes, err = ComputeDsUpdate("child.example.com.", current, map[string][]*dns.CDS{
+		"ns1.example.net.": {cdsdel},
+	})
+	if err != nil || len(adds) != 0 || len(removes) != 2 {
+		t.Errorf("delete CDS: got %v, %v, %v, want both DS removed", adds, removes, err)
+	}
+}
@@ -1,6 +1,7 @@
 package music
 
 import (
+       "fmt"
        "log"
        "strings"
        
@@ -21,6 +22,8 @@ func ParseSignerAuth(astr, method string) AuthData {
 	auth := AuthData{}
 
 	switch method {
+	case "file-include": // TSIG is optional, only used for queries
+	     fallthrough
 	case "rlddns":
 	     fallthrough
 	case "ddns":
@@ -30,15 +33,13 @@ func ParseSignerAuth(astr, method string) AuthData {
 			keyname = parts[0]
 			secret = parts[1]
 		} else {
-		        parts[0] = dns.Fqdn(parts[0])
-			if _, ok := ValidTSIGAlgs[parts[0]]; ok {
-				auth.TSIGAlg = parts[0]
-				keyname = parts[1]
-				secret = parts[2]
-			} else {
-				log.Fatalf("ParseSignerAuth: Unknown TSIG algorithm: '%s'. Terminating.",
-							     parts[0])
+			alg, err := CanonicalTSIGAlg(parts[0])
+			if err != nil {
+				log.Fatalf("ParseSignerAuth: %v Terminating.", err)
 			}
+			auth.TSIGAlg = alg
+			keyname = parts[1]
+			secret = parts[2]
 		}
 
 		keyname := dns.Fqdn(keyname)
@@ -59,9 +60,46 @@ func ParseSignerAuth(astr, method string) AuthData {
 	     fallthrough
 	case "desec":
 		// NYI
+
+	case "route53":
+		// the AWS credentials are in the config (signers.route53)
 		
 	default:
 		log.Fatalf("Unknown signer method '%s'", method)
 	}
 	return auth
 }
+
+// CanonicalTSIGAlg returns the name of the TSIG algorithm as used by the dns package.
+// "hmac-sha512", "hmac-sha512." and "sha512" are all accepted, "" is the default
+// (hmac-sha256).
+func CanonicalTSIGAlg(alg string) (string, error) {
+	if alg == "" {
+		return dns.HmacSHA256, nil
+	}
+	name := strings.ToLower(strings.TrimSuffix(alg, "."))
+	if !strings.HasPrefix(name, "hmac-") {
+		name = "hmac-" + name
+	}
+	name = dns.Fqdn(name)
+	if ValidTSIGAlgs[name] {
+		return name, nil
+	}
+	if name == "hmac-sha384." || name == "hmac-sha224." {
+		return "", fmt.Errorf("TSIG algorithm '%s' is not supported (yet), use hmac-sha256 or hmac-sha512.", alg)
+	}
+	return "", fmt.Errorf("Unknown TSIG algorithm: '%s'.", alg)
+}
+
+// ParseAuthStr parses the TSIG data of a signer as stored in the DB:
+// "alg:keyname:secret", or "keyname:secret" for the default algorithm.
+func ParseAuthStr(authstr string) AuthData {
+	p := strings.Split(authstr, ":")
+	switch len(p) {
+	case 3:
+		return AuthData{TSIGAlg: p[0], TSIGName: p[1], TSIGKey: p[2]}
+	case 2:
+		return AuthData{TSIGAlg: dns.HmacSHA256, TSIGName: p[0], TSIGKey: p[1]}
+	}
+	return AuthData{}
+}
@@ -0,0 +1,40 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCanonicalTSIGAlg(t *testing.T) {
+	tests := []struct {
+		alg, want string
+		ok        bool
+	}{
+		{"", dns.HmacSHA256, true},
+		{"hmac-sha512", dns.HmacSHA512, true},
+		{"hmac-sha512.", dns.HmacSHA512, true},
+		{"SHA512", dns.HmacSHA512, true},
+		{"hmac-sha384", "", false},
+		{"hmac-md5", "", false},
+	}
+	for _, tc := range tests {
+		got, err := CanonicalTSIGAlg(tc.alg)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("CanonicalTSIGAlg(%q) = %q, %v", tc.alg, got, err)
+		}
+	}
+}
+
+func TestParseAuthStr(t *testing.T) {
+	a := ParseAuthStr("hmac-sha512.:key.example.:c2VjcmV0")
+	if a.TSIGAlg != dns.HmacSHA512 || a.TSIGName != "key.example." || a.TSIGKey != "c2VjcmV0" {
+		t.Errorf("ParseAuthStr = %+v", a)
+	}
+	if a := ParseAuthStr("key.example.:c2VjcmV0"); a.TSIGAlg != dns.HmacSHA256 {
+		t.Errorf("ParseAuthStr without algorithm = %+v", a)
+	}
+	if a := ParseAuthStr(""); a.TSIGKey != "" {
+		t.Errorf("ParseAuthStr(\"\") = %+v", a)
+	}
+}
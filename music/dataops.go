@@ -38,6 +38,9 @@ func (mdb *MusicDB) ZoneCopyRRset(tx *sql.Tx, dbzone *Zone, owner,
 	if !dbzone.Exists {
 		return fmt.Errorf("Zone %s unknown", dbzone.Name), ""
 	}
+	if err := CheckOwner(dbzone.Name, owner, dns.StringToType[rrtype]); err != nil {
+		return err, ""
+	}
 
 	localtx, tx, err := mdb.StartTransaction(tx)
 	if err != nil {
@@ -232,7 +235,7 @@ func AuthoritativeDNSQuery(qname, nameserver string, rrtype uint16, verbose bool
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(qname), rrtype)
 	m.SetEdns0(4096, true)
-	r, err := dns.Exchange(m, net.JoinHostPort(nameserver, "53"))
+	r, _, err := DnsExchange(new(dns.Client), m, net.JoinHostPort(nameserver, "53"))
 	if err != nil && verbose {
 		log.Printf("AuthDNSQuery: Error from dns.Exchange: %v", err)
 	}
@@ -244,7 +247,7 @@ func RecursiveDNSQuery(qname, nameserver string, rrtype uint16, verbose bool) (*
 	m := new(dns.Msg)
 	m.SetQuestion(qname, rrtype)
 	m.SetEdns0(4096, true)
-	r, err := dns.Exchange(m, net.JoinHostPort(nameserver, "53"))
+	r, _, err := DnsExchange(new(dns.Client), m, net.JoinHostPort(nameserver, "53"))
 	if err != nil && verbose {
 		log.Printf("RecursiveDNSQuery: Error from dns.Exchange: %v", err)
 	}
@@ -281,7 +284,9 @@ func (mdb *MusicDB) GetMeta(tx *sql.Tx, z *Zone, key string) (string, bool, erro
 
 func (mdb *MusicDB) GetStopReason(tx *sql.Tx, z *Zone) (string, bool, error) {
 
+	mdb.stopmu.Lock()
 	foo := mdb.StopReasonCache[z.Name]
+	mdb.stopmu.Unlock()
 	if foo != "" {
 		return foo, true, nil
 	}
@@ -30,21 +30,50 @@ func (u *DdnsUpdater) GetApi() Api {
 	return Api{}
 }
 
-func (signer *Signer) NewDnsClient() dns.Client {
-	var c dns.Client
-	if signer.UseTcp {
-		c = dns.Client{Net: "tcp"}
+func (u *DdnsUpdater) Capabilities() UpdaterCapabilities {
+	return UpdaterCapabilities{CDS: true, CSYNC: true, Batch: true, MaxRRsPerUpdate: ddnsMaxRRs()}
+}
+
+func (signer *Signer) NewDnsClient() *dns.Client {
+	var c *dns.Client
+	if signer.TLS.Active {
+		c = &dns.Client{Net: "tcp-tls", TLSConfig: signer.tlsConfig(signer.Address)}
+	} else if signer.UseTcp {
+		c = &dns.Client{Net: "tcp"}
 	} else {
 		log.Printf("DDNS: Accessing signer %s via UDP. This is a debugging mechanism only",
 			signer.Name)
-		c = dns.Client{Net: "udp"}
+		c = &dns.Client{Net: "udp"}
 	}
 	return c
 }
 
+// updateZone returns the name for the zone section of a DNS UPDATE. The managed
+// records are not necessarily at the apex, so the owner name is only used if the
+// zone is not known.
+func updateZone(zone, owner string) string {
+	if zone == "" {
+		return owner
+	}
+	return dns.Fqdn(zone)
+}
+
+// PrepareTSIGExchange authenticates m with the TSIG key of the signer, or signs it with
+// the SIG(0) key of the signer if it has one (see signersig0.go).
 func (signer *Signer) PrepareTSIGExchange(c *dns.Client, m *dns.Msg) error {
+	if signer.SIG0.Active {
+		err := signer.signSIG0(m)
+		if err != nil {
+			log.Printf("PrepareTSIGExchange: %v", err)
+		}
+		return err
+	}
 	if signer.UseTSIG {
-		m.SetTsig(signer.Auth.TSIGName, signer.Auth.TSIGAlg, 300, time.Now().Unix())
+		alg := signer.Auth.TSIGAlg
+		if alg == "" {
+			alg = dns.HmacSHA256
+		}
+		m.SetTsig(signer.Auth.TSIGName, alg, 300, time.Now().Unix())
 		c.TsigSecret = map[string]string{signer.Auth.TSIGName: signer.Auth.TSIGKey}
 		// log.Printf("DDNS: FetchRRset: TsigSecret: %v", c.TsigSecret)
 	} else {
@@ -78,13 +107,13 @@ func (u *DdnsUpdater) Update(signer *Signer, zone, fqdn string,
 	if signer.Address == "" {
 		return fmt.Errorf("No ip|host for signer %s", signer.Name)
 	}
-	if signer.Auth.TSIGKey == "" {
-		return fmt.Errorf("No TSIG for signer %s", signer.Name)
+	if !signer.hasUpdateAuth() {
+		return fmt.Errorf("No TSIG or SIG(0) key for signer %s", signer.Name)
 	}
 
 	c := signer.NewDnsClient()
 	m := new(dns.Msg)
-	m.SetUpdate(fqdn)
+	m.SetUpdate(updateZone(zone, fqdn))
 	if inserts != nil {
 		for _, insert := range *inserts {
 			m.Insert(insert)
@@ -96,9 +125,11 @@ func (u *DdnsUpdater) Update(signer *Signer, zone, fqdn string,
 		}
 	}
 
-	signer.PrepareTSIGExchange(&c, m)
+	if err := signer.PrepareTSIGExchange(c, m); err != nil {
+		return err
+	}
 
-	in, _, err := c.Exchange(m, signer.Address+":"+signer.Port) // TODO: add DnsAddress or solve this in a better way
+	in, _, err := signer.Exchange(c, m)
 	if err != nil {
 		if viper.GetString("log.ddns") == "debug" {
 			log.Printf("Update msg that caused error:\n%v\n", m.String())
@@ -128,20 +159,22 @@ func (u *DdnsUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]
 	if signer.Address == "" {
 		return fmt.Errorf("No ip|host for signer %s", signer.Name)
 	}
-	if signer.Auth.TSIGKey == "" {
-		return fmt.Errorf("No TSIG for signer %s", signer.Name)
+	if !signer.hasUpdateAuth() {
+		return fmt.Errorf("No TSIG or SIG(0) key for signer %s", signer.Name)
 	}
 
 	c := signer.NewDnsClient()
 	m := new(dns.Msg)
-	m.SetUpdate(fqdn)
+	m.SetUpdate(updateZone(zone, fqdn))
 	for _, rrset := range rrsets {
 		m.RemoveRRset(rrset)
 	}
 
-	signer.PrepareTSIGExchange(&c, m)
+	if err := signer.PrepareTSIGExchange(c, m); err != nil {
+		return err
+	}
 
-	in, _, err := c.Exchange(m, signer.Address+":"+signer.Port) // TODO: add DnsAddress or solve this in a better way
+	in, _, err := signer.Exchange(c, m)
 	if err != nil {
 		return err
 	}
@@ -158,18 +191,15 @@ func (u *DdnsUpdater) FetchRRset(signer *Signer, zone, fqdn string,
 	if signer.Address == "" {
 		return fmt.Errorf("No ip|host for signer %s", signer.Name), []dns.RR{}
 	}
-	if signer.Auth.TSIGKey == "" {
-		return fmt.Errorf("No TSIG for signer %s", signer.Name), []dns.RR{}
+	if !signer.hasUpdateAuth() {
+		return fmt.Errorf("No TSIG or SIG(0) key for signer %s", signer.Name), []dns.RR{}
 	}
 
-	c := signer.NewDnsClient()
 	m := new(dns.Msg)
 	m.SetQuestion(fqdn, rrtype)
 	// m.SetEdns0(4096, true)
 
-	signer.PrepareTSIGExchange(&c, m)
-
-	r, _, err := c.Exchange(m, signer.Address+":"+signer.Port) // TODO: add DnsAddress or solve this in a better way
+	r, err := signer.FetchExchange(m)
 	if err != nil {
 		log.Printf("DDNS: FetchRRset: dns.Exchange error: err: %v r: %v", err, r)
 		return err, []dns.RR{}
@@ -179,9 +209,10 @@ func (u *DdnsUpdater) FetchRRset(signer *Signer, zone, fqdn string,
 		return fmt.Errorf("Fetch of %s RRset failed, RCODE = %s", dns.TypeToString[rrtype], dns.RcodeToString[r.MsgHdr.Rcode]), []dns.RR{}
 	}
 
+	learnNegativeTTL(signer.Name, zone, r)
 	log.Printf("Length of %s answer from %s: %d RRs\n",
 		dns.TypeToString[rrtype],
-		signer.Name+":"+signer.Port, len(r.Answer))
+		signer.Name+" ("+signer.DnsAddress()+")", len(r.Answer))
 
 	var rrs []dns.RR
 
@@ -8,6 +8,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -80,10 +82,15 @@ func (api *Api) DesecLogin() (DesecLResponse, error) {
 	status, buf, err := api.NoAuthPost(endpoint, bytebuf.Bytes())
 	if err != nil {
 		log.Println("Error from api.Post:", err)
+		return DesecLResponse{}, err
 	}
 	if api.Verbose {
 		fmt.Printf("Status: %d\n", status)
 	}
+	if status != 200 {
+		// keep the stored token, a failed login must not replace it with nothing
+		return DesecLResponse{}, fmt.Errorf("deSEC login failed: status %d: %s", status, string(buf))
+	}
 
 	var dlr DesecLResponse
 	err = json.Unmarshal(buf, &dlr)
@@ -189,6 +196,89 @@ func (api *Api) DesecTokenRefresh() bool {
 	return true
 }
 
+// desecLoginLock serializes logins after a 401, so that concurrent requests that are
+// rejected with the same expired token only cause one new login.
+var desecLoginLock sync.Mutex
+
+// DesecRequest sends a request to deSEC on behalf of the signer via send, using the zone
+// credential of the signer for zone if there is one, otherwise the account token. A
+// 401 response to a request made with the account token means that the token has
+// expired or been revoked (e.g. after a restart of musicd with an old token on disk):
+// DesecRequest logs in again and resends the request once, rather than failing the
+// operation. A zone credential can not be renewed by a login, so a 401 response to a
+// request made with one is returned as an error.
+func (api *Api) DesecRequest(s *Signer, zone string, send func() (int, []byte, error)) (int, []byte, error) {
+	api.DesecTokenRefresh()
+	account := api.apiKey
+	api.apiKey = s.apiToken(zone, account)
+	scoped := api.apiKey != account
+
+	status, buf, err := send()
+	if err != nil || status != 401 {
+		return status, buf, err
+	}
+	if scoped {
+		return status, buf, fmt.Errorf("deSEC rejected the token of signer %s for zone %s (401). "+
+			"Set a new one with 'signer set-token'.", s.Name, zone)
+	}
+
+	desecLoginLock.Lock()
+	if token := api.TokViper.GetString("desec.token"); token != account && token != "" {
+		api.apiKey = token // another request has logged in already
+	} else {
+		log.Printf("DesecRequest: token rejected by deSEC (401), logging in again")
+		if _, err := api.DesecLogin(); err != nil {
+			desecLoginLock.Unlock()
+			return status, buf, fmt.Errorf("deSEC rejected the token (401) and a new login failed: %v", err)
+		}
+	}
+	desecLoginLock.Unlock()
+
+	status, buf, err = send()
+	if err == nil && status == 401 {
+		err = fmt.Errorf("deSEC rejected the new token (401)")
+	}
+	return status, buf, err
+}
+
+// desecMaxHolds is the number of times a rate-limited request is sent again.
+func desecMaxHolds() int {
+	if !viper.IsSet("signers.desec.limits.holds") {
+		return 3
+	}
+	return viper.GetInt("signers.desec.limits.holds")
+}
+
+// DesecHoldRetry sends a request to deSEC via send. As long as deSEC responds 429 it
+// waits for the hold period given by the response (see ExtractHoldPeriod) and sends the
+// request again, at most signers.desec.limits.holds times. header returns the headers of
+// the latest response; it may be nil for clients that do not keep them, in which case
+// the hold period is taken from the response body. The rate-limited updater does not
+// use this, its manager holds the requests to the signer instead.
+func DesecHoldRetry(what string, send func() (int, []byte, error),
+	header func() http.Header) (int, []byte, error) {
+	for holds := 0; ; holds++ {
+		status, buf, err := send()
+		if err != nil || status != 429 {
+			return status, buf, err
+		}
+		if holds >= desecMaxHolds() {
+			return status, buf, fmt.Errorf("%s: still rate-limited by deSEC after %d retries",
+				what, holds)
+		}
+		var h http.Header
+		if header != nil {
+			h = header()
+		}
+		hold, herr := ExtractHoldPeriod(h, buf)
+		if herr != nil {
+			log.Printf("%s: %v. Using default hold period.", what, herr)
+		}
+		log.Printf("%s: rate-limited by deSEC. Retry in %d seconds.", what, hold)
+		time.Sleep(time.Duration(hold) * time.Second)
+	}
+}
+
 func DesecLogout(cc *CliConfig, tokvip *viper.Viper) error {
 	token := tokvip.GetString("desec.token")
 	apiurl := viper.GetString("signers.desec.baseurl") + "/auth/logout/"
@@ -197,8 +287,10 @@ func DesecLogout(cc *CliConfig, tokvip *viper.Viper) error {
 	// fmt.Printf("About to post '%s' to desec\n", string(bytebuf.Bytes()))
 	// return nil
 
-	status, _, err := GenericAPIpost(apiurl, token, "Authorization",
-		bytebuf.Bytes(), true, cc.Verbose, cc.Debug, nil)
+	status, _, err := DesecHoldRetry("DesecLogout", func() (int, []byte, error) {
+		return GenericAPIpost(apiurl, token, "Authorization",
+			bytebuf.Bytes(), true, cc.Verbose, cc.Debug, nil)
+	}, nil)
 	if err != nil {
 		log.Println("Error from GenericAPIpost:", err)
 	}
@@ -221,13 +313,16 @@ func DesecListZone(cc *CliConfig, zone string, tokvip *viper.Viper) ([]DesecZone
 	}
 	apikey := tokvip.GetString("desec.token")
 
-	status, buf, err := GenericAPIget(apiurl, apikey, "Authorization", true,
-		cc.Verbose, cc.Debug, nil)
+	status, buf, err := DesecHoldRetry("DesecListZone", func() (int, []byte, error) {
+		return GenericAPIget(apiurl, apikey, "Authorization", true,
+			cc.Verbose, cc.Debug, nil)
+	}, nil)
 	if status == 401 {
 		return []DesecZone{}, fmt.Errorf("401 Unauthorized.")
 	}
 	if err != nil {
 		log.Println("Error from GenericAPIget:", err)
+		return []DesecZone{}, err
 	}
 	fmt.Printf("Status: %d\n", status)
 
@@ -254,8 +349,10 @@ func DesecAddZone(cc *CliConfig, zone string, tokvip *viper.Viper) (DesecZone, e
 	// fmt.Printf("About to post to desec: '%s'\n", string(bytebuf.Bytes()))
 	// os.Exit(1)
 
-	status, buf, err := GenericAPIpost(apiurl, apikey, "Authorization",
-		bytebuf.Bytes(), true, cc.Verbose, cc.Debug, nil)
+	status, buf, err := DesecHoldRetry("DesecAddZone", func() (int, []byte, error) {
+		return GenericAPIpost(apiurl, apikey, "Authorization",
+			bytebuf.Bytes(), true, cc.Verbose, cc.Debug, nil)
+	}, nil)
 	if status == 401 {
 		return DesecZone{}, fmt.Errorf("401 Unauthorized.")
 	}
@@ -280,8 +377,10 @@ func DesecDeleteZone(cc *CliConfig, zone string, tokvip *viper.Viper) error {
 	apiurl := viper.GetString("api.baseurl") + "/domains/" + zone + "/"
 	apikey := tokvip.GetString("desec.token")
 
-	status, _, err := GenericAPIdelete(apiurl, apikey, "Authorization",
-		true, cc.Verbose, cc.Debug, nil)
+	status, _, err := DesecHoldRetry("DesecDeleteZone", func() (int, []byte, error) {
+		return GenericAPIdelete(apiurl, apikey, "Authorization",
+			true, cc.Verbose, cc.Debug, nil)
+	}, nil)
 	if cc.Verbose {
 		fmt.Printf("Status: %d\n", status)
 	}
@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
 	"time"
 
@@ -40,24 +41,25 @@ func (u *DesecUpdater) GetApi() Api {
 	return u.Api
 }
 
+// deSEC manages DNSKEY, CDS and CDNSKEY itself, but accepts additional RRs in them.
+func (u *DesecUpdater) Capabilities() UpdaterCapabilities {
+	return UpdaterCapabilities{CDS: true, CSYNC: true, Batch: true}
+}
+
+// DesecSubname returns the owner name relative to the zone. Zone and owner may be given
+// with or without the trailing dot. Owner names outside the zone are returned unchanged.
 func DesecSubname(zone, owner string, urluse bool) string {
-	newowner := owner
-	if strings.HasSuffix(owner, zone) {
-		if len(owner) > (len(zone) + 1) {
-			newowner = owner[:len(owner)-len(zone)-1]
-		} else if len(owner) == len(zone) {
-			if urluse {
-				newowner = "@"
-			} else {
-				newowner = ""
-			}
+	fzone, fowner := strings.ToLower(dns.Fqdn(zone)), strings.ToLower(dns.Fqdn(owner))
+	if !dns.IsSubDomain(fzone, fowner) {
+		return owner
+	}
+	if fzone == fowner {
+		if urluse {
+			return "@"
 		}
-		//fmt.Printf("DesecSubname: removing zone %s from owner name %s returning '%s'\n",
-		// zone, owner, newowner)
-		// owner = strings.TrimSuffix(owner, zone)
+		return ""
 	}
-
-	return newowner
+	return owner[:len(fowner)-len(fzone)-1]
 }
 
 func (u *DesecUpdater) FetchRRset(s *Signer, zone, owner string,
@@ -87,13 +89,11 @@ func (u *DesecUpdater) FetchRRset(s *Signer, zone, owner string,
 	//apikey := tokvip.GetString("desec.token")
 
 	api := GetUpdater("desec-api").GetApi() // kludge
-	api.DesecTokenRefresh()
-
-	status, buf, err := api.Get(endpoint)
-	if status == 429 { // we have been rate-limited
-		fmt.Printf("desec.FetchRRset: rate-limit. This is what we got: '%v'. Retry in %d seconds.\n", string(buf), 10)
-		return nil, []dns.RR{}
-	}
+	status, buf, err := api.DesecRequest(s, zone, func() (int, []byte, error) {
+		return DesecHoldRetry("desec.FetchRRset "+endpoint, func() (int, []byte, error) {
+			return api.Get(endpoint)
+		}, func() http.Header { return api.LastHeader })
+	})
 
 	if err != nil {
 		log.Printf("Error from GenericAPIget (desec): %v\n", err)
@@ -112,7 +112,7 @@ func (u *DesecUpdater) FetchRRset(s *Signer, zone, owner string,
 		var dr DesecDomain
 		err = json.Unmarshal(buf, &dr)
 		if err != nil {
-			log.Fatalf("FetchRRset: Error from unmarshal: %v\n", err)
+			return fmt.Errorf("FetchRRset: Error from unmarshal: %v", err), []dns.RR{}
 		}
 		var rrs []dns.RR
 
@@ -136,7 +136,7 @@ func (u *DesecUpdater) FetchRRset(s *Signer, zone, owner string,
 		var dr DesecResponseRRset
 		err = json.Unmarshal(buf, &dr)
 		if err != nil {
-			log.Fatalf("FetchRRset: Error from unmarshal: %v\n", err)
+			return fmt.Errorf("FetchRRset: Error from unmarshal: %v", err), []dns.RR{}
 		}
 
 		var rrs []dns.RR
@@ -314,11 +314,13 @@ func (u *DesecUpdater) Update(signer *Signer, zone, owner string,
 	json.NewEncoder(bytebuf).Encode(desecRRsets)
 
 	api := GetUpdater("desec-api").GetApi()
-	api.DesecTokenRefresh()
-	fmt.Printf("DesecUpdater: deSEC API url: %s. token: %s Data: %v\n",
-		endpoint, api.apiKey, desecRRsets)
+	fmt.Printf("DesecUpdater: deSEC API url: %s. Data: %v\n", endpoint, desecRRsets)
 
-	status, buf, err := api.Put(endpoint, bytebuf.Bytes())
+	status, buf, err := api.DesecRequest(signer, zone, func() (int, []byte, error) {
+		return DesecHoldRetry("desec.Update "+endpoint, func() (int, []byte, error) {
+			return api.Put(endpoint, bytebuf.Bytes())
+		}, func() http.Header { return api.LastHeader })
+	})
 	if err != nil {
 		log.Printf("Error from GenericAPIpost (desec): %v\n", err)
 		return fmt.Errorf("Error from deSEC API for %s: %v",
@@ -343,13 +345,10 @@ func CreateDesecRRset(zone, owner string,
 	rrset []dns.RR, remove bool) (DesecRRset, error) {
 	var rdata []string
 	var err error
-	subname := "" // most common case
-	if owner != zone {
-		subname = DesecSubname(zone, owner, false)
-	}
-
 	rr := rrset[0]
 	rrtype := rr.Header().Rrtype
+	// the owner of the RRset, as the managed records are not necessarily at the apex
+	subname := DesecSubname(zone, rr.Header().Name, false)
 
 	if remove {
 		rdata = []string{}
@@ -364,23 +363,11 @@ func CreateDesecRRset(zone, owner string,
 	log.Printf("CreateDesecRRset: creating update of RRset '%s IN %s\n",
 		owner, dns.TypeToString[rrtype])
 
-	rrType := dns.TypeToString[rrtype]
-	data := DesecRRset{}
-
-	if rrType == "DNSKEY" || rrType == "CDS" || rrType == "CDNSKEY" || rrType == "CSYNC" || rrType == "NS" {
-		data = DesecRRset{
-			RRtype: dns.TypeToString[rrtype],
-			TTL:    3600,
-			RData:  rdata,
-		}
-		fmt.Printf("###### dis is our data %v\n", data)
-	} else {
-		data = DesecRRset{
-			Subname: subname,
-			RRtype:  dns.TypeToString[rrtype],
-			TTL:     3600,
-			RData:   rdata,
-		}
+	data := DesecRRset{
+		Subname: subname,
+		RRtype:  dns.TypeToString[rrtype],
+		TTL:     3600,
+		RData:   rdata,
 	}
 
 	fmt.Printf("CreateDesecRRset: data: %v\n", data)
@@ -0,0 +1,103 @@
+package music
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestDesecSubname(t *testing.T) {
+	tests := []struct {
+		zone, owner string
+		urluse      bool
+		want        string
+	}{
+		{"example.com", "example.com", true, "@"},
+		{"example.com.", "example.com.", false, ""},
+		{"example.com", "www.example.com", false, "www"},
+		{"example.com.", "child.example.com.", false, "child"},
+		{"example.com", "a.b.example.com.", false, "a.b"},
+		{"example.com.", "notexample.com.", false, "notexample.com."},
+	}
+	for _, tt := range tests {
+		if got := DesecSubname(tt.zone, tt.owner, tt.urluse); got != tt.want {
+			t.Errorf("DesecSubname(%q, %q, %v) = %q, want %q", tt.zone, tt.owner, tt.urluse,
+				got, tt.want)
+		}
+	}
+}
+
+func TestDesecRequestRelogin(t *testing.T) {
+	logins := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth/login/" {
+			logins++
+			w.Write([]byte(`{"token": "new", "max_age": "7 00:00:00", "max_unused_period": "01:00:00"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "token new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	tokvip := viper.New()
+	tokvip.Set("desec.token", "expired")
+	tokvip.Set("desec.maxunused", "1h")
+	tokvip.Set("desec.touched", time.Now().Format(layout))
+	api := &Api{Client: srv.Client(), BaseUrl: srv.URL, Authmethod: "Authorization", TokViper: tokvip}
+	s := &Signer{Name: "desec", zoneTokens: map[string]string{"scoped.example.": "expired"}}
+
+	status, _, err := api.DesecRequest(s, "example.", func() (int, []byte, error) {
+		return api.Get("/domains/example/")
+	})
+	if err != nil || status != http.StatusOK || logins != 1 {
+		t.Errorf("account token: got status %d, error %v after %d logins, wanted 200 after 1",
+			status, err, logins)
+	}
+
+	_, _, err = api.DesecRequest(s, "scoped.example.", func() (int, []byte, error) {
+		return api.Get("/domains/scoped.example/")
+	})
+	if err == nil || logins != 1 {
+		t.Errorf("zone credential: got error %v after %d logins, wanted an error and no login", err, logins)
+	}
+}
+
+func TestDesecHoldRetry(t *testing.T) {
+	viper.Set("signers.desec.limits.holds", 2)
+	defer viper.Set("signers.desec.limits.holds", nil)
+
+	throttled := 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if throttled > 0 {
+			throttled--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"detail": "Request was throttled. Expected available in 60 seconds."}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	api := &Api{Client: srv.Client(), BaseUrl: srv.URL, apiKey: "token", Authmethod: "Authorization"}
+	send := func() (int, []byte, error) { return api.Get("/domains/") }
+	header := func() http.Header { return api.LastHeader }
+
+	status, _, err := DesecHoldRetry("test", send, header)
+	if err != nil || status != http.StatusOK || throttled != 0 {
+		t.Errorf("DesecHoldRetry: got status %d, error %v, wanted 200 after two holds", status, err)
+	}
+
+	throttled = 3 // more than the holds allowed
+	status, _, err = DesecHoldRetry("test", send, header)
+	if err == nil || status != http.StatusTooManyRequests || throttled != 0 {
+		t.Errorf("DesecHoldRetry: got status %d, error %v, wanted 429 and an error", status, err)
+	}
+}
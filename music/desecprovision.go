@@ -0,0 +1,138 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/spf13/viper"
+)
+
+// deSEC domain provisioning. deSEC only accepts RRsets for domains that exist in the
+// account, so normally the domain must be created by hand before a zone can join a signer
+// group with a deSEC signer. With signers.desec.provision set, MUSIC creates the domain
+// when the zone joins the group and deletes it again when the zone is removed from MUSIC.
+// Only domains that MUSIC created are ever deleted.
+
+// no domains are created in dry-run or observer mode
+func desecProvisionActive() bool {
+	return viper.GetBool("signers.desec.provision") && !viper.GetBool("signers.dryrun") &&
+		!ObserverMode()
+}
+
+func isDesecSigner(s *Signer) bool {
+	return s.Method == "desec-api" || s.Method == "rldesec-api"
+}
+
+// desecRequest sends a request of the class (see ratelimiter.go) to the deSEC signer s
+// concerning zone, see DesecRequest.
+func desecRequest(s *Signer, zone, class string, send func(api *Api) (int, []byte, error)) (int, []byte, error) {
+	GetRateLimiter("desec").Wait(s.Name, class)
+	api := GetUpdater("desec-api").GetApi() // kludge, see DesecUpdater.FetchRRset
+	return api.DesecRequest(s, zone, func() (int, []byte, error) { return send(&api) })
+}
+
+// desecProvisionZone creates the domain at every deSEC signer in the group that does not
+// have it yet.
+func (mdb *MusicDB) desecProvisionZone(tx *sql.Tx, zone string, sg *SignerGroup) ([]string, error) {
+	var msgs []string
+	if !desecProvisionActive() || sg == nil {
+		return msgs, nil
+	}
+	for _, s := range sg.SignerMap {
+		if !isDesecSigner(s) {
+			continue
+		}
+		domain := StripDot(zone)
+
+		status, _, err := desecRequest(s, zone, OpRead, func(api *Api) (int, []byte, error) {
+			return api.Get(fmt.Sprintf("/domains/%s/", domain))
+		})
+		if err != nil {
+			return msgs, fmt.Errorf("Unable to look up %s at deSEC signer %s: %v", zone, s.Name, err)
+		}
+		if status == 200 {
+			continue // created by someone else, leave it alone
+		}
+		if status != 404 {
+			return msgs, fmt.Errorf("Unable to look up %s at deSEC signer %s: status %d", zone,
+				s.Name, status)
+		}
+
+		bytebuf := new(bytes.Buffer)
+		json.NewEncoder(bytebuf).Encode(ZoneName{Name: domain})
+		status, buf, err := desecRequest(s, zone, OpWriteDomain, func(api *Api) (int, []byte, error) {
+			return api.Post("/domains/", bytebuf.Bytes())
+		})
+		if err != nil {
+			return msgs, fmt.Errorf("Unable to create %s at deSEC signer %s: %v", zone, s.Name, err)
+		}
+		if status != 201 {
+			return msgs, fmt.Errorf("Unable to create %s at deSEC signer %s: status %d: %s", zone,
+				s.Name, status, string(buf))
+		}
+
+		const sqlq = "INSERT OR IGNORE INTO desec_provisioned(zone, signer, created) VALUES (?, ?, datetime('now'))"
+		_, err = tx.Exec(sqlq, zone, s.Name)
+		if CheckSQLError("desecProvisionZone", sqlq, err, false) {
+			return msgs, err
+		}
+		log.Printf("desecProvisionZone: created domain %s at deSEC signer %s", zone, s.Name)
+		msgs = append(msgs, fmt.Sprintf("Domain %s created at deSEC signer %s.", zone, s.Name))
+	}
+	return msgs, nil
+}
+
+// desecDeprovisionZone deletes the domains that MUSIC created for the zone. Failures are
+// reported, the zone is removed from MUSIC regardless.
+func (mdb *MusicDB) desecDeprovisionZone(tx *sql.Tx, zone string) []string {
+	var msgs []string
+
+	const sqlq = "SELECT signer FROM desec_provisioned WHERE zone=?"
+	rows, err := tx.Query(sqlq, zone)
+	if CheckSQLError("desecDeprovisionZone", sqlq, err, false) {
+		return msgs
+	}
+	var signers []string
+	for rows.Next() {
+		var signer string
+		if err := rows.Scan(&signer); err != nil {
+			log.Fatalf("desecDeprovisionZone: Error from rows.Scan(): %v", err)
+		}
+		signers = append(signers, signer)
+	}
+	rows.Close()
+
+	for _, name := range signers {
+		s, err := mdb.GetSigner(tx, &Signer{Name: name}, false) // not apisafe
+		if err != nil || !s.Exists {
+			msgs = append(msgs, fmt.Sprintf("Signer %s is gone, domain %s must be deleted at deSEC by hand.",
+				name, zone))
+		} else {
+			status, buf, err := desecRequest(s, zone, OpWriteDomain, func(api *Api) (int, []byte, error) {
+				return api.Delete(fmt.Sprintf("/domains/%s/", StripDot(zone)))
+			})
+			switch {
+			case err != nil:
+				msgs = append(msgs, fmt.Sprintf("Unable to delete %s at deSEC signer %s: %v", zone, name, err))
+				continue
+			case status != 204 && status != 404:
+				msgs = append(msgs, fmt.Sprintf("Unable to delete %s at deSEC signer %s: status %d: %s",
+					zone, name, status, string(buf)))
+				continue
+			}
+			log.Printf("desecDeprovisionZone: deleted domain %s at deSEC signer %s", zone, name)
+			msgs = append(msgs, fmt.Sprintf("Domain %s deleted at deSEC signer %s.", zone, name))
+		}
+		const sqlq2 = "DELETE FROM desec_provisioned WHERE zone=? AND signer=?"
+		_, err = tx.Exec(sqlq2, zone, name)
+		CheckSQLError("desecDeprovisionZone", sqlq2, err, false)
+	}
+	return msgs
+}
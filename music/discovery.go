@@ -0,0 +1,230 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Signer discovery. Given only a zone name, look up the delegation (via the resolver in
+// common.resolver, default the first nameserver in /etc/resolv.conf), query each name
+// server directly and match it against the known signers. Name servers that are not
+// known signers get a suggested signer record, which may be created right away. This is
+// meant to reduce the manual data entry when importing many zones.
+
+type DiscoveredNS struct {
+	NS            string
+	Addresses     []string
+	Authoritative bool
+	Serial        uint32
+	SerialScheme  string // "date" | "unixtime" | "counter"
+	Backend       string // known backend (from discovery.backends), if any
+	Signer        string // matching known signer, if any
+	Suggested     *Signer
+	Created       bool
+	Error         string
+}
+
+type DiscoveryResult struct {
+	Zone         string
+	NSes         []DiscoveredNS
+	SerialsAgree bool
+	Msg          string
+}
+
+// default NS name suffixes of known API based backends, extended by discovery.backends
+var defaultDiscoveryBackends = map[string]string{
+	"desec.io.":  "desec-api",
+	"desec.org.": "desec-api",
+}
+
+func discoveryResolver() (string, error) {
+	if r := viper.GetString("common.resolver"); r != "" {
+		if _, _, err := net.SplitHostPort(r); err != nil {
+			r = net.JoinHostPort(r, "53")
+		}
+		return r, nil
+	}
+	cc, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(cc.Servers) == 0 {
+		return "", fmt.Errorf("No resolver configured (common.resolver) and none found in /etc/resolv.conf")
+	}
+	return net.JoinHostPort(cc.Servers[0], cc.Port), nil
+}
+
+// SerialScheme guesses how the SOA serial of a zone is maintained.
+func SerialScheme(serial uint32, now time.Time) string {
+	if serial >= 1970010100 && serial <= 2099123199 {
+		if _, err := time.Parse("20060102", fmt.Sprintf("%d", serial/100)); err == nil {
+			return "date"
+		}
+	}
+	if d := now.Sub(time.Unix(int64(serial), 0)); d > -24*time.Hour && d < 10*365*24*time.Hour {
+		return "unixtime"
+	}
+	return "counter"
+}
+
+func discoveryBackend(ns string) string {
+	backends := map[string]string{}
+	for suffix, method := range defaultDiscoveryBackends {
+		backends[suffix] = method
+	}
+	for suffix, method := range viper.GetStringMapString("discovery.backends") {
+		backends[dns.Fqdn(strings.ToLower(suffix))] = method
+	}
+	for suffix, method := range backends {
+		if dns.IsSubDomain(suffix, ns) {
+			return method
+		}
+	}
+	return ""
+}
+
+func resolveQuestion(c *dns.Client, resolver, qname string, qtype uint16) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(qname, qtype)
+	r, _, err := DnsExchange(c, m, resolver)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%s %s: RCODE = %s", qname, dns.TypeToString[qtype],
+			dns.RcodeToString[r.Rcode])
+	}
+	return r.Answer, nil
+}
+
+// DiscoverSigners looks up the name servers of zone and matches them against the known
+// signers. If create is true, signer records are added for the name servers that are not
+// known signers and not served by an API based backend (those need credentials first).
+func (mdb *MusicDB) DiscoverSigners(zone string, create bool) (*DiscoveryResult, error) {
+	zone = dns.Fqdn(strings.ToLower(zone))
+	res := DiscoveryResult{Zone: zone, SerialsAgree: true}
+
+	resolver, err := discoveryResolver()
+	if err != nil {
+		return nil, err
+	}
+	c := &dns.Client{Timeout: 5 * time.Second}
+
+	answer, err := resolveQuestion(c, resolver, zone, dns.TypeNS)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to look up the NS RRset of %s: %v", zone, err)
+	}
+
+	signers, err := mdb.ListSigners(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var serial uint32
+	for _, rr := range answer {
+		nsrr, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		dn := DiscoveredNS{NS: strings.ToLower(nsrr.Ns)}
+		dn.Backend = discoveryBackend(dn.NS)
+
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			addrs, err := resolveQuestion(c, resolver, dn.NS, qtype)
+			if err != nil {
+				dn.Error = err.Error()
+				continue
+			}
+			for _, a := range addrs {
+				switch a := a.(type) {
+				case *dns.A:
+					dn.Addresses = append(dn.Addresses, a.A.String())
+				case *dns.AAAA:
+					dn.Addresses = append(dn.Addresses, a.AAAA.String())
+				}
+			}
+		}
+
+		for name, s := range signers {
+			for _, addr := range dn.Addresses {
+				if s.Address == addr {
+					dn.Signer = name
+				}
+			}
+			if strings.EqualFold(dns.Fqdn(s.Address), dn.NS) || strings.EqualFold(dns.Fqdn(name), dn.NS) {
+				dn.Signer = name
+			}
+		}
+
+		if len(dn.Addresses) > 0 {
+			m := new(dns.Msg)
+			m.SetQuestion(zone, dns.TypeSOA)
+			m.RecursionDesired = false
+			r, _, err := DnsExchange(c, m, net.JoinHostPort(dn.Addresses[0], "53"))
+			if err != nil {
+				dn.Error = err.Error()
+			} else {
+				dn.Authoritative = r.Authoritative
+				for _, a := range r.Answer {
+					if soa, ok := a.(*dns.SOA); ok {
+						dn.Serial = soa.Serial
+						dn.SerialScheme = SerialScheme(soa.Serial, time.Now())
+						if serial != 0 && serial != soa.Serial {
+							res.SerialsAgree = false
+						}
+						serial = soa.Serial
+					}
+				}
+			}
+		}
+
+		if dn.Signer == "" && len(dn.Addresses) > 0 {
+			method := dn.Backend
+			if method == "" {
+				method = "ddns"
+			}
+			dn.Suggested = &Signer{
+				Name:    strings.TrimSuffix(dn.NS, "."),
+				Method:  method,
+				Address: dn.Addresses[0],
+				Port:    "53",
+				UseTcp:  true,
+				UseTSIG: true,
+			}
+		}
+		res.NSes = append(res.NSes, dn)
+	}
+	sort.Slice(res.NSes, func(i, j int) bool { return res.NSes[i].NS < res.NSes[j].NS })
+
+	if !create {
+		return &res, nil
+	}
+
+	var created []string
+	for i, dn := range res.NSes {
+		if dn.Suggested == nil || dn.Backend != "" {
+			continue
+		}
+		s := *dn.Suggested // dn.Signer == "", so there is no signer by this name
+		if _, err := mdb.AddSigner(nil, &s, ""); err != nil {
+			res.NSes[i].Error = err.Error()
+			continue
+		}
+		log.Printf("DiscoverSigners: zone %s: added signer %s (%s)", zone, s.Name, s.Address)
+		res.NSes[i].Created = true
+		created = append(created, s.Name)
+	}
+	if len(created) > 0 {
+		res.Msg = fmt.Sprintf("Added signers: %s. Note that they have no TSIG key yet.",
+			strings.Join(created, ", "))
+	}
+	return &res, nil
+}
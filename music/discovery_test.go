@@ -0,0 +1,30 @@
+package music
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSerialScheme(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		serial uint32
+		scheme string
+	}{
+		{2024053101, "date"},
+		{2024133101, "counter"}, // month 13
+		{uint32(now.Add(-time.Hour).Unix()), "unixtime"},
+		{42, "counter"},
+	} {
+		if got := SerialScheme(tc.serial, now); got != tc.scheme {
+			t.Errorf("SerialScheme(%d) = %s, want %s", tc.serial, got, tc.scheme)
+		}
+	}
+
+	if got := discoveryBackend("ns1.desec.io."); got != "desec-api" {
+		t.Errorf("discoveryBackend(ns1.desec.io.) = %q, want desec-api", got)
+	}
+	if got := discoveryBackend("ns1.example.net."); got != "" {
+		t.Errorf("discoveryBackend(ns1.example.net.) = %q, want none", got)
+	}
+}
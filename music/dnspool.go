@@ -0,0 +1,172 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Connection pool for DNS over TCP (and TLS). Setting up a new TCP (and possibly TLS)
+// connection for every query dominates the latency for remote signers, so connections
+// are kept open and reused for later queries to the same address. Connections that
+// have been idle for longer than common.dnspool.idle seconds (default 30) are closed.
+// Queries over UDP are sent as usual.
+
+const dnsPoolMaxIdle = 4 // per address
+
+type pooledConn struct {
+	conn *dns.Conn
+	used time.Time
+}
+
+var dnsPool = struct {
+	sync.Mutex
+	conns map[string][]pooledConn
+}{conns: map[string][]pooledConn{}}
+
+func dnsPoolIdle() time.Duration {
+	idle := viper.GetInt("common.dnspool.idle")
+	if idle <= 0 {
+		idle = 30
+	}
+	return time.Duration(idle) * time.Second
+}
+
+func dnsPoolGet(key string) *dns.Conn {
+	dnsPool.Lock()
+	defer dnsPool.Unlock()
+
+	idle := dnsPoolIdle()
+	conns := dnsPool.conns[key]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(pc.used) < idle {
+			dnsPool.conns[key] = conns
+			return pc.conn
+		}
+		pc.conn.Close()
+	}
+	delete(dnsPool.conns, key)
+	return nil
+}
+
+func dnsPoolPut(key string, conn *dns.Conn) {
+	dnsPool.Lock()
+	defer dnsPool.Unlock()
+
+	if len(dnsPool.conns[key]) >= dnsPoolMaxIdle {
+		conn.Close()
+		return
+	}
+	dnsPool.conns[key] = append(dnsPool.conns[key], pooledConn{conn: conn, used: time.Now()})
+}
+
+// CloseDnsPool closes all idle connections.
+func CloseDnsPool() {
+	dnsPool.Lock()
+	defer dnsPool.Unlock()
+
+	for key, conns := range dnsPool.conns {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+		delete(dnsPool.conns, key)
+	}
+}
+
+func dnsConnExchange(c *dns.Client, conn *dns.Conn, m *dns.Msg) (*dns.Msg, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Second // same as the dns package
+	}
+
+	conn.TsigSecret = c.TsigSecret
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err := conn.WriteMsg(m); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	r, err := conn.ReadMsg()
+	if err == nil && r.Id != m.Id {
+		err = dns.ErrId
+	}
+	return r, err
+}
+
+// DnsExchange sends m to addr using the client c, just like c.Exchange(), but over
+// a pooled connection when c uses TCP or TLS. All DNS traffic from musicd should go
+// through here, so that it is included in the query log.
+func DnsExchange(c *dns.Client, m *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+	return exchangeVia(c, m, addr, jumpHost{})
+}
+
+// exchangeVia is DnsExchange via the jump host jh (none if jh.proxy is empty).
+func exchangeVia(c *dns.Client, m *dns.Msg, addr string, jh jumpHost) (*dns.Msg, time.Duration, error) {
+	r, rtt, err := dnsExchange(c, m, addr, jh)
+	logQuery(c.Net, addr, m, r, rtt, err)
+	backpressureQuery(addr, r, err)
+	return r, rtt, err
+}
+
+func dnsExchange(c *dns.Client, m *dns.Msg, addr string, jh jumpHost) (*dns.Msg, time.Duration, error) {
+	if c.Net == "https" {
+		return dohExchange(c, m, addr) // addr is the DoH URL, see signertransport.go
+	}
+	// signers behind a jump host are always reached over TCP through the jump host
+	viaproxy := jh.proxy != ""
+	if !viaproxy && c.Net != "tcp" && c.Net != "tcp-tls" {
+		return c.Exchange(m, addr)
+	}
+
+	key := c.Net + "|" + addr
+	if viaproxy {
+		key = jh.proxy + "|" + c.Net + "|" + addr
+	}
+	t := time.Now()
+
+	if conn := dnsPoolGet(key); conn != nil {
+		r, err := dnsConnExchange(c, conn, m)
+		if err == nil {
+			dnsPoolPut(key, conn)
+			return r, time.Since(t), nil
+		}
+		// the server has most likely closed the idle connection; retry on a new one
+		conn.Close()
+		t = time.Now()
+	}
+
+	var conn *dns.Conn
+	var err error
+	if viaproxy {
+		conn, err = dialJumpHost(jh, addr)
+		if err == nil && c.Net == "tcp-tls" {
+			// TLS end-to-end to the signer, through the tunnel
+			tc := tls.Client(conn.Conn, c.TLSConfig)
+			if err = tc.Handshake(); err != nil {
+				tc.Close()
+				return nil, 0, err
+			}
+			conn.Conn = tc
+		}
+	} else {
+		conn, err = c.Dial(addr)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := dnsConnExchange(c, conn, m)
+	if err != nil {
+		conn.Close()
+		return r, time.Since(t), err
+	}
+	dnsPoolPut(key, conn)
+	return r, time.Since(t), nil
+}
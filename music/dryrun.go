@@ -0,0 +1,164 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Dry-run mode. With signers.dryrun set (or the zone metadata "dryrun" set to "true", or
+// a zone type that declares DryRun) updates and removals are not sent to the signers. Instead the exact RRs are logged
+// and recorded in the proposed_changes table, so that the operator can review them
+// ('music-cli show dryrun') before switching to live mode. Fetches are still done, so
+// that the preconditions run against the real signer data. Note that a process in
+// dry-run will stop at the first post-condition that checks the signers for the changes
+// that were never made.
+
+const DryRunKey = "dryrun"
+
+type DryRunChange struct {
+	ID     int
+	Time   time.Time
+	Signer string
+	Zone   string
+	Owner  string
+	Op     string // "insert" | "remove" | "remove-rrset"
+	RRs    []string
+}
+
+// proposedChangesMax is the max number of proposed changes kept; older ones are dropped.
+const proposedChangesMax = 10000
+
+func (mdb *MusicDB) zoneDryRun(zone string) bool {
+	if viper.GetBool("signers.dryrun") || ObserverMode() {
+		return true
+	}
+	if mdb == nil {
+		return false
+	}
+	var zonetype string
+	const sqlq = "SELECT zonetype FROM zones WHERE name=?"
+	if err := mdb.db.QueryRow(sqlq, zone).Scan(&zonetype); err == nil {
+		if zt, err := GetZoneType(zonetype); err == nil && zt.DryRun {
+			return true
+		}
+	}
+	value, _, err := mdb.GetMeta(nil, &Zone{Name: zone, Exists: true}, DryRunKey)
+	return err == nil && value == "true"
+}
+
+func (mdb *MusicDB) dryRunRecord(signer, zone, owner, op string, rrsets [][]dns.RR) {
+	var rrs []string
+	for _, rrset := range rrsets {
+		for _, rr := range rrset {
+			rrs = append(rrs, rr.String())
+		}
+	}
+	if len(rrs) == 0 {
+		return
+	}
+	log.Printf("DRY-RUN: signer %s: zone %s: would %s:\n\t%s", signer, zone, op,
+		strings.Join(rrs, "\n\t"))
+	if mdb == nil {
+		return
+	}
+
+	const sqlq = `
+INSERT INTO proposed_changes(time, signer, zone, owner, op, rrs) VALUES (?, ?, ?, ?, ?, ?)`
+	res, err := mdb.db.Exec(sqlq, time.Now().UTC().Format(layout), signer, zone, owner, op,
+		strings.Join(rrs, "\n"))
+	if CheckSQLError("dryRunRecord", sqlq, err, false) {
+		return
+	}
+	if id, err := res.LastInsertId(); err == nil && id > proposedChangesMax {
+		const dsql = "DELETE FROM proposed_changes WHERE id <= ?"
+		_, err = mdb.db.Exec(dsql, id-proposedChangesMax)
+		CheckSQLError("dryRunRecord", dsql, err, false)
+	}
+}
+
+// ListDryRunChanges returns the proposed changes, optionally only those for zone.
+func (mdb *MusicDB) ListDryRunChanges(tx *sql.Tx, zone string) ([]DryRunChange, error) {
+	var res []DryRunChange
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListDryRunChanges: Error from mdb.StartTransaction(): %v\n", err)
+		return res, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT id, COALESCE(time, ''), signer, zone, owner, op, rrs FROM proposed_changes
+WHERE ? = '' OR zone = ? ORDER BY id`
+	rows, err := tx.Query(sqlq, zone, zone)
+	if CheckSQLError("ListDryRunChanges", sqlq, err, false) {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ch DryRunChange
+		var t, rrs string
+		if err = rows.Scan(&ch.ID, &t, &ch.Signer, &ch.Zone, &ch.Owner, &ch.Op, &rrs); err != nil {
+			return res, err
+		}
+		ch.Time, _ = time.Parse(layout, t)
+		ch.RRs = strings.Split(rrs, "\n")
+		res = append(res, ch)
+	}
+	return res, nil
+}
+
+// ClearDryRunChanges forgets the reviewed proposed changes (up to and including upto),
+// optionally only those for zone.
+func (mdb *MusicDB) ClearDryRunChanges(tx *sql.Tx, zone string, upto int) (int, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ClearDryRunChanges: Error from mdb.StartTransaction(): %v\n", err)
+		return 0, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "DELETE FROM proposed_changes WHERE id <= ? AND (? = '' OR zone = ?)"
+	res, err := tx.Exec(sqlq, upto, zone, zone)
+	if CheckSQLError("ClearDryRunChanges", sqlq, err, false) {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// DryRunUpdater wraps an updater and only records the changes when in dry-run mode.
+type DryRunUpdater struct {
+	Updater
+}
+
+func (u *DryRunUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	if !signer.MusicDB().zoneDryRun(zone) {
+		return u.Updater.Update(signer, zone, fqdn, inserts, removes)
+	}
+	mdb := signer.MusicDB()
+	if inserts != nil {
+		mdb.dryRunRecord(signer.Name, zone, fqdn, "insert", *inserts)
+	}
+	if removes != nil {
+		mdb.dryRunRecord(signer.Name, zone, fqdn, "remove", *removes)
+	}
+	return nil
+}
+
+func (u *DryRunUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	if !signer.MusicDB().zoneDryRun(zone) {
+		return u.Updater.RemoveRRset(signer, zone, fqdn, rrsets)
+	}
+	signer.MusicDB().dryRunRecord(signer.Name, zone, fqdn, "remove-rrset", rrsets)
+	return nil
+}
@@ -5,34 +5,101 @@
 package music
 
 import (
-        "database/sql"
+	"database/sql"
+	"fmt"
 	"log"
-	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
 )
 
 const (
 	AutoZones = `
-SELECT name, zonetype, fsm, fsmsigner, fsmstatus
+SELECT name, zonetype, state, fsmmode, COALESCE(statestamp, datetime('now')) AS timestamp,
+       fsm, fsmsigner, fsmstatus, COALESCE(sgroup, '') AS signergroup
 FROM zones WHERE fsmmode='auto' AND fsm != '' AND fsmstatus != 'blocked'`
 	AllAutoZones = `
-SELECT name, zonetype, fsm, fsmsigner, fsmstatus
-FROM zones WHERE fsmmode='auto' AND fsm != ''` 
+SELECT name, zonetype, state, fsmmode, COALESCE(statestamp, datetime('now')) AS timestamp,
+       fsm, fsmsigner, fsmstatus, COALESCE(sgroup, '') AS signergroup
+FROM zones WHERE fsmmode='auto' AND fsm != ''`
 )
 
 // PushZones: Try to move all "auto" zones forward through their respective processes until they
 //            hit a stop.
 //
+// The candidate zones are read in one pass, and the zone data from the query is used
+// directly, i.e. there is no second lookup per zone. A zone is only fully loaded (with
+// its signer group) right before it is pushed, so that the engine never holds more than
+// one loaded zone per worker. The returned zones only contain the name and FSM status of
+// the zones that were pushed.
+//
+// With fsmengine.workers (default 1) > 1 the zones are pushed by a pool of that many
+// workers, so that a zone waiting for DNS does not hold up the others. The zones of one
+// signer group are pushed one after another by the same worker, as they act on the same
+// signers; zones in different signer groups are pushed concurrently. The workers hold no
+// transaction while waiting for DNS (see AttemptStateTransition), so that they only
+// contend for the database in short transactions. If the candidates can not be read no
+// zone is pushed, and the first error of any worker is returned. With one worker (or
+// when called within a transaction) each zone is pushed in the transaction as it is read.
+//
 // Note that we also need to add management for:
 // (a) trying stopped zones, but less frequently, as they may have become unwedged
 // (b)
 
 func (mdb *MusicDB) PushZones(tx *sql.Tx, checkzones map[string]bool, checkall bool) ([]Zone, error) {
+	// duplicate fetches within this run are served from the query cache
+	defer BeginQueryCycle()()
+
+	cached := len(checkzones) == 0 // see loadPushZone
+	if workers := FSMWorkers(); workers > 1 && tx == nil {
+		var candidates []*Zone
+		zones, err := mdb.pushCandidates(nil, checkzones, checkall, func(z *Zone) error {
+			candidates = append(candidates, z)
+			return nil
+		})
+		if err != nil {
+			return zones, err
+		}
+		return zones, mdb.pushParallel(candidates, workers, cached)
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("PushZones: Error from mdb.StartTransaction(): %v\n", err)
+		return []Zone{}, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	return mdb.pushCandidates(tx, checkzones, checkall, func(z *Zone) error {
+		push, err := mdb.loadPushZone(tx, z, cached)
+		if !push {
+			return err
+		}
+		log.Printf("PushZones: pushing zone %s", z.Name)
+		return mdb.PushZone(tx, z)
+	})
+}
+
+// FSMWorkers returns the number of zones that PushZones may push at the same time.
+func FSMWorkers() int {
+	if workers := viper.GetInt("fsmengine.workers"); workers > 1 {
+		return workers
+	}
+	return 1
+}
+
+// pushCandidates calls f for each zone that PushZones should consider, as read from the
+// zones table, i.e. without its signer group (see loadPushZone). It returns the zones
+// to report and the first error encountered, of the query or of f.
+func (mdb *MusicDB) pushCandidates(tx *sql.Tx, checkzones map[string]bool, checkall bool,
+	f func(z *Zone) error) ([]Zone, error) {
 	var zones []Zone
 	var err error
 
 	localtx, tx, err := mdb.StartTransaction(tx)
 	if err != nil {
-		log.Printf("ZoneJoinGroup: Error from mdb.StartTransaction(): %v\n", err)
+		log.Printf("pushCandidates: Error from mdb.StartTransaction(): %v\n", err)
 		return zones, err
 	}
 	defer mdb.CloseTransaction(localtx, tx, err)
@@ -41,76 +108,205 @@ func (mdb *MusicDB) PushZones(tx *sql.Tx, checkzones map[string]bool, checkall b
 	if checkall {
 		sqlq = AllAutoZones
 	}
+	// zones delayed by SetDelayReason() are only pushed once the delay has passed
 
 	rows, err := tx.Query(sqlq)
-	if err != nil {
-		log.Printf("PushZones: Error from tx.Query(%s): %v", sqlq, err)
+	if CheckSQLError("PushZones", sqlq, err, false) {
 		return zones, err
 	}
 	defer rows.Close()
 
-	if CheckSQLError("PushZones", AutoZones, err, false) {
-		return zones, err
-	} else {
-		var name, zonetype, fsm, fsmsigner, fsmstatus string
-		for rows.Next() {
-			err := rows.Scan(&name, &zonetype, &fsm, &fsmsigner, &fsmstatus)
-			if err != nil {
-				log.Fatalf("PushZones: Error from rows.Scan: %v", err)
-			}
+	var pusherr error
+	seen := map[string]bool{} // a zone pushed in this transaction may be read again
+	var name, zonetype, state, fsmmode, timestamp, fsm, fsmsigner, fsmstatus, signergroup string
+	for rows.Next() {
+		err := rows.Scan(&name, &zonetype, &state, &fsmmode, &timestamp, &fsm, &fsmsigner,
+			&fsmstatus, &signergroup)
+		if err != nil {
+			log.Fatalf("PushZones: Error from rows.Scan: %v", err)
+		}
 
-			z := Zone{ Name: name, FSMStatus: fsmstatus }
+		if len(checkzones) != 0 && !checkzones[name] {
+			continue
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if !OwnsZone(name, signergroup) {
+			continue // another musicd instance takes care of the zone, see sharding.go
+		}
+		zones = append(zones, Zone{Name: name, FSMStatus: fsmstatus})
 
-			if len(checkzones) == 0 {
-			   zones = append(zones, z)
-			} else {
-			   if checkzones[name] {
-			      zones = append(zones, z)
-			   }
-			}
+		t, err := time.Parse(layout, timestamp)
+		if err != nil {
+			log.Printf("PushZones: zone %s: Error from time.Parse(): %v", name, err)
+			continue
+		}
+		z := &Zone{
+			Name:       name,
+			Exists:     true,
+			ZoneType:   zonetype,
+			State:      state,
+			FSMMode:    fsmmode,
+			FSMStatus:  fsmstatus,
+			Statestamp: t,
+			FSM:        fsm,
+			FSMSigner:  fsmsigner,
+			SGname:     signergroup,
+			MusicDB:    mdb,
+		}
+		if err := f(z); err != nil && pusherr == nil {
+			pusherr = err // save first error encountered
+		}
+	}
+	return zones, pusherr
+}
+
+// loadPushZone loads the signer group of a zone read by pushCandidates and reports
+// whether the zone is to be pushed now. Unless cached is false (i.e. when zones are
+// checked explicitly), zones that are waiting for propagation or whose pre-conditions
+// failed recently are left for now.
+func (mdb *MusicDB) loadPushZone(tx *sql.Tx, z *Zone, cached bool) (bool, error) {
+	if z.FSMStatus == "delayed" {
+		until, delayed, err := mdb.ZoneDelayedUntil(tx, z)
+		if delayed {
+			log.Printf("PushZones: zone %s is delayed until %v. Leaving for now.",
+				z.Name, until.Format(time.RFC3339))
+		}
+		if err != nil || delayed {
+			return false, err
 		}
 	}
 
-	var tmperr error
-	if len(zones) > 0 {
-	   	      zonelist := []string{}
-		      for _, z := range zones {
-			    zonelist = append(zonelist, z.Name)
-		      }
+	sg, err := mdb.GetSignerGroup(tx, z.SGname, false) // not apisafe
+	if err != nil {
+		ReportError("fsmengine", err, map[string]string{"zone": z.Name, "signergroup": z.SGname})
+		return false, err
+	}
+	z.SGroup = sg
+	z.SGname = sg.Name
+
+	for _, s := range sg.SignerMap {
+		if until, open := SignerBreakerOpen(s.Name); open {
+			z.SetStopReason(fmt.Sprintf("Waiting on signer %s (circuit breaker open until %s)",
+				s.Name, until.Format(time.RFC3339)))
+			return false, nil
+		}
+	}
+	if s, stuck := ZoneStuck(z.Name); stuck {
+		log.Printf("PushZones: zone %s: %s. Leaving for now.", z.Name, s)
+		return false, nil
+	}
+
+	z.NextState = map[string]bool{}
+	for k := range mdb.FSMlist[z.FSM].States[z.State].Next {
+		z.NextState[k] = true
+	}
+
+	if cached {
+		if next, due := mdb.soaHintDue(z); !due {
+			log.Printf("PushZones: zone %s is waiting for propagation, next check at %s (SOA hint)",
+				z.Name, next.Format(time.RFC3339))
+			return false, nil
+		}
+		if recheck, cached := mdb.preconditionsCached(z); cached {
+			log.Printf("PushZones: zone %s: pre-conditions failed recently, next check at %s (TTL)",
+				z.Name, recheck.Format(time.RFC3339))
+			return false, nil
+		}
+	}
+	return true, nil
+}
 
-		log.Printf("PushZones: will push on these zones: %v", strings.Join(zonelist, " "))
-		for _, z := range zones {
-		        if z.FSMStatus == "delayed" {
-			   log.Printf("PushZones: zone %s is delayed until %v. Leaving for now.",
-			   			  z.Name, "time-when zone-has-waited-long-enough")
-			} else {
-				tmperr = mdb.PushZone(tx, z)
-				if err == nil {
-					err = tmperr // save first error encountered
+// pushParallel loads and pushes the zones with a pool of workers, one lane (see
+// pushLanes) at a time per worker, and returns the first error encountered.
+func (mdb *MusicDB) pushParallel(candidates []*Zone, workers int, cached bool) error {
+	lanes := mdb.pushLanes(candidates)
+	if workers > len(lanes) {
+		workers = len(lanes)
+	}
+
+	var mu sync.Mutex
+	var pusherr error
+	jobs := make(chan []*Zone)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for lane := range jobs {
+				for _, z := range lane {
+					push, err := mdb.loadPushZone(nil, z, cached)
+					if push {
+						log.Printf("PushZones: worker %d pushing zone %s", worker, z.Name)
+						err = mdb.PushZone(nil, z)
+					}
+					if err != nil {
+						mu.Lock()
+						if pusherr == nil {
+							pusherr = err // save first error encountered
+						}
+						mu.Unlock()
+					}
 				}
 			}
-		}
-	} 
-	return zones, err
+		}(w)
+	}
+	for _, lane := range lanes {
+		jobs <- lane
+	}
+	close(jobs)
+	wg.Wait()
+	return pusherr
 }
 
-func (mdb *MusicDB) PushZone(tx *sql.Tx, z Zone) error {
-	dbzone, _, err := mdb.GetZone(tx, z.Name)
-	if err != nil {
-	   return err
+// pushLanes splits the zones into lanes that may be pushed concurrently. The zones of a
+// signer group are in one lane, in order. The signer groups with a zone that is about to
+// start a process of a process group (processgroups.go) all share one lane, so that two
+// zones can not both be let in while there is room for one.
+func (mdb *MusicDB) pushLanes(push []*Zone) [][]*Zone {
+	admission := map[string]bool{} // signer groups
+	for _, z := range push {
+		if mdb.startsGroupedProcess(z) {
+			admission[z.SGname] = true
+		}
 	}
-	success, _, _ := mdb.ZoneStepFsm(tx, dbzone, "")
-	oldstate := dbzone.State
-	if success {
-		dbzone, _, err := mdb.GetZone(tx, z.Name)
-		if err != nil {
-		   return err
+
+	var lanes [][]*Zone
+	index := map[string]int{}
+	for _, z := range push {
+		key := "zone:" + z.Name // zones without signer group are independent
+		switch {
+		case admission[z.SGname]:
+			key = "process-groups"
+		case z.SGname != "":
+			key = "group:" + z.SGname
 		}
-		log.Printf("PushZone: successfully transitioned zone '%s' from '%s' to '%s'",
-			z.Name, oldstate, dbzone.State)
+		i, exist := index[key]
+		if !exist {
+			i = len(lanes)
+			index[key] = i
+			lanes = append(lanes, nil)
+		}
+		lanes[i] = append(lanes[i], z)
+	}
+	return lanes
+}
+
+// PushZone attempts to move the zone one step forward in its process. The zone must be
+// fully loaded (as by GetZone).
+func (mdb *MusicDB) PushZone(tx *sql.Tx, z *Zone) error {
+	defer ReportPanics("fsmengine", map[string]string{"zone": z.Name, "process": z.FSM,
+		"state": z.State, "signer": z.FSMSigner, "signergroup": z.SGname})
+
+	oldstate := z.State
+	success, msg, err := mdb.ZoneStepFsm(tx, z, "")
+	if success {
+		log.Printf("PushZone: zone '%s' (was in state '%s'): %s", z.Name, oldstate, msg)
 	} else {
-		log.Printf("PushZone: failed to transition zone '%s' from state '%s'",
-			z.Name, oldstate)
+		log.Printf("PushZone: failed to transition zone '%s' from state '%s' (err: %v)",
+			z.Name, oldstate, err)
 	}
-	return nil
+	return err
 }
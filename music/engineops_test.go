@@ -0,0 +1,100 @@
+package music
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestPushLanes(t *testing.T) {
+	viper.Set("signers.concurrency.groups", map[string]interface{}{
+		"rollovers": map[string]interface{}{"processes": []string{"ksk-rollover"}},
+	})
+	defer viper.Set("signers.concurrency.groups", nil)
+
+	mdb := &MusicDB{FSMlist: map[string]FSM{
+		"ksk-rollover": {InitialState: "start"},
+		"add-signer":   {InitialState: "start"},
+	}}
+	zones := []*Zone{
+		{Name: "a1.", SGname: "a", FSM: "add-signer", State: "start"},
+		{Name: "b1.", SGname: "b", FSM: "add-signer", State: "start"},
+		{Name: "a2.", SGname: "a", FSM: "add-signer", State: "later"},
+		{Name: "c1.", SGname: "c", FSM: "ksk-rollover", State: "start"},
+		{Name: "d1.", SGname: "d", FSM: "ksk-rollover", State: "later"},
+		{Name: "e1.", SGname: "e", FSM: "ksk-rollover", State: "start"},
+		{Name: "d2.", SGname: "d", FSM: "add-signer", State: "start"},
+	}
+
+	var got []string
+	for _, lane := range mdb.pushLanes(zones) {
+		var names string
+		for _, z := range lane {
+			names += z.Name
+		}
+		got = append(got, names)
+	}
+	want := []string{"a1.a2.", "b1.", "c1.e1.", "d1.d2."}
+	if len(got) != len(want) {
+		t.Fatalf("pushLanes() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("lane %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPushParallel(t *testing.T) {
+	viper.Set("fsmengine.workers", 4)
+	defer viper.Set("fsmengine.workers", nil)
+
+	mdb, err := NewDB(filepath.Join(t.TempDir(), "music.db"), "", false)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	step := func(z *Zone) bool {
+		time.Sleep(20 * time.Millisecond) // waiting for DNS
+		_, err := mdb.ZoneSetMeta(nil, z, "pushed", "yes")
+		return err == nil
+	}
+	mdb.FSMlist["test"] = FSM{InitialState: "start", States: map[string]FSMState{
+		"start": {Next: map[string]FSMTransition{"done": {PreCondition: step, Action: step,
+			PostCondition: step}}},
+	}}
+
+	for i := 0; i < 12; i++ {
+		sg := fmt.Sprintf("sg%d", i%4)
+		for _, sqlq := range []string{
+			"INSERT OR IGNORE INTO signergroups(name) VALUES (?)",
+			"INSERT INTO zones(name, state, fsm, fsmmode, sgroup) VALUES (? || '.', 'start', 'test', 'auto', ?)",
+		} {
+			args := []interface{}{sg}
+			if strings.HasPrefix(sqlq, "INSERT INTO zones") {
+				args = []interface{}{fmt.Sprintf("z%d", i), sg}
+			}
+			if _, err := mdb.Exec(sqlq, args...); err != nil {
+				t.Fatalf("%s: %v", sqlq, err)
+			}
+		}
+	}
+
+	if _, err := mdb.PushZones(nil, map[string]bool{}, false); err != nil {
+		t.Errorf("PushZones: %v", err)
+	}
+	var moved, marked int
+	mdb.db.QueryRow("SELECT COUNT(*) FROM zones WHERE state='done'").Scan(&moved)
+	mdb.db.QueryRow("SELECT COUNT(*) FROM metadata WHERE key='pushed'").Scan(&marked)
+	if moved != 12 || marked != 12 {
+		t.Errorf("PushZones: %d zones moved and %d marked, want 12", moved, marked)
+	}
+
+	// the process has no state 'done', so now every push fails in its worker
+	if _, err := mdb.PushZones(nil, map[string]bool{}, false); err == nil {
+		t.Errorf("PushZones: the error of a worker was not returned")
+	}
+}
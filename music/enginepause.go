@@ -0,0 +1,221 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Engine pauses. During an incident the operator may want to stop MuSiC from changing
+// anything without stopping the daemon (and with it the API and the read-only checks).
+// A pause applies to one of:
+//
+//   engine:  all zones; no zone takes a step in any process.
+//   process: the zones running the named process.
+//   signer:  the zones that have the named signer in their signer group; also no
+//            updates at all are sent to the signer, not even those requested via the API.
+//
+// As with backpressure, the pre-conditions are still evaluated while paused, only the
+// actions are not taken. The pauses are kept in the DB and thus survive restarts.
+
+const (
+	PauseEngine  = "engine"
+	PauseProcess = "process"
+	PauseSigner  = "signer"
+)
+
+type EnginePause struct {
+	Scope  string
+	Target string // process or signer name, "" for the engine
+	Reason string
+	Since  time.Time
+}
+
+func (mdb *MusicDB) PauseEngine(tx *sql.Tx, scope, target, reason string) (string, error) {
+	switch scope {
+	case PauseEngine:
+		target = ""
+	case PauseProcess:
+		if _, ok := mdb.FSMlist[target]; !ok {
+			return "", fmt.Errorf("Process %s is unknown.", target)
+		}
+	case PauseSigner:
+		if dbsigner, _ := mdb.GetSignerByName(tx, target, false); dbsigner == nil || !dbsigner.Exists {
+			return "", fmt.Errorf("Signer %s is unknown.", target)
+		}
+	default:
+		return "", fmt.Errorf("Unknown pause scope '%s'. Known scopes are: %s, %s, %s", scope,
+			PauseEngine, PauseProcess, PauseSigner)
+	}
+	if reason == "" {
+		reason = "no reason given"
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("PauseEngine: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "INSERT OR REPLACE INTO engine_pauses(scope, target, reason, since) VALUES (?, ?, ?, datetime('now'))"
+	_, err = tx.Exec(sqlq, scope, target, reason)
+	if CheckSQLError("PauseEngine", sqlq, err, false) {
+		return "", err
+	}
+	log.Printf("Engine paused (%s): %s", pauseName(scope, target), reason)
+	return fmt.Sprintf("Paused %s (%s).", pauseName(scope, target), reason), nil
+}
+
+func (mdb *MusicDB) ResumeEngine(tx *sql.Tx, scope, target string) (string, error) {
+	if scope == PauseEngine {
+		target = ""
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ResumeEngine: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "DELETE FROM engine_pauses WHERE scope=? AND target=?"
+	res, err := tx.Exec(sqlq, scope, target)
+	if CheckSQLError("ResumeEngine", sqlq, err, false) {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Sprintf("%s was not paused.", pauseName(scope, target)), nil
+	}
+	log.Printf("Engine resumed (%s)", pauseName(scope, target))
+	return fmt.Sprintf("Resumed %s.", pauseName(scope, target)), nil
+}
+
+func (mdb *MusicDB) ListEnginePauses(tx *sql.Tx) ([]EnginePause, error) {
+	const sqlq = "SELECT scope, target, reason, COALESCE(since, '') FROM engine_pauses"
+
+	var rows *sql.Rows
+	var err error
+	if tx != nil {
+		rows, err = tx.Query(sqlq)
+	} else {
+		rows, err = mdb.db.Query(sqlq)
+	}
+	if CheckSQLError("ListEnginePauses", sqlq, err, false) {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pauses []EnginePause
+	for rows.Next() {
+		var ep EnginePause
+		var since string
+		if err := rows.Scan(&ep.Scope, &ep.Target, &ep.Reason, &since); err != nil {
+			return nil, err
+		}
+		ep.Since, _ = time.Parse("2006-01-02 15:04:05", since)
+		pauses = append(pauses, ep)
+	}
+	sort.Slice(pauses, func(i, j int) bool {
+		return pauseName(pauses[i].Scope, pauses[i].Target) < pauseName(pauses[j].Scope, pauses[j].Target)
+	})
+	return pauses, nil
+}
+
+func pauseName(scope, target string) string {
+	if scope == PauseEngine || target == "" {
+		return "the engine"
+	}
+	return fmt.Sprintf("%s %s", scope, target)
+}
+
+// pauseApplies returns the reason of the first pause that stops a zone running process
+// with the given signers, if any.
+func pauseApplies(pauses []EnginePause, process string, signers []string) (string, bool) {
+	for _, ep := range pauses {
+		switch ep.Scope {
+		case PauseEngine:
+		case PauseProcess:
+			if ep.Target != process {
+				continue
+			}
+		case PauseSigner:
+			found := false
+			for _, s := range signers {
+				if s == ep.Target {
+					found = true
+				}
+			}
+			if !found {
+				continue
+			}
+		default:
+			continue
+		}
+		return fmt.Sprintf("Paused: %s (%s)", pauseName(ep.Scope, ep.Target), ep.Reason), true
+	}
+	return "", false
+}
+
+// enginePaused returns the reason why the zone may not take any action now, if any.
+func (mdb *MusicDB) enginePaused(tx *sql.Tx, z *Zone) (string, bool) {
+	pauses, err := mdb.ListEnginePauses(tx)
+	if err != nil || len(pauses) == 0 {
+		return "", false
+	}
+	var signers []string
+	if z.SGroup != nil {
+		for name := range z.SGroup.SignerMap {
+			signers = append(signers, name)
+		}
+	}
+	return pauseApplies(pauses, z.FSM, signers)
+}
+
+// signerPaused returns the reason why no updates may be sent to the signer, if any.
+func (mdb *MusicDB) signerPaused(signer string) (string, bool) {
+	if mdb == nil {
+		return "", false
+	}
+	pauses, err := mdb.ListEnginePauses(nil)
+	if err != nil {
+		return "", false
+	}
+	for _, ep := range pauses {
+		if ep.Scope == PauseSigner && ep.Target == signer {
+			return ep.Reason, true
+		}
+	}
+	return "", false
+}
+
+func pausedError(signer, reason string) error {
+	return fmt.Errorf("Signer %s is paused (%s). Modifications refused.", signer, reason)
+}
+
+// PauseUpdater wraps an updater and refuses all modifications at paused signers.
+type PauseUpdater struct {
+	Updater
+}
+
+func (u *PauseUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	if reason, paused := signer.MusicDB().signerPaused(signer.Name); paused {
+		return pausedError(signer.Name, reason)
+	}
+	return u.Updater.Update(signer, zone, fqdn, inserts, removes)
+}
+
+func (u *PauseUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	if reason, paused := signer.MusicDB().signerPaused(signer.Name); paused {
+		return pausedError(signer.Name, reason)
+	}
+	return u.Updater.RemoveRRset(signer, zone, fqdn, rrsets)
+}
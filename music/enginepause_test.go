@@ -0,0 +1,34 @@
+package music
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPauseApplies(t *testing.T) {
+	pauses := []EnginePause{
+		{Scope: PauseProcess, Target: "ksk-rollover", Reason: "incident 17"},
+		{Scope: PauseSigner, Target: "signer2", Reason: "signer upgrade"},
+	}
+	tests := []struct {
+		process string
+		signers []string
+		paused  bool
+		reason  string
+	}{
+		{"add-signer", []string{"signer1"}, false, ""},
+		{"ksk-rollover", []string{"signer1"}, true, "incident 17"},
+		{"add-signer", []string{"signer1", "signer2"}, true, "signer upgrade"},
+	}
+	for _, tc := range tests {
+		reason, paused := pauseApplies(pauses, tc.process, tc.signers)
+		if paused != tc.paused || !strings.Contains(reason, tc.reason) {
+			t.Errorf("pauseApplies(%s, %v) = %q, %v", tc.process, tc.signers, reason, paused)
+		}
+	}
+
+	engine := append(pauses, EnginePause{Scope: PauseEngine, Reason: "all stop"})
+	if _, paused := pauseApplies(engine, "add-signer", nil); !paused {
+		t.Errorf("engine pause does not apply")
+	}
+}
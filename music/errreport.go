@@ -0,0 +1,233 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Error reporting. If errorreporting.dsn is set, panics and unexpected errors from the
+// engine, the managers and the API handlers are sent (with zone and signer context) to
+// a Sentry-compatible endpoint. Secrets (TSIG keys, API keys, tokens, passwords) are
+// scrubbed from everything that is sent. Reports are sent in the background and are
+// dropped rather than delaying the caller if the endpoint is slow.
+
+type errorEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type errorReporter struct {
+	storeurl string
+	authhdr  string
+	client   *http.Client
+	events   chan errorEvent
+	pending  sync.WaitGroup
+}
+
+var reporter struct {
+	once sync.Once
+	r    *errorReporter
+}
+
+// parseDSN returns the store endpoint and the public key of a Sentry DSN, which has
+// the form https://<publickey>@<host>[/<path>]/<projectid>.
+func parseDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+	if u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return "", "", fmt.Errorf("DSN %s lacks public key or host.", dsn)
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || i == len(path)-1 {
+		return "", "", fmt.Errorf("DSN %s lacks project id.", dsn)
+	}
+	store := fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:i], path[i+1:])
+	return store, u.User.Username(), nil
+}
+
+func getReporter() *errorReporter {
+	reporter.once.Do(func() {
+		dsn := viper.GetString("errorreporting.dsn")
+		if dsn == "" {
+			return
+		}
+		store, key, err := parseDSN(dsn)
+		if err != nil {
+			log.Printf("Error reporting disabled: %v", err)
+			return
+		}
+		r := &errorReporter{
+			storeurl: store,
+			authhdr:  fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=music/1.0", key),
+			client:   &http.Client{Timeout: 10 * time.Second},
+			events:   make(chan errorEvent, 100),
+		}
+		go r.sender()
+		reporter.r = r
+	})
+	return reporter.r
+}
+
+func (r *errorReporter) sender() {
+	for ev := range r.events {
+		buf, err := json.Marshal(ev)
+		if err == nil {
+			var req *http.Request
+			req, err = http.NewRequest(http.MethodPost, r.storeurl, bytes.NewReader(buf))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("X-Sentry-Auth", r.authhdr)
+				var resp *http.Response
+				resp, err = r.client.Do(req)
+				if err == nil {
+					resp.Body.Close()
+					if resp.StatusCode != http.StatusOK {
+						err = fmt.Errorf("status %d", resp.StatusCode)
+					}
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Error reporting: failed to send event %s: %v", ev.EventID, err)
+		}
+		r.pending.Done()
+	}
+}
+
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// TSIG auth strings: alg:name:secret
+	{regexp.MustCompile(`(hmac-[A-Za-z0-9]+:[^:\s]+:)[A-Za-z0-9+/=]+`), "${1}[scrubbed]"},
+	// Authorization headers
+	{regexp.MustCompile(`(?i)((?:token|bearer)\s+)[A-Za-z0-9._~+/=-]{8,}`), "${1}[scrubbed]"},
+	// key=value and key: value
+	{regexp.MustCompile(`(?i)((?:password|passwd|apikey|api-key|api_key|secret|token|tsigkey)["']?\s*[:=]\s*["']?)[^\s"',}]+`),
+		"${1}[scrubbed]"},
+}
+
+// scrubSecrets removes secrets from s, both the configured ones and anything that
+// looks like a secret.
+func scrubSecrets(s string) string {
+	for _, key := range []string{"apiserver.apikey", "signers.desec.password"} {
+		if secret := viper.GetString(key); len(secret) >= 4 {
+			s = strings.ReplaceAll(s, secret, "[scrubbed]")
+		}
+	}
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+func reportEvent(level, component, msg string, tags, extra map[string]string) {
+	r := getReporter()
+	if r == nil {
+		return
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	hostname, _ := os.Hostname()
+
+	ev := errorEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Level:       level,
+		Platform:    "go",
+		Logger:      component,
+		ServerName:  hostname,
+		Environment: viper.GetString("errorreporting.environment"),
+		Message:     scrubSecrets(msg),
+		Tags:        map[string]string{"component": component},
+		Extra:       map[string]string{},
+	}
+	for k, v := range tags {
+		if v != "" {
+			ev.Tags[k] = scrubSecrets(v)
+		}
+	}
+	for k, v := range extra {
+		ev.Extra[k] = scrubSecrets(v)
+	}
+
+	r.pending.Add(1)
+	select {
+	case r.events <- ev:
+	default:
+		r.pending.Done()
+		log.Printf("Error reporting: queue full, dropping event for %s", component)
+	}
+}
+
+// ReportError reports an unexpected error. The tags (e.g. "zone", "signer") give the
+// context of the error.
+func ReportError(component string, err error, tags map[string]string) {
+	if err == nil {
+		return
+	}
+	reportEvent("error", component, err.Error(), tags, nil)
+}
+
+// ReportPanics is deferred by goroutines that should report a panic before it crashes
+// musicd. The panic is reported (and sent) and then continues.
+func ReportPanics(component string, tags map[string]string) {
+	if r := recover(); r != nil {
+		ReportPanic(component, r, tags)
+		FlushErrorReports(5 * time.Second)
+		panic(r)
+	}
+}
+
+// ReportPanic reports a recovered panic, including the stack of the current goroutine.
+func ReportPanic(component string, r interface{}, tags map[string]string) {
+	reportEvent("fatal", component, fmt.Sprintf("panic: %v", r), tags,
+		map[string]string{"stack": string(debug.Stack())})
+}
+
+// FlushErrorReports waits (at most timeout) for queued reports to be sent.
+func FlushErrorReports(timeout time.Duration) {
+	r := getReporter()
+	if r == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		r.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
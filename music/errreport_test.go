@@ -0,0 +1,43 @@
+package music
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDSN(t *testing.T) {
+	store, key, err := parseDSN("https://abc123@errors.example.com/sentry/42")
+	if err != nil {
+		t.Fatalf("parseDSN: unexpected error: %v", err)
+	}
+	if store != "https://errors.example.com/sentry/api/42/store/" || key != "abc123" {
+		t.Errorf("parseDSN: got %s, %s", store, key)
+	}
+
+	for _, dsn := range []string{"https://errors.example.com/42", "https://abc@errors.example.com/"} {
+		if _, _, err := parseDSN(dsn); err == nil {
+			t.Errorf("parseDSN(%s): expected error", dsn)
+		}
+	}
+}
+
+func TestScrubSecrets(t *testing.T) {
+	tests := []struct {
+		in, secret string
+	}{
+		{"auth hmac-sha256:musiclab.:c2VjcmV0LWtleQ== rejected", "c2VjcmV0LWtleQ=="},
+		{"Authorization: Token 0123456789abcdef", "0123456789abcdef"},
+		{`{"password": "hunter22", "email": "x@example.com"}`, "hunter22"},
+		{"apikey=frotzblinger", "frotzblinger"},
+	}
+	for _, test := range tests {
+		got := scrubSecrets(test.in)
+		if strings.Contains(got, test.secret) || !strings.Contains(got, "[scrubbed]") {
+			t.Errorf("scrubSecrets(%q) = %q", test.in, got)
+		}
+	}
+
+	if got := scrubSecrets("zone example.com. not in sync"); got != "zone example.com. not in sync" {
+		t.Errorf("scrubSecrets changed harmless text: %q", got)
+	}
+}
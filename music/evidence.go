@@ -0,0 +1,577 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Evidence bundles. With evidence.active set, every process run of a zone is recorded:
+// the transitions, the updates sent to the signers and a snapshot of the DNSSEC related
+// apex RRsets of all signers (and the DS RRset in the parent) when the process started
+// and when it ended. When the zone leaves the process the record is assembled into a
+// JSON bundle, signed as a JWS (RFC 7515, compact serialization) with the key in
+// evidence.key, and kept in the DB where it can be downloaded via the API.
+
+type EvidenceRun struct {
+	ID        int
+	Zone      string
+	Process   string
+	Status    string // "running", "completed", "detached", "preempted"
+	Started   time.Time
+	Completed time.Time
+	Signed    bool
+}
+
+type EvidenceEvent struct {
+	Time   time.Time
+	Kind   string // "transition", "update" or "measurement"
+	Detail string
+}
+
+type EvidenceBundle struct {
+	Zone         string
+	Process      string
+	Run          int
+	Status       string
+	Started      time.Time
+	Completed    time.Time
+	SignerGroup  string
+	Transitions  []EvidenceEvent
+	Updates      []EvidenceEvent
+	Measurements []EvidenceEvent                // RIPE Atlas, see ripeatlas.go
+	Annotations  []EvidenceEvent                // by the operators, see annotations.go
+	Before       map[string]map[string][]string // map[signer|"parent"][rrtype][]RR
+	After        map[string]map[string][]string
+}
+
+var evidenceRRtypes = []uint16{dns.TypeDNSKEY, dns.TypeCDS, dns.TypeCDNSKEY, dns.TypeNS, dns.TypeCSYNC}
+
+func evidenceActive() bool {
+	return viper.GetBool("evidence.active")
+}
+
+// Updates (and measurements) are made while the engine holds its transaction open, so
+// they are kept in memory until the next state transition of the zone writes them to
+// the DB.
+var evidenceUpdates = struct {
+	mu     sync.Mutex
+	events map[string][]EvidenceEvent
+}{events: map[string][]EvidenceEvent{}}
+
+func recordEvidenceUpdate(signer, zone, fqdn, op string, rrsets [][]dns.RR) {
+	if !evidenceActive() {
+		return
+	}
+	var rrs []string
+	for _, rrset := range rrsets {
+		for _, rr := range rrset {
+			rrs = append(rrs, rr.String())
+		}
+	}
+	recordEvidence(zone, "update", fmt.Sprintf("%s: %s %s\n%s", signer, op, fqdn,
+		strings.Join(rrs, "\n")))
+}
+
+func recordEvidence(zone, kind, detail string) {
+	if !evidenceActive() {
+		return
+	}
+	ev := EvidenceEvent{Time: time.Now(), Kind: kind, Detail: detail}
+	evidenceUpdates.mu.Lock()
+	evidenceUpdates.events[zone] = append(evidenceUpdates.events[zone], ev)
+	evidenceUpdates.mu.Unlock()
+}
+
+// EvidenceUpdater wraps an updater and records every successful update.
+type EvidenceUpdater struct {
+	Updater
+}
+
+func (u *EvidenceUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	err := u.Updater.Update(signer, zone, fqdn, inserts, removes)
+	if err == nil {
+		if removes != nil && len(*removes) > 0 {
+			recordEvidenceUpdate(signer.Name, zone, fqdn, "remove", *removes)
+		}
+		if inserts != nil && len(*inserts) > 0 {
+			recordEvidenceUpdate(signer.Name, zone, fqdn, "add", *inserts)
+		}
+	}
+	return err
+}
+
+func (u *EvidenceUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	err := u.Updater.RemoveRRset(signer, zone, fqdn, rrsets)
+	if err == nil {
+		recordEvidenceUpdate(signer.Name, zone, fqdn, "remove-rrset", rrsets)
+	}
+	return err
+}
+
+// evidenceSnapshot fetches the evidence RRsets of the zone from all signers of the
+// signer group and the DS RRset from the parent (if the parent address is known).
+// Errors are recorded in the snapshot rather than returned.
+func (mdb *MusicDB) evidenceSnapshot(tx *sql.Tx, z *Zone) map[string]map[string][]string {
+	snap := map[string]map[string][]string{}
+	if z.SGroup == nil {
+		return snap
+	}
+
+	for name, s := range z.SGroup.SignerMap {
+		snap[name] = map[string][]string{}
+		updater := GetUpdater(s.Method)
+		for _, rrtype := range evidenceRRtypes {
+			t := dns.TypeToString[rrtype]
+			err, rrs := updater.FetchRRset(s, z.Name, z.Name, rrtype)
+			if err != nil {
+				snap[name][t] = []string{"error: " + err.Error()}
+				continue
+			}
+			for _, rr := range rrs {
+				snap[name][t] = append(snap[name][t], rr.String())
+			}
+		}
+	}
+
+	parentaddr, exist, err := mdb.GetMeta(tx, z, "parentaddr")
+	if err == nil && exist && parentaddr != "" {
+		snap["parent"] = map[string][]string{}
+		m := new(dns.Msg)
+		m.SetQuestion(z.Name, dns.TypeDS)
+		r, _, err := DnsExchange(new(dns.Client), m, parentaddr)
+		if err != nil {
+			snap["parent"]["DS"] = []string{"error: " + err.Error()}
+		} else {
+			for _, rr := range r.Answer {
+				if rr.Header().Rrtype == dns.TypeDS {
+					snap["parent"]["DS"] = append(snap["parent"]["DS"], rr.String())
+				}
+			}
+		}
+	}
+	return snap
+}
+
+func (mdb *MusicDB) openEvidenceRun(tx *sql.Tx, zone string) (int, error) {
+	const sqlq = "SELECT id FROM process_runs WHERE zone=? AND status='running' ORDER BY id DESC LIMIT 1"
+	var id int
+	err := tx.QueryRow(sqlq, zone).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if CheckSQLError("openEvidenceRun", sqlq, err, false) {
+		return 0, err
+	}
+	return id, nil
+}
+
+// flushEvidence writes the updates recorded in memory for the zone to its open run.
+func (mdb *MusicDB) flushEvidence(tx *sql.Tx, zone string, run int) error {
+	evidenceUpdates.mu.Lock()
+	events := evidenceUpdates.events[zone]
+	delete(evidenceUpdates.events, zone)
+	evidenceUpdates.mu.Unlock()
+
+	if run == 0 {
+		return nil
+	}
+	const sqlq = "INSERT INTO process_events(run, time, kind, detail) VALUES (?, ?, ?, ?)"
+	for _, ev := range events {
+		_, err := tx.Exec(sqlq, run, ev.Time.UTC().Format(layout), ev.Kind, ev.Detail)
+		if CheckSQLError("flushEvidence", sqlq, err, false) {
+			return err
+		}
+	}
+	return nil
+}
+
+// evidenceStartRun opens a new run for the zone, closing any run still open (the
+// previous process was preempted).
+func (mdb *MusicDB) evidenceStartRun(tx *sql.Tx, z *Zone, process string) error {
+	if !evidenceActive() {
+		return nil
+	}
+	if err := mdb.evidenceEndRun(tx, z, "preempted"); err != nil {
+		return err
+	}
+
+	before, err := json.Marshal(mdb.evidenceSnapshot(tx, z))
+	if err != nil {
+		return err
+	}
+	const sqlq = `
+INSERT INTO process_runs(zone, process, sgroup, status, started, before) VALUES (?, ?, ?, 'running', datetime('now'), ?)`
+	_, err = tx.Exec(sqlq, z.Name, process, z.SGname, string(before))
+	if CheckSQLError("evidenceStartRun", sqlq, err, false) {
+		return err
+	}
+	return nil
+}
+
+// evidenceTransition records a state transition in the open run of the zone.
+func (mdb *MusicDB) evidenceTransition(tx *sql.Tx, z *Zone, from, to string) error {
+	if !evidenceActive() {
+		return nil
+	}
+	run, err := mdb.openEvidenceRun(tx, z.Name)
+	if err != nil || run == 0 {
+		return err
+	}
+	if err = mdb.flushEvidence(tx, z.Name, run); err != nil {
+		return err
+	}
+	const sqlq = "INSERT INTO process_events(run, time, kind, detail) VALUES (?, datetime('now'), 'transition', ?)"
+	_, err = tx.Exec(sqlq, run, fmt.Sprintf("%s: %s -> %s", z.FSM, from, to))
+	if CheckSQLError("evidenceTransition", sqlq, err, false) {
+		return err
+	}
+	return nil
+}
+
+// evidenceEndRun closes the open run of the zone (if any), takes the "after" snapshot
+// and stores the signed bundle.
+func (mdb *MusicDB) evidenceEndRun(tx *sql.Tx, z *Zone, status string) error {
+	if !evidenceActive() {
+		return nil
+	}
+	run, err := mdb.openEvidenceRun(tx, z.Name)
+	if err != nil || run == 0 {
+		return err
+	}
+	if err = mdb.flushEvidence(tx, z.Name, run); err != nil {
+		return err
+	}
+
+	after, err := json.Marshal(mdb.evidenceSnapshot(tx, z))
+	if err != nil {
+		return err
+	}
+	const sqlq = `
+UPDATE process_runs SET status=?, completed=datetime('now'), after=? WHERE id=?`
+	_, err = tx.Exec(sqlq, status, string(after), run)
+	if CheckSQLError("evidenceEndRun", sqlq, err, false) {
+		return err
+	}
+
+	bundle, err := mdb.buildEvidenceBundle(tx, run)
+	if err != nil {
+		return err
+	}
+	jws, err := SignEvidenceBundle(bundle)
+	if err != nil {
+		// the bundle is signed on download instead, once a key is in place
+		log.Printf("evidenceEndRun: zone %s: run %d: %v", z.Name, run, err)
+		return nil
+	}
+	const sqlq2 = "UPDATE process_runs SET jws=? WHERE id=?"
+	_, err = tx.Exec(sqlq2, jws, run)
+	if CheckSQLError("evidenceEndRun", sqlq2, err, false) {
+		return err
+	}
+	return nil
+}
+
+func (mdb *MusicDB) buildEvidenceBundle(tx *sql.Tx, run int) ([]byte, error) {
+	var eb EvidenceBundle
+	var started, completed, before, after string
+
+	const sqlq = `
+SELECT zone, process, sgroup, status, COALESCE(started, ''), COALESCE(completed, ''), before, after
+FROM process_runs WHERE id=?`
+	err := tx.QueryRow(sqlq, run).Scan(&eb.Zone, &eb.Process, &eb.SignerGroup, &eb.Status,
+		&started, &completed, &before, &after)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("Process run %d unknown.", run)
+	}
+	if CheckSQLError("buildEvidenceBundle", sqlq, err, false) {
+		return nil, err
+	}
+	eb.Run = run
+	eb.Started, _ = time.Parse(layout, started)
+	eb.Completed, _ = time.Parse(layout, completed)
+	json.Unmarshal([]byte(before), &eb.Before)
+	json.Unmarshal([]byte(after), &eb.After)
+
+	const sqlq2 = "SELECT time, kind, detail FROM process_events WHERE run=? ORDER BY time, id"
+	rows, err := tx.Query(sqlq2, run)
+	if CheckSQLError("buildEvidenceBundle", sqlq2, err, false) {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ev EvidenceEvent
+		var t string
+		if err := rows.Scan(&t, &ev.Kind, &ev.Detail); err != nil {
+			log.Fatalf("buildEvidenceBundle: Error from rows.Scan(): %v", err)
+		}
+		ev.Time, _ = time.Parse(layout, t)
+		switch ev.Kind {
+		case "transition":
+			eb.Transitions = append(eb.Transitions, ev)
+		case "measurement":
+			eb.Measurements = append(eb.Measurements, ev)
+		default:
+			eb.Updates = append(eb.Updates, ev)
+		}
+	}
+
+	annotations, err := mdb.ListZoneAnnotations(tx, eb.Zone, run)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range annotations {
+		eb.Annotations = append(eb.Annotations, EvidenceEvent{Time: a.Time, Kind: "annotation",
+			Detail: a.Author + ": " + a.Text})
+	}
+	return json.MarshalIndent(eb, "", "  ")
+}
+
+func (mdb *MusicDB) ListEvidenceRuns(tx *sql.Tx, dbzone *Zone) ([]EvidenceRun, error) {
+	var runs []EvidenceRun
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListEvidenceRuns: Error from mdb.StartTransaction(): %v\n", err)
+		return runs, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT id, zone, process, status, COALESCE(started, ''), COALESCE(completed, ''), jws != ''
+FROM process_runs WHERE zone=? ORDER BY id`
+	rows, err := tx.Query(sqlq, dbzone.Name)
+	if CheckSQLError("ListEvidenceRuns", sqlq, err, false) {
+		return runs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r EvidenceRun
+		var started, completed string
+		if err := rows.Scan(&r.ID, &r.Zone, &r.Process, &r.Status, &started, &completed,
+			&r.Signed); err != nil {
+			log.Fatalf("ListEvidenceRuns: Error from rows.Scan(): %v", err)
+		}
+		r.Started, _ = time.Parse(layout, started)
+		r.Completed, _ = time.Parse(layout, completed)
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
+
+// GetEvidenceBundle returns the signed bundle of a completed run of the zone. Bundles
+// that could not be signed when the run ended are signed now.
+func (mdb *MusicDB) GetEvidenceBundle(tx *sql.Tx, dbzone *Zone, run int) (string, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("GetEvidenceBundle: Error from mdb.StartTransaction(): %v\n", err)
+		return "", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	var status, jws string
+	const sqlq = "SELECT status, jws FROM process_runs WHERE id=? AND zone=?"
+	err = tx.QueryRow(sqlq, run, dbzone.Name).Scan(&status, &jws)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("Zone %s has no process run %d.", dbzone.Name, run)
+		return "", err
+	}
+	if CheckSQLError("GetEvidenceBundle", sqlq, err, false) {
+		return "", err
+	}
+	if status == "running" {
+		err = fmt.Errorf("Process run %d of zone %s has not ended yet.", run, dbzone.Name)
+		return "", err
+	}
+	if jws != "" {
+		return jws, nil
+	}
+
+	bundle, err := mdb.buildEvidenceBundle(tx, run)
+	if err != nil {
+		return "", err
+	}
+	if jws, err = SignEvidenceBundle(bundle); err != nil {
+		return "", err
+	}
+	const sqlq2 = "UPDATE process_runs SET jws=? WHERE id=?"
+	_, err = tx.Exec(sqlq2, jws, run)
+	if CheckSQLError("GetEvidenceBundle", sqlq2, err, false) {
+		return "", err
+	}
+	return jws, nil
+}
+
+func loadEvidenceKey(file string) (crypto.Signer, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found in %s.", file)
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if s, ok := key.(crypto.Signer); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("Unsupported key type in %s.", file)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unable to parse the private key in %s.", file)
+}
+
+func jwsAlg(key crypto.Signer) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return "RS256", nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return "", fmt.Errorf("Only P-256 ECDSA keys are supported.")
+		}
+		return "ES256", nil
+	case ed25519.PrivateKey:
+		return "EdDSA", nil
+	}
+	return "", fmt.Errorf("Unsupported key type %T.", key)
+}
+
+// SignJWS returns the compact serialization of a JWS over payload.
+func SignJWS(key crypto.Signer, kid string, payload []byte) (string, error) {
+	alg, err := jwsAlg(key)
+	if err != nil {
+		return "", err
+	}
+	header := map[string]string{"alg": alg, "cty": "music-evidence+json"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	hbuf, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(hbuf) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(input))
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", err
+		}
+		sig = make([]byte, 64) // R || S, each left-padded to 32 octets
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	default:
+		digest := sha256.Sum256([]byte(input))
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return "", err
+		}
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyJWS checks the signature of a compact JWS and returns the payload.
+func VerifyJWS(jws string, pub crypto.PublicKey) ([]byte, error) {
+	parts := strings.Split(strings.TrimSpace(jws), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Malformed JWS.")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	hbuf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hbuf, &header) != nil {
+		return nil, fmt.Errorf("Malformed JWS header.")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed JWS signature.")
+	}
+	input := []byte(parts[0] + "." + parts[1])
+	digest := sha256.Sum256(input)
+
+	valid := false
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		valid = header.Alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		valid = header.Alg == "ES256" && len(sig) == 64 && ecdsa.Verify(k, digest[:],
+			new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case ed25519.PublicKey:
+		valid = header.Alg == "EdDSA" && ed25519.Verify(k, input, sig)
+	default:
+		return nil, fmt.Errorf("Unsupported key type %T.", pub)
+	}
+	if !valid {
+		return nil, fmt.Errorf("Invalid JWS signature.")
+	}
+	return base64.RawURLEncoding.DecodeString(parts[1])
+}
+
+// LoadPublicKey reads a PEM encoded public key (PKIX) or certificate.
+func LoadPublicKey(file string) (crypto.PublicKey, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found in %s.", file)
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func SignEvidenceBundle(bundle []byte) (string, error) {
+	keyfile := viper.GetString("evidence.key")
+	if keyfile == "" {
+		return "", fmt.Errorf("No signing key configured (evidence.key).")
+	}
+	key, err := loadEvidenceKey(keyfile)
+	if err != nil {
+		return "", err
+	}
+	return SignJWS(key, viper.GetString("evidence.kid"), bundle)
+}
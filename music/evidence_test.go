@@ -0,0 +1,44 @@
+package music
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+)
+
+func TestSignVerifyJWS(t *testing.T) {
+	rsakey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	eckey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edkey, _ := ed25519.GenerateKey(rand.Reader)
+	payload := []byte(`{"Zone":"example.com."}`)
+
+	for _, key := range []crypto.Signer{rsakey, eckey, edkey} {
+		jws, err := SignJWS(key, "test", payload)
+		if err != nil {
+			t.Fatalf("SignJWS(%T): %v", key, err)
+		}
+		got, err := VerifyJWS(jws, key.Public())
+		if err != nil {
+			t.Fatalf("VerifyJWS(%T): %v", key, err)
+		}
+		if string(got) != string(payload) {
+			t.Errorf("VerifyJWS(%T) = %s, want %s", key, got, payload)
+		}
+
+		parts := strings.Split(jws, ".")
+		tampered := parts[0] + "." + parts[1] + "x." + parts[2]
+		if _, err := VerifyJWS(tampered, key.Public()); err == nil {
+			t.Errorf("VerifyJWS(%T) accepted a modified payload", key)
+		}
+	}
+
+	jws, _ := SignJWS(rsakey, "", payload)
+	if _, err := VerifyJWS(jws, eckey.Public()); err == nil {
+		t.Errorf("VerifyJWS accepted a signature made with another key")
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Explaining a stuck zone. "zone explain" re-runs the pre-condition of every possible
+// next transition of the zone in a diagnostic mode: the sub-checks that the
+// pre-condition makes (per signer and RRset) are recorded with the data observed, via
+// Zone.Explain, and every stop reason it would set is kept in the report rather than
+// stored. Nothing is changed while explaining: no wait is started or ended, no RIPE
+// Atlas measurement or approval request is created and no metric is emitted. The checks
+// that stop a transition after a true pre-condition (freeze, approvals, engine pause,
+// backpressure, process groups) are reported as well.
+
+// ExplainCheck is one sub-check made by a pre-condition.
+type ExplainCheck struct {
+	Check    string
+	Signer   string // signer name, "parent" or "" if not specific to a signer
+	RRtype   string
+	Ok       bool
+	Observed []string // the RRs (or other data) observed
+	Detail   string
+}
+
+type TransitionExplanation struct {
+	From         string
+	To           string
+	Description  string
+	PreCondition bool
+	Checks       []ExplainCheck
+	StopReasons  []string // the stop reasons set by the pre-condition
+	Blocked      []string // why the transition would not happen although the pre-condition is true
+}
+
+type ZoneExplanation struct {
+	Zone        string
+	Process     string
+	State       string
+	Time        time.Time
+	StopReason  string // the current stop reason
+	Delayed     string
+	Transitions []TransitionExplanation
+}
+
+// Explaining returns true if the zone is being explained, see ExplainZone.
+func (z *Zone) Explaining() bool {
+	return z.explain != nil
+}
+
+// Explain records a sub-check made by a pre-condition when the zone is being explained.
+// Otherwise it does nothing, so pre-conditions may call it freely.
+func (z *Zone) Explain(check, signer string, rrtype uint16, ok bool, observed []dns.RR, detail string) {
+	if z.explain == nil {
+		return
+	}
+	c := ExplainCheck{Check: check, Signer: signer, Ok: ok, Detail: detail}
+	if rrtype != 0 {
+		c.RRtype = dns.TypeToString[rrtype]
+	}
+	for _, rr := range observed {
+		c.Observed = append(c.Observed, rr.String())
+	}
+	z.explain.Checks = append(z.explain.Checks, c)
+}
+
+// ExplainZone re-runs the pre-conditions of the possible next transitions of the zone
+// and returns what they checked and found.
+func (mdb *MusicDB) ExplainZone(tx *sql.Tx, z *Zone) (*ZoneExplanation, error) {
+	if !z.Exists {
+		return nil, fmt.Errorf("Zone %s unknown", z.Name)
+	}
+	if z.FSM == "" || z.FSM == "---" {
+		return nil, fmt.Errorf("Zone %s not attached to any process.", z.Name)
+	}
+	process, exist := mdb.FSMlist[z.FSM]
+	if !exist {
+		return nil, fmt.Errorf("Zone %s: process %s unknown.", z.Name, z.FSM)
+	}
+	state, exist := process.States[z.State]
+	if !exist {
+		return nil, fmt.Errorf("Zone state '%s' does not exist in process %s.", z.State, z.FSM)
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ExplainZone: Error from mdb.StartTransaction(): %v\n", err)
+		return nil, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	ze := ZoneExplanation{
+		Zone:    z.Name,
+		Process: z.FSM,
+		State:   z.State,
+		Time:    time.Now(),
+	}
+	ze.StopReason, _, err = mdb.GetStopReason(tx, z)
+	if err != nil {
+		return nil, err
+	}
+	if until, delayed, err := mdb.ZoneDelayedUntil(tx, z); err != nil {
+		return nil, err
+	} else if delayed {
+		ze.Delayed = fmt.Sprintf("Zone is delayed until %s", until.Format(time.RFC3339))
+	}
+
+	var next []string
+	for name := range state.Next {
+		next = append(next, name)
+	}
+	sort.Strings(next)
+
+	for _, nextstate := range next {
+		t := state.Next[nextstate]
+		te := TransitionExplanation{
+			From:        z.State,
+			To:          nextstate,
+			Description: t.Description,
+		}
+		z.explain = &te
+		te.PreCondition = t.PreCondition(z)
+		z.explain = nil
+
+		if reason, blocked := mdb.processGroupBlocked(tx, z); blocked {
+			te.Blocked = append(te.Blocked, reason)
+		}
+		if te.PreCondition {
+			te.Blocked = append(te.Blocked, mdb.explainGates(tx, z, nextstate)...)
+		}
+		ze.Transitions = append(ze.Transitions, te)
+	}
+	return &ze, nil
+}
+
+// explainGates returns the reasons why a transition to nextstate would not be executed
+// even though its pre-condition is true (see AttemptStateTransition).
+func (mdb *MusicDB) explainGates(tx *sql.Tx, z *Zone, nextstate string) []string {
+	var reasons []string
+	if reason, frozen := mdb.ZoneFrozen(tx, z.Name); frozen {
+		reasons = append(reasons, fmt.Sprintf("Zone is frozen (%s)", reason))
+	}
+	if ObserverMode() {
+		reasons = append(reasons, "Observer mode: transitions are never executed")
+	}
+	z.explain = &TransitionExplanation{} // checkApproval must not request an approval
+	approved, reason, err := mdb.checkApproval(tx, z, nextstate)
+	z.explain = nil
+	if err != nil {
+		reasons = append(reasons, fmt.Sprintf("Approval: %v", err))
+	} else if !approved {
+		reasons = append(reasons, reason)
+	}
+	if reason, paused := mdb.enginePaused(tx, z); paused {
+		reasons = append(reasons, reason)
+	}
+	if reason, paused := backpressurePaused(); paused {
+		reasons = append(reasons, reason)
+	}
+	return reasons
+}
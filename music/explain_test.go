@@ -0,0 +1,32 @@
+package music
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestZoneExplain(t *testing.T) {
+	z := &Zone{Name: "example.se."}
+	z.Explain("fetch RRset", "s1", dns.TypeNS, true, nil, "") // not explaining: ignored
+
+	te := TransitionExplanation{}
+	z.explain = &te
+	ns, _ := dns.NewRR("example.se. 3600 IN NS ns1.example.net.")
+	z.Explain("fetch RRset", "s1", dns.TypeNS, true, []dns.RR{ns}, "")
+	z.SetStopReason("NS ns2.example.net. is missing in signer s1") // no MusicDB: must not be stored
+	z.EmitMetric("test_seconds", 1, nil)
+	z.explain = nil
+
+	if len(te.Checks) != 1 || te.Checks[0].RRtype != "NS" || len(te.Checks[0].Observed) != 1 ||
+		te.Checks[0].Observed[0] != ns.String() {
+		t.Errorf("Explain: got checks %+v", te.Checks)
+	}
+	if len(te.StopReasons) != 1 {
+		t.Errorf("SetStopReason while explaining: got stop reasons %v", te.StopReasons)
+	}
+	if out := FormatProcessMetrics(); strings.Contains(out, "music_process_test_seconds{zone=\"example.se.\",process=\"\"}") {
+		t.Errorf("EmitMetric while explaining: metric exported:\n%s", out)
+	}
+}
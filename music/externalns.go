@@ -0,0 +1,144 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Managed vs external NS records. The NS records that the signers publish are recorded
+// in zone_nses with the signer they originated from, and are removed again when that
+// signer leaves. Zones may also have NS records that do not belong to any signer (vanity
+// names, third-party secondaries). Such NS names are declared external per zone and are
+// never attributed to a signer, so that the sync steps keep them in the NS RRset.
+
+type ZoneNS struct {
+	NS       string
+	Signer   string // signer the NS originated from, "" if unknown or external
+	External bool
+}
+
+func canonicalNS(ns string) string {
+	return strings.ToLower(dns.Fqdn(ns))
+}
+
+// ZoneSetExternalNSes replaces the set of external NS names of the zone.
+func (mdb *MusicDB) ZoneSetExternalNSes(tx *sql.Tx, z *Zone, nses []string) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+	for _, ns := range nses {
+		if _, ok := dns.IsDomainName(ns); !ok {
+			return "", fmt.Errorf("'%s' is not a valid name server name.", ns)
+		}
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneSetExternalNSes: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const dsql = "DELETE FROM zone_external_nses WHERE zone=?"
+	_, err = tx.Exec(dsql, z.Name)
+	if CheckSQLError("ZoneSetExternalNSes", dsql, err, false) {
+		return "", err
+	}
+
+	const isql = "INSERT OR IGNORE INTO zone_external_nses(zone, ns) VALUES (?, ?)"
+	const usql = "DELETE FROM zone_nses WHERE zone=? AND ns=?"
+	for _, ns := range nses {
+		ns = canonicalNS(ns)
+		_, err = tx.Exec(isql, z.Name, ns)
+		if CheckSQLError("ZoneSetExternalNSes", isql, err, false) {
+			return "", err
+		}
+		// an external NS may previously have been attributed to a signer
+		_, err = tx.Exec(usql, z.Name, ns)
+		if CheckSQLError("ZoneSetExternalNSes", usql, err, false) {
+			return "", err
+		}
+	}
+	if len(nses) == 0 {
+		return fmt.Sprintf("Zone %s has no external NS records.", z.Name), nil
+	}
+	return fmt.Sprintf("Zone %s: external NS records: %s", z.Name, strings.Join(nses, ", ")), nil
+}
+
+// ExternalNSes returns the external NS names of the zone.
+func (mdb *MusicDB) ExternalNSes(tx *sql.Tx, zone string) (map[string]bool, error) {
+	ext := map[string]bool{}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ExternalNSes: Error from mdb.StartTransaction(): %v\n", err)
+		return ext, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT ns FROM zone_external_nses WHERE zone=?"
+	rows, err := tx.Query(sqlq, zone)
+	if CheckSQLError("ExternalNSes", sqlq, err, false) {
+		return ext, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ns string
+		if err := rows.Scan(&ns); err != nil {
+			log.Fatalf("ExternalNSes: Error from rows.Scan(): %v", err)
+		}
+		ext[ns] = true
+	}
+	return ext, nil
+}
+
+// IsExternalNS returns true if ns is one of the external NS names in ext.
+func IsExternalNS(ext map[string]bool, ns string) bool {
+	return ext[canonicalNS(ns)]
+}
+
+// ListZoneNSes returns the managed (with originating signer) and external NS names of
+// the zone.
+func (mdb *MusicDB) ListZoneNSes(tx *sql.Tx, z *Zone) ([]ZoneNS, error) {
+	var nsl []ZoneNS
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListZoneNSes: Error from mdb.StartTransaction(): %v\n", err)
+		return nsl, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT ns, signer FROM zone_nses WHERE zone=?"
+	rows, err := tx.Query(sqlq, z.Name)
+	if CheckSQLError("ListZoneNSes", sqlq, err, false) {
+		return nsl, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var zn ZoneNS
+		if err := rows.Scan(&zn.NS, &zn.Signer); err != nil {
+			log.Fatalf("ListZoneNSes: Error from rows.Scan(): %v", err)
+		}
+		nsl = append(nsl, zn)
+	}
+
+	ext, err := mdb.ExternalNSes(tx, z.Name)
+	if err != nil {
+		return nsl, err
+	}
+	for ns := range ext {
+		nsl = append(nsl, ZoneNS{NS: ns, External: true})
+	}
+	sort.Slice(nsl, func(i, j int) bool { return nsl[i].NS < nsl[j].NS })
+	return nsl, nil
+}
@@ -0,0 +1,276 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// File-include signers. Some signers do not accept DDNS or API updates of the apex, but
+// can include a file managed by MUSIC in the zone. For such signers the updates (CDS,
+// CDNSKEY, CSYNC, NS, ...) are written to an include file per zone, after which an
+// optional reload command is run. Both the path and the command may contain "{zone}",
+// which is replaced by the zone name (without the trailing dot). Fetches are ordinary
+// DNS queries to the signer (with TSIG, if the signer has a key), so that a process only
+// moves forward once the signer actually serves the data.
+
+type FileInclude struct {
+	Path   string
+	Reload string
+}
+
+type FileIncludeUpdater struct {
+}
+
+func init() {
+	Updaters["file-include"] = &FileIncludeUpdater{}
+}
+
+var fileIncludeLock sync.Mutex // serializes read-modify-write of include files
+
+func (mdb *MusicDB) SignerSetInclude(tx *sql.Tx, dbsigner *Signer, fi FileInclude) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	if dbsigner.Method != "file-include" {
+		return "", fmt.Errorf("Signer %s has method %s. Include files are only used by file-include signers.",
+			dbsigner.Name, dbsigner.Method)
+	}
+	if !filepath.IsAbs(fi.Path) {
+		return "", fmt.Errorf("The include file path must be absolute.")
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerSetInclude: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	err = mdb.setSignerOptionJSON(tx, dbsigner.Name, signerOptInclude, fi)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Signer %s: updates will be written to %s.", dbsigner.Name, fi.Path), nil
+}
+
+func (fi FileInclude) expand(s, zone string) string {
+	return strings.ReplaceAll(s, "{zone}", StripDot(zone))
+}
+
+func readIncludeFile(file, zone string) ([]dns.RR, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return []dns.RR{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rrs []dns.RR
+	zp := dns.NewZoneParser(f, dns.Fqdn(zone), file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("Error parsing include file %s: %v", file, err)
+	}
+	return rrs, nil
+}
+
+// writeIncludeFile replaces the include file atomically, so that a reload by the signer
+// never sees a partially written file.
+func writeIncludeFile(file string, rrs []dns.RR) error {
+	var out strings.Builder
+	out.WriteString("; This file is managed by MUSIC. Do not edit.\n")
+	for _, rr := range rrs {
+		out.WriteString(rr.String() + "\n")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".musicinclude-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.WriteString(out.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// applyIncludeUpdate applies inserts and removes (of individual RRs) to the RRs in an
+// include file. The TTL is ignored when comparing RRs.
+func applyIncludeUpdate(rrs []dns.RR, inserts, removes [][]dns.RR) []dns.RR {
+	var out []dns.RR
+	for _, rr := range rrs {
+		keep := true
+		for _, remove := range removes {
+			for _, r := range remove {
+				if dns.IsDuplicate(rr, r) {
+					keep = false
+				}
+			}
+		}
+		if keep {
+			out = append(out, rr)
+		}
+	}
+	for _, insert := range inserts {
+		for _, r := range insert {
+			dup := false
+			for _, rr := range out {
+				if dns.IsDuplicate(rr, r) {
+					dup = true
+				}
+			}
+			if !dup {
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+// removeIncludeRRsets removes the RRsets (owner and type of each of rrsets) from rrs.
+func removeIncludeRRsets(rrs []dns.RR, rrsets [][]dns.RR) []dns.RR {
+	var out []dns.RR
+	for _, rr := range rrs {
+		keep := true
+		for _, rrset := range rrsets {
+			if len(rrset) == 0 {
+				continue
+			}
+			h := rrset[0].Header()
+			if strings.EqualFold(rr.Header().Name, h.Name) && rr.Header().Rrtype == h.Rrtype {
+				keep = false
+			}
+		}
+		if keep {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// editInclude reads the include file of the zone, applies edit and writes the result
+// back, followed by the reload command (if any).
+func editInclude(signer *Signer, zone string, edit func([]dns.RR) []dns.RR) error {
+	if signer.Include.Path == "" {
+		return fmt.Errorf("No include file configured for signer %s", signer.Name)
+	}
+	file := signer.Include.expand(signer.Include.Path, zone)
+
+	fileIncludeLock.Lock()
+	defer fileIncludeLock.Unlock()
+
+	rrs, err := readIncludeFile(file, zone)
+	if err != nil {
+		return err
+	}
+	if err = writeIncludeFile(file, edit(rrs)); err != nil {
+		return fmt.Errorf("Error writing include file %s: %v", file, err)
+	}
+
+	if signer.Include.Reload == "" {
+		return nil
+	}
+	cmd := exec.Command("/bin/sh", "-c", signer.Include.expand(signer.Include.Reload, zone))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Reload command for signer %s failed: %v: %s", signer.Name, err,
+			strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (u *FileIncludeUpdater) SetChannels(fetch, update chan SignerOp) {
+	// no-op
+}
+
+func (u *FileIncludeUpdater) SetApi(api Api) {
+	// no-op
+}
+
+func (u *FileIncludeUpdater) GetApi() Api {
+	// no-op
+	return Api{}
+}
+
+func (u *FileIncludeUpdater) Capabilities() UpdaterCapabilities {
+	return UpdaterCapabilities{CDS: true, CSYNC: true, Batch: true}
+}
+
+func (u *FileIncludeUpdater) Update(signer *Signer, zone, fqdn string,
+	inserts, removes *[][]dns.RR) error {
+	var ins, rem [][]dns.RR
+	if inserts != nil {
+		ins = *inserts
+	}
+	if removes != nil {
+		rem = *removes
+	}
+	if len(ins) == 0 && len(rem) == 0 {
+		return fmt.Errorf("Inserts and removes empty, nothing to do")
+	}
+	return editInclude(signer, zone, func(rrs []dns.RR) []dns.RR {
+		return applyIncludeUpdate(rrs, ins, rem)
+	})
+}
+
+func (u *FileIncludeUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	return editInclude(signer, zone, func(rrs []dns.RR) []dns.RR {
+		return removeIncludeRRsets(rrs, rrsets)
+	})
+}
+
+func (u *FileIncludeUpdater) FetchRRset(signer *Signer, zone, fqdn string,
+	rrtype uint16) (error, []dns.RR) {
+	if signer.Address == "" {
+		return fmt.Errorf("No ip|host for signer %s", signer.Name), []dns.RR{}
+	}
+
+	c := signer.NewDnsClient()
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn, rrtype)
+	if signer.hasUpdateAuth() {
+		if err := signer.PrepareTSIGExchange(c, m); err != nil {
+			return err, []dns.RR{}
+		}
+	}
+
+	r, _, err := signer.Exchange(c, m)
+	if err != nil {
+		return err, []dns.RR{}
+	}
+	if r.MsgHdr.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("Fetch of %s RRset failed, RCODE = %s", dns.TypeToString[rrtype],
+			dns.RcodeToString[r.MsgHdr.Rcode]), []dns.RR{}
+	}
+
+	rrs := []dns.RR{}
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == rrtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	return nil, rrs
+}
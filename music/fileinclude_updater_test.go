@@ -0,0 +1,55 @@
+package music
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestFileIncludeEdits(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("NewRR(%s): %v", s, err)
+		}
+		return r
+	}
+
+	ns1 := rr("example.com. 3600 IN NS ns1.example.net.")
+	ns2 := rr("example.com. 3600 IN NS ns2.example.net.")
+	cds := rr("example.com. 3600 IN CDS 12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF")
+
+	rrs := applyIncludeUpdate(nil, [][]dns.RR{{ns1, ns2}, {cds}}, nil)
+	if len(rrs) != 3 {
+		t.Fatalf("after insert: got %d RRs, want 3", len(rrs))
+	}
+
+	// a duplicate (with a different TTL) is not added twice
+	rrs = applyIncludeUpdate(rrs, [][]dns.RR{{rr("example.com. 300 IN NS ns1.example.net.")}}, nil)
+	if len(rrs) != 3 {
+		t.Fatalf("after duplicate insert: got %d RRs, want 3", len(rrs))
+	}
+
+	rrs = applyIncludeUpdate(rrs, nil, [][]dns.RR{{ns2}})
+	if len(rrs) != 2 {
+		t.Fatalf("after remove: got %d RRs, want 2", len(rrs))
+	}
+
+	rrs = removeIncludeRRsets(rrs, [][]dns.RR{{rr("EXAMPLE.com. 3600 IN CDS 0 0 0 00")}})
+	if len(rrs) != 1 || !dns.IsDuplicate(rrs[0], ns1) {
+		t.Fatalf("after RRset removal: got %v, want only %v", rrs, ns1)
+	}
+
+	file := filepath.Join(t.TempDir(), "example.com.include")
+	if err := writeIncludeFile(file, rrs); err != nil {
+		t.Fatalf("writeIncludeFile: %v", err)
+	}
+	back, err := readIncludeFile(file, "example.com.")
+	if err != nil {
+		t.Fatalf("readIncludeFile: %v", err)
+	}
+	if len(back) != 1 || !dns.IsDuplicate(back[0], ns1) {
+		t.Errorf("read back %v, want %v", back, ns1)
+	}
+}
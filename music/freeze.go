@@ -0,0 +1,88 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/miekg/dns"
+)
+
+// Zone freeze. A frozen zone may not have its data at the signers modified, neither by
+// the FSM actions nor via the API, e.g. during a change freeze. Unlike a zone in manual
+// mode the pre-conditions and post-conditions (which only read data) still run, so the
+// state of the zone remains visible. The freeze reason is kept as zone metadata.
+
+const ZoneFrozenKey = "frozen"
+
+func (mdb *MusicDB) ZoneFreeze(tx *sql.Tx, z *Zone, reason string) (string, error) {
+	if reason == "" {
+		reason = "no reason given"
+	}
+	if _, err := mdb.ZoneSetMeta(tx, z, ZoneFrozenKey, reason); err != nil {
+		return "", err
+	}
+	log.Printf("Zone %s frozen: %s", z.Name, reason)
+	return fmt.Sprintf("Zone %s is now frozen (%s).", z.Name, reason), nil
+}
+
+func (mdb *MusicDB) ZoneUnfreeze(tx *sql.Tx, z *Zone) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+	const sqlq = "DELETE FROM metadata WHERE zone=? AND key=?"
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneUnfreeze: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	_, err = tx.Exec(sqlq, z.Name, ZoneFrozenKey)
+	if CheckSQLError("ZoneUnfreeze", sqlq, err, false) {
+		return "", err
+	}
+	log.Printf("Zone %s unfrozen", z.Name)
+	return fmt.Sprintf("Zone %s is no longer frozen.", z.Name), nil
+}
+
+// ZoneFrozen returns the freeze reason and true if the zone is frozen.
+func (mdb *MusicDB) ZoneFrozen(tx *sql.Tx, zone string) (string, bool) {
+	if mdb == nil {
+		return "", false
+	}
+	reason, exist, err := mdb.GetMeta(tx, &Zone{Name: zone, Exists: true}, ZoneFrozenKey)
+	if err != nil {
+		log.Printf("ZoneFrozen: zone %s: %v", zone, err)
+		return "", false
+	}
+	return reason, exist && reason != ""
+}
+
+func frozenError(zone, reason string) error {
+	return fmt.Errorf("Zone %s is frozen (%s). Modifications refused.", zone, reason)
+}
+
+// FreezeUpdater wraps an updater and refuses all modifications of frozen zones.
+type FreezeUpdater struct {
+	Updater
+}
+
+func (u *FreezeUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	if reason, frozen := signer.MusicDB().ZoneFrozen(nil, zone); frozen {
+		return frozenError(zone, reason)
+	}
+	return u.Updater.Update(signer, zone, fqdn, inserts, removes)
+}
+
+func (u *FreezeUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	if reason, frozen := signer.MusicDB().ZoneFrozen(nil, zone); frozen {
+		return frozenError(zone, reason)
+	}
+	return u.Updater.RemoveRRset(signer, zone, fqdn, rrsets)
+}
@@ -28,6 +28,8 @@ type FSMTransition struct {
 	PreCondition  func(z *Zone) bool
 	Action        func(z *Zone) bool
 	PostCondition func(z *Zone) bool
+
+	Propagation bool // PreCondition waits for a change to propagate, see soahints.go
 }
 
 type FSM struct {
@@ -36,6 +38,15 @@ type FSM struct {
 	Desc         string
 	InitialState string // zones that enter this process start here
 	States       map[string]FSMState
+	Params       map[string]FSMParam // parameters that may be given at process start
+}
+
+// FSMParam describes a process parameter. Type is one of "string", "int" and "bool".
+// Parameters that are not given at process start get the value Default.
+type FSMParam struct {
+	Type    string
+	Default string
+	Desc    string
 }
 
 // Generic stop transistion
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -62,6 +63,26 @@ func (mdb *MusicDB) ZoneAttachFsm(tx *sql.Tx, dbzone *Zone, fsm, fsmsigner strin
 	if CheckSQLError("JoinGroup", sqlq, err, false) {
 		return msg, err
 	}
+
+	if err = mdb.clearApprovals(tx, dbzone.Name); err != nil {
+		return msg, err
+	}
+	if err = mdb.evidenceStartRun(tx, dbzone, fsm); err != nil {
+		return msg, err
+	}
+
+	// parameters from a previous process must not leak into this one
+	defaults, _ := ValidateProcessParams(process, nil)
+	if err = mdb.ZoneSetProcessParams(tx, dbzone, defaults); err != nil {
+		return msg, err
+	}
+	joining := ""
+	if _, joins := process.Params[joiningSignerKey]; joins {
+		joining = fsmsigner
+	}
+	if err = mdb.ZoneSetJoiningSigner(tx, dbzone, joining); err != nil {
+		return msg, err
+	}
 	return msg + fmt.Sprintf("Zone %s has now started process '%s' in state '%s'.",
 		dbzone.Name, fsm, initialstate), nil
 }
@@ -108,6 +129,19 @@ func (mdb *MusicDB) ZoneDetachFsm(tx *sql.Tx, dbzone *Zone, fsm, fsmsigner strin
 	if CheckSQLError("DetachFsm", sqlq, err, false) {
 		return "", err
 	}
+	if err = mdb.clearApprovals(tx, dbzone.Name); err != nil {
+		return "", err
+	}
+	if err = mdb.ZoneSetJoiningSigner(tx, dbzone, ""); err != nil {
+		return "", err
+	}
+	status := "detached"
+	if dbzone.State == FsmStateStop {
+		status = "completed"
+	}
+	if err = mdb.evidenceEndRun(tx, dbzone, status); err != nil {
+		return "", err
+	}
 	return fmt.Sprintf("Zone %s has now left process '%s'.",
 		dbzone.Name, fsm), nil
 }
@@ -131,14 +165,27 @@ func (mdb *MusicDB) ZoneStepFsm(tx *sql.Tx, dbzone *Zone, nextstate string) (boo
 
 	state := dbzone.State
 
-	localtx, tx, err := mdb.StartTransaction(tx)
-	if err != nil {
-		log.Printf("ZoneStepFsm: Error from mdb.StartTransaction(): %v\n", err)
-		return false, "fail", err
+	if until, delayed, err := mdb.ZoneDelayedUntil(tx, dbzone); err != nil {
+		return false, "", err
+	} else if delayed {
+		return false, "", fmt.Errorf("Zone %s is delayed until %s.", dbzone.Name,
+			until.Format(time.RFC3339))
+	}
+
+	if state == FsmStateStop && ObserverMode() {
+		observeTransition(dbzone, "---", true, "")
+		return false, fmt.Sprintf("%s: observer mode: would leave process '%s'.", dbzone.Name,
+			fsmname), nil
 	}
-	defer mdb.CloseTransaction(localtx, tx, err)
 
 	if state == FsmStateStop {
+		localtx, tx, err := mdb.StartTransaction(tx)
+		if err != nil {
+			log.Printf("ZoneStepFsm: Error from mdb.StartTransaction(): %v\n", err)
+			return false, "fail", err
+		}
+		defer mdb.CloseTransaction(localtx, tx, err)
+
 		// 1. Zone leaves process
 		// 2. Count of #zones in process in signergroup is decremented
 		msg, err := mdb.ZoneDetachFsm(tx, dbzone, fsmname, "")
@@ -230,20 +277,69 @@ func (z *Zone) AttemptStateTransition(tx *sql.Tx, nextstate string,
 
 	log.Printf("AttemptStateTransition: zone '%s' to state '%s'\n", z.Name, nextstate)
 
-	localtx, tx, err := mdb.StartTransaction(tx)
-	if err != nil {
-		log.Printf("AttemptStateTransition: Error from mdb.StartTransaction(): %v\n", err)
-		// XXX: What is the correct thing to return here?
-		return false, "fail", err
+	// The pre-condition, action and post-condition wait for DNS, so no transaction is
+	// started here: with a nil tx (as from the FSM engine workers, see PushZones) each
+	// database step below is a short transaction of its own.
+
+	if s, stuck := ZoneStuck(z.Name); stuck {
+		z.SetStopReason(fmt.Sprintf("Stuck: %s", s))
+		return false, fmt.Sprintf("%s: not attempting transition to '%s': %s.", z.Name, nextstate, s), nil
+	}
+	timeout := transitionTimeout(z.FSM, nextstate)
+	t.PreCondition = z.timedStep(nextstate, "pre-condition", timeout, t.PreCondition)
+	t.Action = z.timedStep(nextstate, "action", timeout, t.Action)
+
+	if reason, blocked := mdb.processGroupBlocked(tx, z); blocked {
+		z.SetStopReason(reason)
+		return false, fmt.Sprintf("%s: not starting process '%s': %s.", z.Name, z.FSM, reason), nil
 	}
-	defer mdb.CloseTransaction(localtx, tx, err)
 
 	// If pre-condition(aka criteria)==true ==> execute action
 	// If post-condition==true ==> change state.
 	// If post-condition==false ==> bump hold time
-	if t.PreCondition(z) {
+	if mdb.preCondition(z, nextstate, t) {
 		log.Printf("AttemptStateTransition: zone '%s'--> '%s': PreCondition: true\n", z.Name, nextstate)
-		t.Action(z)                 //TODO XXX: catch return value
+		if reason, frozen := mdb.ZoneFrozen(tx, z.Name); frozen {
+			z.SetStopReason(fmt.Sprintf("Zone is frozen (%s)", reason))
+			return false, fmt.Sprintf("%s: PreCondition for '%s' true, but the zone is frozen (%s).",
+				z.Name, nextstate, reason), nil
+		}
+		if ObserverMode() {
+			// the action is not executed, only the transition it would lead to is recorded
+			observeTransition(z, nextstate, true, "")
+			return false, fmt.Sprintf("%s: observer mode: would transition from '%s' to '%s'.",
+				z.Name, currentstate, nextstate), nil
+		}
+		approved, reason, err := mdb.checkApproval(tx, z, nextstate)
+		if err != nil {
+			return false, "", err
+		}
+		if !approved {
+			z.SetStopReason(reason)
+			return false, fmt.Sprintf("%s: PreCondition for '%s' true, but: %s.", z.Name,
+				nextstate, reason), nil
+		}
+		if reason, paused := mdb.enginePaused(tx, z); paused {
+			z.SetStopReason(reason)
+			return false, fmt.Sprintf("%s: PreCondition for '%s' true, but: %s.", z.Name,
+				nextstate, reason), nil
+		}
+		if reason, paused := backpressurePaused(); paused {
+			z.SetStopReason(reason)
+			return false, fmt.Sprintf("%s: PreCondition for '%s' true, but: %s.", z.Name,
+				nextstate, reason), nil
+		}
+		release, reason := acquireSignerSlots(z)
+		if reason != "" {
+			z.SetStopReason(reason)
+			return false, fmt.Sprintf("%s: PreCondition for '%s' true, but: %s.", z.Name,
+				nextstate, reason), nil
+		}
+		t.Action(z) //TODO XXX: catch return value
+		release()
+		if s, stuck := ZoneStuck(z.Name); stuck {
+			return false, fmt.Sprintf("%s: %s.", z.Name, s), nil
+		}
 		if t.PostCondition != nil { //TODO XXX: remove once we have post conditions everywhere.
 			postcond := t.PostCondition(z)
 			if postcond {
@@ -278,6 +374,9 @@ func (z *Zone) AttemptStateTransition(tx *sql.Tx, nextstate string,
 			z.Name, stopreason), err
 
 	}
+	if ObserverMode() {
+		observeTransition(z, nextstate, false, stopreason)
+	}
 	if exist {
 		stopreason = fmt.Sprintf(" Current stop reason: %s", stopreason)
 	}
@@ -290,8 +389,9 @@ func (mdb *MusicDB) ListProcesses() ([]Process, error, string) {
 	var resp []Process
 	for name, fsm := range mdb.FSMlist {
 		resp = append(resp, Process{
-			Name: name,
-			Desc: fsm.Desc,
+			Name:   name,
+			Desc:   fsm.Desc,
+			Params: fsm.Params,
 		})
 	}
 	return resp, nil, ""
@@ -306,10 +406,12 @@ func (z *Zone) GetParentAddressOrStop() (string, error) {
 		return "", fmt.Errorf("Zone %s: Error retrieving parent address: %v", z.Name, err)
 	}
 
+	z.Explain("parent address registered", "parent", 0, exist, nil, parentAddress)
 	if !exist {
 		z.SetStopReason("No parent-agent address registered")
 		return "", fmt.Errorf("Zone %s has no parent address registered", z.Name)
 	}
+	registerParent(parentAddress)
 	return parentAddress, nil
 }
 
@@ -0,0 +1,119 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Transition timeouts. A pre-condition or action that hangs (e.g. on a DNS query that is
+// never answered) would otherwise hold up the zone, and the engine worker, forever. The
+// pre-condition and the action of a transition are therefore given a timeout:
+//
+//	fsmengine.timeouts.default:                       300  # seconds, 0 = no timeout
+//	fsmengine.timeouts.transitions.<process>:         600  # all transitions of the process
+//	fsmengine.timeouts.transitions.<process>:<state>: 900  # the transition into state
+//
+// If the step does not return in time the transition is given up and the zone gets a
+// stop reason. The step can not be interrupted, so it keeps running in the background;
+// until it returns the zone is stuck: it is not pushed by the engine, its transitions
+// are refused and "zone status" shows since when it is stuck.
+
+type StuckTransition struct {
+	Zone    string
+	Process string
+	From    string
+	To      string
+	Step    string // "pre-condition" or "action"
+	Since   time.Time
+	Timeout time.Duration
+}
+
+func (s StuckTransition) String() string {
+	return fmt.Sprintf("%s of transition '%s' -> '%s' stuck since %s (timeout %v)", s.Step,
+		s.From, s.To, s.Since.Format(time.RFC3339), s.Timeout)
+}
+
+var stuckZones = struct {
+	sync.Mutex
+	m map[string]StuckTransition // key: zone
+}{m: map[string]StuckTransition{}}
+
+// transitionTimeout returns the timeout of the steps of the transition into state to.
+func transitionTimeout(process, to string) time.Duration {
+	for _, key := range []string{process + ":" + to, process} {
+		if k := "fsmengine.timeouts.transitions." + strings.ToLower(key); viper.IsSet(k) {
+			return time.Duration(viper.GetInt(k)) * time.Second
+		}
+	}
+	return time.Duration(viper.GetInt("fsmengine.timeouts.default")) * time.Second
+}
+
+// ZoneStuck returns the stuck transition of the zone, if there is one.
+func ZoneStuck(zone string) (StuckTransition, bool) {
+	stuckZones.Lock()
+	defer stuckZones.Unlock()
+	s, stuck := stuckZones.m[zone]
+	return s, stuck
+}
+
+// timedStep returns step limited to the timeout of the transition of the zone to next.
+// When it times out the zone is marked stuck until step returns, and false is returned.
+func (z *Zone) timedStep(next, name string, timeout time.Duration,
+	step func(*Zone) bool) func(*Zone) bool {
+	if timeout <= 0 || step == nil {
+		return step
+	}
+	return func(z *Zone) bool {
+		done := make(chan bool, 1)
+		var finished bool // protected by stuckZones
+		since := time.Now()
+		go func() {
+			defer ReportPanics("fsmengine", map[string]string{"zone": z.Name, "process": z.FSM,
+				"state": z.State, "step": name})
+			res := step(z)
+			stuckZones.Lock()
+			finished = true
+			if s, stuck := stuckZones.m[z.Name]; stuck && s.Since.Equal(since) {
+				delete(stuckZones.m, z.Name)
+				log.Printf("Zone %s: %s of transition to '%s' returned after %v, no longer stuck",
+					z.Name, name, next, time.Since(since).Round(time.Second))
+			}
+			stuckZones.Unlock()
+			done <- res
+		}()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case res := <-done:
+			return res
+		case <-timer.C:
+		}
+
+		stuckZones.Lock()
+		if finished { // just in time
+			stuckZones.Unlock()
+			return <-done
+		}
+		s := StuckTransition{Zone: z.Name, Process: z.FSM, From: z.State, To: next, Step: name,
+			Since: since, Timeout: timeout}
+		stuckZones.m[z.Name] = s
+		stuckZones.Unlock()
+
+		err := fmt.Errorf("zone %s: %s", z.Name, s)
+		log.Printf("Zone %s: %s of transition to '%s' timed out after %v", z.Name, name, next, timeout)
+		ReportError("fsmengine", err, map[string]string{"zone": z.Name, "process": z.FSM,
+			"state": z.State, "step": name})
+		z.SetStopReason(fmt.Sprintf("Stuck: %s", s))
+		return false
+	}
+}
@@ -0,0 +1,66 @@
+package music
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestTransitionTimeout(t *testing.T) {
+	viper.Set("fsmengine.timeouts.default", 300)
+	viper.Set("fsmengine.timeouts.transitions", map[string]interface{}{
+		"add-signer":                600,
+		"add-signer:dnskeys-synced": 900,
+	})
+	defer viper.Set("fsmengine.timeouts", nil)
+
+	for _, tc := range []struct {
+		process, to string
+		want        time.Duration
+	}{
+		{"add-signer", "dnskeys-synced", 900 * time.Second},
+		{"add-signer", "cds-added", 600 * time.Second},
+		{"remove-signer", "dnskeys-synced", 300 * time.Second},
+	} {
+		if got := transitionTimeout(tc.process, tc.to); got != tc.want {
+			t.Errorf("transitionTimeout(%s, %s) = %v wanted %v", tc.process, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestTimedStep(t *testing.T) {
+	z := &Zone{Name: "stuck.example.", FSM: "add-signer", State: "start",
+		explain: &TransitionExplanation{}} // collects the stop reasons
+	release := make(chan struct{})
+	hanging := func(*Zone) bool { <-release; return true }
+
+	step := z.timedStep("next", "action", 50*time.Millisecond, hanging)
+	if step(z) {
+		t.Fatalf("timed out step returned true")
+	}
+	s, stuck := ZoneStuck(z.Name)
+	if !stuck || s.Step != "action" || s.To != "next" {
+		t.Fatalf("ZoneStuck() = %+v, %v", s, stuck)
+	}
+	if len(z.explain.StopReasons) != 1 || !strings.Contains(z.explain.StopReasons[0], "stuck since") {
+		t.Errorf("stop reasons = %q", z.explain.StopReasons)
+	}
+
+	close(release)
+	for i := 0; i < 100; i++ {
+		if _, stuck = ZoneStuck(z.Name); !stuck {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stuck {
+		t.Errorf("zone still stuck after the step returned")
+	}
+
+	quick := z.timedStep("next", "pre-condition", time.Second, func(*Zone) bool { return true })
+	if !quick(z) {
+		t.Errorf("step that returned in time failed")
+	}
+}
@@ -0,0 +1,264 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// GitOps: zones, signer groups and desired signer sets may be defined in YAML files in
+// a directory (possibly a checkout of a Git repository). The definitions are compared
+// with the MusicDB and the resulting plan is applied through the same functions as the
+// API uses. Objects that exist in the DB but not in the definitions are reported, but
+// never deleted.
+
+type StateDefinition struct {
+	SignerGroups []SignerGroupDef `yaml:"signergroups"`
+	Zones        []ZoneDef        `yaml:"zones"`
+}
+
+type SignerGroupDef struct {
+	Name    string   `yaml:"name"`
+	Signers []string `yaml:"signers"`
+}
+
+type ZoneDef struct {
+	Name        string   `yaml:"name"`
+	SignerGroup string   `yaml:"signergroup"`
+	ZoneType    string   `yaml:"type"`
+	FSMMode     string   `yaml:"fsmmode"`
+	Signers     []string `yaml:"signers"` // desired signer set, optional
+}
+
+type PlanItem struct {
+	Action string // add-group, join-signer, add-zone, join-group, update-zone, desired-signers, unmanaged
+	Object string
+	Arg    string
+	Arg2   []string
+}
+
+func (pi PlanItem) String() string {
+	switch pi.Action {
+	case "desired-signers":
+		return fmt.Sprintf("%-16s %s: %s", pi.Action, pi.Object, strings.Join(pi.Arg2, ", "))
+	default:
+		if pi.Arg != "" {
+			return fmt.Sprintf("%-16s %s: %s", pi.Action, pi.Object, pi.Arg)
+		}
+		return fmt.Sprintf("%-16s %s", pi.Action, pi.Object)
+	}
+}
+
+// GitOpsSync updates the checkout in dir from the repository repo (if repo is set)
+// using the git command.
+func GitOpsSync(repo, branch, dir string) error {
+	if repo == "" {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		args := []string{"clone", "--depth", "1"}
+		if branch != "" {
+			args = append(args, "--branch", branch)
+		}
+		cmd = exec.Command("git", append(args, repo, dir)...)
+	} else {
+		cmd = exec.Command("git", "-C", dir, "pull", "--ff-only")
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s failed: %v: %s", cmd.Args[1], err, string(out))
+	}
+	return nil
+}
+
+// LoadStateDefinitions reads all *.yaml and *.yml files in dir and merges them.
+func LoadStateDefinitions(dir string) (*StateDefinition, error) {
+	var def StateDefinition
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.y*ml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for _, f := range files {
+		buf, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var fdef StateDefinition
+		if err = yaml.UnmarshalStrict(buf, &fdef); err != nil {
+			return nil, fmt.Errorf("Error parsing %s: %v", f, err)
+		}
+		def.SignerGroups = append(def.SignerGroups, fdef.SignerGroups...)
+		def.Zones = append(def.Zones, fdef.Zones...)
+	}
+
+	for i, zd := range def.Zones {
+		zname, err := CanonicalZoneName(zd.Name)
+		if err != nil {
+			return nil, err
+		}
+		def.Zones[i].Name = zname
+	}
+	return &def, nil
+}
+
+// PlanState compares the definitions with the DB and returns the changes needed.
+func (mdb *MusicDB) PlanState(def *StateDefinition) ([]PlanItem, error) {
+	var plan []PlanItem
+
+	sgs, err := mdb.ListSignerGroups(nil)
+	if err != nil {
+		return plan, err
+	}
+	zones, err := mdb.ListZones()
+	if err != nil {
+		return plan, err
+	}
+
+	defgroups := map[string]bool{}
+	for _, sgd := range def.SignerGroups {
+		defgroups[sgd.Name] = true
+		sg, exists := sgs[sgd.Name]
+		if !exists {
+			plan = append(plan, PlanItem{Action: "add-group", Object: sgd.Name})
+		}
+		for _, s := range sgd.Signers {
+			if _, member := sg.SignerMap[s]; !member {
+				plan = append(plan, PlanItem{Action: "join-signer", Object: sgd.Name, Arg: s})
+			}
+		}
+	}
+
+	defzones := map[string]bool{}
+	for _, zd := range def.Zones {
+		defzones[zd.Name] = true
+		z, exists := zones[zd.Name]
+		if !exists {
+			plan = append(plan, PlanItem{Action: "add-zone", Object: zd.Name, Arg: zd.SignerGroup})
+		} else {
+			if zd.SignerGroup != "" && z.SGname != zd.SignerGroup {
+				if z.SGname == "" {
+					plan = append(plan, PlanItem{Action: "join-group", Object: zd.Name,
+						Arg: zd.SignerGroup})
+				} else {
+					plan = append(plan, PlanItem{Action: "conflict", Object: zd.Name,
+						Arg: fmt.Sprintf("in group %s, defined in group %s (not moved automatically)",
+							z.SGname, zd.SignerGroup)})
+				}
+			}
+			if (zd.ZoneType != "" && zd.ZoneType != z.ZoneType) ||
+				(zd.FSMMode != "" && zd.FSMMode != z.FSMMode) {
+				plan = append(plan, PlanItem{Action: "update-zone", Object: zd.Name,
+					Arg: fmt.Sprintf("type=%s fsmmode=%s", zd.ZoneType, zd.FSMMode)})
+			}
+		}
+
+		desired := []string{}
+		if exists {
+			dbz := z
+			desired, _, err = mdb.GetDesiredSigners(nil, &dbz)
+			if err != nil {
+				return plan, err
+			}
+		}
+		wanted := append([]string{}, zd.Signers...)
+		sort.Strings(wanted)
+		if strings.Join(wanted, ",") != strings.Join(desired, ",") {
+			plan = append(plan, PlanItem{Action: "desired-signers", Object: zd.Name, Arg2: wanted})
+		}
+	}
+
+	for name := range sgs {
+		if !defgroups[name] {
+			plan = append(plan, PlanItem{Action: "unmanaged", Object: "signergroup " + name})
+		}
+	}
+	for name := range zones {
+		if !defzones[name] {
+			plan = append(plan, PlanItem{Action: "unmanaged", Object: "zone " + name})
+		}
+	}
+	return plan, nil
+}
+
+// ApplyPlan executes the plan item by item. Errors are collected in the output and
+// do not stop the remaining items.
+func (mdb *MusicDB) ApplyPlan(def *StateDefinition, plan []PlanItem,
+	enginecheck chan EngineCheck) []string {
+	var out []string
+	var msg string
+	var err error
+
+	zonedefs := map[string]ZoneDef{}
+	for _, zd := range def.Zones {
+		zonedefs[zd.Name] = zd
+	}
+
+	for _, pi := range plan {
+		switch pi.Action {
+		case "add-group":
+			msg, err = mdb.AddSignerGroup(nil, pi.Object)
+
+		case "join-signer":
+			var dbsigner *Signer
+			dbsigner, err = mdb.GetSignerByName(nil, pi.Arg, false)
+			if err == nil {
+				msg, err = mdb.SignerJoinGroup(nil, dbsigner, pi.Object)
+			}
+
+		case "add-zone":
+			zd := zonedefs[pi.Object]
+			msg, err = mdb.AddZone(&Zone{Name: zd.Name, ZoneType: zd.ZoneType,
+				FSMMode: zd.FSMMode}, zd.SignerGroup, enginecheck)
+
+		case "join-group":
+			var dbzone *Zone
+			dbzone, _, err = mdb.GetZone(nil, pi.Object)
+			if err == nil {
+				msg, err = mdb.ZoneJoinGroup(nil, dbzone, pi.Arg, enginecheck)
+			}
+
+		case "update-zone":
+			zd := zonedefs[pi.Object]
+			var dbzone *Zone
+			dbzone, _, err = mdb.GetZone(nil, pi.Object)
+			if err == nil {
+				msg, err = mdb.UpdateZone(dbzone, &Zone{ZoneType: zd.ZoneType,
+					FSMMode: zd.FSMMode}, enginecheck)
+			}
+
+		case "desired-signers":
+			var dbzone *Zone
+			dbzone, _, err = mdb.GetZone(nil, pi.Object)
+			if err == nil {
+				msg, err = mdb.ZoneSetDesiredSigners(nil, dbzone, pi.Arg2)
+			}
+
+		default:
+			continue // unmanaged and conflicts are only reported
+		}
+
+		if err != nil {
+			log.Printf("ApplyPlan: %s: %v", pi.String(), err)
+			out = append(out, fmt.Sprintf("%s: Error: %v", pi.String(), err))
+		} else {
+			out = append(out, fmt.Sprintf("%s: %s", pi.String(), msg))
+		}
+	}
+	return out
+}
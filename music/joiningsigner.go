@@ -0,0 +1,74 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// The joining signer of an add-signer process. When a zone is attached to the
+// add-signer process the name of the joining signer is recorded in the zone metadata
+// (key "joining-signer"), so that the join transitions do not have to work out which
+// signer is new from the membership of the signer group while the process is running.
+// The "joining-signer" process parameter, if given, overrides the recorded signer.
+
+const joiningSignerKey = "joining-signer"
+
+// ZoneSetJoiningSigner records the signer joining the signer group of the zone. An
+// empty signer removes the record.
+func (mdb *MusicDB) ZoneSetJoiningSigner(tx *sql.Tx, z *Zone, signer string) error {
+	if signer != "" {
+		_, err := mdb.ZoneSetMeta(tx, z, joiningSignerKey, signer)
+		return err
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneSetJoiningSigner: Error from mdb.StartTransaction(): %v\n", err)
+		return err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "DELETE FROM metadata WHERE zone=? AND key=?"
+	_, err = tx.Exec(sqlq, z.Name, joiningSignerKey)
+	if CheckSQLError("ZoneSetJoiningSigner", sqlq, err, false) {
+		return err
+	}
+	return nil
+}
+
+// JoiningSigner returns the signer joining the signer group of the zone in the current
+// process. It is an error if no joining signer is recorded or if the signer is not (or
+// no longer) a member of the group.
+func (z *Zone) JoiningSigner() (*Signer, error) {
+	name := z.ProcessParam(joiningSignerKey)
+	if name == "" {
+		recorded, _, err := z.MusicDB.GetMeta(nil, z, joiningSignerKey)
+		if err != nil {
+			return nil, err
+		}
+		name = recorded
+	}
+	if name == "" {
+		name = z.FSMSigner // zones attached before the joining signer was recorded
+	}
+	return joiningSignerMember(z.SGroup, name)
+}
+
+func joiningSignerMember(sg *SignerGroup, name string) (*Signer, error) {
+	if name == "" {
+		return nil, fmt.Errorf("No joining signer is recorded for the process. Restart it with the parameter joining-signer=<signer>")
+	}
+	if sg == nil {
+		return nil, fmt.Errorf("Joining signer %s: zone is not assigned to a signer group", name)
+	}
+	s, exist := sg.SignerMap[name]
+	if !exist {
+		return nil, fmt.Errorf("Joining signer %s is not a member of signer group %s", name, sg.Name)
+	}
+	return s, nil
+}
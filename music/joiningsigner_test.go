@@ -0,0 +1,23 @@
+package music
+
+import "testing"
+
+func TestJoiningSignerMember(t *testing.T) {
+	sg := &SignerGroup{Name: "sg1", SignerMap: map[string]*Signer{
+		"signer1": &Signer{Name: "signer1"},
+		"signer2": &Signer{Name: "signer2"},
+	}}
+
+	s, err := joiningSignerMember(sg, "signer2")
+	if err != nil || s.Name != "signer2" {
+		t.Errorf("joiningSignerMember(signer2) = %v, %v", s, err)
+	}
+	for _, name := range []string{"", "signer3"} {
+		if _, err := joiningSignerMember(sg, name); err == nil {
+			t.Errorf("joiningSignerMember(%q): expected an error", name)
+		}
+	}
+	if _, err := joiningSignerMember(nil, "signer1"); err == nil {
+		t.Errorf("joiningSignerMember without a group: expected an error")
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+// Jump hosts. A signer that is only reachable via a bastion may have a proxy:
+//
+//	socks5://[user:password@]host:port	a SOCKS5 proxy
+//	ssh://user@host[:port]			an SSH jump host (key in the signer's sshkey file)
+//
+// All DNS traffic to the signer (and its views) goes through Signer.Exchange and is then
+// sent over TCP through the proxy.
+// The host keys of SSH jump hosts are verified against signers.jumphost.knownhosts
+// (default ~/.ssh/known_hosts).
+
+type jumpHost struct {
+	proxy  string
+	sshkey string
+}
+
+var jumpHosts = struct {
+	sync.Mutex
+	clients map[string]*ssh.Client // ssh://... -> client
+}{clients: map[string]*ssh.Client{}}
+
+// ValidateProxy checks the syntax of a proxy URL.
+func ValidateProxy(proxyurl, sshkey string) error {
+	if proxyurl == "" {
+		return nil
+	}
+	u, err := url.Parse(proxyurl)
+	if err != nil {
+		return fmt.Errorf("Proxy %s is not a valid URL: %v", proxyurl, err)
+	}
+	switch u.Scheme {
+	case "socks5":
+	case "ssh":
+		if u.User == nil || u.User.Username() == "" {
+			return fmt.Errorf("SSH jump host %s lacks a user name.", proxyurl)
+		}
+		if sshkey == "" {
+			return fmt.Errorf("SSH jump host %s requires an SSH key file.", proxyurl)
+		}
+	default:
+		return fmt.Errorf("Unsupported proxy type '%s'. Supported types are socks5 and ssh.", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("Proxy %s lacks a host.", proxyurl)
+	}
+	return nil
+}
+
+func (mdb *MusicDB) SignerSetProxy(tx *sql.Tx, dbsigner *Signer, proxyurl, sshkey string) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	if err := ValidateProxy(proxyurl, sshkey); err != nil {
+		return "", err
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerSetProxy: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if proxyurl == "" {
+		sshkey = ""
+	}
+	err = mdb.setSignerOption(tx, dbsigner.Name, signerOptProxy, proxyurl)
+	if err != nil {
+		return "", err
+	}
+	err = mdb.setSignerOption(tx, dbsigner.Name, signerOptSshKey, sshkey)
+	if err != nil {
+		return "", err
+	}
+	if proxyurl == "" {
+		return fmt.Sprintf("Signer %s is now reached directly.", dbsigner.Name), nil
+	}
+	return fmt.Sprintf("Signer %s will be reached via %s.", dbsigner.Name, proxyurl), nil
+}
+
+// redactProxy hides the password of a SOCKS5 proxy.
+func redactProxy(proxyurl string) string {
+	u, err := url.Parse(proxyurl)
+	if err != nil || proxyurl == "" {
+		return proxyurl
+	}
+	return u.Redacted()
+}
+
+func sshClient(jh jumpHost) (*ssh.Client, error) {
+	jumpHosts.Lock()
+	defer jumpHosts.Unlock()
+
+	key := jh.proxy + "|" + jh.sshkey
+	if c, ok := jumpHosts.clients[key]; ok {
+		return c, nil
+	}
+
+	u, _ := url.Parse(jh.proxy)
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	pem, err := ioutil.ReadFile(jh.sshkey)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("SSH key %s: %v", jh.sshkey, err)
+	}
+
+	khfile := viper.GetString("signers.jumphost.knownhosts")
+	if khfile == "" {
+		home, _ := os.UserHomeDir()
+		khfile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostkeys, err := knownhosts.New(khfile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot verify host key of %s: %v", host, err)
+	}
+
+	c, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostkeys,
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	jumpHosts.clients[key] = c
+	return c, nil
+}
+
+func dropSshClient(jh jumpHost) {
+	jumpHosts.Lock()
+	defer jumpHosts.Unlock()
+	key := jh.proxy + "|" + jh.sshkey
+	if c, ok := jumpHosts.clients[key]; ok {
+		c.Close()
+		delete(jumpHosts.clients, key)
+	}
+}
+
+// dialJumpHost opens a TCP connection to addr via the jump host.
+func dialJumpHost(jh jumpHost, addr string) (*dns.Conn, error) {
+	u, err := url.Parse(jh.proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "socks5":
+		var auth *proxy.Auth
+		if u.User != nil {
+			pw, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: pw}
+		}
+		var d proxy.Dialer
+		d, err = proxy.SOCKS5("tcp", u.Host, auth, &net.Dialer{Timeout: 10 * time.Second})
+		if err == nil {
+			conn, err = d.Dial("tcp", addr)
+		}
+
+	case "ssh":
+		var c *ssh.Client
+		if c, err = sshClient(jh); err == nil {
+			conn, err = c.Dial("tcp", addr)
+			if err != nil {
+				// the SSH connection may have died; retry once on a new one
+				dropSshClient(jh)
+				if c, err = sshClient(jh); err == nil {
+					conn, err = c.Dial("tcp", addr)
+				}
+			}
+		}
+
+	default:
+		err = fmt.Errorf("Unsupported proxy type '%s'", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Dialing %s via %s: %v", addr, u.Host, err)
+	}
+	return &dns.Conn{Conn: conn}, nil
+}
@@ -0,0 +1,186 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSKEY inventory. Every DNSKEY RRset fetched from a signer (by the processes, the
+// observer or a scan of all zones) is recorded per zone and signer, with the time each
+// key was first and last seen. A key is current if it was part of the latest DNSKEY
+// RRset fetched from that signer. This answers questions like "which zones still
+// publish an algorithm 5 key" during fleet-wide algorithm migrations.
+
+type InventoryKey struct {
+	Zone      string
+	Signer    string
+	KeyTag    uint16
+	Algorithm uint8
+	Flags     uint16
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Current   bool // part of the latest DNSKEY RRset from the signer
+}
+
+// Role returns "KSK" for keys with the SEP flag set, otherwise "ZSK".
+func (k InventoryKey) Role() string {
+	if k.Flags&dns.SEP != 0 {
+		return "KSK"
+	}
+	return "ZSK"
+}
+
+type KeyInventoryFilter struct {
+	Zone      string
+	Signer    string
+	Algorithm int  // 0: all algorithms
+	All       bool // include keys that are no longer published
+}
+
+// recordKeyInventory records the DNSKEY RRset rrs fetched from signer for zone.
+func (mdb *MusicDB) recordKeyInventory(signer, zone string, rrs []dns.RR) error {
+	localtx, tx, err := mdb.StartTransaction(nil)
+	if err != nil {
+		log.Printf("recordKeyInventory: Error from mdb.StartTransaction(): %v\n", err)
+		return err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "UPDATE dnskey_inventory SET current=0 WHERE zone=? AND signer=?"
+	_, err = tx.Exec(sqlq, zone, signer)
+	if CheckSQLError("recordKeyInventory", sqlq, err, false) {
+		return err
+	}
+
+	const sqlq2 = `
+UPDATE dnskey_inventory SET lastseen=datetime('now'), current=1
+WHERE zone=? AND signer=? AND keytag=? AND algorithm=? AND flags=?`
+	const sqlq3 = `
+INSERT INTO dnskey_inventory(zone, signer, keytag, algorithm, flags, firstseen, lastseen, current)
+VALUES (?, ?, ?, ?, ?, datetime('now'), datetime('now'), 1)`
+
+	for _, rr := range rrs {
+		key, ok := rr.(*dns.DNSKEY)
+		if !ok {
+			continue
+		}
+		tag := key.KeyTag()
+		var res sql.Result
+		res, err = tx.Exec(sqlq2, zone, signer, tag, key.Algorithm, key.Flags)
+		if CheckSQLError("recordKeyInventory", sqlq2, err, false) {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			continue
+		}
+		_, err = tx.Exec(sqlq3, zone, signer, tag, key.Algorithm, key.Flags)
+		if CheckSQLError("recordKeyInventory", sqlq3, err, false) {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyInventoryUpdater wraps an updater and records every DNSKEY RRset fetched from the
+// zone apex.
+type KeyInventoryUpdater struct {
+	Updater
+}
+
+func (u *KeyInventoryUpdater) FetchRRset(signer *Signer, zone, fqdn string, rrtype uint16) (error, []dns.RR) {
+	err, rrs := u.Updater.FetchRRset(signer, zone, fqdn, rrtype)
+	if err == nil && rrtype == dns.TypeDNSKEY && fqdn == zone && signer.MusicDB() != nil {
+		go signer.MusicDB().recordKeyInventory(signer.Name, zone, rrs)
+	}
+	return err, rrs
+}
+
+// ScanKeyInventory fetches the DNSKEY RRset of all zones in signer groups from each of
+// their signers and records the keys. The number of zones scanned is returned.
+func (mdb *MusicDB) ScanKeyInventory() (int, error) {
+	zones, err := mdb.ListZones()
+	if err != nil {
+		return 0, err
+	}
+
+	scanned := 0
+	for _, z := range zones {
+		if z.SGname == "" || z.SGname == "---" || !z.Type().Monitored {
+			continue
+		}
+		sg, err := mdb.GetSignerGroup(nil, z.SGname, false) // not apisafe
+		if err != nil {
+			log.Printf("ScanKeyInventory: zone %s: %v", z.Name, err)
+			continue
+		}
+		for _, s := range sg.SignerMap {
+			err, rrs := GetUpdater(s.Method).FetchRRset(s, z.Name, z.Name, dns.TypeDNSKEY)
+			if err != nil {
+				log.Printf("ScanKeyInventory: zone %s signer %s: %v", z.Name, s.Name, err)
+				continue
+			}
+			// the answer may come from the query cache, so record it here as well
+			if err := mdb.recordKeyInventory(s.Name, z.Name, rrs); err != nil {
+				return scanned, err
+			}
+		}
+		scanned++
+	}
+	return scanned, nil
+}
+
+func (mdb *MusicDB) ListKeyInventory(f KeyInventoryFilter) ([]InventoryKey, error) {
+	var keys []InventoryKey
+
+	var where []string
+	var args []interface{}
+	if f.Zone != "" {
+		where = append(where, "zone=?")
+		args = append(args, f.Zone)
+	}
+	if f.Signer != "" {
+		where = append(where, "signer=?")
+		args = append(args, f.Signer)
+	}
+	if f.Algorithm != 0 {
+		where = append(where, "algorithm=?")
+		args = append(args, f.Algorithm)
+	}
+	if !f.All {
+		where = append(where, "current=1")
+	}
+
+	sqlq := `
+SELECT zone, signer, keytag, algorithm, flags, firstseen, lastseen, current FROM dnskey_inventory`
+	if len(where) > 0 {
+		sqlq += " WHERE " + strings.Join(where, " AND ")
+	}
+	sqlq += " ORDER BY zone, signer, flags DESC, keytag"
+
+	rows, err := mdb.db.Query(sqlq, args...)
+	if CheckSQLError("ListKeyInventory", sqlq, err, false) {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k InventoryKey
+		var firstseen, lastseen string
+		if err := rows.Scan(&k.Zone, &k.Signer, &k.KeyTag, &k.Algorithm, &k.Flags, &firstseen,
+			&lastseen, &k.Current); err != nil {
+			log.Fatalf("ListKeyInventory: Error from rows.Scan(): %v", err)
+		}
+		k.FirstSeen, _ = time.Parse(layout, firstseen)
+		k.LastSeen, _ = time.Parse(layout, lastseen)
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
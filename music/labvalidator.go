@@ -0,0 +1,155 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Lab validator. In a test lab (see README.md) the signers and the parent are real name
+// servers, and a mistake in a process (e.g. a DS removed before the DNSKEY it points to)
+// breaks the zone for validating resolvers even though the signers look consistent. With
+// lab.validator.active set, musicd continuously resolves the test zones through a local
+// validating resolver (e.g. unbound or dnsconfd at lab.validator.resolver) and reports
+// every zone that fails to validate, together with the process and state it is in.
+
+type ValidationResult struct {
+	Zone    string
+	Process string
+	State   string
+	Status  string // "secure", "insecure", "bogus", "error"
+	Detail  string
+	Since   time.Time // when the status last changed
+	Checked time.Time
+}
+
+var validations = struct {
+	sync.Mutex
+	zones map[string]ValidationResult
+}{zones: map[string]ValidationResult{}}
+
+func LabValidatorActive() bool {
+	return viper.GetBool("lab.validator.active")
+}
+
+func labResolver() string {
+	resolver := viper.GetString("lab.validator.resolver")
+	if resolver == "" {
+		resolver = "127.0.0.1:53"
+	}
+	return resolver
+}
+
+// validateZone resolves the SOA and DNSKEY RRsets of the zone through the resolver. A
+// SERVFAIL that goes away with the CD bit set means that validation failed.
+func validateZone(resolver, zone string) (status, detail string) {
+	c := &dns.Client{Timeout: 5 * time.Second}
+	for _, rrtype := range []uint16{dns.TypeSOA, dns.TypeDNSKEY} {
+		m := new(dns.Msg)
+		m.SetQuestion(zone, rrtype)
+		m.SetEdns0(1232, true)
+		r, _, err := DnsExchange(c, m, resolver)
+		if err != nil {
+			return "error", fmt.Sprintf("%s: %v", dns.TypeToString[rrtype], err)
+		}
+		if r.Rcode == dns.RcodeServerFailure {
+			m.CheckingDisabled = true
+			rcd, _, err := DnsExchange(c, m, resolver)
+			if err == nil && rcd.Rcode == dns.RcodeSuccess {
+				return "bogus", fmt.Sprintf("%s: SERVFAIL, but NOERROR with CD set", dns.TypeToString[rrtype])
+			}
+			return "error", fmt.Sprintf("%s: SERVFAIL, also with CD set", dns.TypeToString[rrtype])
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			return "error", fmt.Sprintf("%s: %s", dns.TypeToString[rrtype], dns.RcodeToString[r.Rcode])
+		}
+		if !r.AuthenticatedData {
+			return "insecure", fmt.Sprintf("%s: AD bit not set", dns.TypeToString[rrtype])
+		}
+	}
+	return "secure", ""
+}
+
+// labZones returns the zones to validate: lab.validator.zones if set, otherwise all
+// zones that are in a process.
+func (mdb *MusicDB) labZones() (map[string]Zone, error) {
+	zones, err := mdb.ListZones()
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]Zone{}
+	if names := viper.GetStringSlice("lab.validator.zones"); len(names) > 0 {
+		for _, name := range names {
+			name = dns.Fqdn(name)
+			res[name] = zones[name] // zero value if not in MUSIC
+		}
+		return res, nil
+	}
+	for name, z := range zones {
+		if z.FSM != "" && z.FSM != "---" && z.Type().Monitored {
+			res[name] = z
+		}
+	}
+	return res, nil
+}
+
+// ValidateLabZones validates all lab zones once and reports the zones whose status
+// changed to something other than "secure".
+func (mdb *MusicDB) ValidateLabZones() error {
+	zones, err := mdb.labZones()
+	if err != nil {
+		return err
+	}
+	resolver := labResolver()
+	now := time.Now()
+
+	for name, z := range zones {
+		status, detail := validateZone(resolver, name)
+
+		validations.Lock()
+		vr, exist := validations.zones[name]
+		changed := !exist || vr.Status != status
+		if changed {
+			vr.Since = now
+		}
+		vr.Zone, vr.Process, vr.State = name, z.FSM, z.State
+		vr.Status, vr.Detail, vr.Checked = status, detail, now
+		validations.zones[name] = vr
+		validations.Unlock()
+
+		if !changed || status == "secure" {
+			continue
+		}
+		msg := fmt.Sprintf("Zone %s does not validate (%s: %s) in process '%s', state '%s'",
+			name, status, detail, z.FSM, z.State)
+		log.Printf("LAB VALIDATOR: %s", msg)
+		recordEvidence(name, "measurement", "Lab validator: "+msg)
+		go ReportError("labvalidator", fmt.Errorf("%s", msg),
+			map[string]string{"zone": name, "status": status})
+	}
+	return nil
+}
+
+// ListValidations returns the latest validation results, optionally only the one for zone.
+func ListValidations(zone string) []ValidationResult {
+	validations.Lock()
+	defer validations.Unlock()
+
+	var res []ValidationResult
+	for name, vr := range validations.zones {
+		if zone == "" || name == zone {
+			res = append(res, vr)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Zone < res[j].Zone })
+	return res
+}
@@ -0,0 +1,209 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Managed names. Most of what MUSIC manages lives at the zone apex, but a signer group
+// may also serve records below it that must be identical at all signers, e.g. the DS
+// and NS RRsets of child delegations when the signer group is the parent. Such
+// (owner, rrtype) pairs are registered per zone, and when signers are synchronized
+// (the first step of joining a signer) their RRsets are synchronized as well: every
+// signer is given the union of the RRsets of all signers.
+
+type ManagedName struct {
+	Owner  string
+	RRtype string
+}
+
+// apex-only types are managed by the processes themselves
+var apexOnlyRRtypes = map[uint16]bool{
+	dns.TypeDNSKEY:  true,
+	dns.TypeCDS:     true,
+	dns.TypeCDNSKEY: true,
+	dns.TypeCSYNC:   true,
+	dns.TypeSOA:     true,
+}
+
+func (mdb *MusicDB) ZoneSetManagedName(tx *sql.Tx, z *Zone, owner, rrtype string,
+	remove bool) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+	owner, err := CanonicalZoneName(owner)
+	if err != nil {
+		return "", err
+	}
+	if owner == z.Name || !dns.IsSubDomain(z.Name, owner) {
+		return "", fmt.Errorf("Owner name %s is not below zone %s.", owner, z.Name)
+	}
+	t, ok := dns.StringToType[strings.ToUpper(rrtype)]
+	if !ok {
+		return "", fmt.Errorf("Unknown RR type: %s.", rrtype)
+	}
+	if apexOnlyRRtypes[t] {
+		return "", fmt.Errorf("RR type %s is only managed at the apex.", dns.TypeToString[t])
+	}
+	rrtype = dns.TypeToString[t]
+	if !remove {
+		if err := CheckOwner(z.Name, owner, t); err != nil {
+			return "", err
+		}
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneSetManagedName: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if remove {
+		const sqlq = "DELETE FROM zone_managed_names WHERE zone=? AND owner=? AND rrtype=?"
+		_, err = tx.Exec(sqlq, z.Name, owner, rrtype)
+		if CheckSQLError("ZoneSetManagedName", sqlq, err, false) {
+			return "", err
+		}
+		return fmt.Sprintf("Zone %s: %s %s is no longer managed.", z.Name, owner, rrtype), nil
+	}
+
+	const sqlq = "INSERT OR IGNORE INTO zone_managed_names(zone, owner, rrtype) VALUES (?, ?, ?)"
+	_, err = tx.Exec(sqlq, z.Name, owner, rrtype)
+	if CheckSQLError("ZoneSetManagedName", sqlq, err, false) {
+		return "", err
+	}
+	return fmt.Sprintf("Zone %s: %s %s is now managed.", z.Name, owner, rrtype), nil
+}
+
+func (mdb *MusicDB) GetManagedNames(tx *sql.Tx, zone string) ([]ManagedName, error) {
+	var mns []ManagedName
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("GetManagedNames: Error from mdb.StartTransaction(): %v\n", err)
+		return mns, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT owner, rrtype FROM zone_managed_names WHERE zone=? ORDER BY owner, rrtype"
+	rows, err := tx.Query(sqlq, zone)
+	if CheckSQLError("GetManagedNames", sqlq, err, false) {
+		return mns, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mn ManagedName
+		if err := rows.Scan(&mn.Owner, &mn.RRtype); err != nil {
+			log.Fatalf("GetManagedNames: Error from rows.Scan(): %v", err)
+		}
+		mns = append(mns, mn)
+	}
+	return mns, nil
+}
+
+// managedNameDiff returns, per signer, the RRs of the union of all RRsets that the
+// signer is missing.
+func managedNameDiff(rrsets map[string][]dns.RR) map[string][]dns.RR {
+	union := map[string]dns.RR{}
+	for _, rrs := range rrsets {
+		for _, rr := range rrs {
+			union[rrCompareKey(rr)] = rr
+		}
+	}
+	var keys []string
+	for k := range union {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	missing := map[string][]dns.RR{}
+	for signer, rrs := range rrsets {
+		have := map[string]bool{}
+		for _, rr := range rrs {
+			have[rrCompareKey(rr)] = true
+		}
+		for _, k := range keys {
+			if !have[k] {
+				missing[signer] = append(missing[signer], union[k])
+			}
+		}
+	}
+	return missing
+}
+
+// fetchManagedName fetches the RRset of the managed name from all signers of the zone.
+func (z *Zone) fetchManagedName(mn ManagedName) (map[string][]dns.RR, error) {
+	rrtype := dns.StringToType[mn.RRtype]
+	rrsets := map[string][]dns.RR{}
+	for name, s := range z.SGroup.SignerMap {
+		err, rrs := GetUpdater(s.Method).FetchRRset(s, z.Name, mn.Owner, rrtype)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to fetch %s %s from %s: %v", mn.Owner, mn.RRtype, name, err)
+		}
+		// a name that only exists by way of the wildcard must not be copied as a real one
+		if rrs, err = z.dropSynthesized(s, mn.Owner, rrtype, rrs); err != nil {
+			return nil, err
+		}
+		rrsets[name] = rrs
+	}
+	return rrsets, nil
+}
+
+// SyncManagedNames gives every signer of the zone the union of the RRsets of the
+// managed names. If something fails the reason is returned.
+func (z *Zone) SyncManagedNames() (bool, string) {
+	if z.Type().Simulated || z.SGroup == nil {
+		return true, ""
+	}
+	mns, err := z.MusicDB.GetManagedNames(nil, z.Name)
+	if err != nil {
+		return false, err.Error()
+	}
+	for _, mn := range mns {
+		rrsets, err := z.fetchManagedName(mn)
+		if err != nil {
+			return false, err.Error()
+		}
+		for name, rrs := range managedNameDiff(rrsets) {
+			s := z.SGroup.SignerMap[name]
+			if err := GetUpdater(s.Method).Update(s, z.Name, mn.Owner, &[][]dns.RR{rrs}, nil); err != nil {
+				return false, fmt.Sprintf("Unable to update %s %s at %s: %v", mn.Owner, mn.RRtype, name, err)
+			}
+			log.Printf("%s: added %d RRs to %s %s at %s", z.Name, len(rrs), mn.Owner, mn.RRtype, name)
+		}
+	}
+	return true, ""
+}
+
+// ManagedNamesInSync returns true if all signers of the zone serve the same RRsets for
+// the managed names. If not, the reason is returned.
+func (z *Zone) ManagedNamesInSync() (bool, string) {
+	if z.Type().Simulated || z.SGroup == nil {
+		return true, ""
+	}
+	mns, err := z.MusicDB.GetManagedNames(nil, z.Name)
+	if err != nil {
+		return false, err.Error()
+	}
+	for _, mn := range mns {
+		rrsets, err := z.fetchManagedName(mn)
+		if err != nil {
+			return false, err.Error()
+		}
+		for name, rrs := range managedNameDiff(rrsets) {
+			return false, fmt.Sprintf("%s %s at %s is missing %d RRs", mn.Owner, mn.RRtype, name, len(rrs))
+		}
+	}
+	return true, ""
+}
@@ -0,0 +1,28 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestManagedNameDiff(t *testing.T) {
+	ds1, _ := dns.NewRR("child.example.com. 3600 IN DS 1 13 2 AAAA")
+	ds2, _ := dns.NewRR("child.example.com. 3600 IN DS 2 13 2 BBBB")
+	ds2ttl, _ := dns.NewRR("child.example.com. 60 IN DS 2 13 2 BBBB")
+
+	missing := managedNameDiff(map[string][]dns.RR{
+		"s1": {ds1, ds2},
+		"s2": {ds2ttl},
+		"s3": {},
+	})
+	if len(missing["s1"]) != 0 {
+		t.Errorf("s1 is missing %v, want nothing", missing["s1"])
+	}
+	if len(missing["s2"]) != 1 || rrCompareKey(missing["s2"][0]) != rrCompareKey(ds1) {
+		t.Errorf("s2 is missing %v, want %v", missing["s2"], ds1)
+	}
+	if len(missing["s3"]) != 2 {
+		t.Errorf("s3 is missing %v, want both DS RRs", missing["s3"])
+	}
+}
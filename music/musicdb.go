@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 	// "github.com/spf13/viper"
@@ -96,6 +95,391 @@ key        TEXT NOT NULL DEFAULT '',
 time	   DATETIME,
 value      TEXT NOT NULL DEFAULT '',
 UNIQUE (zone, key)
+)`,
+
+	// policies: named sets of TTLs, timers and algorithms used by the FSM actions.
+	//        algorithms and digests are comma-separated lists of numbers.
+
+	"policies": `CREATE TABLE IF NOT EXISTS 'policies' (
+id          INTEGER PRIMARY KEY,
+name        TEXT NOT NULL DEFAULT '',
+cdsttl      INTEGER NOT NULL DEFAULT 0,
+csyncttl    INTEGER NOT NULL DEFAULT 300,
+nsttl       INTEGER NOT NULL DEFAULT 0,
+dsholddown  INTEGER NOT NULL DEFAULT 0,
+nsholddown  INTEGER NOT NULL DEFAULT 0,
+algorithms  TEXT NOT NULL DEFAULT '',
+digests     TEXT NOT NULL DEFAULT '',
+UNIQUE (name)
+)`,
+
+	// policy_assignments: objtype = {zone,signergroup}
+
+	"policy_assignments": `CREATE TABLE IF NOT EXISTS 'policy_assignments' (
+id          INTEGER PRIMARY KEY,
+objtype     TEXT NOT NULL DEFAULT '',
+objname     TEXT NOT NULL DEFAULT '',
+policy      TEXT NOT NULL DEFAULT '',
+UNIQUE (objtype, objname)
+)`,
+
+	// tsig_rotations: one row per signer, tracking the state of the latest TSIG key rotation.
+	//        oldauth is kept until the rotation is complete to make rollback possible.
+	//        notify is set if the rotation waits for the old key to be retired.
+
+	"tsig_rotations": `CREATE TABLE IF NOT EXISTS 'tsig_rotations' (
+id          INTEGER PRIMARY KEY,
+signer      TEXT NOT NULL DEFAULT '',
+state       TEXT NOT NULL DEFAULT '',
+oldauth     TEXT NOT NULL DEFAULT '',
+newauth     TEXT NOT NULL DEFAULT '',
+started     DATETIME,
+statestamp  DATETIME,
+reason      TEXT NOT NULL DEFAULT '',
+notify      INTEGER NOT NULL DEFAULT 0,
+UNIQUE (signer)
+)`,
+
+	// observed_generations: the latest spec generation per object that has been fully
+	//        applied through an upsert (i.e. the observedGeneration of the object).
+
+	"observed_generations": `CREATE TABLE IF NOT EXISTS 'observed_generations' (
+id          INTEGER PRIMARY KEY,
+kind        TEXT NOT NULL DEFAULT '',
+name        TEXT NOT NULL DEFAULT '',
+generation  INTEGER NOT NULL DEFAULT 0,
+time        DATETIME,
+UNIQUE (kind, name)
+)`,
+
+	// signer_options: the settings of the optional signer features, one row per signer and
+	//        option (see signeroptions.go). Structured values are stored as JSON.
+
+	"signer_options": `CREATE TABLE IF NOT EXISTS 'signer_options' (
+id          INTEGER PRIMARY KEY,
+signer      TEXT NOT NULL DEFAULT '',
+option      TEXT NOT NULL DEFAULT '',
+value       TEXT NOT NULL DEFAULT '',
+UNIQUE (signer, option)
+)`,
+
+	// parent_profiles: observed capabilities of parents (see parentprobe.go). The delays
+	//        are in seconds.
+
+	"parent_profiles": `CREATE TABLE IF NOT EXISTS 'parent_profiles' (
+id           INTEGER PRIMARY KEY,
+parent       TEXT NOT NULL DEFAULT '',
+cdsprobed    BOOLEAN NOT NULL DEFAULT 0 CHECK (cdsprobed IN (0, 1)),
+scanscds     BOOLEAN NOT NULL DEFAULT 0 CHECK (scanscds IN (0, 1)),
+cdsdelay     INTEGER NOT NULL DEFAULT 0,
+csyncprobed  BOOLEAN NOT NULL DEFAULT 0 CHECK (csyncprobed IN (0, 1)),
+acceptscsync BOOLEAN NOT NULL DEFAULT 0 CHECK (acceptscsync IN (0, 1)),
+csyncdelay   INTEGER NOT NULL DEFAULT 0,
+updated      DATETIME,
+UNIQUE (parent)
+)`,
+
+	// parent_probes: the latest probe of each kind ("cds", "csync") for each parent.
+
+	"parent_probes": `CREATE TABLE IF NOT EXISTS 'parent_probes' (
+id          INTEGER PRIMARY KEY,
+parent      TEXT NOT NULL DEFAULT '',
+testzone    TEXT NOT NULL DEFAULT '',
+kind        TEXT NOT NULL DEFAULT '',
+started     DATETIME,
+state       TEXT NOT NULL DEFAULT '',
+result      TEXT NOT NULL DEFAULT '',
+UNIQUE (parent, kind)
+)`,
+
+	// signergroup_snapshots: the composition of a signer group and the settings of its
+	//        signers (as JSON), recorded on every change.
+
+	"signergroup_snapshots": `CREATE TABLE IF NOT EXISTS 'signergroup_snapshots' (
+id          INTEGER PRIMARY KEY,
+sgroup      TEXT NOT NULL DEFAULT '',
+stamp       TEXT NOT NULL DEFAULT '',
+reason      TEXT NOT NULL DEFAULT '',
+snapshot    TEXT NOT NULL DEFAULT ''
+)`,
+
+	// propagation_times: time (in seconds) from an update to a signer until the change
+	//        was visible on all public nameservers of the signer for the zone.
+
+	"propagation_times": `CREATE TABLE IF NOT EXISTS 'propagation_times' (
+id          INTEGER PRIMARY KEY,
+signer      TEXT NOT NULL DEFAULT '',
+zone        TEXT NOT NULL DEFAULT '',
+owner       TEXT NOT NULL DEFAULT '',
+rrtype      TEXT NOT NULL DEFAULT '',
+started     DATETIME,
+seconds     REAL NOT NULL DEFAULT 0,
+timedout    INTEGER NOT NULL DEFAULT 0
+)`,
+
+	// approvals: approval requests for gated transitions (see approvals.go). status is
+	//        one of "pending", "approved" and "denied".
+
+	"approvals": `CREATE TABLE IF NOT EXISTS 'approvals' (
+id          INTEGER PRIMARY KEY,
+zone        TEXT NOT NULL DEFAULT '',
+process     TEXT NOT NULL DEFAULT '',
+fromstate   TEXT NOT NULL DEFAULT '',
+tostate     TEXT NOT NULL DEFAULT '',
+status      TEXT NOT NULL DEFAULT 'pending',
+requested   DATETIME,
+approver    TEXT NOT NULL DEFAULT '',
+decided     DATETIME,
+UNIQUE (zone, process, fromstate, tostate)
+)`,
+
+	// zone_external_nses: NS names of the zone that do not belong to any signer (see
+	//        externalns.go). They are never removed by the sync steps.
+
+	"zone_external_nses": `CREATE TABLE IF NOT EXISTS 'zone_external_nses' (
+id          INTEGER PRIMARY KEY,
+zone        TEXT NOT NULL DEFAULT '',
+ns          TEXT NOT NULL DEFAULT '',
+UNIQUE (zone, ns)
+)`,
+
+	// process_runs: one row per process run of a zone, with the DNS snapshots taken when
+	//        it started and ended and the signed evidence bundle (see evidence.go).
+
+	"process_runs": `CREATE TABLE IF NOT EXISTS 'process_runs' (
+id          INTEGER PRIMARY KEY,
+zone        TEXT NOT NULL DEFAULT '',
+process     TEXT NOT NULL DEFAULT '',
+sgroup      TEXT NOT NULL DEFAULT '',
+status      TEXT NOT NULL DEFAULT 'running',
+started     DATETIME,
+completed   DATETIME,
+before      TEXT NOT NULL DEFAULT '',
+after       TEXT NOT NULL DEFAULT '',
+jws         TEXT NOT NULL DEFAULT ''
+)`,
+
+	// process_events: transitions and updates of a process run. kind is one of
+	//        "transition" and "update".
+
+	"process_events": `CREATE TABLE IF NOT EXISTS 'process_events' (
+id          INTEGER PRIMARY KEY,
+run         INTEGER NOT NULL DEFAULT 0,
+time        DATETIME,
+kind        TEXT NOT NULL DEFAULT '',
+detail      TEXT NOT NULL DEFAULT ''
+)`,
+
+	// signer_zone_credentials: API tokens scoped to one zone, used instead of the signer
+	//        credentials for that zone (see zonecredentials.go).
+
+	"signer_zone_credentials": `CREATE TABLE IF NOT EXISTS 'signer_zone_credentials' (
+id          INTEGER PRIMARY KEY,
+signer      TEXT NOT NULL DEFAULT '',
+zone        TEXT NOT NULL DEFAULT '',
+token       TEXT NOT NULL DEFAULT '',
+UNIQUE (signer, zone)
+)`,
+
+	// atlas_measurements: RIPE Atlas measurements of the propagation of an RRset (see
+	//        ripeatlas.go). status is one of "running", "confirmed" and "superseded".
+
+	"atlas_measurements": `CREATE TABLE IF NOT EXISTS 'atlas_measurements' (
+id          INTEGER PRIMARY KEY,
+zone        TEXT NOT NULL DEFAULT '',
+rrtype      TEXT NOT NULL DEFAULT '',
+msmid       INTEGER NOT NULL DEFAULT 0,
+created     DATETIME,
+checked     DATETIME,
+responses   INTEGER NOT NULL DEFAULT 0,
+agreeing    INTEGER NOT NULL DEFAULT 0,
+status      TEXT NOT NULL DEFAULT 'running'
+)`,
+
+	// dnskey_inventory: DNSKEYs observed per zone and signer (see keyinventory.go).
+	//        current is set for the keys in the latest DNSKEY RRset from the signer.
+
+	"dnskey_inventory": `CREATE TABLE IF NOT EXISTS 'dnskey_inventory' (
+id          INTEGER PRIMARY KEY,
+zone        TEXT NOT NULL DEFAULT '',
+signer      TEXT NOT NULL DEFAULT '',
+keytag      INTEGER NOT NULL DEFAULT 0,
+algorithm   INTEGER NOT NULL DEFAULT 0,
+flags       INTEGER NOT NULL DEFAULT 0,
+firstseen   DATETIME,
+lastseen    DATETIME,
+current     INTEGER NOT NULL DEFAULT 0,
+UNIQUE (zone, signer, keytag, algorithm, flags)
+)`,
+
+	// published_records: CDS, CDNSKEY and CSYNC records published by MUSIC, per zone and
+	//        signer, kept until removed (see published.go). Not removed with the zone.
+
+	"published_records": `CREATE TABLE IF NOT EXISTS 'published_records' (
+id          INTEGER PRIMARY KEY,
+zone        TEXT NOT NULL DEFAULT '',
+signer      TEXT NOT NULL DEFAULT '',
+owner       TEXT NOT NULL DEFAULT '',
+rrtype      TEXT NOT NULL DEFAULT '',
+rr          TEXT NOT NULL DEFAULT '',
+published   DATETIME,
+UNIQUE (zone, signer, rr)
+)`,
+
+	// zone_managed_names: RRsets below the apex of the zone that must be identical at all
+	//        signers, e.g. the DS RRsets of child delegations (see managednames.go).
+
+	"zone_managed_names": `CREATE TABLE IF NOT EXISTS 'zone_managed_names' (
+id          INTEGER PRIMARY KEY,
+zone        TEXT NOT NULL DEFAULT '',
+owner       TEXT NOT NULL DEFAULT '',
+rrtype      TEXT NOT NULL DEFAULT '',
+UNIQUE (zone, owner, rrtype)
+)`,
+
+	// child_delegations: children of the zone whose DS RRsets are maintained from their
+	//        CDS RRsets at the signers of the zone (see childds.go).
+
+	"child_delegations": `CREATE TABLE IF NOT EXISTS 'child_delegations' (
+id          INTEGER PRIMARY KEY,
+parent      TEXT NOT NULL DEFAULT '',
+child       TEXT NOT NULL DEFAULT '',
+lastscan    DATETIME,
+status      TEXT NOT NULL DEFAULT '',
+UNIQUE (parent, child)
+)`,
+
+	// desec_provisioned: domains that MUSIC created at deSEC signers, and that are
+	//        deleted again when the zone is removed (see desecprovision.go).
+
+	"desec_provisioned": `CREATE TABLE IF NOT EXISTS 'desec_provisioned' (
+id          INTEGER PRIMARY KEY,
+zone        TEXT NOT NULL DEFAULT '',
+signer      TEXT NOT NULL DEFAULT '',
+created     DATETIME,
+UNIQUE (zone, signer)
+)`,
+
+	// provider_zone_ids: the provider-specific IDs (e.g. Route 53 hosted zone IDs) of the
+	//        zones at cloud signers, resolved at first use (see zoneids.go).
+
+	"provider_zone_ids": `CREATE TABLE IF NOT EXISTS 'provider_zone_ids' (
+id          INTEGER PRIMARY KEY,
+signer      TEXT NOT NULL DEFAULT '',
+zone        TEXT NOT NULL DEFAULT '',
+zoneid      TEXT NOT NULL DEFAULT '',
+resolved    DATETIME,
+UNIQUE (signer, zone)
+)`,
+
+	// update_history: every update made to a signer, and whether reading the RRsets back
+	//        showed the intended result (see verifyread.go).
+
+	"update_history": `CREATE TABLE IF NOT EXISTS 'update_history' (
+id          INTEGER PRIMARY KEY,
+time        DATETIME,
+zone        TEXT NOT NULL DEFAULT '',
+signer      TEXT NOT NULL DEFAULT '',
+owner       TEXT NOT NULL DEFAULT '',
+op          TEXT NOT NULL DEFAULT '',
+inserts     INTEGER NOT NULL DEFAULT 0,
+removes     INTEGER NOT NULL DEFAULT 0,
+verified    TEXT NOT NULL DEFAULT '',
+detail      TEXT NOT NULL DEFAULT ''
+)`,
+
+	// scheduled_tasks: things to do for a zone at a later time, e.g. the end of a wait
+	//        state (see scheduler.go).
+
+	"scheduled_tasks": `CREATE TABLE IF NOT EXISTS 'scheduled_tasks' (
+id          INTEGER PRIMARY KEY,
+zone        TEXT NOT NULL DEFAULT '',
+task        TEXT NOT NULL DEFAULT '',
+param       TEXT NOT NULL DEFAULT '',
+runat       DATETIME,
+done        INTEGER NOT NULL DEFAULT 0,
+UNIQUE (zone, task)
+)`,
+
+	// proposed_changes: the changes not sent to the signers due to dry-run mode, for
+	//        review (see dryrun.go). rrs is a newline separated list.
+
+	"proposed_changes": `CREATE TABLE IF NOT EXISTS 'proposed_changes' (
+id          INTEGER PRIMARY KEY,
+time        DATETIME,
+signer      TEXT NOT NULL DEFAULT '',
+zone        TEXT NOT NULL DEFAULT '',
+owner       TEXT NOT NULL DEFAULT '',
+op          TEXT NOT NULL DEFAULT '',
+rrs         TEXT NOT NULL DEFAULT ''
+)`,
+
+	// pending_ops: destructive operations waiting for a second person under the
+	//        two-person rule (see twoperson.go).
+
+	"pending_ops": `CREATE TABLE IF NOT EXISTS 'pending_ops' (
+id          INTEGER PRIMARY KEY,
+op          TEXT NOT NULL DEFAULT '',
+target      TEXT NOT NULL DEFAULT '',
+requester   TEXT NOT NULL DEFAULT '',
+requested   DATETIME,
+UNIQUE (op, target)
+)`,
+
+	// process_metrics: the custom metrics emitted by the FSM transitions (see
+	//        processmetrics.go). labels is a JSON object.
+
+	"process_metrics": `CREATE TABLE IF NOT EXISTS 'process_metrics' (
+id          INTEGER PRIMARY KEY,
+time        DATETIME,
+zone        TEXT NOT NULL DEFAULT '',
+process     TEXT NOT NULL DEFAULT '',
+state       TEXT NOT NULL DEFAULT '',
+name        TEXT NOT NULL DEFAULT '',
+value       REAL NOT NULL DEFAULT 0,
+labels      TEXT NOT NULL DEFAULT ''
+)`,
+
+	// shard_members: the musicd instances sharing this DB, with their latest heartbeat
+	//        (see sharding.go).
+
+	"shard_members": `CREATE TABLE IF NOT EXISTS 'shard_members' (
+name        TEXT PRIMARY KEY,
+heartbeat   DATETIME
+)`,
+
+	// spilled_ops: the inserts and removes of signer updates that were queued while the
+	//        queue of the manager was full (see opqueue.go).
+
+	"spilled_ops": `CREATE TABLE IF NOT EXISTS 'spilled_ops' (
+id          TEXT PRIMARY KEY,
+queue       TEXT NOT NULL DEFAULT '',
+inserts     TEXT NOT NULL DEFAULT '',
+removes     TEXT NOT NULL DEFAULT '',
+spilled     DATETIME
+)`,
+
+	// engine_pauses: the engine, processes and signers paused by the operator (see
+	//        enginepause.go).
+
+	"engine_pauses": `CREATE TABLE IF NOT EXISTS 'engine_pauses' (
+scope       TEXT NOT NULL DEFAULT '',
+target      TEXT NOT NULL DEFAULT '',
+reason      TEXT NOT NULL DEFAULT '',
+since       DATETIME,
+PRIMARY KEY (scope, target)
+)`,
+
+	// zone_annotations: notes of the operators on zones (run 0) and on process runs (see
+	//        annotations.go).
+
+	"zone_annotations": `CREATE TABLE IF NOT EXISTS 'zone_annotations' (
+id          INTEGER PRIMARY KEY,
+zone        TEXT NOT NULL DEFAULT '',
+run         INTEGER NOT NULL DEFAULT 0,
+author      TEXT NOT NULL DEFAULT '',
+time        DATETIME,
+text        TEXT NOT NULL DEFAULT ''
 )`,
 }
 
@@ -117,9 +501,52 @@ func dbSetupTables(mdb *MusicDB) (bool, error) {
 		}
 	}
 
+	if err = dbMigrate(tx); err != nil {
+		log.Fatalf("Failed to migrate the db schema. Error: %v", err)
+	}
+
 	return false, nil
 }
 
+// dbMigrate brings the schema of an existing db up to date, for the changes that
+// CREATE TABLE IF NOT EXISTS does not cover.
+// dbColumns are the columns added to tables after they were first created.
+var dbColumns = []struct {
+	table, column, def string
+}{
+	{"policies", "nsttl", "INTEGER NOT NULL DEFAULT 0"},        // see nsttl.go
+	{"tsig_rotations", "notify", "INTEGER NOT NULL DEFAULT 0"}, // see tsigrotation.go
+}
+
+func dbMigrate(tx *sql.Tx) error {
+	for _, c := range dbColumns {
+		exists, err := columnExists(tx, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		log.Printf("Adding column %s to table %s", c.column, c.table)
+		sqlq := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.def)
+		_, err = tx.Exec(sqlq)
+		if CheckSQLError("dbMigrate", sqlq, err, false) {
+			return err
+		}
+	}
+	return nil
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	const sqlq = "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?"
+	var count int
+	err := tx.QueryRow(sqlq, table, column).Scan(&count)
+	if CheckSQLError("columnExists", sqlq, err, false) {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func NewDB(dbfile, dbmode string, force bool) (*MusicDB, error) {
 	log.Printf("NewMusicDB: using sqlite db in file %s\n", dbfile)
 
@@ -129,13 +556,16 @@ func NewDB(dbfile, dbmode string, force bool) (*MusicDB, error) {
 			log.Printf("NewMusicDB: Error trying to ensure that db %s is writable: %v", dbfile, err)
 		}
 	}
-	db, err := sql.Open("sqlite3", dbfile)
+	// with several FSM engine workers (see PushZones) transactions are concurrent; a
+	// writer waits up to 5 s for another to finish rather than failing at once
+	db, err := sql.Open("sqlite3", dbfile+"?_busy_timeout=5000")
 	if err != nil {
 		log.Printf("NewMusicDB: Error from sql.Open: %v", err)
 		return nil, err
 	}
 
-	if dbmode == "WAL" {
+	// WAL mode lets the workers read while another one writes
+	if dbmode == "WAL" || FSMWorkers() > 1 {
 		_, err := db.Exec("PRAGMA journal_mode=WAL;")
 		if err != nil {
 			log.Fatalf("NewDB: Error entering DB WAL mode: %v", err)
@@ -299,22 +729,22 @@ func (mdb *MusicDB) GetSigner(tx *sql.Tx, s *Signer, apisafe bool) (*Signer, err
 		if err != nil {
 			log.Fatalf("mdb.GetSigner: Error from signer.GetSignerGroups: %v", err)
 		}
-
-		auth := AuthData{}
-		p := strings.Split(authstr, ":")
-		if len(p) == 3 {
-			auth = AuthData{
-				TSIGAlg:  p[0],
-				TSIGName: p[1],
-				TSIGKey:  p[2],
-			}
+		opts, err := mdb.getSignerOptions(tx, s.Name)
+		if err != nil {
+			return nil, err
 		}
+		creds, err := mdb.getZoneCredentials(tx, s.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		auth := ParseAuthStr(authstr)
 
 		dbref := mdb
 		if apisafe {
 			dbref = nil
 		}
-		return &Signer{
+		signer := &Signer{
 			Name:         name,
 			Exists:       true,
 			Method:       method,
@@ -325,8 +755,17 @@ func (mdb *MusicDB) GetSigner(tx *sql.Tx, s *Signer, apisafe bool) (*Signer, err
 			UseTcp:       usetcp,
 			UseTSIG:      usetsig,
 			SignerGroups: sgs,
+			TokenZones:   credentialZones(creds),
 			DB:           dbref,
-		}, nil
+			zoneTokens:   creds,
+		}
+		if err = signer.applyOptions(opts); err != nil {
+			return nil, err
+		}
+		if apisafe {
+			signer.redact()
+		}
+		return signer, nil
 
 	default:
 		log.Fatalf("GetSigner: error from row.Scan(): name=%s, err=%v", s.Name, err)
@@ -0,0 +1,54 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+)
+
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false), // allow underscore labels, like _dsboot
+)
+
+// CanonicalZoneName returns the canonical form of a zone name as used in DNS queries
+// and as key in the DB: U-labels converted to punycode A-labels, case folded to lower
+// case and fully qualified. Names that are not legal domain names are rejected.
+func CanonicalZoneName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("Zone name not specified.")
+	}
+	if name == "." {
+		return name, nil
+	}
+
+	ascii, err := idnaProfile.ToASCII(strings.TrimSuffix(name, "."))
+	if err != nil {
+		return "", fmt.Errorf("Zone name '%s' is not a valid IDN: %v", name, err)
+	}
+
+	fqdn := dns.Fqdn(strings.ToLower(ascii))
+	if _, ok := dns.IsDomainName(fqdn); !ok {
+		return "", fmt.Errorf("Zone name '%s' is not a legal domain name.", name)
+	}
+	if len(fqdn) > 255 {
+		return "", fmt.Errorf("Zone name '%s' is longer than 255 octets.", name)
+	}
+	for _, label := range dns.SplitDomainName(fqdn) {
+		if len(label) == 0 || len(label) > 63 {
+			return "", fmt.Errorf("Zone name '%s' has an illegal label '%s'.", name, label)
+		}
+		if strings.ContainsAny(label, " \t\\/@:;") {
+			return "", fmt.Errorf("Zone name '%s' has an illegal label '%s'.", name, label)
+		}
+	}
+	return fqdn, nil
+}
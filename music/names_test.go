@@ -0,0 +1,33 @@
+package music
+
+import (
+	"testing"
+)
+
+func TestCanonicalZoneName(t *testing.T) {
+	good := map[string]string{
+		"Example.SE":     "example.se.",
+		"example.se.":    "example.se.",
+		" example.se ":   "example.se.",
+		"räksmörgås.se":  "xn--rksmrgs-5wao1o.se.",
+		"RÄKSMÖRGÅS.SE.": "xn--rksmrgs-5wao1o.se.",
+		"_dsboot.ns1.se": "_dsboot.ns1.se.",
+	}
+	for in, want := range good {
+		got, err := CanonicalZoneName(in)
+		if err != nil {
+			t.Errorf("CanonicalZoneName(%q): unexpected error: %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("CanonicalZoneName(%q): got %q wanted %q", in, got, want)
+		}
+	}
+
+	bad := []string{"", "foo..se", "foo bar.se", "foo@bar.se"}
+	for _, in := range bad {
+		if got, err := CanonicalZoneName(in); err == nil {
+			t.Errorf("CanonicalZoneName(%q): got %q wanted error", in, got)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Zone contacts are kept in the metadata table, so that they follow the zone
+// without any change to the zones table.
+const (
+	ZoneContactEmailKey   = "contact-email"
+	ZoneContactWebhookKey = "contact-webhook"
+)
+
+// Events that are routed to the zone contact.
+const (
+	ZoneEventStopped         = "stopped"
+	ZoneEventProcessComplete = "process-complete"
+)
+
+type ZoneContact struct {
+	Email   string
+	Webhook string
+}
+
+type ZoneEvent struct {
+	Zone    string
+	Event   string
+	Process string
+	State   string
+	Message string
+	Time    time.Time
+}
+
+func (mdb *MusicDB) GetZoneContact(tx *sql.Tx, z *Zone) (ZoneContact, error) {
+	var zc ZoneContact
+	var err error
+
+	zc.Email, _, err = mdb.GetMeta(tx, z, ZoneContactEmailKey)
+	if err != nil {
+		return zc, err
+	}
+	zc.Webhook, _, err = mdb.GetMeta(tx, z, ZoneContactWebhookKey)
+	if err != nil {
+		return zc, err
+	}
+	return zc, nil
+}
+
+// ZoneSetContact updates the contact of the zone. Only the fields that are set in zc are
+// changed; the fields named in clear ("email", "webhook") are removed.
+func (mdb *MusicDB) ZoneSetContact(tx *sql.Tx, z *Zone, zc ZoneContact,
+	clear []string) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+	for _, field := range clear {
+		if field != "email" && field != "webhook" {
+			return "", fmt.Errorf("Zone %s: unknown contact field '%s' (should be email or webhook).",
+				z.Name, field)
+		}
+	}
+
+	if zc.Email != "" {
+		if err := validate.Var(zc.Email, "email"); err != nil {
+			return "", fmt.Errorf("Zone %s: '%s' is not a valid email address.", z.Name, zc.Email)
+		}
+	}
+	if zc.Webhook != "" {
+		if err := validate.Var(zc.Webhook, "url"); err != nil {
+			return "", fmt.Errorf("Zone %s: '%s' is not a valid webhook URL.", z.Name, zc.Webhook)
+		}
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneSetContact: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	cur, err := mdb.GetZoneContact(tx, z)
+	if err != nil {
+		return "", err
+	}
+	cur = mergeContact(cur, zc, clear)
+
+	_, err = mdb.ZoneSetMeta(tx, z, ZoneContactEmailKey, cur.Email)
+	if err != nil {
+		return "", err
+	}
+	_, err = mdb.ZoneSetMeta(tx, z, ZoneContactWebhookKey, cur.Webhook)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Zone %s contact updated (email: '%s', webhook: '%s').",
+		z.Name, cur.Email, cur.Webhook), nil
+}
+
+// mergeContact returns cur with the fields that are set in zc replaced and the fields
+// named in clear removed.
+func mergeContact(cur, zc ZoneContact, clear []string) ZoneContact {
+	if zc.Email != "" {
+		cur.Email = zc.Email
+	}
+	if zc.Webhook != "" {
+		cur.Webhook = zc.Webhook
+	}
+	for _, field := range clear {
+		switch field {
+		case "email":
+			cur.Email = ""
+		case "webhook":
+			cur.Webhook = ""
+		}
+	}
+	return cur
+}
+
+// NotifyZoneContact routes an event about a zone to the contact of that zone. If the
+// zone has no contact the default contact from the config (notifications.default) is
+// used. Delivery is done in the background and failures are only logged.
+func (mdb *MusicDB) NotifyZoneContact(z *Zone, event, msg string) {
+	if !viper.GetBool("notifications.active") {
+		return
+	}
+
+	ev := ZoneEvent{
+		Zone:    z.Name,
+		Event:   event,
+		Process: z.FSM,
+		State:   z.State,
+		Message: msg,
+		Time:    time.Now(),
+	}
+
+	go func() {
+		zc, err := mdb.GetZoneContact(nil, z)
+		if err != nil {
+			log.Printf("NotifyZoneContact: Error from GetZoneContact(%s): %v", z.Name, err)
+		}
+		if zc.Email == "" && zc.Webhook == "" {
+			zc.Email = viper.GetString("notifications.default.email")
+			zc.Webhook = viper.GetString("notifications.default.webhook")
+		}
+
+		if zc.Webhook != "" {
+			if err := ev.SendWebhook(zc.Webhook); err != nil {
+				log.Printf("NotifyZoneContact: zone %s: webhook %s: %v", z.Name, zc.Webhook, err)
+			}
+		}
+		if zc.Email != "" {
+			if err := ev.SendEmail(zc.Email); err != nil {
+				log.Printf("NotifyZoneContact: zone %s: email to %s: %v", z.Name, zc.Email, err)
+			}
+		}
+	}()
+}
+
+func (ev *ZoneEvent) SendWebhook(url string) error {
+	bytebuf := new(bytes.Buffer)
+	err := json.NewEncoder(bytebuf).Encode(ev)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytebuf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (ev *ZoneEvent) SendEmail(to string) error {
+	server := viper.GetString("notifications.smtp.server")
+	from := viper.GetString("notifications.smtp.from")
+	if server == "" || from == "" {
+		return fmt.Errorf("notifications.smtp.server or notifications.smtp.from not configured")
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: MuSiC: zone %s %s\r\n\r\n%s\r\n\r\nProcess: %s\r\nState: %s\r\nTime: %s\r\n",
+		from, to, ev.Zone, ev.Event, ev.Message, ev.Process, ev.State,
+		ev.Time.Format(time.RFC3339))
+	return smtp.SendMail(server, nil, from, []string{to}, []byte(body))
+}
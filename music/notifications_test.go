@@ -0,0 +1,25 @@
+package music
+
+import "testing"
+
+func TestMergeContact(t *testing.T) {
+	cur := ZoneContact{Email: "ops@example.com", Webhook: "https://hooks.example.com/music"}
+	for _, tc := range []struct {
+		zc    ZoneContact
+		clear []string
+		want  ZoneContact
+	}{
+		{ZoneContact{Webhook: "https://other.example.com/"}, nil,
+			ZoneContact{Email: "ops@example.com", Webhook: "https://other.example.com/"}},
+		{ZoneContact{Email: "noc@example.com"}, nil,
+			ZoneContact{Email: "noc@example.com", Webhook: "https://hooks.example.com/music"}},
+		{ZoneContact{}, []string{"webhook"}, ZoneContact{Email: "ops@example.com"}},
+		{ZoneContact{}, []string{"email", "webhook"}, ZoneContact{}},
+		{ZoneContact{Email: "noc@example.com"}, []string{"webhook"},
+			ZoneContact{Email: "noc@example.com"}},
+	} {
+		if got := mergeContact(cur, tc.zc, tc.clear); got != tc.want {
+			t.Errorf("mergeContact(%+v, %v) = %+v, want %+v", tc.zc, tc.clear, got, tc.want)
+		}
+	}
+}
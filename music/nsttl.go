@@ -0,0 +1,69 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/miekg/dns"
+)
+
+// NS TTL normalization. The signers in a group may publish the apex NS RRset with
+// different TTLs, and when the parent (acting on CSYNC) and the resolvers see different
+// TTLs depending on which signer they ask, the NS change is harder to reason about. The
+// add-signer and remove-signer processes therefore have a step before CSYNC is published
+// that sets the TTL of the apex NS RRset at all signers to the NS TTL of the policy of
+// the zone (or the "ns-ttl" process parameter). With no NS TTL the step does nothing.
+
+// NsTTL returns the TTL that the apex NS RRset of the zone should be normalized to, or 0
+// if it should be left as is.
+func (z *Zone) NsTTL() uint32 {
+	if ttl, ok := z.ProcessParamInt("ns-ttl"); ok && ttl >= 0 {
+		return uint32(ttl)
+	}
+	return z.Policy().NsTTL
+}
+
+// rrsetWithTTL returns a copy of the RRset with all TTLs set to ttl, and whether any TTL
+// differed.
+func rrsetWithTTL(rrs []dns.RR, ttl uint32) ([]dns.RR, bool) {
+	var res []dns.RR
+	changed := false
+	for _, rr := range rrs {
+		rc := dns.Copy(rr)
+		if rc.Header().Ttl != ttl {
+			rc.Header().Ttl = ttl
+			changed = true
+		}
+		res = append(res, rc)
+	}
+	return res, changed
+}
+
+// NormalizeRRsetTTL sets the TTL of the RRset at the signer to ttl, and returns true if an
+// update was needed. The RRset is sent again with the new TTL, as the differential updates
+// (see rrsetdiff.go) compare RRs without regard to TTL.
+func NormalizeRRsetTTL(signer *Signer, zone, owner string, rrtype uint16, ttl uint32) (bool, error) {
+	updater := GetUpdater(signer.Method)
+	err, rrs := updater.FetchRRset(signer, zone, owner, rrtype)
+	if err != nil {
+		return false, fmt.Errorf("Unable to fetch %s %s from %s: %v", owner,
+			dns.TypeToString[rrtype], signer.Name, err)
+	}
+	rrset, changed := rrsetWithTTL(rrs, ttl)
+	if !changed {
+		return false, nil
+	}
+	if err := signer.CheckUpdateSupport(rrtype); err != nil {
+		return false, err
+	}
+	if err := updater.Update(signer, zone, owner, &[][]dns.RR{rrset}, nil); err != nil {
+		return false, err
+	}
+	log.Printf("NormalizeRRsetTTL: %s %s at %s now has TTL %d", owner, dns.TypeToString[rrtype],
+		signer.Name, ttl)
+	return true, nil
+}
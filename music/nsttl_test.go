@@ -0,0 +1,41 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRRsetWithTTL(t *testing.T) {
+	var rrs []dns.RR
+	for _, s := range []string{
+		"example.com. 3600 IN NS ns1.example.net.",
+		"example.com. 86400 IN NS ns2.example.net.",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("dns.NewRR(%q): %v", s, err)
+		}
+		rrs = append(rrs, rr)
+	}
+
+	res, changed := rrsetWithTTL(rrs, 3600)
+	if !changed || len(res) != 2 {
+		t.Fatalf("got %v (changed %v), want 2 RRs, changed", res, changed)
+	}
+	for _, rr := range res {
+		if rr.Header().Ttl != 3600 {
+			t.Errorf("%v: TTL not normalized", rr)
+		}
+	}
+	if rrs[1].Header().Ttl != 86400 {
+		t.Errorf("original RRset modified")
+	}
+
+	if _, changed := rrsetWithTTL(res, 3600); changed {
+		t.Errorf("normalized RRset reported as changed")
+	}
+	if _, changed := rrsetWithTTL(nil, 3600); changed {
+		t.Errorf("empty RRset reported as changed")
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Observer mode. With observer.active set musicd tracks the zones and evaluates the
+// pre-conditions of their processes, but never changes anything: no actions are
+// executed, any other updates are handled as in dry-run mode (see dryrun.go) and no
+// zone changes state. Instead, the latest observation of each zone (what the next
+// transition would be, or why it is stuck, and any drift between the signers) is kept
+// for 'music-cli show observer'.
+
+type ZoneObservation struct {
+	Zone        string
+	SignerGroup string
+	Process     string
+	State       string
+	NextState   string
+	WouldMove   bool // pre-condition true, the transition would be executed
+	StopReason  string
+	Drift       []string // differences between the signers
+	Time        time.Time
+}
+
+// RRtypes compared between the signers of a zone
+var observerRRtypes = []uint16{dns.TypeDNSKEY, dns.TypeCDS, dns.TypeCDNSKEY, dns.TypeNS}
+
+var observations = struct {
+	sync.Mutex
+	zones map[string]ZoneObservation
+}{zones: map[string]ZoneObservation{}}
+
+func ObserverMode() bool {
+	return viper.GetBool("observer.active")
+}
+
+func observe(z *Zone, f func(zo *ZoneObservation)) {
+	observations.Lock()
+	defer observations.Unlock()
+
+	zo, exist := observations.zones[z.Name]
+	if !exist {
+		zo = ZoneObservation{Zone: z.Name}
+	}
+	zo.SignerGroup = z.SGname
+	zo.Process = z.FSM
+	zo.State = z.State
+	zo.Time = time.Now()
+	f(&zo)
+	observations.zones[z.Name] = zo
+}
+
+// observeTransition records the outcome of the pre-condition for the transition of z
+// to nextstate.
+func observeTransition(z *Zone, nextstate string, wouldmove bool, stopreason string) {
+	observe(z, func(zo *ZoneObservation) {
+		zo.NextState = nextstate
+		zo.WouldMove = wouldmove
+		zo.StopReason = stopreason
+	})
+	if wouldmove {
+		log.Printf("OBSERVER: zone %s would transition from '%s' to '%s' in process '%s'",
+			z.Name, z.State, nextstate, z.FSM)
+	}
+}
+
+// signerDrift compares the RRsets of the signers of the zone.
+func signerDrift(z *Zone) []string {
+	var drift []string
+	if z.SGroup == nil || len(z.SGroup.SignerMap) < 2 {
+		return drift
+	}
+
+	var names []string
+	for name := range z.SGroup.SignerMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, rrtype := range observerRRtypes {
+		rrsets := map[string][]dns.RR{}
+		for _, name := range names {
+			s := z.SGroup.SignerMap[name]
+			err, rrs := GetUpdater(s.Method).FetchRRset(s, z.Name, z.Name, rrtype)
+			if err != nil {
+				drift = append(drift, fmt.Sprintf("%s: %s: %v", dns.TypeToString[rrtype], name, err))
+				continue
+			}
+			rrsets[name] = rrs
+		}
+		first := names[0]
+		for _, name := range names[1:] {
+			if _, ok := rrsets[name]; !ok {
+				continue
+			}
+			equal, extra1, extra2 := RRsetEqual(rrsets[first], rrsets[name])
+			if equal {
+				continue
+			}
+			if len(extra1) > 0 {
+				drift = append(drift, fmt.Sprintf("%s: %d RRs at %s missing at %s",
+					dns.TypeToString[rrtype], len(extra1), first, name))
+			}
+			if len(extra2) > 0 {
+				drift = append(drift, fmt.Sprintf("%s: %d RRs at %s missing at %s",
+					dns.TypeToString[rrtype], len(extra2), name, first))
+			}
+		}
+	}
+	return drift
+}
+
+// ObserveZones checks all zones in signer groups for drift between the signers. It is
+// run by the FSM engine in observer mode, in addition to the evaluation of the processes.
+func (mdb *MusicDB) ObserveZones() error {
+	zones, err := mdb.ListZones()
+	if err != nil {
+		return err
+	}
+	for _, z := range zones {
+		if z.SGname == "" || z.SGname == "---" || !z.Type().Monitored {
+			continue
+		}
+		sg, err := mdb.GetSignerGroup(nil, z.SGname, false) // not apisafe
+		if err != nil {
+			log.Printf("ObserveZones: zone %s: %v", z.Name, err)
+			continue
+		}
+		zc := z
+		zc.SGroup = sg
+		zc.MusicDB = mdb
+		drift := signerDrift(&zc)
+		if len(drift) > 0 {
+			log.Printf("OBSERVER: zone %s: signers differ: %s", z.Name, strings.Join(drift, "; "))
+		}
+		observe(&zc, func(zo *ZoneObservation) { zo.Drift = drift })
+	}
+	return nil
+}
+
+// ListObservations returns the latest observations, optionally only the one for zone.
+func ListObservations(zone string) []ZoneObservation {
+	observations.Lock()
+	defer observations.Unlock()
+
+	var res []ZoneObservation
+	for name, zo := range observations.zones {
+		if zone == "" || name == zone {
+			res = append(res, zo)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Zone < res[j].Zone })
+	return res
+}
@@ -0,0 +1,354 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC support. The CLI uses the OAuth 2.0 device authorization grant (RFC 8628) to
+// obtain a token for a human operator and caches it locally. musicd verifies such
+// tokens (RS256 signed JWTs) against the keys published by the same issuer, as an
+// alternative to the shared API key.
+
+type OIDCProvider struct {
+	Issuer                      string `json:"issuer"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	JwksURI                     string `json:"jwks_uri"`
+}
+
+type OIDCToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
+	ExpiresIn    int       `json:"expires_in,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// Valid returns true if the token can be used for at least another minute.
+func (t *OIDCToken) Valid() bool {
+	return t != nil && t.AccessToken != "" && time.Now().Add(time.Minute).Before(t.Expiry)
+}
+
+type oidcError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+var oidcClient = &http.Client{Timeout: 15 * time.Second}
+
+func OIDCDiscover(issuer string) (*OIDCProvider, error) {
+	wk := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := oidcClient.Get(wk)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery at %s failed: status %d", wk, resp.StatusCode)
+	}
+
+	var p OIDCProvider
+	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("OIDC discovery at %s: %v", wk, err)
+	}
+	return &p, nil
+}
+
+func oidcPostForm(endpoint string, form url.Values, result interface{}) (*oidcError, error) {
+	resp, err := oidcClient.PostForm(endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oe oidcError
+		if json.Unmarshal(buf, &oe) == nil && oe.Error != "" {
+			return &oe, nil
+		}
+		return nil, fmt.Errorf("%s: status %d: %s", endpoint, resp.StatusCode, string(buf))
+	}
+	return nil, json.Unmarshal(buf, result)
+}
+
+// OIDCDeviceLogin runs the device authorization flow. The prompt function is called
+// with the URL to visit and the code to enter, after which the token endpoint is polled
+// until the user has completed (or denied) the login.
+func OIDCDeviceLogin(p *OIDCProvider, clientid, scopes string,
+	prompt func(verifyuri, usercode string)) (*OIDCToken, error) {
+	if p.DeviceAuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("OIDC issuer %s does not support the device flow", p.Issuer)
+	}
+
+	var da struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+	}
+	oe, err := oidcPostForm(p.DeviceAuthorizationEndpoint,
+		url.Values{"client_id": {clientid}, "scope": {scopes}}, &da)
+	if err != nil {
+		return nil, err
+	}
+	if oe != nil {
+		return nil, fmt.Errorf("Device authorization failed: %s: %s", oe.Error, oe.Description)
+	}
+
+	verifyuri := da.VerificationURIComplete
+	if verifyuri == "" {
+		verifyuri = da.VerificationURI
+	}
+	prompt(verifyuri, da.UserCode)
+
+	interval := time.Duration(da.Interval) * time.Second
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(da.ExpiresIn) * time.Second)
+	if da.ExpiresIn == 0 {
+		deadline = time.Now().Add(10 * time.Minute)
+	}
+
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		var tok OIDCToken
+		oe, err := oidcPostForm(p.TokenEndpoint, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {da.DeviceCode},
+			"client_id":   {clientid},
+		}, &tok)
+		if err != nil {
+			return nil, err
+		}
+		if oe == nil {
+			tok.Expiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+			return &tok, nil
+		}
+
+		switch oe.Error {
+		case "authorization_pending":
+			// keep polling
+		case "slow_down":
+			interval += 5 * time.Second
+		default: // access_denied, expired_token, ...
+			return nil, fmt.Errorf("Login failed: %s: %s", oe.Error, oe.Description)
+		}
+	}
+	return nil, fmt.Errorf("Login failed: the device code expired")
+}
+
+func OIDCRefresh(p *OIDCProvider, clientid string, old *OIDCToken) (*OIDCToken, error) {
+	if old.RefreshToken == "" {
+		return nil, fmt.Errorf("No refresh token available. Please login again.")
+	}
+
+	var tok OIDCToken
+	oe, err := oidcPostForm(p.TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {old.RefreshToken},
+		"client_id":     {clientid},
+	}, &tok)
+	if err != nil {
+		return nil, err
+	}
+	if oe != nil {
+		return nil, fmt.Errorf("Token refresh failed: %s: %s", oe.Error, oe.Description)
+	}
+	tok.Expiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = old.RefreshToken
+	}
+	return &tok, nil
+}
+
+func LoadOIDCToken(file string) (*OIDCToken, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var tok OIDCToken
+	err = json.Unmarshal(buf, &tok)
+	return &tok, err
+}
+
+// SaveOIDCToken stores the token in a file only readable by the user.
+func SaveOIDCToken(file string, tok *OIDCToken) error {
+	buf, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, buf, 0600)
+}
+
+// OIDCVerifier verifies access tokens issued by one issuer for one audience.
+type OIDCVerifier struct {
+	Issuer   string
+	Audience string
+	provider *OIDCProvider
+	mu       sync.Mutex
+	keys     map[string]*rsa.PublicKey
+	fetched  time.Time
+}
+
+func NewOIDCVerifier(issuer, audience string) *OIDCVerifier {
+	return &OIDCVerifier{Issuer: issuer, Audience: audience}
+}
+
+func (v *OIDCVerifier) getKey(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// Unknown key ids trigger a refetch (key rollover), but at most once a minute.
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetched) < time.Minute {
+		return nil, fmt.Errorf("Unknown signing key '%s'", kid)
+	}
+	v.fetched = time.Now()
+
+	if v.provider == nil {
+		p, err := OIDCDiscover(v.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		v.provider = p
+	}
+
+	resp, err := oidcClient.Get(v.provider.JwksURI)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("Error decoding JWKS from %s: %v", v.provider.JwksURI, err)
+	}
+
+	v.keys = map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		v.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unknown signing key '%s'", kid)
+}
+
+// Verify checks the signature, issuer, audience and expiry of a JWT and returns the
+// subject (or preferred username, if present).
+func (v *OIDCVerifier) Verify(rawtoken string) (string, error) {
+	parts := strings.Split(rawtoken, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("Malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(buf, &header) != nil {
+		return "", fmt.Errorf("Malformed token header")
+	}
+	if header.Alg != "RS256" {
+		return "", fmt.Errorf("Unsupported token algorithm '%s'", header.Alg)
+	}
+
+	key, err := v.getKey(header.Kid)
+	if err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("Malformed token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return "", fmt.Errorf("Invalid token signature")
+	}
+
+	var claims struct {
+		Iss      string          `json:"iss"`
+		Sub      string          `json:"sub"`
+		Aud      json.RawMessage `json:"aud"`
+		Exp      int64           `json:"exp"`
+		Username string          `json:"preferred_username"`
+	}
+	buf, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(buf, &claims) != nil {
+		return "", fmt.Errorf("Malformed token claims")
+	}
+	if claims.Iss != v.Issuer {
+		return "", fmt.Errorf("Token issued by '%s', not '%s'", claims.Iss, v.Issuer)
+	}
+	if time.Now().Unix() >= claims.Exp {
+		return "", fmt.Errorf("Token expired")
+	}
+	if v.Audience != "" {
+		var auds []string
+		var aud string
+		if json.Unmarshal(claims.Aud, &aud) == nil {
+			auds = []string{aud}
+		} else {
+			json.Unmarshal(claims.Aud, &auds)
+		}
+		found := false
+		for _, a := range auds {
+			if a == v.Audience {
+				found = true
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("Token not issued for audience '%s'", v.Audience)
+		}
+	}
+
+	if claims.Username != "" {
+		return claims.Username, nil
+	}
+	return claims.Sub, nil
+}
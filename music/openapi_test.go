@@ -0,0 +1,101 @@
+package music
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+type apiDescription struct {
+	Paths map[string]struct {
+		Post struct {
+			RequestBody struct {
+				Content map[string]struct {
+					Schema struct {
+						Ref string `yaml:"$ref"`
+					} `yaml:"schema"`
+				} `yaml:"content"`
+			} `yaml:"requestBody"`
+		} `yaml:"post"`
+	} `yaml:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]interface{} `yaml:"properties"`
+		} `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+// jsonFields returns the names of the fields of t that encoding/json sends.
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(f.Type)...)
+			continue
+		}
+		switch {
+		case f.PkgPath != "", f.Tag.Get("json") == "-":
+			continue
+		case f.Type.Kind() == reflect.Func, f.Type.Kind() == reflect.Chan:
+			continue
+		}
+		fields = append(fields, f.Name)
+	}
+	return fields
+}
+
+// TestAPIDescription checks that openapi.yaml describes every field of the request and
+// response structs, and nothing else.
+func TestAPIDescription(t *testing.T) {
+	data, err := os.ReadFile("openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var desc apiDescription
+	if err := yaml.Unmarshal(data, &desc); err != nil {
+		t.Fatalf("openapi.yaml: %v", err)
+	}
+
+	for _, v := range []interface{}{
+		PingPost{}, PingResponse{},
+		SignerPost{}, SignerResponse{},
+		ZonePost{}, ZoneResponse{},
+		SignerGroupPost{}, SignerGroupResponse{},
+		ProcessPost{}, ProcessResponse{},
+		PolicyPost{}, PolicyResponse{},
+		ParentPost{}, ParentResponse{},
+		GitOpsPost{}, GitOpsResponse{},
+		UpsertPost{}, UpsertResponse{},
+		ShowPost{}, ShowResponse{},
+		TestPost{}, TestResponse{},
+	} {
+		typ := reflect.TypeOf(v)
+		schema, ok := desc.Components.Schemas[typ.Name()]
+		if !ok {
+			t.Errorf("openapi.yaml: no schema for %s", typ.Name())
+			continue
+		}
+		var props []string
+		for p := range schema.Properties {
+			props = append(props, p)
+		}
+		fields := jsonFields(typ)
+		sort.Strings(props)
+		sort.Strings(fields)
+		if !reflect.DeepEqual(props, fields) {
+			t.Errorf("openapi.yaml: %s has properties %v, the struct has fields %v", typ.Name(),
+				props, fields)
+		}
+	}
+
+	for path, item := range desc.Paths {
+		ref := item.Post.RequestBody.Content["application/json"].Schema.Ref
+		if ref == "" {
+			t.Errorf("openapi.yaml: %s has no request schema", path)
+		}
+	}
+}
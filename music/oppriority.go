@@ -0,0 +1,81 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Op priorities. The ops that an operator waits for (a zone stepped, or RRsets fetched
+// or copied, via the API) should not sit behind the background work of PushZones in the
+// queues of the rate-limiting managers. While the API works on a zone, the zone is
+// marked interactive (MarkInteractive) and the ops queued for it get
+// OpPriorityInteractive (see TrackSignerOp). OpQueue.Pop takes interactive ops ahead of
+// background ops, but after signers.queues.interactive-burst (default 4) interactive ops
+// have been taken ahead of waiting background ops the oldest background op is taken, so
+// background work is slowed down but never stopped.
+
+const (
+	OpPriorityBackground = iota
+	OpPriorityInteractive
+)
+
+var OpPriorityToString = map[int]string{
+	OpPriorityBackground:  "background",
+	OpPriorityInteractive: "interactive",
+}
+
+var interactiveZones = struct {
+	sync.Mutex
+	m map[string]int // zone: number of API requests working on it
+}{m: map[string]int{}}
+
+func priorityZoneKey(zone string) string {
+	return strings.ToLower(dns.Fqdn(zone))
+}
+
+// MarkInteractive marks the zone as being worked on by an operator until the returned
+// function is called.
+func MarkInteractive(zone string) func() {
+	key := priorityZoneKey(zone)
+	interactiveZones.Lock()
+	interactiveZones.m[key]++
+	interactiveZones.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			interactiveZones.Lock()
+			if interactiveZones.m[key]--; interactiveZones.m[key] <= 0 {
+				delete(interactiveZones.m, key)
+			}
+			interactiveZones.Unlock()
+		})
+	}
+}
+
+// ZonePriority returns the priority of the ops for the zone.
+func ZonePriority(zone string) int {
+	if zone == "" {
+		return OpPriorityBackground
+	}
+	interactiveZones.Lock()
+	defer interactiveZones.Unlock()
+	if interactiveZones.m[priorityZoneKey(zone)] > 0 {
+		return OpPriorityInteractive
+	}
+	return OpPriorityBackground
+}
+
+func interactiveBurst() int {
+	if !viper.IsSet("signers.queues.interactive-burst") {
+		return 4
+	}
+	return viper.GetInt("signers.queues.interactive-burst")
+}
@@ -0,0 +1,423 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Op queues. The rate-limiting managers (ddnsmgr, deSECmgr) keep the ops they cannot
+// carry out yet in an OpQueue. A queue holds at most signers.queues.maxlen ops (default
+// 1000); what happens to an op that arrives when the queue is full is decided by
+// signers.queues.overflow:
+//
+//   reject: (default) the op fails at once with ErrQueueFull. The caller (usually an FSM
+//           action) will try again later.
+//   spill:  the inserts and removes of an update are moved to the DB (spilled_ops) and
+//           read back when the op reaches the head of the queue. Only the op itself stays
+//           in memory. Fetches carry no data worth spilling and are rejected.
+//
+// An op that is still queued when its deadline (see TrackSignerOp) has passed is not
+// carried out but fails with ErrOpDeadline; the caller has likely given up on it anyway.
+//
+// Interactive ops are taken ahead of background ops, see oppriority.go.
+//
+// Queue lengths, the number of rejected, spilled and expired ops and the time the ops
+// waited in the queue are exported as metrics ("show queues" and /metrics).
+
+var ErrQueueFull = errors.New("signer op queue full, try again later")
+var ErrOpDeadline = errors.New("signer op deadline passed before it was carried out")
+
+type queuedOp struct {
+	op     SignerOp
+	queued time.Time
+}
+
+type OpQueue struct {
+	Name     string
+	ops      []queuedOp
+	spilled  map[string]bool // ids of the ops whose data is in the DB
+	rejected int
+	spills   int
+	expired  int
+	dequeued int
+	waited   time.Duration // total wait of the dequeued ops
+	maxwait  time.Duration
+	skipped  int // interactive ops taken ahead of waiting background ops in a row
+	mdb      *MusicDB
+	mu       sync.Mutex
+}
+
+type OpQueueStats struct {
+	Name        string
+	Length      int
+	Spilled     int // ops in the queue whose data is in the DB
+	Interactive int // interactive ops in the queue
+	Rejected    int // since musicd started
+	Spills      int // since musicd started
+	Expired     int // since musicd started
+	Dequeued    int // since musicd started
+	MaxLen      int
+	WaitTotal   float64 // seconds waited by the dequeued ops
+	MaxWait     float64 // seconds, longest wait of a dequeued op
+	Oldest      float64 // seconds, age of the op at the head of the queue
+}
+
+var opQueues = struct {
+	sync.Mutex
+	m map[string]*OpQueue
+}{m: map[string]*OpQueue{}}
+
+// NewOpQueue creates the queue name. Data spilled by an earlier run is of no use (the
+// callers are gone) and is removed.
+func NewOpQueue(name string, mdb *MusicDB) *OpQueue {
+	q := &OpQueue{Name: name, spilled: map[string]bool{}, mdb: mdb}
+	if mdb != nil {
+		const sqlq = "DELETE FROM spilled_ops WHERE queue=?"
+		_, err := mdb.db.Exec(sqlq, name)
+		CheckSQLError("NewOpQueue", sqlq, err, false)
+	}
+	opQueues.Lock()
+	opQueues.m[name] = q
+	opQueues.Unlock()
+	return q
+}
+
+func opQueueConfig() (maxlen int, overflow string) {
+	maxlen = viper.GetInt("signers.queues.maxlen")
+	if maxlen <= 0 {
+		maxlen = 1000
+	}
+	overflow = strings.ToLower(viper.GetString("signers.queues.overflow"))
+	if overflow != "spill" {
+		overflow = "reject"
+	}
+	return
+}
+
+// Push adds op to the tail of the queue, or, when the queue is full, applies the
+// overflow policy.
+func (q *OpQueue) Push(op SignerOp) {
+	maxlen, overflow := opQueueConfig()
+
+	q.mu.Lock()
+	inmem := len(q.ops) - len(q.spilled)
+	if inmem < maxlen {
+		q.ops = append(q.ops, queuedOp{op: op, queued: time.Now()})
+		q.mu.Unlock()
+		return
+	}
+	if overflow == "spill" && (op.Inserts != nil || op.Removes != nil) {
+		err := q.spill(op)
+		if err == nil {
+			q.spilled[op.ID] = true
+			q.spills++
+			op.Inserts, op.Removes = nil, nil
+			q.ops = append(q.ops, queuedOp{op: op, queued: time.Now()})
+			q.mu.Unlock()
+			return
+		}
+		log.Printf("OpQueue %s: [%s] unable to spill op: %v", q.Name, op.ID, err)
+	}
+	q.rejected++
+	q.mu.Unlock()
+
+	log.Printf("OpQueue %s: [%s] queue full (%d ops), op for zone %s rejected", q.Name, op.ID,
+		maxlen, op.Zone)
+	op.Respond(SignerOpResult{Error: fmt.Errorf("%s: %w", q.Name, ErrQueueFull)})
+}
+
+// next returns the index of the op to take from the queue among those that eligible
+// accepts (all if nil): the oldest interactive op, unless too many of those have been
+// taken ahead of waiting background ops, in which case (or if there is none) the oldest
+// op. It returns -1 if no op is eligible.
+func (q *OpQueue) next(eligible func(SignerOp) bool) int {
+	first := -1
+	for i, qop := range q.ops {
+		if eligible != nil && !eligible(qop.op) {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		if qop.op.Priority <= OpPriorityBackground {
+			continue
+		}
+		if i == first {
+			return i
+		}
+		if q.skipped < interactiveBurst() {
+			q.skipped++
+			return i
+		}
+		break
+	}
+	if first >= 0 {
+		q.skipped = 0
+	}
+	return first
+}
+
+// Pop removes the next op (see next) from the queue, with its data read back from the DB
+// if it was spilled. Ops whose deadline has passed are failed and skipped.
+func (q *OpQueue) Pop() (SignerOp, bool) {
+	return q.PopEligible(nil)
+}
+
+// PopEligible is Pop restricted to the ops that eligible accepts, e.g. those for signers
+// that are not busy.
+func (q *OpQueue) PopEligible(eligible func(SignerOp) bool) (SignerOp, bool) {
+	now := time.Now()
+	q.mu.Lock()
+	i := q.next(eligible)
+	if i < 0 {
+		q.mu.Unlock()
+		return SignerOp{}, false
+	}
+	qop := q.ops[i]
+	if i == 0 {
+		q.ops[0] = queuedOp{} // let go of the data
+		q.ops = q.ops[1:]
+	} else {
+		copy(q.ops[i:], q.ops[i+1:])
+		q.ops[len(q.ops)-1] = queuedOp{}
+		q.ops = q.ops[:len(q.ops)-1]
+	}
+	op := qop.op
+	spilled := q.spilled[op.ID]
+	delete(q.spilled, op.ID)
+	if op.Expired(now) {
+		q.expired++
+		q.mu.Unlock()
+		q.fail(op, spilled, qop.queued)
+		return q.PopEligible(eligible)
+	}
+	wait := now.Sub(qop.queued)
+	q.dequeued++
+	q.waited += wait
+	if wait > q.maxwait {
+		q.maxwait = wait
+	}
+	q.mu.Unlock()
+
+	if spilled {
+		if err := q.unspill(&op); err != nil {
+			log.Printf("OpQueue %s: [%s] unable to read back spilled op: %v", q.Name, op.ID, err)
+			op.Respond(SignerOpResult{Error: fmt.Errorf("%s: spilled op lost: %v", q.Name, err)})
+			return q.PopEligible(eligible)
+		}
+	}
+	return op, true
+}
+
+// Expire fails all queued ops whose deadline has passed, so that their callers need not
+// wait until the ops reach the head of the queue. It returns the number of ops expired.
+func (q *OpQueue) Expire() int {
+	now := time.Now()
+	type expiredOp struct {
+		queuedOp
+		spilled bool
+	}
+	var expired []expiredOp
+	q.mu.Lock()
+	kept := q.ops[:0]
+	for _, qop := range q.ops {
+		if qop.op.Expired(now) {
+			expired = append(expired, expiredOp{qop, q.spilled[qop.op.ID]})
+			delete(q.spilled, qop.op.ID)
+			continue
+		}
+		kept = append(kept, qop)
+	}
+	for i := len(kept); i < len(q.ops); i++ {
+		q.ops[i] = queuedOp{}
+	}
+	q.ops = kept
+	q.expired += len(expired)
+	q.mu.Unlock()
+
+	for _, e := range expired {
+		q.fail(e.op, e.spilled, e.queued)
+	}
+	return len(expired)
+}
+
+// fail responds to an op that passed its deadline in the queue.
+func (q *OpQueue) fail(op SignerOp, spilled bool, queued time.Time) {
+	if spilled && q.mdb != nil {
+		const sqlq = "DELETE FROM spilled_ops WHERE id=?"
+		_, err := q.mdb.db.Exec(sqlq, op.ID)
+		CheckSQLError("OpQueue.fail", sqlq, err, false)
+	}
+	log.Printf("OpQueue %s: [%s] deadline passed after %v in the queue, op for zone %s dropped",
+		q.Name, op.ID, time.Since(queued).Round(time.Second), op.Zone)
+	op.Respond(SignerOpResult{Error: fmt.Errorf("%s: %w", q.Name, ErrOpDeadline)})
+}
+
+func (q *OpQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.ops)
+}
+
+func rrsetsToStrings(rrsets *[][]dns.RR) [][]string {
+	if rrsets == nil {
+		return nil
+	}
+	res := [][]string{}
+	for _, rrset := range *rrsets {
+		var rrs []string
+		for _, rr := range rrset {
+			rrs = append(rrs, rr.String())
+		}
+		res = append(res, rrs)
+	}
+	return res
+}
+
+func stringsToRRsets(strs [][]string) (*[][]dns.RR, error) {
+	if strs == nil {
+		return nil, nil
+	}
+	res := [][]dns.RR{}
+	for _, rrstrs := range strs {
+		var rrset []dns.RR
+		for _, s := range rrstrs {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				return nil, err
+			}
+			rrset = append(rrset, rr)
+		}
+		res = append(res, rrset)
+	}
+	return &res, nil
+}
+
+func (q *OpQueue) spill(op SignerOp) error {
+	if q.mdb == nil {
+		return fmt.Errorf("no DB")
+	}
+	ins, _ := json.Marshal(rrsetsToStrings(op.Inserts))
+	rem, _ := json.Marshal(rrsetsToStrings(op.Removes))
+
+	const sqlq = "INSERT OR REPLACE INTO spilled_ops(id, queue, inserts, removes, spilled) VALUES (?, ?, ?, ?, ?)"
+	_, err := q.mdb.db.Exec(sqlq, op.ID, q.Name, string(ins), string(rem),
+		time.Now().UTC().Format(layout))
+	CheckSQLError("OpQueue.spill", sqlq, err, false)
+	return err
+}
+
+func (q *OpQueue) unspill(op *SignerOp) error {
+	var ins, rem string
+	const sqlq = "SELECT inserts, removes FROM spilled_ops WHERE id=?"
+	err := q.mdb.db.QueryRow(sqlq, op.ID).Scan(&ins, &rem)
+	if err != nil {
+		return err
+	}
+	const sqlq2 = "DELETE FROM spilled_ops WHERE id=?"
+	_, err = q.mdb.db.Exec(sqlq2, op.ID)
+	CheckSQLError("OpQueue.unspill", sqlq2, err, false)
+
+	var insstrs, remstrs [][]string
+	if err := json.Unmarshal([]byte(ins), &insstrs); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(rem), &remstrs); err != nil {
+		return err
+	}
+	if op.Inserts, err = stringsToRRsets(insstrs); err != nil {
+		return err
+	}
+	op.Removes, err = stringsToRRsets(remstrs)
+	return err
+}
+
+// ListOpQueueStats returns the occupancy of all queues, by name.
+func ListOpQueueStats() []OpQueueStats {
+	maxlen, _ := opQueueConfig()
+	now := time.Now()
+	var res []OpQueueStats
+	opQueues.Lock()
+	for _, q := range opQueues.m {
+		q.mu.Lock()
+		s := OpQueueStats{
+			Name:      q.Name,
+			Length:    len(q.ops),
+			Spilled:   len(q.spilled),
+			Rejected:  q.rejected,
+			Spills:    q.spills,
+			Expired:   q.expired,
+			Dequeued:  q.dequeued,
+			MaxLen:    maxlen,
+			WaitTotal: q.waited.Seconds(),
+			MaxWait:   q.maxwait.Seconds(),
+		}
+		if len(q.ops) > 0 {
+			s.Oldest = now.Sub(q.ops[0].queued).Seconds()
+		}
+		for _, qop := range q.ops {
+			if qop.op.Priority > OpPriorityBackground {
+				s.Interactive++
+			}
+		}
+		res = append(res, s)
+		q.mu.Unlock()
+	}
+	opQueues.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func FormatOpQueueMetrics(stats []OpQueueStats) string {
+	var out strings.Builder
+	metrics := []struct {
+		name, help, typ string
+		value           func(OpQueueStats) float64
+	}{
+		{"music_op_queue_length", "Signer ops waiting in the queue of a manager.", "gauge",
+			func(s OpQueueStats) float64 { return float64(s.Length) }},
+		{"music_op_queue_interactive", "Interactive signer ops waiting in the queue of a manager.", "gauge",
+			func(s OpQueueStats) float64 { return float64(s.Interactive) }},
+		{"music_op_queue_spilled", "Queued signer ops whose data has been spilled to the DB.", "gauge",
+			func(s OpQueueStats) float64 { return float64(s.Spilled) }},
+		{"music_op_queue_maxlen", "Maximum number of signer ops kept in memory per queue.", "gauge",
+			func(s OpQueueStats) float64 { return float64(s.MaxLen) }},
+		{"music_op_queue_rejected_total", "Signer ops rejected because the queue was full.", "counter",
+			func(s OpQueueStats) float64 { return float64(s.Rejected) }},
+		{"music_op_queue_spills_total", "Signer ops spilled to the DB because the queue was full.", "counter",
+			func(s OpQueueStats) float64 { return float64(s.Spills) }},
+		{"music_op_queue_expired_total", "Signer ops dropped because their deadline passed in the queue.", "counter",
+			func(s OpQueueStats) float64 { return float64(s.Expired) }},
+		{"music_op_queue_dequeued_total", "Signer ops taken from the queue to be carried out.", "counter",
+			func(s OpQueueStats) float64 { return float64(s.Dequeued) }},
+		{"music_op_queue_wait_seconds_total", "Time the dequeued signer ops waited in the queue.", "counter",
+			func(s OpQueueStats) float64 { return s.WaitTotal }},
+		{"music_op_queue_wait_seconds_max", "Longest time a dequeued signer op waited in the queue.", "gauge",
+			func(s OpQueueStats) float64 { return s.MaxWait }},
+		{"music_op_queue_oldest_seconds", "Time the op at the head of the queue has been waiting.", "gauge",
+			func(s OpQueueStats) float64 { return s.Oldest }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(&out, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&out, "# TYPE %s %s\n", m.name, m.typ)
+		for _, s := range stats {
+			fmt.Fprintf(&out, "%s{queue=\"%s\"} %s\n", m.name, promLabel(s.Name),
+				strconv.FormatFloat(m.value(s), 'f', -1, 64))
+		}
+	}
+	return out.String()
+}
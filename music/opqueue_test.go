@@ -0,0 +1,151 @@
+package music
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+func TestOpQueueReject(t *testing.T) {
+	viper.Set("signers.queues.maxlen", 2)
+	viper.Set("signers.queues.overflow", "spill") // no DB, so spilling fails
+	defer viper.Set("signers.queues.maxlen", nil)
+	defer viper.Set("signers.queues.overflow", nil)
+
+	q := NewOpQueue("test-reject", nil)
+	var ops []SignerOp
+	for i := 0; i < 3; i++ {
+		op := SignerOp{ID: newSignerOpID(), Removes: &[][]dns.RR{},
+			Response: make(chan SignerOpResult, 1)}
+		ops = append(ops, op)
+		q.Push(op)
+	}
+	if q.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", q.Len())
+	}
+	if res := <-ops[2].Response; !errors.Is(res.Error, ErrQueueFull) {
+		t.Errorf("overflowing op: error %v, want ErrQueueFull", res.Error)
+	}
+	for i := 0; i < 2; i++ {
+		if op, ok := q.Pop(); !ok || op.ID != ops[i].ID {
+			t.Errorf("Pop() = %s, %v, want %s", op.ID, ok, ops[i].ID)
+		}
+	}
+	if _, ok := q.Pop(); ok {
+		t.Errorf("Pop() from empty queue succeeded")
+	}
+
+	stats := ListOpQueueStats()
+	for _, s := range stats {
+		if s.Name == "test-reject" && (s.Rejected != 1 || s.Length != 0) {
+			t.Errorf("stats = %+v", s)
+		}
+	}
+}
+
+func TestRRsetsStrings(t *testing.T) {
+	rr, _ := dns.NewRR("example.com. 3600 IN NS ns1.example.net.")
+	in := &[][]dns.RR{{rr}, {}}
+	out, err := stringsToRRsets(rrsetsToStrings(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*out) != 2 || len((*out)[0]) != 1 || !dns.IsDuplicate((*out)[0][0], rr) {
+		t.Errorf("round trip of %v gave %v", *in, *out)
+	}
+	if out, _ := stringsToRRsets(rrsetsToStrings(nil)); out != nil {
+		t.Errorf("nil did not survive the round trip")
+	}
+}
+
+func TestOpQueueDeadline(t *testing.T) {
+	q := NewOpQueue("test-deadline", nil)
+	past := SignerOp{ID: newSignerOpID(), Deadline: time.Now().Add(-time.Second),
+		Response: make(chan SignerOpResult, 1)}
+	live := SignerOp{ID: newSignerOpID(), Deadline: time.Now().Add(time.Hour),
+		Response: make(chan SignerOpResult, 1)}
+	never := SignerOp{ID: newSignerOpID(), Response: make(chan SignerOpResult, 1)}
+
+	q.Push(past)
+	q.Push(live)
+	if n := q.Expire(); n != 1 || q.Len() != 1 {
+		t.Errorf("Expire() = %d, Len() = %d, want 1, 1", n, q.Len())
+	}
+	if res := <-past.Response; !errors.Is(res.Error, ErrOpDeadline) {
+		t.Errorf("expired op: error %v, want ErrOpDeadline", res.Error)
+	}
+
+	past.ID = newSignerOpID()
+	q.Push(past)
+	q.Push(never)
+	for _, want := range []string{live.ID, never.ID} {
+		if op, ok := q.Pop(); !ok || op.ID != want {
+			t.Errorf("Pop() = %s, %v, want %s", op.ID, ok, want)
+		}
+	}
+	if res := <-past.Response; !errors.Is(res.Error, ErrOpDeadline) {
+		t.Errorf("expired op at the head: error %v, want ErrOpDeadline", res.Error)
+	}
+
+	for _, s := range ListOpQueueStats() {
+		if s.Name == "test-deadline" && (s.Expired != 2 || s.Dequeued != 2 || s.MaxWait < 0 ||
+			s.WaitTotal < s.MaxWait) {
+			t.Errorf("stats = %+v", s)
+		}
+	}
+}
+
+func TestOpQueuePriority(t *testing.T) {
+	viper.Set("signers.queues.interactive-burst", 2)
+	defer viper.Set("signers.queues.interactive-burst", nil)
+
+	q := NewOpQueue("test-priority", nil)
+	for _, id := range []string{"b1", "b2", "i1", "i2", "i3", "b3", "i4"} {
+		op := SignerOp{ID: id, Response: make(chan SignerOpResult, 1)}
+		if id[0] == 'i' {
+			op.Priority = OpPriorityInteractive
+		}
+		q.Push(op)
+	}
+	var got []string
+	for op, ok := q.Pop(); ok; op, ok = q.Pop() {
+		got = append(got, op.ID)
+	}
+	want := []string{"i1", "i2", "b1", "i3", "i4", "b2", "b3"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Pop() order %v, want %v", got, want)
+	}
+
+	done := MarkInteractive("Example.com")
+	again := MarkInteractive("example.com.")
+	done()
+	done() // only once
+	if p := ZonePriority("example.com."); p != OpPriorityInteractive {
+		t.Errorf("ZonePriority() = %d while marked, want %d", p, OpPriorityInteractive)
+	}
+	again()
+	if p := ZonePriority("example.com"); p != OpPriorityBackground {
+		t.Errorf("ZonePriority() = %d after unmarking, want %d", p, OpPriorityBackground)
+	}
+}
+
+func TestOpQueuePopEligible(t *testing.T) {
+	q := NewOpQueue("test-eligible", nil)
+	for _, id := range []string{"a1", "a2", "b1"} {
+		q.Push(SignerOp{ID: id, Signer: &Signer{Name: id[:1]}, Response: make(chan SignerOpResult, 1)})
+	}
+	notA := func(op SignerOp) bool { return op.Signer.Name != "a" }
+	if op, ok := q.PopEligible(notA); !ok || op.ID != "b1" {
+		t.Errorf("PopEligible() = %s, %v, want b1", op.ID, ok)
+	}
+	if op, ok := q.PopEligible(notA); ok {
+		t.Errorf("PopEligible() = %s, want nothing", op.ID)
+	}
+	if q.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", q.Len())
+	}
+}
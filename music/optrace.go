@@ -0,0 +1,208 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Operation tracing. Every SignerOp that is placed on a fetch or update channel gets an
+// ID ("op-<n>") that follows it through the manager, the updater, the log messages and
+// the response. Until the response has been sent the op is kept in the in-flight table,
+// together with where it is (queued, running or held because of rate-limiting), so that
+// an operation that is stuck can be found ("show ops") rather than guessed at.
+//
+// An op also gets a deadline, signers.queues.deadline seconds (default 300) after it was
+// queued. The managers do not carry out an op after its deadline (see OpQueue); 0 means
+// no deadline. The caller waits for the response (RunSignerOp) until the deadline and
+// then gives up, which cancels the op if it has not been started yet.
+
+var ErrOpTimeout = errors.New("no response to signer op before its deadline")
+
+type InFlightOp struct {
+	ID       string
+	Queue    string // the channel (manager) the op was placed on
+	Command  string
+	Signer   string
+	Zone     string
+	Owner    string
+	RRtype   string
+	State    string // "queued", "running" or "held"
+	Priority string
+	Throttle string // throttling class of the zone, "" = none
+	Queued   time.Time
+	Deadline time.Time // zero = none
+	Started  time.Time // zero until the manager has picked up the op
+	Attempts int
+	Hold     int // seconds, when held
+}
+
+var signerOpSeq uint64
+
+var inFlight = struct {
+	sync.Mutex
+	ops map[string]*InFlightOp
+}{ops: map[string]*InFlightOp{}}
+
+func newSignerOpID() string {
+	return fmt.Sprintf("op-%d", atomic.AddUint64(&signerOpSeq, 1))
+}
+
+func signerOpDeadline() time.Duration {
+	if !viper.IsSet("signers.queues.deadline") {
+		return 300 * time.Second
+	}
+	return time.Duration(viper.GetInt("signers.queues.deadline")) * time.Second
+}
+
+// TrackSignerOp assigns an ID (unless it has one), a deadline (unless it has one) and
+// the priority of its zone to op and adds it to the in-flight table as queued on queue.
+func TrackSignerOp(op *SignerOp, queue string) {
+	if op.ID == "" {
+		op.ID = newSignerOpID()
+	}
+	if p := ZonePriority(op.Zone); p > op.Priority {
+		op.Priority = p
+	}
+	if op.Throttle == "" && op.Signer != nil {
+		op.Throttle = op.Signer.MusicDB().ZoneThrottleClass(nil, op.Zone)
+	}
+	now := time.Now()
+	if d := signerOpDeadline(); op.Deadline.IsZero() && d > 0 {
+		op.Deadline = now.Add(d)
+	}
+	ifo := &InFlightOp{
+		ID:       op.ID,
+		Queue:    queue,
+		Command:  op.Command,
+		Zone:     op.Zone,
+		Owner:    op.Owner,
+		State:    "queued",
+		Priority: OpPriorityToString[op.Priority],
+		Throttle: op.Throttle,
+		Queued:   now,
+		Deadline: op.Deadline,
+	}
+	if op.Signer != nil {
+		ifo.Signer = op.Signer.Name
+	}
+	if op.RRtype != 0 {
+		ifo.RRtype = dns.TypeToString[op.RRtype]
+	}
+	inFlight.Lock()
+	inFlight.ops[op.ID] = ifo
+	inFlight.Unlock()
+}
+
+// QueueSignerOp tracks op and places it on ch without waiting for the response, which
+// the caller reads from op.Response. The returned op carries the ID.
+func QueueSignerOp(ch chan SignerOp, queue string, op SignerOp) SignerOp {
+	TrackSignerOp(&op, queue)
+	ch <- op
+	return op
+}
+
+// RunSignerOp tracks op, places it on ch (the channel of a manager) and waits for the
+// response, or until the deadline of the op has passed.
+func RunSignerOp(ch chan SignerOp, queue string, op SignerOp) SignerOpResult {
+	op.Response = make(chan SignerOpResult, 2) // a manager must never block on a caller that is gone
+	TrackSignerOp(&op, queue)
+
+	var cancel context.CancelFunc
+	if op.Deadline.IsZero() {
+		op.ctx, cancel = context.WithCancel(context.Background())
+	} else {
+		op.ctx, cancel = context.WithDeadline(context.Background(), op.Deadline)
+	}
+	defer cancel()
+
+	select {
+	case ch <- op:
+		select {
+		case res := <-op.Response:
+			return res
+		case <-op.ctx.Done():
+		}
+	case <-op.ctx.Done(): // the channel stayed full
+	}
+	forgetSignerOp(op.ID)
+	log.Printf("RunSignerOp: [%s] %s %s for zone %s: no response before the deadline", op.ID,
+		queue, op.Command, op.Zone)
+	return SignerOpResult{OpID: op.ID, Error: fmt.Errorf("%s: [%s] %w", queue, op.ID, ErrOpTimeout)}
+}
+
+func forgetSignerOp(id string) {
+	inFlight.Lock()
+	delete(inFlight.ops, id)
+	inFlight.Unlock()
+}
+
+func updateInFlight(id string, f func(ifo *InFlightOp)) {
+	inFlight.Lock()
+	if ifo, ok := inFlight.ops[id]; ok {
+		f(ifo)
+	}
+	inFlight.Unlock()
+}
+
+// Expired returns true if the deadline of the op has passed at now, or if the caller has
+// stopped waiting for it.
+func (op SignerOp) Expired(now time.Time) bool {
+	if op.ctx != nil && op.ctx.Err() != nil {
+		return true
+	}
+	return !op.Deadline.IsZero() && now.After(op.Deadline)
+}
+
+// Running marks the op as being carried out (again).
+func (op SignerOp) Running() {
+	updateInFlight(op.ID, func(ifo *InFlightOp) {
+		if ifo.Started.IsZero() {
+			ifo.Started = time.Now()
+		}
+		ifo.State = "running"
+		ifo.Hold = 0
+		ifo.Attempts++
+	})
+}
+
+// Held marks the op as waiting out a rate-limit of hold seconds.
+func (op SignerOp) Held(hold int) {
+	updateInFlight(op.ID, func(ifo *InFlightOp) {
+		ifo.State = "held"
+		ifo.Hold = hold
+	})
+}
+
+// Respond sends the result of the op to whoever placed it and removes it from the
+// in-flight table.
+func (op SignerOp) Respond(res SignerOpResult) {
+	res.OpID = op.ID
+	forgetSignerOp(op.ID)
+	op.Response <- res
+}
+
+// ListInFlightOps returns the ops that have not been responded to, oldest first.
+func ListInFlightOps() []InFlightOp {
+	var res []InFlightOp
+	inFlight.Lock()
+	for _, ifo := range inFlight.ops {
+		res = append(res, *ifo)
+	}
+	inFlight.Unlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Queued.Before(res[j].Queued) })
+	return res
+}
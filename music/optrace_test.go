@@ -0,0 +1,77 @@
+package music
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func findInFlight(id string) (InFlightOp, bool) {
+	for _, ifo := range ListInFlightOps() {
+		if ifo.ID == id {
+			return ifo, true
+		}
+	}
+	return InFlightOp{}, false
+}
+
+func TestSignerOpTracing(t *testing.T) {
+	ch := make(chan SignerOp, 1)
+	op := QueueSignerOp(ch, "test-fetch", SignerOp{Command: "fetch", Signer: &Signer{Name: "s1"},
+		Zone: "example.com.", Response: make(chan SignerOpResult, 1)})
+	if op.ID == "" || (<-ch).ID != op.ID {
+		t.Fatalf("op queued without ID")
+	}
+
+	ifo, ok := findInFlight(op.ID)
+	if !ok || ifo.State != "queued" || ifo.Signer != "s1" || ifo.Queue != "test-fetch" {
+		t.Fatalf("in-flight op = %+v, %v", ifo, ok)
+	}
+
+	op.Running()
+	op.Held(7)
+	op.Running()
+	ifo, _ = findInFlight(op.ID)
+	if ifo.State != "running" || ifo.Attempts != 2 || ifo.Started.IsZero() {
+		t.Errorf("in-flight op = %+v", ifo)
+	}
+
+	op.Respond(SignerOpResult{})
+	if res := <-op.Response; res.OpID != op.ID {
+		t.Errorf("OpID = %q, want %q", res.OpID, op.ID)
+	}
+	if _, ok := findInFlight(op.ID); ok {
+		t.Errorf("op still in flight after the response")
+	}
+
+	other := QueueSignerOp(ch, "test-fetch", SignerOp{})
+	<-ch
+	if other.ID == op.ID {
+		t.Errorf("IDs not unique: %s", op.ID)
+	}
+}
+
+func TestRunSignerOpTimeout(t *testing.T) {
+	ch := make(chan SignerOp, 1)
+	deadline := time.Now().Add(50 * time.Millisecond)
+	res := RunSignerOp(ch, "test-fetch", SignerOp{Command: "fetch", Deadline: deadline})
+	if !errors.Is(res.Error, ErrOpTimeout) {
+		t.Fatalf("error %v, want ErrOpTimeout", res.Error)
+	}
+	if _, ok := findInFlight(res.OpID); ok {
+		t.Errorf("op still in flight after the timeout")
+	}
+	op := <-ch // never picked up by a manager
+	if !op.Expired(deadline.Add(-time.Second)) {
+		t.Errorf("op not cancelled after the caller gave up")
+	}
+	op.Respond(SignerOpResult{}) // must not block
+
+	go func() {
+		op := <-ch
+		op.Respond(SignerOpResult{Rcode: 3})
+	}()
+	if res := RunSignerOp(ch, "test-fetch", SignerOp{Command: "fetch"}); res.Error != nil || res.Rcode != 3 {
+		t.Errorf("RunSignerOp() = %+v, want the response", res)
+	}
+}
@@ -0,0 +1,367 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Parent capability probing. What a parent supports on paper and what it does in
+// practice are two different things. A probe observes a test zone (a zone in MuSiC
+// whose signers publish CDS or CSYNC that the parent has not yet acted on) until the
+// parent has updated the DS (or NS) RRset, or until the probe times out. The outcome
+// is stored in the profile of the parent and used by the processes, e.g. to not
+// publish CSYNC for parents that ignore it.
+
+const (
+	ParentProbeCds   = "cds"
+	ParentProbeCsync = "csync"
+
+	ParentProbeRunning = "running"
+	ParentProbeDone    = "done"
+	ParentProbeTimeout = "timeout"
+)
+
+type ParentProfile struct {
+	Parent       string
+	CdsProbed    bool
+	ScansCds     bool
+	CdsDelay     int // seconds from CDS publication to DS update
+	CsyncProbed  bool
+	AcceptsCsync bool
+	CsyncDelay   int // seconds from CSYNC publication to NS update
+	Updated      time.Time
+}
+
+type ParentProbe struct {
+	Parent   string
+	TestZone string
+	Kind     string
+	Started  time.Time
+	State    string
+	Result   string
+}
+
+// ParentOf returns the name of the parent zone, based on the zone name alone.
+func ParentOf(zone string) string {
+	labels := dns.SplitDomainName(zone)
+	if len(labels) <= 1 {
+		return "."
+	}
+	return dns.Fqdn(strings.Join(labels[1:], "."))
+}
+
+func (mdb *MusicDB) GetParentProfile(tx *sql.Tx, parent string) (*ParentProfile, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("GetParentProfile: Error from mdb.StartTransaction(): %v\n", err)
+		return nil, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT cdsprobed, scanscds, cdsdelay, csyncprobed, acceptscsync, csyncdelay,
+       COALESCE(updated, datetime('now')) FROM parent_profiles WHERE parent=?`
+
+	pp := ParentProfile{Parent: parent}
+	var updated string
+	err = tx.QueryRow(sqlq, parent).Scan(&pp.CdsProbed, &pp.ScansCds, &pp.CdsDelay,
+		&pp.CsyncProbed, &pp.AcceptsCsync, &pp.CsyncDelay, &updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if CheckSQLError("GetParentProfile", sqlq, err, false) {
+		return nil, err
+	}
+	pp.Updated, _ = time.Parse(layout, updated)
+	return &pp, nil
+}
+
+func (mdb *MusicDB) ListParentProfiles(tx *sql.Tx) ([]ParentProfile, []ParentProbe, error) {
+	var ppl []ParentProfile
+	var probes []ParentProbe
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListParentProfiles: Error from mdb.StartTransaction(): %v\n", err)
+		return ppl, probes, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT parent FROM parent_profiles ORDER BY parent"
+	rows, err := tx.Query(sqlq)
+	if CheckSQLError("ListParentProfiles", sqlq, err, false) {
+		return ppl, probes, err
+	}
+	var parents []string
+	for rows.Next() {
+		var parent string
+		if err := rows.Scan(&parent); err != nil {
+			log.Fatalf("ListParentProfiles: Error from rows.Scan(): %v", err)
+		}
+		parents = append(parents, parent)
+	}
+	rows.Close()
+
+	for _, parent := range parents {
+		pp, err := mdb.GetParentProfile(tx, parent)
+		if err != nil {
+			return ppl, probes, err
+		}
+		ppl = append(ppl, *pp)
+	}
+
+	probes, err = mdb.listParentProbes(tx, "")
+	return ppl, probes, err
+}
+
+func (mdb *MusicDB) listParentProbes(tx *sql.Tx, state string) ([]ParentProbe, error) {
+	var probes []ParentProbe
+
+	const sqlq = `
+SELECT parent, testzone, kind, COALESCE(started, datetime('now')), state, result
+FROM parent_probes WHERE ?='' OR state=? ORDER BY parent, kind`
+	rows, err := tx.Query(sqlq, state, state)
+	if CheckSQLError("listParentProbes", sqlq, err, false) {
+		return probes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p ParentProbe
+		var started string
+		if err := rows.Scan(&p.Parent, &p.TestZone, &p.Kind, &started, &p.State,
+			&p.Result); err != nil {
+			log.Fatalf("listParentProbes: Error from rows.Scan(): %v", err)
+		}
+		p.Started, _ = time.Parse(layout, started)
+		probes = append(probes, p)
+	}
+	return probes, nil
+}
+
+// probeObserve returns whether the signers of the test zone publish the record the
+// probe is about and whether the parent has acted on it.
+func (z *Zone) probeObserve(kind string) (bool, bool, error) {
+	switch kind {
+	case ParentProbeCds:
+		return z.dsMatchesCds(z.SGroup.SignerMap)
+	case ParentProbeCsync:
+		return z.nsMatchesCsync(z.SGroup.SignerMap)
+	}
+	return false, false, fmt.Errorf("Unknown probe kind '%s'", kind)
+}
+
+// nsMatchesCsync compares the NS RRset in the parent with the union of the NS RRsets
+// of the signers. The first return value is false if no signer publishes CSYNC.
+func (z *Zone) nsMatchesCsync(signers map[string]*Signer) (bool, bool, error) {
+	havecsync := false
+	nsmap := map[string]bool{}
+	for _, s := range signers {
+		c := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
+		for _, rrtype := range []uint16{dns.TypeCSYNC, dns.TypeNS} {
+			m := new(dns.Msg)
+			m.SetQuestion(z.Name, rrtype)
+			r, _, err := s.Exchange(c, m)
+			if err != nil {
+				return false, false, err
+			}
+			for _, rr := range r.Answer {
+				switch rr := rr.(type) {
+				case *dns.CSYNC:
+					havecsync = true
+				case *dns.NS:
+					nsmap[strings.ToLower(rr.Ns)] = true
+				}
+			}
+		}
+	}
+	if !havecsync {
+		return false, false, nil
+	}
+
+	parentAddress, exist, err := z.MusicDB.GetMeta(nil, z, "parentaddr")
+	if err != nil || !exist {
+		return true, false, err
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(z.Name, dns.TypeNS)
+	r, _, err := DnsExchange(new(dns.Client), m, parentAddress)
+	if err != nil {
+		return true, false, err
+	}
+	parentns := map[string]bool{}
+	for _, rr := range r.Ns {
+		if ns, ok := rr.(*dns.NS); ok {
+			parentns[strings.ToLower(ns.Ns)] = true
+		}
+	}
+	if len(parentns) != len(nsmap) {
+		return true, false, nil
+	}
+	for ns := range nsmap {
+		if !parentns[ns] {
+			return true, false, nil
+		}
+	}
+	return true, true, nil
+}
+
+// StartParentProbe starts observing the parent of testzone. The signers of the test zone
+// must publish CDS (or CSYNC) that the parent has not yet acted on.
+func (mdb *MusicDB) StartParentProbe(tx *sql.Tx, dbzone *Zone, kind string) (string, error) {
+	if !dbzone.Exists {
+		return "", fmt.Errorf("Zone %s unknown", dbzone.Name)
+	}
+	if dbzone.SGroup == nil || len(dbzone.SGroup.SignerMap) == 0 {
+		return "", fmt.Errorf("Zone %s has no signers to observe.", dbzone.Name)
+	}
+	if kind != ParentProbeCds && kind != ParentProbeCsync {
+		return "", fmt.Errorf("Unknown probe kind '%s' (known kinds: cds, csync)", kind)
+	}
+
+	published, synced, err := dbzone.probeObserve(kind)
+	if err != nil {
+		return "", err
+	}
+	if !published {
+		return "", fmt.Errorf("The signers of zone %s do not publish %s.", dbzone.Name,
+			strings.ToUpper(kind))
+	}
+	if synced {
+		return "", fmt.Errorf("The parent of zone %s is already in sync with its %s. Nothing to observe.",
+			dbzone.Name, strings.ToUpper(kind))
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("StartParentProbe: Error from mdb.StartTransaction(): %v\n", err)
+		return "", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	parent := ParentOf(dbzone.Name)
+	const sqlq = `
+INSERT OR REPLACE INTO parent_probes(parent, testzone, kind, started, state, result)
+VALUES (?, ?, ?, datetime('now'), ?, '')`
+	_, err = tx.Exec(sqlq, parent, dbzone.Name, kind, ParentProbeRunning)
+	if CheckSQLError("StartParentProbe", sqlq, err, false) {
+		return "", err
+	}
+	return fmt.Sprintf("Started %s probe of parent %s using test zone %s.", kind, parent,
+		dbzone.Name), nil
+}
+
+// CheckParentProbes looks at all running probes and records the results of those that
+// are finished, either because the parent acted or because the probe timed out.
+func (mdb *MusicDB) CheckParentProbes(timeout time.Duration) error {
+	tx, err := mdb.Begin()
+	if err != nil {
+		return err
+	}
+	probes, err := mdb.listParentProbes(tx, ParentProbeRunning)
+	tx.Rollback()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range probes {
+		dbzone, _, err := mdb.GetZone(nil, p.TestZone)
+		if err != nil || !dbzone.Exists {
+			mdb.finishParentProbe(p, ParentProbeTimeout, false, 0, "test zone no longer exists")
+			continue
+		}
+
+		published, synced, err := dbzone.probeObserve(p.Kind)
+		elapsed := time.Since(p.Started)
+		switch {
+		case err != nil:
+			log.Printf("CheckParentProbes: %s probe of %s: %v", p.Kind, p.Parent, err)
+		case synced:
+			mdb.finishParentProbe(p, ParentProbeDone, true, int(elapsed.Seconds()),
+				fmt.Sprintf("parent acted after %v", elapsed.Round(time.Second)))
+			continue
+		case !published:
+			mdb.finishParentProbe(p, ParentProbeTimeout, false, 0,
+				strings.ToUpper(p.Kind)+" withdrawn from test zone before parent acted")
+			continue
+		}
+
+		if elapsed > timeout {
+			mdb.finishParentProbe(p, ParentProbeTimeout, false, 0,
+				fmt.Sprintf("parent did not act within %v", timeout))
+		}
+	}
+	return nil
+}
+
+func (mdb *MusicDB) finishParentProbe(p ParentProbe, state string, supported bool, delay int,
+	result string) {
+	tx, err := mdb.Begin()
+	if err != nil {
+		log.Printf("finishParentProbe: Error from mdb.Begin(): %v", err)
+		return
+	}
+
+	const sqlq = "UPDATE parent_probes SET state=?, result=? WHERE parent=? AND kind=?"
+	_, err = tx.Exec(sqlq, state, result, p.Parent, p.Kind)
+	if CheckSQLError("finishParentProbe", sqlq, err, false) {
+		tx.Rollback()
+		return
+	}
+
+	const sqlq2 = "INSERT OR IGNORE INTO parent_profiles(parent) VALUES (?)"
+	_, err = tx.Exec(sqlq2, p.Parent)
+	if CheckSQLError("finishParentProbe", sqlq2, err, false) {
+		tx.Rollback()
+		return
+	}
+
+	sqlq3 := `
+UPDATE parent_profiles SET cdsprobed=1, scanscds=?, cdsdelay=?, updated=datetime('now') WHERE parent=?`
+	if p.Kind == ParentProbeCsync {
+		sqlq3 = `
+UPDATE parent_profiles SET csyncprobed=1, acceptscsync=?, csyncdelay=?, updated=datetime('now') WHERE parent=?`
+	}
+	_, err = tx.Exec(sqlq3, supported, delay, p.Parent)
+	if CheckSQLError("finishParentProbe", sqlq3, err, false) {
+		tx.Rollback()
+		return
+	}
+	tx.Commit()
+	log.Printf("Parent probe: %s probe of %s using %s finished: %s", p.Kind, p.Parent,
+		p.TestZone, result)
+}
+
+// ParentProfile returns the profile of the parent of the zone, or nil if the parent has
+// not been probed.
+func (z *Zone) ParentProfile() *ParentProfile {
+	if z.MusicDB == nil {
+		return nil
+	}
+	pp, err := z.MusicDB.GetParentProfile(nil, ParentOf(z.Name))
+	if err != nil {
+		log.Printf("ParentProfile: zone %s: %v", z.Name, err)
+		return nil
+	}
+	return pp
+}
+
+// SkipCsync returns true if CSYNC should not be published for the zone, either because
+// the process was started with skip-csync, because the zone type does not signal the
+// parent or because the parent is known to ignore CSYNC.
+func (z *Zone) SkipCsync() bool {
+	if z.ProcessParamBool("skip-csync") || !z.Type().ParentSignals {
+		return true
+	}
+	pp := z.ParentProfile()
+	return pp != nil && pp.CsyncProbed && !pp.AcceptsCsync
+}
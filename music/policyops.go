@@ -0,0 +1,334 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A Policy collects the TTLs, timers and algorithms that the FSM actions use when
+// they publish CDS/CDNSKEY/CSYNC records and wait for the parent. A policy may be
+// assigned to a zone or to a signer group. A zone policy takes precedence over the
+// policy of the signer group and if neither exists the DefaultPolicy is used.
+type Policy struct {
+	Name        string
+	CdsTTL      uint32  // TTL of published CDS/CDNSKEY, 0 = same as DNSKEY
+	CsyncTTL    uint32  // TTL of published CSYNC
+	NsTTL       uint32  // TTL of the apex NS RRset before CSYNC is published, 0 = as is
+	DsHoldDown  int     // seconds to wait for DS propagation, 0 = 2 * largest TTL
+	NsHoldDown  int     // seconds to wait for NS propagation, 0 = 2 * largest TTL
+	Algorithms  []uint8 // allowed DNSKEY algorithms, empty = all
+	DigestTypes []uint8 // CDS digest types, empty = per process (see CdsDigests)
+}
+
+var DefaultPolicy = Policy{
+	Name:        "default",
+	CdsTTL:      0,
+	CsyncTTL:    300,
+	NsTTL:       0,
+	DsHoldDown:  0,
+	NsHoldDown:  0,
+	Algorithms:  []uint8{},
+	DigestTypes: []uint8{},
+}
+
+// CdsDigests returns the digest types to publish CDS for, the first of which is used
+// for verification. Without digest types in the policy the FSM action passes its own
+// default: joining signers publish SHA-256 and SHA-384 CDS, while leaving signers keep
+// to SHA-256 as before policies were introduced.
+func (p *Policy) CdsDigests(def ...uint8) []uint8 {
+	if len(p.DigestTypes) == 0 {
+		return def
+	}
+	return p.DigestTypes
+}
+
+// AlgorithmAllowed reports whether DNSKEYs with algorithm alg may be used under the policy.
+func (p *Policy) AlgorithmAllowed(alg uint8) bool {
+	if len(p.Algorithms) == 0 {
+		return true
+	}
+	for _, a := range p.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// HoldDown returns the time to wait given the largest TTL found and a configured hold down.
+func HoldDown(holddown int, ttl uint32) time.Duration {
+	if holddown > 0 {
+		return time.Duration(holddown) * time.Second
+	}
+	return time.Duration(ttl*2) * time.Second
+}
+
+func Uint8ListToString(l []uint8) string {
+	var s []string
+	for _, v := range l {
+		s = append(s, strconv.Itoa(int(v)))
+	}
+	return strings.Join(s, ",")
+}
+
+func StringToUint8List(s string) ([]uint8, error) {
+	l := []uint8{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(f, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number between 0 and 255", f)
+		}
+		l = append(l, uint8(v))
+	}
+	return l, nil
+}
+
+func (mdb *MusicDB) AddPolicy(tx *sql.Tx, p Policy) (string, error) {
+	if p.Name == "" {
+		return "", fmt.Errorf("Policy name not specified.")
+	}
+	if p.Name == DefaultPolicy.Name {
+		return "", fmt.Errorf("Policy '%s' is built in and can not be modified.", p.Name)
+	}
+	for _, dt := range p.DigestTypes {
+		if dt != dns.SHA256 && dt != dns.SHA384 {
+			return "", fmt.Errorf("Policy %s: digest type %d not supported.", p.Name, dt)
+		}
+	}
+	for _, alg := range p.Algorithms {
+		if _, ok := dns.AlgorithmToString[alg]; !ok {
+			return "", fmt.Errorf("Policy %s: unknown DNSKEY algorithm %d.", p.Name, alg)
+		}
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("AddPolicy: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+INSERT OR REPLACE INTO policies(name, cdsttl, csyncttl, nsttl, dsholddown, nsholddown, algorithms, digests)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = tx.Exec(sqlq, p.Name, p.CdsTTL, p.CsyncTTL, p.NsTTL, p.DsHoldDown, p.NsHoldDown,
+		Uint8ListToString(p.Algorithms), Uint8ListToString(p.DigestTypes))
+	if CheckSQLError("AddPolicy", sqlq, err, false) {
+		return "", err
+	}
+	return fmt.Sprintf("Policy %s saved.", p.Name), nil
+}
+
+func (mdb *MusicDB) DeletePolicy(tx *sql.Tx, name string) (string, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("DeletePolicy: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	var count int
+	const sqlq = "SELECT COUNT(*) FROM policy_assignments WHERE policy=?"
+	err = tx.QueryRow(sqlq, name).Scan(&count)
+	if CheckSQLError("DeletePolicy", sqlq, err, false) {
+		return "", err
+	}
+	if count > 0 {
+		return "", fmt.Errorf("Policy %s is assigned to %d zones or signer groups.", name, count)
+	}
+
+	const sqlq2 = "DELETE FROM policies WHERE name=?"
+	res, err := tx.Exec(sqlq2, name)
+	if CheckSQLError("DeletePolicy", sqlq2, err, false) {
+		return "", err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return "", fmt.Errorf("Policy %s is unknown.", name)
+	}
+	return fmt.Sprintf("Policy %s deleted.", name), nil
+}
+
+func (mdb *MusicDB) GetPolicy(tx *sql.Tx, name string) (*Policy, error) {
+	if name == "" || name == DefaultPolicy.Name {
+		p := DefaultPolicy
+		return &p, nil
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("GetPolicy: Error from mdb.StartTransaction(): %v\n", err)
+		return nil, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT cdsttl, csyncttl, nsttl, dsholddown, nsholddown, algorithms, digests FROM policies WHERE name=?`
+
+	p := Policy{Name: name}
+	var algs, digests string
+	err = tx.QueryRow(sqlq, name).Scan(&p.CdsTTL, &p.CsyncTTL, &p.NsTTL, &p.DsHoldDown,
+		&p.NsHoldDown, &algs, &digests)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("Policy %s is unknown.", name)
+	}
+	if CheckSQLError("GetPolicy", sqlq, err, false) {
+		return nil, err
+	}
+	if p.Algorithms, err = StringToUint8List(algs); err != nil {
+		return nil, err
+	}
+	if p.DigestTypes, err = StringToUint8List(digests); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (mdb *MusicDB) ListPolicies(tx *sql.Tx) (map[string]Policy, error) {
+	pl := map[string]Policy{DefaultPolicy.Name: DefaultPolicy}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListPolicies: Error from mdb.StartTransaction(): %v\n", err)
+		return pl, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT name FROM policies"
+	rows, err := tx.Query(sqlq)
+	if CheckSQLError("ListPolicies", sqlq, err, false) {
+		return pl, err
+	}
+	defer rows.Close()
+
+	var names []string
+	var name string
+	for rows.Next() {
+		if err := rows.Scan(&name); err != nil {
+			log.Fatalf("ListPolicies: Error from rows.Scan(): %v", err)
+		}
+		names = append(names, name)
+	}
+	for _, name := range names {
+		p, err := mdb.GetPolicy(tx, name)
+		if err != nil {
+			return pl, err
+		}
+		pl[name] = *p
+	}
+	return pl, nil
+}
+
+// AssignPolicy assigns a policy to a zone (objtype "zone") or a signer group (objtype
+// "signergroup"). Assigning the policy "default" removes any existing assignment.
+func (mdb *MusicDB) AssignPolicy(tx *sql.Tx, objtype, objname, policy string) (string, error) {
+	if objtype != "zone" && objtype != "signergroup" {
+		return "", fmt.Errorf("Policies can only be assigned to zones or signer groups.")
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("AssignPolicy: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if _, err = mdb.GetPolicy(tx, policy); err != nil {
+		return "", err
+	}
+
+	switch objtype {
+	case "zone":
+		z, exists, err := mdb.GetZone(tx, objname)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", fmt.Errorf("Zone %s unknown", objname)
+		}
+		objname = z.Name
+	case "signergroup":
+		if _, err = mdb.GetSignerGroup(tx, objname, false); err != nil {
+			return "", err
+		}
+	}
+
+	if policy == "" || policy == DefaultPolicy.Name {
+		const sqlq = "DELETE FROM policy_assignments WHERE objtype=? AND objname=?"
+		_, err = tx.Exec(sqlq, objtype, objname)
+		if CheckSQLError("AssignPolicy", sqlq, err, false) {
+			return "", err
+		}
+		return fmt.Sprintf("The %s %s now uses the default policy.", objtype, objname), nil
+	}
+
+	const sqlq = `
+INSERT OR REPLACE INTO policy_assignments(objtype, objname, policy) VALUES (?, ?, ?)`
+	_, err = tx.Exec(sqlq, objtype, objname, policy)
+	if CheckSQLError("AssignPolicy", sqlq, err, false) {
+		return "", err
+	}
+	return fmt.Sprintf("Policy %s assigned to %s %s.", policy, objtype, objname), nil
+}
+
+func (mdb *MusicDB) getPolicyAssignment(tx *sql.Tx, objtype, objname string) (string, error) {
+	const sqlq = "SELECT policy FROM policy_assignments WHERE objtype=? AND objname=?"
+
+	var policy string
+	err := tx.QueryRow(sqlq, objtype, objname).Scan(&policy)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if CheckSQLError("getPolicyAssignment", sqlq, err, false) {
+		return "", err
+	}
+	return policy, nil
+}
+
+// Policy returns the policy in effect for the zone. Errors are logged and result in
+// the default policy, as the FSM actions must always have a policy to work with.
+func (z *Zone) Policy() *Policy {
+	p := DefaultPolicy
+	mdb := z.MusicDB
+	if mdb == nil {
+		return &p
+	}
+
+	var tx *sql.Tx
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("Zone.Policy: Error from mdb.StartTransaction(): %v", err)
+		return &p
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	name, err := mdb.getPolicyAssignment(tx, "zone", z.Name)
+	if err == nil && name == "" && z.SGname != "" {
+		name, err = mdb.getPolicyAssignment(tx, "signergroup", z.SGname)
+	}
+	if err != nil {
+		log.Printf("Zone.Policy: zone %s: %v. Using default policy.", z.Name, err)
+		return &p
+	}
+
+	zp, err := mdb.GetPolicy(tx, name)
+	if err != nil {
+		log.Printf("Zone.Policy: zone %s: %v. Using default policy.", z.Name, err)
+		return &p
+	}
+	return zp
+}
@@ -0,0 +1,47 @@
+package music
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// labPolicy is the kind of policy used in a test lab, with short static hold downs
+// so that zones move through the processes quickly.
+var labPolicy = Policy{
+	Name:       "lab",
+	CsyncTTL:   300,
+	DsHoldDown: 5,
+	NsHoldDown: 5,
+}
+
+func TestHoldDown(t *testing.T) {
+	for _, tc := range []struct {
+		policy Policy
+		ttl    uint32
+		want   time.Duration
+	}{
+		{DefaultPolicy, 3600, 2 * time.Hour},
+		{DefaultPolicy, 0, 0},
+		{labPolicy, 3600, 5 * time.Second},
+	} {
+		if got := HoldDown(tc.policy.DsHoldDown, tc.ttl); got != tc.want {
+			t.Errorf("HoldDown(%s DS, %d) = %v, want %v", tc.policy.Name, tc.ttl, got, tc.want)
+		}
+		if got := HoldDown(tc.policy.NsHoldDown, tc.ttl); got != tc.want {
+			t.Errorf("HoldDown(%s NS, %d) = %v, want %v", tc.policy.Name, tc.ttl, got, tc.want)
+		}
+	}
+}
+
+func TestCdsDigests(t *testing.T) {
+	if got := DefaultPolicy.CdsDigests(dns.SHA256); !reflect.DeepEqual(got, []uint8{dns.SHA256}) {
+		t.Errorf("default policy: got %v, want the default of the caller", got)
+	}
+	p := Policy{Name: "sha384", DigestTypes: []uint8{dns.SHA384}}
+	if got := p.CdsDigests(dns.SHA256, dns.SHA384); !reflect.DeepEqual(got, []uint8{dns.SHA384}) {
+		t.Errorf("policy %s: got %v, want [%d]", p.Name, got, dns.SHA384)
+	}
+}
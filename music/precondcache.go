@@ -0,0 +1,274 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Pre-condition cache. A pre-condition that fails because a signer has not yet published
+// some data is by default evaluated again on every run of the FSM engine, sending the
+// same queries to the signers every time, although the answers can not be expected to
+// change before the data the signers sent has expired. With
+// fsmengine.precondcache.active set, the TTLs of the RRsets fetched while a pre-condition
+// is evaluated are observed: the TTL of the RRs for a positive answer, and the negative
+// TTL (SOA minimum) for an empty answer. When the pre-condition fails, the check (zone,
+// process, state and next state) is not evaluated again until the smallest TTL observed
+// has passed. The recheck interval is bounded by fsmengine.intervals.minimum and
+// fsmengine.precondcache.maximum; fsmengine.precondcache.negative (default the minimum) is
+// used for empty answers from signers that do not report a negative TTL (e.g. deSEC).
+//
+// An update or removal at a signer for the zone forgets its checks, and zones explicitly
+// asked for (e.g. "zone step") are always evaluated.
+
+type PreconditionCheck struct {
+	Zone     string
+	Process  string
+	State    string
+	Next     string
+	Reason   string // stop reason when the check failed
+	Queries  int    // RRsets fetched during the check
+	TTL      uint32 // smallest TTL observed, seconds
+	Observed time.Time
+	Recheck  time.Time
+}
+
+var precondCache = struct {
+	sync.Mutex
+	checks map[string]*PreconditionCheck // key: zone|process|state|next
+}{checks: map[string]*PreconditionCheck{}}
+
+// TTLs observed per zone while its pre-conditions are evaluated.
+type ttlWatch struct {
+	refs    int
+	queries int
+	ttl     uint32
+	seen    bool
+}
+
+var ttlWatches = struct {
+	sync.Mutex
+	zones    map[string]*ttlWatch
+	negative map[string]uint32 // key: signer|zone, learned from the SOA of empty answers
+}{zones: map[string]*ttlWatch{}, negative: map[string]uint32{}}
+
+func precondCacheActive() bool {
+	return viper.GetBool("fsmengine.precondcache.active")
+}
+
+func precondCacheBounds() (time.Duration, time.Duration) {
+	min := viper.GetInt("fsmengine.intervals.minimum")
+	if min < 15 {
+		min = 15
+	}
+	max := viper.GetInt("fsmengine.precondcache.maximum")
+	if max <= 0 {
+		max = 3600
+	}
+	if max < min {
+		max = min
+	}
+	return time.Duration(min) * time.Second, time.Duration(max) * time.Second
+}
+
+func precondZoneKey(zone string) string {
+	return strings.ToLower(dns.Fqdn(zone))
+}
+
+func precondKey(z *Zone, next string) string {
+	return precondZoneKey(z.Name) + "|" + z.FSM + "|" + z.State + "|" + next
+}
+
+// watchTTLs starts observing the TTLs of the RRsets fetched for the zone. The returned
+// function stops it and returns the number of RRsets fetched, the smallest TTL and
+// whether a TTL was observed at all.
+func watchTTLs(zone string) func() (int, uint32, bool) {
+	key := precondZoneKey(zone)
+	ttlWatches.Lock()
+	w, exist := ttlWatches.zones[key]
+	if !exist {
+		w = &ttlWatch{}
+		ttlWatches.zones[key] = w
+	}
+	w.refs++
+	ttlWatches.Unlock()
+
+	return func() (int, uint32, bool) {
+		ttlWatches.Lock()
+		defer ttlWatches.Unlock()
+		if w.refs--; w.refs <= 0 {
+			delete(ttlWatches.zones, key)
+		}
+		return w.queries, w.ttl, w.seen
+	}
+}
+
+// observeRRset records the TTL of an RRset fetched from the signer for the zone.
+func observeRRset(signer, zone string, rrs []dns.RR) {
+	key := precondZoneKey(zone)
+	ttlWatches.Lock()
+	defer ttlWatches.Unlock()
+	w, exist := ttlWatches.zones[key]
+	if !exist {
+		return
+	}
+	w.queries++
+
+	var ttl uint32
+	var known bool
+	for _, rr := range rrs {
+		if !known || rr.Header().Ttl < ttl {
+			ttl, known = rr.Header().Ttl, true
+		}
+	}
+	if len(rrs) == 0 {
+		ttl, known = ttlWatches.negative[strings.ToLower(signer)+"|"+key]
+	}
+	if !known {
+		ttl = uint32(viper.GetInt("fsmengine.precondcache.negative"))
+	}
+	if !w.seen || ttl < w.ttl {
+		w.ttl, w.seen = ttl, true
+	}
+}
+
+// learnNegativeTTL records the negative TTL of the zone at the signer from the SOA in
+// the authority section of an empty answer.
+func learnNegativeTTL(signer, zone string, r *dns.Msg) {
+	if r == nil || len(r.Answer) > 0 {
+		return
+	}
+	for _, rr := range r.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Minttl
+			if soa.Hdr.Ttl < ttl {
+				ttl = soa.Hdr.Ttl
+			}
+			ttlWatches.Lock()
+			ttlWatches.negative[strings.ToLower(signer)+"|"+precondZoneKey(zone)] = ttl
+			ttlWatches.Unlock()
+			return
+		}
+	}
+}
+
+// precondRecheck returns when a failed check should be evaluated again.
+func precondRecheck(now time.Time, ttl uint32, min, max time.Duration) time.Time {
+	d := time.Duration(ttl) * time.Second
+	switch {
+	case d < min:
+		d = min
+	case d > max:
+		d = max
+	}
+	return now.Add(d)
+}
+
+// preCondition evaluates the pre-condition of the transition of the zone to next and,
+// if it fails, records when it is worth evaluating again.
+func (mdb *MusicDB) preCondition(z *Zone, next string, t FSMTransition) bool {
+	if !precondCacheActive() || z.explain != nil {
+		return t.PreCondition(z)
+	}
+	done := watchTTLs(z.Name)
+	ok := t.PreCondition(z)
+	queries, ttl, seen := done()
+
+	key := precondKey(z, next)
+	precondCache.Lock()
+	defer precondCache.Unlock()
+	if ok || !seen {
+		delete(precondCache.checks, key)
+		return ok
+	}
+	now := time.Now()
+	min, max := precondCacheBounds()
+	mdb.stopmu.Lock()
+	reason := mdb.StopReasonCache[z.Name]
+	mdb.stopmu.Unlock()
+	c := &PreconditionCheck{
+		Zone:     z.Name,
+		Process:  z.FSM,
+		State:    z.State,
+		Next:     next,
+		Reason:   reason,
+		Queries:  queries,
+		TTL:      ttl,
+		Observed: now,
+		Recheck:  precondRecheck(now, ttl, min, max),
+	}
+	precondCache.checks[key] = c
+	log.Printf("Zone %s: pre-condition for '%s' failed, next check at %s (smallest TTL %ds)",
+		z.Name, next, c.Recheck.Format(time.RFC3339), ttl)
+	return ok
+}
+
+// preconditionsCached returns true if every transition from the current state of the
+// zone failed its pre-condition recently and the data observed then has not expired.
+// If so, the time of the first recheck is returned.
+func (mdb *MusicDB) preconditionsCached(z *Zone) (time.Time, bool) {
+	if !precondCacheActive() {
+		return time.Time{}, false
+	}
+	next := mdb.FSMlist[z.FSM].States[z.State].Next
+	if len(next) == 0 {
+		return time.Time{}, false
+	}
+	now := time.Now()
+	var first time.Time
+	precondCache.Lock()
+	defer precondCache.Unlock()
+	for name := range next {
+		c, exist := precondCache.checks[precondKey(z, name)]
+		if !exist || !now.Before(c.Recheck) {
+			return time.Time{}, false
+		}
+		if first.IsZero() || c.Recheck.Before(first) {
+			first = c.Recheck
+		}
+	}
+	return first, true
+}
+
+// forgetPreconditions drops the cached checks of the zone, e.g. after an update.
+func forgetPreconditions(zone string) {
+	prefix := precondZoneKey(zone) + "|"
+	precondCache.Lock()
+	defer precondCache.Unlock()
+	for k := range precondCache.checks {
+		if strings.HasPrefix(k, prefix) {
+			delete(precondCache.checks, k)
+		}
+	}
+}
+
+// ListPreconditionChecks returns the cached failed checks, by zone.
+func ListPreconditionChecks() []PreconditionCheck {
+	precondCache.Lock()
+	defer precondCache.Unlock()
+	var res []PreconditionCheck
+	now := time.Now()
+	for k, c := range precondCache.checks {
+		if !now.Before(c.Recheck) {
+			delete(precondCache.checks, k) // expired
+			continue
+		}
+		res = append(res, *c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Zone != res[j].Zone {
+			return res[i].Zone < res[j].Zone
+		}
+		return res[i].Next < res[j].Next
+	})
+	return res
+}
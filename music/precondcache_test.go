@@ -0,0 +1,64 @@
+package music
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+func TestPreconditionCache(t *testing.T) {
+	viper.Set("fsmengine.precondcache.active", true)
+	defer viper.Set("fsmengine.precondcache", nil)
+
+	mdb := &MusicDB{FSMlist: map[string]FSM{
+		"add-signer": {States: map[string]FSMState{
+			"wait": {Next: map[string]FSMTransition{"done": {}}},
+		}},
+	}}
+	z := &Zone{Name: "example.com.", FSM: "add-signer", State: "wait", MusicDB: mdb}
+
+	dnskey, _ := dns.NewRR("example.com. 600 IN DNSKEY 257 3 13 AAAA")
+	soa, _ := dns.NewRR("example.com. 3600 IN SOA ns. h. 1 2 3 4 300")
+	learnNegativeTTL("s2", "example.com.", &dns.Msg{Ns: []dns.RR{soa}})
+
+	var fetches int
+	fail := FSMTransition{PreCondition: func(z *Zone) bool {
+		fetches++
+		observeRRset("s1", z.Name, []dns.RR{dnskey})
+		observeRRset("s2", z.Name, nil) // empty, negative TTL 300
+		return false
+	}}
+	if mdb.preCondition(z, "done", fail) {
+		t.Fatalf("preCondition() = true")
+	}
+	recheck, cached := mdb.preconditionsCached(z)
+	if !cached || time.Until(recheck) < 290*time.Second || time.Until(recheck) > 300*time.Second {
+		t.Errorf("preconditionsCached() = %v, %v wanted about 300s", time.Until(recheck), cached)
+	}
+	if checks := ListPreconditionChecks(); len(checks) != 1 || checks[0].Queries != 2 || checks[0].TTL != 300 {
+		t.Errorf("ListPreconditionChecks() = %+v", checks)
+	}
+
+	observeRRset("s1", z.Name, []dns.RR{dnskey}) // not watched
+	forgetPreconditions("EXAMPLE.COM")
+	if _, cached := mdb.preconditionsCached(z); cached {
+		t.Errorf("check still cached after forgetPreconditions")
+	}
+
+	ok := FSMTransition{PreCondition: func(z *Zone) bool { return true }}
+	mdb.preCondition(z, "done", fail)
+	mdb.preCondition(z, "done", ok)
+	if _, cached := mdb.preconditionsCached(z); cached || fetches != 2 {
+		t.Errorf("check still cached after the pre-condition passed")
+	}
+
+	min, max := 15*time.Second, time.Hour
+	now := time.Now()
+	for ttl, want := range map[uint32]time.Duration{0: min, 60: time.Minute, 86400: max} {
+		if got := precondRecheck(now, ttl, min, max).Sub(now); got != want {
+			t.Errorf("precondRecheck(%d) = %v wanted %v", ttl, got, want)
+		}
+	}
+}
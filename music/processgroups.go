@@ -0,0 +1,148 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Process concurrency groups. Some processes are heavy for the signers (e.g. two KSK
+// rollovers of huge zones on the same small signer) and should not run concurrently
+// for zones that share a signer. Such processes are declared as a group in the config:
+//
+//   signers.concurrency.groups.<name>.processes: [ ksk-rollover, zsk-rollover ]
+//   signers.concurrency.groups.<name>.max:       1
+//
+// A zone in the initial state of a process in a group does not make its first transition
+// while, at any of its signers, max other zones are running a process of the group
+// (i.e. are past the initial state and not stopped). Zones that have to wait get a stop
+// reason and are let through in turn as the running zones complete.
+//
+// Unlike the per-signer limits (signerlimits.go), which only serialize the actions, the
+// group applies for the whole duration of the processes.
+
+type ProcessGroup struct {
+	Name      string
+	Processes []string
+	Max       int
+}
+
+func processGroups() []ProcessGroup {
+	var groups []ProcessGroup
+	for name := range viper.GetStringMap("signers.concurrency.groups") {
+		key := "signers.concurrency.groups." + name
+		pg := ProcessGroup{
+			Name:      name,
+			Processes: viper.GetStringSlice(key + ".processes"),
+			Max:       viper.GetInt(key + ".max"),
+		}
+		if pg.Max <= 0 {
+			pg.Max = 1
+		}
+		groups = append(groups, pg)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+func (pg ProcessGroup) has(process string) bool {
+	for _, p := range pg.Processes {
+		if p == process {
+			return true
+		}
+	}
+	return false
+}
+
+// startsGroupedProcess returns true if the zone is about to start a process of a group.
+// The check in processGroupBlocked is only reliable if such zones are not pushed
+// concurrently, see pushParallel.
+func (mdb *MusicDB) startsGroupedProcess(z *Zone) bool {
+	process, ok := mdb.FSMlist[z.FSM]
+	if !ok || z.State != process.InitialState {
+		return false
+	}
+	for _, pg := range processGroups() {
+		if pg.has(z.FSM) {
+			return true
+		}
+	}
+	return false
+}
+
+// processGroupBlocked returns the reason why the zone may not start its process now, if
+// there is one.
+func (mdb *MusicDB) processGroupBlocked(tx *sql.Tx, z *Zone) (string, bool) {
+	process, ok := mdb.FSMlist[z.FSM]
+	if !ok || z.State != process.InitialState {
+		return "", false
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("processGroupBlocked: Error from mdb.StartTransaction(): %v\n", err)
+		return "", false
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	for _, pg := range processGroups() {
+		if !pg.has(z.FSM) {
+			continue
+		}
+		busy, err := mdb.processGroupBusySigners(tx, z, pg)
+		if err != nil {
+			log.Printf("processGroupBlocked: zone %s: %v", z.Name, err)
+			continue
+		}
+		if len(busy) > 0 {
+			return fmt.Sprintf("Waiting for other zones in the '%s' processes (%s) at signer(s) %s",
+				pg.Name, strings.Join(pg.Processes, ", "), strings.Join(busy, ", ")), true
+		}
+	}
+	return "", false
+}
+
+// processGroupBusySigners returns the signers of the zone that already have pg.Max other
+// zones running a process of the group.
+func (mdb *MusicDB) processGroupBusySigners(tx *sql.Tx, z *Zone, pg ProcessGroup) ([]string, error) {
+	const sqlq = `
+SELECT gs.signer, z.fsm, z.state FROM zones z, group_signers gs
+WHERE z.sgroup = gs.name AND z.name != ? AND z.fsm != ''
+AND gs.signer IN (SELECT signer FROM group_signers WHERE name = ?)`
+
+	rows, err := tx.Query(sqlq, z.Name, z.SGname)
+	if CheckSQLError("processGroupBusySigners", sqlq, err, false) {
+		return nil, err
+	}
+	defer rows.Close()
+
+	running := map[string]int{}
+	for rows.Next() {
+		var signer, fsm, state string
+		if err := rows.Scan(&signer, &fsm, &state); err != nil {
+			return nil, err
+		}
+		process, ok := mdb.FSMlist[fsm]
+		if !pg.has(fsm) || !ok || state == process.InitialState || state == FsmStateStop {
+			continue
+		}
+		running[signer]++
+	}
+
+	var busy []string
+	for signer, n := range running {
+		if n >= pg.Max {
+			busy = append(busy, signer)
+		}
+	}
+	sort.Strings(busy)
+	return busy, nil
+}
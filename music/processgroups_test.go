@@ -0,0 +1,31 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestProcessGroups(t *testing.T) {
+	viper.Set("signers.concurrency.groups", map[string]interface{}{
+		"rollovers": map[string]interface{}{
+			"processes": []string{"ksk-rollover", "zsk-rollover"},
+		},
+		"signers": map[string]interface{}{
+			"processes": []string{"add-signer"},
+			"max":       3,
+		},
+	})
+	defer viper.Set("signers.concurrency.groups", nil)
+
+	groups := processGroups()
+	if len(groups) != 2 {
+		t.Fatalf("processGroups() = %+v", groups)
+	}
+	if g := groups[0]; g.Name != "rollovers" || g.Max != 1 || !g.has("zsk-rollover") || g.has("add-signer") {
+		t.Errorf("group = %+v", g)
+	}
+	if g := groups[1]; g.Name != "signers" || g.Max != 3 || !g.has("add-signer") {
+		t.Errorf("group = %+v", g)
+	}
+}
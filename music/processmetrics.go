@@ -0,0 +1,195 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Custom process metrics. An FSM transition may report a measurement of its own via
+// Zone.EmitMetric, e.g. how long it took for the parent to publish the DS
+// ("ds_seen_at_parent_seconds"). The latest value of every metric (per zone and label
+// set) is exported on the metrics endpoint as music_process_<name>, and every value is
+// stored in process_metrics with the zone, process and state, where it is listed with
+// "zone metrics". A new process thus gets observability without any changes to the
+// metrics plumbing.
+
+type ProcessMetric struct {
+	Time    time.Time
+	Zone    string
+	Process string
+	State   string
+	Name    string
+	Value   float64
+	Labels  map[string]string
+}
+
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// the labels every process metric gets, which may not be given by the process
+var reservedMetricLabels = map[string]bool{"zone": true, "process": true}
+
+var processMetrics = struct {
+	sync.Mutex
+	latest map[string]ProcessMetric // key: see ProcessMetric.key
+}{latest: map[string]ProcessMetric{}}
+
+func (pm ProcessMetric) key() string {
+	var labels []string
+	for k, v := range pm.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return fmt.Sprintf("%s|%s|%s", pm.Name, pm.Zone, strings.Join(labels, ","))
+}
+
+// checkProcessMetric returns an error if the name or the labels of the metric can not
+// be exported.
+func checkProcessMetric(name string, labels map[string]string) error {
+	if !metricNameRE.MatchString(name) {
+		return fmt.Errorf("invalid metric name '%s'", name)
+	}
+	for k := range labels {
+		if !metricNameRE.MatchString(k) || strings.HasPrefix(k, "__") || reservedMetricLabels[k] {
+			return fmt.Errorf("metric %s: invalid label name '%s'", name, k)
+		}
+	}
+	return nil
+}
+
+// EmitMetric reports a custom metric for the zone in its current process and state.
+// Invalid metrics are logged and dropped; a process should never fail because of them.
+func (z *Zone) EmitMetric(name string, value float64, labels map[string]string) {
+	if err := checkProcessMetric(name, labels); err != nil {
+		log.Printf("EmitMetric: zone %s: %v. Ignored.", z.Name, err)
+		return
+	}
+	if z.Explaining() {
+		return // not a measurement of the process
+	}
+	pm := ProcessMetric{
+		Time:    time.Now(),
+		Zone:    z.Name,
+		Process: z.FSM,
+		State:   z.State,
+		Name:    name,
+		Value:   value,
+		Labels:  labels,
+	}
+	processMetrics.Lock()
+	processMetrics.latest[pm.key()] = pm
+	processMetrics.Unlock()
+
+	// transitions run while the engine holds its transaction open
+	if mdb := z.MusicDB; mdb != nil && mdb.UpdateC != nil {
+		buf, _ := json.Marshal(pm)
+		mdb.UpdateC <- DBUpdate{Type: "METRIC", Zone: z.Name, Key: name, Value: string(buf)}
+	}
+}
+
+// StateSeconds returns the number of seconds the zone has been in its current state.
+func (z *Zone) StateSeconds() float64 {
+	if z.Statestamp.IsZero() {
+		return 0
+	}
+	return time.Since(z.Statestamp).Seconds()
+}
+
+// RecordProcessMetric applies a queued "METRIC" update to the DB.
+func (mdb *MusicDB) RecordProcessMetric(tx *sql.Tx, u DBUpdate) error {
+	var pm ProcessMetric
+	if err := json.Unmarshal([]byte(u.Value), &pm); err != nil {
+		return fmt.Errorf("RecordProcessMetric: malformed update: %v", err)
+	}
+	labels, _ := json.Marshal(pm.Labels)
+	const sqlq = `
+INSERT INTO process_metrics(time, zone, process, state, name, value, labels) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := tx.Exec(sqlq, pm.Time.UTC().Format(layout), pm.Zone, pm.Process, pm.State, pm.Name,
+		pm.Value, string(labels))
+	return err
+}
+
+// ListProcessMetrics returns the latest (at most limit) metrics emitted for the zone,
+// newest first.
+func (mdb *MusicDB) ListProcessMetrics(tx *sql.Tx, zone string, limit int) ([]ProcessMetric, error) {
+	var pms []ProcessMetric
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListProcessMetrics: Error from mdb.StartTransaction(): %v\n", err)
+		return pms, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT time, zone, process, state, name, value, labels FROM process_metrics
+WHERE zone=? ORDER BY id DESC LIMIT ?`
+	rows, err := tx.Query(sqlq, zone, limit)
+	if CheckSQLError("ListProcessMetrics", sqlq, err, false) {
+		return pms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pm ProcessMetric
+		var t, labels string
+		if err := rows.Scan(&t, &pm.Zone, &pm.Process, &pm.State, &pm.Name, &pm.Value,
+			&labels); err != nil {
+			log.Fatalf("ListProcessMetrics: Error from rows.Scan(): %v", err)
+		}
+		pm.Time, _ = time.Parse(layout, t)
+		json.Unmarshal([]byte(labels), &pm.Labels)
+		pms = append(pms, pm)
+	}
+	return pms, nil
+}
+
+// FormatProcessMetrics returns the latest value of every process metric in the
+// Prometheus text exposition format.
+func FormatProcessMetrics() string {
+	processMetrics.Lock()
+	byname := map[string][]ProcessMetric{}
+	for _, pm := range processMetrics.latest {
+		byname[pm.Name] = append(byname[pm.Name], pm)
+	}
+	processMetrics.Unlock()
+
+	var names []string
+	for name := range byname {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, name := range names {
+		pms := byname[name]
+		sort.Slice(pms, func(i, j int) bool { return pms[i].key() < pms[j].key() })
+		metric := "music_process_" + name
+		fmt.Fprintf(&out, "# HELP %s Custom metric emitted by the zone processes.\n# TYPE %s gauge\n",
+			metric, metric)
+		for _, pm := range pms {
+			labels := []string{fmt.Sprintf(`zone="%s"`, promLabel(pm.Zone)),
+				fmt.Sprintf(`process="%s"`, promLabel(pm.Process))}
+			var keys []string
+			for k := range pm.Labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				labels = append(labels, fmt.Sprintf(`%s="%s"`, k, promLabel(pm.Labels[k])))
+			}
+			fmt.Fprintf(&out, "%s{%s} %g\n", metric, strings.Join(labels, ","), pm.Value)
+		}
+	}
+	return out.String()
+}
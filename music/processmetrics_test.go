@@ -0,0 +1,41 @@
+package music
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckProcessMetric(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		ok     bool
+	}{
+		{"ds_seen_at_parent_seconds", nil, true},
+		{"ds_seen_at_parent_seconds", map[string]string{"signer": "s1"}, true},
+		{"ds-seen", nil, false},
+		{"2fast", nil, false},
+		{"ds_seen", map[string]string{"zone": "x"}, false},
+		{"ds_seen", map[string]string{"__name": "x"}, false},
+	} {
+		if err := checkProcessMetric(tc.name, tc.labels); (err == nil) != tc.ok {
+			t.Errorf("checkProcessMetric(%s, %v) = %v, want ok=%v", tc.name, tc.labels, err, tc.ok)
+		}
+	}
+}
+
+func TestFormatProcessMetrics(t *testing.T) {
+	z := &Zone{Name: "example.se.", FSM: "add-signer", State: "cds-added"}
+	z.EmitMetric("test_seconds", 12.5, map[string]string{"signer": "s1"})
+	z.EmitMetric("test_seconds", 17, map[string]string{"signer": "s1"}) // replaces the first
+	z.EmitMetric("bad-name", 1, nil)
+
+	out := FormatProcessMetrics()
+	want := `music_process_test_seconds{zone="example.se.",process="add-signer",signer="s1"} 17`
+	if !strings.Contains(out, want+"\n") || strings.Contains(out, "12.5") {
+		t.Errorf("FormatProcessMetrics: got\n%s\nwanted the line %s", out, want)
+	}
+	if strings.Contains(out, "bad") {
+		t.Errorf("FormatProcessMetrics: invalid metric exported:\n%s", out)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Process parameters make it possible to use one process definition for several
+// operational variants (e.g. a different CDS TTL or no CSYNC). The parameters are
+// given when the process is started, validated against the Params of the process and
+// stored as zone metadata for as long as the zone remains in the process.
+
+// ValidateProcessParams checks params against the schema of the process and returns
+// the complete set of parameters, with defaults filled in.
+func ValidateProcessParams(process FSM, params map[string]string) (map[string]string, error) {
+	res := map[string]string{}
+	for name, value := range params {
+		p, exist := process.Params[name]
+		if !exist {
+			var known []string
+			for k := range process.Params {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("Process %s has no parameter '%s' (known parameters: %s).",
+				process.Name, name, strings.Join(known, ", "))
+		}
+		switch p.Type {
+		case "int":
+			if _, err := strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("Parameter '%s' must be an integer, not '%s'.", name, value)
+			}
+		case "bool":
+			if _, err := strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("Parameter '%s' must be a boolean, not '%s'.", name, value)
+			}
+		}
+		res[name] = value
+	}
+	for name, p := range process.Params {
+		if _, given := res[name]; !given && p.Default != "" {
+			res[name] = p.Default
+		}
+	}
+	return res, nil
+}
+
+// ZoneSetProcessParams stores the (validated) parameters of the current process of
+// the zone, replacing the parameters of any previous process.
+func (mdb *MusicDB) ZoneSetProcessParams(tx *sql.Tx, z *Zone, params map[string]string) error {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	_, err = mdb.ZoneSetMeta(tx, z, "process-params", string(buf))
+	return err
+}
+
+// ProcessParams returns the parameters of the current process of the zone.
+func (z *Zone) ProcessParams() map[string]string {
+	params := map[string]string{}
+	value, exist, err := z.MusicDB.GetMeta(nil, z, "process-params")
+	if err != nil || !exist || value == "" {
+		return params
+	}
+	if err = json.Unmarshal([]byte(value), &params); err != nil {
+		log.Printf("ProcessParams: zone %s: Error from Unmarshal: %v", z.Name, err)
+	}
+	return params
+}
+
+func (z *Zone) ProcessParam(name string) string {
+	return z.ProcessParams()[name]
+}
+
+// ProcessParamInt returns the value of an integer parameter, or false if not set.
+func (z *Zone) ProcessParamInt(name string) (int, bool) {
+	i, err := strconv.Atoi(z.ProcessParam(name))
+	return i, err == nil
+}
+
+func (z *Zone) ProcessParamBool(name string) bool {
+	b, _ := strconv.ParseBool(z.ProcessParam(name))
+	return b
+}
@@ -0,0 +1,38 @@
+package music
+
+import (
+	"testing"
+)
+
+func TestValidateProcessParams(t *testing.T) {
+	process := FSM{
+		Name: "test",
+		Params: map[string]FSMParam{
+			"ttl":  FSMParam{Type: "int"},
+			"skip": FSMParam{Type: "bool", Default: "false"},
+			"name": FSMParam{Type: "string"},
+		},
+	}
+
+	got, err := ValidateProcessParams(process, map[string]string{"ttl": "120"})
+	if err != nil {
+		t.Fatalf("ValidateProcessParams: unexpected error: %v", err)
+	}
+	if got["ttl"] != "120" || got["skip"] != "false" {
+		t.Errorf("ValidateProcessParams: got %v", got)
+	}
+	if _, exist := got["name"]; exist {
+		t.Errorf("ValidateProcessParams: parameter without default should not be set: %v", got)
+	}
+
+	bad := []map[string]string{
+		{"ttl": "long"},
+		{"skip": "maybe"},
+		{"unknown": "1"},
+	}
+	for _, params := range bad {
+		if got, err := ValidateProcessParams(process, params); err == nil {
+			t.Errorf("ValidateProcessParams(%v): got %v wanted error", params, got)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Propagation time. With signers.propagation.active set, every update made to a signer
+// is followed by polling the public nameservers of that signer for the zone (as recorded
+// in zone_nses; the signer itself if there are none) until the change is visible on all
+// of them. The time this takes is recorded per signer and zone, so that slow signers can
+// be identified and hold-down times tuned with real data.
+
+const propagationWindow = "-7 days" // statistics are computed over this period
+
+type PropagationStats struct {
+	Signer   string
+	Zone     string // empty when aggregated over all zones
+	Count    int
+	Timeouts int
+	Min      float64 // seconds
+	Median   float64
+	P90      float64
+	Max      float64
+	Sum      float64
+}
+
+// propagationCheck is the expected state of one RRset after an update.
+type propagationCheck struct {
+	owner   string
+	rrtype  uint16
+	present map[string]bool
+	absent  map[string]bool
+	gone    bool // the entire RRset was removed
+}
+
+func rrCompareKey(rr dns.RR) string {
+	rc := dns.Copy(rr)
+	rc.Header().Ttl = 0
+	return strings.ToLower(rc.String())
+}
+
+// propagationChecks returns the checks for an update. An update may change RRsets at
+// several owner names (see UpdateBatch), so the owner is taken from each RR, with fqdn
+// for RRs without one.
+func propagationChecks(fqdn string, inserts, removes [][]dns.RR, gone bool) []*propagationCheck {
+	type key struct {
+		owner  string
+		rrtype uint16
+	}
+	checks := map[key]*propagationCheck{}
+	get := func(rr dns.RR) *propagationCheck {
+		owner := rr.Header().Name
+		if owner == "" {
+			owner = fqdn
+		}
+		k := key{strings.ToLower(dns.Fqdn(owner)), rr.Header().Rrtype}
+		if c, ok := checks[k]; ok {
+			return c
+		}
+		c := &propagationCheck{owner: owner, rrtype: k.rrtype, present: map[string]bool{},
+			absent: map[string]bool{}}
+		checks[k] = c
+		return c
+	}
+	for _, rrset := range inserts {
+		for _, rr := range rrset {
+			get(rr).present[rrCompareKey(rr)] = true
+		}
+	}
+	for _, rrset := range removes {
+		for _, rr := range rrset {
+			c := get(rr)
+			if gone {
+				c.gone = true
+			} else {
+				c.absent[rrCompareKey(rr)] = true
+			}
+		}
+	}
+
+	var res []*propagationCheck
+	for _, c := range checks {
+		res = append(res, c)
+	}
+	return res
+}
+
+func (pc *propagationCheck) visibleAt(addr string) bool {
+	m := new(dns.Msg)
+	m.SetQuestion(pc.owner, pc.rrtype)
+	m.RecursionDesired = false
+	r, _, err := DnsExchange(new(dns.Client), m, addr)
+	if err != nil || r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return false
+	}
+	return pc.matches(r.Answer)
+}
+
+// matches returns true if rrs (an answer or a fetched RRset) is in the expected state.
+func (pc *propagationCheck) matches(rrs []dns.RR) bool {
+	seen := map[string]bool{}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == pc.rrtype {
+			seen[rrCompareKey(rr)] = true
+		}
+	}
+	if pc.gone && len(seen) > 0 {
+		return false
+	}
+	for k := range pc.present {
+		if !seen[k] {
+			return false
+		}
+	}
+	for k := range pc.absent {
+		if seen[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// publicAddresses returns the addresses of the nameservers of signer for zone.
+func (mdb *MusicDB) publicAddresses(signer *Signer, zone string) []string {
+	var addrs []string
+
+	const sqlq = "SELECT ns FROM zone_nses WHERE zone=? AND signer=?"
+	rows, err := mdb.db.Query(sqlq, zone, signer.Name)
+	if CheckSQLError("publicAddresses", sqlq, err, false) {
+		return addrs
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ns string
+		if err := rows.Scan(&ns); err != nil {
+			log.Fatalf("publicAddresses: Error from rows.Scan(): %v", err)
+		}
+		ips, err := net.LookupHost(strings.TrimSuffix(ns, "."))
+		if err != nil {
+			log.Printf("Propagation: zone %s: cannot resolve nameserver %s: %v", zone, ns, err)
+			continue
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, "53"))
+		}
+	}
+	if len(addrs) == 0 && signer.Address != "" {
+		addrs = append(addrs, signer.DnsAddress())
+	}
+	return addrs
+}
+
+func (mdb *MusicDB) measurePropagation(signer *Signer, zone string, checks []*propagationCheck,
+	start time.Time) {
+	timeout := viper.GetInt("signers.propagation.timeout")
+	if timeout <= 0 {
+		timeout = 600
+	}
+	deadline := start.Add(time.Duration(timeout) * time.Second)
+
+	addrs := mdb.publicAddresses(signer, zone)
+	if len(addrs) == 0 {
+		return
+	}
+
+	timedout := false
+	for pending := addrs; len(pending) > 0; {
+		var left []string
+		for _, addr := range pending {
+			for _, c := range checks {
+				if !c.visibleAt(addr) {
+					left = append(left, addr)
+					break
+				}
+			}
+		}
+		pending = left
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			timedout = true
+			log.Printf("Propagation: signer %s zone %s: change not visible at %s after %d seconds",
+				signer.Name, zone, strings.Join(pending, ", "), timeout)
+			break
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	seconds := time.Since(start).Seconds()
+	const sqlq = `
+INSERT INTO propagation_times(signer, zone, owner, rrtype, started, seconds, timedout)
+VALUES (?, ?, ?, ?, ?, ?, ?)`
+	for _, c := range checks {
+		_, err := mdb.db.Exec(sqlq, signer.Name, zone, c.owner, dns.TypeToString[c.rrtype],
+			start.UTC().Format("2006-01-02 15:04:05"), seconds, timedout)
+		CheckSQLError("measurePropagation", sqlq, err, false)
+	}
+}
+
+// ListPropagationStats returns propagation time statistics per signer (and per zone if
+// perzone is true) over the last week.
+func (mdb *MusicDB) ListPropagationStats(tx *sql.Tx, zone string, perzone bool) ([]PropagationStats, error) {
+	var stats []PropagationStats
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListPropagationStats: Error from mdb.StartTransaction(): %v\n", err)
+		return stats, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT signer, zone, seconds, timedout FROM propagation_times
+WHERE started > datetime('now', ?) AND (? = '' OR zone = ?) ORDER BY signer, zone, seconds`
+	rows, err := tx.Query(sqlq, propagationWindow, zone, zone)
+	if CheckSQLError("ListPropagationStats", sqlq, err, false) {
+		return stats, err
+	}
+	defer rows.Close()
+
+	samples := map[[2]string][]float64{}
+	timeouts := map[[2]string]int{}
+	for rows.Next() {
+		var signer, z string
+		var seconds float64
+		var timedout bool
+		if err := rows.Scan(&signer, &z, &seconds, &timedout); err != nil {
+			log.Fatalf("ListPropagationStats: Error from rows.Scan(): %v", err)
+		}
+		if !perzone {
+			z = ""
+		}
+		key := [2]string{signer, z}
+		if timedout {
+			timeouts[key]++
+			continue
+		}
+		samples[key] = append(samples[key], seconds)
+		if _, ok := timeouts[key]; !ok {
+			timeouts[key] = 0
+		}
+	}
+
+	for key, n := range timeouts {
+		ps := PropagationStats{Signer: key[0], Zone: key[1], Timeouts: n}
+		s := samples[key]
+		sort.Float64s(s)
+		if len(s) > 0 {
+			ps.Count = len(s)
+			ps.Min, ps.Max = s[0], s[len(s)-1]
+			ps.Median = s[len(s)/2]
+			ps.P90 = s[len(s)*9/10]
+			for _, v := range s {
+				ps.Sum += v
+			}
+		}
+		stats = append(stats, ps)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Signer != stats[j].Signer {
+			return stats[i].Signer < stats[j].Signer
+		}
+		return stats[i].Zone < stats[j].Zone
+	})
+	return stats, nil
+}
+
+// FormatPropagationMetrics returns the per-signer statistics as a Prometheus summary.
+func FormatPropagationMetrics(stats []PropagationStats) string {
+	var out strings.Builder
+	const name = "music_signer_propagation_seconds"
+	fmt.Fprintf(&out, "# HELP %s Time until an update is visible on all public nameservers of the signer.\n", name)
+	fmt.Fprintf(&out, "# TYPE %s summary\n", name)
+	for _, ps := range stats {
+		sl := fmt.Sprintf(`signer="%s"`, promLabel(ps.Signer))
+		fmt.Fprintf(&out, "%s{%s,quantile=\"0.5\"} %g\n", name, sl, ps.Median)
+		fmt.Fprintf(&out, "%s{%s,quantile=\"0.9\"} %g\n", name, sl, ps.P90)
+		fmt.Fprintf(&out, "%s_sum{%s} %g\n", name, sl, ps.Sum)
+		fmt.Fprintf(&out, "%s_count{%s} %d\n", name, sl, ps.Count)
+	}
+	fmt.Fprintf(&out, "# HELP music_signer_propagation_timeouts Updates not visible on all public nameservers in time.\n")
+	fmt.Fprintf(&out, "# TYPE music_signer_propagation_timeouts gauge\n")
+	for _, ps := range stats {
+		fmt.Fprintf(&out, "music_signer_propagation_timeouts{signer=\"%s\"} %d\n",
+			promLabel(ps.Signer), ps.Timeouts)
+	}
+	return out.String()
+}
+
+// PropagationUpdater wraps an updater and measures the propagation time of every
+// successful update.
+type PropagationUpdater struct {
+	Updater
+}
+
+func (u *PropagationUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	start := time.Now()
+	err := u.Updater.Update(signer, zone, fqdn, inserts, removes)
+	if err == nil && viper.GetBool("signers.propagation.active") && signer.MusicDB() != nil {
+		var ins, rem [][]dns.RR
+		if inserts != nil {
+			ins = *inserts
+		}
+		if removes != nil {
+			rem = *removes
+		}
+		if checks := propagationChecks(fqdn, ins, rem, false); len(checks) > 0 {
+			go signer.MusicDB().measurePropagation(signer, zone, checks, start)
+		}
+	}
+	return err
+}
+
+func (u *PropagationUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	start := time.Now()
+	err := u.Updater.RemoveRRset(signer, zone, fqdn, rrsets)
+	if err == nil && viper.GetBool("signers.propagation.active") && signer.MusicDB() != nil {
+		if checks := propagationChecks(fqdn, nil, rrsets, true); len(checks) > 0 {
+			go signer.MusicDB().measurePropagation(signer, zone, checks, start)
+		}
+	}
+	return err
+}
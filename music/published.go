@@ -0,0 +1,242 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Published records. The CDS, CDNSKEY and CSYNC records that MUSIC publishes at the
+// signers are only meant to exist while a process is running. If the process is
+// aborted, or the zone or signer is removed, they may be left behind. Every such record
+// is therefore tracked per zone and signer, and CleanupPublished removes the ones that
+// are orphaned: the zone is not in a process (or no longer exists) or the signer is no
+// longer part of the signer group of the zone. Only the exact records that MUSIC
+// published are removed, and only if the signer still serves them.
+
+var publishedRRtypes = map[uint16]bool{
+	dns.TypeCDS:     true,
+	dns.TypeCDNSKEY: true,
+	dns.TypeCSYNC:   true,
+}
+
+type PublishedRecord struct {
+	Zone      string
+	Signer    string
+	RR        string
+	Published string
+}
+
+// notePublished queues the tracking of an update (op is "add", "remove" or
+// "remove-rrset"). Updates are made while the engine holds its transaction open, so
+// the DB is updated by the dbUpdater (see RecordPublished).
+func notePublished(signer *Signer, zone, op string, rrsets [][]dns.RR) {
+	mdb := signer.MusicDB()
+	if mdb == nil || mdb.UpdateC == nil {
+		return
+	}
+	for _, rrset := range rrsets {
+		for _, rr := range rrset {
+			if !publishedRRtypes[rr.Header().Rrtype] {
+				continue
+			}
+			mdb.UpdateC <- DBUpdate{
+				Type:  "PUBLISHED",
+				Zone:  zone,
+				Key:   signer.Name,
+				Value: op + " " + rr.String(),
+			}
+		}
+	}
+}
+
+// RecordPublished applies a queued "PUBLISHED" update to the DB.
+func (mdb *MusicDB) RecordPublished(tx *sql.Tx, u DBUpdate) error {
+	parts := strings.SplitN(u.Value, " ", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("RecordPublished: malformed update: %s", u.Value)
+	}
+	rr, err := dns.NewRR(parts[1])
+	if err != nil || rr == nil {
+		return fmt.Errorf("RecordPublished: unable to parse '%s': %v", parts[1], err)
+	}
+	owner := strings.ToLower(rr.Header().Name)
+	rrtype := dns.TypeToString[rr.Header().Rrtype]
+
+	switch parts[0] {
+	case "add":
+		const sqlq = `
+INSERT OR IGNORE INTO published_records(zone, signer, owner, rrtype, rr, published)
+VALUES (?, ?, ?, ?, ?, datetime('now'))`
+		_, err = tx.Exec(sqlq, u.Zone, u.Key, owner, rrtype, rrCompareKey(rr))
+	case "remove":
+		const sqlq = "DELETE FROM published_records WHERE zone=? AND signer=? AND rr=?"
+		_, err = tx.Exec(sqlq, u.Zone, u.Key, rrCompareKey(rr))
+	case "remove-rrset":
+		const sqlq = "DELETE FROM published_records WHERE zone=? AND signer=? AND owner=? AND rrtype=?"
+		_, err = tx.Exec(sqlq, u.Zone, u.Key, owner, rrtype)
+	default:
+		return fmt.Errorf("RecordPublished: unknown operation: %s", parts[0])
+	}
+	return err
+}
+
+// PublishedUpdater wraps an updater and tracks the CDS, CDNSKEY and CSYNC records it
+// publishes and removes.
+type PublishedUpdater struct {
+	Updater
+}
+
+func (u *PublishedUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	err := u.Updater.Update(signer, zone, fqdn, inserts, removes)
+	if err == nil {
+		if removes != nil {
+			notePublished(signer, zone, "remove", *removes)
+		}
+		if inserts != nil {
+			notePublished(signer, zone, "add", *inserts)
+		}
+	}
+	return err
+}
+
+func (u *PublishedUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	err := u.Updater.RemoveRRset(signer, zone, fqdn, rrsets)
+	if err == nil {
+		notePublished(signer, zone, "remove-rrset", rrsets)
+	}
+	return err
+}
+
+func (mdb *MusicDB) ListPublished(tx *sql.Tx, zone string) ([]PublishedRecord, error) {
+	var prs []PublishedRecord
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListPublished: Error from mdb.StartTransaction(): %v\n", err)
+		return prs, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	sqlq := "SELECT zone, signer, rr, COALESCE(published, '') FROM published_records"
+	var args []interface{}
+	if zone != "" {
+		sqlq += " WHERE zone=?"
+		args = append(args, zone)
+	}
+	sqlq += " ORDER BY zone, signer, id"
+
+	rows, err := tx.Query(sqlq, args...)
+	if CheckSQLError("ListPublished", sqlq, err, false) {
+		return prs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pr PublishedRecord
+		if err := rows.Scan(&pr.Zone, &pr.Signer, &pr.RR, &pr.Published); err != nil {
+			log.Fatalf("ListPublished: Error from rows.Scan(): %v", err)
+		}
+		prs = append(prs, pr)
+	}
+	return prs, nil
+}
+
+func (mdb *MusicDB) forgetPublished(zone, signer, rr string) error {
+	const sqlq = "DELETE FROM published_records WHERE zone=? AND signer=? AND rr=?"
+	_, err := mdb.Exec(sqlq, zone, signer, rr)
+	CheckSQLError("forgetPublished", sqlq, err, false)
+	return err
+}
+
+// orphanReason returns why the records published by signer for the zone are orphaned,
+// or "" if they are still needed.
+func orphanReason(z *Zone, signer string) string {
+	switch {
+	case !z.Exists:
+		return "zone removed"
+	case z.FSM == "" || z.FSM == "---":
+		return "zone not in a process"
+	case z.SGroup == nil || z.SGroup.SignerMap[signer] == nil:
+		return "signer not in signer group"
+	}
+	return ""
+}
+
+// CleanupPublished removes the orphaned records that MUSIC published for the zone (or
+// for all zones if zone is ""). The returned messages describe what was done.
+func (mdb *MusicDB) CleanupPublished(zone string) ([]string, error) {
+	var msgs []string
+
+	prs, err := mdb.ListPublished(nil, zone)
+	if err != nil {
+		return msgs, err
+	}
+
+	zones := map[string]*Zone{}
+	for _, pr := range prs {
+		z, ok := zones[pr.Zone]
+		if !ok {
+			z, _, err = mdb.GetZone(nil, pr.Zone)
+			if err != nil {
+				return msgs, err
+			}
+			zones[pr.Zone] = z
+		}
+		reason := orphanReason(z, pr.Signer)
+		if reason == "" {
+			continue
+		}
+
+		rr, err := dns.NewRR(pr.RR)
+		if err != nil || rr == nil {
+			log.Printf("CleanupPublished: unable to parse '%s': %v", pr.RR, err)
+			continue
+		}
+		signer, err := mdb.GetSigner(nil, &Signer{Name: pr.Signer}, false) // not apisafe
+		if err != nil || !signer.Exists {
+			// nothing can be done about records at a signer that is gone
+			mdb.forgetPublished(pr.Zone, pr.Signer, pr.RR)
+			msgs = append(msgs, fmt.Sprintf("%s: signer %s no longer exists, forgetting: %s",
+				pr.Zone, pr.Signer, pr.RR))
+			continue
+		}
+
+		updater := GetUpdater(signer.Method)
+		err, rrs := updater.FetchRRset(signer, pr.Zone, rr.Header().Name, rr.Header().Rrtype)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: signer %s: %v", pr.Zone, pr.Signer, err))
+			continue
+		}
+		var present []dns.RR
+		for _, r := range rrs {
+			if rrCompareKey(r) == pr.RR {
+				present = append(present, r)
+			}
+		}
+		if len(present) == 0 {
+			mdb.forgetPublished(pr.Zone, pr.Signer, pr.RR)
+			msgs = append(msgs, fmt.Sprintf("%s: signer %s no longer serves: %s", pr.Zone,
+				pr.Signer, pr.RR))
+			continue
+		}
+
+		if err := updater.Update(signer, pr.Zone, rr.Header().Name, &[][]dns.RR{},
+			&[][]dns.RR{present}); err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: signer %s: unable to remove %s: %v", pr.Zone,
+				pr.Signer, pr.RR, err))
+			continue
+		}
+		mdb.forgetPublished(pr.Zone, pr.Signer, pr.RR)
+		msgs = append(msgs, fmt.Sprintf("%s: signer %s: removed (%s): %s", pr.Zone, pr.Signer,
+			reason, pr.RR))
+	}
+	return msgs, nil
+}
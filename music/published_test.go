@@ -0,0 +1,37 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPublishedRecordKey(t *testing.T) {
+	a, _ := dns.NewRR("Example.COM. 3600 IN CDS 12345 13 2 ABCDEF")
+	b, _ := dns.NewRR("example.com. 0 IN CDS 12345 13 2 abcdef")
+	if rrCompareKey(a) != rrCompareKey(b) {
+		t.Errorf("rrCompareKey: %q != %q", rrCompareKey(a), rrCompareKey(b))
+	}
+	if a.Header().Ttl != 3600 {
+		t.Errorf("rrCompareKey modified the RR")
+	}
+}
+
+func TestOrphanReason(t *testing.T) {
+	sg := &SignerGroup{SignerMap: map[string]*Signer{"s1": {Name: "s1"}}}
+	tests := []struct {
+		z      Zone
+		signer string
+		want   string
+	}{
+		{Zone{Exists: false}, "s1", "zone removed"},
+		{Zone{Exists: true, FSM: "", SGroup: sg}, "s1", "zone not in a process"},
+		{Zone{Exists: true, FSM: "add-signer", SGroup: sg}, "s2", "signer not in signer group"},
+		{Zone{Exists: true, FSM: "add-signer", SGroup: sg}, "s1", ""},
+	}
+	for _, tt := range tests {
+		if got := orphanReason(&tt.z, tt.signer); got != tt.want {
+			t.Errorf("orphanReason(%+v, %s) = %q, want %q", tt.z, tt.signer, got, tt.want)
+		}
+	}
+}
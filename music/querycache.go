@@ -0,0 +1,158 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Query cache for one engine cycle. Within a cycle the preconditions for a zone tend
+// to fetch the same DNSKEY and NS RRsets from the same signers several times. While a
+// cycle is running, fetched RRsets are cached, keyed by (signer, owner, rrtype), and
+// the cache is emptied when the cycle ends. Any update or removal at a signer drops
+// the cached RRsets of that owner at that signer, so that post-conditions always see
+// the result of the action. The TTLs of the RRsets fetched are also passed on to the
+// pre-condition cache (precondcache.go).
+
+var queryCache = struct {
+	sync.Mutex
+	cycles  int
+	entries map[string][]dns.RR
+	hits    int
+	misses  int
+}{}
+
+// BeginQueryCycle enables the query cache. The returned function ends the cycle; when
+// the last running cycle ends the cache is emptied.
+func BeginQueryCycle() func() {
+	queryCache.Lock()
+	defer queryCache.Unlock()
+
+	queryCache.cycles++
+	if queryCache.entries == nil {
+		queryCache.entries = map[string][]dns.RR{}
+	}
+
+	return func() {
+		queryCache.Lock()
+		defer queryCache.Unlock()
+
+		queryCache.cycles--
+		if queryCache.cycles > 0 {
+			return
+		}
+		if viper.GetBool("common.debug") && queryCache.hits+queryCache.misses > 0 {
+			log.Printf("Query cache: %d hits, %d misses this cycle", queryCache.hits,
+				queryCache.misses)
+		}
+		queryCache.entries = nil
+		queryCache.hits, queryCache.misses = 0, 0
+	}
+}
+
+func queryCacheKey(signer, owner string, rrtype uint16) string {
+	return fmt.Sprintf("%s|%s|%d", signer, strings.ToLower(dns.Fqdn(owner)), rrtype)
+}
+
+func copyRRs(rrs []dns.RR) []dns.RR {
+	res := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		res[i] = dns.Copy(rr)
+	}
+	return res
+}
+
+func queryCacheInvalidate(signer, owner string) {
+	queryCache.Lock()
+	defer queryCache.Unlock()
+
+	prefix := fmt.Sprintf("%s|%s|", signer, strings.ToLower(dns.Fqdn(owner)))
+	for k := range queryCache.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(queryCache.entries, k)
+		}
+	}
+}
+
+// QueryCacheUpdater wraps an updater and serves repeated fetches from the cycle cache.
+type QueryCacheUpdater struct {
+	Updater
+}
+
+func (u *QueryCacheUpdater) FetchRRset(signer *Signer, zone, fqdn string, rrtype uint16) (error, []dns.RR) {
+	key := queryCacheKey(signer.Name, fqdn, rrtype)
+
+	queryCache.Lock()
+	if queryCache.entries == nil {
+		queryCache.Unlock()
+		err, rrs := u.Updater.FetchRRset(signer, zone, fqdn, rrtype)
+		if err == nil {
+			observeRRset(signer.Name, zone, rrs)
+		}
+		return err, rrs
+	}
+	if rrs, ok := queryCache.entries[key]; ok {
+		queryCache.hits++
+		queryCache.Unlock()
+		observeRRset(signer.Name, zone, rrs)
+		return nil, copyRRs(rrs)
+	}
+	queryCache.misses++
+	queryCache.Unlock()
+
+	err, rrs := u.Updater.FetchRRset(signer, zone, fqdn, rrtype)
+	if err != nil {
+		return err, rrs
+	}
+	observeRRset(signer.Name, zone, rrs)
+
+	queryCache.Lock()
+	if queryCache.entries != nil {
+		queryCache.entries[key] = copyRRs(rrs)
+	}
+	queryCache.Unlock()
+	return nil, rrs
+}
+
+func (u *QueryCacheUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	var rrsets [][]dns.RR
+	if inserts != nil {
+		rrsets = append(rrsets, *inserts...)
+	}
+	if removes != nil {
+		rrsets = append(rrsets, *removes...)
+	}
+	defer queryCacheInvalidateAll(signer.Name, fqdn, rrsets)
+	defer forgetPreconditions(zone)
+	return u.Updater.Update(signer, zone, fqdn, inserts, removes)
+}
+
+func (u *QueryCacheUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	defer queryCacheInvalidateAll(signer.Name, fqdn, rrsets)
+	defer forgetPreconditions(zone)
+	return u.Updater.RemoveRRset(signer, zone, fqdn, rrsets)
+}
+
+// queryCacheInvalidateAll invalidates fqdn and the owners of the RRs in rrsets, which
+// differ from fqdn for batched updates.
+func queryCacheInvalidateAll(signer, fqdn string, rrsets [][]dns.RR) {
+	queryCacheInvalidate(signer, fqdn)
+	done := map[string]bool{strings.ToLower(dns.Fqdn(fqdn)): true}
+	for _, rrset := range rrsets {
+		for _, rr := range rrset {
+			owner := strings.ToLower(dns.Fqdn(rr.Header().Name))
+			if !done[owner] {
+				done[owner] = true
+				queryCacheInvalidate(signer, owner)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Query log. With querylog.active set, every DNS message musicd sends (queries to
+// signers, parents and resolvers as well as dynamic updates) is logged together with
+// the response, one JSON object per line, to querylog.file. This gives security teams
+// an exact record of what the daemon did, e.g. during a migration. The file is not
+// rotated by musicd; ReopenQueryLog lets go of it after an external rotation.
+
+type QueryLogEntry struct {
+	Time    time.Time `json:"time"`
+	Server  string    `json:"server"`
+	Proto   string    `json:"proto"`
+	Opcode  string    `json:"opcode"`
+	Qname   string    `json:"qname"`
+	Qtype   string    `json:"qtype"`
+	Update  []string  `json:"update,omitempty"` // RRs in the update section
+	TsigKey string    `json:"tsigkey,omitempty"`
+	Rcode   string    `json:"rcode,omitempty"`
+	Answer  []string  `json:"answer,omitempty"`
+	RttMs   float64   `json:"rtt_ms"`
+	Error   string    `json:"error,omitempty"`
+}
+
+var queryLog struct {
+	once sync.Once
+	mu   sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+}
+
+func queryLogger() *json.Encoder {
+	queryLog.once.Do(func() {
+		if !viper.GetBool("querylog.active") {
+			return
+		}
+		file := viper.GetString("querylog.file")
+		if file == "" {
+			log.Printf("Query log: querylog.file not set. Query log disabled.")
+			return
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			log.Printf("Query log: cannot open %s: %v. Query log disabled.", file, err)
+			return
+		}
+		queryLog.f = f
+		queryLog.enc = json.NewEncoder(f)
+	})
+	return queryLog.enc
+}
+
+// ReopenQueryLog reopens querylog.file, if the query log is active.
+func ReopenQueryLog() {
+	if queryLogger() == nil {
+		return
+	}
+	queryLog.mu.Lock()
+	defer queryLog.mu.Unlock()
+	file := queryLog.f.Name()
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("Query log: cannot reopen %s: %v. Still writing to the old file.", file, err)
+		return
+	}
+	queryLog.f.Close()
+	queryLog.f = f
+	queryLog.enc = json.NewEncoder(f)
+}
+
+func logQuery(proto, server string, m, r *dns.Msg, rtt time.Duration, err error) {
+	recordDNSRequest(server, m, r, rtt, err)
+
+	if queryLogger() == nil {
+		return
+	}
+
+	if proto == "" {
+		proto = "udp"
+	}
+	e := QueryLogEntry{
+		Time:   time.Now().UTC(),
+		Server: server,
+		Proto:  proto,
+		Opcode: dns.OpcodeToString[m.Opcode],
+		RttMs:  float64(rtt.Microseconds()) / 1000,
+	}
+	if len(m.Question) > 0 {
+		e.Qname = m.Question[0].Name
+		e.Qtype = dns.TypeToString[m.Question[0].Qtype]
+	}
+	if m.Opcode == dns.OpcodeUpdate {
+		for _, rr := range m.Ns {
+			e.Update = append(e.Update, rr.String())
+		}
+	}
+	if t := m.IsTsig(); t != nil {
+		e.TsigKey = t.Hdr.Name
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if r != nil {
+		e.Rcode = dns.RcodeToString[r.Rcode]
+		for _, rr := range r.Answer {
+			e.Answer = append(e.Answer, rr.String())
+		}
+	}
+
+	queryLog.mu.Lock()
+	defer queryLog.mu.Unlock()
+	if err := queryLog.enc.Encode(e); err != nil {
+		log.Printf("Query log: write error: %v", err)
+	}
+}
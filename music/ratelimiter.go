@@ -0,0 +1,251 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Rate limiting of the requests to the signers. Every backend that has limits (ddns,
+// desec, route53, ...) gets a RateLimiter with a token bucket per signer and class of
+// operation. The rate (requests per second) of a class is read from
+// signers.<backend>.limits.<class>, for the classes below. The older settings
+// limits.fetch (for read) and limits.update (for write-rrset and write-domain) are
+// used if the class is not set. If neither is set, all classes share one bucket with the
+// rate limits.rate (Route 53: 5 if unset). limits.burst (default 1) is the number of
+// requests that may be sent at once after an idle period. A rate of 0 means no limit.
+//
+// A backend that is told by the signer to back off (e.g. a 429 from deSEC) calls Hold,
+// after which no request of that class is sent to the signer until the hold is over.
+//
+// The rates are for all musicd instances sharing the DB together; with sharding each
+// instance uses its share (see sharding.go).
+
+const (
+	OpRead        = "read"         // fetch an RRset or other data
+	OpWriteRRset  = "write-rrset"  // update RRsets
+	OpWriteDomain = "write-domain" // create or delete a domain
+)
+
+// the setting used for a class that has no setting of its own
+var legacyLimitKeys = map[string]string{
+	OpRead:        "fetch",
+	OpWriteRRset:  "update",
+	OpWriteDomain: "update",
+}
+
+// limits.rate of the backends that must be limited even if nothing is configured
+var defaultRates = map[string]float64{
+	"route53": 5, // AWS allows 5 requests per second and account
+}
+
+type tokenBucket struct {
+	rate   float64 // tokens per second, 0 = unlimited
+	burst  float64
+	tokens float64
+	last   time.Time // of the latest refill; in the future during a hold
+}
+
+// reserve takes a token from the bucket and returns how long to wait before it may be
+// used.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	var wait time.Duration
+	if b.last.After(now) {
+		wait = b.last.Sub(now) // held
+	}
+	if b.rate <= 0 {
+		return wait
+	}
+	b.refill(now)
+	b.tokens--
+	if b.tokens < 0 {
+		wait += time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	return wait
+}
+
+// refill adds the tokens earned since the latest refill.
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// wait returns how long until the bucket has a token, without taking it.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// hold keeps the bucket from handing out tokens for d.
+func (b *tokenBucket) hold(now time.Time, d time.Duration) {
+	b.refill(now)
+	if until := now.Add(d); until.After(b.last) {
+		b.last = until
+	}
+	b.tokens = math.Min(b.tokens, 1) // one request when the hold is over
+}
+
+type RateLimiter struct {
+	Backend string // the section under signers in the config
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket // key: signer|class, or signer|class|throttling class
+}
+
+var rateLimiters = struct {
+	sync.Mutex
+	m map[string]*RateLimiter
+}{m: map[string]*RateLimiter{}}
+
+// GetRateLimiter returns the rate limiter of the backend.
+func GetRateLimiter(backend string) *RateLimiter {
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+	rl, exist := rateLimiters.m[backend]
+	if !exist {
+		rl = &RateLimiter{Backend: backend, buckets: map[string]*tokenBucket{}}
+		rateLimiters.m[backend] = rl
+	}
+	return rl
+}
+
+// RateLimitConfig returns the rate (requests per second) and burst of the class of
+// operations to the signers of backend, and the bucket the class uses: the class itself,
+// or "" if all classes share the limits.rate bucket.
+func RateLimitConfig(backend, class string) (rate, burst float64, bucket string) {
+	prefix := "signers." + backend + ".limits."
+	bucket = class
+	switch {
+	case viper.IsSet(prefix + class):
+		rate = viper.GetFloat64(prefix + class)
+	case legacyLimitKeys[class] != "" && viper.IsSet(prefix+legacyLimitKeys[class]):
+		rate = viper.GetFloat64(prefix + legacyLimitKeys[class])
+	default:
+		rate = viper.GetFloat64(prefix + "rate")
+		if rate <= 0 {
+			rate = defaultRates[backend]
+		}
+		bucket = ""
+	}
+	burst = viper.GetFloat64(prefix + "burst")
+	if burst < 1 {
+		burst = 1
+	}
+	return math.Max(rate, 0), burst, bucket
+}
+
+// rateLimit returns the rate of the class of operations to the signers of backend that
+// this instance may use.
+func rateLimit(backend, class string) float64 {
+	rate, _, _ := RateLimitConfig(backend, class)
+	return shardShareRate(rate)
+}
+
+func (rl *RateLimiter) bucket(signer, class string) *tokenBucket {
+	rate, burst, name := RateLimitConfig(rl.Backend, class)
+	rate = shardShareRate(rate)
+	key := strings.ToLower(signer) + "|" + name
+	b, exist := rl.buckets[key]
+	if !exist {
+		b = &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+		rl.buckets[key] = b
+	}
+	b.rate = rate // the share changes as instances come and go
+	return b
+}
+
+// Reserve returns how long the caller must wait before it sends a request of the class
+// to the signer. The request is counted at once, so a caller that does not wait uses up
+// the budget of others.
+func (rl *RateLimiter) Reserve(signer, class string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.bucket(signer, class).reserve(time.Now())
+}
+
+// Wait blocks until a request of the class may be sent to the signer.
+func (rl *RateLimiter) Wait(signer, class string) {
+	if wait := rl.Reserve(signer, class); wait > 0 {
+		if wait > time.Second {
+			log.Printf("RateLimiter %s: %s request to %s delayed %v", rl.Backend, class, signer,
+				wait.Round(time.Millisecond))
+		}
+		time.Sleep(wait)
+	}
+}
+
+// throttleBucket returns the bucket of the share of the signer's rate for the class that
+// the zones in the throttling class may use, or nil if they may use all of it.
+func (rl *RateLimiter) throttleBucket(signer, class, throttle string) *tokenBucket {
+	rate, _, name := RateLimitConfig(rl.Backend, class)
+	share := throttleShare(throttle)
+	if rate <= 0 || share >= 1 {
+		return nil
+	}
+	rate = shardShareRate(rate)
+	key := strings.ToLower(signer) + "|" + name + "|" + strings.ToLower(throttle)
+	b, exist := rl.buckets[key]
+	if !exist {
+		b = &tokenBucket{rate: rate * share, burst: 1, tokens: 1, last: time.Now()}
+		rl.buckets[key] = b
+	}
+	b.rate = rate * share
+	return b
+}
+
+// ThrottleWait returns how long an op of a zone in the throttling class must wait before
+// the class may send another request of the class of operation to the signer. Nothing is
+// taken from the budget of the throttling class, see TakeThrottle.
+func (rl *RateLimiter) ThrottleWait(signer, class, throttle string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if b := rl.throttleBucket(signer, class, throttle); b != nil {
+		return b.wait(time.Now())
+	}
+	return 0
+}
+
+// TakeThrottle counts a request of the class of operation to the signer against the
+// budget of the throttling class.
+func (rl *RateLimiter) TakeThrottle(signer, class, throttle string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if b := rl.throttleBucket(signer, class, throttle); b != nil {
+		b.reserve(time.Now())
+	}
+}
+
+// Hold stops all requests of the class to the signer for secs seconds.
+func (rl *RateLimiter) Hold(signer, class string, secs int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.bucket(signer, class).hold(time.Now(), time.Duration(secs)*time.Second)
+}
+
+// CheckRateLimits returns an error if no rate is configured for a class that the
+// backend must limit.
+func CheckRateLimits(backend string, classes ...string) error {
+	for _, class := range classes {
+		if rate, _, _ := RateLimitConfig(backend, class); rate <= 0 {
+			return fmt.Errorf("signers.%s.limits.%s (or limits.%s) must be defined and > 0",
+				backend, class, legacyLimitKeys[class])
+		}
+	}
+	return nil
+}
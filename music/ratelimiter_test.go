@@ -0,0 +1,89 @@
+package music
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{rate: 2, burst: 2, tokens: 2, last: now}
+	for i, want := range []time.Duration{0, 0, 500 * time.Millisecond, time.Second} {
+		if got := b.reserve(now); got != want {
+			t.Errorf("reserve %d: got %v wanted %v", i, got, want)
+		}
+	}
+
+	now = now.Add(10 * time.Second) // refilled to the burst
+	b.hold(now, 3*time.Second)
+	if got := b.reserve(now); got != 3*time.Second {
+		t.Errorf("reserve during hold: got %v wanted 3s", got)
+	}
+	if got := b.reserve(now.Add(3 * time.Second)); got != 500*time.Millisecond {
+		t.Errorf("reserve after hold: got %v wanted 500ms", got)
+	}
+
+	unlimited := &tokenBucket{last: now}
+	if got := unlimited.reserve(now); got != 0 {
+		t.Errorf("reserve without limit: got %v wanted 0", got)
+	}
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	viper.Set("signers.rltest.limits.update", 2)
+	viper.Set("signers.rltest.limits.read", 5)
+	defer viper.Set("signers.rltest", nil)
+
+	for _, tc := range []struct {
+		backend, class string
+		rate           float64
+		bucket         string
+	}{
+		{"rltest", OpRead, 5, OpRead},
+		{"rltest", OpWriteRRset, 2, OpWriteRRset}, // legacy setting
+		{"rltest", OpWriteDomain, 2, OpWriteDomain},
+		{"route53", OpRead, 5, ""}, // default, one shared bucket
+		{"nolimits", OpRead, 0, ""},
+	} {
+		rate, burst, bucket := RateLimitConfig(tc.backend, tc.class)
+		if rate != tc.rate || bucket != tc.bucket || burst != 1 {
+			t.Errorf("RateLimitConfig(%s, %s) = %v, %v, %q wanted %v, 1, %q", tc.backend, tc.class,
+				rate, burst, bucket, tc.rate, tc.bucket)
+		}
+	}
+}
+
+func TestThrottleClass(t *testing.T) {
+	viper.Set("signers.rltest.limits.read", 4)
+	viper.Set("signers.throttling.classes", map[string]interface{}{"bulk": 0.25, "full": 1})
+	defer viper.Set("signers.rltest", nil)
+	defer viper.Set("signers.throttling", nil)
+
+	if err := CheckThrottleClasses(); err != nil {
+		t.Fatalf("CheckThrottleClasses: %v", err)
+	}
+	rl := &RateLimiter{Backend: "rltest", buckets: map[string]*tokenBucket{}}
+	for _, throttle := range []string{"", "full", "unknown"} {
+		if wait := rl.ThrottleWait("s1", OpRead, throttle); wait != 0 {
+			t.Errorf("ThrottleWait(%q) = %v wanted 0", throttle, wait)
+		}
+	}
+	if rl.ThrottleWait("s1", OpRead, "bulk") != 0 {
+		t.Errorf("bulk throttled before the first request")
+	}
+	rl.TakeThrottle("s1", OpRead, "bulk")
+	// a quarter of 4 requests per second: the next one after a second
+	if wait := rl.ThrottleWait("s1", OpRead, "bulk"); wait <= 900*time.Millisecond || wait > time.Second {
+		t.Errorf("ThrottleWait(bulk) = %v wanted about 1s", wait)
+	}
+	if rl.ThrottleWait("s2", OpRead, "bulk") != 0 {
+		t.Errorf("bulk throttled for another signer")
+	}
+
+	viper.Set("signers.throttling.default", "missing")
+	if err := CheckThrottleClasses(); err == nil {
+		t.Errorf("CheckThrottleClasses: undefined default class accepted")
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Desired-state reconciliation. Instead of starting the add-signer and remove-signer
+// processes explicitly, an operator may declare the set of signers that a zone should
+// have. The reconciler then compares the desired set with the signers of the signer
+// group that the zone is in and takes one step at a time towards convergence. As
+// signers are added to and removed from signer groups (not zones) the reconciler will
+// only modify a signer group that is dedicated to the zone. A zone that shares its
+// signer group with other zones is reported as drifting, but left alone.
+
+const ZoneDesiredSignersKey = "desired-signers"
+
+func (mdb *MusicDB) ZoneSetDesiredSigners(tx *sql.Tx, z *Zone, signers []string) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneSetDesiredSigners: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if len(signers) == 0 {
+		const sqlq = "DELETE FROM metadata WHERE zone=? AND key=?"
+		_, err = tx.Exec(sqlq, z.Name, ZoneDesiredSignersKey)
+		if CheckSQLError("ZoneSetDesiredSigners", sqlq, err, false) {
+			return "", err
+		}
+		return fmt.Sprintf("Zone %s no longer has a desired signer set.", z.Name), nil
+	}
+
+	for _, s := range signers {
+		if _, err = mdb.GetSignerByName(tx, s, false); err != nil {
+			return "", err
+		}
+	}
+	sort.Strings(signers)
+
+	_, err = mdb.ZoneSetMeta(tx, z, ZoneDesiredSignersKey, strings.Join(signers, ","))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Zone %s desired signers: %s", z.Name, strings.Join(signers, ", ")), nil
+}
+
+func (mdb *MusicDB) GetDesiredSigners(tx *sql.Tx, z *Zone) ([]string, bool, error) {
+	value, exists, err := mdb.GetMeta(tx, z, ZoneDesiredSignersKey)
+	if err != nil || !exists || value == "" {
+		return []string{}, false, err
+	}
+	return strings.Split(value, ","), true, nil
+}
+
+// signerSetDiff returns the signers in desired that are not in current and the
+// signers in current that are not in desired, both sorted.
+func signerSetDiff(desired []string, current map[string]*Signer) ([]string, []string) {
+	var missing, extra []string
+	dmap := map[string]bool{}
+	for _, s := range desired {
+		dmap[s] = true
+		if _, ok := current[s]; !ok {
+			missing = append(missing, s)
+		}
+	}
+	for s := range current {
+		if !dmap[s] {
+			extra = append(extra, s)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return missing, extra
+}
+
+// ReconcileZone takes (at most) one step to move the zone towards its desired signer
+// set. Signers are always added before any signer is removed, so that a replacement
+// never leaves the zone with fewer signers than it started with.
+func (mdb *MusicDB) ReconcileZone(tx *sql.Tx, z *Zone, enginecheck chan EngineCheck) (string, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ReconcileZone: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	desired, exists, err := mdb.GetDesiredSigners(tx, z)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return fmt.Sprintf("Zone %s has no desired signer set.", z.Name), nil
+	}
+
+	if z.FSM != "" && z.FSM != "---" {
+		return fmt.Sprintf("Zone %s is in process '%s'. Waiting for it to complete.",
+			z.Name, z.FSM), nil
+	}
+
+	// A zone without a signer group joins a group with exactly the desired signers,
+	// which is created if needed.
+	if z.SGname == "" {
+		sgs, err := mdb.ListSignerGroups(tx)
+		if err != nil {
+			return "", err
+		}
+		for name, sg := range sgs {
+			missing, extra := signerSetDiff(desired, sg.SignerMap)
+			if len(missing) == 0 && len(extra) == 0 && !sg.Locked {
+				return mdb.ZoneJoinGroup(tx, z, name, enginecheck)
+			}
+		}
+
+		group := "desired-" + StripDot(z.Name)
+		if _, err = mdb.AddSignerGroup(tx, group); err != nil {
+			return "", err
+		}
+		for _, s := range desired {
+			dbsigner, err := mdb.GetSignerByName(tx, s, false)
+			if err != nil {
+				return "", err
+			}
+			if _, err = mdb.SignerJoinGroup(tx, dbsigner, group); err != nil {
+				return "", err
+			}
+		}
+		return mdb.ZoneJoinGroup(tx, z, group, enginecheck)
+	}
+
+	sg := z.SignerGroup()
+	missing, extra := signerSetDiff(desired, sg.SignerMap)
+	if len(missing) == 0 && len(extra) == 0 {
+		return fmt.Sprintf("Zone %s is in sync with its desired signer set.", z.Name), nil
+	}
+
+	if sg.CurrentProcess != "" || sg.Locked {
+		return fmt.Sprintf("Signer group %s is in process '%s'. Waiting for it to complete.",
+			sg.Name, sg.CurrentProcess), nil
+	}
+
+	if sg.NumZones > 1 {
+		return "", fmt.Errorf("Zone %s has drifted from its desired signer set (missing: %v, extra: %v), but shares signer group %s with %d other zones. Not modifying the group.",
+			z.Name, missing, extra, sg.Name, sg.NumZones-1)
+	}
+
+	if len(missing) > 0 {
+		dbsigner, err := mdb.GetSignerByName(tx, missing[0], false)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("ReconcileZone: zone %s: adding signer %s to group %s", z.Name,
+			dbsigner.Name, sg.Name)
+		return mdb.SignerJoinGroup(tx, dbsigner, sg.Name)
+	}
+
+	dbsigner, err := mdb.GetSignerByName(tx, extra[0], false)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("ReconcileZone: zone %s: removing signer %s from group %s", z.Name,
+		dbsigner.Name, sg.Name)
+	return mdb.SignerLeaveGroup(tx, dbsigner, sg.Name)
+}
+
+// ReconcileZones runs ReconcileZone() for all zones that have a desired signer set.
+func (mdb *MusicDB) ReconcileZones(enginecheck chan EngineCheck) (map[string]string, error) {
+	result := map[string]string{}
+
+	const sqlq = "SELECT zone FROM metadata WHERE key=? AND value != ''"
+	rows, err := mdb.Query(sqlq, ZoneDesiredSignersKey)
+	if CheckSQLError("ReconcileZones", sqlq, err, false) {
+		return result, err
+	}
+
+	var zones []string
+	var zone string
+	for rows.Next() {
+		if err := rows.Scan(&zone); err != nil {
+			log.Fatalf("ReconcileZones: Error from rows.Scan(): %v", err)
+		}
+		zones = append(zones, zone)
+	}
+	rows.Close()
+
+	for _, zone := range zones {
+		z, exists, err := mdb.GetZone(nil, zone)
+		if err != nil || !exists {
+			continue
+		}
+		msg, err := mdb.ReconcileZone(nil, z, enginecheck)
+		if err != nil {
+			msg = err.Error()
+		}
+		result[zone] = msg
+	}
+	return result, nil
+}
@@ -0,0 +1,202 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Request log. The latest requestlog.size requests (default 100) exchanged with each
+// signer backend are kept in memory together with the responses, so that what MUSIC
+// sent to a backend at a given time can be looked up afterwards ("show requests").
+// Unlike the query log this is always on and also covers the API backends (deSEC).
+// Credentials are never stored: auth headers are not recorded and the values of JSON
+// fields that look like secrets (tokens, passwords, keys) are replaced by "REDACTED".
+
+type BackendRequest struct {
+	Time     time.Time
+	Backend  string // API name or host, or DNS server address
+	Method   string // HTTP method or DNS opcode
+	Target   string // URL or "qname qtype"
+	Request  string // request body or update section, redacted
+	Status   string // HTTP status or DNS rcode
+	Response string // response body or answer section, redacted
+	RttMs    float64
+	Error    string
+}
+
+var requestLog = struct {
+	sync.Mutex
+	rings map[string][]BackendRequest // oldest first
+}{rings: map[string][]BackendRequest{}}
+
+func requestLogConfig() (size, maxbody int) {
+	size = viper.GetInt("requestlog.size")
+	if size <= 0 {
+		size = 100
+	}
+	maxbody = viper.GetInt("requestlog.maxbody")
+	if maxbody <= 0 {
+		maxbody = 4096
+	}
+	return
+}
+
+func recordRequest(br BackendRequest) {
+	size, maxbody := requestLogConfig()
+	br.Request = truncateBody(br.Request, maxbody)
+	br.Response = truncateBody(br.Response, maxbody)
+
+	requestLog.Lock()
+	defer requestLog.Unlock()
+	ring := append(requestLog.rings[br.Backend], br)
+	if len(ring) > size {
+		ring = ring[len(ring)-size:]
+	}
+	requestLog.rings[br.Backend] = ring
+}
+
+func truncateBody(body string, max int) string {
+	if len(body) <= max {
+		return body
+	}
+	return fmt.Sprintf("%s... (%d bytes)", body[:max], len(body))
+}
+
+// JSON field names (lower case, without "_" and "-") whose values are never stored, and
+// suffixes of such names
+var secretFields = map[string]bool{"key": true, "authorization": true, "credentials": true}
+var secretSuffixes = []string{"token", "password", "secret", "apikey", "privatekey"}
+
+func isSecretField(name string) bool {
+	name = strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(name))
+	if secretFields[name] {
+		return true
+	}
+	for _, s := range secretSuffixes {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if isSecretField(k) {
+				t[k] = "REDACTED"
+			} else {
+				t[k] = redactValue(val)
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = redactValue(t[i])
+		}
+	}
+	return v
+}
+
+// RedactBody returns body with the values of secret-looking JSON fields replaced. A body
+// that is not JSON is returned as is.
+func RedactBody(body []byte) string {
+	var v interface{}
+	if len(body) == 0 || json.Unmarshal(body, &v) != nil {
+		return string(body)
+	}
+	buf, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return string(body)
+	}
+	return string(buf)
+}
+
+func recordHTTPRequest(api *Api, req *http.Request, status int, resp []byte, start time.Time, err error) {
+	br := BackendRequest{
+		Time:     start.UTC(),
+		Backend:  api.Name,
+		Method:   req.Method,
+		Target:   req.URL.String(),
+		Response: RedactBody(resp),
+		RttMs:    float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if br.Backend == "" {
+		br.Backend = req.URL.Host
+	}
+	if req.GetBody != nil {
+		if body, berr := req.GetBody(); berr == nil {
+			buf, _ := ioutil.ReadAll(body)
+			br.Request = RedactBody(buf)
+		}
+	}
+	if err != nil {
+		br.Error = err.Error()
+	} else {
+		br.Status = fmt.Sprintf("%d", status)
+	}
+	recordRequest(br)
+}
+
+func recordDNSRequest(server string, m, r *dns.Msg, rtt time.Duration, err error) {
+	br := BackendRequest{
+		Time:    time.Now().Add(-rtt).UTC(),
+		Backend: server,
+		Method:  dns.OpcodeToString[m.Opcode],
+		RttMs:   float64(rtt.Microseconds()) / 1000,
+	}
+	if len(m.Question) > 0 {
+		br.Target = m.Question[0].Name + " " + dns.TypeToString[m.Question[0].Qtype]
+	}
+	var rrs []string
+	for _, rr := range m.Ns { // the update section; the TSIG RR is in the additional section
+		rrs = append(rrs, rr.String())
+	}
+	br.Request = strings.Join(rrs, "\n")
+	if err != nil {
+		br.Error = err.Error()
+	}
+	if r != nil {
+		br.Status = dns.RcodeToString[r.Rcode]
+		rrs = []string{}
+		for _, rr := range r.Answer {
+			rrs = append(rrs, rr.String())
+		}
+		br.Response = strings.Join(rrs, "\n")
+	}
+	recordRequest(br)
+}
+
+// ListBackendRequests returns the logged requests to the backend (all backends if "")
+// made at or after since, oldest first.
+func ListBackendRequests(backend string, since time.Time) []BackendRequest {
+	var res []BackendRequest
+	requestLog.Lock()
+	for b, ring := range requestLog.rings {
+		if backend != "" && b != backend {
+			continue
+		}
+		for _, br := range ring {
+			if !br.Time.Before(since) {
+				res = append(res, br)
+			}
+		}
+	}
+	requestLog.Unlock()
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Time.Before(res[j].Time) })
+	return res
+}
@@ -0,0 +1,42 @@
+package music
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactBody(t *testing.T) {
+	body := `{"email": "ops@example.se", "password": "hunter2", "rrsets": [{"auth_token": "abc", "ttl": 3600}]}`
+	got := RedactBody([]byte(body))
+	for _, secret := range []string{"hunter2", "abc"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("RedactBody: %q not redacted: %s", secret, got)
+		}
+	}
+	if !strings.Contains(got, "ops@example.se") || !strings.Contains(got, "3600") {
+		t.Errorf("RedactBody: too much redacted: %s", got)
+	}
+	if got := RedactBody([]byte("not json")); got != "not json" {
+		t.Errorf("RedactBody: non-JSON body changed: %s", got)
+	}
+}
+
+func TestRequestLogRing(t *testing.T) {
+	size, _ := requestLogConfig()
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < size+10; i++ {
+		recordRequest(BackendRequest{Time: start.Add(time.Duration(i) * time.Second),
+			Backend: "test-ring", Method: "GET"})
+	}
+	brs := ListBackendRequests("test-ring", time.Time{})
+	if len(brs) != size {
+		t.Fatalf("ListBackendRequests: got %d requests, wanted %d", len(brs), size)
+	}
+	if !brs[0].Time.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("ListBackendRequests: oldest request not dropped first")
+	}
+	if n := len(ListBackendRequests("test-ring", start.Add(time.Duration(size)*time.Second))); n != 10 {
+		t.Errorf("ListBackendRequests: since: got %d requests, wanted 10", n)
+	}
+}
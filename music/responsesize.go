@@ -0,0 +1,105 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Response size guardrails. When several signers publish their keys in the same DNSKEY
+// RRset (and, during a rollover, more than one key each) the response to a DNSKEY query,
+// including one RRSIG per signing key, may grow beyond what can be sent without IP
+// fragmentation. Before such RRsets are published their response size is estimated and
+// compared to signers.responsesize.warn (default 1232 octets, only logged) and
+// signers.responsesize.max (default 0 = no limit, blocks the transition).
+
+const defaultResponseSizeWarn = 1232
+
+// sigLength returns the length (in octets) of a signature made with key.
+func sigLength(key *dns.DNSKEY) int {
+	switch key.Algorithm {
+	case dns.ECDSAP256SHA256, dns.ED25519:
+		return 64
+	case dns.ECDSAP384SHA384:
+		return 96
+	case dns.ED448:
+		return 114
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512:
+		buf, err := base64.StdEncoding.DecodeString(key.PublicKey)
+		if err != nil || len(buf) < 3 {
+			break
+		}
+		// RFC 3110: exponent length, exponent, modulus
+		if buf[0] != 0 {
+			return len(buf) - 1 - int(buf[0])
+		}
+		return len(buf) - 3 - (int(buf[1])<<8 | int(buf[2]))
+	}
+	return 256 // assume RSA 2048
+}
+
+// EstimateResponseSize returns the size of a DNSSEC response with rrset in the answer,
+// signed by the keys among dnskeys that would sign an RRset of that type (the KSKs for
+// DNSKEY, the ZSKs otherwise, all keys if there is no such split).
+func EstimateResponseSize(zone string, rrtype uint16, rrset []dns.RR, dnskeys []*dns.DNSKEY) int {
+	var ksks, zsks []*dns.DNSKEY
+	for _, k := range dnskeys {
+		if k.Flags&dns.SEP != 0 {
+			ksks = append(ksks, k)
+		} else {
+			zsks = append(zsks, k)
+		}
+	}
+	signers := zsks
+	if rrtype == dns.TypeDNSKEY {
+		signers = ksks
+	}
+	if len(signers) == 0 {
+		signers = dnskeys
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(zone), rrtype)
+	m.Response = true
+	m.Answer = append(m.Answer, rrset...)
+	for _, k := range signers {
+		m.Answer = append(m.Answer, &dns.RRSIG{
+			Hdr:         dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeRRSIG, Class: dns.ClassINET},
+			TypeCovered: rrtype,
+			Algorithm:   k.Algorithm,
+			KeyTag:      k.KeyTag(),
+			SignerName:  dns.Fqdn(zone),
+			Signature:   base64.StdEncoding.EncodeToString(make([]byte, sigLength(k))),
+		})
+	}
+	m.SetEdns0(4096, true)
+	m.Compress = true
+	return m.Len()
+}
+
+// CheckResponseSize logs a warning if the estimated response size for the RRset is
+// above the warning threshold and returns an error if it is above the maximum.
+func CheckResponseSize(zone string, rrtype uint16, rrset []dns.RR, dnskeys []*dns.DNSKEY) error {
+	size := EstimateResponseSize(zone, rrtype, rrset, dnskeys)
+
+	warn := viper.GetInt("signers.responsesize.warn")
+	if warn == 0 {
+		warn = defaultResponseSizeWarn
+	}
+	if max := viper.GetInt("signers.responsesize.max"); max > 0 && size > max {
+		return fmt.Errorf("The %s response for %s would be about %d octets, above the limit of %d octets",
+			dns.TypeToString[rrtype], zone, size, max)
+	}
+	if size > warn {
+		log.Printf("Warning: the %s response for %s will be about %d octets (%d RRs), which may cause fragmentation",
+			dns.TypeToString[rrtype], zone, size, len(rrset))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestEstimateResponseSize(t *testing.T) {
+	newkey := func(flags uint16) *dns.DNSKEY {
+		k := &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+			Flags:     flags,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		if _, err := k.Generate(256); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		return k
+	}
+
+	var keys []*dns.DNSKEY
+	var rrset []dns.RR
+	for i := 0; i < 2; i++ { // two signers, one KSK and one ZSK each
+		for _, flags := range []uint16{257, 256} {
+			k := newkey(flags)
+			keys = append(keys, k)
+			rrset = append(rrset, k)
+		}
+	}
+
+	small := EstimateResponseSize("example.com.", dns.TypeDNSKEY, rrset[:2], keys[:2])
+	large := EstimateResponseSize("example.com.", dns.TypeDNSKEY, rrset, keys)
+	if small <= 0 || large <= small {
+		t.Errorf("EstimateResponseSize: one signer %d octets, two signers %d octets", small, large)
+	}
+	// 4 keys (~99 octets each) and 2 RRSIGs (~93 octets each) plus header, question and OPT
+	if large < 550 || large > 700 {
+		t.Errorf("EstimateResponseSize: two signers: %d octets, expected about 600", large)
+	}
+}
+
+func TestSigLength(t *testing.T) {
+	k := &dns.DNSKEY{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET},
+		Flags: 257, Protocol: 3, Algorithm: dns.RSASHA256}
+	if _, err := k.Generate(2048); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if l := sigLength(k); l != 256 {
+		t.Errorf("sigLength: RSA 2048: got %d, want 256", l)
+	}
+}
@@ -0,0 +1,324 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// RIPE Atlas. With ripeatlas.active set, the wait states (DS and NS propagation) do not
+// end when the hold-down time has passed, but only once a one-off RIPE Atlas DNS
+// measurement confirms that resolvers across the Internet see the new RRset (as served
+// by the parent). The measurements and their outcome are kept per zone and are part of
+// the evidence of the process run (see evidence.go).
+
+type AtlasMeasurement struct {
+	ID          int
+	Zone        string
+	RRtype      string
+	Measurement int // RIPE Atlas measurement id
+	Created     time.Time
+	Checked     time.Time
+	Responses   int
+	Agreeing    int
+	Status      string // "running", "confirmed", "superseded"
+}
+
+var atlasClient = &http.Client{Timeout: 30 * time.Second}
+
+func atlasConfig() (baseurl string, probes, minresponses int, threshold float64, retry time.Duration) {
+	baseurl = viper.GetString("ripeatlas.baseurl")
+	if baseurl == "" {
+		baseurl = "https://atlas.ripe.net/api/v2"
+	}
+	probes = viper.GetInt("ripeatlas.probes")
+	if probes < 1 {
+		probes = 50
+	}
+	minresponses = viper.GetInt("ripeatlas.minresponses")
+	if minresponses < 1 {
+		minresponses = probes / 2
+	}
+	threshold = viper.GetFloat64("ripeatlas.threshold")
+	if threshold <= 0 || threshold > 1 {
+		threshold = 0.95
+	}
+	retry = time.Duration(viper.GetInt("ripeatlas.retry")) * time.Minute
+	if retry <= 0 {
+		retry = 30 * time.Minute
+	}
+	return
+}
+
+func atlasRequest(method, url string, body interface{}, result interface{}) error {
+	var buf []byte
+	if body != nil {
+		var err error
+		if buf, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Key "+viper.GetString("ripeatlas.key"))
+
+	resp, err := atlasClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	rbuf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("RIPE Atlas: %s: status %d: %s", url, resp.StatusCode, string(rbuf))
+	}
+	return json.Unmarshal(rbuf, result)
+}
+
+// atlasCreate starts a one-off measurement of (zone, rrtype) using the resolvers of
+// the probes.
+func atlasCreate(zone string, rrtype uint16) (int, error) {
+	baseurl, probes, _, _, _ := atlasConfig()
+	area := viper.GetString("ripeatlas.area")
+	if area == "" {
+		area = "WW"
+	}
+
+	def := map[string]interface{}{
+		"definitions": []map[string]interface{}{{
+			"type":               "dns",
+			"af":                 4,
+			"description":        fmt.Sprintf("MUSIC: %s %s propagation", zone, dns.TypeToString[rrtype]),
+			"query_class":        "IN",
+			"query_type":         dns.TypeToString[rrtype],
+			"query_argument":     zone,
+			"use_probe_resolver": true,
+			"set_rd_bit":         true,
+			"protocol":           "UDP",
+			"udp_payload_size":   1232,
+			"include_abuf":       true,
+		}},
+		"probes":    []map[string]interface{}{{"requested": probes, "type": "area", "value": area}},
+		"is_oneoff": true,
+	}
+	var res struct {
+		Measurements []int `json:"measurements"`
+	}
+	if err := atlasRequest("POST", baseurl+"/measurements/", def, &res); err != nil {
+		return 0, err
+	}
+	if len(res.Measurements) == 0 {
+		return 0, fmt.Errorf("RIPE Atlas did not return a measurement id")
+	}
+	return res.Measurements[0], nil
+}
+
+type atlasResult struct {
+	ProbeID int `json:"prb_id"`
+	Result  *struct {
+		Abuf string `json:"abuf"`
+	} `json:"result"`
+	ResultSet []struct {
+		Result *struct {
+			Abuf string `json:"abuf"`
+		} `json:"result"`
+	} `json:"resultset"`
+}
+
+// atlasAnswerKey returns the RRset of rrtype in the answer as a comparable string.
+func atlasAnswerKey(abuf string, rrtype uint16) (string, bool) {
+	buf, err := base64.StdEncoding.DecodeString(abuf)
+	if err != nil {
+		return "", false
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil || m.Rcode != dns.RcodeSuccess {
+		return "", false
+	}
+	var rrs []dns.RR
+	for _, rr := range m.Answer {
+		if rr.Header().Rrtype == rrtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	return strings.ToLower(rrsetKey(rrs)), true
+}
+
+// atlasTally counts the probes that answered and the probes whose resolvers all see
+// the expected RRset.
+func atlasTally(results []atlasResult, rrtype uint16, expected string) (responses, agreeing int) {
+	for _, r := range results {
+		var abufs []string
+		if r.Result != nil && r.Result.Abuf != "" {
+			abufs = append(abufs, r.Result.Abuf)
+		}
+		for _, rs := range r.ResultSet {
+			if rs.Result != nil && rs.Result.Abuf != "" {
+				abufs = append(abufs, rs.Result.Abuf)
+			}
+		}
+		answered, agree := false, true
+		for _, abuf := range abufs {
+			key, ok := atlasAnswerKey(abuf, rrtype)
+			if !ok {
+				continue
+			}
+			answered = true
+			if key != expected {
+				agree = false
+			}
+		}
+		if answered {
+			responses++
+			if agree {
+				agreeing++
+			}
+		}
+	}
+	return
+}
+
+// atlasExpected returns the RRset the parent serves for the zone.
+func (z *Zone) atlasExpected(rrtype uint16) (string, error) {
+	parentAddress, err := z.GetParentAddressOrStop()
+	if err != nil {
+		return "", err
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(z.Name, rrtype)
+	r, _, err := DnsExchange(new(dns.Client), m, parentAddress)
+	if err != nil {
+		return "", err
+	}
+	var rrs []dns.RR
+	for _, rr := range append(r.Answer, r.Ns...) {
+		if rr.Header().Rrtype == rrtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	return strings.ToLower(rrsetKey(rrs)), nil
+}
+
+// AtlasConfirmed returns true if a RIPE Atlas measurement confirms that the parent's
+// RRset of type rrtype for the zone has propagated. If not, the reason is returned.
+// A measurement is started on the first call, and a new one when the latest is older
+// than ripeatlas.retry without having confirmed the propagation.
+func (z *Zone) AtlasConfirmed(rrtype uint16) (bool, string) {
+	if !viper.GetBool("ripeatlas.active") || z.Type().Simulated {
+		return true, ""
+	}
+	mdb := z.MusicDB
+	t := dns.TypeToString[rrtype]
+	baseurl, _, minresponses, threshold, retry := atlasConfig()
+
+	var id, msmid int
+	var created string
+	const sqlq = `
+SELECT id, msmid, created FROM atlas_measurements WHERE zone=? AND rrtype=? AND status='running'
+ORDER BY id DESC LIMIT 1`
+	err := mdb.db.QueryRow(sqlq, z.Name, t).Scan(&id, &msmid, &created)
+	if err != nil && err != sql.ErrNoRows {
+		CheckSQLError("AtlasConfirmed", sqlq, err, false)
+		return false, fmt.Sprintf("RIPE Atlas: %v", err)
+	}
+
+	start := err == sql.ErrNoRows
+	if !start {
+		if c, _ := time.Parse(layout, created); time.Since(c) > retry {
+			mdb.Exec("UPDATE atlas_measurements SET status='superseded' WHERE id=?", id)
+			start = true
+		}
+	}
+	if start && z.Explaining() {
+		return false, fmt.Sprintf("RIPE Atlas: a measurement of the %s RRset would be started", t)
+	}
+	if start {
+		msmid, err = atlasCreate(z.Name, rrtype)
+		if err != nil {
+			return false, fmt.Sprintf("Unable to start RIPE Atlas measurement: %v", err)
+		}
+		const sqlq2 = `
+INSERT INTO atlas_measurements(zone, rrtype, msmid, created, status) VALUES (?, ?, ?, datetime('now'), 'running')`
+		if _, err = mdb.Exec(sqlq2, z.Name, t, msmid); err != nil {
+			CheckSQLError("AtlasConfirmed", sqlq2, err, false)
+		}
+		recordEvidence(z.Name, "measurement",
+			fmt.Sprintf("RIPE Atlas measurement %d of %s %s started", msmid, z.Name, t))
+		return false, fmt.Sprintf("Waiting for RIPE Atlas measurement %d (%s %s)", msmid, z.Name, t)
+	}
+
+	expected, err := z.atlasExpected(rrtype)
+	if err != nil {
+		return false, fmt.Sprintf("Unable to fetch %s from parent: %v", t, err)
+	}
+	var results []atlasResult
+	err = atlasRequest("GET", fmt.Sprintf("%s/measurements/%d/results/?format=json", baseurl, msmid),
+		nil, &results)
+	if err != nil {
+		return false, fmt.Sprintf("Unable to fetch RIPE Atlas results: %v", err)
+	}
+	responses, agreeing := atlasTally(results, rrtype, expected)
+
+	const sqlq3 = `
+UPDATE atlas_measurements SET checked=datetime('now'), responses=?, agreeing=? WHERE id=?`
+	if _, err = mdb.Exec(sqlq3, responses, agreeing, id); err != nil {
+		CheckSQLError("AtlasConfirmed", sqlq3, err, false)
+	}
+
+	if responses < minresponses || float64(agreeing) < threshold*float64(responses) {
+		return false, fmt.Sprintf("RIPE Atlas measurement %d: %d of %d probes see the new %s RRset (need %.0f%% of at least %d)",
+			msmid, agreeing, responses, t, 100*threshold, minresponses)
+	}
+
+	mdb.Exec("UPDATE atlas_measurements SET status='confirmed' WHERE id=?", id)
+	msg := fmt.Sprintf("RIPE Atlas measurement %d confirmed propagation of %s %s: %d of %d probes agree",
+		msmid, z.Name, t, agreeing, responses)
+	log.Printf("%s", msg)
+	recordEvidence(z.Name, "measurement", msg)
+	return true, ""
+}
+
+func (mdb *MusicDB) ListAtlasMeasurements(dbzone *Zone) ([]AtlasMeasurement, error) {
+	var ams []AtlasMeasurement
+
+	const sqlq = `
+SELECT id, zone, rrtype, msmid, COALESCE(created, ''), COALESCE(checked, ''), responses, agreeing, status
+FROM atlas_measurements WHERE zone=? ORDER BY id`
+	rows, err := mdb.db.Query(sqlq, dbzone.Name)
+	if CheckSQLError("ListAtlasMeasurements", sqlq, err, false) {
+		return ams, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var am AtlasMeasurement
+		var created, checked string
+		if err := rows.Scan(&am.ID, &am.Zone, &am.RRtype, &am.Measurement, &created, &checked,
+			&am.Responses, &am.Agreeing, &am.Status); err != nil {
+			log.Fatalf("ListAtlasMeasurements: Error from rows.Scan(): %v", err)
+		}
+		am.Created, _ = time.Parse(layout, created)
+		am.Checked, _ = time.Parse(layout, checked)
+		ams = append(ams, am)
+	}
+	return ams, nil
+}
@@ -0,0 +1,59 @@
+package music
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func atlasAbuf(t *testing.T, rrs ...string) string {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeDS)
+	for _, s := range rrs {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("dns.NewRR(%q): %v", s, err)
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func TestAtlasTally(t *testing.T) {
+	oldds := "example.com. 3600 IN DS 1 13 2 AAAA"
+	newds := "example.com. 3600 IN DS 2 13 2 BBBB"
+	rr, _ := dns.NewRR(newds)
+	rr.Header().Ttl = 17 // resolvers count the TTL down
+	expected := strings.ToLower(rrsetKey([]dns.RR{rr}))
+
+	var results []atlasResult
+	add := func(abufs ...string) {
+		var r atlasResult
+		for _, abuf := range abufs {
+			r.ResultSet = append(r.ResultSet, struct {
+				Result *struct {
+					Abuf string `json:"abuf"`
+				} `json:"result"`
+			}{Result: &struct {
+				Abuf string `json:"abuf"`
+			}{Abuf: abuf}})
+		}
+		results = append(results, r)
+	}
+	add(atlasAbuf(t, newds))
+	add(atlasAbuf(t, strings.ToUpper(newds[:12])+newds[12:]))
+	add(atlasAbuf(t, newds), atlasAbuf(t, oldds)) // one of the resolvers is behind
+	add(atlasAbuf(t, oldds))
+	add("not base64")
+
+	responses, agreeing := atlasTally(results, dns.TypeDS, expected)
+	if responses != 4 || agreeing != 2 {
+		t.Errorf("atlasTally() = %d, %d, want 4, 2", responses, agreeing)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"log"
 	// "strings"
-	"time"
 
 	"github.com/miekg/dns"
 )
@@ -34,19 +33,22 @@ func (u *RLDdnsUpdater) GetApi() Api {
 	return Api{}
 }
 
+func (u *RLDdnsUpdater) Capabilities() UpdaterCapabilities {
+	return UpdaterCapabilities{CDS: true, CSYNC: true, Batch: true, MaxRRsPerUpdate: ddnsMaxRRs(),
+		RateLimit: rateLimit("ddns", OpWriteRRset)}
+}
+
 func (u *RLDdnsUpdater) Update(signer *Signer, zone, owner string,
 	inserts, removes *[][]dns.RR) error {
 	op := SignerOp{
-		Signer:   signer,
-		Zone:     zone,
-		Owner:    owner,
-		Inserts:  inserts,
-		Removes:  removes,
-		Response: make(chan SignerOpResult, 2),
+		Command: "update",
+		Signer:  signer,
+		Zone:    zone,
+		Owner:   owner,
+		Inserts: inserts,
+		Removes: removes,
 	}
-	u.UpdateCh <- op
-	time.Sleep(1 * time.Second)
-	resp := <-op.Response
+	resp := RunSignerOp(u.UpdateCh, "rlddns-update", op)
 	return resp.Error
 }
 
@@ -74,26 +76,26 @@ func RLDdnsUpdate(udop SignerOp) (bool, int, error) {
 			removes_len += len(remove)
 		}
 	}
-	log.Printf("RLDDNS Updater: signer: %s, fqdn: %s inserts: %d removes: %d\n",
-		signer.Name, owner, inserts_len, removes_len)
+	log.Printf("RLDDNS Updater: [%s] signer: %s, fqdn: %s inserts: %d removes: %d\n",
+		udop.ID, signer.Name, owner, inserts_len, removes_len)
 
 	var err error
 	if inserts_len == 0 && removes_len == 0 {
 		err = fmt.Errorf("Inserts and removes empty, nothing to do")
 	} else if signer.Address == "" {
 		err = fmt.Errorf("No ip|host for signer %s", signer.Name)
-	} else if signer.Auth.TSIGKey == "" {
-		err = fmt.Errorf("No TSIG for signer %s", signer.Name)
+	} else if !signer.hasUpdateAuth() {
+		err = fmt.Errorf("No TSIG or SIG(0) key for signer %s", signer.Name)
 	}
 
 	if err != nil {
-		udop.Response <- SignerOpResult{Error: err}
+		udop.Respond(SignerOpResult{Error: err})
 		return false, 0, nil // return to ddnsmgr: no rate-limiting, no hold
 	}
 
 	c := signer.NewDnsClient()
 	m := new(dns.Msg)
-	m.SetUpdate(owner)
+	m.SetUpdate(updateZone(udop.Zone, owner))
 	if inserts != nil {
 		for _, insert := range *inserts {
 			m.Insert(insert)
@@ -105,35 +107,36 @@ func RLDdnsUpdate(udop SignerOp) (bool, int, error) {
 		}
 	}
 
-	signer.PrepareTSIGExchange(&c, m)
+	if err := signer.PrepareTSIGExchange(c, m); err != nil {
+		udop.Respond(SignerOpResult{Error: err})
+		return false, 0, nil // return to ddnsmgr: no rate-limiting, no hold
+	}
 
-	in, _, err := c.Exchange(m, signer.Address+":"+signer.Port) // TODO: add DnsAddress or solve this in a better way
+	in, _, err := signer.Exchange(c, m)
 	if err != nil {
-		udop.Response <- SignerOpResult{Error: err}
+		udop.Respond(SignerOpResult{Error: err})
 		return false, 0, nil // return to ddnsmgr: no rate-limiting, no hold
 	}
 	if in.MsgHdr.Rcode != dns.RcodeSuccess {
-		udop.Response <- SignerOpResult{
+		udop.Respond(SignerOpResult{
 			Error: fmt.Errorf("Update failed, RCODE = %s", dns.RcodeToString[in.MsgHdr.Rcode]),
-		}
+		})
 		return false, 0, nil // return to ddnsmgr: no rate-limiting, no hold
 	}
-	udop.Response <- SignerOpResult{Error: nil, Rcode: dns.RcodeSuccess}
+	udop.Respond(SignerOpResult{Error: nil, Rcode: dns.RcodeSuccess})
 	return false, 0, nil // return to ddnsmgr: no rate-limiting, no hold
 }
 
 // Why is RemoveRRset using [][]dns.RR when all other methods use *[][]dns.RR? Intentionally or a mistake?
 func (u *RLDdnsUpdater) RemoveRRset(signer *Signer, zone, owner string, rrsets [][]dns.RR) error {
 	op := SignerOp{
-		Signer:   signer,
-		Zone:     zone,
-		Owner:    owner,
-		Removes:  &rrsets,
-		Response: make(chan SignerOpResult, 2),
+		Command: "remove-rrset",
+		Signer:  signer,
+		Zone:    zone,
+		Owner:   owner,
+		Removes: &rrsets,
 	}
-	u.UpdateCh <- op
-	time.Sleep(1 * time.Second)
-	resp := <-op.Response
+	resp := RunSignerOp(u.UpdateCh, "rlddns-update", op)
 	return resp.Error
 }
 
@@ -153,36 +156,39 @@ func RLDdnsRemoveRRset(udop SignerOp) (bool, int, error) {
 	if signer.Address == "" {
 		err = fmt.Errorf("No ip|host for signer %s", signer.Name)
 	}
-	if signer.Auth.TSIGKey == "" {
-		err = fmt.Errorf("No TSIG for signer %s", signer.Name)
+	if !signer.hasUpdateAuth() {
+		err = fmt.Errorf("No TSIG or SIG(0) key for signer %s", signer.Name)
 	}
 
 	if err != nil {
-		udop.Response <- SignerOpResult{Error: err}
+		udop.Respond(SignerOpResult{Error: err})
 		return false, 0, nil // return to ddnsmgr: no rate-limiting, no hold
 	}
 
 	c := signer.NewDnsClient()
 	m := new(dns.Msg)
-	m.SetUpdate(udop.Owner)
+	m.SetUpdate(updateZone(udop.Zone, udop.Owner))
 	for _, rrset := range rrsets {
 		m.RemoveRRset(rrset)
 	}
 
-	signer.PrepareTSIGExchange(&c, m)	
+	if err := signer.PrepareTSIGExchange(c, m); err != nil {
+		udop.Respond(SignerOpResult{Error: err})
+		return false, 0, nil // return to ddnsmgr: no rate-limiting, no hold
+	}
 
-	in, _, err := c.Exchange(m, signer.Address+":"+signer.Port) // TODO: add DnsAddress or solve this in a better way
+	in, _, err := signer.Exchange(c, m)
 	if err != nil {
-		udop.Response <- SignerOpResult{Error: err}
+		udop.Respond(SignerOpResult{Error: err})
 		return false, 0, nil // return to ddnsmgr: no rate-limiting, no hold
 	}
 	if in.MsgHdr.Rcode != dns.RcodeSuccess {
-		udop.Response <- SignerOpResult{
+		udop.Respond(SignerOpResult{
 			Error: fmt.Errorf("Update failed, RCODE = %s", dns.RcodeToString[in.MsgHdr.Rcode]),
-		}
+		})
 		return false, 0, nil // return to ddnsmgr: no rate-limiting, no hold
 	}
-	udop.Response <- SignerOpResult{Error: nil, Rcode: dns.RcodeSuccess}
+	udop.Respond(SignerOpResult{Error: nil, Rcode: dns.RcodeSuccess})
 	return false, 0, nil // return to ddnsmgr: no rate-limiting, no hold
 }
 
@@ -192,15 +198,13 @@ func (u *RLDdnsUpdater) FetchRRset(s *Signer, zone, owner string,
 	// fmt.Printf("rlddns.FetchRRset: received query for '%s %s'\n", owner, dns.TypeToString[rrtype])
 
 	op := SignerOp{
-		Signer:   s,
-		Zone:     zone,
-		Owner:    owner,
-		RRtype:   rrtype,
-		Response: make(chan SignerOpResult, 2),
+		Command: "fetch",
+		Signer:  s,
+		Zone:    zone,
+		Owner:   owner,
+		RRtype:  rrtype,
 	}
-	u.FetchCh <- op
-	time.Sleep(1 * time.Second)
-	resp := <-op.Response
+	resp := RunSignerOp(u.FetchCh, "rlddns-fetch", op)
 	// fmt.Printf("rlddns.FetchRRset: response received, returning\n")
 	return resp.Error, resp.RRs
 }
@@ -215,28 +219,25 @@ func RLDdnsFetchRRset(fdop SignerOp) (bool, int, error) {
 	if signer.Address == "" {
 		err = fmt.Errorf("No ip|host for signer %s", signer.Name)
 	}
-	if signer.Auth.TSIGKey == "" {
-		err = fmt.Errorf("No TSIG for signer %s", signer.Name)
+	if !signer.hasUpdateAuth() {
+		err = fmt.Errorf("No TSIG or SIG(0) key for signer %s", signer.Name)
 	}
 
 	if err != nil {
-		fmt.Printf("RLDdnsFetchRRset: Pre-req error: %v. Returning response chan + call stack\n", err)
-		fdop.Response <- SignerOpResult{Error: err}
+		fmt.Printf("RLDdnsFetchRRset: [%s] Pre-req error: %v. Returning response chan + call stack\n", fdop.ID, err)
+		fdop.Respond(SignerOpResult{Error: err})
 		// fmt.Printf("RLDdnsFetchRRset: post response chan after prereq error\n", err)
 		return false, 0, nil
 	}
 
-	c := signer.NewDnsClient()
 	m := new(dns.Msg)
 	m.SetQuestion(owner, rrtype)
 	// m.SetEdns0(4096, true)
 
-	signer.PrepareTSIGExchange(&c, m)
-
-	r, _, err := c.Exchange(m, signer.Address+":"+signer.Port) // TODO: add DnsAddress or solve this in a better way
+	r, err := signer.FetchExchange(m)
 	if err != nil {
-		fmt.Printf("RLDdnsFetchRRset: Error from Exchange: %v. Returning response chan + call stack\n", err)
-		fdop.Response <- SignerOpResult{Error: err}
+		fmt.Printf("RLDdnsFetchRRset: [%s] Error from Exchange: %v. Returning response chan + call stack\n", fdop.ID, err)
+		fdop.Respond(SignerOpResult{Error: err})
 		return false, 0, nil
 	}
 
@@ -245,13 +246,14 @@ func RLDdnsFetchRRset(fdop SignerOp) (bool, int, error) {
 			dns.TypeToString[rrtype],
 			dns.RcodeToString[r.MsgHdr.Rcode])
 		// fmt.Printf("RLDdnsFetchRRset: Rcode error: %v. Returning response chan + call stack\n", err)
-		fdop.Response <- SignerOpResult{Error: err}
+		fdop.Respond(SignerOpResult{Error: err})
 		// fmt.Printf("RLDdnsFetchRRset: post response chan after rcode error\n", err)
 		return false, 0, nil
 	}
 
-	log.Printf("RLDDNS: Length of %s answer from %s: %d RRs\n",
-		dns.TypeToString[rrtype], signer.Name,
+	learnNegativeTTL(signer.Name, fdop.Zone, r)
+	log.Printf("RLDDNS: [%s] Length of %s answer from %s: %d RRs\n",
+		fdop.ID, dns.TypeToString[rrtype], signer.Name,
 		len(r.Answer))
 
 	var rrs []dns.RR
@@ -316,13 +318,13 @@ func RLDdnsFetchRRset(fdop SignerOp) (bool, int, error) {
 	}
 
 	// fmt.Printf("RLDdnsFetchRRset: All ok. Returning result ->response chan + call stack\n", err)
-	fdop.Response <- SignerOpResult{
+	fdop.Respond(SignerOpResult{
 		Status:   0, // should perhaps use DNS Rcodes?
 		Rcode:    dns.RcodeSuccess,
 		RRs:      rrs,
 		Error:    nil,
 		Response: "Tjolahopp",
-	}
+	})
 	// fmt.Printf("RLDdnsFetchRRset: post response chan\n", err)
 
 	return false, 0, nil
@@ -9,7 +9,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/miekg/dns"
@@ -41,20 +40,23 @@ func (u *RLDesecUpdater) GetApi() Api {
 	return u.Api
 }
 
+func (u *RLDesecUpdater) Capabilities() UpdaterCapabilities {
+	return UpdaterCapabilities{CDS: true, CSYNC: true, Batch: true,
+		RateLimit: rateLimit("desec", OpWriteRRset)}
+}
+
 func (u *RLDesecUpdater) FetchRRset(s *Signer, zone, owner string,
 	rrtype uint16) (error, []dns.RR) {
 
 	// what we want:
 	op := SignerOp{
-		Signer:   s,
-		Zone:     zone,
-		Owner:    owner,
-		RRtype:   rrtype,
-		Response: make(chan SignerOpResult),
+		Command: "fetch",
+		Signer:  s,
+		Zone:    zone,
+		Owner:   owner,
+		RRtype:  rrtype,
 	}
-	u.FetchCh <- op
-	time.Sleep(1 * time.Second)
-	resp := <-op.Response
+	resp := RunSignerOp(u.FetchCh, "rldesec-fetch", op)
 	return resp.Error, resp.RRs
 }
 
@@ -79,10 +81,11 @@ func RLDesecFetchRRset(fdop SignerOp) (bool, int, error) {
 
 	// temporary kludge
 	api := GetUpdater("rldesec-api").GetApi()
-	api.DesecTokenRefresh()
 
-	fmt.Printf("FetchRRset: deSEC API endpoint: %s. token: %s\n", endpoint, api.apiKey)
-	status, buf, err := api.Get(endpoint)
+	fmt.Printf("FetchRRset: deSEC API endpoint: %s.\n", endpoint)
+	status, buf, err := api.DesecRequest(signer, zone, func() (int, []byte, error) {
+		return api.Get(endpoint)
+	})
 
 	if err != nil {
 		log.Printf("Error from api.Get (desec): %v\n", err)
@@ -92,11 +95,13 @@ func RLDesecFetchRRset(fdop SignerOp) (bool, int, error) {
 	}
 
 	if status == 429 { // we have been rate-limited
-		fmt.Printf("desec.FetchRRset: rate-limit. This is what we got: '%v'. Retry in %d seconds.\n", string(buf), 10)
-		// return true, status, nil, []dns.RR{}
-		hold := ExtractHoldPeriod(buf)
+		hold, err := ExtractHoldPeriod(api.LastHeader, buf)
+		if err != nil {
+			log.Printf("desec.FetchRRset: %v. Using default hold period.", err)
+		}
+		fmt.Printf("desec.FetchRRset: rate-limit. Retry in %d seconds.\n", hold)
 		// rate-limited, hold period, no error
-		return false, hold, nil // API should be (RL success, hold period, error)
+		return true, hold, nil // API should be (RL success, hold period, error)
 	}
 
 	fmt.Printf("FetchRRset: got a response from deSEC:\n%v\n", string(buf))
@@ -107,7 +112,8 @@ func RLDesecFetchRRset(fdop SignerOp) (bool, int, error) {
 	var dr DesecResponseRRset
 	err = json.Unmarshal(buf, &dr)
 	if err != nil {
-		log.Fatalf("FetchRRset: Error from unmarshal: %v\n", err)
+		// not rate-limited, no hold, but error return for a response we cannot parse
+		return false, 0, fmt.Errorf("FetchRRset: Error from unmarshal: %v", err)
 	}
 
 	var rrs []dns.RR
@@ -117,7 +123,7 @@ func RLDesecFetchRRset(fdop SignerOp) (bool, int, error) {
 		rr, err := dns.NewRR(rrstr)
 		if err != nil {
 			// not rate-limited, no hold, but error return for parse error
-			return true, 0,
+			return false, 0,
 				fmt.Errorf("FetchRRset: Error parsing RR into dns.RR: %v\n",
 					err)
 		}
@@ -126,28 +132,26 @@ func RLDesecFetchRRset(fdop SignerOp) (bool, int, error) {
 
 	mdb.WriteRRs(signer, dns.Fqdn(owner), zone, rrtype, rrs)
 	// return false, status, nil, DNSFilterRRsetOnType(rrs, rrtype)
-	fdop.Response <- SignerOpResult{
+	fdop.Respond(SignerOpResult{
 		Status:   status,
 		RRs:      DNSFilterRRsetOnType(rrs, rrtype),
 		Error:    err,
 		Response: "Obladi, oblada!",
-	}
-	return true, 0, nil // all is good, we're done with this request
+	})
+	return false, 0, nil // all is good, we're done with this request
 }
 
 func (u *RLDesecUpdater) Update(signer *Signer, zone, owner string,
 	inserts, removes *[][]dns.RR) error {
 	op := SignerOp{
-		Signer:   signer,
-		Zone:     zone,
-		Owner:    owner,
-		Inserts:  inserts,
-		Removes:  removes,
-		Response: make(chan SignerOpResult, 2),
+		Command: "update",
+		Signer:  signer,
+		Zone:    zone,
+		Owner:   owner,
+		Inserts: inserts,
+		Removes: removes,
 	}
-	u.UpdateCh <- op
-	time.Sleep(1 * time.Second)
-	resp := <-op.Response
+	resp := RunSignerOp(u.UpdateCh, "rldesec-update", op)
 	return resp.Error
 }
 
@@ -203,27 +207,37 @@ func RLDesecUpdate(udop SignerOp) (bool, int, error) {
 	json.NewEncoder(bytebuf).Encode(desecRRsets)
 
 	api := GetUpdater("rldesec-api").GetApi()
-	api.DesecTokenRefresh()
-	fmt.Printf("RLdeSECUpdater: deSEC API endpoint: %s. Data: %v\n",
-		endpoint, desecRRsets)
+	fmt.Printf("RLdeSECUpdater: [%s] deSEC API endpoint: %s. Data: %v\n",
+		udop.ID, endpoint, desecRRsets)
 
-	status, buf, err := api.Put(endpoint, bytebuf.Bytes())
+	status, buf, err := api.DesecRequest(udop.Signer, zone, func() (int, []byte, error) {
+		return api.Put(endpoint, bytebuf.Bytes())
+	})
 	if err != nil {
 		log.Printf("Error from api.Post (desec): %v\n", err)
-		udop.Response <- SignerOpResult{
+		udop.Respond(SignerOpResult{
 			Error: fmt.Errorf("Error from deSEC API for %s: %v",
 				endpoint, err),
-		}
+		})
 		return false, 0, nil
 	}
 
+	if status == 429 { // rate-limited, the manager holds and sends the update again
+		hold, err := ExtractHoldPeriod(api.LastHeader, buf)
+		if err != nil {
+			log.Printf("desec.Update: %v. Using default hold period.", err)
+		}
+		fmt.Printf("desec.Update: rate-limit. Retry in %d seconds.\n", hold)
+		return true, hold, nil
+	}
+
 	if verbose {
 		fmt.Printf("DesecUpdateRRset: status: %d\n", status)
 	}
 
-	udop.Response <- SignerOpResult{
+	udop.Respond(SignerOpResult{
 		Error: nil, // + send back some sort of desec status code?
-	}
+	})
 	fmt.Printf("DesecUpdateRRset: buf: %v\n", string(buf))
 	return false, 0, nil
 }
@@ -0,0 +1,643 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// AWS Route 53. The updater talks to the Route 53 API directly (requests are signed with
+// AWS Signature Version 4, there is no dependency on the AWS SDK). Zones are addressed by
+// their hosted zone ID, which is resolved via the provider zone ID cache (zoneids.go).
+// Route 53 only replaces entire RRsets, so an update fetches the current RRset and
+// writes back the result (UPSERT, or DELETE if nothing is left). The DNSKEY RRset is
+// not available through the API and is fetched from the name servers of the zone
+// instead; it cannot be updated, nor can CDS, CDNSKEY or CSYNC (see the template quirks).
+//
+// Credentials are taken from signers.route53.credentials.<signer> (accesskeyid,
+// secretaccesskey and, for temporary credentials, sessiontoken), falling back to
+// signers.route53.accesskeyid etc. and finally to the AWS_* environment variables.
+//
+// Route 53 allows 5 requests per second per account. Requests to a signer are paced
+// accordingly (signers.route53.limits.rate) and a throttled request (Throttling or
+// PriorRequestNotComplete) is reported as rate-limited with a hold period, like the
+// rlddns and rldesec updaters do.
+
+const route53Version = "2013-04-01"
+
+var route53RRtypes = map[uint16]bool{
+	dns.TypeA: true, dns.TypeAAAA: true, dns.TypeCAA: true, dns.TypeCNAME: true,
+	dns.TypeDS: true, dns.TypeMX: true, dns.TypeNAPTR: true, dns.TypeNS: true,
+	dns.TypePTR: true, dns.TypeSOA: true, dns.TypeSPF: true, dns.TypeSRV: true,
+	dns.TypeTXT: true, dns.TypeSSHFP: true, dns.TypeTLSA: true,
+}
+
+type Route53Updater struct {
+	Api Api
+}
+
+func init() {
+	Updaters["route53"] = &Route53Updater{}
+	ZoneIDResolvers["route53"] = route53ResolveZoneID
+}
+
+func (u *Route53Updater) SetChannels(fetch, update chan SignerOp) {
+	// no-op
+}
+
+func (u *Route53Updater) SetApi(api Api) {
+	u.Api = api
+}
+
+func (u *Route53Updater) GetApi() Api {
+	return u.Api
+}
+
+// A change batch may hold at most 1000 RRs.
+func (u *Route53Updater) Capabilities() UpdaterCapabilities {
+	return UpdaterCapabilities{MaxRRsPerUpdate: 1000, RateLimit: route53Rate(),
+		RRtypes: rrtypeNames(route53RRtypes)}
+}
+
+type route53Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+func route53CredentialsFor(s *Signer) (route53Credentials, error) {
+	get := func(key, env string) string {
+		if v := viper.GetString(fmt.Sprintf("signers.route53.credentials.%s.%s",
+			strings.ToLower(s.Name), key)); v != "" {
+			return v
+		}
+		if v := viper.GetString("signers.route53." + key); v != "" {
+			return v
+		}
+		return os.Getenv(env)
+	}
+	c := route53Credentials{
+		AccessKeyID:     get("accesskeyid", "AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: get("secretaccesskey", "AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    get("sessiontoken", "AWS_SESSION_TOKEN"),
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return c, fmt.Errorf("No AWS credentials for signer %s", s.Name)
+	}
+	return c, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// signAWSv4 adds the AWS Signature Version 4 headers to req.
+func signAWSv4(req *http.Request, body []byte, creds route53Credentials, region,
+	service string, now time.Time) {
+	amzdate := now.UTC().Format("20060102T150405Z")
+	date := amzdate[:8]
+	req.Header.Set("X-Amz-Date", amzdate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonheaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonheaders, "%s:%s\n", k, headers[k])
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	query := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+
+	canonreq := strings.Join([]string{req.Method, path, query, canonheaders.String(), signed,
+		sha256Hex(body)}, "\n")
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	tosign := strings.Join([]string{"AWS4-HMAC-SHA256", amzdate, scope,
+		sha256Hex([]byte(canonreq))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, tosign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signed, signature))
+}
+
+var route53Client struct {
+	once   sync.Once
+	client *http.Client
+}
+
+// route53Rate is the max number of requests per second to a signer.
+func route53Rate() float64 {
+	return rateLimit("route53", OpWriteRRset)
+}
+
+// route53Wait paces the requests to each signer, see ratelimiter.go.
+func route53Wait(s *Signer, method string) {
+	class := OpWriteRRset
+	if method == http.MethodGet {
+		class = OpRead
+	}
+	GetRateLimiter("route53").Wait(s.Name, class)
+}
+
+type route53Error struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Code    string   `xml:"Error>Code"`
+	Message string   `xml:"Error>Message"`
+}
+
+// route53Throttled is returned when Route 53 asks us to slow down.
+type route53Throttled struct {
+	hold int // seconds
+	msg  string
+}
+
+func (e *route53Throttled) Error() string {
+	return fmt.Sprintf("Route 53 request throttled: %s", e.msg)
+}
+
+func route53Request(s *Signer, method, path string, query url.Values, body []byte) ([]byte, error) {
+	creds, err := route53CredentialsFor(s)
+	if err != nil {
+		return nil, err
+	}
+	route53Client.once.Do(func() {
+		tlsconf, err := GenericTLSConfig()
+		if err != nil {
+			log.Printf("Route53: %v. Using the system roots.", err)
+			tlsconf = nil
+		}
+		route53Client.client = &http.Client{Transport: NewApiTransport(tlsconf)}
+	})
+
+	host := s.Address
+	if host == "" {
+		host = "route53.amazonaws.com"
+	}
+	if s.Port != "" && s.Port != "443" {
+		host = net.JoinHostPort(host, s.Port)
+	}
+	u := url.URL{Scheme: "https", Host: host, Path: "/" + route53Version + path,
+		RawQuery: query.Encode()}
+
+	ctx, cancel := apiContext(nil)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "text/xml")
+	}
+	region := viper.GetString("signers.route53.region")
+	if region == "" {
+		region = "us-east-1"
+	}
+	signAWSv4(req, body, creds, region, "route53", time.Now())
+
+	route53Wait(s, method)
+	api := &Api{Name: "route53:" + s.Name, Client: route53Client.client}
+	status, buf, err := api.sendWithRetry(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error from Route 53 API: %v", err)
+	}
+	if status >= 200 && status < 300 {
+		return buf, nil
+	}
+
+	var rerr route53Error
+	xml.Unmarshal(buf, &rerr)
+	switch rerr.Code {
+	case "Throttling", "PriorRequestNotComplete":
+		hold := 1
+		if secs, ok := ParseRetryAfter(api.LastHeader.Get("Retry-After")); ok && secs > 0 {
+			hold = secs
+		}
+		return nil, &route53Throttled{hold: hold, msg: rerr.Message}
+	case "NoSuchHostedZone":
+		return nil, fmt.Errorf("%w: %s", ErrZoneIDNotFound, rerr.Message)
+	}
+	if rerr.Code == "" {
+		return nil, fmt.Errorf("Route 53 API: status %d: %s", status, string(buf))
+	}
+	return nil, fmt.Errorf("Route 53 API: %s: %s", rerr.Code, rerr.Message)
+}
+
+type route53HostedZones struct {
+	XMLName     xml.Name `xml:"ListHostedZonesByNameResponse"`
+	HostedZones []struct {
+		Id   string `xml:"Id"`
+		Name string `xml:"Name"`
+	} `xml:"HostedZones>HostedZone"`
+}
+
+func route53ResolveZoneID(s *Signer, zone string) (string, error) {
+	q := url.Values{"dnsname": {dns.Fqdn(zone)}, "maxitems": {"1"}}
+	buf, err := route53Request(s, http.MethodGet, "/hostedzonesbyname", q, nil)
+	if err != nil {
+		return "", err
+	}
+	var hz route53HostedZones
+	if err := xml.Unmarshal(buf, &hz); err != nil {
+		return "", fmt.Errorf("Malformed response from Route 53: %v", err)
+	}
+	if len(hz.HostedZones) == 0 || !strings.EqualFold(route53Name(hz.HostedZones[0].Name),
+		dns.Fqdn(zone)) {
+		return "", fmt.Errorf("No hosted zone %s at signer %s", zone, s.Name)
+	}
+	return strings.TrimPrefix(hz.HostedZones[0].Id, "/hostedzone/"), nil
+}
+
+// route53Name undoes the octal escapes (e.g. \052 for "*") in names returned by Route 53.
+func route53Name(name string) string {
+	if !strings.Contains(name, `\`) {
+		return dns.Fqdn(name)
+	}
+	var out strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+3 < len(name) {
+			var c int
+			if _, err := fmt.Sscanf(name[i+1:i+4], "%03o", &c); err == nil {
+				out.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		out.WriteByte(name[i])
+	}
+	return dns.Fqdn(out.String())
+}
+
+type route53RRset struct {
+	Name   string   `xml:"Name"`
+	Type   string   `xml:"Type"`
+	TTL    uint32   `xml:"TTL"`
+	Values []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+type route53RRsets struct {
+	XMLName xml.Name       `xml:"ListResourceRecordSetsResponse"`
+	RRsets  []route53RRset `xml:"ResourceRecordSets>ResourceRecordSet"`
+}
+
+type route53Change struct {
+	Action string       `xml:"Action"`
+	RRset  route53RRset `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Comment string          `xml:"ChangeBatch>Comment,omitempty"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+func rdataString(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+func (rs route53RRset) rrs() ([]dns.RR, error) {
+	var rrs []dns.RR
+	for _, v := range rs.Values {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", rs.Name, rs.TTL, rs.Type, v))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse %s %s record from Route 53: %v", rs.Name,
+				rs.Type, err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+func route53FetchRRset(s *Signer, id, owner string, rrtype uint16) ([]dns.RR, error) {
+	owner = dns.Fqdn(owner)
+	q := url.Values{"name": {owner}, "type": {dns.TypeToString[rrtype]}, "maxitems": {"1"}}
+	buf, err := route53Request(s, http.MethodGet, "/hostedzone/"+id+"/rrset", q, nil)
+	if err != nil {
+		return nil, err
+	}
+	var list route53RRsets
+	if err := xml.Unmarshal(buf, &list); err != nil {
+		return nil, fmt.Errorf("Malformed response from Route 53: %v", err)
+	}
+	// the listing starts at owner and rrtype, but the RRset may not exist
+	for _, rs := range list.RRsets {
+		rs.Name = route53Name(rs.Name)
+		if strings.EqualFold(rs.Name, owner) && rs.Type == dns.TypeToString[rrtype] {
+			return rs.rrs()
+		}
+	}
+	return []dns.RR{}, nil
+}
+
+// route53FetchDNSKEYs queries the name servers of the zone for the DNSKEY RRset, which
+// is not available through the API.
+func route53FetchDNSKEYs(s *Signer, id, zone string) ([]dns.RR, error) {
+	nses, err := route53FetchRRset(s, id, zone, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range nses {
+		ns := strings.TrimSuffix(rr.(*dns.NS).Ns, ".")
+		r := AuthoritativeDNSQuery(zone, ns, dns.TypeDNSKEY, false)
+		if r == nil || r.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		return DNSFilterRRsetOnType(r.Answer, dns.TypeDNSKEY), nil
+	}
+	return nil, fmt.Errorf("None of the name servers of %s at signer %s answered for the DNSKEY RRset",
+		zone, s.Name)
+}
+
+func route53ChangeRRsets(s *Signer, id string, changes []route53Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	body, err := xml.Marshal(route53ChangeRequest{Comment: "MUSIC", Changes: changes})
+	if err != nil {
+		return err
+	}
+	_, err = route53Request(s, http.MethodPost, "/hostedzone/"+id+"/rrset/", nil,
+		append([]byte(xml.Header), body...))
+	return err
+}
+
+// route53Changes computes the changes that apply the inserts and removes to the current
+// RRsets at the owner name. fetch returns the current RRset of a type.
+func route53Changes(owner string, inserts, removes [][]dns.RR,
+	fetch func(rrtype uint16) ([]dns.RR, error)) ([]route53Change, error) {
+	type rrsetstate struct {
+		ttl      uint32
+		current  []dns.RR
+		have     map[string]dns.RR
+		order    []string
+		modified bool
+	}
+	states := map[uint16]*rrsetstate{}
+	var types []uint16
+	get := func(rrtype uint16) (*rrsetstate, error) {
+		if st, ok := states[rrtype]; ok {
+			return st, nil
+		}
+		if !route53RRtypes[rrtype] {
+			return nil, fmt.Errorf("Route 53 does not support updates of %s records",
+				dns.TypeToString[rrtype])
+		}
+		current, err := fetch(rrtype)
+		if err != nil {
+			return nil, err
+		}
+		st := &rrsetstate{current: current, have: map[string]dns.RR{}}
+		for _, rr := range current {
+			k := rrCompareKey(rr)
+			if _, ok := st.have[k]; !ok {
+				st.order = append(st.order, k)
+			}
+			st.have[k] = rr
+			st.ttl = rr.Header().Ttl
+		}
+		states[rrtype] = st
+		types = append(types, rrtype)
+		return st, nil
+	}
+
+	for _, rrset := range removes {
+		for _, rr := range rrset {
+			st, err := get(rr.Header().Rrtype)
+			if err != nil {
+				return nil, err
+			}
+			k := rrCompareKey(rr)
+			if _, ok := st.have[k]; ok {
+				delete(st.have, k)
+				st.modified = true
+			}
+		}
+	}
+	for _, rrset := range inserts {
+		for _, rr := range rrset {
+			st, err := get(rr.Header().Rrtype)
+			if err != nil {
+				return nil, err
+			}
+			k := rrCompareKey(rr)
+			if _, ok := st.have[k]; !ok {
+				st.have[k] = rr
+				st.order = append(st.order, k)
+				st.modified = true
+			}
+			if len(st.current) == 0 {
+				st.ttl = rr.Header().Ttl
+			}
+		}
+	}
+
+	var changes []route53Change
+	for _, rrtype := range types {
+		st := states[rrtype]
+		if !st.modified {
+			continue
+		}
+		rs := route53RRset{Name: dns.Fqdn(owner), Type: dns.TypeToString[rrtype], TTL: st.ttl}
+		if len(st.have) == 0 {
+			// a DELETE must match the current RRset exactly
+			for _, rr := range st.current {
+				rs.Values = append(rs.Values, rdataString(rr))
+			}
+			changes = append(changes, route53Change{Action: "DELETE", RRset: rs})
+			continue
+		}
+		for _, k := range st.order {
+			if rr, ok := st.have[k]; ok {
+				rs.Values = append(rs.Values, rdataString(rr))
+			}
+		}
+		changes = append(changes, route53Change{Action: "UPSERT", RRset: rs})
+	}
+	return changes, nil
+}
+
+// route53Hold returns the hold period if err says that the request was throttled.
+func route53Hold(err error) (int, bool) {
+	var te *route53Throttled
+	if errors.As(err, &te) {
+		return te.hold, true
+	}
+	return 0, false
+}
+
+// Route53Update carries out an update (Command "update" or "remove-rrset") and sends
+// the result on udop.Response unless the request was throttled. Returns: rate-limited
+// (bool), hold in seconds (int), error (error) as for the rl updaters.
+func Route53Update(udop SignerOp) (bool, int, error) {
+	s := udop.Signer
+	mdb := s.MusicDB()
+	var ins, rem [][]dns.RR
+	if udop.Inserts != nil {
+		ins = *udop.Inserts
+	}
+	if udop.Removes != nil {
+		rem = *udop.Removes
+	}
+
+	err := mdb.WithProviderZoneID(s, udop.Zone, func(id string) error {
+		fetch := func(rrtype uint16) ([]dns.RR, error) {
+			return route53FetchRRset(s, id, udop.Owner, rrtype)
+		}
+		if udop.Command == "remove-rrset" {
+			var changes []route53Change
+			for _, rrset := range rem {
+				if len(rrset) == 0 {
+					continue
+				}
+				rrtype := rrset[0].Header().Rrtype
+				current, err := fetch(rrtype)
+				if err != nil {
+					return err
+				}
+				c, err := route53Changes(udop.Owner, nil, [][]dns.RR{current},
+					func(uint16) ([]dns.RR, error) { return current, nil })
+				if err != nil {
+					return err
+				}
+				changes = append(changes, c...)
+			}
+			return route53ChangeRRsets(s, id, changes)
+		}
+		changes, err := route53Changes(udop.Owner, ins, rem, fetch)
+		if err != nil {
+			return err
+		}
+		return route53ChangeRRsets(s, id, changes)
+	})
+	if hold, rl := route53Hold(err); rl {
+		return true, hold, nil
+	}
+	udop.Respond(SignerOpResult{Error: err})
+	return false, 0, nil
+}
+
+// Route53FetchRRset fetches an RRset and sends it on fdop.Response unless the request was
+// throttled. Returns as Route53Update.
+func Route53FetchRRset(fdop SignerOp) (bool, int, error) {
+	s := fdop.Signer
+	var rrs []dns.RR
+	err := s.MusicDB().WithProviderZoneID(s, fdop.Zone, func(id string) error {
+		var err error
+		if fdop.RRtype == dns.TypeDNSKEY {
+			rrs, err = route53FetchDNSKEYs(s, id, fdop.Zone)
+		} else {
+			rrs, err = route53FetchRRset(s, id, fdop.Owner, fdop.RRtype)
+		}
+		return err
+	})
+	if hold, rl := route53Hold(err); rl {
+		return true, hold, nil
+	}
+	if err == nil && s.MusicDB() != nil {
+		s.MusicDB().WriteRRs(s, dns.Fqdn(fdop.Owner), fdop.Zone, fdop.RRtype, rrs)
+	}
+	fdop.Respond(SignerOpResult{Error: err, RRs: rrs})
+	return false, 0, nil
+}
+
+// route53Run runs the operation, waiting out throttling for at most
+// signers.route53.maxhold seconds (default 30) in total.
+func route53Run(op SignerOp, f func(SignerOp) (bool, int, error)) SignerOpResult {
+	maxhold := viper.GetInt("signers.route53.maxhold")
+	if maxhold <= 0 {
+		maxhold = 30
+	}
+	op.Response = make(chan SignerOpResult, 1)
+	TrackSignerOp(&op, "route53")
+	held := 0
+	for {
+		op.Running()
+		rl, hold, err := f(op)
+		if err != nil {
+			op.Respond(SignerOpResult{Error: err})
+			return <-op.Response
+		}
+		if !rl {
+			return <-op.Response
+		}
+		if held+hold > maxhold {
+			op.Respond(SignerOpResult{Error: fmt.Errorf("Route 53 signer %s: still throttled after %d seconds",
+				op.Signer.Name, held)})
+			return <-op.Response
+		}
+		log.Printf("Route53: [%s] signer %s throttled. Will hold for %d seconds.", op.ID,
+			op.Signer.Name, hold)
+		op.Held(hold)
+		time.Sleep(time.Duration(hold) * time.Second)
+		held += hold
+	}
+}
+
+func (u *Route53Updater) Update(signer *Signer, zone, owner string, inserts, removes *[][]dns.RR) error {
+	res := route53Run(SignerOp{Command: "update", Signer: signer, Zone: zone, Owner: owner,
+		Inserts: inserts, Removes: removes}, Route53Update)
+	return res.Error
+}
+
+func (u *Route53Updater) RemoveRRset(signer *Signer, zone, owner string, rrsets [][]dns.RR) error {
+	res := route53Run(SignerOp{Command: "remove-rrset", Signer: signer, Zone: zone, Owner: owner,
+		Removes: &rrsets}, Route53Update)
+	return res.Error
+}
+
+func (u *Route53Updater) FetchRRset(s *Signer, zone, owner string, rrtype uint16) (error, []dns.RR) {
+	res := route53Run(SignerOp{Command: "fetch", Signer: s, Zone: zone, Owner: owner,
+		RRtype: rrtype}, Route53FetchRRset)
+	if res.Error != nil {
+		return res.Error, []dns.RR{}
+	}
+	return nil, res.RRs
+}
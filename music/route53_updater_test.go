@@ -0,0 +1,97 @@
+package music
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSignAWSv4(t *testing.T) {
+	// the "get-vanilla" case of the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := route53Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSv4(req, nil, creds, "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestRoute53Name(t *testing.T) {
+	tests := map[string]string{
+		"example.com.":        "example.com.",
+		"example.com":         "example.com.",
+		`\052.example.com.`:   "*.example.com.",
+		`a\100b.example.com.`: "a@b.example.com.",
+		`\\.example.com.`:     `\\.example.com.`,
+	}
+	for in, want := range tests {
+		if got := route53Name(in); got != want {
+			t.Errorf("route53Name(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRoute53Changes(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	current := map[uint16][]dns.RR{
+		dns.TypeNS: {rr("example.com. 3600 IN NS ns1.example.net."),
+			rr("example.com. 3600 IN NS ns2.example.net.")},
+		dns.TypeDS: {rr("child.example.com. 3600 IN DS 1 13 2 " + strings.Repeat("ab", 32))},
+	}
+	fetch := func(rrtype uint16) ([]dns.RR, error) { return current[rrtype], nil }
+
+	changes, err := route53Changes("example.com.",
+		[][]dns.RR{{rr("example.com. 300 IN NS ns3.example.org.")}},
+		[][]dns.RR{{rr("example.com. 3600 IN NS ns1.example.net.")}}, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != "UPSERT" {
+		t.Fatalf("changes = %+v, want one UPSERT", changes)
+	}
+	rs := changes[0].RRset
+	if rs.TTL != 3600 || len(rs.Values) != 2 || rs.Values[0] != "ns2.example.net." ||
+		rs.Values[1] != "ns3.example.org." {
+		t.Errorf("UPSERT RRset = %+v", rs)
+	}
+
+	// removing the last record deletes the RRset
+	changes, err = route53Changes("child.example.com.", nil,
+		[][]dns.RR{current[dns.TypeDS]}, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != "DELETE" || len(changes[0].RRset.Values) != 1 {
+		t.Errorf("changes = %+v, want one DELETE", changes)
+	}
+
+	// nothing to do
+	changes, err = route53Changes("example.com.",
+		[][]dns.RR{{rr("example.com. 3600 IN NS ns1.example.net.")}}, nil, fetch)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("changes = %+v, err = %v, want none", changes, err)
+	}
+
+	_, err = route53Changes("example.com.",
+		[][]dns.RR{{rr("example.com. 3600 IN CDS 1 13 2 " + strings.Repeat("ab", 32))}}, nil, fetch)
+	if err == nil {
+		t.Errorf("CDS update accepted, want error")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/miekg/dns"
 	"log"
+	"sync"
 )
 
 // RRsetEqual compares two RRsets and returns if they are equal or not,
@@ -54,15 +55,37 @@ func SignerRRsetEqual(zone *Zone, rrType uint16) bool {
 	var signerNames []string
 	matches := true
 
-	// Collect RRset for each signer
+	// Collect RRset for each signer. The signers are asked in parallel, so that a slow
+	// (or rate-limited) signer does not add to the time spent on the others.
+	type fetched struct {
+		rrs []dns.RR
+		err error
+	}
+	results := make(map[string]fetched)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
 	for signerName, signer := range zone.SGroup.SignerMap {
 		signerNames = append(signerNames, signerName)
-		updater := GetUpdater(signer.Method)
-		err, rrSet := updater.FetchRRset(signer, zone.Name, zone.Name, rrType)
-		if err != nil {
-			log.Printf("SignerCompare: Error from updater.FetchRRset (signer %s): %v", signer.Name, err)
+		wg.Add(1)
+		go func(signer *Signer) {
+			defer wg.Done()
+			err, rrSet := GetUpdater(signer.Method).FetchRRset(signer, zone.Name, zone.Name, rrType)
+			mu.Lock()
+			results[signer.Name] = fetched{rrSet, err}
+			mu.Unlock()
+		}(signer)
+	}
+	wg.Wait()
+	for _, signerName := range signerNames {
+		signer := zone.SGroup.SignerMap[signerName]
+		res := results[signer.Name]
+		if res.err != nil {
+			log.Printf("SignerCompare: Error from updater.FetchRRset (signer %s): %v", signer.Name, res.err)
+			zone.Explain("fetch RRset", signer.Name, rrType, false, nil, res.err.Error())
+		} else {
+			zone.Explain("fetch RRset", signer.Name, rrType, true, res.rrs, "")
 		}
-		rrSets[signer.Name] = rrSet
+		rrSets[signer.Name] = res.rrs
 	}
 
 	// Check that the RRsets match between the signers.
@@ -70,6 +93,15 @@ func SignerRRsetEqual(zone *Zone, rrType uint16) bool {
 	if numSigners > 1 {
 		for i := numSigners - 1; i > 0; i-- {
 			match, rrSet1Extra, rrSet2Extra := RRsetEqual(rrSets[signerNames[0]], rrSets[signerNames[i]])
+			if zone.Explaining() {
+				var detail string
+				if !match {
+					detail = fmt.Sprintf("%d RRs missing at %s, %d missing at %s (observed: the differing RRs)",
+						len(rrSet1Extra), signerNames[i], len(rrSet2Extra), signerNames[0])
+				}
+				zone.Explain("RRset equal to the one of "+signerNames[0], signerNames[i], rrType, match,
+					append(append([]dns.RR{}, rrSet1Extra...), rrSet2Extra...), detail)
+			}
 			if !match {
 				matches = false
 				if len(rrSet1Extra) > 0 {
@@ -0,0 +1,81 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"github.com/miekg/dns"
+)
+
+// Differential updates. The actions describe the desired state of the RRsets at a signer
+// (the RRs that must be present, the RRs that must be absent, or the exact RRset) rather
+// than the updates to send. ApplyRRsetChanges fetches the current RRsets, computes the
+// minimal inserts and removes and only sends an update if something actually differs.
+// This saves API quota, and it makes the actions safe to re-run after a partial failure.
+// RRs are compared without regard to TTL (see rrCompareKey).
+
+type RRsetChange struct {
+	Owner   string
+	RRtype  uint16
+	Present []dns.RR // RRs that must be in the RRset
+	Absent  []dns.RR // RRs that must not be in the RRset
+	Exact   bool     // the RRset must consist of exactly the Present RRs
+}
+
+// DiffRRset returns the RRs to insert and to remove to bring the observed RRset to the
+// desired state.
+func DiffRRset(c RRsetChange, observed []dns.RR) (inserts, removes []dns.RR) {
+	have := map[string]dns.RR{}
+	var order []string
+	for _, rr := range observed {
+		if rr.Header().Rrtype != c.RRtype {
+			continue
+		}
+		k := rrCompareKey(rr)
+		if _, ok := have[k]; !ok {
+			order = append(order, k)
+		}
+		have[k] = rr
+	}
+
+	want := map[string]bool{}
+	for _, rr := range c.Present {
+		k := rrCompareKey(rr)
+		if want[k] {
+			continue
+		}
+		want[k] = true
+		if _, ok := have[k]; !ok {
+			inserts = append(inserts, rr)
+		}
+	}
+
+	if c.Exact {
+		for _, k := range order {
+			if !want[k] {
+				removes = append(removes, have[k])
+			}
+		}
+		return inserts, removes
+	}
+
+	removed := map[string]bool{}
+	for _, rr := range c.Absent {
+		k := rrCompareKey(rr)
+		if _, ok := have[k]; ok && !removed[k] && !want[k] {
+			removed[k] = true
+			removes = append(removes, have[k])
+		}
+	}
+	return inserts, removes
+}
+
+// ApplyRRsetChanges brings the RRsets at the signer to the desired state, with as few
+// updates as the backend allows (see UpdateBatch) and only if needed. The number of RRs
+// inserted and removed is returned.
+func ApplyRRsetChanges(signer *Signer, zone string, changes []RRsetChange) (int, int, error) {
+	b := NewUpdateBatch(signer, zone)
+	b.Add(changes...)
+	return b.Flush()
+}
@@ -0,0 +1,42 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDiffRRset(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("dns.NewRR(%q): %v", s, err)
+		}
+		return r
+	}
+	ns1 := rr("example.com. 3600 IN NS ns1.example.net.")
+	ns2 := rr("example.com. 3600 IN NS ns2.example.net.")
+	ns2ttl := rr("example.com. 60 IN NS NS2.example.net.")
+	ns3 := rr("example.com. 3600 IN NS ns3.example.net.")
+	observed := []dns.RR{ns1, ns2ttl}
+
+	ins, rems := DiffRRset(RRsetChange{RRtype: dns.TypeNS, Present: []dns.RR{ns2, ns3}}, observed)
+	if len(ins) != 1 || rrCompareKey(ins[0]) != rrCompareKey(ns3) || len(rems) != 0 {
+		t.Errorf("present: got +%v -%v, want +ns3", ins, rems)
+	}
+
+	ins, rems = DiffRRset(RRsetChange{RRtype: dns.TypeNS, Present: []dns.RR{ns2, ns3}, Exact: true}, observed)
+	if len(ins) != 1 || len(rems) != 1 || rrCompareKey(rems[0]) != rrCompareKey(ns1) {
+		t.Errorf("exact: got +%v -%v, want +ns3 -ns1", ins, rems)
+	}
+
+	ins, rems = DiffRRset(RRsetChange{RRtype: dns.TypeNS, Absent: []dns.RR{ns2, ns3}}, observed)
+	if len(ins) != 0 || len(rems) != 1 || rems[0] != ns2ttl {
+		t.Errorf("absent: got +%v -%v, want the observed ns2 removed", ins, rems)
+	}
+
+	ins, rems = DiffRRset(RRsetChange{RRtype: dns.TypeNS, Present: []dns.RR{ns1, ns2}}, observed)
+	if len(ins) != 0 || len(rems) != 0 {
+		t.Errorf("in sync: got +%v -%v, want no change", ins, rems)
+	}
+}
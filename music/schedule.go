@@ -0,0 +1,99 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Scheduled process starts. A zone may be attached to a process immediately but with a
+// start time in the future. Until then the zone is "delayed" and the FSM engine leaves it
+// alone. This makes it possible to queue changes during office hours and have them
+// executed in an approved maintenance window.
+
+// ZoneDelayedUntil returns the time until which the zone is delayed. If the delay has
+// passed it is removed and the zone becomes eligible for the FSM engine again.
+func (mdb *MusicDB) ZoneDelayedUntil(tx *sql.Tx, z *Zone) (time.Time, bool, error) {
+	value, exists, err := mdb.GetMeta(tx, z, "delay-until")
+	if err != nil || !exists || value == "" {
+		return time.Time{}, false, err
+	}
+
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Printf("ZoneDelayedUntil: zone %s: bad delay-until '%s'. Ignoring delay.", z.Name, value)
+	}
+	if err == nil && time.Now().Before(until) {
+		return until, true, nil
+	}
+
+	return time.Time{}, false, mdb.zoneClearDelay(tx, z)
+}
+
+func (mdb *MusicDB) zoneClearDelay(tx *sql.Tx, z *Zone) error {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("zoneClearDelay: Error from mdb.StartTransaction(): %v\n", err)
+		return err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "DELETE FROM metadata WHERE zone=? AND key IN ('delay-reason', 'delay-until')"
+	_, err = tx.Exec(sqlq, z.Name)
+	if CheckSQLError("zoneClearDelay", sqlq, err, false) {
+		return err
+	}
+
+	const sqlq2 = "UPDATE zones SET fsmstatus='' WHERE name=? AND fsmstatus='delayed'"
+	_, err = tx.Exec(sqlq2, z.Name)
+	if CheckSQLError("zoneClearDelay", sqlq2, err, false) {
+		return err
+	}
+	log.Printf("Zone %s is no longer delayed.", z.Name)
+	return nil
+}
+
+// ZoneStartProcess attaches the zone to the process, either immediately (zero at) or
+// at the time at. The process parameters are validated before the process is started.
+func (mdb *MusicDB) ZoneStartProcess(tx *sql.Tx, dbzone *Zone, fsm, fsmsigner string,
+	at time.Time, params map[string]string) (string, error) {
+	process, exist := mdb.FSMlist[fsm]
+	if !exist {
+		return "", fmt.Errorf("Process %s unknown. Sorry.", fsm)
+	}
+	params, err := ValidateProcessParams(process, params)
+	if err != nil {
+		return "", err
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneStartProcess: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	msg, err := mdb.ZoneAttachFsm(tx, dbzone, fsm, fsmsigner, false)
+	if err != nil {
+		return msg, err
+	}
+	if err = mdb.ZoneSetProcessParams(tx, dbzone, params); err != nil {
+		return "", err
+	}
+	if at.IsZero() || !time.Now().Before(at) {
+		return msg, nil
+	}
+
+	reason := fmt.Sprintf("Process '%s' scheduled to start at %s", fsm,
+		at.UTC().Format(time.RFC3339))
+	if _, err = dbzone.SetDelayReason(tx, reason, time.Until(at)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Zone %s has been attached to process '%s', which will start at %s.",
+		dbzone.Name, fsm, at.UTC().Format(time.RFC3339)), nil
+}
@@ -0,0 +1,310 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Scheduler. Things that must happen for a zone at a later time (the end of a delay, the
+// end of a wait state in a process, ...) are scheduled as tasks rather than handled with
+// sleeps and in-memory maps. A task is identified by zone and name (scheduling it again
+// moves it), is stored in the DB so that it survives a restart, and runs at its time:
+// first the function registered for the name (if any), then the FSM engine is asked to
+// check the zone. A task that has run is kept, marked done, until it is cancelled or
+// the zone is deleted, so that e.g. a wait state can tell "waited" from "not started".
+
+type ScheduledTask struct {
+	Zone  string
+	Task  string
+	Param string
+	RunAt time.Time
+	Done  bool
+}
+
+// TaskFunc is run by the scheduler when a task with the registered name is due.
+type TaskFunc func(mdb *MusicDB, t ScheduledTask) error
+
+var taskFuncs = map[string]TaskFunc{
+	"delay-end": func(mdb *MusicDB, t ScheduledTask) error {
+		_, _, err := mdb.ZoneDelayedUntil(nil, &Zone{Name: t.Zone, Exists: true})
+		return err
+	},
+}
+
+// RegisterTask registers the function to run for tasks with the name.
+func RegisterTask(name string, f TaskFunc) {
+	taskFuncs[name] = f
+}
+
+var schedulerWake = make(chan struct{}, 1)
+
+func wakeScheduler() {
+	select {
+	case schedulerWake <- struct{}{}:
+	default:
+	}
+}
+
+// ScheduleTask schedules (or reschedules) the task for the zone at the time at.
+func (mdb *MusicDB) ScheduleTask(tx *sql.Tx, zone, task, param string, at time.Time) error {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ScheduleTask: Error from mdb.StartTransaction(): %v\n", err)
+		return err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	err = recordTask(tx, ScheduledTask{Zone: zone, Task: task, Param: param, RunAt: at})
+	if err == nil {
+		wakeScheduler()
+	}
+	return err
+}
+
+func recordTask(tx *sql.Tx, t ScheduledTask) error {
+	const sqlq = `
+INSERT OR REPLACE INTO scheduled_tasks(zone, task, param, runat, done) VALUES (?, ?, ?, ?, 0)`
+	_, err := tx.Exec(sqlq, t.Zone, t.Task, t.Param, t.RunAt.UTC().Format(layout))
+	CheckSQLError("ScheduleTask", sqlq, err, false)
+	return err
+}
+
+// CancelTask removes the task for the zone, whether it has run or not.
+func (mdb *MusicDB) CancelTask(tx *sql.Tx, zone, task string) error {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("CancelTask: Error from mdb.StartTransaction(): %v\n", err)
+		return err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "DELETE FROM scheduled_tasks WHERE zone=? AND task=?"
+	_, err = tx.Exec(sqlq, zone, task)
+	CheckSQLError("CancelTask", sqlq, err, false)
+	return err
+}
+
+// ScheduleTaskAsync and CancelTaskAsync are for use while the FSM engine holds its
+// transaction open (i.e. from pre- and post-conditions and actions).
+func (mdb *MusicDB) ScheduleTaskAsync(zone, task, param string, at time.Time) {
+	buf, _ := json.Marshal(ScheduledTask{Zone: zone, Task: task, Param: param, RunAt: at})
+	mdb.UpdateC <- DBUpdate{Type: "SCHEDULE", Zone: zone, Key: task, Value: string(buf)}
+}
+
+func (mdb *MusicDB) CancelTaskAsync(zone, task string) {
+	mdb.UpdateC <- DBUpdate{Type: "SCHEDULE", Zone: zone, Key: task}
+}
+
+// RecordScheduledTask applies a queued "SCHEDULE" update to the DB. An empty value
+// cancels the task.
+func (mdb *MusicDB) RecordScheduledTask(tx *sql.Tx, u DBUpdate) error {
+	if u.Value == "" {
+		const sqlq = "DELETE FROM scheduled_tasks WHERE zone=? AND task=?"
+		_, err := tx.Exec(sqlq, u.Zone, u.Key)
+		return err
+	}
+	var t ScheduledTask
+	if err := json.Unmarshal([]byte(u.Value), &t); err != nil {
+		return fmt.Errorf("RecordScheduledTask: malformed task: %v", err)
+	}
+	err := recordTask(tx, t)
+	if err == nil {
+		wakeScheduler()
+	}
+	return err
+}
+
+// GetTask returns the task for the zone, if there is one. It does not use a transaction
+// and is safe to call while the FSM engine holds its transaction open.
+func (mdb *MusicDB) GetTask(zone, task string) (ScheduledTask, bool, error) {
+	t := ScheduledTask{Zone: zone, Task: task}
+	var runat string
+	const sqlq = "SELECT param, runat, done FROM scheduled_tasks WHERE zone=? AND task=?"
+	err := mdb.db.QueryRow(sqlq, zone, task).Scan(&t.Param, &runat, &t.Done)
+	switch {
+	case err == sql.ErrNoRows:
+		return t, false, nil
+	case err != nil:
+		CheckSQLError("GetTask", sqlq, err, false)
+		return t, false, err
+	}
+	t.RunAt, _ = time.Parse(layout, runat)
+	return t, true, nil
+}
+
+// ListScheduledTasks returns the tasks of the zone (all zones if zone is ""), by time.
+func (mdb *MusicDB) ListScheduledTasks(tx *sql.Tx, zone string) ([]ScheduledTask, error) {
+	var tasks []ScheduledTask
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListScheduledTasks: Error from mdb.StartTransaction(): %v\n", err)
+		return tasks, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT zone, task, param, runat, done FROM scheduled_tasks WHERE ? = '' OR zone = ?
+ORDER BY runat, zone, task`
+	rows, err := tx.Query(sqlq, zone, zone)
+	if CheckSQLError("ListScheduledTasks", sqlq, err, false) {
+		return tasks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t ScheduledTask
+		var runat string
+		if err := rows.Scan(&t.Zone, &t.Task, &t.Param, &runat, &t.Done); err != nil {
+			log.Fatalf("ListScheduledTasks: Error from rows.Scan(): %v", err)
+		}
+		t.RunAt, _ = time.Parse(layout, runat)
+		tasks = append(tasks, t)
+	}
+	return tasks, nil
+}
+
+// dueTasks marks the tasks that are due as done and returns them, together with the
+// time of the next task that is not yet due (zero if there is none).
+func (mdb *MusicDB) dueTasks() ([]ScheduledTask, time.Time, error) {
+	var due []ScheduledTask
+	var next time.Time
+
+	localtx, tx, err := mdb.StartTransaction(nil)
+	if err != nil {
+		log.Printf("dueTasks: Error from mdb.StartTransaction(): %v\n", err)
+		return due, next, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	now := time.Now().UTC().Format(layout)
+	const sqlq = `
+SELECT t.zone, t.task, t.param, t.runat, COALESCE(z.sgroup, '') FROM scheduled_tasks t
+LEFT JOIN zones z ON z.name = t.zone WHERE t.done=0 AND t.runat <= ?`
+	rows, err := tx.Query(sqlq, now)
+	if CheckSQLError("dueTasks", sqlq, err, false) {
+		return due, next, err
+	}
+	others := false // due tasks of zones owned by other musicd instances, see sharding.go
+	for rows.Next() {
+		var t ScheduledTask
+		var runat, sgroup string
+		if err := rows.Scan(&t.Zone, &t.Task, &t.Param, &runat, &sgroup); err != nil {
+			log.Fatalf("dueTasks: Error from rows.Scan(): %v", err)
+		}
+		t.RunAt, _ = time.Parse(layout, runat)
+		if !OwnsZone(t.Zone, sgroup) {
+			others = true
+			continue
+		}
+		due = append(due, t)
+	}
+	rows.Close()
+
+	const sqlq2 = "UPDATE scheduled_tasks SET done=1 WHERE zone=? AND task=?"
+	for _, t := range due {
+		_, err = tx.Exec(sqlq2, t.Zone, t.Task)
+		if CheckSQLError("dueTasks", sqlq2, err, false) {
+			return due, next, err
+		}
+	}
+
+	var runat sql.NullString
+	const sqlq3 = "SELECT MIN(runat) FROM scheduled_tasks WHERE done=0 AND runat > ?"
+	err = tx.QueryRow(sqlq3, now).Scan(&runat)
+	if CheckSQLError("dueTasks", sqlq3, err, false) {
+		return due, next, err
+	}
+	if runat.Valid {
+		next, _ = time.Parse(layout, runat.String)
+	}
+	if others {
+		// look again after the next heartbeat, in case the zones are rebalanced to us
+		if recheck := time.Now().Add(ShardHeartbeatInterval()); next.IsZero() || recheck.Before(next) {
+			next = recheck
+		}
+	}
+	return due, next, nil
+}
+
+// RunScheduler runs the tasks as they become due, until stopch is closed.
+func (mdb *MusicDB) RunScheduler(enginecheck chan EngineCheck, stopch chan struct{}) {
+	log.Printf("Starting Scheduler")
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-timer.C:
+		case <-schedulerWake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-stopch:
+			timer.Stop()
+			log.Println("Scheduler: stop signal received.")
+			return
+		}
+
+		due, next, err := mdb.dueTasks()
+		if err != nil {
+			log.Printf("Scheduler: Error from dueTasks: %v", err)
+			next = time.Now().Add(time.Minute) // try again later
+		}
+		for _, t := range due {
+			if f, ok := taskFuncs[t.Task]; ok {
+				if err := f(mdb, t); err != nil {
+					log.Printf("Scheduler: zone %s: task %s failed: %v", t.Zone, t.Task, err)
+					ReportError("scheduler", err, map[string]string{"zone": t.Zone, "task": t.Task})
+				}
+			}
+			if t.Zone != "" {
+				enginecheck <- EngineCheck{ZoneName: t.Zone}
+			}
+		}
+
+		wait := time.Hour // nothing scheduled; ScheduleTask wakes us up
+		if !next.IsZero() {
+			wait = time.Until(next)
+			if wait < 0 {
+				wait = 0
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// WaitUntil starts the wait state name of the zone, which ends at until. Safe to call
+// while the FSM engine holds its transaction open.
+func (z *Zone) WaitUntil(name string, until time.Time) {
+	if z.Explaining() {
+		z.Explain("start wait "+name, "", 0, true, nil, "would wait until "+until.Format(time.RFC3339))
+		return
+	}
+	z.MusicDB.ScheduleTaskAsync(z.Name, name, "", until)
+}
+
+// Waiting returns the end of the wait state name of the zone, if it has been started.
+func (z *Zone) Waiting(name string) (time.Time, bool) {
+	t, exists, err := z.MusicDB.GetTask(z.Name, name)
+	if err != nil || !exists {
+		return time.Time{}, false
+	}
+	return t.RunAt, true
+}
+
+// EndWait ends the wait state name of the zone.
+func (z *Zone) EndWait(name string) {
+	if z.Explaining() {
+		return
+	}
+	z.MusicDB.CancelTaskAsync(z.Name, name)
+}
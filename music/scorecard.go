@@ -0,0 +1,308 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Zone scorecard. A summary of the multi-signer health of a zone, for customer portals
+// and the like: every item is "pass", "warn" or "fail", and the zone gets the worst
+// result of its items. The scorecard is computed from live queries to the signers and
+// the parent when asked for; nothing is stored.
+
+const (
+	ScorePass = "pass"
+	ScoreWarn = "warn"
+	ScoreFail = "fail"
+)
+
+type ScorecardItem struct {
+	Check  string
+	Result string
+	Detail string
+}
+
+type Scorecard struct {
+	Zone   string
+	Time   time.Time
+	Result string
+	Items  []ScorecardItem
+}
+
+var scoreRank = map[string]int{ScorePass: 0, ScoreWarn: 1, ScoreFail: 2}
+
+func worstScore(items []ScorecardItem) string {
+	res := ScorePass
+	for _, i := range items {
+		if scoreRank[i.Result] > scoreRank[res] {
+			res = i.Result
+		}
+	}
+	return res
+}
+
+// rrsigWarn is how long before expiry an RRSIG is considered stale.
+func rrsigWarn() time.Duration {
+	days := viper.GetInt("scorecard.rrsigwarn")
+	if days <= 0 {
+		days = 3
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// rrsigFreshness scores the validity period of sig at the time now.
+func rrsigFreshness(sig *dns.RRSIG, now time.Time, warn time.Duration) (string, string) {
+	incep := time.Unix(int64(sig.Inception), 0)
+	expir := time.Unix(int64(sig.Expiration), 0)
+	switch {
+	case now.Before(incep):
+		return ScoreFail, fmt.Sprintf("not valid until %s", incep.UTC().Format(time.RFC3339))
+	case !now.Before(expir):
+		return ScoreFail, fmt.Sprintf("expired at %s", expir.UTC().Format(time.RFC3339))
+	case expir.Sub(now) < warn:
+		return ScoreWarn, fmt.Sprintf("expires in %s", expir.Sub(now).Round(time.Minute))
+	}
+	return ScorePass, fmt.Sprintf("valid until %s", expir.UTC().Format(time.RFC3339))
+}
+
+// ZoneScorecard computes the scorecard of the zone.
+func (mdb *MusicDB) ZoneScorecard(z *Zone) (*Scorecard, error) {
+	if !z.Exists {
+		return nil, fmt.Errorf("Zone %s unknown", z.Name)
+	}
+	if z.SGname == "" || z.SGname == "---" {
+		return nil, fmt.Errorf("Zone %s is not assigned to any signer group", z.Name)
+	}
+	sg, err := mdb.GetSignerGroup(nil, z.SGname, false)
+	if err != nil {
+		return nil, err
+	}
+	return mdb.groupScorecard(z, sg)
+}
+
+// groupScorecard computes the scorecard of the zone as served by the signers of sg,
+// which need not (yet) be the signer group of the zone.
+func (mdb *MusicDB) groupScorecard(z *Zone, sg *SignerGroup) (*Scorecard, error) {
+	if len(sg.SignerMap) == 0 {
+		return nil, fmt.Errorf("Signer group %s has no signers", sg.Name)
+	}
+
+	var signers []*Signer
+	for _, s := range sg.SignerMap {
+		signers = append(signers, s)
+	}
+	sort.Slice(signers, func(i, j int) bool { return signers[i].Name < signers[j].Name })
+
+	fetch := func(rrtype uint16) (map[string][]dns.RR, []string) {
+		rrsets := map[string][]dns.RR{}
+		var errs []string
+		for _, s := range signers {
+			err, rrs := GetUpdater(s.Method).FetchRRset(s, z.Name, z.Name, rrtype)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", s.Name, err))
+				continue
+			}
+			rrsets[s.Name] = rrs
+		}
+		return rrsets, errs
+	}
+
+	sc := &Scorecard{Zone: z.Name, Time: time.Now()}
+	dnskeys, errs := fetch(dns.TypeDNSKEY)
+	sc.Items = append(sc.Items, consistencyItem("dnskeys", "DNSKEY", signers, dnskeys, errs))
+	nses, errs := fetch(dns.TypeNS)
+	sc.Items = append(sc.Items, consistencyItem("ns", "NS", signers, nses, errs))
+	cdses, errs := fetch(dns.TypeCDS)
+	sc.Items = append(sc.Items, mdb.dsItem(z, dnskeys, cdses, errs))
+	sc.Items = append(sc.Items, rrsigItem(z, signers, time.Now()))
+	csyncs, errs := fetch(dns.TypeCSYNC)
+	sc.Items = append(sc.Items, csyncItem(z, csyncs, errs))
+
+	sc.Result = worstScore(sc.Items)
+	return sc, nil
+}
+
+// consistencyItem checks that the RRset is the same on all signers.
+func consistencyItem(check, rrtype string, signers []*Signer, rrsets map[string][]dns.RR,
+	errs []string) ScorecardItem {
+	if len(errs) > 0 {
+		return ScorecardItem{check, ScoreFail, "fetch failed: " + strings.Join(errs, "; ")}
+	}
+	first := signers[0].Name
+	if len(rrsets[first]) == 0 {
+		return ScorecardItem{check, ScoreFail, fmt.Sprintf("no %s RRset at %s", rrtype, first)}
+	}
+	var diffs []string
+	for _, s := range signers[1:] {
+		if equal, _, _ := RRsetEqual(rrsets[first], rrsets[s.Name]); !equal {
+			diffs = append(diffs, s.Name)
+		}
+	}
+	if len(diffs) > 0 {
+		return ScorecardItem{check, ScoreFail, fmt.Sprintf("%s RRset at %s differs from %s",
+			rrtype, strings.Join(diffs, ", "), first)}
+	}
+	return ScorecardItem{check, ScorePass, fmt.Sprintf("%d %s RRs, the same on all %d signers",
+		len(rrsets[first]), rrtype, len(signers))}
+}
+
+// dsItem checks the DS RRset in the parent against the CDS RRsets published by the
+// signers, or against the KSKs when no CDS is published.
+func (mdb *MusicDB) dsItem(z *Zone, dnskeys, cdses map[string][]dns.RR, errs []string) ScorecardItem {
+	const check = "cds-ds"
+	if !z.Type().ParentSignals {
+		return ScorecardItem{check, ScorePass, fmt.Sprintf("zone type %s does not signal the parent", z.ZoneType)}
+	}
+	if len(errs) > 0 {
+		return ScorecardItem{check, ScoreFail, "CDS fetch failed: " + strings.Join(errs, "; ")}
+	}
+	parent, exist, err := mdb.GetMeta(nil, z, "parentaddr")
+	if err != nil || !exist {
+		return ScorecardItem{check, ScoreWarn, "no parent address registered, DS not checked"}
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(z.Name, dns.TypeDS)
+	r, _, err := DnsExchange(new(dns.Client), m, parent)
+	if err != nil {
+		return ScorecardItem{check, ScoreWarn, fmt.Sprintf("DS query to parent failed: %v", err)}
+	}
+	dses := map[string]bool{}
+	for _, rr := range r.Answer {
+		if ds, ok := rr.(*dns.DS); ok {
+			dses[dsKey(ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)] = true
+		}
+	}
+
+	cdsset := map[string]bool{}
+	for _, rrs := range cdses {
+		for _, rr := range rrs {
+			if cds, ok := rr.(*dns.CDS); ok {
+				cdsset[dsKey(cds.KeyTag, cds.Algorithm, cds.DigestType, cds.Digest)] = true
+			}
+		}
+	}
+	if len(cdsset) > 0 {
+		if missing, extra := setDiff(cdsset, dses), setDiff(dses, cdsset); len(missing)+len(extra) > 0 {
+			return ScorecardItem{check, ScoreWarn, fmt.Sprintf(
+				"DS in parent does not match CDS yet (missing: %d, not in CDS: %d)", len(missing), len(extra))}
+		}
+		return ScorecardItem{check, ScorePass, fmt.Sprintf("%d DS in parent match the CDS", len(dses))}
+	}
+
+	// no CDS published: every DS must match a KSK at the signers
+	if len(dses) == 0 {
+		return ScorecardItem{check, ScoreFail, "no DS in parent"}
+	}
+	ksks := map[uint16]*dns.DNSKEY{}
+	for _, rrs := range dnskeys {
+		for _, rr := range rrs {
+			if k, ok := rr.(*dns.DNSKEY); ok && k.Flags&dns.SEP != 0 {
+				ksks[k.KeyTag()] = k
+			}
+		}
+	}
+	var unmatched []string
+	for _, rr := range r.Answer {
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			continue
+		}
+		var kds *dns.DS
+		if k, exist := ksks[ds.KeyTag]; exist {
+			kds = k.ToDS(ds.DigestType)
+		}
+		if kds == nil || !strings.EqualFold(kds.Digest, ds.Digest) {
+			unmatched = append(unmatched, fmt.Sprintf("%d", ds.KeyTag))
+		}
+	}
+	if len(unmatched) > 0 {
+		return ScorecardItem{check, ScoreFail, fmt.Sprintf("DS in parent without a matching KSK: %s",
+			strings.Join(unmatched, ", "))}
+	}
+	return ScorecardItem{check, ScorePass, fmt.Sprintf("%d DS in parent match KSKs at the signers", len(dses))}
+}
+
+// setDiff returns the keys in a that are not in b.
+func setDiff(a, b map[string]bool) []string {
+	var res []string
+	for k := range a {
+		if !b[k] {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// rrsigItem checks the RRSIGs over the SOA RRset at every signer.
+func rrsigItem(z *Zone, signers []*Signer, now time.Time) ScorecardItem {
+	item := ScorecardItem{Check: "rrsig", Result: ScorePass}
+	var details []string
+	note := func(result, detail string) {
+		if scoreRank[result] > scoreRank[item.Result] {
+			item.Result = result
+		}
+		details = append(details, detail)
+	}
+	for _, s := range signers {
+		if s.Address == "" {
+			note(ScoreWarn, fmt.Sprintf("%s: no address, not checked", s.Name))
+			continue
+		}
+		m := new(dns.Msg)
+		m.SetQuestion(z.Name, dns.TypeSOA)
+		m.SetEdns0(4096, true)
+		r, _, err := s.Exchange(new(dns.Client), m)
+		if err != nil {
+			note(ScoreFail, fmt.Sprintf("%s: %v", s.Name, err))
+			continue
+		}
+		var sig *dns.RRSIG
+		for _, rr := range r.Answer {
+			if rs, ok := rr.(*dns.RRSIG); ok && rs.TypeCovered == dns.TypeSOA {
+				if sig == nil || rs.Expiration < sig.Expiration {
+					sig = rs
+				}
+			}
+		}
+		if sig == nil {
+			note(ScoreFail, fmt.Sprintf("%s: SOA is not signed", s.Name))
+			continue
+		}
+		result, detail := rrsigFreshness(sig, now, rrsigWarn())
+		note(result, fmt.Sprintf("%s: %s", s.Name, detail))
+	}
+	item.Detail = strings.Join(details, "; ")
+	return item
+}
+
+// csyncItem checks that no CSYNC is published unless the zone is in a process.
+func csyncItem(z *Zone, csyncs map[string][]dns.RR, errs []string) ScorecardItem {
+	const check = "csync"
+	if len(errs) > 0 {
+		return ScorecardItem{check, ScoreWarn, "CSYNC fetch failed: " + strings.Join(errs, "; ")}
+	}
+	var with []string
+	for signer, rrs := range csyncs {
+		if len(rrs) > 0 {
+			with = append(with, signer)
+		}
+	}
+	sort.Strings(with)
+	switch {
+	case len(with) == 0:
+		return ScorecardItem{check, ScorePass, "no CSYNC published"}
+	case z.FSM != "":
+		return ScorecardItem{check, ScorePass, fmt.Sprintf("CSYNC published during process %s", z.FSM)}
+	}
+	return ScorecardItem{check, ScoreWarn, fmt.Sprintf("CSYNC published at %s while the zone is idle",
+		strings.Join(with, ", "))}
+}
@@ -0,0 +1,50 @@
+package music
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRRsigFreshness(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	day := int64(24 * 3600)
+	sig := func(incep, expir int64) *dns.RRSIG {
+		return &dns.RRSIG{Inception: uint32(now.Unix() + incep), Expiration: uint32(now.Unix() + expir)}
+	}
+	for _, tc := range []struct {
+		sig  *dns.RRSIG
+		want string
+	}{
+		{sig(-day, 14*day), ScorePass},
+		{sig(-day, day), ScoreWarn},
+		{sig(-14*day, -day), ScoreFail},
+		{sig(day, 14*day), ScoreFail},
+	} {
+		if got, detail := rrsigFreshness(tc.sig, now, 3*24*time.Hour); got != tc.want {
+			t.Errorf("rrsigFreshness(%d-%d) = %s (%s), want %s", tc.sig.Inception, tc.sig.Expiration,
+				got, detail, tc.want)
+		}
+	}
+}
+
+func TestScorecardItems(t *testing.T) {
+	items := []ScorecardItem{{"a", ScorePass, ""}, {"b", ScoreWarn, ""}}
+	if got := worstScore(items); got != ScoreWarn {
+		t.Errorf("worstScore = %s, want %s", got, ScoreWarn)
+	}
+	if got := worstScore(append(items, ScorecardItem{"c", ScoreFail, ""})); got != ScoreFail {
+		t.Errorf("worstScore = %s, want %s", got, ScoreFail)
+	}
+
+	z := &Zone{Name: "example.com."}
+	csync, _ := dns.NewRR("example.com. 3600 IN CSYNC 1 3 A NS AAAA")
+	if i := csyncItem(z, map[string][]dns.RR{"s1": nil, "s2": {csync}}, nil); i.Result != ScoreWarn {
+		t.Errorf("CSYNC while idle: %v", i)
+	}
+	z.FSM = "add-signer"
+	if i := csyncItem(z, map[string][]dns.RR{"s2": {csync}}, nil); i.Result != ScorePass {
+		t.Errorf("CSYNC during a process: %v", i)
+	}
+}
@@ -0,0 +1,356 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signer group snapshots. Every change to the composition of a signer group (or to the
+// settings of one of its signers) records a snapshot of the group. Two snapshots (or a
+// snapshot and the current configuration) can be compared, and the group can be rolled
+// back to an earlier snapshot. A rollback changes the signer settings directly, while
+// changes to the composition are made via the normal join and leave processes.
+
+type SignerSnapshot struct {
+	Name    string
+	Method  string
+	Address string
+	Port    string
+	UseTcp  bool
+	UseTSIG bool
+	AuthStr string // not sent via the API
+}
+
+type SignerGroupSnapshot struct {
+	ID              int
+	Group           string
+	Time            time.Time
+	Reason          string
+	Signers         []SignerSnapshot
+	PendingAddition string
+	PendingRemoval  string
+}
+
+// apiSafe returns a copy without any secrets.
+func (ss SignerGroupSnapshot) apiSafe() SignerGroupSnapshot {
+	signers := make([]SignerSnapshot, len(ss.Signers))
+	for i, s := range ss.Signers {
+		s.AuthStr = ""
+		signers[i] = s
+	}
+	ss.Signers = signers
+	return ss
+}
+
+func (mdb *MusicDB) currentSignerGroupSnapshot(tx *sql.Tx, group string) (*SignerGroupSnapshot, error) {
+	sg, err := mdb.GetSignerGroup(tx, group, false) // not apisafe
+	if err != nil {
+		return nil, err
+	}
+	if sg.Name == "" {
+		return nil, fmt.Errorf("Signer group %s is unknown.", group)
+	}
+	signers, err := mdb.GetGroupSigners(tx, group, false) // not apisafe
+	if err != nil {
+		return nil, err
+	}
+
+	ss := SignerGroupSnapshot{
+		Group:           group,
+		Time:            time.Now(),
+		PendingAddition: sg.PendingAddition,
+		PendingRemoval:  sg.PendingRemoval,
+	}
+	for _, s := range signers {
+		ss.Signers = append(ss.Signers, SignerSnapshot{
+			Name:    s.Name,
+			Method:  s.Method,
+			Address: s.Address,
+			Port:    s.Port,
+			UseTcp:  s.UseTcp,
+			UseTSIG: s.UseTSIG,
+			AuthStr: s.AuthStr,
+		})
+	}
+	sort.Slice(ss.Signers, func(i, j int) bool { return ss.Signers[i].Name < ss.Signers[j].Name })
+	return &ss, nil
+}
+
+// SnapshotSignerGroup records the current configuration of the group, unless it is
+// identical to the latest snapshot.
+func (mdb *MusicDB) SnapshotSignerGroup(tx *sql.Tx, group, reason string) error {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SnapshotSignerGroup: Error from mdb.StartTransaction(): %v\n", err)
+		return err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	ss, err := mdb.currentSignerGroupSnapshot(tx, group)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(ss)
+	if err != nil {
+		return err
+	}
+
+	if latest, err := mdb.latestSignerGroupSnapshot(tx, group); err == nil && latest != nil {
+		latest.ID, latest.Time, latest.Reason = 0, ss.Time, ss.Reason
+		if lbuf, _ := json.Marshal(latest); string(lbuf) == string(buf) {
+			return nil
+		}
+	}
+
+	const sqlq = "INSERT INTO signergroup_snapshots(sgroup, stamp, reason, snapshot) VALUES (?, ?, ?, ?)"
+	_, err = tx.Exec(sqlq, group, ss.Time.UTC().Format(time.RFC3339), reason, string(buf))
+	if CheckSQLError("SnapshotSignerGroup", sqlq, err, false) {
+		return err
+	}
+	return nil
+}
+
+func scanSignerGroupSnapshot(id int, reason, data string) (*SignerGroupSnapshot, error) {
+	var ss SignerGroupSnapshot
+	if err := json.Unmarshal([]byte(data), &ss); err != nil {
+		return nil, fmt.Errorf("Snapshot %d is corrupt: %v", id, err)
+	}
+	ss.ID, ss.Reason = id, reason
+	return &ss, nil
+}
+
+func (mdb *MusicDB) latestSignerGroupSnapshot(tx *sql.Tx, group string) (*SignerGroupSnapshot, error) {
+	const sqlq = `
+SELECT id, reason, snapshot FROM signergroup_snapshots WHERE sgroup=? ORDER BY id DESC LIMIT 1`
+	var id int
+	var reason, data string
+	err := tx.QueryRow(sqlq, group).Scan(&id, &reason, &data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if CheckSQLError("latestSignerGroupSnapshot", sqlq, err, false) {
+		return nil, err
+	}
+	return scanSignerGroupSnapshot(id, reason, data)
+}
+
+func (mdb *MusicDB) getSignerGroupSnapshot(tx *sql.Tx, group string, id int) (*SignerGroupSnapshot, error) {
+	const sqlq = "SELECT reason, snapshot FROM signergroup_snapshots WHERE sgroup=? AND id=?"
+	var reason, data string
+	err := tx.QueryRow(sqlq, group, id).Scan(&reason, &data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("Signer group %s has no snapshot %d.", group, id)
+	}
+	if CheckSQLError("getSignerGroupSnapshot", sqlq, err, false) {
+		return nil, err
+	}
+	return scanSignerGroupSnapshot(id, reason, data)
+}
+
+// ListSignerGroupSnapshots returns the snapshots of the group, oldest first and without secrets.
+func (mdb *MusicDB) ListSignerGroupSnapshots(tx *sql.Tx, group string) ([]SignerGroupSnapshot, error) {
+	var snapshots []SignerGroupSnapshot
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListSignerGroupSnapshots: Error from mdb.StartTransaction(): %v\n", err)
+		return snapshots, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT id, reason, snapshot FROM signergroup_snapshots WHERE sgroup=? ORDER BY id"
+	rows, err := tx.Query(sqlq, group)
+	if CheckSQLError("ListSignerGroupSnapshots", sqlq, err, false) {
+		return snapshots, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var reason, data string
+		if err := rows.Scan(&id, &reason, &data); err != nil {
+			log.Fatalf("ListSignerGroupSnapshots: Error from rows.Scan(): %v", err)
+		}
+		ss, err := scanSignerGroupSnapshot(id, reason, data)
+		if err != nil {
+			return snapshots, err
+		}
+		snapshots = append(snapshots, ss.apiSafe())
+	}
+	return snapshots, nil
+}
+
+// diffSignerGroupSnapshots describes the changes needed to go from a to b.
+func diffSignerGroupSnapshots(a, b *SignerGroupSnapshot) []string {
+	var diff []string
+	am := map[string]SignerSnapshot{}
+	for _, s := range a.Signers {
+		am[s.Name] = s
+	}
+	bm := map[string]SignerSnapshot{}
+	for _, s := range b.Signers {
+		bm[s.Name] = s
+	}
+
+	for _, s := range a.Signers {
+		if _, ok := bm[s.Name]; !ok {
+			diff = append(diff, fmt.Sprintf("- signer %s", s.Name))
+		}
+	}
+	for _, bs := range b.Signers {
+		as, ok := am[bs.Name]
+		if !ok {
+			diff = append(diff, fmt.Sprintf("+ signer %s (%s, %s)", bs.Name, bs.Method, bs.Address))
+			continue
+		}
+		change := func(what, from, to string) {
+			if from != to {
+				diff = append(diff, fmt.Sprintf("~ signer %s: %s %s -> %s", bs.Name, what, from, to))
+			}
+		}
+		change("method", as.Method, bs.Method)
+		change("address", as.Address, bs.Address)
+		change("port", as.Port, bs.Port)
+		change("usetcp", fmt.Sprintf("%v", as.UseTcp), fmt.Sprintf("%v", bs.UseTcp))
+		change("usetsig", fmt.Sprintf("%v", as.UseTSIG), fmt.Sprintf("%v", bs.UseTSIG))
+		if as.AuthStr != bs.AuthStr {
+			diff = append(diff, fmt.Sprintf("~ signer %s: TSIG key changed", bs.Name))
+		}
+	}
+	if a.PendingAddition != b.PendingAddition {
+		diff = append(diff, fmt.Sprintf("~ pending addition: '%s' -> '%s'", a.PendingAddition,
+			b.PendingAddition))
+	}
+	if a.PendingRemoval != b.PendingRemoval {
+		diff = append(diff, fmt.Sprintf("~ pending removal: '%s' -> '%s'", a.PendingRemoval,
+			b.PendingRemoval))
+	}
+	return diff
+}
+
+// DiffSignerGroupSnapshots compares snapshot from with snapshot to (0 = the current
+// configuration).
+func (mdb *MusicDB) DiffSignerGroupSnapshots(tx *sql.Tx, group string, from, to int) ([]string, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("DiffSignerGroupSnapshots: Error from mdb.StartTransaction(): %v\n", err)
+		return nil, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	a, err := mdb.getSignerGroupSnapshot(tx, group, from)
+	if err != nil {
+		return nil, err
+	}
+	var b *SignerGroupSnapshot
+	if to == 0 {
+		b, err = mdb.currentSignerGroupSnapshot(tx, group)
+	} else {
+		b, err = mdb.getSignerGroupSnapshot(tx, group, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return diffSignerGroupSnapshots(a, b), nil
+}
+
+// RollbackSignerGroup returns the group to the configuration in snapshot id. Signer
+// settings are restored immediately. As a signer group only runs one process at a time,
+// at most one signer joins or leaves the group per call; the rollback must be repeated
+// once that process is complete.
+func (mdb *MusicDB) RollbackSignerGroup(tx *sql.Tx, group string, id int) (string, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("RollbackSignerGroup: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	target, err := mdb.getSignerGroupSnapshot(tx, group, id)
+	if err != nil {
+		return "", err
+	}
+	current, err := mdb.currentSignerGroupSnapshot(tx, group)
+	if err != nil {
+		return "", err
+	}
+
+	var msgs []string
+	cur := map[string]SignerSnapshot{}
+	for _, s := range current.Signers {
+		cur[s.Name] = s
+	}
+	want := map[string]bool{}
+	var joins []SignerSnapshot
+	for _, ts := range target.Signers {
+		if ts.Name == target.PendingRemoval {
+			continue // was on its way out
+		}
+		want[ts.Name] = true
+
+		dbsigner, err := mdb.GetSignerByName(tx, ts.Name, false)
+		if err != nil {
+			return "", fmt.Errorf("Signer %s in snapshot %d no longer exists. Add it again before rolling back.",
+				ts.Name, id)
+		}
+		if dbsigner.Method != ts.Method || dbsigner.Address != ts.Address ||
+			dbsigner.Port != ts.Port || dbsigner.AuthStr != ts.AuthStr ||
+			dbsigner.UseTcp != ts.UseTcp || dbsigner.UseTSIG != ts.UseTSIG {
+			us := Signer{Method: ts.Method, Address: ts.Address, Port: ts.Port,
+				UseTcp: ts.UseTcp, UseTSIG: ts.UseTSIG}
+			us.Auth = ParseAuthStr(ts.AuthStr)
+			if _, err = mdb.UpdateSigner(tx, dbsigner, us); err != nil {
+				return "", err
+			}
+			msgs = append(msgs, fmt.Sprintf("Signer %s: settings restored.", ts.Name))
+		}
+		if _, member := cur[ts.Name]; !member {
+			joins = append(joins, ts)
+		}
+	}
+	var leaves []string
+	for _, s := range current.Signers {
+		if !want[s.Name] && s.Name != current.PendingRemoval {
+			leaves = append(leaves, s.Name)
+		}
+	}
+
+	// joins first, so that the group never runs out of signers
+	pending := len(joins) + len(leaves)
+	if len(joins) > 0 {
+		dbsigner, _ := mdb.GetSignerByName(tx, joins[0].Name, false)
+		msg, err := mdb.SignerJoinGroup(tx, dbsigner, group)
+		if err != nil {
+			return "", err
+		}
+		msgs = append(msgs, msg)
+	} else if len(leaves) > 0 {
+		dbsigner, err := mdb.GetSignerByName(tx, leaves[0], false)
+		if err != nil {
+			return "", err
+		}
+		msg, err := mdb.SignerLeaveGroup(tx, dbsigner, group)
+		if err != nil {
+			return "", err
+		}
+		msgs = append(msgs, msg)
+	}
+	if pending > 1 {
+		msgs = append(msgs, fmt.Sprintf("%d more signer changes remain. Repeat the rollback when the current process is complete.",
+			pending-1))
+	}
+	if len(msgs) == 0 {
+		return fmt.Sprintf("Signer group %s already matches snapshot %d.", group, id), nil
+	}
+	mdb.SnapshotSignerGroup(tx, group, fmt.Sprintf("rollback to snapshot %d", id))
+	return strings.Join(msgs, "\n"), nil
+}
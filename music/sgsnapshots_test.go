@@ -0,0 +1,37 @@
+package music
+
+import (
+	"testing"
+)
+
+func TestDiffSignerGroupSnapshots(t *testing.T) {
+	a := &SignerGroupSnapshot{Signers: []SignerSnapshot{
+		{Name: "s1", Method: "ddns", Address: "192.0.2.1", AuthStr: "hmac-sha256:k1.:AAAA"},
+		{Name: "s2", Method: "ddns", Address: "192.0.2.2"},
+	}}
+	b := &SignerGroupSnapshot{Signers: []SignerSnapshot{
+		{Name: "s1", Method: "ddns", Address: "192.0.2.10", AuthStr: "hmac-sha256:k1.:BBBB"},
+		{Name: "s3", Method: "desec-api"},
+	}, PendingAddition: "s3"}
+
+	want := []string{
+		"- signer s2",
+		"~ signer s1: address 192.0.2.1 -> 192.0.2.10",
+		"~ signer s1: TSIG key changed",
+		"+ signer s3 (desec-api, )",
+		"~ pending addition: '' -> 's3'",
+	}
+	got := diffSignerGroupSnapshots(a, b)
+	if len(got) != len(want) {
+		t.Fatalf("diffSignerGroupSnapshots: got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("diffSignerGroupSnapshots: line %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	if d := diffSignerGroupSnapshots(a, a); len(d) != 0 {
+		t.Errorf("diffSignerGroupSnapshots: identical snapshots differ: %q", d)
+	}
+}
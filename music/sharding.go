@@ -0,0 +1,257 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Zone sharding. For very large fleets several musicd instances may share one DB, each
+// running the FSM engine (and the scheduled tasks) for a subset of the zones. Every
+// instance announces itself in shard_members with a heartbeat every sharding.heartbeat
+// seconds; instances that have not been heard from for three heartbeats are no longer
+// members. The zones are assigned to the members by rendezvous hashing of their signer
+// group, i.e. every instance computes the same owner for every zone without any
+// coordination, all zones of a signer group are run by the same instance (the engine
+// serializes the zones of a group, see pushLanes), and when an instance joins or leaves
+// only the groups it gains or loses move. An instance owns no zones until it has heard
+// from the DB who the members are.
+//
+// The limits per signer that are kept in memory (the rate limits, the concurrency limits
+// and the window of the circuit breaker) apply to all instances together: each instance
+// uses its share, the limit divided by the number of members. The process groups are
+// counted in the DB and need no sharing.
+//
+// The API and the read-only parts of musicd keep working on all zones in every
+// instance. Without sharding (the default) the instance owns all zones.
+
+type ShardMember struct {
+	Name      string
+	Heartbeat time.Time
+	Self      bool
+	Zones     int // zones in a process owned by the member
+}
+
+var shards = struct {
+	sync.RWMutex
+	self    string
+	members []string // sorted, including self
+}{}
+
+func ShardingActive() bool {
+	return viper.GetBool("sharding.active")
+}
+
+// ShardName returns the name of this instance (sharding.name, default the host name).
+func ShardName() string {
+	if name := viper.GetString("sharding.name"); name != "" {
+		return name
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "musicd"
+	}
+	return host
+}
+
+func ShardHeartbeatInterval() time.Duration {
+	hb := viper.GetInt("sharding.heartbeat")
+	if hb <= 0 {
+		hb = 30
+	}
+	return time.Duration(hb) * time.Second
+}
+
+// shardOwner returns the member that owns key, by rendezvous hashing.
+func shardOwner(members []string, key string) string {
+	var owner string
+	var best uint64
+	for _, m := range members {
+		h := fnv.New64a()
+		h.Write([]byte(m))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if w := h.Sum64(); owner == "" || w > best || (w == best && m < owner) {
+			owner, best = m, w
+		}
+	}
+	return owner
+}
+
+// shardKey returns the key by which the zone is assigned to a member: its signer group
+// or, for a zone that is not in a group, its name.
+func shardKey(zone, sgroup string) string {
+	if sgroup != "" {
+		return "group:" + sgroup
+	}
+	return zone
+}
+
+// OwnsZone returns true if this instance is responsible for the zone in the signer group
+// sgroup. Tasks that do not belong to a zone use the zone name "".
+func OwnsZone(zone, sgroup string) bool {
+	if !ShardingActive() {
+		return true
+	}
+	shards.RLock()
+	defer shards.RUnlock()
+	if len(shards.members) == 0 {
+		return false // not yet heard from the DB; the zone may be owned by another member
+	}
+	return shardOwner(shards.members, shardKey(zone, sgroup)) == shards.self
+}
+
+// shardCount returns the number of members that share the per-signer limits.
+func shardCount() int {
+	if !ShardingActive() {
+		return 1
+	}
+	shards.RLock()
+	defer shards.RUnlock()
+	if len(shards.members) < 2 {
+		return 1
+	}
+	return len(shards.members)
+}
+
+// shardShare returns the share of this instance of a limit that applies to all members
+// together. It is rounded up, so that every member may do something, which means that a
+// limit smaller than the number of members is exceeded.
+func shardShare(limit int) int {
+	n := shardCount()
+	if limit <= 0 || n == 1 {
+		return limit
+	}
+	return (limit + n - 1) / n
+}
+
+// shardShareRate returns the share of this instance of a rate that applies to all
+// members together.
+func shardShareRate(rate float64) float64 {
+	return rate / float64(shardCount())
+}
+
+// ShardHeartbeat announces this instance and updates the list of members. It returns
+// true if the membership changed, i.e. the zones were rebalanced.
+func (mdb *MusicDB) ShardHeartbeat(self string) (bool, error) {
+	const sqlq = "INSERT OR REPLACE INTO shard_members(name, heartbeat) VALUES (?, datetime('now'))"
+	if _, err := mdb.db.Exec(sqlq, self); CheckSQLError("ShardHeartbeat", sqlq, err, false) {
+		return false, err
+	}
+
+	members, err := mdb.ListShardMembers(nil)
+	if err != nil {
+		return false, err
+	}
+	var names []string
+	for _, m := range members {
+		names = append(names, m.Name)
+	}
+
+	shards.Lock()
+	defer shards.Unlock()
+	changed := shards.self != self || !sameStrings(shards.members, names)
+	shards.self, shards.members = self, names
+	return changed, nil
+}
+
+// ShardLeave removes this instance from the members, so that the others take over its
+// zones immediately rather than after the heartbeat timeout.
+func (mdb *MusicDB) ShardLeave(self string) {
+	const sqlq = "DELETE FROM shard_members WHERE name=?"
+	_, err := mdb.db.Exec(sqlq, self)
+	CheckSQLError("ShardLeave", sqlq, err, false)
+}
+
+// ListShardMembers returns the live members, sorted by name.
+func (mdb *MusicDB) ListShardMembers(tx *sql.Tx) ([]ShardMember, error) {
+	timeout := int(3 * ShardHeartbeatInterval() / time.Second)
+	const sqlq = `
+SELECT name, heartbeat FROM shard_members
+WHERE heartbeat >= datetime('now', '-' || ? || ' seconds') ORDER BY name`
+
+	var rows *sql.Rows
+	var err error
+	if tx != nil {
+		rows, err = tx.Query(sqlq, timeout)
+	} else {
+		rows, err = mdb.db.Query(sqlq, timeout)
+	}
+	if CheckSQLError("ListShardMembers", sqlq, err, false) {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shards.RLock()
+	self := shards.self
+	shards.RUnlock()
+
+	var members []ShardMember
+	for rows.Next() {
+		var m ShardMember
+		var hb string
+		if err := rows.Scan(&m.Name, &hb); err != nil {
+			return nil, err
+		}
+		m.Heartbeat, _ = time.Parse("2006-01-02 15:04:05", hb)
+		m.Self = m.Name == self
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// ShardStatus returns the members with the number of zones in a process each one owns.
+func (mdb *MusicDB) ShardStatus() ([]ShardMember, error) {
+	members, err := mdb.ListShardMembers(nil)
+	if err != nil || len(members) == 0 {
+		return members, err
+	}
+	var names []string
+	index := map[string]int{}
+	for i, m := range members {
+		names = append(names, m.Name)
+		index[m.Name] = i
+	}
+
+	const sqlq = "SELECT name, COALESCE(sgroup, '') FROM zones WHERE fsm != ''"
+	rows, err := mdb.db.Query(sqlq)
+	if CheckSQLError("ShardStatus", sqlq, err, false) {
+		return members, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var zone, sgroup string
+		if err := rows.Scan(&zone, &sgroup); err != nil {
+			return members, err
+		}
+		members[index[shardOwner(names, shardKey(zone, sgroup))]].Zones++
+	}
+	return members, nil
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ShardMembers returns the names of the current members, as last seen by this instance.
+func ShardMembers() []string {
+	shards.RLock()
+	defer shards.RUnlock()
+	return append([]string{}, shards.members...)
+}
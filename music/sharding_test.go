@@ -0,0 +1,67 @@
+package music
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestShardOwner(t *testing.T) {
+	var zones []string
+	for i := 0; i < 1000; i++ {
+		zones = append(zones, fmt.Sprintf("zone%d.example.", i))
+	}
+	three := []string{"a", "b", "c"}
+	count := map[string]int{}
+	for _, z := range zones {
+		count[shardOwner(three, z)]++
+	}
+	for _, m := range three {
+		if count[m] < 200 {
+			t.Errorf("member %s owns only %d of %d zones: %v", m, count[m], len(zones), count)
+		}
+	}
+
+	// when a member leaves, only its zones move
+	two := []string{"a", "c"}
+	for _, z := range zones {
+		before, after := shardOwner(three, z), shardOwner(two, z)
+		if before != "b" && before != after {
+			t.Errorf("zone %s moved from %s to %s", z, before, after)
+		}
+	}
+
+	if owner := shardOwner(nil, "zone.example."); owner != "" {
+		t.Errorf("shardOwner without members = %q", owner)
+	}
+}
+
+func TestShardShare(t *testing.T) {
+	viper.Set("sharding.active", true)
+	defer viper.Set("sharding.active", false)
+	shards.self, shards.members = "a", nil
+	defer func() { shards.self, shards.members = "", nil }()
+
+	if OwnsZone("zone.example.", "") {
+		t.Errorf("OwnsZone before the membership is known = true")
+	}
+
+	shards.members = []string{"a", "b", "c"}
+	for limit, want := range map[int]int{0: 0, 1: 1, 3: 1, 4: 2, 9: 3} {
+		if got := shardShare(limit); got != want {
+			t.Errorf("shardShare(%d) with 3 members = %d, want %d", limit, got, want)
+		}
+	}
+	if got := shardShareRate(6); got != 2 {
+		t.Errorf("shardShareRate(6) with 3 members = %v, want 2", got)
+	}
+
+	// all zones of a signer group have the same owner
+	owner := OwnsZone("zone0.example.", "sg1")
+	for i := 1; i < 100; i++ {
+		if OwnsZone(fmt.Sprintf("zone%d.example.", i), "sg1") != owner {
+			t.Fatalf("zones of signer group sg1 have different owners")
+		}
+	}
+}
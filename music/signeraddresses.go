@@ -0,0 +1,194 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Multiple addresses per signer. A signer may have several addresses (e.g. an IPv4 and
+// an IPv6 address, or several frontends), in order of preference. The first one is the
+// address of the signer, the others are kept in the addresses option of the signer.
+// All DNS queries and updates to the signer go through Signer.Exchange, which uses the
+// first address that is not down. An address is down for signers.failover.holddown
+// seconds after a query to it fails at the transport level (no response, connection
+// refused, ...); a DNS error response does not make an address down, as the next
+// address would most likely answer the same. If all addresses are down they are all
+// tried, in order.
+
+type addressHealth struct {
+	Failures  int
+	DownUntil time.Time
+	LastError string
+}
+
+var addrHealth = struct {
+	sync.Mutex
+	addrs map[string]*addressHealth // key: host:port
+}{addrs: map[string]*addressHealth{}}
+
+func failoverHolddown() time.Duration {
+	hd := viper.GetInt("signers.failover.holddown")
+	if hd <= 0 {
+		hd = 60
+	}
+	return time.Duration(hd) * time.Second
+}
+
+func noteAddressResult(addr string, err error) {
+	addrHealth.Lock()
+	defer addrHealth.Unlock()
+	h, known := addrHealth.addrs[addr]
+	if err == nil {
+		if known && h.Failures > 0 {
+			log.Printf("Signer address %s is up again after %d failures", addr, h.Failures)
+		}
+		delete(addrHealth.addrs, addr)
+		return
+	}
+	if !known {
+		h = &addressHealth{}
+		addrHealth.addrs[addr] = h
+	}
+	h.Failures++
+	h.DownUntil = time.Now().Add(failoverHolddown())
+	h.LastError = err.Error()
+	log.Printf("Signer address %s is down until %s: %v", addr, h.DownUntil.Format(time.RFC3339), err)
+}
+
+func addressDown(addr string) bool {
+	addrHealth.Lock()
+	defer addrHealth.Unlock()
+	h, known := addrHealth.addrs[addr]
+	return known && time.Now().Before(h.DownUntil)
+}
+
+// dnsAddress returns address:port, with the port defaulting to 53 and brackets around
+// IPv6 addresses.
+func dnsAddress(address, port string) string {
+	if port == "" {
+		port = "53"
+	}
+	return net.JoinHostPort(strings.Trim(address, "[]"), port)
+}
+
+// DnsAddress returns the (first) address of the signer, with the port, for DNS queries
+// and updates. Use Signer.Exchange to fail over to the other addresses.
+func (s *Signer) DnsAddress() string {
+	return dnsAddress(s.Address, s.Port)
+}
+
+// addressList returns the addresses of the signer, in order of preference.
+func (s *Signer) addressList() []string {
+	if len(s.Addresses) == 0 {
+		return []string{s.Address}
+	}
+	return s.Addresses
+}
+
+// exchangeAddrs returns the host:port of the addresses of the signer, in the order
+// they should be tried.
+func (s *Signer) exchangeAddrs() []string {
+	var up, down []string
+	for _, a := range s.addressList() {
+		addr := dnsAddress(a, s.Port)
+		if addressDown(addr) {
+			down = append(down, addr)
+		} else {
+			up = append(up, addr)
+		}
+	}
+	return append(up, down...)
+}
+
+// Exchange sends m to the signer, failing over between the addresses of the signer.
+func (s *Signer) Exchange(c *dns.Client, m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	var r *dns.Msg
+	var rtt time.Duration
+	var err error
+	for _, addr := range s.exchangeAddrs() {
+		// sending a TSIG signed message removes the TSIG RR from it, so send a copy
+		r, rtt, err = exchangeVia(c, m.Copy(), addr, s.jumphost)
+		noteAddressResult(addr, err)
+		if err == nil {
+			return r, rtt, nil
+		}
+	}
+	return r, rtt, err
+}
+
+// DownAddresses returns the addresses of the signer that are currently down.
+func (s *Signer) DownAddresses() []string {
+	var res []string
+	for _, a := range s.addressList() {
+		if addressDown(dnsAddress(a, s.Port)) {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// normalizeAddresses checks the addresses and removes brackets and duplicates.
+func normalizeAddresses(addrs []string) ([]string, error) {
+	var res []string
+	seen := map[string]bool{}
+	for _, a := range addrs {
+		a = strings.Trim(strings.TrimSpace(a), "[]")
+		if a == "" || seen[a] {
+			continue
+		}
+		if net.ParseIP(a) == nil {
+			if _, ok := dns.IsDomainName(a); !ok || strings.Contains(a, ":") {
+				return nil, fmt.Errorf("Invalid signer address '%s'", a)
+			}
+		}
+		seen[a] = true
+		res = append(res, a)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("A signer must have at least one address.")
+	}
+	return res, nil
+}
+
+// SignerSetAddresses replaces the addresses of the signer. The first address becomes
+// the address of the signer.
+func (mdb *MusicDB) SignerSetAddresses(tx *sql.Tx, dbsigner *Signer, addrs []string) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	addrs, err := normalizeAddresses(addrs)
+	if err != nil {
+		return "", err
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerSetAddresses: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "UPDATE signers SET addr=? WHERE name=?"
+	_, err = tx.Exec(sqlq, addrs[0], dbsigner.Name)
+	if CheckSQLError("SignerSetAddresses", sqlq, err, false) {
+		return "", err
+	}
+	err = mdb.setSignerOption(tx, dbsigner.Name, signerOptAddresses, strings.Join(addrs[1:], " "))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Signer %s now has the addresses (in order of preference): %s",
+		dbsigner.Name, strings.Join(addrs, ", ")), nil
+}
@@ -0,0 +1,57 @@
+package music
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeAddresses(t *testing.T) {
+	got, err := normalizeAddresses([]string{"192.0.2.1", "[2001:db8::1]", " signer.example.net", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.1", "2001:db8::1", "signer.example.net"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeAddresses = %v, want %v", got, want)
+	}
+	for _, bad := range [][]string{nil, {""}, {"192.0.2.1:53"}} {
+		if _, err := normalizeAddresses(bad); err == nil {
+			t.Errorf("normalizeAddresses(%v): expected an error", bad)
+		}
+	}
+}
+
+func TestExchangeAddrsFailover(t *testing.T) {
+	s := &Signer{Address: "192.0.2.1", Addresses: []string{"192.0.2.1", "2001:db8::1"}, Port: "53"}
+	want := []string{"192.0.2.1:53", "[2001:db8::1]:53"}
+	if got := s.exchangeAddrs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("exchangeAddrs = %v, want %v", got, want)
+	}
+
+	noteAddressResult("192.0.2.1:53", errors.New("i/o timeout"))
+	defer noteAddressResult("192.0.2.1:53", nil)
+	want = []string{"[2001:db8::1]:53", "192.0.2.1:53"}
+	if got := s.exchangeAddrs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("exchangeAddrs with the first address down = %v, want %v", got, want)
+	}
+	if down := s.DownAddresses(); !reflect.DeepEqual(down, []string{"192.0.2.1"}) {
+		t.Errorf("DownAddresses = %v", down)
+	}
+}
+
+func TestDnsAddress(t *testing.T) {
+	for _, tc := range []struct{ addr, port, want string }{
+		{"192.0.2.1", "53", "192.0.2.1:53"},
+		{"192.0.2.1", "", "192.0.2.1:53"},
+		{"192.0.2.1", "5353", "192.0.2.1:5353"},
+		{"2001:db8::1", "853", "[2001:db8::1]:853"},
+		{"[2001:db8::1]", "", "[2001:db8::1]:53"},
+		{"signer.example.net", "", "signer.example.net:53"},
+	} {
+		s := &Signer{Address: tc.addr, Port: tc.port}
+		if got := s.DnsAddress(); got != tc.want {
+			t.Errorf("DnsAddress(%s, %s) = %s, want %s", tc.addr, tc.port, got, tc.want)
+		}
+	}
+}
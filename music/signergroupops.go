@@ -39,6 +39,7 @@ func (mdb *MusicDB) AddSignerGroup(tx *sql.Tx, sg string) (string, error) {
 	if CheckSQLError("AddSignerGroup", addcmd, err, false) {
 		return fmt.Sprintf("Signergroup %s not created. Reason: %v", sg, err), err
 	}
+	mdb.SnapshotSignerGroup(tx, sg, "signer group created")
 	return fmt.Sprintf("Signergroup %s created.", sg), nil
 }
 
@@ -147,6 +148,13 @@ func (mdb *MusicDB) DeleteSignerGroup(tx *sql.Tx, group string) (string, error)
 		return fmt.Sprintf("Signergroup %s not deleted. Reason: %v", group, err), err
 	}
 
+	const sqlq4 = "DELETE FROM signergroup_snapshots WHERE sgroup=?"
+
+	_, err = tx.Exec(sqlq4, group)
+	if CheckSQLError("DeleteSignerGroup", sqlq4, err, false) {
+		return fmt.Sprintf("Signergroup %s not deleted. Reason: %v", group, err), err
+	}
+
 	return fmt.Sprintf("Signergroup %s deleted. Any zones or signers in signergroup were detached.", group),
 	       nil
 }
@@ -412,6 +420,7 @@ func (mdb *MusicDB) CheckIfProcessComplete(tx *sql.Tx, sg *SignerGroup) (bool, s
 			        return false, fmt.Sprintf("Error from tx.Exec(%s): %v", sqlq, err), err
 			}
 		}
+		mdb.SnapshotSignerGroup(tx, sg.Name, fmt.Sprintf("process '%s' complete", cp))
 
 		return true, msg, nil
 	}
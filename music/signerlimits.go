@@ -0,0 +1,112 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Per-signer concurrency limits. A small (e.g. self-hosted) signer may be overwhelmed
+// when many zones execute update actions against it at the same time, even if the
+// rate limits of the updater would allow it. The number of zones that concurrently
+// execute an action against a signer is therefore capped. A zone that would exceed the
+// cap does not wait (the engine holds its transaction during the action); it gets the
+// stop reason "signer X at concurrency limit" and is retried on the next engine run.
+
+func (mdb *MusicDB) SignerSetMaxZones(tx *sql.Tx, dbsigner *Signer, maxzones int) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	if maxzones < 0 {
+		return "", fmt.Errorf("The max number of concurrent zones must not be negative.")
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerSetMaxZones: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if maxzones == 0 {
+		err = mdb.setSignerOption(tx, dbsigner.Name, signerOptMaxZones, "")
+	} else {
+		err = mdb.setSignerOption(tx, dbsigner.Name, signerOptMaxZones, strconv.Itoa(maxzones))
+	}
+	if err != nil {
+		return "", err
+	}
+	if maxzones == 0 {
+		return fmt.Sprintf("Signer %s now uses the default concurrency limit (%d, 0 = unlimited).",
+			dbsigner.Name, viper.GetInt("signers.concurrency.default")), nil
+	}
+	return fmt.Sprintf("At most %d zones will execute actions against signer %s at the same time.",
+		maxzones, dbsigner.Name), nil
+}
+
+// maxZones returns the max number of concurrent zones for the signer in this instance,
+// 0 = unlimited. The limit is for all instances together, see sharding.go.
+func (s *Signer) maxZones() int {
+	if s.MaxZones != 0 {
+		return shardShare(s.MaxZones)
+	}
+	return shardShare(viper.GetInt("signers.concurrency.default"))
+}
+
+var signerSlots = struct {
+	sync.Mutex
+	active map[string]int
+}{active: map[string]int{}}
+
+// acquireSignerSlots takes one slot at every signer of the zone, if all of them have
+// room for one more zone. All slots are taken at once, so that two zones can not
+// deadlock each other. On success the returned function releases the slots; if some
+// signer is at its limit nothing is taken and the returned reason says why.
+func acquireSignerSlots(z *Zone) (func(), string) {
+	if z.SGroup == nil || len(z.SGroup.SignerMap) == 0 {
+		return func() {}, ""
+	}
+
+	var signers, busy []string
+	for name := range z.SGroup.SignerMap {
+		signers = append(signers, name)
+	}
+	sort.Strings(signers)
+
+	signerSlots.Lock()
+	defer signerSlots.Unlock()
+	for _, name := range signers {
+		limit := z.SGroup.SignerMap[name].maxZones()
+		if limit > 0 && signerSlots.active[name] >= limit {
+			busy = append(busy, name)
+		}
+	}
+	switch len(busy) {
+	case 0:
+	case 1:
+		return nil, fmt.Sprintf("signer %s at concurrency limit", busy[0])
+	default:
+		return nil, fmt.Sprintf("signers %s at concurrency limit", strings.Join(busy, ", "))
+	}
+	for _, name := range signers {
+		signerSlots.active[name]++
+	}
+
+	return func() {
+		signerSlots.Lock()
+		for _, name := range signers {
+			signerSlots.active[name]--
+		}
+		signerSlots.Unlock()
+	}, ""
+}
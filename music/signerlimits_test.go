@@ -0,0 +1,30 @@
+package music
+
+import "testing"
+
+func TestAcquireSignerSlots(t *testing.T) {
+	sg := &SignerGroup{SignerMap: map[string]*Signer{
+		"limited":   {Name: "limited", MaxZones: 1},
+		"unlimited": {Name: "unlimited"},
+	}}
+	z1 := &Zone{Name: "z1.", SGroup: sg}
+	z2 := &Zone{Name: "z2.", SGroup: sg}
+
+	release, reason := acquireSignerSlots(z1)
+	if reason != "" {
+		t.Fatalf("first zone: got reason %q", reason)
+	}
+	if _, reason := acquireSignerSlots(z2); reason != "signer limited at concurrency limit" {
+		t.Errorf("second zone: got reason %q", reason)
+	}
+	if signerSlots.active["unlimited"] != 1 {
+		t.Errorf("refused zone took a slot: %v", signerSlots.active)
+	}
+
+	release()
+	release2, reason := acquireSignerSlots(z2)
+	if reason != "" {
+		t.Fatalf("after release: got reason %q", reason)
+	}
+	release2()
+}
@@ -0,0 +1,166 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// NOTIFY after updates. Some provisioning paths (in particular the APIs of some signer
+// providers) update the zone without notifying the secondaries, which then only pick
+// up the change at the next SOA refresh. For signers with notify targets MUSIC sends
+// a NOTIFY for the zone to every target after each successful update. A target is
+// either host[:port] or "secondaries", i.e. the name servers in the NS RRset of the
+// zone at the signer (except the signer itself).
+//
+// The NOTIFYs are sent in the background and failures are only logged; they speed up
+// propagation but nothing depends on them.
+
+const notifySecondaries = "secondaries"
+
+// normalizeNotifyTargets checks the targets and adds the default port where missing.
+func normalizeNotifyTargets(targets []string) ([]string, error) {
+	var res []string
+	for _, t := range targets {
+		t = strings.TrimSpace(t)
+		switch {
+		case t == "":
+			continue
+		case t == notifySecondaries:
+		case strings.Contains(t, "]:") || (strings.Count(t, ":") == 1):
+			host, port, err := net.SplitHostPort(t)
+			if err != nil || host == "" || port == "" {
+				return nil, fmt.Errorf("Invalid notify target '%s'", t)
+			}
+		default:
+			t = net.JoinHostPort(strings.Trim(t, "[]"), "53")
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+func (mdb *MusicDB) SignerSetNotify(tx *sql.Tx, dbsigner *Signer, targets []string) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	targets, err := normalizeNotifyTargets(targets)
+	if err != nil {
+		return "", err
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerSetNotify: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	err = mdb.setSignerOption(tx, dbsigner.Name, signerOptNotify, strings.Join(targets, " "))
+	if err != nil {
+		return "", err
+	}
+	if len(targets) == 0 {
+		return fmt.Sprintf("No NOTIFYs are sent after updates to signer %s.", dbsigner.Name), nil
+	}
+
+	return fmt.Sprintf("After updates to signer %s NOTIFYs are sent to: %s", dbsigner.Name,
+		strings.Join(targets, ", ")), nil
+}
+
+// NotifyUpdater wraps an updater and sends NOTIFYs to the notify targets of the signer
+// after each successful update.
+type NotifyUpdater struct {
+	Updater
+}
+
+func (u *NotifyUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	err := u.Updater.Update(signer, zone, fqdn, inserts, removes)
+	if err == nil {
+		u.notify(signer, zone, fqdn)
+	}
+	return err
+}
+
+func (u *NotifyUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	err := u.Updater.RemoveRRset(signer, zone, fqdn, rrsets)
+	if err == nil {
+		u.notify(signer, zone, fqdn)
+	}
+	return err
+}
+
+func (u *NotifyUpdater) notify(signer *Signer, zone, fqdn string) {
+	if len(signer.Notify) == 0 {
+		return
+	}
+	if zone == "" {
+		zone = fqdn
+	}
+	zone = dns.Fqdn(zone)
+	targets := append([]string{}, signer.Notify...)
+	go func() {
+		for _, target := range u.notifyTargets(signer, zone, targets) {
+			if err := sendNotify(zone, target); err != nil {
+				log.Printf("NOTIFY: zone %s, signer %s: %v", zone, signer.Name, err)
+			}
+		}
+	}()
+}
+
+// notifyTargets expands "secondaries" into the addresses of the name servers of zone.
+func (u *NotifyUpdater) notifyTargets(signer *Signer, zone string, targets []string) []string {
+	var res []string
+	for _, t := range targets {
+		if t != notifySecondaries {
+			res = append(res, t)
+			continue
+		}
+		err, rrs := u.Updater.FetchRRset(signer, zone, zone, dns.TypeNS)
+		if err != nil {
+			log.Printf("NOTIFY: zone %s: unable to fetch the NS RRset from signer %s: %v",
+				zone, signer.Name, err)
+			continue
+		}
+		for _, rr := range rrs {
+			ns, ok := rr.(*dns.NS)
+			if !ok {
+				continue
+			}
+			addrs, err := net.LookupHost(ns.Ns)
+			if err != nil {
+				log.Printf("NOTIFY: zone %s: unable to look up %s: %v", zone, ns.Ns, err)
+				continue
+			}
+			for _, addr := range addrs {
+				if addr != signer.Address {
+					res = append(res, net.JoinHostPort(addr, "53"))
+				}
+			}
+		}
+	}
+	return res
+}
+
+func sendNotify(zone, target string) error {
+	m := new(dns.Msg)
+	m.SetNotify(zone)
+	c := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+	r, _, err := DnsExchange(c, m, target)
+	if err != nil {
+		return fmt.Errorf("NOTIFY to %s failed: %v", target, err)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("NOTIFY to %s failed, RCODE = %s", target, dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
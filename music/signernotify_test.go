@@ -0,0 +1,22 @@
+package music
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeNotifyTargets(t *testing.T) {
+	got, err := normalizeNotifyTargets([]string{"ns1.example.net", "192.0.2.1:5353", " secondaries",
+		"2001:db8::1", "[2001:db8::2]:53", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ns1.example.net:53", "192.0.2.1:5353", "secondaries", "[2001:db8::1]:53",
+		"[2001:db8::2]:53"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeNotifyTargets = %v, want %v", got, want)
+	}
+	if _, err := normalizeNotifyTargets([]string{"ns1.example.net:"}); err == nil {
+		t.Errorf("expected an error for a target without port")
+	}
+}
@@ -42,8 +42,11 @@ func (mdb *MusicDB) AddSigner(tx *sql.Tx, dbsigner *Signer, group string) (strin
 			"Unknown signer method: %s. Known methods are: %v", dbsigner.Method, updatermap)
 	}
 
-	if dbsigner.Method == "ddns" || dbsigner.Method == "rlddns" {
+	if dbsigner.Method == "ddns" || dbsigner.Method == "rlddns" || dbsigner.Method == "file-include" {
 		if dbsigner.Auth.TSIGKey != "" {
+			if dbsigner.Auth.TSIGAlg, err = CanonicalTSIGAlg(dbsigner.Auth.TSIGAlg); err != nil {
+				return "", err
+			}
 			dbsigner.AuthStr = fmt.Sprintf("%s:%s:%s", dbsigner.Auth.TSIGAlg,
 				dbsigner.Auth.TSIGName, dbsigner.Auth.TSIGKey)
 		}
@@ -61,6 +64,24 @@ func (mdb *MusicDB) AddSigner(tx *sql.Tx, dbsigner *Signer, group string) (strin
 		return msg, err
 	}
 
+	// Verify new signers right away if there is a test zone to verify against
+	var vmsg string
+	if viper.GetString("signers.verification.testzone") != "" {
+		newsigner, err := mdb.GetSigner(tx, dbsigner, false)
+		if err != nil {
+			return msg, err
+		}
+		sv, err := mdb.VerifySigner(tx, newsigner, "")
+		if err != nil {
+			return msg, err
+		}
+		vmsg = fmt.Sprintf(" Verification against %s: passed.", sv.TestZone)
+		if !sv.Verified {
+			vmsg = fmt.Sprintf(" Verification against %s: FAILED (%s).", sv.TestZone,
+				strings.Join(sv.Report, "; "))
+		}
+	}
+
 	if group != "" {
 		log.Printf("AddSigner: signer %s has the signergroup %s specified so we set that too\n",
 			dbsigner.Name, group)
@@ -74,13 +95,13 @@ func (mdb *MusicDB) AddSigner(tx *sql.Tx, dbsigner *Signer, group string) (strin
 			return fmt.Sprintf("AddSigner: Error joinging new signer %s to group %s: %v",
 				dbsigner.Name, group, err), err
 		}
-		return fmt.Sprintf("Signer %s was added and immediately attached to signer group %s.",
-			dbsigner.Name, group), nil
+		return fmt.Sprintf("Signer %s was added and immediately attached to signer group %s.%s",
+			dbsigner.Name, group, vmsg), nil
 	}
 
 	log.Printf("AddSigner: success: %s, %s, %s, %s, %s\n", dbsigner.Name,
 		dbsigner.Method, dbsigner.AuthStr, dbsigner.Address, dbsigner.Port)
-	return fmt.Sprintf("New signer %s successfully added.", dbsigner.Name), nil
+	return fmt.Sprintf("New signer %s successfully added.%s", dbsigner.Name, vmsg), nil
 }
 
 func (mdb *MusicDB) UpdateSigner(tx *sql.Tx, dbsigner *Signer, us Signer) (string, error) {
@@ -108,6 +129,9 @@ func (mdb *MusicDB) UpdateSigner(tx *sql.Tx, dbsigner *Signer, us Signer) (strin
 		dbsigner.Method = us.Method
 
 		if us.Auth.TSIGKey != "" { // only possible to update auth data together with method
+			if us.Auth.TSIGAlg, err = CanonicalTSIGAlg(us.Auth.TSIGAlg); err != nil {
+				return "", err
+			}
 			dbsigner.Auth = us.Auth
 			dbsigner.AuthStr = fmt.Sprintf("%s:%s:%s", us.Auth.TSIGAlg, us.Auth.TSIGName, us.Auth.TSIGKey)
 		}
@@ -134,6 +158,17 @@ func (mdb *MusicDB) UpdateSigner(tx *sql.Tx, dbsigner *Signer, us Signer) (strin
 		return fmt.Sprintf("UpdateSigner: Error from tx.Exec: %v", err), err
 	}
 
+	// a changed signer must be verified again
+	if err = mdb.signerResetVerification(tx, dbsigner.Name); err != nil {
+		return "", err
+	}
+
+	if sgs, err := mdb.GetSignerGroups(tx, dbsigner.Name); err == nil {
+		for _, sg := range sgs {
+			mdb.SnapshotSignerGroup(tx, sg, fmt.Sprintf("signer %s updated", dbsigner.Name))
+		}
+	}
+
 	log.Printf("UpdateSigner: success: %s, %s, %s, %s, %s\n", dbsigner.Name,
 		dbsigner.Method, dbsigner.Auth,
 		dbsigner.Address, dbsigner.Port)
@@ -167,6 +202,10 @@ func (mdb *MusicDB) SignerJoinGroup(tx *sql.Tx, dbsigner *Signer, g string) (str
 		return "", err
 	}
 
+	if err = mdb.signerCheckVerified(tx, dbsigner.Name); err != nil {
+		return "", err
+	}
+
 	if _, member := sg.SignerMap[dbsigner.Name]; member {
 		return "", fmt.Errorf("Signer %s is already a member of group %s", dbsigner.Name, sg.Name)
 	}
@@ -213,6 +252,7 @@ func (mdb *MusicDB) SignerJoinGroup(tx *sql.Tx, dbsigner *Signer, g string) (str
 		}
 
 		if len(zones) == 0 {
+			mdb.SnapshotSignerGroup(tx, g, fmt.Sprintf("signer %s joined", dbsigner.Name))
 			return fmt.Sprintf(
 				"Signer %s has joined signer group %s, which now has %d signers but no zones.",
 				dbsigner.Name, sg.Name, len(sg.SignerMap)), nil
@@ -243,10 +283,12 @@ func (mdb *MusicDB) SignerJoinGroup(tx *sql.Tx, dbsigner *Signer, g string) (str
 			}
 			log.Printf("SJG: Message from ZAF: %s", msg)
 		}
+		mdb.SnapshotSignerGroup(tx, g, fmt.Sprintf("signer %s joining", dbsigner.Name))
 		return fmt.Sprintf(
 			"Signer %s has joined signer group %s and %d zones have entered the 'add-signer' process.",
 			dbsigner.Name, g, len(zones)), nil
 	}
+	mdb.SnapshotSignerGroup(tx, g, fmt.Sprintf("signer %s joined", dbsigner.Name))
 	return fmt.Sprintf(
 		"Signer %s has joined signer group %s as the first signer. No zones entered the 'add-signer' process.",
 		dbsigner.Name, g), nil
@@ -320,6 +362,7 @@ func (mdb *MusicDB) SignerLeaveGroup(tx *sql.Tx, dbsigner *Signer, g string) (st
 		if CheckSQLError("SignerLeaveGroup", sqlq, err, false) {
 			return "", err
 		}
+		mdb.SnapshotSignerGroup(tx, g, fmt.Sprintf("signer %s left", dbsigner.Name))
 		return fmt.Sprintf(
 			"Signer %s was removed from signer group %s immediately (because the signer group has no zones).",
 			dbsigner.Name, g), nil
@@ -359,6 +402,7 @@ func (mdb *MusicDB) SignerLeaveGroup(tx *sql.Tx, dbsigner *Signer, g string) (st
 		return "", fmt.Errorf("Signer %s is still a member of group %s", dbsigner.Name, sg.Name)
 	}
 
+	mdb.SnapshotSignerGroup(tx, g, fmt.Sprintf("signer %s leaving", dbsigner.Name))
 	return fmt.Sprintf(
 		"Signer %s is in pending removal from signer group %s and therefore %d zones entered the '%s' process.",
 		dbsigner.Name, g, len(zones), SignerLeaveGroupProcess), nil
@@ -397,6 +441,20 @@ func (mdb *MusicDB) DeleteSigner(tx *sql.Tx, dbsigner *Signer) (string, error) {
 	if CheckSQLError("DeleteSigner", dsql2, err, false) {
 		return "", err
 	}
+
+	if err = mdb.deleteSignerOptions(tx, dbsigner.Name); err != nil {
+		return "", err
+	}
+
+	if err = mdb.forgetZoneIDs(tx, "", dbsigner.Name); err != nil {
+		return "", err
+	}
+
+	const dsql3 = "DELETE FROM signer_zone_credentials WHERE signer=?"
+	_, err = tx.Exec(dsql3, dbsigner.Name)
+	if CheckSQLError("DeleteSigner", dsql3, err, false) {
+		return "", err
+	}
 	return fmt.Sprintf("Signer %s deleted.", dbsigner.Name), nil
 }
 
@@ -424,16 +482,7 @@ func (mdb *MusicDB) ListSigners(tx *sql.Tx) (map[string]Signer, error) {
 				log.Fatal("ListSigners: Error from rows.Next():", err)
 			}
 
-			auth := AuthData{}
-			authparts := strings.Split(authstr, ":")
-			log.Printf("ListSigners: authparts: '%s'", authparts)
-			if len(authparts) == 3 {
-				auth = AuthData{
-					TSIGAlg:  authparts[0],
-					TSIGName: authparts[1],
-					TSIGKey:  authparts[2],
-				}
-			}
+			auth := ParseAuthStr(authstr)
 			s := Signer{
 				Name:    name,
 				Exists:  true,
@@ -449,6 +498,15 @@ func (mdb *MusicDB) ListSigners(tx *sql.Tx) (map[string]Signer, error) {
 					err)
 			}
 			s.SignerGroups = sgs
+			opts, err := mdb.getSignerOptions(tx, name)
+			if err != nil {
+				return sl, err
+			}
+			if err = s.applyOptions(opts); err != nil {
+				return sl, err
+			}
+			s.redact()
+			s.DownAddrs = s.DownAddresses()
 			sl[name] = s
 		}
 	}
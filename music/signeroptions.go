@@ -0,0 +1,169 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Signer options. The settings of optional signer features, such as the views of
+// split-horizon signers, are kept in the signer_options table, one row per signer and
+// option, so that all of them are loaded with a single query when a signer is fetched.
+// Options that are not set have no row. Structured options are stored as JSON.
+
+const (
+	signerOptViews        = "views"        // JSON list of storedView, see signerviews.go
+	signerOptProxy        = "proxy"        // jump host URL, see jumphost.go
+	signerOptSshKey       = "sshkey"       // SSH key file of an ssh:// jump host
+	signerOptInclude      = "include"      // JSON FileInclude, see fileinclude_updater.go
+	signerOptAnycast      = "anycast"      // "1", see anycast.go
+	signerOptTLS          = "tls"          // JSON SignerTLS, see signertls.go
+	signerOptDoH          = "doh"          // DoH URL, see signertransport.go
+	signerOptSIG0         = "sig0"         // JSON SignerSIG0 (both keys), see signersig0.go
+	signerOptNotify       = "notify"       // space separated targets, see signernotify.go
+	signerOptAddresses    = "addresses"    // space separated, after the signer address
+	signerOptMaxZones     = "maxzones"     // integer, see signerlimits.go
+	signerOptVerification = "verification" // JSON SignerVerification, see signerverify.go
+)
+
+type signerOptions map[string]string
+
+// getSignerOptions returns all options of the signer.
+func (mdb *MusicDB) getSignerOptions(tx *sql.Tx, signer string) (signerOptions, error) {
+	const sqlq = "SELECT option, value FROM signer_options WHERE signer=?"
+
+	opts := signerOptions{}
+	rows, err := tx.Query(sqlq, signer)
+	if CheckSQLError("getSignerOptions", sqlq, err, false) {
+		return opts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var option, value string
+		if err := rows.Scan(&option, &value); err != nil {
+			return opts, err
+		}
+		opts[option] = value
+	}
+	return opts, rows.Err()
+}
+
+// getSignerOption returns one option of the signer, "" if not set.
+func (mdb *MusicDB) getSignerOption(tx *sql.Tx, signer, option string) (string, error) {
+	const sqlq = "SELECT value FROM signer_options WHERE signer=? AND option=?"
+
+	var value string
+	err := tx.QueryRow(sqlq, signer, option).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if CheckSQLError("getSignerOption", sqlq, err, false) {
+		return "", err
+	}
+	return value, nil
+}
+
+// setSignerOption sets an option of the signer. An empty value removes the option.
+func (mdb *MusicDB) setSignerOption(tx *sql.Tx, signer, option, value string) error {
+	if value == "" {
+		const sqlq = "DELETE FROM signer_options WHERE signer=? AND option=?"
+		_, err := tx.Exec(sqlq, signer, option)
+		if CheckSQLError("setSignerOption", sqlq, err, false) {
+			return err
+		}
+		return nil
+	}
+
+	const sqlq = "INSERT OR REPLACE INTO signer_options(signer, option, value) VALUES (?, ?, ?)"
+	_, err := tx.Exec(sqlq, signer, option, value)
+	if CheckSQLError("setSignerOption", sqlq, err, false) {
+		return err
+	}
+	return nil
+}
+
+// setSignerOptionJSON sets an option of the signer to v, encoded as JSON.
+func (mdb *MusicDB) setSignerOptionJSON(tx *sql.Tx, signer, option string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return mdb.setSignerOption(tx, signer, option, string(buf))
+}
+
+// deleteSignerOptions removes all options of the signer.
+func (mdb *MusicDB) deleteSignerOptions(tx *sql.Tx, signer string) error {
+	const sqlq = "DELETE FROM signer_options WHERE signer=?"
+	_, err := tx.Exec(sqlq, signer)
+	if CheckSQLError("deleteSignerOptions", sqlq, err, false) {
+		return err
+	}
+	return nil
+}
+
+// decode decodes the JSON option into v. v is left as is if the option is not set.
+func (o signerOptions) decode(option string, v interface{}) error {
+	value, ok := o[option]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(value), v); err != nil {
+		return fmt.Errorf("signer option %s: %v", option, err)
+	}
+	return nil
+}
+
+// applyOptions sets the fields of the signer from its options.
+func (s *Signer) applyOptions(o signerOptions) error {
+	var views []storedView
+	if err := o.decode(signerOptViews, &views); err != nil {
+		return err
+	}
+	for _, v := range views {
+		s.Views = append(s.Views, v.signerView())
+	}
+	if err := o.decode(signerOptInclude, &s.Include); err != nil {
+		return err
+	}
+	if err := o.decode(signerOptTLS, &s.TLS); err != nil {
+		return err
+	}
+	if err := o.decode(signerOptSIG0, &s.SIG0); err != nil {
+		return err
+	}
+	s.SIG0 = s.SIG0.withKeyInfo()
+
+	s.Proxy = o[signerOptProxy]
+	s.jumphost = jumpHost{proxy: o[signerOptProxy], sshkey: o[signerOptSshKey]}
+	s.Anycast = o[signerOptAnycast] != ""
+	s.DoHURL = o[signerOptDoH]
+	s.Notify = strings.Fields(o[signerOptNotify])
+	s.Addresses = append([]string{s.Address}, strings.Fields(o[signerOptAddresses])...)
+	if v := o[signerOptMaxZones]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("signer option %s: %v", signerOptMaxZones, err)
+		}
+		s.MaxZones = n
+	}
+	return nil
+}
+
+// redact removes the secrets from a signer that is returned by the API.
+func (s *Signer) redact() {
+	s.Proxy = redactProxy(s.Proxy)
+	s.jumphost = jumpHost{}
+	s.SIG0.PrivateKey = ""
+	s.zoneTokens = nil
+	for i := range s.Views {
+		s.Views[i].AuthStr = ""
+		s.Views[i].Auth.TSIGKey = ""
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"crypto"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SIG(0) authentication (RFC 2931) to DDNS signers, as an alternative to TSIG for
+// signers that prefer asymmetric update authentication. The key pair is generated on
+// the MUSIC side (e.g. "dnssec-keygen -T KEY -a ECDSAP256SHA256 -n HOST music.") and
+// the public KEY RR is published at (or configured in) the signer. Both halves are
+// stored per signer; the private key is never returned by the API.
+//
+// When SIG(0) is active for a signer it is used for all updates and fetches instead of
+// TSIG. Responses are not verified, as most servers do not sign them.
+
+type SignerSIG0 struct {
+	Active     bool
+	KeyName    string
+	Algorithm  string
+	KeyTag     uint16
+	PublicKey  string // the KEY RR, in presentation format
+	PrivateKey string `json:",omitempty"` // in BIND private key format; never sent by musicd
+}
+
+// sig0Algorithms are the algorithms that SIG(0) signing is supported for.
+var sig0Algorithms = map[uint8]bool{
+	dns.RSASHA256:       true,
+	dns.RSASHA512:       true,
+	dns.ECDSAP256SHA256: true,
+	dns.ECDSAP384SHA384: true,
+}
+
+// parseSIG0Key parses and checks the key pair of st.
+func parseSIG0Key(st SignerSIG0) (*dns.KEY, crypto.Signer, error) {
+	rr, err := dns.NewRR(st.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to parse the SIG(0) public key: %v", err)
+	}
+	key, ok := rr.(*dns.KEY)
+	if !ok {
+		return nil, nil, fmt.Errorf("The SIG(0) public key must be a KEY RR, not %s",
+			dns.TypeToString[rr.Header().Rrtype])
+	}
+	if !sig0Algorithms[key.Algorithm] {
+		return nil, nil, fmt.Errorf("SIG(0) is not supported with algorithm %s",
+			dns.AlgorithmToString[key.Algorithm])
+	}
+	priv, err := key.NewPrivateKey(st.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to parse the SIG(0) private key: %v", err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("The SIG(0) private key can not be used for signing")
+	}
+	return key, signer, nil
+}
+
+func (mdb *MusicDB) SignerSetSIG0(tx *sql.Tx, dbsigner *Signer, st SignerSIG0) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	if dbsigner.Method != "ddns" && dbsigner.Method != "rlddns" {
+		return "", fmt.Errorf("Signer %s has method %s. SIG(0) is only supported for DDNS signers.",
+			dbsigner.Name, dbsigner.Method)
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerSetSIG0: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if !st.Active {
+		err = mdb.setSignerOption(tx, dbsigner.Name, signerOptSIG0, "")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Signer %s is now accessed with TSIG.", dbsigner.Name), nil
+	}
+
+	key, _, err := parseSIG0Key(st)
+	if err != nil {
+		return "", err
+	}
+	err = mdb.setSignerOptionJSON(tx, dbsigner.Name, signerOptSIG0,
+		SignerSIG0{Active: true, PublicKey: key.String(), PrivateKey: st.PrivateKey})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Signer %s is now accessed with SIG(0) key %s (%s, key tag %d).", dbsigner.Name,
+		key.Header().Name, dns.AlgorithmToString[key.Algorithm], key.KeyTag()), nil
+}
+
+// withKeyInfo returns st with the key name, algorithm and key tag taken from the public key.
+func (st SignerSIG0) withKeyInfo() SignerSIG0 {
+	if !st.Active {
+		return st
+	}
+	if rr, err := dns.NewRR(st.PublicKey); err == nil {
+		if key, ok := rr.(*dns.KEY); ok {
+			st.KeyName = key.Header().Name
+			st.Algorithm = dns.AlgorithmToString[key.Algorithm]
+			st.KeyTag = key.KeyTag()
+		}
+	}
+	return st
+}
+
+// signSIG0 adds a SIG(0) signature to m. This must be the last change to m before it is
+// sent.
+func (signer *Signer) signSIG0(m *dns.Msg) error {
+	key, priv, err := parseSIG0Key(signer.SIG0)
+	if err != nil {
+		return fmt.Errorf("Signer %s: %v", signer.Name, err)
+	}
+	now := time.Now().Unix()
+	sig := &dns.SIG{RRSIG: dns.RRSIG{
+		Algorithm:  key.Algorithm,
+		KeyTag:     key.KeyTag(),
+		SignerName: dns.Fqdn(strings.ToLower(key.Header().Name)),
+		Inception:  uint32(now - 300),
+		Expiration: uint32(now + 300),
+	}}
+	if _, err := sig.Sign(priv, m); err != nil {
+		return fmt.Errorf("Signer %s: SIG(0) signing failed: %v", signer.Name, err)
+	}
+	// Sign() only returns the signed message, but packing m with the signature appended
+	// produces the same bytes
+	m.Extra = append(m.Extra, sig)
+	return nil
+}
+
+// hasUpdateAuth returns true if the signer has a TSIG or SIG(0) key.
+func (signer *Signer) hasUpdateAuth() bool {
+	return signer.Auth.TSIGKey != "" || signer.SIG0.Active
+}
@@ -0,0 +1,48 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSignSIG0(t *testing.T) {
+	key := &dns.KEY{DNSKEY: dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "music.", Rrtype: dns.TypeKEY, Class: dns.ClassINET},
+		Flags:     512,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := SignerSIG0{Active: true, PublicKey: key.String(), PrivateKey: key.PrivateKeyString(priv)}
+	if _, _, err := parseSIG0Key(st); err != nil {
+		t.Fatalf("parseSIG0Key: %v", err)
+	}
+
+	signer := &Signer{Name: "signer1", SIG0: st}
+	m := new(dns.Msg)
+	m.SetUpdate("example.com.")
+	if err := signer.signSIG0(m); err != nil {
+		t.Fatalf("signSIG0: %v", err)
+	}
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, ok := m.Extra[len(m.Extra)-1].(*dns.SIG)
+	if !ok {
+		t.Fatalf("no SIG(0) record added: %v", m.Extra)
+	}
+	if err := sig.Verify(key, buf); err != nil {
+		t.Errorf("SIG(0) signature does not verify: %v", err)
+	}
+
+	// keys that are not KEY RRs, or of unsupported algorithms, are rejected
+	st.PublicKey = "music. 3600 IN DNSKEY 256 3 13 " + key.PublicKey
+	if _, _, err := parseSIG0Key(st); err == nil {
+		t.Errorf("parseSIG0Key accepted a DNSKEY RR")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Signer templates. Most signers run on one of a handful of platforms, and for each of
+// them the right updater, port, auth method and concurrency limit are known in advance.
+// A template is selected when a signer is added (signer add --template), so that the
+// operator only has to provide the address and the credentials. Explicitly given values
+// take precedence over the template.
+
+type SignerTemplate struct {
+	Name        string
+	Description string
+	Method      string // updater
+	Address     string // default address, if the platform has a well-known one
+	Port        string
+	AuthMethod  string   // "tsig", "login" (signers.desec.* in musicd.yaml) or "aws"
+	MaxZones    int      // concurrent zones, 0 = default (see signerlimits.go)
+	Quirks      []string // things the operator should know about the platform
+}
+
+var SignerTemplates = map[string]SignerTemplate{
+	"bind": {
+		Name:        "bind",
+		Description: "BIND 9 with dynamic updates signed with TSIG",
+		Method:      "ddns",
+		Port:        "53",
+		AuthMethod:  "tsig",
+		Quirks: []string{
+			"the zone must have an update-policy (or allow-update) granting the TSIG key",
+			"with dnssec-policy, CDS/CDNSKEY are managed by BIND unless cds-digest-types is empty",
+		},
+	},
+	"knot": {
+		Name:        "knot",
+		Description: "Knot DNS with dynamic updates signed with TSIG",
+		Method:      "ddns",
+		Port:        "53",
+		AuthMethod:  "tsig",
+		Quirks: []string{
+			"the zone needs an ACL with action: update for the TSIG key",
+			"automatic signing must not publish CDS/CDNSKEY (cds-cdnskey-publish: none)",
+		},
+	},
+	"powerdns": {
+		Name:        "powerdns",
+		Description: "PowerDNS Authoritative with dynamic updates signed with TSIG",
+		Method:      "rlddns",
+		Port:        "53",
+		AuthMethod:  "tsig",
+		MaxZones:    10,
+		Quirks: []string{
+			"dnsupdate=yes is required and the key must be set in TSIG-ALLOW-DNSUPDATE per zone",
+			"DNSKEY records of other signers are added with pdnsutil import-zone-key, not via updates",
+		},
+	},
+	"desec": {
+		Name:        "desec",
+		Description: "deSEC (desec.io) via its REST API",
+		Method:      "rldesec-api",
+		Address:     "desec.io",
+		Port:        "443",
+		AuthMethod:  "login",
+		MaxZones:    5,
+		Quirks: []string{
+			"the API is rate limited, requests are queued by the rldesec-api updater",
+			"the minimum TTL is 3600 seconds",
+			"DNSKEY, CDS and CDNSKEY are managed by deSEC, only additional DNSKEYs can be added",
+		},
+	},
+	"route53": {
+		Name:        "route53",
+		Description: "AWS Route 53 via the AWS API",
+		Method:      "route53",
+		Address:     "route53.amazonaws.com",
+		Port:        "443",
+		AuthMethod:  "aws",
+		MaxZones:    5,
+		Quirks: []string{
+			"the API allows 5 requests per second per account",
+			"Route 53 signs with its own KSK (managed in KMS) and does not publish CDS/CDNSKEY",
+		},
+	},
+}
+
+func GetSignerTemplate(name string) (SignerTemplate, error) {
+	t, ok := SignerTemplates[strings.ToLower(name)]
+	if !ok {
+		return t, fmt.Errorf("Unknown signer template: %s. Known templates are: %s", name,
+			strings.Join(ListSignerTemplates(), ", "))
+	}
+	return t, nil
+}
+
+func ListSignerTemplates() []string {
+	var names []string
+	for name := range SignerTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply fills in the values of the signer that were not given explicitly.
+func (t SignerTemplate) Apply(s *Signer) error {
+	if _, ok := Updaters[t.Method]; !ok {
+		return fmt.Errorf("Signer template %s requires the %s updater, which is not available.",
+			t.Name, t.Method)
+	}
+	if s.Method != "" && s.Method != t.Method {
+		return fmt.Errorf("Signer template %s uses method %s, not %s.", t.Name, t.Method, s.Method)
+	}
+	s.Method = t.Method
+	if s.Address == "" {
+		s.Address = t.Address
+	}
+	if s.Port == "" {
+		s.Port = t.Port
+	}
+	if t.AuthMethod == "tsig" && s.Auth.TSIGKey == "" {
+		return fmt.Errorf("Signer template %s requires a TSIG key (--auth algname:key.name:secret).",
+			t.Name)
+	}
+	if s.Address == "" {
+		return fmt.Errorf("Signer template %s requires an address.", t.Name)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package music
+
+import "testing"
+
+func TestSignerTemplateApply(t *testing.T) {
+	tmpl, err := GetSignerTemplate("deSEC")
+	if err != nil {
+		t.Fatalf("GetSignerTemplate: %v", err)
+	}
+	s := &Signer{Name: "desec1"}
+	if err := tmpl.Apply(s); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if s.Method != "rldesec-api" || s.Address != "desec.io" || s.Port != "443" {
+		t.Errorf("Apply: got method %s, address %s, port %s", s.Method, s.Address, s.Port)
+	}
+
+	tmpl, _ = GetSignerTemplate("bind")
+	s = &Signer{Name: "bind1", Address: "192.0.2.1", Port: "5353"}
+	if err := tmpl.Apply(s); err == nil {
+		t.Errorf("Apply: expected an error without a TSIG key")
+	}
+	s.Auth.TSIGKey = "c2VjcmV0"
+	if err := tmpl.Apply(s); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if s.Method != "ddns" || s.Port != "5353" {
+		t.Errorf("Apply: got method %s, port %s, want ddns, 5353", s.Method, s.Port)
+	}
+	s.Method = "desec-api"
+	if err := tmpl.Apply(s); err == nil {
+		t.Errorf("Apply: expected an error for a conflicting method")
+	}
+
+	if _, err := GetSignerTemplate("nosuchvendor"); err == nil {
+		t.Errorf("GetSignerTemplate: expected an error for an unknown template")
+	}
+}
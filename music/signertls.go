@@ -0,0 +1,133 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DNS over TLS to DDNS signers. TSIG authenticates the updates but does not hide them,
+// so signers that are reached across untrusted networks may instead be reached over TLS
+// (RFC 7858). The server certificate of the signer is verified against the CA in
+// CAFile (or the system roots if not set) and must be valid for ServerName (default:
+// the address of the signer). TSIG is still used on top of TLS. Note that the port of
+// the signer must be changed to the DoT port (usually 853) separately.
+
+type SignerTLS struct {
+	Active     bool
+	CAFile     string // PEM file with the CA certificate(s) of the signer, "" = system roots
+	ServerName string // name in the server certificate, "" = the address of the signer
+}
+
+func (mdb *MusicDB) SignerSetTLS(tx *sql.Tx, dbsigner *Signer, st SignerTLS) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	if dbsigner.Method != "ddns" && dbsigner.Method != "rlddns" {
+		return "", fmt.Errorf("Signer %s has method %s. TLS is only supported for DDNS signers.",
+			dbsigner.Name, dbsigner.Method)
+	}
+	if st.Active && st.CAFile != "" {
+		if _, err := loadCAFile(st.CAFile); err != nil {
+			return "", err
+		}
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerSetTLS: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if !st.Active {
+		err = mdb.setSignerOption(tx, dbsigner.Name, signerOptTLS, "")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Signer %s is no longer accessed over TLS.", dbsigner.Name), nil
+	}
+
+	err = mdb.setSignerOptionJSON(tx, dbsigner.Name, signerOptTLS, st)
+	if err != nil {
+		return "", err
+	}
+	msg := fmt.Sprintf("Signer %s is now accessed over TLS.", dbsigner.Name)
+	if dbsigner.Port == "53" {
+		msg += " Note: the signer port is still 53, DNS over TLS normally uses port 853."
+	}
+	return msg, nil
+}
+
+func loadCAFile(cafile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(cafile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read CA file %s: %v", cafile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in CA file %s", cafile)
+	}
+	return pool, nil
+}
+
+// noCAs is the (shared) pool used when the CA file of a signer can not be read.
+var noCAs = x509.NewCertPool()
+
+var caPools = struct {
+	sync.Mutex
+	pools map[string]caPool
+}{pools: map[string]caPool{}}
+
+type caPool struct {
+	modtime time.Time
+	pool    *x509.CertPool
+}
+
+// cachedCAFile is loadCAFile, but the CA file is only read again when it has changed.
+func cachedCAFile(cafile string) (*x509.CertPool, error) {
+	fi, err := os.Stat(cafile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read CA file %s: %v", cafile, err)
+	}
+	caPools.Lock()
+	defer caPools.Unlock()
+	if cp, ok := caPools.pools[cafile]; ok && cp.modtime.Equal(fi.ModTime()) {
+		return cp.pool, nil
+	}
+	pool, err := loadCAFile(cafile)
+	if err != nil {
+		return nil, err
+	}
+	caPools.pools[cafile] = caPool{modtime: fi.ModTime(), pool: pool}
+	return pool, nil
+}
+
+// tlsConfig returns the TLS config for connections to the signer. The server name
+// defaults to servername. If the CA file can not be read, the config trusts no CA at
+// all, i.e. the connection fails rather than falls back to an unverified one.
+func (signer *Signer) tlsConfig(servername string) *tls.Config {
+	conf := &tls.Config{ServerName: signer.TLS.ServerName, MinVersion: tls.VersionTLS12}
+	if conf.ServerName == "" {
+		conf.ServerName = servername
+	}
+	if signer.TLS.CAFile != "" {
+		pool, err := cachedCAFile(signer.TLS.CAFile)
+		if err != nil {
+			log.Printf("DDNS: signer %s: %v. No server certificate will be accepted.", signer.Name, err)
+			pool = noCAs
+		}
+		conf.RootCAs = pool
+	}
+	return conf
+}
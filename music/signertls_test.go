@@ -0,0 +1,22 @@
+package music
+
+import "testing"
+
+func TestSignerTLSConfig(t *testing.T) {
+	signer := &Signer{Name: "s1", Address: "signer.example.net", TLS: SignerTLS{Active: true}}
+	conf := signer.tlsConfig(signer.Address)
+	if conf.ServerName != "signer.example.net" || conf.RootCAs != nil {
+		t.Errorf("tlsConfig() = %+v", conf)
+	}
+
+	signer.TLS.ServerName = "dot.example.net"
+	signer.TLS.CAFile = "/nonexistent/ca.pem"
+	conf = signer.tlsConfig(signer.Address)
+	if conf.ServerName != "dot.example.net" {
+		t.Errorf("ServerName = %s", conf.ServerName)
+	}
+	// an unreadable CA file must not fall back to the system roots
+	if conf.RootCAs == nil {
+		t.Errorf("RootCAs is nil with an unreadable CA file")
+	}
+}
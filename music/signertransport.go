@@ -0,0 +1,222 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Transport to DDNS signers. The transport is selected per signer:
+//
+//   udp:   debugging only (the usetcp flag of the signer is off).
+//   tcp:   the default.
+//   tls:   DNS over TLS, see signertls.go.
+//   https: the fetches are sent as DNS over HTTPS (RFC 8484) to the DoH URL of the
+//          signer, e.g. when only HTTPS gets through the firewalls (or via the HTTPS
+//          proxy in the HTTPS_PROXY environment variable) in between. Updates are not
+//          accepted by most DoH frontends and are still sent over TLS or TCP.
+//
+// The CA file and server name of the TLS settings of the signer, if any, also apply to
+// the DoH server.
+
+const (
+	TransportUDP   = "udp"
+	TransportTCP   = "tcp"
+	TransportTLS   = "tls"
+	TransportHTTPS = "https"
+)
+
+// Transport returns the transport used for fetches from the signer.
+func (signer *Signer) Transport() string {
+	switch {
+	case signer.DoHURL != "":
+		return TransportHTTPS
+	case signer.TLS.Active:
+		return TransportTLS
+	case signer.UseTcp:
+		return TransportTCP
+	}
+	return TransportUDP
+}
+
+func (mdb *MusicDB) SignerSetTransport(tx *sql.Tx, dbsigner *Signer, transport, dohurl string) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	if dbsigner.Method != "ddns" && dbsigner.Method != "rlddns" {
+		return "", fmt.Errorf("Signer %s has method %s. The transport can only be selected for DDNS signers.",
+			dbsigner.Name, dbsigner.Method)
+	}
+	if transport == TransportHTTPS {
+		u, err := url.Parse(dohurl)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return "", fmt.Errorf("Transport https requires a DoH URL, e.g. https://signer.example.net/dns-query")
+		}
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerSetTransport: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "UPDATE signers SET usetcp=? WHERE name=?"
+	switch transport {
+	case TransportUDP, TransportTCP:
+		_, err = tx.Exec(sqlq, transport == TransportTCP, dbsigner.Name)
+		if CheckSQLError("SignerSetTransport", sqlq, err, false) {
+			return "", err
+		}
+		if err = mdb.setSignerOption(tx, dbsigner.Name, signerOptTLS, ""); err != nil {
+			return "", err
+		}
+		err = mdb.setSignerOption(tx, dbsigner.Name, signerOptDoH, "")
+	case TransportTLS:
+		_, err = tx.Exec(sqlq, true, dbsigner.Name)
+		if CheckSQLError("SignerSetTransport", sqlq, err, false) {
+			return "", err
+		}
+		// the CA file and server name are kept if already set with "signer set-tls"
+		st := dbsigner.TLS
+		st.Active = true
+		if err = mdb.setSignerOptionJSON(tx, dbsigner.Name, signerOptTLS, st); err != nil {
+			return "", err
+		}
+		err = mdb.setSignerOption(tx, dbsigner.Name, signerOptDoH, "")
+	case TransportHTTPS:
+		err = mdb.setSignerOption(tx, dbsigner.Name, signerOptDoH, dohurl)
+	default:
+		return "", fmt.Errorf("Unknown transport '%s'. Known transports are: %s, %s, %s, %s", transport,
+			TransportUDP, TransportTCP, TransportTLS, TransportHTTPS)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if transport == TransportHTTPS {
+		return fmt.Sprintf("Fetches from signer %s are now sent to %s. Updates are still sent over %s.",
+			dbsigner.Name, dohurl, (&Signer{UseTcp: dbsigner.UseTcp, TLS: dbsigner.TLS}).Transport()), nil
+	}
+	return fmt.Sprintf("Signer %s is now accessed over %s.", dbsigner.Name, transport), nil
+}
+
+// FetchExchange sends the query m to the signer over the transport of the signer.
+func (signer *Signer) FetchExchange(m *dns.Msg) (*dns.Msg, error) {
+	if signer.DoHURL == "" {
+		c := signer.NewDnsClient()
+		if err := signer.PrepareTSIGExchange(c, m); err != nil {
+			return nil, err
+		}
+		r, _, err := signer.Exchange(c, m)
+		return r, err
+	}
+	c := &dns.Client{Net: TransportHTTPS, TLSConfig: signer.tlsConfig(""), Timeout: 10 * time.Second}
+	m.Id = 0 // as dohExchange would, but before a SIG(0) signature is added
+	if err := signer.PrepareTSIGExchange(c, m); err != nil {
+		return nil, err
+	}
+	r, _, err := DnsExchange(c, m, signer.DoHURL)
+	return r, err
+}
+
+var dohClients = struct {
+	sync.Mutex
+	clients map[string]*http.Client
+}{clients: map[string]*http.Client{}}
+
+// dohClient returns an HTTP client for the TLS config of c. The clients are kept, so
+// that the connections to the DoH servers are reused.
+func dohClient(c *dns.Client) *http.Client {
+	key := ""
+	if c.TLSConfig != nil {
+		// the CA pools are cached (see cachedCAFile), so the same pool means the same CA
+		key = fmt.Sprintf("%s|%p", c.TLSConfig.ServerName, c.TLSConfig.RootCAs)
+	}
+	dohClients.Lock()
+	defer dohClients.Unlock()
+	if hc, ok := dohClients.clients[key]; ok {
+		return hc
+	}
+	hc := &http.Client{
+		Timeout: c.Timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: c.TLSConfig,
+			IdleConnTimeout: dnsPoolIdle(),
+		},
+	}
+	dohClients.clients[key] = hc
+	return hc
+}
+
+// dohExchange sends m as a DNS over HTTPS POST request to dohurl. A TSIG signature is
+// generated and verified here, as there is no dns.Conn to do it.
+func dohExchange(c *dns.Client, m *dns.Msg, dohurl string) (*dns.Msg, time.Duration, error) {
+	m.Id = 0 // RFC 8484, section 4.1
+
+	var buf []byte
+	var mac, secret string
+	var err error
+	if t := m.IsTsig(); t != nil {
+		secret = c.TsigSecret[t.Hdr.Name]
+		buf, mac, err = dns.TsigGenerate(m, secret, "", false)
+	} else {
+		buf, err = m.Pack()
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, dohurl, bytes.NewReader(buf))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	t := time.Now()
+	resp, err := dohClient(c).Do(req)
+	if err != nil {
+		return nil, time.Since(t), err
+	}
+	defer resp.Body.Close()
+	rbuf, err := ioutil.ReadAll(resp.Body)
+	rtt := time.Since(t)
+	if err != nil {
+		return nil, rtt, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, rtt, fmt.Errorf("DoH request to %s failed: %s", dohurl, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/dns-message") {
+		return nil, rtt, fmt.Errorf("DoH request to %s: unexpected content type '%s'", dohurl, ct)
+	}
+
+	r := new(dns.Msg)
+	if err := r.Unpack(rbuf); err != nil {
+		return nil, rtt, err
+	}
+	if mac != "" {
+		if r.IsTsig() == nil {
+			return r, rtt, fmt.Errorf("DoH response from %s is not TSIG signed", dohurl)
+		}
+		if err := dns.TsigVerify(rbuf, secret, mac, false); err != nil {
+			return r, rtt, err
+		}
+	}
+	return r, rtt, nil
+}
@@ -0,0 +1,64 @@
+package music
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDohExchange(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		m := new(dns.Msg)
+		if r.Header.Get("Content-Type") != "application/dns-message" || m.Unpack(buf) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		resp := new(dns.Msg)
+		resp.SetReply(m)
+		resp.Answer = append(resp.Answer, &dns.NS{
+			Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 3600},
+			Ns:  "ns1.example.net.",
+		})
+		out, _ := resp.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(out)
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	c := &dns.Client{Net: TransportHTTPS, TLSConfig: &tls.Config{RootCAs: pool}}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeNS)
+	r, _, err := dohExchange(c, m, srv.URL+"/dns-query")
+	if err != nil {
+		t.Fatalf("dohExchange: %v", err)
+	}
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.NS).Ns != "ns1.example.net." {
+		t.Errorf("answer = %v", r.Answer)
+	}
+}
+
+func TestSignerTransport(t *testing.T) {
+	tests := []struct {
+		signer Signer
+		want   string
+	}{
+		{Signer{}, TransportUDP},
+		{Signer{UseTcp: true}, TransportTCP},
+		{Signer{UseTcp: true, TLS: SignerTLS{Active: true}}, TransportTLS},
+		{Signer{UseTcp: true, DoHURL: "https://signer.example.net/dns-query"}, TransportHTTPS},
+	}
+	for _, tc := range tests {
+		if got := tc.signer.Transport(); got != tc.want {
+			t.Errorf("Transport(%+v) = %s, want %s", tc.signer, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Signer onboarding verification. Before a new signer is trusted with real zones it is
+// checked against a designated test zone: the SOA and DNSKEY RRsets must be possible to
+// fetch via the updater of the signer and (for DDNS signers) a no-op update must be
+// accepted, which proves that the TSIG key works. With signers.verification.required
+// set, only verified signers may join a signer group.
+
+type SignerVerification struct {
+	Signer   string
+	Verified bool
+	Time     time.Time
+	TestZone string
+	Report   []string
+}
+
+// VerifySigner runs the verification suite against the signer and records the result.
+func (mdb *MusicDB) VerifySigner(tx *sql.Tx, dbsigner *Signer, testzone string) (*SignerVerification, error) {
+	if !dbsigner.Exists {
+		return nil, fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	if testzone == "" {
+		testzone = viper.GetString("signers.verification.testzone")
+	}
+	if testzone == "" {
+		return nil, fmt.Errorf("No test zone specified and signers.verification.testzone not set.")
+	}
+	testzone = dns.Fqdn(testzone)
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("VerifySigner: Error from mdb.StartTransaction(): %v\n", err)
+		return nil, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	sv := SignerVerification{
+		Signer:   dbsigner.Name,
+		Time:     time.Now(),
+		TestZone: testzone,
+		Verified: true,
+	}
+	check := func(name string, err error) {
+		if err != nil {
+			sv.Verified = false
+			sv.Report = append(sv.Report, fmt.Sprintf("%s: FAIL: %v", name, err))
+		} else {
+			sv.Report = append(sv.Report, fmt.Sprintf("%s: ok", name))
+		}
+	}
+
+	updater := GetUpdater(dbsigner.Method)
+	for _, rrtype := range []uint16{dns.TypeSOA, dns.TypeDNSKEY} {
+		name := "fetch " + dns.TypeToString[rrtype]
+		err, rrs := updater.FetchRRset(dbsigner, testzone, testzone, rrtype)
+		if err == nil && len(rrs) == 0 {
+			err = fmt.Errorf("no %s RRset returned for %s", dns.TypeToString[rrtype], testzone)
+		}
+		check(name, err)
+	}
+
+	switch dbsigner.Method {
+	case "ddns", "rlddns":
+		if !dbsigner.UseTSIG {
+			sv.Report = append(sv.Report, "no-op update: skipped, TSIG not in use")
+			break
+		}
+		for _, ep := range dbsigner.Endpoints() {
+			check("no-op update via "+ep.Name, ep.VerifyTSIG(testzone))
+		}
+	default:
+		// API based signers have no write operation that is guaranteed to be a no-op.
+		// The authenticated fetches above verify the credentials.
+		sv.Report = append(sv.Report, fmt.Sprintf("no-op update: not available for method %s",
+			dbsigner.Method))
+	}
+
+	err = mdb.setSignerOptionJSON(tx, sv.Signer, signerOptVerification, sv)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("VerifySigner: signer %s verified: %v (test zone %s)", sv.Signer, sv.Verified,
+		sv.TestZone)
+	return &sv, nil
+}
+
+func (mdb *MusicDB) GetSignerVerification(tx *sql.Tx, signer string) (*SignerVerification, error) {
+	value, err := mdb.getSignerOption(tx, signer, signerOptVerification)
+	if err != nil || value == "" {
+		return nil, err
+	}
+	var sv SignerVerification
+	err = signerOptions{signerOptVerification: value}.decode(signerOptVerification, &sv)
+	if err != nil {
+		return nil, err
+	}
+	return &sv, nil
+}
+
+// signerResetVerification is called when the address or credentials of a signer change.
+func (mdb *MusicDB) signerResetVerification(tx *sql.Tx, signer string) error {
+	return mdb.setSignerOption(tx, signer, signerOptVerification, "")
+}
+
+// signerCheckVerified returns an error if verification is required and the signer
+// has not passed it.
+func (mdb *MusicDB) signerCheckVerified(tx *sql.Tx, signer string) error {
+	if !viper.GetBool("signers.verification.required") {
+		return nil
+	}
+	sv, err := mdb.GetSignerVerification(tx, signer)
+	if err != nil {
+		return err
+	}
+	if sv == nil {
+		return fmt.Errorf("Signer %s has not been verified. Run 'signer verify' first.", signer)
+	}
+	if !sv.Verified {
+		return fmt.Errorf("Signer %s failed verification at %s: %s", signer,
+			sv.Time.Format(time.RFC3339), strings.Join(sv.Report, "; "))
+	}
+	return nil
+}
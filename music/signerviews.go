@@ -0,0 +1,218 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Split-horizon signers. A DDNS signer may serve different data to different audiences
+// (e.g. "internal" and "external"), with each view reached via its own address and
+// TSIG key. The signer itself (its address and TSIG key) is the default view. Every
+// update made by a process is applied to all views and every fetch compares the views,
+// so that a process does not move forward while the views disagree.
+
+type SignerView struct {
+	Name    string
+	Address string
+	Port    string // empty = same as signer
+	AuthStr string // empty = same as signer
+	Auth    AuthData
+}
+
+// storedView is a view as kept in the views option of the signer.
+type storedView struct {
+	Name    string
+	Address string
+	Port    string
+	Auth    string
+}
+
+func (v storedView) signerView() SignerView {
+	return SignerView{Name: v.Name, Address: v.Address, Port: v.Port, AuthStr: v.Auth,
+		Auth: ParseAuthStr(v.Auth)}
+}
+
+func (mdb *MusicDB) getStoredViews(tx *sql.Tx, signer string) ([]storedView, error) {
+	var views []storedView
+	value, err := mdb.getSignerOption(tx, signer, signerOptViews)
+	if err != nil {
+		return views, err
+	}
+	err = signerOptions{signerOptViews: value}.decode(signerOptViews, &views)
+	if value == "" {
+		err = nil
+	}
+	return views, err
+}
+
+func (mdb *MusicDB) GetSignerViews(tx *sql.Tx, signer string) ([]SignerView, error) {
+	var views []SignerView
+	stored, err := mdb.getStoredViews(tx, signer)
+	for _, v := range stored {
+		views = append(views, v.signerView())
+	}
+	return views, err
+}
+
+func (mdb *MusicDB) SignerAddView(tx *sql.Tx, dbsigner *Signer, view SignerView) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	if dbsigner.Method != "ddns" && dbsigner.Method != "rlddns" {
+		return "", fmt.Errorf("Signer %s has method %s. Views are only supported for DDNS signers.",
+			dbsigner.Name, dbsigner.Method)
+	}
+	if view.Name == "" || view.Address == "" {
+		return "", fmt.Errorf("A view must have a name and an address.")
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerAddView: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	authstr := ""
+	if view.Auth.TSIGKey != "" {
+		authstr = view.Auth.TSIGString()
+	}
+
+	views, err := mdb.getStoredViews(tx, dbsigner.Name)
+	if err != nil {
+		return "", err
+	}
+	sv := storedView{Name: view.Name, Address: view.Address, Port: view.Port, Auth: authstr}
+	i := sort.Search(len(views), func(i int) bool { return views[i].Name >= view.Name })
+	if i < len(views) && views[i].Name == view.Name {
+		views[i] = sv
+	} else {
+		views = append(views[:i], append([]storedView{sv}, views[i:]...)...)
+	}
+	err = mdb.setSignerOptionJSON(tx, dbsigner.Name, signerOptViews, views)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Signer %s: view %s (%s) added.", dbsigner.Name, view.Name,
+		view.Address), nil
+}
+
+func (mdb *MusicDB) SignerDeleteView(tx *sql.Tx, dbsigner *Signer, view string) (string, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerDeleteView: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	views, err := mdb.getStoredViews(tx, dbsigner.Name)
+	if err != nil {
+		return "", err
+	}
+	var keep []storedView
+	for _, v := range views {
+		if v.Name != view {
+			keep = append(keep, v)
+		}
+	}
+	if len(keep) == len(views) {
+		return "", fmt.Errorf("Signer %s has no view %s.", dbsigner.Name, view)
+	}
+	if len(keep) == 0 {
+		err = mdb.setSignerOption(tx, dbsigner.Name, signerOptViews, "")
+	} else {
+		err = mdb.setSignerOptionJSON(tx, dbsigner.Name, signerOptViews, keep)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Signer %s: view %s deleted.", dbsigner.Name, view), nil
+}
+
+// Endpoints returns the signer followed by one pseudo-signer per view, each with the
+// address and TSIG key of that view.
+func (s *Signer) Endpoints() []*Signer {
+	eps := []*Signer{s}
+	for _, v := range s.Views {
+		ep := *s
+		ep.Name = s.Name + "/" + v.Name
+		ep.Address = v.Address
+		ep.Addresses = nil
+		if v.Port != "" {
+			ep.Port = v.Port
+		}
+		if v.Auth.TSIGKey != "" {
+			ep.Auth = v.Auth
+			ep.AuthStr = v.AuthStr
+		}
+		ep.Views = nil
+		eps = append(eps, &ep)
+	}
+	return eps
+}
+
+// ViewUpdater wraps a DDNS updater and applies every operation to all views of the signer.
+type ViewUpdater struct {
+	Updater
+}
+
+func (u *ViewUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	for _, ep := range signer.Endpoints() {
+		if err := u.Updater.Update(ep, zone, fqdn, inserts, removes); err != nil {
+			return fmt.Errorf("%s: %v", ep.Name, err)
+		}
+	}
+	return nil
+}
+
+func (u *ViewUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	for _, ep := range signer.Endpoints() {
+		if err := u.Updater.RemoveRRset(ep, zone, fqdn, rrsets); err != nil {
+			return fmt.Errorf("%s: %v", ep.Name, err)
+		}
+	}
+	return nil
+}
+
+func rrsetKey(rrs []dns.RR) string {
+	var l []string
+	for _, rr := range rrs {
+		// the TTL may differ between views without the data being different
+		rc := dns.Copy(rr)
+		rc.Header().Ttl = 0
+		l = append(l, rc.String())
+	}
+	sort.Strings(l)
+	return strings.Join(l, "\n")
+}
+
+// FetchRRset returns the RRset from the default view, but only if all other views
+// serve the same RRset.
+func (u *ViewUpdater) FetchRRset(signer *Signer, zone, fqdn string, rrtype uint16) (error, []dns.RR) {
+	err, rrs := u.Updater.FetchRRset(signer, zone, fqdn, rrtype)
+	if err != nil || len(signer.Views) == 0 {
+		return err, rrs
+	}
+
+	key := rrsetKey(rrs)
+	for _, ep := range signer.Endpoints()[1:] {
+		err, vrrs := u.Updater.FetchRRset(ep, zone, fqdn, rrtype)
+		if err != nil {
+			return fmt.Errorf("%s: %v", ep.Name, err), []dns.RR{}
+		}
+		if rrsetKey(vrrs) != key {
+			return fmt.Errorf("Signer %s: %s %s RRset differs between views (%s differs from default view)",
+				signer.Name, fqdn, dns.TypeToString[rrtype], ep.Name), []dns.RR{}
+		}
+	}
+	return nil, rrs
+}
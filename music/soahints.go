@@ -0,0 +1,143 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// SOA based scheduling hints. A zone in a state whose transitions wait for a change to
+// propagate (FSMTransition.Propagation) is by default re-evaluated on every run of the
+// FSM engine, although a slow-moving zone will not have changed in between. With
+// fsmengine.soahints.active set, the SOA timers observed at the signers are used as a
+// hint instead: the zone is re-evaluated every SOA refresh seconds, or every SOA retry
+// seconds while the signers do not agree on the serial (i.e. a transfer is pending).
+// The interval is bounded by fsmengine.intervals.minimum and fsmengine.soahints.maximum.
+// Zones explicitly asked for (e.g. "zone step") are always evaluated.
+
+type soaHint struct {
+	State    string // process/state the hint was computed for
+	Interval time.Duration
+	Next     time.Time
+}
+
+var soaHints = struct {
+	sync.Mutex
+	zones map[string]*soaHint
+}{zones: map[string]*soaHint{}}
+
+func soaHintsActive() bool {
+	return viper.GetBool("fsmengine.soahints.active")
+}
+
+func soaHintBounds() (time.Duration, time.Duration) {
+	min := viper.GetInt("fsmengine.intervals.minimum")
+	if min < 15 {
+		min = 15
+	}
+	max := viper.GetInt("fsmengine.soahints.maximum")
+	if max <= 0 {
+		max = 3600
+	}
+	if max < min {
+		max = min
+	}
+	return time.Duration(min) * time.Second, time.Duration(max) * time.Second
+}
+
+// soaHintInterval returns the re-evaluation interval given the SOAs of the signers.
+func soaHintInterval(soas []*dns.SOA, min, max time.Duration) time.Duration {
+	if len(soas) == 0 {
+		return min
+	}
+	insync := true
+	var refresh, retry uint32
+	for i, soa := range soas {
+		if i == 0 || soa.Refresh < refresh {
+			refresh = soa.Refresh
+		}
+		if i == 0 || soa.Retry < retry {
+			retry = soa.Retry
+		}
+		insync = insync && soa.Serial == soas[0].Serial
+	}
+	res := time.Duration(refresh) * time.Second
+	if !insync {
+		res = time.Duration(retry) * time.Second
+	}
+	switch {
+	case res < min:
+		return min
+	case res > max:
+		return max
+	}
+	return res
+}
+
+// propagationWait returns true if the transitions from the current state of the zone
+// wait for propagation.
+func (mdb *MusicDB) propagationWait(z *Zone) bool {
+	for _, t := range mdb.FSMlist[z.FSM].States[z.State].Next {
+		if t.Propagation {
+			return true
+		}
+	}
+	return false
+}
+
+// signerSOAs returns the SOAs of the zone at the signers of the group.
+func signerSOAs(z *Zone) []*dns.SOA {
+	var soas []*dns.SOA
+	if z.SGroup == nil {
+		return soas
+	}
+	for _, s := range z.SGroup.SignerMap {
+		m := new(dns.Msg)
+		m.SetQuestion(z.Name, dns.TypeSOA)
+		r, _, err := s.Exchange(new(dns.Client), m)
+		if err != nil {
+			log.Printf("signerSOAs: zone %s: unable to fetch SOA from %s: %v", z.Name, s.Name, err)
+			continue
+		}
+		for _, rr := range r.Answer {
+			if soa, ok := rr.(*dns.SOA); ok {
+				soas = append(soas, soa)
+			}
+		}
+	}
+	return soas
+}
+
+// soaHintDue returns true if the zone should be evaluated now. If not, the time of the
+// next evaluation is returned.
+func (mdb *MusicDB) soaHintDue(z *Zone) (time.Time, bool) {
+	if !soaHintsActive() || !mdb.propagationWait(z) {
+		return time.Time{}, true
+	}
+	state := z.FSM + "/" + z.State
+
+	soaHints.Lock()
+	h, exist := soaHints.zones[z.Name]
+	soaHints.Unlock()
+	if exist && h.State == state && time.Now().Before(h.Next) {
+		return h.Next, false
+	}
+
+	min, max := soaHintBounds()
+	interval := soaHintInterval(signerSOAs(z), min, max)
+	if !exist || h.State != state || h.Interval != interval {
+		log.Printf("Zone %s: waiting for propagation in state %s, re-evaluating every %v (SOA hint)",
+			z.Name, z.State, interval)
+	}
+	soaHints.Lock()
+	soaHints.zones[z.Name] = &soaHint{State: state, Interval: interval, Next: time.Now().Add(interval)}
+	soaHints.Unlock()
+	return time.Time{}, true
+}
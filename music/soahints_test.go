@@ -0,0 +1,30 @@
+package music
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSOAHintInterval(t *testing.T) {
+	soa := func(serial, refresh, retry uint32) *dns.SOA {
+		return &dns.SOA{Serial: serial, Refresh: refresh, Retry: retry}
+	}
+	min, max := 15*time.Second, time.Hour
+	for _, tc := range []struct {
+		soas []*dns.SOA
+		want time.Duration
+	}{
+		{nil, min},
+		{[]*dns.SOA{soa(1, 900, 300)}, 900 * time.Second},
+		{[]*dns.SOA{soa(1, 900, 300), soa(1, 600, 120)}, 600 * time.Second},
+		{[]*dns.SOA{soa(1, 900, 300), soa(2, 900, 300)}, 300 * time.Second},
+		{[]*dns.SOA{soa(1, 86400, 7200)}, max},
+		{[]*dns.SOA{soa(1, 5, 1)}, min},
+	} {
+		if got := soaHintInterval(tc.soas, min, max); got != tc.want {
+			t.Errorf("soaHintInterval(%v) = %v, want %v", tc.soas, got, tc.want)
+		}
+	}
+}
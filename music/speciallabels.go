@@ -0,0 +1,188 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Wildcards and special labels. A wildcard directly below the apex answers for every
+// name below the zone that does not exist, so a fetch of such a name from a signer may
+// return synthesized records that a process would then write back to the other signers
+// as real ones. Some underscore labels (e.g. _acme-challenge, or the _dsboot and _signal
+// labels of authenticated DNSSEC bootstrapping) are maintained by each signer itself and
+// legitimately differ between signers. MUSIC never manages such names, but they are
+// listed with the managed names so that an operator sees whether the signers agree.
+
+var specialLabelDefaults = []string{"_acme-challenge", "_dsboot", "_signal"}
+
+// the RR types shown for the wildcard and the special labels
+var specialRRtypes = map[string][]uint16{
+	"*": {dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeMX, dns.TypeTXT},
+	"_": {dns.TypeTXT},
+}
+
+type SpecialRRset struct {
+	Owner  string
+	RRtype string
+	Reason string              // "wildcard" or "special label"
+	RRs    map[string][]string // map[signer][]RRs
+	InSync bool                // all signers serve the same RRset
+}
+
+func specialLabels() []string {
+	if labels := viper.GetStringSlice("signers.speciallabels"); len(labels) > 0 {
+		return labels
+	}
+	return specialLabelDefaults
+}
+
+// SpecialOwner returns "wildcard" if owner is the wildcard directly below the apex of
+// zone, "special label" if it has one of the underscore labels that the signers maintain
+// themselves and "" otherwise.
+func SpecialOwner(zone, owner string) string {
+	zone, owner = strings.ToLower(dns.Fqdn(zone)), strings.ToLower(dns.Fqdn(owner))
+	if owner == zone || !dns.IsSubDomain(zone, owner) {
+		return ""
+	}
+	if owner == "*."+zone || zone == "." && owner == "*." {
+		return "wildcard"
+	}
+	labels := dns.SplitDomainName(owner)
+	for _, label := range labels[:len(labels)-dns.CountLabel(zone)] {
+		for _, sl := range specialLabels() {
+			if label == strings.ToLower(sl) {
+				return "special label"
+			}
+		}
+	}
+	return ""
+}
+
+// CheckOwner returns an error if processes must not modify the rrtype RRset at owner.
+func CheckOwner(zone, owner string, rrtype uint16) error {
+	switch SpecialOwner(zone, owner) {
+	case "wildcard":
+		return fmt.Errorf("Owner name %s is the wildcard of zone %s, which MUSIC does not manage.",
+			owner, zone)
+	case "special label":
+		return fmt.Errorf("Owner name %s has a label that the signers maintain themselves.", owner)
+	}
+	if rrtype == dns.TypeNS || rrtype == dns.TypeDS {
+		for _, label := range dns.SplitDomainName(owner) {
+			if strings.HasPrefix(label, "_") {
+				return fmt.Errorf("Owner name %s has an underscore label and cannot be a delegation.",
+					owner)
+			}
+		}
+	}
+	return nil
+}
+
+func rdataKey(rr dns.RR) string {
+	rc := dns.Copy(rr)
+	rc.Header().Name = "."
+	return rrCompareKey(rc)
+}
+
+// sameRdata returns true if the two RRsets have the same RRs, regardless of owner name.
+func sameRdata(a, b []dns.RR) bool {
+	ka := map[string]bool{}
+	for _, rr := range a {
+		ka[rdataKey(rr)] = true
+	}
+	kb := map[string]bool{}
+	for _, rr := range b {
+		kb[rdataKey(rr)] = true
+	}
+	if len(ka) != len(kb) {
+		return false
+	}
+	for k := range ka {
+		if !kb[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// dropSynthesized returns rrs, or nothing if rrs is what the wildcard of the zone at the
+// signer would answer, i.e. if the owner does not exist there.
+func (z *Zone) dropSynthesized(s *Signer, owner string, rrtype uint16, rrs []dns.RR) ([]dns.RR, error) {
+	if len(rrs) == 0 || SpecialOwner(z.Name, owner) == "wildcard" {
+		return rrs, nil
+	}
+	err, wrrs := GetUpdater(s.Method).FetchRRset(s, z.Name, "*."+z.Name, rrtype)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch the wildcard %s RRset from %s: %v",
+			dns.TypeToString[rrtype], s.Name, err)
+	}
+	if len(wrrs) > 0 && sameRdata(rrs, wrrs) {
+		return nil, nil
+	}
+	return rrs, nil
+}
+
+// SpecialRRsets fetches the RRsets at the wildcard and the special labels below the
+// apex from all signers of the zone. Only RRsets that exist at some signer are returned.
+func (z *Zone) SpecialRRsets() ([]SpecialRRset, error) {
+	var res []SpecialRRset
+	if z.Type().Simulated || z.SGroup == nil {
+		return res, nil
+	}
+
+	owners := []string{"*." + z.Name}
+	for _, sl := range specialLabels() {
+		owners = append(owners, strings.ToLower(sl)+"."+z.Name)
+	}
+	var signers []string
+	for name := range z.SGroup.SignerMap {
+		signers = append(signers, name)
+	}
+	sort.Strings(signers)
+
+	for _, owner := range owners {
+		reason := SpecialOwner(z.Name, owner)
+		for _, rrtype := range specialRRtypes[owner[:1]] {
+			sr := SpecialRRset{Owner: owner, RRtype: dns.TypeToString[rrtype], Reason: reason,
+				RRs: map[string][]string{}, InSync: true}
+			var first []dns.RR
+			found := false
+			for i, name := range signers {
+				s := z.SGroup.SignerMap[name]
+				err, rrs := GetUpdater(s.Method).FetchRRset(s, z.Name, owner, rrtype)
+				if err != nil {
+					return res, fmt.Errorf("Unable to fetch %s %s from %s: %v", owner,
+						sr.RRtype, name, err)
+				}
+				if reason != "wildcard" {
+					if rrs, err = z.dropSynthesized(s, owner, rrtype, rrs); err != nil {
+						return res, err
+					}
+				}
+				for _, rr := range rrs {
+					sr.RRs[name] = append(sr.RRs[name], rr.String())
+				}
+				if len(rrs) > 0 {
+					found = true
+				}
+				if i == 0 {
+					first = rrs
+				} else if !sameRdata(first, rrs) {
+					sr.InSync = false
+				}
+			}
+			if found {
+				res = append(res, sr)
+			}
+		}
+	}
+	return res, nil
+}
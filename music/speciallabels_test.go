@@ -0,0 +1,55 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSpecialOwner(t *testing.T) {
+	cases := []struct {
+		owner string
+		want  string
+	}{
+		{"example.se.", ""},
+		{"www.example.se.", ""},
+		{"*.example.se.", "wildcard"},
+		{"*.www.example.se.", ""},
+		{"_acme-challenge.example.se.", "special label"},
+		{"_dsboot.child.example.se._signal.ns1.example.se.", "special label"},
+		{"_dmarc.example.se.", ""},
+		{"*.example.org.", ""},
+	}
+	for _, c := range cases {
+		if got := SpecialOwner("example.se.", c.owner); got != c.want {
+			t.Errorf("SpecialOwner(%q): got %q wanted %q", c.owner, got, c.want)
+		}
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	if err := CheckOwner("example.se.", "child.example.se.", dns.TypeDS); err != nil {
+		t.Errorf("CheckOwner: child.example.se. DS: unexpected error: %v", err)
+	}
+	if err := CheckOwner("example.se.", "_dmarc.example.se.", dns.TypeTXT); err != nil {
+		t.Errorf("CheckOwner: _dmarc.example.se. TXT: unexpected error: %v", err)
+	}
+	if err := CheckOwner("example.se.", "_tcp.example.se.", dns.TypeNS); err == nil {
+		t.Errorf("CheckOwner: _tcp.example.se. NS: no error")
+	}
+	if err := CheckOwner("example.se.", "*.example.se.", dns.TypeA); err == nil {
+		t.Errorf("CheckOwner: *.example.se. A: no error")
+	}
+}
+
+func TestSameRdata(t *testing.T) {
+	a, _ := dns.NewRR("foo.example.se. 300 IN A 192.0.2.1")
+	w, _ := dns.NewRR("*.example.se. 3600 IN A 192.0.2.1")
+	b, _ := dns.NewRR("foo.example.se. 300 IN A 192.0.2.2")
+	if !sameRdata([]dns.RR{a}, []dns.RR{w}) {
+		t.Errorf("sameRdata: synthesized RRset not recognized")
+	}
+	if sameRdata([]dns.RR{a}, []dns.RR{w, b}) {
+		t.Errorf("sameRdata: different RRsets considered the same")
+	}
+}
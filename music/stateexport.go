@@ -0,0 +1,161 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// State export: a snapshot of all zones and signers, written as a JSON file for
+// monitoring systems that cannot call the API (e.g. in air-gapped environments).
+
+type StateExport struct {
+	Time    time.Time
+	Zones   []ZoneStatus
+	Signers []SignerStatus
+}
+
+type ZoneStatus struct {
+	Name         string
+	SignerGroup  string
+	Process      string
+	State        string
+	Since        time.Time
+	FSMMode      string
+	Blocked      bool
+	StopReason   string
+	InProcessFor int // seconds in current state, 0 if not in a process
+}
+
+type SignerStatus struct {
+	Name         string
+	Method       string
+	Address      string
+	SignerGroups []string
+	Checked      bool // false if no health check was done
+	Healthy      bool
+	HealthError  string
+	TsigRotation string // state of the latest TSIG rotation, if any
+}
+
+// CheckHealth verifies that the signer answers authoritatively for zone.
+func (s *Signer) CheckHealth(zone string) error {
+	if s.Address == "" {
+		return fmt.Errorf("No ip|host for signer %s", s.Name)
+	}
+
+	c := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(zone), dns.TypeSOA)
+	r, _, err := s.Exchange(c, m)
+	if err != nil {
+		return fmt.Errorf("Signer %s did not respond: %v", s.Name, err)
+	}
+	if r.MsgHdr.Rcode != dns.RcodeSuccess || !r.MsgHdr.Authoritative {
+		return fmt.Errorf("Signer %s is not authoritative for %s (RCODE = %s)", s.Name, zone,
+			dns.RcodeToString[r.MsgHdr.Rcode])
+	}
+	return nil
+}
+
+// ExportState collects the current state of all zones and signers. If probe is true
+// every DDNS signer is checked by querying it for a zone it serves.
+func (mdb *MusicDB) ExportState(probe bool) (*StateExport, error) {
+	se := StateExport{Time: time.Now()}
+
+	zones, err := mdb.ListZones()
+	if err != nil {
+		return nil, err
+	}
+	for _, z := range zones {
+		zs := ZoneStatus{
+			Name:        z.Name,
+			SignerGroup: z.SGname,
+			Process:     z.FSM,
+			State:       z.State,
+			Since:       z.Statestamp,
+			FSMMode:     z.FSMMode,
+			Blocked:     z.FSMStatus == "blocked",
+			StopReason:  z.StopReason,
+		}
+		if z.FSM != "" && z.FSM != "---" {
+			zs.InProcessFor = int(time.Since(z.Statestamp).Seconds())
+		}
+		se.Zones = append(se.Zones, zs)
+	}
+	sort.Slice(se.Zones, func(i, j int) bool { return se.Zones[i].Name < se.Zones[j].Name })
+
+	signers, err := mdb.ListSigners(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range signers {
+		ss := SignerStatus{
+			Name:         s.Name,
+			Method:       s.Method,
+			Address:      s.Address,
+			SignerGroups: s.SignerGroups,
+		}
+
+		if tr, err := mdb.GetTsigRotation(nil, s.Name); err == nil && tr != nil {
+			ss.TsigRotation = tr.State
+		}
+
+		if probe && s.Method == "ddns" {
+			tx, err := mdb.Begin()
+			if err != nil {
+				return nil, err
+			}
+			zone, err := mdb.signerVerifyZone(tx, s.Name)
+			tx.Rollback()
+			if err == nil {
+				ss.Checked = true
+				if err = s.CheckHealth(zone); err != nil {
+					ss.HealthError = err.Error()
+				} else {
+					ss.Healthy = true
+				}
+			}
+		}
+		se.Signers = append(se.Signers, ss)
+	}
+	sort.Slice(se.Signers, func(i, j int) bool { return se.Signers[i].Name < se.Signers[j].Name })
+
+	return &se, nil
+}
+
+// WriteStateExport writes the export to file. The file is replaced atomically, so that
+// readers never see a partially written export.
+func WriteStateExport(file string, se *StateExport) error {
+	buf, err := json.MarshalIndent(se, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".musicstate-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
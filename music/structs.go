@@ -4,7 +4,9 @@
 package music
 
 import (
+	"context"
 	"database/sql"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
@@ -44,6 +46,7 @@ type Zone struct {
 	ZskState   string
 	ZoneType   string // "normal", "debug"
 	CSYNC      *dns.CSYNC
+	explain    *TransitionExplanation // set while the zone is explained, see explain.go
 }
 
 // A process object encapsulates the change that
@@ -78,11 +81,25 @@ type Signer struct {
 	UseTcp       bool   // debugging tools, easier to check UDP
 	UseTSIG      bool   // debugging tool, not for production
 	Address      string
+	Addresses    []string     // all addresses, in order of preference (see signeraddresses.go)
+	DownAddrs    []string     // addresses currently down, "signer list" only
 	Port         string
 	AuthStr      string // AuthDataTmp // TODO: Issue #28
 	Auth         AuthData
 	SignerGroup  string   // single signer group for join/leave
 	SignerGroups []string // all signer groups signer is member of
+	Views        []SignerView // split-horizon views, in addition to the default view
+	Proxy        string       // jump host (socks5:// or ssh://), if any
+	Include      FileInclude  // file-include signers only
+	TokenZones   []string     // zones with their own API token (see zonecredentials.go)
+	Anycast      bool         // verify fetches from all vantage points (see anycast.go)
+	TLS          SignerTLS    // DDNS over TLS (see signertls.go)
+	DoHURL       string       // fetches over DNS over HTTPS (see signertransport.go)
+	SIG0         SignerSIG0   // SIG(0) rather than TSIG (see signersig0.go)
+	Notify       []string     // NOTIFY targets after updates (see signernotify.go)
+	MaxZones     int          // max concurrent zones, 0 = default (see signerlimits.go)
+	jumphost     jumpHost     // not set for apisafe signers (see jumphost.go)
+	zoneTokens   map[string]string
 	DB           *MusicDB
 }
 
@@ -102,9 +119,11 @@ type MusicDB struct {
 	FSMlist         map[string]FSM
 	Tokvip          *viper.Viper
 	StopReasonCache map[string]string // key: zonename value: stopreason
+	stopmu          sync.Mutex        // protects StopReasonCache, zones are pushed in parallel
 }
 
 type SignerOp struct {
+	ID       string // assigned when the op is queued, see optrace.go
 	Command  string
 	Signer   *Signer
 	Zone     string
@@ -112,7 +131,11 @@ type SignerOp struct {
 	RRtype   uint16
 	Inserts  *[][]dns.RR
 	Removes  *[][]dns.RR
+	Deadline time.Time // zero = none; see TrackSignerOp
+	Priority int       // OpPriorityBackground or OpPriorityInteractive, see oppriority.go
+	Throttle string    // throttling class of the zone, see throttle.go
 	Response chan SignerOpResult
+	ctx      context.Context // cancelled when the caller stops waiting, see RunSignerOp
 }
 
 type SignerOpResult struct {
@@ -121,4 +144,5 @@ type SignerOpResult struct {
 	RRs      []dns.RR
 	Error    error
 	Response string
+	OpID     string
 }
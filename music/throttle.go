@@ -0,0 +1,133 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Zone throttling classes. Signers are shared by many zones, and a large background
+// migration can use up the whole budget of the rate limiter of a signer, so that the
+// changes a customer makes have to wait behind it. A zone may be assigned a named
+// throttling class (kept as zone metadata); the zones without one get the class
+// signers.throttling.default (none if unset). Each class is given a share of the rate
+// of every signer and class of operation (see ratelimiter.go):
+//
+//	signers.throttling.classes.<name>: 0.25
+//
+// The rate-limiting managers do not start an op of a zone whose class has used up its
+// share, so with e.g. bulk: 0.25 the ops of the other zones always have three quarters
+// of the rate of the signer. A share of 1 (or a zone without class) is only limited by
+// the rate of the signer.
+
+const ZoneThrottleKey = "throttle-class"
+
+// ThrottleClasses returns the configured throttling classes and their shares.
+func ThrottleClasses() map[string]float64 {
+	classes := map[string]float64{}
+	for name := range viper.GetStringMap("signers.throttling.classes") {
+		classes[name] = viper.GetFloat64("signers.throttling.classes." + name)
+	}
+	return classes
+}
+
+// throttleShare returns the share of the rate of a signer the class may use (1 = all).
+func throttleShare(class string) float64 {
+	if class == "" {
+		return 1
+	}
+	share, exist := ThrottleClasses()[strings.ToLower(class)]
+	if !exist || share <= 0 || share > 1 {
+		return 1
+	}
+	return share
+}
+
+// CheckThrottleClasses returns an error if a class has a share outside (0, 1] or the
+// default class is not defined.
+func CheckThrottleClasses() error {
+	classes := ThrottleClasses()
+	for name, share := range classes {
+		if share <= 0 || share > 1 {
+			return fmt.Errorf("signers.throttling.classes.%s: share %v not in (0, 1]", name, share)
+		}
+	}
+	if def := viper.GetString("signers.throttling.default"); def != "" {
+		if _, exist := classes[strings.ToLower(def)]; !exist {
+			return fmt.Errorf("signers.throttling.default: class %q not defined", def)
+		}
+	}
+	return nil
+}
+
+func (mdb *MusicDB) ZoneSetThrottleClass(tx *sql.Tx, z *Zone, class string) (string, error) {
+	if class == "" {
+		return mdb.ZoneClearThrottleClass(tx, z)
+	}
+	class = strings.ToLower(class)
+	classes := ThrottleClasses()
+	if _, exist := classes[class]; !exist {
+		var names []string
+		for name := range classes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("Unknown throttling class %q. Defined classes: %s", class,
+			strings.Join(names, ", "))
+	}
+	if _, err := mdb.ZoneSetMeta(tx, z, ZoneThrottleKey, class); err != nil {
+		return "", err
+	}
+	log.Printf("Zone %s: throttling class set to %s", z.Name, class)
+	return fmt.Sprintf("Zone %s now has throttling class %s (%.0f%% of the signer rates).",
+		z.Name, class, 100*throttleShare(class)), nil
+}
+
+func (mdb *MusicDB) ZoneClearThrottleClass(tx *sql.Tx, z *Zone) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+	const sqlq = "DELETE FROM metadata WHERE zone=? AND key=?"
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ZoneClearThrottleClass: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	_, err = tx.Exec(sqlq, z.Name, ZoneThrottleKey)
+	if CheckSQLError("ZoneClearThrottleClass", sqlq, err, false) {
+		return "", err
+	}
+	log.Printf("Zone %s: throttling class cleared", z.Name)
+	if def := viper.GetString("signers.throttling.default"); def != "" {
+		return fmt.Sprintf("Zone %s now has the default throttling class %s.", z.Name, def), nil
+	}
+	return fmt.Sprintf("Zone %s no longer has a throttling class.", z.Name), nil
+}
+
+// ZoneThrottleClass returns the throttling class of the zone ("" = none).
+func (mdb *MusicDB) ZoneThrottleClass(tx *sql.Tx, zone string) string {
+	def := strings.ToLower(viper.GetString("signers.throttling.default"))
+	if mdb == nil || zone == "" {
+		return def
+	}
+	class, exist, err := mdb.GetMeta(tx, &Zone{Name: zone, Exists: true}, ZoneThrottleKey)
+	if err != nil {
+		log.Printf("ZoneThrottleClass: zone %s: %v", zone, err)
+		return def
+	}
+	if !exist || class == "" {
+		return def
+	}
+	return class
+}
@@ -0,0 +1,503 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TSIG key rotation for DDNS signers. The rotation is a small linear process, modelled
+// on the zone FSMs: provision the new key, verify that updates succeed with it, switch
+// the stored credential, verify again and finally (optionally) wait for the signer
+// operator to retire the old key. A failed verification rolls the signer back to the
+// old key. The state is kept in the DB and the rotation is driven in the background by
+// RunTsigRotations(), so that no transaction is held open while talking to the signer.
+
+const (
+	TsigRotationProvisioned   = "provisioned"
+	TsigRotationVerified      = "verified"
+	TsigRotationSwitched      = "switched"
+	TsigRotationRetirePending = "retire-pending"
+	TsigRotationComplete      = "complete"
+	TsigRotationRolledBack    = "rolled-back"
+)
+
+// ZoneEventTsigRetire is sent to the contacts of the zones served by a signer when the
+// old TSIG key of the signer may be retired (see notifications.go).
+const ZoneEventTsigRetire = "tsig-retire-pending"
+
+type TsigRotation struct {
+	Signer     string
+	State      string
+	OldAuth    string
+	NewAuth    string
+	Started    time.Time
+	Statestamp time.Time
+	Reason     string
+	Notify     bool // stop in retire-pending until the old key is retired
+}
+
+// TSIGString returns the auth data in the "alg:name:secret" form used in the signers table.
+func (a AuthData) TSIGString() string {
+	return fmt.Sprintf("%s:%s:%s", a.TSIGAlg, a.TSIGName, a.TSIGKey)
+}
+
+// VerifyTSIG sends a DDNS UPDATE to the signer that contains only the prerequisite
+// "zone apex is in use" and no updates. The signer must validate the TSIG signature
+// before it looks at the prerequisite, so a NOERROR response proves that the key works
+// for updates without changing any data.
+func (s *Signer) VerifyTSIG(zone string) error {
+	if s.Address == "" {
+		return fmt.Errorf("No ip|host for signer %s", s.Name)
+	}
+	if s.Auth.TSIGKey == "" {
+		return fmt.Errorf("No TSIG for signer %s", s.Name)
+	}
+
+	c := s.NewDnsClient()
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.Used([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeANY,
+		Class: dns.ClassANY}}})
+	m.SetTsig(s.Auth.TSIGName, s.Auth.TSIGAlg, 300, time.Now().Unix())
+	c.TsigSecret = map[string]string{s.Auth.TSIGName: s.Auth.TSIGKey}
+
+	r, _, err := s.Exchange(c, m)
+	if err != nil {
+		return fmt.Errorf("TSIG verification of key %s with signer %s failed: %v",
+			s.Auth.TSIGName, s.Name, err)
+	}
+	if r.MsgHdr.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("TSIG verification of key %s with signer %s failed, RCODE = %s",
+			s.Auth.TSIGName, s.Name, dns.RcodeToString[r.MsgHdr.Rcode])
+	}
+	return nil
+}
+
+// signerVerifyZone returns a zone served by the signer that can be used to verify TSIG keys.
+func (mdb *MusicDB) signerVerifyZone(tx *sql.Tx, signer string) (string, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("signerVerifyZone: Error from mdb.StartTransaction(): %v\n", err)
+		return "", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT z.name FROM zones z, group_signers gs WHERE z.sgroup=gs.name AND gs.signer=? LIMIT 1`
+
+	var zone string
+	err = tx.QueryRow(sqlq, signer).Scan(&zone)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("Signer %s serves no zones. Unable to verify TSIG key.", signer)
+	}
+	if CheckSQLError("signerVerifyZone", sqlq, err, false) {
+		return "", err
+	}
+	return zone, nil
+}
+
+// signerZones returns the names of all zones served by the signer.
+func (mdb *MusicDB) signerZones(tx *sql.Tx, signer string) ([]string, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("signerZones: Error from mdb.StartTransaction(): %v\n", err)
+		return nil, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT z.name FROM zones z, group_signers gs WHERE z.sgroup=gs.name AND gs.signer=?`
+
+	rows, err := tx.Query(sqlq, signer)
+	if CheckSQLError("signerZones", sqlq, err, false) {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var zones []string
+	for rows.Next() {
+		var zone string
+		if err := rows.Scan(&zone); err != nil {
+			return nil, err
+		}
+		zones = append(zones, zone)
+	}
+	return zones, rows.Err()
+}
+
+func (mdb *MusicDB) GetTsigRotation(tx *sql.Tx, signer string) (*TsigRotation, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("GetTsigRotation: Error from mdb.StartTransaction(): %v\n", err)
+		return nil, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT state, oldauth, newauth, notify, COALESCE(started, datetime('now')),
+       COALESCE(statestamp, datetime('now')), reason FROM tsig_rotations WHERE signer=?`
+
+	tr := TsigRotation{Signer: signer}
+	var started, statestamp string
+	err = tx.QueryRow(sqlq, signer).Scan(&tr.State, &tr.OldAuth, &tr.NewAuth, &tr.Notify,
+		&started, &statestamp, &tr.Reason)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if CheckSQLError("GetTsigRotation", sqlq, err, false) {
+		return nil, err
+	}
+	tr.Started, _ = time.Parse(layout, started)
+	tr.Statestamp, _ = time.Parse(layout, statestamp)
+	return &tr, nil
+}
+
+// tsigRotationActive is true for the states that the TSIG rotator moves on from by itself.
+func tsigRotationActive(state string) bool {
+	switch state {
+	case TsigRotationProvisioned, TsigRotationVerified, TsigRotationSwitched:
+		return true
+	}
+	return false
+}
+
+// setTsigRotationState moves the rotation from state from to state to. Several musicd
+// instances may drive the same rotation, so the move is only made if the rotation is
+// still in state from; otherwise an error is returned and the caller must change nothing.
+func (mdb *MusicDB) setTsigRotationState(tx *sql.Tx, signer, from, to, reason string) error {
+	const sqlq = `
+UPDATE tsig_rotations SET state=?, reason=?, statestamp=datetime('now') WHERE signer=? AND state=?`
+
+	res, err := tx.Exec(sqlq, to, reason, signer, from)
+	if CheckSQLError("setTsigRotationState", sqlq, err, false) {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("TSIG rotation for signer %s is no longer in state '%s'", signer, from)
+	}
+	log.Printf("TSIG rotation for signer %s: new state '%s' %s", signer, to, reason)
+	return nil
+}
+
+// tsigRotationStep makes a state change in a transaction of its own.
+func (mdb *MusicDB) tsigRotationStep(tr *TsigRotation, to, reason string) error {
+	localtx, tx, err := mdb.StartTransaction(nil)
+	if err != nil {
+		log.Printf("tsigRotationStep: Error from mdb.StartTransaction(): %v\n", err)
+		return err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	err = mdb.setTsigRotationState(tx, tr.Signer, tr.State, to, reason)
+	if err != nil {
+		return err
+	}
+	tr.State = to
+	return nil
+}
+
+// tsigRotationSwitch moves the rotation from state from to state to and stores auth as
+// the credential of the signer, in the same transaction.
+func (mdb *MusicDB) tsigRotationSwitch(tr *TsigRotation, to, auth, reason string) error {
+	localtx, tx, err := mdb.StartTransaction(nil)
+	if err != nil {
+		log.Printf("tsigRotationSwitch: Error from mdb.StartTransaction(): %v\n", err)
+		return err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	err = mdb.setTsigRotationState(tx, tr.Signer, tr.State, to, reason)
+	if err != nil {
+		return err
+	}
+	const sqlq = "UPDATE signers SET auth=? WHERE name=?"
+	_, err = tx.Exec(sqlq, auth, tr.Signer)
+	if CheckSQLError("tsigRotationSwitch", sqlq, err, false) {
+		return err
+	}
+	tr.State = to
+	return nil
+}
+
+// SignerRotateTSIG starts a TSIG rotation for the signer. Only the request is checked
+// and recorded here; the rotation itself is driven by RunTsigRotations(), as verifying a
+// key means a round trip to the signer. If notify is true the rotation stops in the
+// "retire-pending" state until SignerRetireTSIG() is called, and the contacts of the zones
+// served by the signer are told that the old key may be retired. Otherwise the rotation
+// is complete once the new key has been verified after the switch.
+func (mdb *MusicDB) SignerRotateTSIG(tx *sql.Tx, dbsigner *Signer, newauth AuthData,
+	notify bool) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+
+	if dbsigner.Method != "ddns" && dbsigner.Method != "rlddns" {
+		return "", fmt.Errorf("Signer %s uses method %s. TSIG rotation only applies to DDNS signers.",
+			dbsigner.Name, dbsigner.Method)
+	}
+
+	if newauth.TSIGKey == "" || newauth.TSIGName == "" {
+		return "", fmt.Errorf("New TSIG key for signer %s not specified.", dbsigner.Name)
+	}
+	alg, err := CanonicalTSIGAlg(newauth.TSIGAlg)
+	if err != nil {
+		return "", err
+	}
+	newauth.TSIGAlg = alg
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerRotateTSIG: Error from mdb.StartTransaction(): %v\n", err)
+		return "", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	tr, err := mdb.GetTsigRotation(tx, dbsigner.Name)
+	if err != nil {
+		return "", err
+	}
+	if tr != nil && tr.State != TsigRotationComplete && tr.State != TsigRotationRolledBack {
+		return "", fmt.Errorf("Signer %s is already in a TSIG rotation (state: %s).",
+			dbsigner.Name, tr.State)
+	}
+
+	// Step 1: provision
+	const sqlq = `
+INSERT OR REPLACE INTO tsig_rotations(signer, state, oldauth, newauth, notify, started, statestamp, reason)
+VALUES (?, ?, ?, ?, ?, datetime('now'), datetime('now'), '')`
+
+	_, err = tx.Exec(sqlq, dbsigner.Name, TsigRotationProvisioned, dbsigner.AuthStr,
+		newauth.TSIGString(), notify)
+	if CheckSQLError("SignerRotateTSIG", sqlq, err, false) {
+		return "", err
+	}
+	log.Printf("TSIG rotation for signer %s: new state '%s'", dbsigner.Name, TsigRotationProvisioned)
+	wakeTsigRotator()
+
+	return fmt.Sprintf("TSIG rotation for signer %s from key %s to %s started. "+
+		"Follow it with: music-cli signer tsig-status -s %s", dbsigner.Name,
+		dbsigner.Auth.TSIGName, newauth.TSIGName, dbsigner.Name), nil
+}
+
+// SignerTsigStatus reports the state of the latest TSIG rotation for the signer.
+func (mdb *MusicDB) SignerTsigStatus(tx *sql.Tx, dbsigner *Signer) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+
+	tr, err := mdb.GetTsigRotation(tx, dbsigner.Name)
+	if err != nil {
+		return "", err
+	}
+	if tr == nil {
+		return fmt.Sprintf("Signer %s: no TSIG rotation.", dbsigner.Name), nil
+	}
+	msg := fmt.Sprintf("Signer %s: TSIG rotation to key %s started %s, state '%s' since %s",
+		dbsigner.Name, ParseAuthStr(tr.NewAuth).TSIGName, tr.Started.Format(layout),
+		tr.State, tr.Statestamp.Format(layout))
+	if tr.Reason != "" {
+		msg += fmt.Sprintf(" (%s)", tr.Reason)
+	}
+	return msg + ".", nil
+}
+
+// SignerRetireTSIG completes a rotation that is waiting for the old key to be retired.
+func (mdb *MusicDB) SignerRetireTSIG(tx *sql.Tx, dbsigner *Signer) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerRetireTSIG: Error from mdb.StartTransaction(): %v\n", err)
+		return "", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	tr, err := mdb.GetTsigRotation(tx, dbsigner.Name)
+	if err != nil {
+		return "", err
+	}
+	if tr == nil || tr.State != TsigRotationRetirePending {
+		return "", fmt.Errorf("Signer %s has no TSIG rotation waiting for the old key to be retired.",
+			dbsigner.Name)
+	}
+
+	err = mdb.setTsigRotationState(tx, dbsigner.Name, TsigRotationRetirePending,
+		TsigRotationComplete, "old key retired")
+	if err != nil {
+		return "", err
+	}
+	const sqlq = "UPDATE tsig_rotations SET oldauth='' WHERE signer=?"
+	_, err = tx.Exec(sqlq, dbsigner.Name)
+	if CheckSQLError("SignerRetireTSIG", sqlq, err, false) {
+		return "", err
+	}
+	return fmt.Sprintf("Signer %s: TSIG rotation complete, old key retired.", dbsigner.Name), nil
+}
+
+var tsigRotatorWake = make(chan struct{}, 1)
+
+func wakeTsigRotator() {
+	select {
+	case tsigRotatorWake <- struct{}{}:
+	default:
+	}
+}
+
+// activeTsigRotations returns the rotations that the TSIG rotator has to move on.
+func (mdb *MusicDB) activeTsigRotations() ([]*TsigRotation, error) {
+	localtx, tx, err := mdb.StartTransaction(nil)
+	if err != nil {
+		log.Printf("activeTsigRotations: Error from mdb.StartTransaction(): %v\n", err)
+		return nil, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT signer FROM tsig_rotations WHERE state IN (?, ?, ?)"
+	rows, err := tx.Query(sqlq, TsigRotationProvisioned, TsigRotationVerified, TsigRotationSwitched)
+	if CheckSQLError("activeTsigRotations", sqlq, err, false) {
+		return nil, err
+	}
+	var signers []string
+	for rows.Next() {
+		var signer string
+		if err := rows.Scan(&signer); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	rows.Close()
+
+	var trs []*TsigRotation
+	for _, signer := range signers {
+		tr, err := mdb.GetTsigRotation(tx, signer)
+		if err != nil {
+			return nil, err
+		}
+		if tr != nil {
+			trs = append(trs, tr)
+		}
+	}
+	return trs, nil
+}
+
+// RunTsigRotations drives the TSIG rotations, one state change at a time, until stopch
+// is closed. Rotations that were in progress when musicd stopped are picked up again at
+// start. Each state change is made in a short transaction of its own and the key is
+// verified against the signer with no transaction open:
+//
+//	provisioned: verify the new key                   -> verified | rolled-back
+//	verified:    store the new key as the credential  -> switched
+//	switched:    verify the stored key                -> retire-pending | complete
+//	             (failure: restore the old key)       -> rolled-back
+func (mdb *MusicDB) RunTsigRotations(stopch chan struct{}) {
+	log.Printf("Starting TSIG rotator")
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		trs, err := mdb.activeTsigRotations()
+		if err != nil {
+			log.Printf("TSIG rotator: Error from activeTsigRotations: %v", err)
+		}
+		for _, tr := range trs {
+			for tsigRotationActive(tr.State) {
+				if err := mdb.advanceTsigRotation(tr); err != nil {
+					// retried at the next tick
+					log.Printf("TSIG rotator: signer %s: %v", tr.Signer, err)
+					break
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-tsigRotatorWake:
+		case <-stopch:
+			log.Println("TSIG rotator: stop signal received.")
+			return
+		}
+	}
+}
+
+// advanceTsigRotation makes the next state change of the rotation.
+func (mdb *MusicDB) advanceTsigRotation(tr *TsigRotation) error {
+	s, err := mdb.GetSignerByName(nil, tr.Signer, false) // not apisafe
+	if err != nil {
+		return err
+	}
+	if !s.Exists {
+		return mdb.tsigRotationStep(tr, TsigRotationRolledBack, "signer deleted")
+	}
+
+	switch tr.State {
+	case TsigRotationProvisioned:
+		zone, err := mdb.signerVerifyZone(nil, tr.Signer)
+		if err != nil {
+			return mdb.tsigRotationStep(tr, TsigRotationRolledBack, err.Error())
+		}
+		news := *s
+		news.Auth = ParseAuthStr(tr.NewAuth)
+		if verr := news.VerifyTSIG(zone); verr != nil {
+			return mdb.tsigRotationStep(tr, TsigRotationRolledBack, verr.Error())
+		}
+		return mdb.tsigRotationStep(tr, TsigRotationVerified, "")
+
+	case TsigRotationVerified:
+		return mdb.tsigRotationSwitch(tr, TsigRotationSwitched, tr.NewAuth, "")
+
+	case TsigRotationSwitched:
+		// verify once more, this time with the credential as stored in the DB
+		zone, err := mdb.signerVerifyZone(nil, tr.Signer)
+		if err == nil {
+			err = s.VerifyTSIG(zone)
+		}
+		if err != nil {
+			return mdb.tsigRotationSwitch(tr, TsigRotationRolledBack, tr.OldAuth,
+				fmt.Sprintf("back to key %s: %v", ParseAuthStr(tr.OldAuth).TSIGName, err))
+		}
+		if !tr.Notify {
+			return mdb.tsigRotationStep(tr, TsigRotationComplete, "")
+		}
+		if err := mdb.tsigRotationStep(tr, TsigRotationRetirePending, ""); err != nil {
+			return err
+		}
+		mdb.notifyTsigRetire(tr)
+	}
+	return nil
+}
+
+// notifyTsigRetire tells the contacts of the zones served by the signer that the old
+// TSIG key may be retired.
+func (mdb *MusicDB) notifyTsigRetire(tr *TsigRotation) {
+	oldname := ParseAuthStr(tr.OldAuth).TSIGName
+	msg := fmt.Sprintf("Signer %s now uses TSIG key %s. The operator of signer %s may retire the old key %s.\n"+
+		"Complete the rotation with: music-cli signer retire-tsig -s %s", tr.Signer,
+		ParseAuthStr(tr.NewAuth).TSIGName, tr.Signer, oldname, tr.Signer)
+	log.Printf("TSIG rotation: %s", msg)
+
+	zones, err := mdb.signerZones(nil, tr.Signer)
+	if err != nil {
+		log.Printf("TSIG rotation: Error from signerZones(%s): %v", tr.Signer, err)
+		return
+	}
+	for _, zone := range zones {
+		z, exists, err := mdb.GetZone(nil, zone)
+		if err != nil {
+			log.Printf("TSIG rotation: Error from GetZone(%s): %v", zone, err)
+			continue
+		}
+		if exists {
+			mdb.NotifyZoneContact(z, ZoneEventTsigRetire, msg)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Removal protection and the two-person rule. With twoperson.active set, destructive
+// operations must be requested by two different API identities: the first request is
+// recorded as a pending operation and refused, and the operation is only carried out
+// when a second identity repeats it within twoperson.validity hours. The rule covers
+//
+//   - deleting a critical zone, i.e. a zone with the metadata "critical" set to "true"
+//   - starting the remove-signer process for a critical zone, also via "signer leave"
+//   - removing the "critical" tag from a zone
+//   - deleting a signer group (which detaches all its zones)
+//
+// The identity is the OIDC user of the request or, for API key clients, the identity
+// given by the client. As anyone with the API key can claim any identity,
+// twoperson.oidconly restricts the rule to OIDC users.
+
+const CriticalKey = "critical"
+
+const (
+	OpDeleteZone        = "delete-zone"
+	OpDeleteSignerGroup = "delete-signergroup"
+	OpRemoveSigner      = "remove-signer"
+	OpUnprotectZone     = "unprotect-zone"
+)
+
+type PendingOp struct {
+	ID        int
+	Op        string
+	Target    string
+	Requester string
+	Requested time.Time
+	Expires   time.Time
+}
+
+func twoPersonActive() bool {
+	return viper.GetBool("twoperson.active")
+}
+
+func twoPersonValidity() time.Duration {
+	hours := viper.GetInt("twoperson.validity")
+	if hours <= 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// TwoPersonIdentity returns the identity of a request: the OIDC user if there is one,
+// otherwise the identity claimed by the client (unless twoperson.oidconly is set).
+func TwoPersonIdentity(oidcuser, claimed string) string {
+	if oidcuser != "" || viper.GetBool("twoperson.oidconly") {
+		return oidcuser
+	}
+	return claimed
+}
+
+// ZoneCritical returns true if the zone is tagged critical.
+func (mdb *MusicDB) ZoneCritical(tx *sql.Tx, z *Zone) bool {
+	value, exist, err := mdb.GetMeta(tx, z, CriticalKey)
+	return err == nil && exist && value == "true"
+}
+
+// TwoPersonCheck returns nil if op on target may be carried out now by identity. If
+// not, the returned error says what is needed and the request is recorded.
+func (mdb *MusicDB) TwoPersonCheck(tx *sql.Tx, op, target, identity string) error {
+	if !twoPersonActive() {
+		return nil
+	}
+	if identity == "" {
+		return fmt.Errorf("%s %s is subject to the two-person rule, which requires an identity "+
+			"(log in via OIDC or give an identity).", op, target)
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("TwoPersonCheck: Error from mdb.StartTransaction(): %v\n", err)
+		return err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT id, requester, COALESCE(requested, '') FROM pending_ops WHERE op=? AND target=?"
+	var id int
+	var requester, requested string
+	err = tx.QueryRow(sqlq, op, target).Scan(&id, &requester, &requested)
+	switch {
+	case err == sql.ErrNoRows:
+	case CheckSQLError("TwoPersonCheck", sqlq, err, false):
+		return err
+	default:
+		t, _ := time.Parse(layout, requested)
+		switch {
+		case time.Since(t) > twoPersonValidity():
+			// expired, a new request is made below
+		case requester == identity:
+			return fmt.Errorf("%s %s was already requested by %s (request %d) and must be confirmed "+
+				"by another identity.", op, target, identity, id)
+		default:
+			const dsql = "DELETE FROM pending_ops WHERE id=?"
+			_, err = tx.Exec(dsql, id)
+			if CheckSQLError("TwoPersonCheck", dsql, err, false) {
+				return err
+			}
+			log.Printf("Two-person rule: %s %s requested by %s and confirmed by %s", op, target,
+				requester, identity)
+			return nil
+		}
+	}
+
+	const isql = "INSERT OR REPLACE INTO pending_ops(op, target, requester, requested) VALUES (?, ?, ?, ?)"
+	res, err := tx.Exec(isql, op, target, identity, time.Now().UTC().Format(layout))
+	if CheckSQLError("TwoPersonCheck", isql, err, false) {
+		return err
+	}
+	newid, _ := res.LastInsertId()
+	log.Printf("Two-person rule: %s %s requested by %s (request %d)", op, target, identity, newid)
+	return fmt.Errorf("%s %s requires a second person: request %d recorded for %s. Another identity "+
+		"must repeat the operation within %v.", op, target, newid, identity, twoPersonValidity())
+}
+
+// CheckZoneDeletion applies the two-person rule to the deletion of the zone.
+func (mdb *MusicDB) CheckZoneDeletion(z *Zone, identity string) error {
+	if !twoPersonActive() || !z.Exists || !mdb.ZoneCritical(nil, z) {
+		return nil
+	}
+	return mdb.TwoPersonCheck(nil, OpDeleteZone, z.Name, identity)
+}
+
+// CheckZoneProcess applies the two-person rule to starting the process for the zone.
+func (mdb *MusicDB) CheckZoneProcess(z *Zone, process, identity string) error {
+	if !twoPersonActive() || process != SignerLeaveGroupProcess || !z.Exists ||
+		!mdb.ZoneCritical(nil, z) {
+		return nil
+	}
+	return mdb.TwoPersonCheck(nil, OpRemoveSigner, z.Name, identity)
+}
+
+// CheckSignerRemoval applies the two-person rule to the removal of the signer from the
+// group, if any zone in the group is critical.
+func (mdb *MusicDB) CheckSignerRemoval(group, signer, identity string) error {
+	if !twoPersonActive() {
+		return nil
+	}
+	sg, err := mdb.GetSignerGroup(nil, group, false)
+	if err != nil {
+		return nil // reported by SignerLeaveGroup
+	}
+	zones, err := mdb.GetSignerGroupZones(nil, sg)
+	if err != nil {
+		return err
+	}
+	for _, z := range zones {
+		if mdb.ZoneCritical(nil, z) {
+			return mdb.TwoPersonCheck(nil, OpRemoveSigner, group+"/"+signer, identity)
+		}
+	}
+	return nil
+}
+
+// CheckSignerGroupDeletion applies the two-person rule to the deletion of the group.
+func (mdb *MusicDB) CheckSignerGroupDeletion(group, identity string) error {
+	if !twoPersonActive() {
+		return nil
+	}
+	return mdb.TwoPersonCheck(nil, OpDeleteSignerGroup, group, identity)
+}
+
+// CheckZoneMeta applies the two-person rule to removing the critical tag of the zone.
+func (mdb *MusicDB) CheckZoneMeta(z *Zone, key, value, identity string) error {
+	if !twoPersonActive() || key != CriticalKey || value == "true" || !mdb.ZoneCritical(nil, z) {
+		return nil
+	}
+	return mdb.TwoPersonCheck(nil, OpUnprotectZone, z.Name, identity)
+}
+
+// ListPendingOps returns the operations waiting for a second person.
+func (mdb *MusicDB) ListPendingOps(tx *sql.Tx) ([]PendingOp, error) {
+	var ops []PendingOp
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListPendingOps: Error from mdb.StartTransaction(): %v\n", err)
+		return ops, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT id, op, target, requester, COALESCE(requested, '') FROM pending_ops ORDER BY id"
+	rows, err := tx.Query(sqlq)
+	if CheckSQLError("ListPendingOps", sqlq, err, false) {
+		return ops, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var po PendingOp
+		var requested string
+		if err = rows.Scan(&po.ID, &po.Op, &po.Target, &po.Requester, &requested); err != nil {
+			return ops, err
+		}
+		po.Requested, _ = time.Parse(layout, requested)
+		po.Expires = po.Requested.Add(twoPersonValidity())
+		if time.Now().After(po.Expires) {
+			continue
+		}
+		ops = append(ops, po)
+	}
+	return ops, nil
+}
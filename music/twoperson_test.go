@@ -0,0 +1,35 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestTwoPersonIdentity(t *testing.T) {
+	defer viper.Set("twoperson.oidconly", false)
+
+	viper.Set("twoperson.oidconly", false)
+	if id := TwoPersonIdentity("alice@example.com", "bob"); id != "alice@example.com" {
+		t.Errorf("OIDC user not preferred: %s", id)
+	}
+	if id := TwoPersonIdentity("", "bob"); id != "bob" {
+		t.Errorf("claimed identity not used: %s", id)
+	}
+
+	viper.Set("twoperson.oidconly", true)
+	if id := TwoPersonIdentity("", "bob"); id != "" {
+		t.Errorf("claimed identity used with oidconly: %s", id)
+	}
+}
+
+func TestTwoPersonInactive(t *testing.T) {
+	viper.Set("twoperson.active", false)
+	mdb := &MusicDB{}
+	if err := mdb.TwoPersonCheck(nil, OpDeleteZone, "example.com.", ""); err != nil {
+		t.Errorf("rule enforced while inactive: %v", err)
+	}
+	if err := mdb.CheckSignerGroupDeletion("group", ""); err != nil {
+		t.Errorf("rule enforced while inactive: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/miekg/dns"
+)
+
+// Update batches. An action that changes several RRsets at a signer queues the changes
+// in an UpdateBatch and flushes them together: the RRsets are fetched and diffed as in
+// ApplyRRsetChanges, and everything that differs is sent as a single update (one DDNS
+// message, one deSEC API call) rather than one per owner name. This matters for the
+// rate-limited backends, where every write counts against the quota. Backends that can
+// only change one owner name per update (Capabilities().Batch is false) get one update
+// per owner, and updates larger than Capabilities().MaxRRsPerUpdate are split.
+
+type UpdateBatch struct {
+	Signer  *Signer
+	Zone    string
+	changes []RRsetChange
+}
+
+func NewUpdateBatch(signer *Signer, zone string) *UpdateBatch {
+	return &UpdateBatch{Signer: signer, Zone: zone}
+}
+
+// Add queues changes. Nothing is sent until Flush.
+func (b *UpdateBatch) Add(changes ...RRsetChange) {
+	b.changes = append(b.changes, changes...)
+}
+
+// Len returns the number of queued changes.
+func (b *UpdateBatch) Len() int {
+	return len(b.changes)
+}
+
+// Flush sends the queued changes and empties the batch. The number of RRs inserted and
+// removed is returned.
+func (b *UpdateBatch) Flush() (int, int, error) {
+	signer, zone := b.Signer, b.Zone
+	changes := b.changes
+	b.changes = nil
+	updater := GetUpdater(signer.Method)
+	caps := updater.Capabilities()
+
+	var owners []string
+	ins := map[string][][]dns.RR{}
+	rems := map[string][][]dns.RR{}
+	for _, c := range changes {
+		err, observed := updater.FetchRRset(signer, zone, c.Owner, c.RRtype)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Unable to fetch %s %s from %s: %v", c.Owner,
+				dns.TypeToString[c.RRtype], signer.Name, err)
+		}
+		inserts, removes := DiffRRset(c, observed)
+		if len(inserts) == 0 && len(removes) == 0 {
+			continue
+		}
+		if err := signer.CheckUpdateSupport(c.RRtype); err != nil {
+			return 0, 0, err
+		}
+		owner := c.Owner
+		if caps.Batch {
+			owner = zone // everything in one update
+		}
+		if _, ok := ins[owner]; !ok {
+			if _, ok := rems[owner]; !ok {
+				owners = append(owners, owner)
+			}
+		}
+		if len(inserts) > 0 {
+			ins[owner] = append(ins[owner], inserts)
+		}
+		if len(removes) > 0 {
+			rems[owner] = append(rems[owner], removes)
+		}
+	}
+
+	added, removed := 0, 0
+	for _, owner := range owners {
+		for _, u := range splitUpdate(ins[owner], rems[owner], caps.MaxRRsPerUpdate) {
+			i, r := u.Inserts, u.Removes
+			if err := updater.Update(signer, zone, owner, &i, &r); err != nil {
+				return added, removed, err
+			}
+			added += countRRs(i)
+			removed += countRRs(r)
+		}
+	}
+	if added+removed == 0 {
+		log.Printf("%s: signer %s already in the desired state, no update needed", zone, signer.Name)
+	}
+	return added, removed, nil
+}
@@ -0,0 +1,44 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPropagationChecksOwners(t *testing.T) {
+	ns, _ := dns.NewRR("example.com. 3600 IN NS ns1.example.net.")
+	ds, _ := dns.NewRR("child.example.com. 3600 IN DS 12345 13 2 " +
+		"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	oldds, _ := dns.NewRR("child.example.com. 3600 IN DS 54321 13 2 " +
+		"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+
+	checks := propagationChecks("example.com.", [][]dns.RR{{ns}, {ds}}, [][]dns.RR{{oldds}}, false)
+	if len(checks) != 2 {
+		t.Fatalf("got %d checks, want 2 (one per owner and type)", len(checks))
+	}
+	for _, c := range checks {
+		switch c.owner {
+		case "example.com.":
+			if c.rrtype != dns.TypeNS || len(c.present) != 1 {
+				t.Errorf("apex check: %+v", c)
+			}
+		case "child.example.com.":
+			if c.rrtype != dns.TypeDS || len(c.present) != 1 || len(c.absent) != 1 {
+				t.Errorf("child check: %+v", c)
+			}
+		default:
+			t.Errorf("unexpected owner %s", c.owner)
+		}
+	}
+}
+
+func TestUpdateBatchQueue(t *testing.T) {
+	b := NewUpdateBatch(&Signer{Name: "s1"}, "example.com.")
+	b.Add(RRsetChange{Owner: "example.com.", RRtype: dns.TypeNS})
+	b.Add(RRsetChange{Owner: "example.com.", RRtype: dns.TypeCDS},
+		RRsetChange{Owner: "example.com.", RRtype: dns.TypeCDNSKEY})
+	if b.Len() != 3 {
+		t.Errorf("got %d queued changes, want 3", b.Len())
+	}
+}
@@ -2,6 +2,7 @@ package music
 
 import (
 	"log"
+	"sync"
 
 	"github.com/miekg/dns"
 )
@@ -17,6 +18,7 @@ type Updater interface {
 	SetChannels(fetch, update chan SignerOp)
 	SetApi(api Api)
 	GetApi() Api
+	Capabilities() UpdaterCapabilities
 
 	Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error
 	RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error
@@ -25,11 +27,80 @@ type Updater interface {
 
 var Updaters map[string]Updater = make(map[string]Updater)
 
+// updaterMiddleware are the wrappers around the updater of every method, outermost
+// first. Each operation passes them in this order on its way to the signer:
+//
+//	DryRun        record, rather than send, updates in dry-run and observer mode
+//	Freeze        refuse modifications of frozen zones
+//	Pause         refuse modifications at paused signers
+//	QueryCache    serve repeated fetches from the cycle cache
+//	Propagation   measure the propagation time of successful updates
+//	Evidence      record successful updates as evidence
+//	Verify        read updated RRsets back and record the update in the history
+//	Published     track the CDS, CDNSKEY and CSYNC records published and removed
+//	KeyInventory  record the DNSKEY RRsets fetched from the zone apex
+//	Notify        send NOTIFYs after successful updates
+//	Breaker       track (and, with an open breaker, stop) the operations per signer
+//
+// Refused and dry-run updates thus never reach the wrappers that record or measure
+// updates, and the breaker sees exactly the operations that are sent to the signer.
+var updaterMiddleware = []func(Updater) Updater{
+	func(u Updater) Updater { return &DryRunUpdater{u} },
+	func(u Updater) Updater { return &FreezeUpdater{u} },
+	func(u Updater) Updater { return &PauseUpdater{u} },
+	func(u Updater) Updater { return &QueryCacheUpdater{u} },
+	func(u Updater) Updater { return &PropagationUpdater{u} },
+	func(u Updater) Updater { return &EvidenceUpdater{u} },
+	func(u Updater) Updater { return &VerifyUpdater{u} },
+	func(u Updater) Updater { return &PublishedUpdater{u} },
+	func(u Updater) Updater { return &KeyInventoryUpdater{u} },
+	func(u Updater) Updater { return &NotifyUpdater{u} },
+	func(u Updater) Updater { return &BreakerUpdater{u} },
+}
+
+// ddnsMiddleware are the additional wrappers of DDNS updaters, inside the breaker: each
+// operation is applied to all views of split-horizon signers, and fetches from anycast
+// signers are verified from all vantage points.
+var ddnsMiddleware = []func(Updater) Updater{
+	func(u Updater) Updater { return &ViewUpdater{u} },
+	func(u Updater) Updater { return &AnycastUpdater{u} },
+}
+
+// updaterChain returns the wrappers around the updater of the method, outermost first.
+func updaterChain(method string) []func(Updater) Updater {
+	switch method {
+	case "ddns", "rlddns":
+		return append(append([]func(Updater) Updater{}, updaterMiddleware...), ddnsMiddleware...)
+	}
+	return updaterMiddleware
+}
+
+func wrapUpdater(updater Updater, chain []func(Updater) Updater) Updater {
+	for i := len(chain) - 1; i >= 0; i-- {
+		updater = chain[i](updater)
+	}
+	return updater
+}
+
+// The wrappers are stateless, so the wrapped updater of each method is built only once.
+var wrappedUpdaters = struct {
+	sync.Mutex
+	updaters map[string]Updater
+}{updaters: map[string]Updater{}}
+
 func GetUpdater(type_ string) Updater {
+	wrappedUpdaters.Lock()
+	defer wrappedUpdaters.Unlock()
+
+	if updater, ok := wrappedUpdaters.updaters[type_]; ok {
+		return updater
+	}
 	updater, ok := Updaters[type_]
 	if !ok {
 		log.Fatal("No updater type", type_)
 	}
+	updater = wrapUpdater(updater, updaterChain(type_))
+	wrappedUpdaters.updaters[type_] = updater
 	return updater
 }
 
@@ -0,0 +1,44 @@
+package music
+
+import (
+	"reflect"
+	"testing"
+)
+
+// updaterChainTypes returns the type names of the wrappers of u, outermost first.
+func updaterChainTypes(u Updater) []string {
+	var types []string
+	for {
+		v := reflect.ValueOf(u).Elem()
+		f, ok := v.Type().FieldByName("Updater")
+		if !ok || !f.Anonymous {
+			return types
+		}
+		types = append(types, v.Type().Name())
+		u = v.FieldByName("Updater").Interface().(Updater)
+	}
+}
+
+func TestUpdaterChain(t *testing.T) {
+	common := []string{"DryRunUpdater", "FreezeUpdater", "PauseUpdater", "QueryCacheUpdater",
+		"PropagationUpdater", "EvidenceUpdater", "VerifyUpdater", "PublishedUpdater",
+		"KeyInventoryUpdater", "NotifyUpdater", "BreakerUpdater"}
+
+	for _, tc := range []struct {
+		method string
+		want   []string
+	}{
+		{"desec-api", common},
+		{"rldesec-api", common},
+		{"ddns", append(append([]string{}, common...), "ViewUpdater", "AnycastUpdater")},
+		{"rlddns", append(append([]string{}, common...), "ViewUpdater", "AnycastUpdater")},
+	} {
+		u := GetUpdater(tc.method)
+		if got := updaterChainTypes(u); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got chain %v, want %v", tc.method, got, tc.want)
+		}
+		if GetUpdater(tc.method) != u {
+			t.Errorf("%s: the wrapped updater is built on every call", tc.method)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Upserts are the reconcile-friendly way of managing zones and signer groups, intended
+// for controllers (like the Kubernetes bridge) that repeatedly send the full desired
+// spec of an object. An upsert is idempotent: sending the same spec twice results in no
+// changes the second time. Every upsert carries the generation of the spec and once the
+// spec has been applied without errors that generation is recorded as the observed
+// generation of the object.
+
+func (mdb *MusicDB) GetObservedGeneration(tx *sql.Tx, kind, name string) (int64, error) {
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("GetObservedGeneration: Error from mdb.StartTransaction(): %v\n", err)
+		return 0, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = "SELECT generation FROM observed_generations WHERE kind=? AND name=?"
+	var gen int64
+	err = tx.QueryRow(sqlq, kind, name).Scan(&gen)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if CheckSQLError("GetObservedGeneration", sqlq, err, false) {
+		return 0, err
+	}
+	return gen, nil
+}
+
+func (mdb *MusicDB) setObservedGeneration(kind, name string, gen int64) error {
+	const sqlq = `
+INSERT OR REPLACE INTO observed_generations(kind, name, generation, time) VALUES (?, ?, ?, datetime('now'))`
+	_, err := mdb.Exec(sqlq, kind, name, gen)
+	if CheckSQLError("setObservedGeneration", sqlq, err, false) {
+		return err
+	}
+	return nil
+}
+
+func (mdb *MusicDB) Upsert(up UpsertPost, enginecheck chan EngineCheck) UpsertResponse {
+	var resp UpsertResponse
+	var def StateDefinition
+
+	switch up.Kind {
+	case "Zone":
+		zname, err := CanonicalZoneName(up.Name)
+		if err != nil {
+			resp.Error, resp.ErrorMsg = true, err.Error()
+			return resp
+		}
+		up.Name = zname
+		up.Zone.Name = zname
+		def.Zones = []ZoneDef{up.Zone}
+
+	case "SignerGroup":
+		up.SignerGroup.Name = up.Name
+		def.SignerGroups = []SignerGroupDef{up.SignerGroup}
+
+	default:
+		resp.Error, resp.ErrorMsg = true, fmt.Sprintf("Unknown kind: '%s'", up.Kind)
+		return resp
+	}
+
+	plan, err := mdb.PlanState(&def)
+	if err != nil {
+		resp.Error, resp.ErrorMsg = true, err.Error()
+		return resp
+	}
+
+	// PlanState() reports everything that is not part of def as unmanaged. Here only
+	// the object itself is of interest.
+	var myplan []PlanItem
+	for _, pi := range plan {
+		if pi.Action != "unmanaged" {
+			myplan = append(myplan, pi)
+		}
+	}
+
+	failed := false
+	if len(myplan) > 0 {
+		resp.Changes = mdb.ApplyPlan(&def, myplan, enginecheck)
+		for _, pi := range myplan {
+			if pi.Action == "conflict" {
+				failed = true
+				resp.Changes = append(resp.Changes, pi.String())
+			}
+		}
+		// A second plan tells us whether everything was applied
+		if plan, err = mdb.PlanState(&def); err == nil {
+			for _, pi := range plan {
+				if pi.Action != "unmanaged" {
+					failed = true
+				}
+			}
+		}
+	}
+
+	if !failed && err == nil {
+		err = mdb.setObservedGeneration(up.Kind, up.Name, up.Generation)
+	}
+	if err != nil {
+		resp.Error, resp.ErrorMsg = true, err.Error()
+	}
+	resp.ObservedGeneration, _ = mdb.GetObservedGeneration(nil, up.Kind, up.Name)
+
+	switch up.Kind {
+	case "Zone":
+		dbzone, _, err := mdb.GetZone(nil, up.Name)
+		if err == nil && dbzone.Exists {
+			inprocess := dbzone.FSM != "" && dbzone.FSM != "---"
+			resp.State = fmt.Sprintf("%s:%s", dbzone.FSM, dbzone.State)
+			resp.Ready = !failed && !inprocess
+		}
+	case "SignerGroup":
+		sg, err := mdb.GetSignerGroup(nil, up.Name, true)
+		if err == nil {
+			resp.State = sg.CurrentProcess
+			resp.Ready = !failed && sg.CurrentProcess == ""
+		}
+	}
+
+	if failed {
+		resp.Msg = fmt.Sprintf("%s %s: spec generation %d not fully applied", up.Kind, up.Name,
+			up.Generation)
+	} else {
+		resp.Msg = fmt.Sprintf("%s %s: spec generation %d applied (%d changes)", up.Kind,
+			up.Name, up.Generation, len(resp.Changes))
+	}
+	return resp
+}
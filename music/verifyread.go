@@ -0,0 +1,162 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// Verify-read. Some backends acknowledge an update that is then never published (e.g.
+// an API that accepts an RRset it later drops). With signers.verifyread.active set,
+// every update is followed by fetching the affected RRsets back from the signer and
+// comparing them with what the update intended. The outcome is recorded in the update
+// history of the zone. With signers.verifyread.strict set, an update that does not
+// verify is also returned as an error, so that the action fails and is retried.
+
+type UpdateRecord struct {
+	Time     time.Time
+	Zone     string
+	Signer   string
+	Owner    string
+	Op       string // "update" or "remove-rrset"
+	Inserts  int
+	Removes  int
+	Verified string // "yes", "no" or "" (not verified)
+	Detail   string
+}
+
+// VerifyUpdater wraps an updater, verifies every update by reading the RRsets back and
+// records the update in the history.
+type VerifyUpdater struct {
+	Updater
+}
+
+func (u *VerifyUpdater) Update(signer *Signer, zone, fqdn string, inserts, removes *[][]dns.RR) error {
+	err := u.Updater.Update(signer, zone, fqdn, inserts, removes)
+	if err != nil {
+		return err
+	}
+	var ins, rem [][]dns.RR
+	if inserts != nil {
+		ins = *inserts
+	}
+	if removes != nil {
+		rem = *removes
+	}
+	ur := UpdateRecord{Op: "update", Inserts: countRRs(ins), Removes: countRRs(rem)}
+	return u.verify(signer, zone, fqdn, propagationChecks(fqdn, ins, rem, false), ur)
+}
+
+func (u *VerifyUpdater) RemoveRRset(signer *Signer, zone, fqdn string, rrsets [][]dns.RR) error {
+	err := u.Updater.RemoveRRset(signer, zone, fqdn, rrsets)
+	if err != nil {
+		return err
+	}
+	ur := UpdateRecord{Op: "remove-rrset", Removes: countRRs(rrsets)}
+	return u.verify(signer, zone, fqdn, propagationChecks(fqdn, nil, rrsets, true), ur)
+}
+
+func countRRs(rrsets [][]dns.RR) int {
+	n := 0
+	for _, rrset := range rrsets {
+		n += len(rrset)
+	}
+	return n
+}
+
+func (u *VerifyUpdater) verify(signer *Signer, zone, fqdn string, checks []*propagationCheck,
+	ur UpdateRecord) error {
+	ur.Time, ur.Zone, ur.Signer, ur.Owner = time.Now(), zone, signer.Name, fqdn
+
+	var err error
+	if viper.GetBool("signers.verifyread.active") && len(checks) > 0 {
+		ur.Verified = "yes"
+		var failed []string
+		for _, c := range checks {
+			// not through the query cache, which would answer with what we just wrote
+			ferr, rrs := u.Updater.FetchRRset(signer, zone, c.owner, c.rrtype)
+			switch {
+			case ferr != nil:
+				failed = append(failed, fmt.Sprintf("%s: %v", dns.TypeToString[c.rrtype], ferr))
+			case !c.matches(rrs):
+				failed = append(failed, fmt.Sprintf("%s: not as intended", dns.TypeToString[c.rrtype]))
+			}
+		}
+		if len(failed) > 0 {
+			ur.Verified = "no"
+			ur.Detail = strings.Join(failed, "; ")
+			log.Printf("VerifyUpdater: signer %s acknowledged an update of %s in %s that did not verify: %s",
+				signer.Name, fqdn, zone, ur.Detail)
+			if viper.GetBool("signers.verifyread.strict") {
+				err = fmt.Errorf("Update of %s at signer %s did not verify: %s", fqdn, signer.Name,
+					ur.Detail)
+			}
+		}
+	}
+
+	// queued rather than written here: with one FSM engine worker the updaters run inside
+	// the transaction of PushZones (see engineops.go)
+	if mdb := signer.MusicDB(); mdb != nil && mdb.UpdateC != nil {
+		buf, _ := json.Marshal(ur)
+		mdb.UpdateC <- DBUpdate{Type: "UPDATEHISTORY", Zone: zone, Key: signer.Name, Value: string(buf)}
+	}
+	return err
+}
+
+// RecordUpdateHistory applies a queued "UPDATEHISTORY" update to the DB.
+func (mdb *MusicDB) RecordUpdateHistory(tx *sql.Tx, u DBUpdate) error {
+	var ur UpdateRecord
+	if err := json.Unmarshal([]byte(u.Value), &ur); err != nil {
+		return fmt.Errorf("RecordUpdateHistory: malformed update: %v", err)
+	}
+	const sqlq = `
+INSERT INTO update_history(time, zone, signer, owner, op, inserts, removes, verified, detail)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := tx.Exec(sqlq, ur.Time.UTC().Format(layout), ur.Zone, ur.Signer, ur.Owner, ur.Op,
+		ur.Inserts, ur.Removes, ur.Verified, ur.Detail)
+	return err
+}
+
+// ListUpdateHistory returns the latest (at most limit) updates made to the signers of
+// the zone, newest first.
+func (mdb *MusicDB) ListUpdateHistory(tx *sql.Tx, zone string, limit int) ([]UpdateRecord, error) {
+	var urs []UpdateRecord
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("ListUpdateHistory: Error from mdb.StartTransaction(): %v\n", err)
+		return urs, err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	const sqlq = `
+SELECT time, zone, signer, owner, op, inserts, removes, verified, detail FROM update_history
+WHERE zone=? ORDER BY id DESC LIMIT ?`
+	rows, err := tx.Query(sqlq, zone, limit)
+	if CheckSQLError("ListUpdateHistory", sqlq, err, false) {
+		return urs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ur UpdateRecord
+		var t string
+		if err := rows.Scan(&t, &ur.Zone, &ur.Signer, &ur.Owner, &ur.Op, &ur.Inserts,
+			&ur.Removes, &ur.Verified, &ur.Detail); err != nil {
+			log.Fatalf("ListUpdateHistory: Error from rows.Scan(): %v", err)
+		}
+		ur.Time, _ = time.Parse(layout, t)
+		urs = append(urs, ur)
+	}
+	return urs, nil
+}
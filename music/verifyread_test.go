@@ -0,0 +1,32 @@
+package music
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPropagationCheckMatches(t *testing.T) {
+	a, _ := dns.NewRR("example.com. 3600 IN CDS 1 13 2 AAAA")
+	b, _ := dns.NewRR("example.com. 3600 IN CDS 2 13 2 BBBB")
+	bttl, _ := dns.NewRR("example.com. 60 IN CDS 2 13 2 bbbb")
+
+	checks := propagationChecks("example.com.", [][]dns.RR{{b}}, [][]dns.RR{{a}}, false)
+	if len(checks) != 1 {
+		t.Fatalf("propagationChecks: got %d checks, want 1", len(checks))
+	}
+	if !checks[0].matches([]dns.RR{bttl}) {
+		t.Errorf("matches: inserted RR with another TTL should match")
+	}
+	if checks[0].matches([]dns.RR{a, b}) {
+		t.Errorf("matches: removed RR still present should not match")
+	}
+	if checks[0].matches(nil) {
+		t.Errorf("matches: missing inserted RR should not match")
+	}
+
+	gone := propagationChecks("example.com.", nil, [][]dns.RR{{a}}, true)
+	if gone[0].matches([]dns.RR{b}) || !gone[0].matches(nil) {
+		t.Errorf("matches: a removed RRset must be entirely gone")
+	}
+}
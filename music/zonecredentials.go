@@ -0,0 +1,104 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// Per-zone API credentials. Some providers (e.g. deSEC) issue tokens that are scoped to
+// a single domain rather than to the account. Such a token can be attached to a
+// (signer, zone) pair and is then used instead of the signer's own credentials for all
+// API calls concerning that zone.
+
+var zoneCredentialMethods = map[string]bool{
+	"desec-api":   true,
+	"rldesec-api": true,
+}
+
+// SignerSetZoneCredential attaches token to the signer for zone. An empty token removes
+// the zone credential, after which the signer's own credentials are used again.
+func (mdb *MusicDB) SignerSetZoneCredential(tx *sql.Tx, dbsigner *Signer, zone,
+	token string) (string, error) {
+	if !dbsigner.Exists {
+		return "", fmt.Errorf("Signer %s is unknown.", dbsigner.Name)
+	}
+	if !zoneCredentialMethods[dbsigner.Method] {
+		return "", fmt.Errorf("Signer %s has method %s. Zone credentials are only supported for API signers.",
+			dbsigner.Name, dbsigner.Method)
+	}
+	if zone == "" {
+		return "", fmt.Errorf("No zone specified.")
+	}
+	zone = dns.Fqdn(zone)
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("SignerSetZoneCredential: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	if token == "" {
+		const sqlq = "DELETE FROM signer_zone_credentials WHERE signer=? AND zone=?"
+		_, err = tx.Exec(sqlq, dbsigner.Name, zone)
+		if CheckSQLError("SignerSetZoneCredential", sqlq, err, false) {
+			return "", err
+		}
+		return fmt.Sprintf("Signer %s: zone %s now uses the signer credentials.",
+			dbsigner.Name, zone), nil
+	}
+
+	const sqlq = `
+INSERT OR REPLACE INTO signer_zone_credentials(signer, zone, token) VALUES (?, ?, ?)`
+	_, err = tx.Exec(sqlq, dbsigner.Name, zone, token)
+	if CheckSQLError("SignerSetZoneCredential", sqlq, err, false) {
+		return "", err
+	}
+	return fmt.Sprintf("Signer %s: credential for zone %s set.", dbsigner.Name, zone), nil
+}
+
+func (mdb *MusicDB) getZoneCredentials(tx *sql.Tx, signer string) (map[string]string, error) {
+	creds := map[string]string{}
+
+	const sqlq = "SELECT zone, token FROM signer_zone_credentials WHERE signer=?"
+	rows, err := tx.Query(sqlq, signer)
+	if CheckSQLError("getZoneCredentials", sqlq, err, false) {
+		return creds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var zone, token string
+		if err := rows.Scan(&zone, &token); err != nil {
+			log.Fatalf("getZoneCredentials: Error from rows.Scan(): %v", err)
+		}
+		creds[zone] = token
+	}
+	return creds, nil
+}
+
+func credentialZones(creds map[string]string) []string {
+	var zones []string
+	for zone := range creds {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// apiToken returns the most specific API token available for zone: the zone credential
+// if there is one, otherwise dflt (the token of the signer).
+func (s *Signer) apiToken(zone, dflt string) string {
+	if token, ok := s.zoneTokens[dns.Fqdn(zone)]; ok {
+		return token
+	}
+	return dflt
+}
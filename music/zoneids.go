@@ -0,0 +1,138 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Provider zone IDs. Cloud backends (e.g. Route 53) do not address zones by name but by
+// a provider-specific ID (the hosted zone ID). An updater for such a backend registers a
+// ZoneIDResolver for its method, and ProviderZoneID resolves the name of a zone to its ID
+// at first use. The mapping is cached (in memory and in the DB) per signer, as different
+// signers are different accounts. If the provider no longer knows the ID (because the
+// zone was recreated) the updater returns ErrZoneIDNotFound and WithProviderZoneID
+// resolves the ID again and retries once.
+
+// ZoneIDResolver looks up the ID of the zone in the account of the signer.
+type ZoneIDResolver func(s *Signer, zone string) (string, error)
+
+var ZoneIDResolvers = map[string]ZoneIDResolver{}
+
+// ErrZoneIDNotFound is returned (wrapped, if need be) by updaters when the provider does
+// not know the zone ID.
+var ErrZoneIDNotFound = errors.New("zone ID not found")
+
+type zoneIDKey struct {
+	signer, zone string
+}
+
+var zoneIDCache = struct {
+	sync.Mutex
+	ids map[zoneIDKey]string
+}{ids: map[zoneIDKey]string{}}
+
+// ProviderZoneID returns the ID of the zone at the signer, resolving it if it is not
+// known yet.
+func (mdb *MusicDB) ProviderZoneID(s *Signer, zone string) (string, error) {
+	key := zoneIDKey{s.Name, zone}
+	zoneIDCache.Lock()
+	id, ok := zoneIDCache.ids[key]
+	zoneIDCache.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	if mdb != nil {
+		const sqlq = "SELECT zoneid FROM provider_zone_ids WHERE signer=? AND zone=?"
+		err := mdb.db.QueryRow(sqlq, s.Name, zone).Scan(&id)
+		switch {
+		case err == nil:
+			zoneIDCache.Lock()
+			zoneIDCache.ids[key] = id
+			zoneIDCache.Unlock()
+			return id, nil
+		case err != sql.ErrNoRows:
+			CheckSQLError("ProviderZoneID", sqlq, err, false)
+		}
+	}
+	return mdb.resolveZoneID(s, zone)
+}
+
+func (mdb *MusicDB) resolveZoneID(s *Signer, zone string) (string, error) {
+	resolver, ok := ZoneIDResolvers[s.Method]
+	if !ok {
+		return "", fmt.Errorf("Signer method %s does not use zone IDs", s.Method)
+	}
+	id, err := resolver(s, zone)
+	if err != nil {
+		return "", fmt.Errorf("Unable to resolve the zone ID of %s at signer %s: %w", zone, s.Name, err)
+	}
+	log.Printf("ProviderZoneID: zone %s at signer %s has ID %s", zone, s.Name, id)
+
+	zoneIDCache.Lock()
+	zoneIDCache.ids[zoneIDKey{s.Name, zone}] = id
+	zoneIDCache.Unlock()
+
+	// queued rather than written here: with one FSM engine worker the updaters run inside
+	// the transaction of PushZones (see engineops.go)
+	if mdb != nil && mdb.UpdateC != nil {
+		mdb.UpdateC <- DBUpdate{Type: "ZONEID", Zone: zone, Key: s.Name, Value: id}
+	}
+	return id, nil
+}
+
+// WithProviderZoneID calls f with the ID of the zone at the signer. If f fails with
+// ErrZoneIDNotFound, the ID is resolved again and f is retried once.
+func (mdb *MusicDB) WithProviderZoneID(s *Signer, zone string, f func(id string) error) error {
+	id, err := mdb.ProviderZoneID(s, zone)
+	if err != nil {
+		return err
+	}
+	err = f(id)
+	if !errors.Is(err, ErrZoneIDNotFound) {
+		return err
+	}
+	log.Printf("WithProviderZoneID: zone ID %s of %s at signer %s is stale, resolving again",
+		id, zone, s.Name)
+	if id, err = mdb.resolveZoneID(s, zone); err != nil {
+		return err
+	}
+	return f(id)
+}
+
+// RecordZoneID applies a queued "ZONEID" update to the DB.
+func (mdb *MusicDB) RecordZoneID(tx *sql.Tx, u DBUpdate) error {
+	const sqlq = `
+INSERT OR REPLACE INTO provider_zone_ids(signer, zone, zoneid, resolved)
+VALUES (?, ?, ?, datetime('now'))`
+	_, err := tx.Exec(sqlq, u.Key, u.Zone, u.Value)
+	return err
+}
+
+// forgetZoneIDs removes the cached zone IDs of the zone (at all signers) or, if zone is
+// "", of the signer.
+func (mdb *MusicDB) forgetZoneIDs(tx *sql.Tx, zone, signer string) error {
+	zoneIDCache.Lock()
+	for key := range zoneIDCache.ids {
+		if (zone == "" || key.zone == zone) && (signer == "" || key.signer == signer) {
+			delete(zoneIDCache.ids, key)
+		}
+	}
+	zoneIDCache.Unlock()
+
+	sqlq := "DELETE FROM provider_zone_ids WHERE zone=?"
+	arg := zone
+	if zone == "" {
+		sqlq, arg = "DELETE FROM provider_zone_ids WHERE signer=?", signer
+	}
+	_, err := tx.Exec(sqlq, arg)
+	CheckSQLError("forgetZoneIDs", sqlq, err, false)
+	return err
+}
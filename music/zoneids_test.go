@@ -0,0 +1,47 @@
+package music
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWithProviderZoneID(t *testing.T) {
+	ids := []string{"Z1", "Z2"}
+	resolved := 0
+	ZoneIDResolvers["test-cloud"] = func(s *Signer, zone string) (string, error) {
+		id := ids[resolved]
+		resolved++
+		return id, nil
+	}
+	defer delete(ZoneIDResolvers, "test-cloud")
+
+	var mdb *MusicDB
+	s := &Signer{Name: "cloud1", Method: "test-cloud"}
+	for i := 0; i < 2; i++ {
+		if id, err := mdb.ProviderZoneID(s, "example.com."); err != nil || id != "Z1" {
+			t.Fatalf("ProviderZoneID: got %s, %v, want Z1", id, err)
+		}
+	}
+	if resolved != 1 {
+		t.Errorf("resolved %d times, want once (cached)", resolved)
+	}
+
+	var used []string
+	err := mdb.WithProviderZoneID(s, "example.com.", func(id string) error {
+		used = append(used, id)
+		if id == "Z1" {
+			return fmt.Errorf("NoSuchHostedZone: %w", ErrZoneIDNotFound)
+		}
+		return nil
+	})
+	if err != nil || len(used) != 2 || used[1] != "Z2" {
+		t.Errorf("WithProviderZoneID: got %v, %v, want a retry with Z2", used, err)
+	}
+	if id, _ := mdb.ProviderZoneID(s, "example.com."); id != "Z2" {
+		t.Errorf("ProviderZoneID after refresh: got %s, want Z2", id)
+	}
+
+	if _, err := mdb.ProviderZoneID(&Signer{Name: "bind1", Method: "ddns"}, "example.com."); err == nil {
+		t.Errorf("ProviderZoneID: expected an error for a method without zone IDs")
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Per-zone DNSSEC health, exported as Prometheus gauges so that alerting rules can be
+// written directly against the zones rather than against the daemon.
+
+type ZoneMetrics struct {
+	Zone         string
+	SignerGroup  string
+	Process      string
+	State        string
+	StateSeconds int
+	Blocked      bool
+	RrsigExpiry  time.Time // earliest RRSIG expiration across all signers, zero if unknown
+	HaveCds      bool      // true if any signer publishes CDS
+	DsMatchesCds bool      // parent DS RRset == union of the signers CDS RRsets
+}
+
+// rrsigExpiry returns the earliest expiration of the RRSIGs over the SOA and DNSKEY
+// RRsets of zone, as served by signer s.
+func (s *Signer) rrsigExpiry(zone string) (time.Time, error) {
+	var earliest time.Time
+	c := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
+
+	for _, rrtype := range []uint16{dns.TypeSOA, dns.TypeDNSKEY} {
+		m := new(dns.Msg)
+		m.SetQuestion(zone, rrtype)
+		m.SetEdns0(4096, true)
+		r, _, err := s.Exchange(c, m)
+		if err != nil {
+			return earliest, err
+		}
+		for _, rr := range r.Answer {
+			if sig, ok := rr.(*dns.RRSIG); ok {
+				exp := time.Unix(int64(sig.Expiration), 0)
+				if earliest.IsZero() || exp.Before(earliest) {
+					earliest = exp
+				}
+			}
+		}
+	}
+	return earliest, nil
+}
+
+func dsKey(keytag uint16, alg, digesttype uint8, digest string) string {
+	return fmt.Sprintf("%d %d %d %s", keytag, alg, digesttype, strings.ToUpper(digest))
+}
+
+// dsMatchesCds compares the DS RRset in the parent with the union of the CDS RRsets
+// of the signers. The first return value is false if no signer publishes CDS.
+func (z *Zone) dsMatchesCds(signers map[string]*Signer) (bool, bool, error) {
+	cdsmap := map[string]bool{}
+	for _, s := range signers {
+		m := new(dns.Msg)
+		m.SetQuestion(z.Name, dns.TypeCDS)
+		c := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
+		r, _, err := s.Exchange(c, m)
+		if err != nil {
+			return false, false, err
+		}
+		for _, rr := range r.Answer {
+			if cds, ok := rr.(*dns.CDS); ok {
+				cdsmap[dsKey(cds.KeyTag, cds.Algorithm, cds.DigestType, cds.Digest)] = true
+			}
+		}
+	}
+	if len(cdsmap) == 0 {
+		return false, false, nil
+	}
+
+	parentAddress, exist, err := z.MusicDB.GetMeta(nil, z, "parentaddr")
+	if err != nil || !exist {
+		return true, false, err
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(z.Name, dns.TypeDS)
+	r, _, err := DnsExchange(new(dns.Client), m, parentAddress)
+	if err != nil {
+		return true, false, err
+	}
+	dsmap := map[string]bool{}
+	for _, rr := range r.Answer {
+		if ds, ok := rr.(*dns.DS); ok {
+			dsmap[dsKey(ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)] = true
+		}
+	}
+
+	if len(dsmap) != len(cdsmap) {
+		return true, false, nil
+	}
+	for k := range cdsmap {
+		if !dsmap[k] {
+			return true, false, nil
+		}
+	}
+	return true, true, nil
+}
+
+// CollectZoneMetrics queries the signers (and parents) of all zones. Errors for
+// individual zones are logged and result in the corresponding metric being left out.
+func (mdb *MusicDB) CollectZoneMetrics() ([]ZoneMetrics, error) {
+	var zml []ZoneMetrics
+
+	zones, err := mdb.ListZones()
+	if err != nil {
+		return zml, err
+	}
+
+	for _, z := range zones {
+		zm := ZoneMetrics{
+			Zone:        z.Name,
+			SignerGroup: z.SGname,
+			Process:     z.FSM,
+			State:       z.State,
+			Blocked:     z.FSMStatus == "blocked",
+		}
+		if z.FSM != "" && z.FSM != "---" {
+			zm.StateSeconds = int(time.Since(z.Statestamp).Seconds())
+		}
+
+		if z.SGroup != nil && len(z.SGroup.SignerMap) > 0 && z.Type().Monitored {
+			for _, s := range z.SGroup.SignerMap {
+				exp, err := s.rrsigExpiry(z.Name)
+				if err != nil {
+					log.Printf("CollectZoneMetrics: zone %s: signer %s: %v", z.Name, s.Name, err)
+					continue
+				}
+				if !exp.IsZero() && (zm.RrsigExpiry.IsZero() || exp.Before(zm.RrsigExpiry)) {
+					zm.RrsigExpiry = exp
+				}
+			}
+
+			tz := z
+			tz.MusicDB = mdb
+			zm.HaveCds, zm.DsMatchesCds, err = tz.dsMatchesCds(z.SGroup.SignerMap)
+			if err != nil {
+				log.Printf("CollectZoneMetrics: zone %s: DS/CDS comparison: %v", z.Name, err)
+			}
+		}
+		zml = append(zml, zm)
+	}
+	sort.Slice(zml, func(i, j int) bool { return zml[i].Zone < zml[j].Zone })
+	return zml, nil
+}
+
+func promLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func promBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// FormatZoneMetrics returns the metrics in the Prometheus text exposition format.
+func FormatZoneMetrics(zml []ZoneMetrics) string {
+	var info, secs, blocked, expiry, dsmatch []string
+
+	for _, zm := range zml {
+		zl := fmt.Sprintf(`zone="%s"`, promLabel(zm.Zone))
+		info = append(info, fmt.Sprintf(`music_zone_info{%s,signergroup="%s",process="%s",state="%s"} 1`,
+			zl, promLabel(zm.SignerGroup), promLabel(zm.Process), promLabel(zm.State)))
+		secs = append(secs, fmt.Sprintf("music_zone_state_seconds{%s} %d", zl, zm.StateSeconds))
+		blocked = append(blocked, fmt.Sprintf("music_zone_blocked{%s} %d", zl, promBool(zm.Blocked)))
+		if !zm.RrsigExpiry.IsZero() {
+			expiry = append(expiry, fmt.Sprintf("music_zone_rrsig_expiry_timestamp_seconds{%s} %d",
+				zl, zm.RrsigExpiry.Unix()))
+		}
+		if zm.HaveCds {
+			dsmatch = append(dsmatch, fmt.Sprintf("music_zone_ds_matches_cds{%s} %d", zl,
+				promBool(zm.DsMatchesCds)))
+		}
+	}
+
+	var out strings.Builder
+	section := func(name, help string, lines []string) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, l := range lines {
+			out.WriteString(l + "\n")
+		}
+	}
+	section("music_zone_info", "Current process and state of the zone.", info)
+	section("music_zone_state_seconds", "Seconds the zone has been in its current process state.", secs)
+	section("music_zone_blocked", "1 if the next state transition of the zone is blocked.", blocked)
+	section("music_zone_rrsig_expiry_timestamp_seconds",
+		"Earliest RRSIG expiration (SOA, DNSKEY) across all signers of the zone.", expiry)
+	section("music_zone_ds_matches_cds",
+		"1 if the DS RRset in the parent matches the CDS RRsets of the signers.", dsmatch)
+	return out.String()
+}
@@ -11,7 +11,6 @@ import (
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/miekg/dns"
 )
 
 func (z *Zone) SignerGroup() *SignerGroup {
@@ -30,7 +29,10 @@ func (mdb *MusicDB) AddZone(z *Zone, group string, enginecheck chan EngineCheck)
 	}
 	defer mdb.CloseTransaction(localtx, tx, err)
 
-	fqdn := dns.Fqdn(z.Name)
+	fqdn, err := CanonicalZoneName(z.Name)
+	if err != nil {
+		return "", err
+	}
 	dbzone, _, err := mdb.GetZone(tx, fqdn)
 	if err != nil {
 		return "", err
@@ -39,6 +41,10 @@ func (mdb *MusicDB) AddZone(z *Zone, group string, enginecheck chan EngineCheck)
 		return "", fmt.Errorf("Zone %s already present in MuSiC system.", fqdn)
 	}
 
+	if _, err = GetZoneType(z.ZoneType); err != nil {
+		return "", err
+	}
+
 	const sqlq = `
 INSERT INTO zones(name, zonetype, state, statestamp, fsm, fsmmode)
 VALUES (?, ?, ?, datetime('now'), ?, ?)`
@@ -50,7 +56,7 @@ VALUES (?, ?, ?, datetime('now'), ?, ?)`
 
 	if group != "" {
 		fmt.Printf("AddGroup: the zone %s has the signergroup %s specified so we set that too\n", z.Name, group)
-		dbzone, _, err := mdb.GetZone(tx, z.Name)
+		dbzone, _, err := mdb.GetZone(tx, fqdn)
 		if err != nil {
 			return "", err
 		}
@@ -80,6 +86,9 @@ func (mdb *MusicDB) UpdateZone(dbzone, uz *Zone, enginecheck chan EngineCheck) (
 	defer mdb.CloseTransaction(localtx, tx, err)
 
 	if uz.ZoneType != "" {
+		if _, err = GetZoneType(uz.ZoneType); err != nil {
+			return "", err
+		}
 		dbzone.ZoneType = uz.ZoneType
 	}
 
@@ -141,7 +150,73 @@ func (mdb *MusicDB) DeleteZone(z *Zone) (string, error) {
 		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
 	}
 
+	if err = mdb.clearApprovals(tx, z.Name); err != nil {
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
+	_, err = tx.Exec("DELETE FROM zone_external_nses WHERE zone=?", z.Name)
+	if err != nil {
+		log.Printf("DeleteZone: Error from tx.Exec: %v\n", err)
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
+	_, err = tx.Exec("DELETE FROM signer_zone_credentials WHERE zone=?", z.Name)
+	if err != nil {
+		log.Printf("DeleteZone: Error from tx.Exec: %v\n", err)
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
+	_, err = tx.Exec("DELETE FROM dnskey_inventory WHERE zone=?", z.Name)
+	if err != nil {
+		log.Printf("DeleteZone: Error from tx.Exec: %v\n", err)
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
+	_, err = tx.Exec("DELETE FROM zone_managed_names WHERE zone=?", z.Name)
+	if err != nil {
+		log.Printf("DeleteZone: Error from tx.Exec: %v\n", err)
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
+	_, err = tx.Exec("DELETE FROM child_delegations WHERE parent=?", z.Name)
+	if err != nil {
+		log.Printf("DeleteZone: Error from tx.Exec: %v\n", err)
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
+	_, err = tx.Exec("DELETE FROM update_history WHERE zone=?", z.Name)
+	if err != nil {
+		log.Printf("DeleteZone: Error from tx.Exec: %v\n", err)
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
+	_, err = tx.Exec("DELETE FROM process_metrics WHERE zone=?", z.Name)
+	if err != nil {
+		log.Printf("DeleteZone: Error from tx.Exec: %v\n", err)
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
+	_, err = tx.Exec("DELETE FROM scheduled_tasks WHERE zone=?", z.Name)
+	if err != nil {
+		log.Printf("DeleteZone: Error from tx.Exec: %v\n", err)
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
+	// the annotations of the process runs are kept along with the runs
+	_, err = tx.Exec("DELETE FROM zone_annotations WHERE zone=? AND run=0", z.Name)
+	if err != nil {
+		log.Printf("DeleteZone: Error from tx.Exec: %v\n", err)
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
+	if err = mdb.forgetZoneIDs(tx, z.Name, ""); err != nil {
+		return fmt.Sprintf("Failed to delete zone '%s'", z.Name), err
+	}
+
 	deletemsg := fmt.Sprintf("Zone %s deleted.", z.Name)
+	for _, m := range mdb.desecDeprovisionZone(tx, z.Name) {
+		deletemsg += "\n" + m
+	}
 	processcomplete, msg, err := mdb.CheckIfProcessComplete(tx, sg)
 	if err != nil {
 		return fmt.Sprintf("Error from CheckIfProcessComplete(): %v", err), err
@@ -153,9 +228,19 @@ func (mdb *MusicDB) DeleteZone(z *Zone) (string, error) {
 }
 
 func (z *Zone) SetStopReason(value string) (error, string) {
+	if z.explain != nil {
+		z.explain.StopReasons = append(z.explain.StopReasons, value)
+		return nil, fmt.Sprintf("Zone %s stop-reason would be '%s'", z.Name, value)
+	}
 	mdb := z.MusicDB
 
+	mdb.stopmu.Lock()
+	changed := mdb.StopReasonCache[z.Name] != value
 	mdb.StopReasonCache[z.Name] = value
+	mdb.stopmu.Unlock()
+	if changed {
+		mdb.NotifyZoneContact(z, ZoneEventStopped, value)
+	}
 
 	mdb.UpdateC <- DBUpdate{
 		Type:  "STOPREASON",
@@ -168,7 +253,9 @@ func (z *Zone) SetStopReason(value string) (error, string) {
 	return nil, fmt.Sprintf("Zone %s stop-reason documented as '%s'", z.Name, value)
 }
 
-// XXX: SetDelayReason is not yet in use, but is needed for the wait-for-parent-ds stuff
+// SetDelayReason marks the zone as delayed for the duration delay. The FSM engine will
+// not try to move the zone forward until the delay has passed.
+// XXX: Also needed for the wait-for-parent-ds stuff
 func (z *Zone) SetDelayReason(tx *sql.Tx, value string, delay time.Duration) (string, error) {
 	mdb := z.MusicDB
 
@@ -183,6 +270,11 @@ func (z *Zone) SetDelayReason(tx *sql.Tx, value string, delay time.Duration) (st
 	if err != nil {
 		return msg, err
 	}
+	until := time.Now().Add(delay).UTC().Format(time.RFC3339)
+	msg, err = mdb.ZoneSetMeta(tx, z, "delay-until", until)
+	if err != nil {
+		return msg, err
+	}
 
 	const sqlq = "UPDATE zones SET fsmstatus='delayed' WHERE name=?"
 
@@ -190,6 +282,11 @@ func (z *Zone) SetDelayReason(tx *sql.Tx, value string, delay time.Duration) (st
 	if err != nil {
 		log.Fatalf("DocumentStop: Error from tx.Exec(%s): %v", sqlq, err)
 	}
+
+	// have the engine look at the zone as soon as the delay has passed
+	if err = mdb.ScheduleTask(tx, z.Name, "delay-end", "", time.Now().Add(delay)); err != nil {
+		return "", err
+	}
 	log.Printf("%s\n", value)
 	return msg, err
 }
@@ -267,6 +364,10 @@ func (z *Zone) StateTransition(tx *sql.Tx, from, to string) error {
 	defer mdb.CloseTransaction(localtx, tx, err)
 
 	fmt.Printf("This is %s StateTransition(%s-->%s) in process %s\n", z.Name, from, to, fsm)
+	if ObserverMode() {
+		log.Printf("OBSERVER: zone %s: not changing state from '%s' to '%s'", z.Name, from, to)
+		return nil
+	}
 	if fsm == "" {
 		return fmt.Errorf("Zone %s is not currently in any ongoing process.", z.Name)
 	}
@@ -278,6 +379,8 @@ func (z *Zone) StateTransition(tx *sql.Tx, from, to string) error {
 
 	if from == FsmStateStop && to == FsmStateStop {
 		log.Printf("StateTransition: terminal state reached. Exiting process.\n")
+		mdb.NotifyZoneContact(z, ZoneEventProcessComplete,
+			fmt.Sprintf("Zone %s has completed the process '%s'.", z.Name, fsm))
 		to = "---"
 		fsm = "---"
 	}
@@ -292,6 +395,14 @@ func (z *Zone) StateTransition(tx *sql.Tx, from, to string) error {
 		log.Printf("StateTransition: Error from ZoneSetMeta: %v\n", err)
 		return err
 	}
+	if err = mdb.evidenceTransition(tx, z, from, to); err != nil {
+		return err
+	}
+	if fsm == "---" {
+		if err = mdb.evidenceEndRun(tx, z, "completed"); err != nil {
+			return err
+		}
+	}
 	log.Printf("Zone %s transitioned from %s to %s in process %s", z.Name, from, to, fsm)
 
 	return nil
@@ -480,6 +591,16 @@ func (mdb *MusicDB) ZoneJoinGroup(tx *sql.Tx, dbzone *Zone, g string,
 	}
 	defer mdb.CloseTransaction(localtx, tx, err)
 
+	// deSEC signers only accept RRsets for domains that exist in the account
+	provmsgs, err := mdb.desecProvisionZone(tx, dbzone.Name, group)
+	if err != nil {
+		return "", err
+	}
+	provmsg := ""
+	for _, m := range provmsgs {
+		provmsg += "\n" + m
+	}
+
 	const sqlq = "UPDATE zones SET sgroup=? WHERE name=?"
 
 	_, err = tx.Exec(sqlq, g, dbzone.Name)
@@ -504,15 +625,15 @@ func (mdb *MusicDB) ZoneJoinGroup(tx *sql.Tx, dbzone *Zone, g string,
 
 		enginecheck <- EngineCheck{ZoneName: dbzone.Name}
 		return fmt.Sprintf(
-			"Zone %s has joined signer group %s and started the process '%s'.",
-			dbzone.Name, g, SignerJoinGroupProcess), nil
+			"Zone %s has joined signer group %s and started the process '%s'.%s",
+			dbzone.Name, g, SignerJoinGroupProcess, provmsg), nil
 	}
 
 	enginecheck <- EngineCheck{ZoneName: dbzone.Name}
 	return fmt.Sprintf(
 		`Zone %s has joined signer group %s but could not start the process '%s'
-as the zone is already in process '%s'. Problematic.`, dbzone.Name,
-		g, SignerJoinGroupProcess, dbzone.FSM), nil
+as the zone is already in process '%s'. Problematic.%s`, dbzone.Name,
+		g, SignerJoinGroupProcess, dbzone.FSM, provmsg), nil
 }
 
 // Leaving a signer group is different from joining in the sense that
@@ -642,6 +763,16 @@ FROM zones`
 				}
 				log.Printf("ListZones: zone %s is blocked. reason: '%s'", name, stopreason)
 				tz.StopReason = stopreason
+			} else if fsmstatus == "delayed" {
+				reason, _, err := mdb.GetMeta(tx, &tz, "delay-reason")
+				if err != nil {
+					return zl, err
+				}
+				until, _, err := mdb.GetMeta(tx, &tz, "delay-until")
+				if err != nil {
+					return zl, err
+				}
+				tz.StopReason = reason + "|" + until
 			}
 			zl[name] = tz
 
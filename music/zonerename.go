@@ -0,0 +1,187 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Zone renaming. A zone entry may have to change name, e.g. after a fix of the IDN
+// canonicalization of the name. Deleting the zone and adding it again would lose its
+// history (process runs, evidence, update history), its signer group and its process
+// state, so instead every reference to the zone in the DB is changed in one transaction.
+// Owner names below the apex (managed names, published RRs) are changed along with it.
+// The cached signer data and the provider zone IDs are dropped and fetched again.
+// Note that the zone must already be served under the new name by the signers.
+
+// tables (and the column) where the zone is referred to by name
+var zoneNameColumns = []struct{ table, column string }{
+	{"zone_dnskeys", "zone"},
+	{"zone_nses", "zone"},
+	{"metadata", "zone"},
+	{"propagation_times", "zone"},
+	{"approvals", "zone"},
+	{"zone_external_nses", "zone"},
+	{"process_runs", "zone"},
+	{"signer_zone_credentials", "zone"},
+	{"atlas_measurements", "zone"},
+	{"dnskey_inventory", "zone"},
+	{"published_records", "zone"},
+	{"zone_managed_names", "zone"},
+	{"child_delegations", "parent"},
+	{"child_delegations", "child"},
+	{"desec_provisioned", "zone"},
+	{"update_history", "zone"},
+	{"scheduled_tasks", "zone"},
+	{"process_metrics", "zone"},
+	{"zone_annotations", "zone"},
+}
+
+// tables with owner names below the apex of the zone
+var zoneOwnerTables = []string{"published_records", "zone_managed_names"}
+
+// renameOwner returns owner moved from below oldzone to below newzone.
+func renameOwner(owner, oldzone, newzone string) string {
+	switch {
+	case strings.EqualFold(owner, oldzone):
+		return newzone
+	case strings.HasSuffix(strings.ToLower(owner), "."+strings.ToLower(oldzone)):
+		return owner[:len(owner)-len(oldzone)] + newzone
+	}
+	return owner
+}
+
+func (mdb *MusicDB) RenameZone(tx *sql.Tx, z *Zone, newname string) (string, error) {
+	if !z.Exists {
+		return "", fmt.Errorf("Zone %s not present in MuSiC system.", z.Name)
+	}
+	newname, err := CanonicalZoneName(newname)
+	if err != nil {
+		return "", err
+	}
+	if newname == z.Name {
+		return "", fmt.Errorf("Zone %s already has that name.", z.Name)
+	}
+
+	localtx, tx, err := mdb.StartTransaction(tx)
+	if err != nil {
+		log.Printf("RenameZone: Error from mdb.StartTransaction(): %v\n", err)
+		return "fail", err
+	}
+	defer mdb.CloseTransaction(localtx, tx, err)
+
+	dbzone, _, err := mdb.GetZone(tx, newname)
+	if err != nil {
+		return "", err
+	}
+	if dbzone.Exists {
+		err = fmt.Errorf("Zone %s already present in MuSiC system.", newname)
+		return "", err
+	}
+
+	if err = mdb.renameOwners(tx, z.Name, newname); err != nil {
+		return "", err
+	}
+
+	const sqlq = "UPDATE zones SET name=? WHERE name=?"
+	if _, err = tx.Exec(sqlq, newname, z.Name); CheckSQLError("RenameZone", sqlq, err, false) {
+		return "", err
+	}
+	for _, zc := range zoneNameColumns {
+		sqlq := fmt.Sprintf("UPDATE %s SET %s=? WHERE %s=?", zc.table, zc.column, zc.column)
+		if _, err = tx.Exec(sqlq, newname, z.Name); CheckSQLError("RenameZone", sqlq, err, false) {
+			return "", err
+		}
+	}
+
+	const sqlq2 = "DELETE FROM records WHERE zone=? OR zone=?"
+	if _, err = tx.Exec(sqlq2, z.Name, StripDot(z.Name)); CheckSQLError("RenameZone", sqlq2, err, false) {
+		return "", err
+	}
+	if err = mdb.forgetZoneIDs(tx, z.Name, ""); err != nil {
+		return "", err
+	}
+	mdb.stopmu.Lock()
+	if reason, ok := mdb.StopReasonCache[z.Name]; ok {
+		mdb.StopReasonCache[newname] = reason
+		delete(mdb.StopReasonCache, z.Name)
+	}
+	mdb.stopmu.Unlock()
+
+	log.Printf("RenameZone: zone %s renamed to %s", z.Name, newname)
+	return fmt.Sprintf("Zone %s renamed to %s. Make sure that the signers serve the zone under the new name.",
+		z.Name, newname), nil
+}
+
+// renameOwners moves the owner names (and RRs) below the apex of oldzone to newzone.
+func (mdb *MusicDB) renameOwners(tx *sql.Tx, oldzone, newzone string) error {
+	for _, table := range zoneOwnerTables {
+		sqlq := fmt.Sprintf("SELECT DISTINCT owner FROM %s WHERE zone=?", table)
+		rows, err := tx.Query(sqlq, oldzone)
+		if CheckSQLError("renameOwners", sqlq, err, false) {
+			return err
+		}
+		var owners []string
+		for rows.Next() {
+			var owner sql.NullString
+			if err := rows.Scan(&owner); err != nil {
+				rows.Close()
+				return err
+			}
+			if owner.Valid {
+				owners = append(owners, owner.String)
+			}
+		}
+		rows.Close()
+
+		for _, owner := range owners {
+			newowner := renameOwner(owner, oldzone, newzone)
+			if newowner == owner {
+				continue
+			}
+			sqlq := fmt.Sprintf("UPDATE %s SET owner=? WHERE zone=? AND owner=?", table)
+			_, err := tx.Exec(sqlq, newowner, oldzone, owner)
+			if CheckSQLError("renameOwners", sqlq, err, false) {
+				return err
+			}
+		}
+	}
+
+	// the published RRs are kept in text form
+	const sqlq = "SELECT id, rr FROM published_records WHERE zone=?"
+	rows, err := tx.Query(sqlq, oldzone)
+	if CheckSQLError("renameOwners", sqlq, err, false) {
+		return err
+	}
+	renamed := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var rrstr string
+		if err := rows.Scan(&id, &rrstr); err != nil {
+			rows.Close()
+			return err
+		}
+		rr, err := dns.NewRR(rrstr)
+		if err != nil || rr == nil {
+			continue
+		}
+		rr.Header().Name = renameOwner(rr.Header().Name, oldzone, newzone)
+		renamed[id] = rr.String()
+	}
+	rows.Close()
+
+	const sqlq2 = "UPDATE published_records SET rr=? WHERE id=?"
+	for id, rrstr := range renamed {
+		if _, err := tx.Exec(sqlq2, rrstr, id); CheckSQLError("renameOwners", sqlq2, err, false) {
+			return err
+		}
+	}
+	return nil
+}
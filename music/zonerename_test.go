@@ -0,0 +1,19 @@
+package music
+
+import "testing"
+
+func TestRenameOwner(t *testing.T) {
+	tests := []struct{ owner, want string }{
+		{"example.com.", "example.net."},
+		{"EXAMPLE.com.", "example.net."},
+		{"child.example.com.", "child.example.net."},
+		{"_dsboot.child.Example.COM.", "_dsboot.child.example.net."},
+		{"notexample.com.", "notexample.com."},
+		{"example.org.", "example.org."},
+	}
+	for _, tt := range tests {
+		if got := renameOwner(tt.owner, "example.com.", "example.net."); got != tt.want {
+			t.Errorf("renameOwner(%q) = %q, want %q", tt.owner, got, tt.want)
+		}
+	}
+}
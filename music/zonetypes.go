@@ -0,0 +1,85 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package music
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Zone types. What sets the types of zones apart is declared as capabilities of the type
+// rather than tested for by name wherever it matters; the FSM code asks e.g. whether the
+// steps of the zone are simulated, never whether the zone is of type "debug". New types
+// are added with RegisterZoneType.
+//
+//   normal:          the zone is handled for real.
+//   debug:           the steps of the processes are simulated: pre- and post-conditions
+//                    are met and actions succeed without any signer or parent involved.
+//   dry-run:         as normal, but changes are only logged, not sent to the signers
+//                    (see dryrun.go).
+//   external-parent: the parent is updated by other means than CDS/CDNSKEY/CSYNC (e.g.
+//                    via the registrar), so nothing is published for the parent.
+
+type ZoneType struct {
+	Name          string
+	Description   string
+	Simulated     bool // process steps succeed without looking at signers or parent
+	DryRun        bool // changes are not sent to the signers
+	ParentSignals bool // CDS, CDNSKEY and CSYNC are published for the parent
+	Monitored     bool // included in the key inventory, metrics, observations etc
+}
+
+var ZoneTypes = map[string]ZoneType{}
+
+const DefaultZoneType = "normal"
+
+func init() {
+	RegisterZoneType(ZoneType{Name: "normal", Description: "handled for real",
+		ParentSignals: true, Monitored: true})
+	RegisterZoneType(ZoneType{Name: "debug", Description: "process steps are simulated",
+		Simulated: true})
+	RegisterZoneType(ZoneType{Name: "dry-run", Description: "changes are logged, not sent to the signers",
+		DryRun: true, ParentSignals: true, Monitored: true})
+	RegisterZoneType(ZoneType{Name: "external-parent",
+		Description: "the parent is updated without CDS/CDNSKEY/CSYNC", Monitored: true})
+}
+
+// RegisterZoneType adds (or replaces) a zone type.
+func RegisterZoneType(zt ZoneType) {
+	ZoneTypes[zt.Name] = zt
+}
+
+// GetZoneType returns the zone type name. "" is the default type.
+func GetZoneType(name string) (ZoneType, error) {
+	if name == "" {
+		name = DefaultZoneType
+	}
+	zt, ok := ZoneTypes[name]
+	if !ok {
+		return ZoneType{}, fmt.Errorf("Unknown zone type '%s'. Known types are: %v", name,
+			ListZoneTypes())
+	}
+	return zt, nil
+}
+
+// ListZoneTypes returns the names of the zone types, sorted.
+func ListZoneTypes() []string {
+	var names []string
+	for name := range ZoneTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Type returns the zone type of the zone. A zone of an unknown type (e.g. one that is
+// no longer registered) is treated as "debug", so that nothing is done to it for real.
+func (z *Zone) Type() ZoneType {
+	zt, err := GetZoneType(z.ZoneType)
+	if err != nil {
+		return ZoneTypes["debug"]
+	}
+	return zt
+}
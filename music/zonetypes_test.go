@@ -0,0 +1,30 @@
+package music
+
+import "testing"
+
+func TestZoneTypes(t *testing.T) {
+	if zt, err := GetZoneType(""); err != nil || zt.Name != DefaultZoneType {
+		t.Errorf(`GetZoneType("") = %v, %v`, zt, err)
+	}
+	if _, err := GetZoneType("bogus"); err == nil {
+		t.Errorf(`GetZoneType("bogus") succeeded`)
+	}
+
+	tests := []struct {
+		zonetype                         string
+		simulated, dryrun, parentsignals bool
+	}{
+		{"", false, false, true},
+		{"normal", false, false, true},
+		{"debug", true, false, false},
+		{"dry-run", false, true, true},
+		{"external-parent", false, false, false},
+		{"bogus", true, false, false}, // unknown types are not handled for real
+	}
+	for _, tt := range tests {
+		zt := (&Zone{ZoneType: tt.zonetype}).Type()
+		if zt.Simulated != tt.simulated || zt.DryRun != tt.dryrun || zt.ParentSignals != tt.parentsignals {
+			t.Errorf("zone type %q: %+v", tt.zonetype, zt)
+		}
+	}
+}
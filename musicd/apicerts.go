@@ -0,0 +1,160 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS certificate of the API listener. Either the certificate is managed by musicd
+// itself via ACME (apiserver.acme: Let's Encrypt or an internal CA with an ACME
+// directory), or it is read from apiserver.certFile and apiserver.keyFile. In the
+// latter case the files are watched and a renewed certificate is used as soon as it is
+// written (or on SIGHUP), without restarting musicd.
+
+type certReloader struct {
+	mu       sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modtime  time.Time // of the latest loaded cert file
+}
+
+var apiCerts *certReloader
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload reads the certificate and key again. On failure the current certificate is
+// kept.
+func (cr *certReloader) Reload() error {
+	fi, err := os.Stat(cr.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load API certificate %s: %v", cr.certFile, err)
+	}
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modtime = fi.ModTime()
+	cr.mu.Unlock()
+	return nil
+}
+
+// changed returns true if the cert or key file has been modified since it was loaded.
+func (cr *certReloader) changed() bool {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	for _, f := range []string{cr.certFile, cr.keyFile} {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(cr.modtime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// Watch reloads the certificate when the files change, until stopch is closed.
+func (cr *certReloader) Watch(interval time.Duration, stopch <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			if !cr.changed() {
+				continue
+			}
+			// the cert and key may not be written at the same time; retry next tick
+			if err := cr.Reload(); err != nil {
+				log.Printf("API certificate: %v. Keeping the current certificate.", err)
+				continue
+			}
+			log.Printf("API certificate: reloaded %s", cr.certFile)
+		case <-stopch:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// ReloadAPICert is called on SIGHUP.
+func ReloadAPICert() {
+	if apiCerts == nil {
+		return
+	}
+	if err := apiCerts.Reload(); err != nil {
+		log.Printf("API certificate: %v. Keeping the current certificate.", err)
+		return
+	}
+	log.Printf("API certificate: reloaded %s", apiCerts.certFile)
+}
+
+// apiTLSConfig returns the TLS config for the API listener.
+func apiTLSConfig(stopch <-chan struct{}) (*tls.Config, error) {
+	if viper.GetBool("apiserver.acme.active") {
+		hosts := viper.GetStringSlice("apiserver.acme.hosts")
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("apiserver.acme.hosts must list the name(s) of the API server")
+		}
+		cachedir := viper.GetString("apiserver.acme.cachedir")
+		if cachedir == "" {
+			cachedir = "/var/lib/musicd/acme"
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cachedir),
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Email:      viper.GetString("apiserver.acme.email"),
+		}
+		if dir := viper.GetString("apiserver.acme.directory"); dir != "" {
+			m.Client = &acme.Client{DirectoryURL: dir} // e.g. an internal CA
+		}
+		// the tls-alpn-01 challenge is answered on the API listener itself; the
+		// http-01 challenge needs a plain HTTP listener (usually on port 80)
+		if httpaddr := viper.GetString("apiserver.acme.httpaddress"); httpaddr != "" {
+			go func() {
+				log.Printf("ACME: answering http-01 challenges on %s", httpaddr)
+				log.Printf("ACME: http-01 listener: %v", http.ListenAndServe(httpaddr, m.HTTPHandler(nil)))
+			}()
+		}
+		log.Printf("API certificate: managed via ACME for %v (cache: %s)", hosts, cachedir)
+		return m.TLSConfig(), nil
+	}
+
+	cr, err := newCertReloader(viper.GetString("apiserver.certFile"),
+		viper.GetString("apiserver.keyFile"))
+	if err != nil {
+		return nil, err
+	}
+	apiCerts = cr
+
+	interval := viper.GetInt("apiserver.certreload")
+	if interval <= 0 {
+		interval = 60
+	}
+	go cr.Watch(time.Duration(interval)*time.Second, stopch)
+	return &tls.Config{GetCertificate: cr.GetCertificate}, nil
+}
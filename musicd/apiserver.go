@@ -6,11 +6,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
@@ -195,7 +199,14 @@ func APIzone(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 		}
 		w.Header().Set("Content-Type", "application/json")
 
-		dbzone, _, err := mdb.GetZone(nil, zp.Zone.Name) // Get a more complete Zone structure
+		var dbzone *music.Zone
+		// commands like "list" don't take a zone name
+		if strings.TrimSpace(zp.Zone.Name) != "" {
+			zp.Zone.Name, err = music.CanonicalZoneName(zp.Zone.Name)
+		}
+		if err == nil {
+			dbzone, _, err = mdb.GetZone(nil, zp.Zone.Name) // Get a more complete Zone structure
+		}
 		if err != nil {
 			resp.Error = true
 			resp.ErrorMsg = err.Error()
@@ -227,6 +238,10 @@ func APIzone(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 							SGname:     sg.Name,
 						}
 						resp.Zones = zl
+						resp.Annotations, _ = mdb.ListZoneAnnotations(nil, dbzone.Name, 0)
+						if s, stuck := music.ZoneStuck(dbzone.Name); stuck {
+							resp.Stuck = &s
+						}
 					}
 
 				} else {
@@ -254,7 +269,10 @@ func APIzone(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 				}
 
 			case "delete":
-				resp.Msg, err = mdb.DeleteZone(dbzone)
+				err = mdb.CheckZoneDeletion(dbzone, music.TwoPersonIdentity(apiUser(r), zp.Identity))
+				if err == nil {
+					resp.Msg, err = mdb.DeleteZone(dbzone)
+				}
 				if err != nil {
 					// log.Printf("Error from DeleteZone: %v", err)
 					resp.Error = true
@@ -269,6 +287,13 @@ func APIzone(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 					resp.ErrorMsg = err.Error()
 				}
 
+			case "adopt":
+				resp.Msg, err = mdb.ZoneAdopt(nil, dbzone, zp.SignerGroup)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
 			case "leave":
 				resp.Msg, err = mdb.ZoneLeaveGroup(nil, dbzone, zp.SignerGroup)
 				if err != nil {
@@ -280,20 +305,36 @@ func APIzone(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 			// XXX: A single zone cannot "choose" to join an FSM, it's the Group that does that.
 			//      This endpoint is only here for development and debugging reasons.
 			case "fsm":
-				resp.Msg, err = mdb.ZoneAttachFsm(nil, dbzone, zp.FSM, zp.FSMSigner, false)
+				err = mdb.CheckZoneProcess(dbzone, zp.FSM, music.TwoPersonIdentity(apiUser(r), zp.Identity))
+				if err == nil {
+					resp.Msg, err = mdb.ZoneAttachFsm(nil, dbzone, zp.FSM, zp.FSMSigner, false)
+				}
 				if err != nil {
 					// log.Printf("Error from ZoneAttachFsm: %v", err)
 					resp.Error = true
 					resp.ErrorMsg = err.Error()
 				}
 
+			case "startprocess":
+				err = mdb.CheckZoneProcess(dbzone, zp.FSM, music.TwoPersonIdentity(apiUser(r), zp.Identity))
+				if err == nil {
+					resp.Msg, err = mdb.ZoneStartProcess(nil, dbzone, zp.FSM, zp.FSMSigner, zp.StartAt,
+						zp.Params)
+				}
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
 			case "step-fsm":
 				// var zones map[string]music.Zone
 				// var success bool
 				// err, resp.Msg, zones = mdb.ZoneStepFsm(nil, dbzone, zp.FsmNextState)
 				// log.Printf("APISERVER: STEP-FSM: Calling ZoneStepFsm for zone %s and %v\n", dbzone.Name, zp.FsmNextState)
 				var success bool
+				interactive := music.MarkInteractive(dbzone.Name) // ahead of PushZones
 				success, resp.Msg, err = mdb.ZoneStepFsm(nil, dbzone, zp.FsmNextState)
+				interactive()
 				if err != nil {
 					log.Printf("APISERVER: Error from ZoneStepFsm: %v", err)
 					resp.Error = true
@@ -325,7 +366,9 @@ func APIzone(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 
 			case "get-rrsets":
 				// var rrsets map[string][]dns.RR
+				interactive := music.MarkInteractive(dbzone.Name)
 				err, msg, _ := mdb.ZoneGetRRsets(dbzone, zp.Owner, zp.RRtype)
+				interactive()
 				resp.Msg = msg
 				if err != nil {
 					// log.Printf("Error from ZoneGetRRset: %v", err)
@@ -360,8 +403,10 @@ func APIzone(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 				fmt.Printf("APIzone: copy-rrset: %s %s %s\n", dbzone.Name,
 					zp.Owner, zp.RRtype)
 				// var rrset []dns.RR
+				interactive := music.MarkInteractive(dbzone.Name)
 				err, resp.Msg = mdb.ZoneCopyRRset(nil, dbzone, zp.Owner, zp.RRtype,
 					zp.FromSigner, zp.ToSigner)
+				interactive()
 				if err != nil {
 					log.Printf("Error from ZoneCopyRRset: %v", err)
 					resp.Error = true
@@ -393,9 +438,246 @@ func APIzone(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 				}
 				return
 
+			case "contact":
+				if zp.Contact.Email == "" && zp.Contact.Webhook == "" && len(zp.ContactClear) == 0 {
+					zc, err := mdb.GetZoneContact(nil, dbzone)
+					if err != nil {
+						resp.Error = true
+						resp.ErrorMsg = err.Error()
+					} else {
+						resp.Msg = fmt.Sprintf("Zone %s contact: email: '%s' webhook: '%s'",
+							dbzone.Name, zc.Email, zc.Webhook)
+					}
+					break
+				}
+				resp.Msg, err = mdb.ZoneSetContact(nil, dbzone, zp.Contact, zp.ContactClear)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "desired-signers":
+				resp.Msg, err = mdb.ZoneSetDesiredSigners(nil, dbzone, zp.Signers)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "reconcile":
+				resp.Msg, err = mdb.ReconcileZone(nil, dbzone, enginecheck)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "rename":
+				resp.Msg, err = mdb.RenameZone(nil, dbzone, zp.NewName)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "freeze":
+				resp.Msg, err = mdb.ZoneFreeze(nil, dbzone, zp.Reason)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "unfreeze":
+				resp.Msg, err = mdb.ZoneUnfreeze(nil, dbzone)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "throttle":
+				resp.Msg, err = mdb.ZoneSetThrottleClass(nil, dbzone, zp.Throttle)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "approve", "deny":
+				approver := apiUser(r)
+				if approver == "" {
+					approver = zp.Approver
+				}
+				resp.Msg, err = mdb.ZoneDecideApproval(nil, dbzone, zp.Approval, approver,
+					zp.Command == "approve")
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "external-ns":
+				resp.Msg, err = mdb.ZoneSetExternalNSes(nil, dbzone, zp.NSes)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "nses":
+				resp.NSes, err = mdb.ListZoneNSes(nil, dbzone)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "discover":
+				resp.Discovery, err = mdb.DiscoverSigners(dbzone.Name, zp.Create)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				} else {
+					resp.Msg = resp.Discovery.Msg
+				}
+
+			case "managed-names":
+				if zp.Owner != "" {
+					resp.Msg, err = mdb.ZoneSetManagedName(nil, dbzone, zp.Owner, zp.RRtype, zp.Remove)
+					if err != nil {
+						resp.Error = true
+						resp.ErrorMsg = err.Error()
+						break
+					}
+				}
+				resp.ManagedNames, err = mdb.GetManagedNames(nil, dbzone.Name)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+				if zp.Owner == "" && !resp.Error {
+					resp.SpecialNames, err = dbzone.SpecialRRsets()
+					if err != nil {
+						resp.Error = true
+						resp.ErrorMsg = err.Error()
+					}
+				}
+
+			case "children":
+				if zp.Owner != "" {
+					resp.Msg, err = mdb.ZoneSetChild(nil, dbzone, zp.Owner, zp.Remove)
+					if err != nil {
+						resp.Error = true
+						resp.ErrorMsg = err.Error()
+						break
+					}
+				}
+				if zp.Scan {
+					msgs, err := mdb.ScanChildren(dbzone.Name)
+					if err != nil {
+						resp.Error = true
+						resp.ErrorMsg = err.Error()
+						break
+					}
+					resp.Msg = strings.Join(msgs, "\n")
+				}
+				resp.Children, err = mdb.ListChildren(nil, dbzone.Name)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "updates":
+				if zp.Limit <= 0 {
+					zp.Limit = 25
+				}
+				resp.Updates, err = mdb.ListUpdateHistory(nil, dbzone.Name, zp.Limit)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "metrics":
+				if zp.Limit <= 0 {
+					zp.Limit = 25
+				}
+				resp.Metrics, err = mdb.ListProcessMetrics(nil, dbzone.Name, zp.Limit)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "explain":
+				resp.Explanation, err = mdb.ExplainZone(nil, dbzone)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "cleanup":
+				msgs, err := mdb.CleanupPublished(dbzone.Name)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				} else if len(msgs) == 0 {
+					resp.Msg = fmt.Sprintf("Zone %s: no orphaned records to clean up.", dbzone.Name)
+				} else {
+					resp.Msg = strings.Join(msgs, "\n")
+				}
+
+			case "measurements":
+				resp.Measurements, err = mdb.ListAtlasMeasurements(dbzone)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "evidence":
+				if zp.Run == 0 {
+					resp.Evidence, err = mdb.ListEvidenceRuns(nil, dbzone)
+					if err == nil {
+						resp.Annotations, err = mdb.ListZoneAnnotations(nil, dbzone.Name, 0)
+					}
+				} else {
+					resp.Bundle, err = mdb.GetEvidenceBundle(nil, dbzone, zp.Run)
+				}
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "annotate":
+				author := music.TwoPersonIdentity(apiUser(r), zp.Identity)
+				resp.Msg, err = mdb.ZoneAnnotate(nil, dbzone, zp.Run, author, zp.Annotation)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "annotations":
+				if zp.Remove {
+					resp.Msg, err = mdb.ZoneRemoveAnnotation(nil, dbzone, zp.AnnotationID)
+				} else {
+					resp.Annotations, err = mdb.ListZoneAnnotations(nil, dbzone.Name, zp.Run)
+				}
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "approvals":
+				resp.Approvals, err = mdb.ListZoneApprovals(nil, dbzone)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
+			case "scorecard":
+				resp.Scorecard, err = mdb.ZoneScorecard(dbzone)
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+				}
+
 			case "meta":
 				dbzone.ZoneType = zp.Zone.ZoneType
-				resp.Msg, err = mdb.ZoneSetMeta(nil, dbzone, zp.Metakey, zp.Metavalue)
+				err = mdb.CheckZoneMeta(dbzone, zp.Metakey, zp.Metavalue,
+					music.TwoPersonIdentity(apiUser(r), zp.Identity))
+				if err == nil {
+					resp.Msg, err = mdb.ZoneSetMeta(nil, dbzone, zp.Metakey, zp.Metavalue)
+				}
 				if err != nil {
 					// log.Printf("Error from ZoneSetMeta: %v", err)
 					resp.Error = true
@@ -451,11 +733,38 @@ func APIsigner(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 			resp.Signers = ss
 
 		case "add":
+			var tmpl music.SignerTemplate
+			if sp.Template != "" {
+				tmpl, err = music.GetSignerTemplate(sp.Template)
+				if err == nil {
+					err = tmpl.Apply(dbsigner)
+				}
+				if err != nil {
+					resp.Error = true
+					resp.ErrorMsg = err.Error()
+					break
+				}
+			}
 			resp.Msg, err = mdb.AddSigner(nil, dbsigner, sp.SignerGroup)
 			if err != nil {
 				// log.Printf("Error from AddSigner: %v", err)
 				resp.Error = true
 				resp.ErrorMsg = err.Error()
+				break
+			}
+			if tmpl.MaxZones > 0 {
+				dbsigner, _ = mdb.GetSigner(nil, dbsigner, false)
+				if _, err := mdb.SignerSetMaxZones(nil, dbsigner, tmpl.MaxZones); err != nil {
+					resp.Msg += fmt.Sprintf(" Unable to set the concurrency limit: %v", err)
+				}
+			}
+			for _, q := range tmpl.Quirks {
+				resp.Msg += fmt.Sprintf("\nNote (%s): %s", tmpl.Name, q)
+			}
+
+		case "templates":
+			for _, name := range music.ListSignerTemplates() {
+				resp.Templates = append(resp.Templates, music.SignerTemplates[name])
 			}
 
 		case "update":
@@ -474,6 +783,89 @@ func APIsigner(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 				resp.ErrorMsg = err.Error()
 			}
 
+		case "verify":
+			resp.Verification, err = mdb.VerifySigner(nil, dbsigner, sp.TestZone)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			} else if resp.Verification.Verified {
+				resp.Msg = fmt.Sprintf("Signer %s verified against test zone %s.",
+					dbsigner.Name, resp.Verification.TestZone)
+			} else {
+				resp.Msg = fmt.Sprintf("Signer %s FAILED verification against test zone %s.",
+					dbsigner.Name, resp.Verification.TestZone)
+			}
+
+		case "set-limit":
+			resp.Msg, err = mdb.SignerSetMaxZones(nil, dbsigner, sp.MaxZones)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "set-proxy":
+			resp.Msg, err = mdb.SignerSetProxy(nil, dbsigner, sp.Proxy, sp.SSHKey)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "set-include":
+			resp.Msg, err = mdb.SignerSetInclude(nil, dbsigner, sp.Include)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "set-anycast":
+			resp.Msg, err = mdb.SignerSetAnycast(nil, dbsigner, sp.Anycast)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "set-tls":
+			resp.Msg, err = mdb.SignerSetTLS(nil, dbsigner, sp.TLS)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "set-transport":
+			resp.Msg, err = mdb.SignerSetTransport(nil, dbsigner, sp.Transport, sp.DoHURL)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "set-sig0":
+			resp.Msg, err = mdb.SignerSetSIG0(nil, dbsigner, sp.SIG0)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "set-notify":
+			resp.Msg, err = mdb.SignerSetNotify(nil, dbsigner, sp.NotifyTargets)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "set-addresses":
+			resp.Msg, err = mdb.SignerSetAddresses(nil, dbsigner, sp.Addresses)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "set-token":
+			resp.Msg, err = mdb.SignerSetZoneCredential(nil, dbsigner, sp.Zone, sp.Token)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
 		case "join":
 			resp.Msg, err = mdb.SignerJoinGroup(nil, dbsigner, sp.Signer.SignerGroup)
 			if err != nil {
@@ -483,7 +875,11 @@ func APIsigner(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 			}
 
 		case "leave":
-			resp.Msg, err = mdb.SignerLeaveGroup(nil, dbsigner, sp.Signer.SignerGroup)
+			err = mdb.CheckSignerRemoval(sp.Signer.SignerGroup, dbsigner.Name,
+				music.TwoPersonIdentity(apiUser(r), sp.Identity))
+			if err == nil {
+				resp.Msg, err = mdb.SignerLeaveGroup(nil, dbsigner, sp.Signer.SignerGroup)
+			}
 			if err != nil {
 				// log.Printf("Error from SignerLeaveGroup: %v", err)
 				resp.Error = true
@@ -504,6 +900,48 @@ func APIsigner(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 				resp.ErrorMsg = err.Error()
 			}
 
+		case "rotate-tsig":
+			resp.Msg, err = mdb.SignerRotateTSIG(nil, dbsigner, sp.NewAuth, sp.Notify)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "update-auth":
+			resp.Msg, err = mdb.SignerBulkUpdateAuth(nil, sp.Match, sp.NewAuth)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "retire-tsig":
+			resp.Msg, err = mdb.SignerRetireTSIG(nil, dbsigner)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "tsig-status":
+			resp.Msg, err = mdb.SignerTsigStatus(nil, dbsigner)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "add-view":
+			resp.Msg, err = mdb.SignerAddView(nil, dbsigner, sp.View)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "delete-view":
+			resp.Msg, err = mdb.SignerDeleteView(nil, dbsigner, sp.View.Name)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
 		default:
 		}
 
@@ -558,11 +996,39 @@ func APIsignergroup(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 			resp.Message = msg
 
 		case "delete":
+			err := mdb.CheckSignerGroupDeletion(sgp.Name, music.TwoPersonIdentity(apiUser(r), sgp.Identity))
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+				break
+			}
 			msg, err := mdb.DeleteSignerGroup(nil, sgp.Name)
 			if err != nil {
 				log.Printf("Error from DeleteSignerGroup: %v", err)
 			}
 			resp.Message = msg
+
+		case "snapshots":
+			resp.Snapshots, err = mdb.ListSignerGroupSnapshots(nil, sgp.Name)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "diff":
+			resp.Diff, err = mdb.DiffSignerGroupSnapshots(nil, sgp.Name, sgp.Snapshot, sgp.To)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
+		case "rollback":
+			resp.Message, err = mdb.RollbackSignerGroup(nil, sgp.Name, sgp.Snapshot)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
 		default:
 
 		}
@@ -581,6 +1047,183 @@ func APIsignergroup(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func APIpolicy(conf *Config) func(w http.ResponseWriter, r *http.Request) {
+	mdb := conf.Internal.MusicDB
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		decoder := json.NewDecoder(r.Body)
+		var pp music.PolicyPost
+		err := decoder.Decode(&pp)
+		if err != nil {
+			log.Println("APIpolicy: error decoding policy post:", err)
+		}
+
+		log.Printf("APIpolicy: received /policy request (command: %s) from %s.\n",
+			pp.Command, r.RemoteAddr)
+
+		var resp = music.PolicyResponse{
+			Time:   time.Now(),
+			Client: r.RemoteAddr,
+		}
+
+		switch pp.Command {
+		case "list":
+			resp.Policies, err = mdb.ListPolicies(nil)
+
+		case "add":
+			resp.Msg, err = mdb.AddPolicy(nil, pp.Policy)
+
+		case "delete":
+			resp.Msg, err = mdb.DeletePolicy(nil, pp.Policy.Name)
+
+		case "assign":
+			if pp.Zone != "" {
+				var zname string
+				zname, err = music.CanonicalZoneName(pp.Zone)
+				if err == nil {
+					resp.Msg, err = mdb.AssignPolicy(nil, "zone", zname, pp.Policy.Name)
+				}
+			} else {
+				resp.Msg, err = mdb.AssignPolicy(nil, "signergroup", pp.SignerGroup,
+					pp.Policy.Name)
+			}
+
+		default:
+			err = fmt.Errorf("Unknown policy command: %s", pp.Command)
+		}
+
+		if err != nil {
+			resp.Error = true
+			resp.ErrorMsg = err.Error()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Printf("Error from Encoder: %v\n", err)
+		}
+	}
+}
+
+func APIparent(conf *Config) func(w http.ResponseWriter, r *http.Request) {
+	mdb := conf.Internal.MusicDB
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		decoder := json.NewDecoder(r.Body)
+		var pp music.ParentPost
+		err := decoder.Decode(&pp)
+		if err != nil {
+			log.Println("APIparent: error decoding parent post:", err)
+		}
+
+		log.Printf("APIparent: received /parent request (command: %s) from %s.\n",
+			pp.Command, r.RemoteAddr)
+
+		var resp = music.ParentResponse{
+			Time:   time.Now(),
+			Client: r.RemoteAddr,
+		}
+
+		switch pp.Command {
+		case "list":
+			resp.Profiles, resp.Probes, err = mdb.ListParentProfiles(nil)
+
+		case "probe":
+			var zname string
+			zname, err = music.CanonicalZoneName(pp.Zone)
+			if err == nil {
+				var dbzone *music.Zone
+				dbzone, _, err = mdb.GetZone(nil, zname)
+				if err == nil {
+					resp.Msg, err = mdb.StartParentProbe(nil, dbzone, pp.Kind)
+				}
+			}
+
+		default:
+			err = fmt.Errorf("Unknown parent command: %s", pp.Command)
+		}
+
+		if err != nil {
+			resp.Error = true
+			resp.ErrorMsg = err.Error()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Printf("Error from Encoder: %v\n", err)
+		}
+	}
+}
+
+func APIupsert(conf *Config) func(w http.ResponseWriter, r *http.Request) {
+	mdb := conf.Internal.MusicDB
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		decoder := json.NewDecoder(r.Body)
+		var up music.UpsertPost
+		err := decoder.Decode(&up)
+		if err != nil {
+			log.Println("APIupsert: error decoding upsert post:", err)
+		}
+
+		log.Printf("APIupsert: received /upsert request (%s %s, generation %d) from %s.\n",
+			up.Kind, up.Name, up.Generation, r.RemoteAddr)
+
+		resp := mdb.Upsert(up, conf.Internal.EngineCheck)
+		resp.Time = time.Now()
+		resp.Client = r.RemoteAddr
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Printf("Error from Encoder: %v\n", err)
+		}
+	}
+}
+
+func APIgitops(conf *Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		decoder := json.NewDecoder(r.Body)
+		var gp music.GitOpsPost
+		err := decoder.Decode(&gp)
+		if err != nil {
+			log.Println("APIgitops: error decoding gitops post:", err)
+		}
+
+		log.Printf("APIgitops: received /gitops request (command: %s) from %s.\n",
+			gp.Command, r.RemoteAddr)
+
+		var resp = music.GitOpsResponse{
+			Time:   time.Now(),
+			Client: r.RemoteAddr,
+		}
+
+		switch gp.Command {
+		case "plan":
+			resp.Output, err = GitOpsRun(conf, false)
+			resp.Msg = fmt.Sprintf("Plan for %s:", viper.GetString("gitops.dir"))
+		case "apply":
+			resp.Output, err = GitOpsRun(conf, true)
+			resp.Msg = fmt.Sprintf("Applied definitions from %s:", viper.GetString("gitops.dir"))
+		default:
+			err = fmt.Errorf("Unknown gitops command: %s", gp.Command)
+		}
+
+		if err != nil {
+			resp.Error = true
+			resp.ErrorMsg = err.Error()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Printf("Error from Encoder: %v\n", err)
+		}
+	}
+}
+
 func APIprocess(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 	mdb := conf.Internal.MusicDB
 	var check music.EngineCheck
@@ -626,6 +1269,30 @@ func APIprocess(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 			}
 			resp.Graph = graph
 
+		case "pause", "resume":
+			target := pp.Process
+			if pp.Scope == music.PauseSigner {
+				target = pp.Signer
+			}
+			var msg string
+			if pp.Command == "pause" {
+				msg, err = mdb.PauseEngine(nil, pp.Scope, target, pp.Reason)
+			} else {
+				msg, err = mdb.ResumeEngine(nil, pp.Scope, target)
+			}
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+			resp.Msg = msg
+
+		case "paused":
+			resp.Pauses, err = mdb.ListEnginePauses(nil)
+			if err != nil {
+				resp.Error = true
+				resp.ErrorMsg = err.Error()
+			}
+
 		default:
 
 		}
@@ -639,7 +1306,7 @@ func APIprocess(conf *Config) func(w http.ResponseWriter, r *http.Request) {
 }
 
 func APIshow(conf *Config, router *mux.Router) func(w http.ResponseWriter, r *http.Request) {
-	address := viper.GetString("services.apiserver.api")
+	address := viper.GetString("apiserver.address")
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		decoder := json.NewDecoder(r.Body)
@@ -678,9 +1345,140 @@ func APIshow(conf *Config, router *mux.Router) func(w http.ResponseWriter, r *ht
 			resp.Message = message
 			resp.ApiData = data
 
+		case "pending-ops":
+			resp.Message = "Operations waiting for a second person"
+			resp.PendingOps, err = conf.Internal.MusicDB.ListPendingOps(nil)
+			if err != nil {
+				resp.Message = err.Error()
+			}
+
 		case "updaters":
 			resp.Message = "Defined updaters"
 			resp.Updaters = music.ListUpdaters()
+			resp.Capabilities = map[string]music.UpdaterCapabilities{}
+			for u := range resp.Updaters {
+				resp.Capabilities[u] = music.Updaters[u].Capabilities()
+			}
+
+		case "dryrun":
+			resp.Message = "Changes not made due to dry-run mode"
+			resp.DryRunChanges, err = conf.Internal.MusicDB.ListDryRunChanges(nil, sp.Zone)
+			if err != nil {
+				resp.Message = err.Error()
+			} else if sp.Clear && len(resp.DryRunChanges) > 0 {
+				upto := resp.DryRunChanges[len(resp.DryRunChanges)-1].ID
+				n, err := conf.Internal.MusicDB.ClearDryRunChanges(nil, sp.Zone, upto)
+				if err != nil {
+					resp.Message = err.Error()
+				} else {
+					resp.Message = fmt.Sprintf("%d proposed changes reviewed and cleared", n)
+				}
+			}
+
+		case "propagation":
+			resp.Propagation, err = conf.Internal.MusicDB.ListPropagationStats(nil, sp.Zone,
+				sp.PerZone)
+			if err != nil {
+				resp.Message = err.Error()
+			} else {
+				resp.Message = "Propagation times per signer (last week)"
+			}
+
+		case "observer":
+			resp.Message = "Latest observations (observer mode)"
+			if !music.ObserverMode() {
+				resp.Message = "Observer mode is not active"
+			}
+			resp.Observations = music.ListObservations(sp.Zone)
+
+		case "validation":
+			resp.Message = "Latest validation results (lab validator)"
+			if !music.LabValidatorActive() {
+				resp.Message = "The lab validator is not active"
+			}
+			resp.Validations = music.ListValidations(sp.Zone)
+
+		case "breakers":
+			resp.Message = "Signer error rates and circuit breakers"
+			resp.SignerHealth = music.ListSignerHealth()
+
+		case "tasks":
+			resp.Message = "Scheduled tasks"
+			tasks, err := conf.Internal.MusicDB.ListScheduledTasks(nil, sp.Zone)
+			if err != nil {
+				resp.Message = err.Error()
+			}
+			resp.Tasks = tasks
+
+		case "ops":
+			resp.Message = "Signer operations in flight"
+			resp.Ops = music.ListInFlightOps()
+
+		case "queues":
+			resp.Message = "Signer op queues of the rate-limiting managers"
+			resp.Queues = music.ListOpQueueStats()
+
+		case "preconditions":
+			resp.Message = "Failed pre-conditions not evaluated again until the data observed expires"
+			if !viper.GetBool("fsmengine.precondcache.active") {
+				resp.Message = "The pre-condition cache is not active"
+			}
+			resp.Preconditions = music.ListPreconditionChecks()
+
+		case "shards":
+			resp.Message = "musicd instances sharing the DB, with the zones each one runs"
+			if !music.ShardingActive() {
+				resp.Message = "Sharding is not active"
+			}
+			resp.Shards, err = conf.Internal.MusicDB.ShardStatus()
+			if err != nil {
+				resp.Message = err.Error()
+			}
+
+		case "requests":
+			resp.Message = "Latest requests to the signer backends"
+			resp.Requests = music.ListBackendRequests(sp.Backend, sp.Since)
+
+		case "backpressure":
+			bs := music.GetBackpressure()
+			resp.Message = "Aggregate error rate of signers and parents"
+			if !viper.GetBool("backpressure.active") {
+				resp.Message = "Backpressure is not active"
+			}
+			resp.Backpressure = &bs
+
+		case "keys":
+			mdb := conf.Internal.MusicDB
+			resp.Message = "DNSKEYs per zone and signer"
+			if sp.Scan {
+				n, err := mdb.ScanKeyInventory()
+				if err != nil {
+					resp.Message = err.Error()
+					break
+				}
+				resp.Message = fmt.Sprintf("DNSKEYs per zone and signer (%d zones scanned)", n)
+			}
+			zone := sp.Zone
+			if zone != "" {
+				zone = dns.Fqdn(zone)
+			}
+			resp.Keys, err = mdb.ListKeyInventory(music.KeyInventoryFilter{
+				Zone:      zone,
+				Signer:    sp.Signer,
+				Algorithm: sp.Algorithm,
+				All:       sp.All,
+			})
+			if err != nil {
+				resp.Message = err.Error()
+			}
+
+		case "state":
+			resp.State, err = conf.Internal.MusicDB.ExportState(sp.Probe)
+			if err != nil {
+				resp.Message = err.Error()
+			} else {
+				resp.Message = "Current state of zones and signers"
+			}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -691,18 +1489,113 @@ func APIshow(conf *Config, router *mux.Router) func(w http.ResponseWriter, r *ht
 	}
 }
 
+// APIauth accepts requests that either carry the API key or, if apiserver.oidc.issuer
+// is configured, a valid OIDC access token from that issuer (as obtained by "music-cli login").
+func APIauth(conf *Config) mux.MiddlewareFunc {
+	apikey := viper.GetString("apiserver.apikey")
+	var verifier *music.OIDCVerifier
+	if issuer := viper.GetString("apiserver.oidc.issuer"); issuer != "" {
+		verifier = music.NewOIDCVerifier(issuer, viper.GetString("apiserver.oidc.audience"))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := r.Header.Get("X-API-Key"); key != "" && key == apikey {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authz := r.Header.Get("Authorization")
+			if verifier != nil && strings.HasPrefix(authz, "Bearer ") {
+				user, err := verifier.Verify(strings.TrimPrefix(authz, "Bearer "))
+				if err == nil {
+					log.Printf("APIauth: %s %s by OIDC user %s", r.Method, r.URL.Path, user)
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiUserKey{}, user)))
+					return
+				}
+				log.Printf("APIauth: rejected OIDC token from %s: %v", r.RemoteAddr, err)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		})
+	}
+}
+
+type apiUserKey struct{}
+
+// apiUser returns the OIDC user of the request, or "" for API key clients.
+func apiUser(r *http.Request) string {
+	user, _ := r.Context().Value(apiUserKey{}).(string)
+	return user
+}
+
+// APIapprovelink handles the signed approval links (see music/approvals.go). The link
+// itself is the credential, so this endpoint is outside the authenticated API. A GET
+// only shows the request, so that link scanners in mail systems can't approve anything.
+func APIapprovelink(conf *Config) func(w http.ResponseWriter, r *http.Request) {
+	mdb := conf.Internal.MusicDB
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		token := r.FormValue("token")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		if r.Method == "GET" {
+			fmt.Fprintf(w, `<html><body><form method="POST">
+<input type="hidden" name="id" value="%d"><input type="hidden" name="token" value="%s">
+<p>MuSiC approval %d</p>
+<button name="decision" value="approve">Approve</button>
+<button name="decision" value="deny">Deny</button>
+</form></body></html>`, id, html.EscapeString(token), id)
+			return
+		}
+
+		msg, err := mdb.DecideApprovalByLink(id, token, r.FormValue("decision") == "approve")
+		if err != nil {
+			log.Printf("APIapprovelink: approval %d from %s: %v", id, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusForbidden)
+			msg = err.Error()
+		}
+		fmt.Fprintf(w, "<html><body><p>%s</p></body></html>\n", html.EscapeString(msg))
+	}
+}
+
+// APIrecoverer reports panics in the API handlers and returns an error to the client
+// instead of dropping the connection.
+func APIrecoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("APIrecoverer: panic in handler for %s: %v", r.URL.Path, p)
+				music.ReportPanic("apiserver", p, map[string]string{"path": r.URL.Path})
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
 func SetupRouter(conf *Config) *mux.Router {
 	r := mux.NewRouter().StrictSlash(true)
 	r.HandleFunc("/", homeLink)
+	if viper.GetBool("metrics.active") {
+		r.HandleFunc("/metrics", APImetrics(conf)).Methods("GET")
+	}
+	if viper.GetString("approvals.secret") != "" {
+		r.HandleFunc("/approve", APIapprovelink(conf)).Methods("GET", "POST")
+	}
 
-	sr := r.PathPrefix("/api/v1").Headers("X-API-Key",
-		viper.GetString("apiserver.apikey")).Subrouter()
+	sr := r.PathPrefix("/api/v1").Subrouter()
+	sr.Use(APIrecoverer)
+	sr.Use(APIauth(conf))
 	sr.HandleFunc("/ping", APIping(conf)).Methods("POST")
 	sr.HandleFunc("/signer", APIsigner(conf)).Methods("POST")
 	sr.HandleFunc("/zone", APIzone(conf)).Methods("POST")
 	sr.HandleFunc("/signergroup", APIsignergroup(conf)).Methods("POST")
 	sr.HandleFunc("/test", APItest(conf)).Methods("POST")
 	sr.HandleFunc("/process", APIprocess(conf)).Methods("POST")
+	sr.HandleFunc("/policy", APIpolicy(conf)).Methods("POST")
+	sr.HandleFunc("/parent", APIparent(conf)).Methods("POST")
+	sr.HandleFunc("/gitops", APIgitops(conf)).Methods("POST")
+	sr.HandleFunc("/upsert", APIupsert(conf)).Methods("POST")
 	sr.HandleFunc("/show", APIshow(conf, r)).Methods("POST")
 
 	return r
@@ -733,12 +1626,15 @@ func walkRoutes(router *mux.Router, address string) {
 func APIdispatcher(conf *Config) error {
 	router := SetupRouter(conf)
 	address := viper.GetString("apiserver.address")
-	certFile := viper.GetString("apiserver.certFile")
-	keyFile := viper.GetString("apiserver.keyFile")
 
 	if address != "" {
+		tlsconf, err := apiTLSConfig(nil)
+		if err != nil {
+			log.Fatalf("API dispatcher: %v", err)
+		}
+		server := &http.Server{Addr: address, Handler: router, TLSConfig: tlsconf}
 		log.Println("Starting API dispatcher. Listening on", address)
-		log.Fatal(http.ListenAndServeTLS(address, certFile, keyFile, router))
+		log.Fatal(server.ListenAndServeTLS("", ""))
 	}
 
 	log.Println("API dispatcher: unclear how to stop the http server nicely.")
@@ -0,0 +1,50 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ChildScanner periodically updates the DS RRsets of the children registered with the
+// zones that MUSIC manages as parent, from the CDS RRsets of the children (see
+// music/childds.go).
+func ChildScanner(conf *Config, stopch chan struct{}) {
+	mdb := conf.Internal.MusicDB
+
+	if !viper.GetBool("childds.active") {
+		log.Printf("ChildScanner is NOT active. Child DS RRsets are only updated by 'zone children --scan'.")
+		return
+	}
+
+	interval := viper.GetInt("childds.interval")
+	if interval < 5 {
+		interval = 5
+	}
+	log.Printf("Starting ChildScanner (will run once every %d minutes)", interval)
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+
+	for {
+		select {
+		case <-ticker.C:
+			msgs, err := mdb.ScanChildren("")
+			if err != nil {
+				log.Printf("ChildScanner: Error from ScanChildren: %v", err)
+			}
+			for _, msg := range msgs {
+				log.Printf("ChildScanner: %s", msg)
+			}
+
+		case <-stopch:
+			ticker.Stop()
+			log.Println("ChildScanner: stop signal received.")
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Cleaner periodically removes the CDS, CDNSKEY and CSYNC records that MUSIC published
+// but that are no longer needed, e.g. because the process was aborted or the zone was
+// removed (see music/published.go).
+func Cleaner(conf *Config, stopch chan struct{}) {
+	mdb := conf.Internal.MusicDB
+
+	if !viper.GetBool("cleanup.active") {
+		log.Printf("Cleaner is NOT active. Orphaned records are only removed by 'zone cleanup'.")
+		return
+	}
+
+	interval := viper.GetInt("cleanup.interval")
+	if interval < 5 {
+		interval = 5
+	}
+	log.Printf("Starting Cleaner (will run once every %d minutes)", interval)
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+
+	for {
+		select {
+		case <-ticker.C:
+			msgs, err := mdb.CleanupPublished("")
+			if err != nil {
+				log.Printf("Cleaner: Error from CleanupPublished: %v", err)
+			}
+			for _, msg := range msgs {
+				log.Printf("Cleaner: %s", msg)
+			}
+
+		case <-stopch:
+			ticker.Stop()
+			log.Println("Cleaner: stop signal received.")
+			return
+		}
+	}
+}
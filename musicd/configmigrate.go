@@ -0,0 +1,201 @@
+/*
+ * Johan Stenstam, johan.stenstam@internetstiftelsen.se
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// Config migration. When a config key is renamed or moved, the old key is added to
+// configRenames below. On startup the old key is still honoured (with a warning), and
+// "musicd config migrate" rewrites an old config file in the current layout and reports
+// the settings that can not be mapped, so that an upgrade does not require every
+// operator to hand-edit the config. Keys that no longer have any meaning go into
+// configObsolete.
+
+var configRenames = []struct{ old, new string }{
+	{"services.apiserver.api", "apiserver.address"},
+	{"fsmengine.mininterval", "fsmengine.intervals.minimum"},
+	{"fsmengine.maxinterval", "fsmengine.intervals.maximum"},
+}
+
+var configObsolete = []struct{ key, why string }{
+	{"apiserver.usetls", "the API is always served over TLS"},
+	{"signers", "signers are no longer defined in the config; add them with 'music-cli signer add'"},
+}
+
+// ApplyConfigRenames makes the settings under renamed keys available under their new
+// names, unless the new key is set as well. It returns a warning per old key in use.
+func ApplyConfigRenames(v *viper.Viper) []string {
+	var warnings []string
+	for _, r := range configRenames {
+		if !v.IsSet(r.old) {
+			continue
+		}
+		if !v.IsSet(r.new) {
+			v.Set(r.new, v.Get(r.old))
+		}
+		warnings = append(warnings, fmt.Sprintf("config key %s is deprecated, use %s (see 'musicd config migrate')",
+			r.old, r.new))
+	}
+	return warnings
+}
+
+// obsoleteSetting returns why the setting is obsolete, if it is. Only the list form of
+// "signers" (from old versions) is obsolete, not the signers section.
+func obsoleteSetting(settings map[string]interface{}, key string) (string, bool) {
+	for _, o := range configObsolete {
+		if o.key != key {
+			continue
+		}
+		val, ok := getSetting(settings, key)
+		if !ok {
+			return "", false
+		}
+		if _, ismap := val.(map[string]interface{}); ismap && key == "signers" {
+			return "", false
+		}
+		return o.why, true
+	}
+	return "", false
+}
+
+func getSetting(settings map[string]interface{}, key string) (interface{}, bool) {
+	parts := strings.Split(key, ".")
+	m := settings
+	for i, p := range parts {
+		val, ok := m[p]
+		if !ok {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return val, true
+		}
+		if m, ok = val.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func setSetting(settings map[string]interface{}, key string, val interface{}) {
+	parts := strings.Split(key, ".")
+	m := settings
+	for _, p := range parts[:len(parts)-1] {
+		sub, ok := m[p].(map[string]interface{})
+		if !ok {
+			sub = map[string]interface{}{}
+			m[p] = sub
+		}
+		m = sub
+	}
+	m[parts[len(parts)-1]] = val
+}
+
+// deleteSetting removes the key, and any sections that become empty.
+func deleteSetting(settings map[string]interface{}, key string) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) == 1 {
+		delete(settings, key)
+		return
+	}
+	sub, ok := settings[parts[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deleteSetting(sub, parts[1])
+	if len(sub) == 0 {
+		delete(settings, parts[0])
+	}
+}
+
+// MigrateSettings moves the renamed settings to their new keys and drops the obsolete
+// ones. It returns what was done and the settings that could not be mapped.
+func MigrateSettings(settings map[string]interface{}) (changes, unmapped []string) {
+	for _, r := range configRenames {
+		val, ok := getSetting(settings, r.old)
+		if !ok {
+			continue
+		}
+		if cur, exists := getSetting(settings, r.new); exists {
+			unmapped = append(unmapped, fmt.Sprintf("%s: not moved to %s, which is already set (to %v)",
+				r.old, r.new, cur))
+		} else {
+			setSetting(settings, r.new, val)
+			changes = append(changes, fmt.Sprintf("%s -> %s", r.old, r.new))
+		}
+		deleteSetting(settings, r.old)
+	}
+	for _, o := range configObsolete {
+		why, ok := obsoleteSetting(settings, o.key)
+		if !ok {
+			continue
+		}
+		val, _ := getSetting(settings, o.key)
+		unmapped = append(unmapped, fmt.Sprintf("%s: removed, %s. Old value: %v", o.key, why, val))
+		deleteSetting(settings, o.key)
+	}
+	sort.Strings(changes)
+	return changes, unmapped
+}
+
+// ConfigMigrate implements "musicd config migrate [-in file] [-out file]".
+func ConfigMigrate(args []string) int {
+	fs := flag.NewFlagSet("config migrate", flag.ExitOnError)
+	in := fs.String("in", DefaultCfgFile, "config file to migrate")
+	out := fs.String("out", "", "file to write the migrated config to (default: <in>.migrated, - = stdout)")
+	fs.Parse(args)
+	if *out == "" {
+		*out = *in + ".migrated"
+	}
+
+	v := viper.New()
+	v.SetConfigFile(*in)
+	if err := v.ReadInConfig(); err != nil {
+		log.Printf("config migrate: %v", err)
+		return 1
+	}
+	settings := v.AllSettings()
+	changes, unmapped := MigrateSettings(settings)
+
+	buf, err := yaml.Marshal(settings)
+	if err != nil {
+		log.Printf("config migrate: %v", err)
+		return 1
+	}
+	if *out == "-" {
+		os.Stdout.Write(buf)
+	} else if err := ioutil.WriteFile(*out, buf, 0600); err != nil {
+		log.Printf("config migrate: %v", err)
+		return 1
+	}
+
+	report := os.Stderr
+	if len(changes) == 0 && len(unmapped) == 0 {
+		fmt.Fprintf(report, "%s already uses the current config layout.\n", *in)
+	}
+	for _, c := range changes {
+		fmt.Fprintf(report, "moved:    %s\n", c)
+	}
+	for _, u := range unmapped {
+		fmt.Fprintf(report, "unmapped: %s\n", u)
+	}
+	if *out != "-" {
+		fmt.Fprintf(report, "Migrated config written to %s (note: comments are not preserved).\n", *out)
+	}
+	if len(unmapped) > 0 {
+		return 2
+	}
+	return 0
+}
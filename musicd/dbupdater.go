@@ -84,13 +84,85 @@ func dbUpdater(conf *Config) {
 						return
 					}
 				}
+
+			case "PUBLISHED":
+				err := mdb.RecordPublished(tx, u)
+				if err != nil {
+					tx.Rollback()
+					if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrLocked {
+						log.Printf("RunDBQueue: PUBLISHED db locked. will try again. queue: %d",
+							len(queue))
+						return // let's try again later
+					}
+					log.Printf("RunDBQueue: PUBLISHED Error from RecordPublished: %v", err)
+					queue = queue[1:]
+					continue
+				}
+
+			case "ZONEID":
+				err := mdb.RecordZoneID(tx, u)
+				if err != nil {
+					tx.Rollback()
+					if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrLocked {
+						log.Printf("RunDBQueue: ZONEID db locked. will try again. queue: %d",
+							len(queue))
+						return // let's try again later
+					}
+					log.Printf("RunDBQueue: ZONEID Error from RecordZoneID: %v", err)
+					queue = queue[1:]
+					continue
+				}
+
+			case "UPDATEHISTORY":
+				err := mdb.RecordUpdateHistory(tx, u)
+				if err != nil {
+					tx.Rollback()
+					if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrLocked {
+						log.Printf("RunDBQueue: UPDATEHISTORY db locked. will try again. queue: %d",
+							len(queue))
+						return // let's try again later
+					}
+					log.Printf("RunDBQueue: UPDATEHISTORY Error from RecordUpdateHistory: %v", err)
+					queue = queue[1:]
+					continue
+				}
+
+			case "METRIC":
+				err := mdb.RecordProcessMetric(tx, u)
+				if err != nil {
+					tx.Rollback()
+					if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrLocked {
+						log.Printf("RunDBQueue: METRIC db locked. will try again. queue: %d",
+							len(queue))
+						return // let's try again later
+					}
+					log.Printf("RunDBQueue: METRIC Error from RecordProcessMetric: %v", err)
+					queue = queue[1:]
+					continue
+				}
+
+			case "SCHEDULE":
+				err := mdb.RecordScheduledTask(tx, u)
+				if err != nil {
+					tx.Rollback()
+					if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrLocked {
+						log.Printf("RunDBQueue: SCHEDULE db locked. will try again. queue: %d",
+							len(queue))
+						return // let's try again later
+					}
+					log.Printf("RunDBQueue: SCHEDULE Error from RecordScheduledTask: %v", err)
+					queue = queue[1:]
+					continue
+				}
 			}
 
 			err = tx.Commit()
 			if err != nil {
 				log.Printf("dbUpdater: RunQueue: Error from tx.Commit: %v", err)
 			} else {
-				log.Printf("dbUpdater: Updated zone %s stop-reason to '%s'", u.Zone, u.Value)
+				if t == "STOPREASON" {
+					log.Printf("dbUpdater: Updated zone %s stop-reason to '%s'", u.Zone, u.Value)
+				}
 				queue = queue[1:] // only drop item after successful commit
 			}
 		}
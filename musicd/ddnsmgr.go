@@ -4,163 +4,27 @@
 package main
 
 import (
-	"fmt"
 	"log"
-	//	"net/http"
-	"time"
-
-	"github.com/miekg/dns"
-	"github.com/spf13/viper"
 
 	"github.com/DNSSEC-Provisioning/music/music"
 )
 
-// According to https://desec.readthedocs.io/en/latest/rate-limits.html
-// these are the rate limits we have to plan for:
-// dns_api_read: 10/s, 50/min
-// dns_api_write_domain: 10/s, 300/min, 1000/h
-// dns_api_write_rrsets: 2/s, 15/min, 30/h, 300/day
-
+// ddnsmgr carries out the queries and updates of the rlddns signers, paced by the
+// "ddns" rate limiter (signers.ddns.limits, see music/ratelimiter.go).
 func ddnsmgr(conf *Config, done <-chan struct{}) {
-
-	ddnsfetch := conf.Internal.DdnsFetch
-	ddnsupdate := conf.Internal.DdnsUpdate
-
-	// we use the limit per minute
-	var fetch_limit = viper.GetInt("signers.ddns.limits.fetch")   // per second
-	var update_limit = viper.GetInt("signers.ddns.limits.update") // per second
-
-	if fetch_limit == 0 {
-		log.Fatalf("Error: signers.ddns.limits.fetch must be defined and > 0. Likely value: 5 (op/s).")
+	if err := music.CheckRateLimits("ddns", music.OpRead, music.OpWriteRRset); err != nil {
+		log.Fatalf("Error: %v. Likely values: 5 (read), 2 (write-rrset) op/s.", err)
 	}
-	if update_limit == 0 {
-		log.Fatalf("Error: signers.ddns.limits.update must be defined and > 0. Likely value: 2 (op/s).")
+	if err := music.CheckThrottleClasses(); err != nil {
+		log.Fatalf("Error: %v", err)
 	}
 
 	log.Println("Starting DDNS Manager. Will rate-limit DDNS requests (queries and updates).")
 
-	// fetch_ticker := time.NewTicker(time.Minute)
-	// update_ticker := time.NewTicker(time.Minute)
-	fetch_ticker := time.NewTicker(5 * time.Second)
-	update_ticker := time.NewTicker(5 * time.Second)
-
-	//	go Recoverer("DDNS fetch routine", func() {
-	// ddns fetcher
-	go func() {
-		var fetchOpQueue = []music.SignerOp{}
-		var rl bool
-		var err error
-		var fdop, op music.SignerOp
-		var fetch_ops, hold int
-		for {
-			select {
-			case op = <-ddnsfetch:
-				fetchOpQueue = append(fetchOpQueue, op)
-				// fmt.Printf("ddnsmgr: request for '%s %s'\n", op.Owner, dns.TypeToString[op.RRtype])
-
-			case <-fetch_ticker.C:
-				if cliconf.Debug {
-					log.Printf("DDNS fetch_ticker: Total ops last period: %d. Ops in queue: %d\n",
-						fetch_ops, len(fetchOpQueue))
-				}
-				fetch_ops = 0
-				for {
-					if len(fetchOpQueue) == 0 {
-						// fmt.Printf("DDNS fetch: queue empty, nothing to do\n")
-						break
-					}
-					fdop = fetchOpQueue[0]
-					fetchOpQueue = fetchOpQueue[1:]
-
-					log.Printf("ddnsmgr: Fetch request to signer %s (%s) for '%s %s'\n",
-						fdop.Signer.Name, fdop.Signer.Address,
-						fdop.Owner, dns.TypeToString[fdop.RRtype])
-					for {
-						rl, hold, err = music.RLDdnsFetchRRset(fdop)
-						if err != nil {
-							log.Printf("ddnsmgr: Error from RLDdnsFetchRRset: %v\n", err)
-						}
-						// fmt.Printf("ddnsmgr: response from RLDdnsFetchRRset: rl: %v hold: %d err: %v\n", rl, hold, err)
-						if !rl {
-							// fmt.Printf("ddnsmgr: all ok, done with this request\n")
-							break
-						} else {
-							fmt.Printf("ddnsmgr: fetch was rate-limited. Will sleep for %d seconds\n", hold)
-							time.Sleep(time.Duration(hold) * time.Second)
-						}
-					}
-					fetch_ops++
-					if fetch_ops >= fetch_limit {
-						break // the loop for this minute
-					}
-
-				}
-
-			case <-done:
-				fetch_ticker.Stop()
-				log.Println("DDNS Mgr fetch ticker: stop signal received.")
-			}
-		}
-	}()
-	//	},
-	//		func(interface{}) {
-	//		   log.Printf("*** DDNS fetch routine bombed\n")
-	//		   } )
-
-	// ddns updater
-	go func() {
-		var updateOpQueue = []music.SignerOp{}
-		var rl bool
-		var err error
-		var op, udop music.SignerOp
-		var update_ops, hold int
-		for {
-			select {
-			case op = <-ddnsupdate:
-				updateOpQueue = append(updateOpQueue, op)
-				// log.Printf("ddnsmgr: request for '%s %s'\n", op.Owner, dns.TypeToString[op.RRtype])
-
-			case <-update_ticker.C:
-				if cliconf.Debug {
-					log.Printf("DDNS update_ticker: Total ops last period: %d. Ops in queue: %d\n",
-						update_ops, len(updateOpQueue))
-				}
-				update_ops = 0
-				for {
-					if len(updateOpQueue) == 0 {
-						// fmt.Printf("DDNS update: queue empty, nothing to do\n")
-						break
-					}
-					udop = updateOpQueue[0]
-					updateOpQueue = updateOpQueue[1:]
-
-					// log.Printf("ddnsmgr: update request for '%s %s'\n",
-					// 			udop.Owner, dns.TypeToString[udop.RRtype])
-					for {
-						rl, hold, err = music.RLDdnsUpdate(udop)
-						if err != nil {
-							log.Printf("ddnsmgr: Error from RLDdnsUpdate: %v\n", err)
-						}
-						// fmt.Printf("ddnsmgr: response from RLDdnsUpdate: rl: %v hold: %d err: %v\n", rl, hold, err)
-						if !rl {
-							// fmt.Printf("ddnsmgr: all ok, done with this request\n")
-							break
-						} else {
-							fmt.Printf("ddnsmgr: update was rate-limited. Will sleep for %d seconds\n", hold)
-							time.Sleep(time.Duration(hold) * time.Second)
-						}
-					}
-					update_ops++
-					if update_ops >= update_limit {
-						break // the loop for this minute
-					}
-				}
-
-			case <-done:
-				update_ticker.Stop()
-				log.Println("DDNS Mgr update ticker: stop signal received.")
-
-			}
-		}
-	}()
+	limiter := music.GetRateLimiter("ddns")
+	mdb := conf.Internal.MusicDB
+	go rlManagerQueue("ddnsmgr", "ddns-fetch", mdb, limiter, music.OpRead,
+		conf.Internal.DdnsFetch, music.RLDdnsFetchRRset, done)
+	go rlManagerQueue("ddnsmgr", "ddns-update", mdb, limiter, music.OpWriteRRset,
+		conf.Internal.DdnsUpdate, music.RLDdnsUpdate, done)
 }
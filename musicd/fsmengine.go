@@ -33,7 +33,7 @@ func NewInterval(current, target, mininterval, maxinterval, count int) int {
 func FSMEngine(conf *Config, stopch chan struct{}) {
 	mdb := conf.Internal.MusicDB
 	var err error
-	var count int
+	var count, skipped int
 	var zones []music.Zone
 	var zonename string
 	var checkitem music.EngineCheck
@@ -78,6 +78,21 @@ func FSMEngine(conf *Config, stopch chan struct{}) {
 	}
 
 	log.Printf("Starting FSM Engine (will run once every %d seconds)", current)
+	if workers := music.FSMWorkers(); workers > 1 {
+		log.Printf("FSM Engine: pushing up to %d signer groups at a time", workers)
+	}
+	if music.ObserverMode() {
+		log.Printf("FSM Engine: observer mode, no updates will be sent and no zone will change state.")
+	}
+
+	Observe := func() {
+		if !music.ObserverMode() {
+			return
+		}
+		if err := mdb.ObserveZones(); err != nil {
+			log.Printf("FSMEngine: Error from ObserveZones: %v", err)
+		}
+	}
 
 	ticker := time.NewTicker(time.Duration(current) * time.Second)
 	completeticker := time.NewTicker(time.Duration(completeinterval) * time.Second)
@@ -85,6 +100,7 @@ func FSMEngine(conf *Config, stopch chan struct{}) {
 	_, err = mdb.PushZones(nil, emptymap, true) // check ALL zones
 	if err != nil {
 		log.Printf("FSMEngine: Error from PushZones: %v", err)
+		music.ReportError("fsmengine", err, nil)
 	}
 
 	UpdateTicker := func() {
@@ -126,15 +142,24 @@ func FSMEngine(conf *Config, stopch chan struct{}) {
 			}
 			if err != nil {
 				log.Printf("FSMEngine: Error from PushZones: %v", err)
+				music.ReportError("fsmengine", err, nil)
 			}
 			ReportProgress()
 			UpdateTicker()
 
 		case <-ticker.C:
+			// when backpressure slows the engine down only every factor:th tick is a run
+			if skipped++; skipped < music.BackpressureFactor() {
+				log.Printf("FSM Engine: backpressure, skipping this run (%d)", skipped)
+				continue
+			}
+			skipped = 0
 			zones, err = mdb.PushZones(nil, emptymap, false) // check non-blocked zones only
 			if err != nil {
 				log.Printf("FSMEngine: Error from PushZones: %v", err)
+				music.ReportError("fsmengine", err, nil)
 			}
+			Observe()
 			ReportProgress()
 			UpdateTicker()
 
@@ -142,7 +167,9 @@ func FSMEngine(conf *Config, stopch chan struct{}) {
 			zones, err = mdb.PushZones(nil, emptymap, true) // check ALL zones
 			if err != nil {
 				log.Printf("FSMEngine: Error from PushZones: %v", err)
+				music.ReportError("fsmengine", err, nil)
 			}
+			Observe()
 			ReportProgress()
 			UpdateTicker()
 
@@ -0,0 +1,80 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/spf13/viper"
+)
+
+// GitOpsRun fetches the latest definitions, computes the plan and, if apply is true,
+// applies it. The plan (and the result of applying it) is returned for display.
+func GitOpsRun(conf *Config, apply bool) ([]string, error) {
+	mdb := conf.Internal.MusicDB
+	dir := viper.GetString("gitops.dir")
+
+	err := music.GitOpsSync(viper.GetString("gitops.repo"), viper.GetString("gitops.branch"), dir)
+	if err != nil {
+		return []string{}, err
+	}
+
+	def, err := music.LoadStateDefinitions(dir)
+	if err != nil {
+		return []string{}, err
+	}
+
+	plan, err := mdb.PlanState(def)
+	if err != nil {
+		return []string{}, err
+	}
+
+	if !apply {
+		var out []string
+		for _, pi := range plan {
+			out = append(out, pi.String())
+		}
+		return out, nil
+	}
+	return mdb.ApplyPlan(def, plan, conf.Internal.EngineCheck), nil
+}
+
+// GitOpsLoop periodically syncs the definitions in gitops.dir (optionally a checkout of
+// gitops.repo) with the MusicDB. Unless gitops.apply is true only the plan is logged.
+func GitOpsLoop(conf *Config, stopch chan struct{}) {
+	if !viper.GetBool("gitops.active") {
+		return
+	}
+
+	interval := viper.GetInt("gitops.interval")
+	if interval < 60 {
+		interval = 60
+	}
+	apply := viper.GetBool("gitops.apply")
+	log.Printf("Starting GitOps sync of %s (will run once every %d seconds, apply: %v)",
+		viper.GetString("gitops.dir"), interval, apply)
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+
+	for {
+		select {
+		case <-ticker.C:
+			out, err := GitOpsRun(conf, apply)
+			if err != nil {
+				log.Printf("GitOps: Error: %v", err)
+			}
+			for _, line := range out {
+				log.Printf("GitOps: %s", line)
+			}
+
+		case <-stopch:
+			ticker.Stop()
+			log.Println("GitOps: stop signal received.")
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// KeyInventoryScanner periodically fetches the DNSKEY RRsets of all zones from all their
+// signers, so that the DNSKEY inventory also covers zones that are not in a process.
+func KeyInventoryScanner(conf *Config, stopch chan struct{}) {
+	mdb := conf.Internal.MusicDB
+
+	interval := viper.GetInt("keyinventory.interval")
+	if interval <= 0 {
+		log.Printf("KeyInventoryScanner is NOT active. Only DNSKEYs fetched by the processes are recorded.")
+		return
+	}
+	if interval < 10 {
+		interval = 10
+	}
+	log.Printf("Starting KeyInventoryScanner (will run once every %d minutes)", interval)
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := mdb.ScanKeyInventory()
+			if err != nil {
+				log.Printf("KeyInventoryScanner: Error from ScanKeyInventory: %v", err)
+			}
+			log.Printf("KeyInventoryScanner: %d zones scanned", n)
+
+		case <-stopch:
+			ticker.Stop()
+			log.Println("KeyInventoryScanner: stop signal received.")
+			return
+		}
+	}
+}
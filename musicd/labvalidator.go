@@ -0,0 +1,46 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/spf13/viper"
+)
+
+// LabValidator periodically resolves the lab zones through a local validating resolver
+// and reports the zones that fail to validate (see music/labvalidator.go).
+func LabValidator(conf *Config, stopch chan struct{}) {
+	mdb := conf.Internal.MusicDB
+
+	if !music.LabValidatorActive() {
+		return
+	}
+
+	interval := viper.GetInt("lab.validator.interval")
+	if interval < 5 {
+		interval = 5
+	}
+	log.Printf("Starting LabValidator (resolver %s, will run once every %d seconds)",
+		viper.GetString("lab.validator.resolver"), interval)
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := mdb.ValidateLabZones(); err != nil {
+				log.Printf("LabValidator: Error from ValidateLabZones: %v", err)
+			}
+
+		case <-stopch:
+			ticker.Stop()
+			log.Println("LabValidator: stop signal received.")
+			return
+		}
+	}
+}
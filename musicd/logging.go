@@ -0,0 +1,292 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/spf13/viper"
+)
+
+// Log output. By default musicd logs to stderr. With log.file set the log is written
+// to that file instead, rotated by size (log.maxsize, MB) and age (log.maxage, hours).
+// With log.syslog set the log is also sent to syslog (and thereby to journald), with
+// the severity derived from the message. Each subsystem (selected by a regexp matched
+// against the start of the log message) may have its own file and syslog setting under
+// log.subsystems.<name>.
+//
+// The log files may also be rotated by an external tool (newsyslog, logrotate), which
+// then signals musicd to reopen them, see ReopenLogs and service.go.
+
+type sysLogger interface {
+	Crit(string) error
+	Err(string) error
+	Warning(string) error
+	Info(string) error
+	Debug(string) error
+}
+
+type logOutput struct {
+	name   string
+	match  *regexp.Regexp
+	file   io.Writer
+	syslog sysLogger
+}
+
+type logRouter struct {
+	mu         sync.Mutex
+	subsystems []logOutput
+	def        logOutput
+}
+
+var activeLogRouter *logRouter // set by SetupLogging, nil when logging to stderr
+
+func (lr *logRouter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	out := &lr.def
+	for i := range lr.subsystems {
+		if lr.subsystems[i].match.MatchString(msg) {
+			out = &lr.subsystems[i]
+			break
+		}
+	}
+
+	var err error
+	if out.file != nil {
+		_, err = fmt.Fprintf(out.file, "%s %s\n", time.Now().Format("2006/01/02 15:04:05"), msg)
+	}
+	if out.syslog != nil {
+		switch logSeverity(msg) {
+		case "crit":
+			err = out.syslog.Crit(msg)
+		case "err":
+			err = out.syslog.Err(msg)
+		case "warning":
+			err = out.syslog.Warning(msg)
+		case "debug":
+			err = out.syslog.Debug(msg)
+		default:
+			err = out.syslog.Info(msg)
+		}
+	}
+	return len(p), err
+}
+
+// logSeverity maps a log message to a syslog severity. The log messages carry no
+// explicit level, so this is a guess based on the wording.
+func logSeverity(msg string) string {
+	lmsg := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lmsg, "panic") || strings.Contains(lmsg, "fatal"):
+		return "crit"
+	case strings.Contains(lmsg, "error") || strings.Contains(lmsg, "failed"):
+		return "err"
+	case strings.Contains(lmsg, "warning"):
+		return "warning"
+	case strings.Contains(lmsg, "debug"):
+		return "debug"
+	}
+	return "info"
+}
+
+// rotatingFile is a log file that is rotated when it grows larger than maxsize or
+// older than maxage. At most maxbackups rotated files are kept.
+type rotatingFile struct {
+	path       string
+	maxsize    int64
+	maxage     time.Duration
+	maxbackups int
+	f          *os.File
+	size       int64
+	opened     time.Time
+}
+
+func newRotatingFile(path string, maxsize int64, maxage time.Duration, maxbackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxsize: maxsize, maxage: maxage, maxbackups: maxbackups}
+	return rf, rf.open()
+}
+
+func (rf *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	backup := rf.path + "." + time.Now().Format("20060102-150405.000")
+	if err := os.Rename(rf.path, backup); err != nil {
+		return err
+	}
+
+	backups, _ := filepath.Glob(rf.path + ".*")
+	sort.Strings(backups)
+	for len(backups) > rf.maxbackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return rf.open()
+}
+
+// reopen closes the file and opens it again, e.g. after it was renamed by newsyslog.
+func (rf *rotatingFile) reopen() error {
+	if rf.f != nil {
+		rf.f.Close()
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	if rf.size+int64(len(p)) > rf.maxsize || time.Since(rf.opened) > rf.maxage {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rotating log file %s: %v\n", rf.path, err)
+			if rf.f == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func setupLogOutput(name, key string) (logOutput, error) {
+	out := logOutput{name: name}
+
+	if file := viper.GetString(key + ".file"); file != "" {
+		maxsize := viper.GetInt64("log.maxsize")
+		if maxsize <= 0 {
+			maxsize = 100
+		}
+		maxage := viper.GetInt("log.maxage")
+		if maxage <= 0 {
+			maxage = 24
+		}
+		maxbackups := viper.GetInt("log.maxbackups")
+		if maxbackups <= 0 {
+			maxbackups = 7
+		}
+		rf, err := newRotatingFile(file, maxsize*1024*1024, time.Duration(maxage)*time.Hour,
+			maxbackups)
+		if err != nil {
+			return out, fmt.Errorf("log file %s: %v", file, err)
+		}
+		out.file = rf
+	}
+
+	if viper.GetBool(key + ".syslog") {
+		facility := viper.GetString(key + ".facility")
+		if facility == "" {
+			facility = viper.GetString("log.facility")
+		}
+		sl, err := newSyslog(facility, "musicd")
+		if err != nil {
+			return out, fmt.Errorf("syslog: %v", err)
+		}
+		out.syslog = sl
+	}
+	return out, nil
+}
+
+// SetupLogging directs the standard logger according to the log section of the config.
+func SetupLogging() error {
+	def, err := setupLogOutput("default", "log")
+	if err != nil {
+		return err
+	}
+	if def.file == nil && def.syslog == nil {
+		if len(viper.GetStringMap("log.subsystems")) == 0 {
+			return nil // plain stderr, as always
+		}
+		def.file = os.Stderr
+	}
+
+	lr := &logRouter{def: def}
+	var names []string
+	for name := range viper.GetStringMap("log.subsystems") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := "log.subsystems." + name
+		match := viper.GetString(key + ".match")
+		if match == "" {
+			match = regexp.QuoteMeta(name)
+		}
+		re, err := regexp.Compile("(?i)^(" + match + ")")
+		if err != nil {
+			return fmt.Errorf("log subsystem %s: bad match: %v", name, err)
+		}
+		out, err := setupLogOutput(name, key)
+		if err != nil {
+			return fmt.Errorf("log subsystem %s: %v", name, err)
+		}
+		if out.file == nil && out.syslog == nil {
+			out.file, out.syslog = def.file, def.syslog
+		}
+		out.match = re
+		lr.subsystems = append(lr.subsystems, out)
+	}
+
+	log.SetFlags(0) // the router adds timestamps where needed
+	log.SetOutput(lr)
+	activeLogRouter = lr
+	return nil
+}
+
+// ReopenLogs reopens the log files and the query log, so that rotated files are let go.
+func ReopenLogs() {
+	music.ReopenQueryLog()
+
+	lr := activeLogRouter
+	if lr == nil {
+		return
+	}
+	lr.mu.Lock()
+	outputs := append([]logOutput{lr.def}, lr.subsystems...)
+	seen := map[*rotatingFile]bool{}
+	var errs []string
+	for _, out := range outputs {
+		rf, ok := out.file.(*rotatingFile)
+		if !ok || seen[rf] {
+			continue
+		}
+		seen[rf] = true
+		if err := rf.reopen(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", rf.path, err))
+		}
+	}
+	lr.mu.Unlock()
+
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "Error reopening log file %s\n", e)
+	}
+	log.Printf("ReopenLogs: %d log files reopened", len(seen)-len(errs))
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import "fmt"
+
+func newSyslog(facility, tag string) (sysLogger, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
@@ -32,11 +32,15 @@ var cliconf = music.CliConfig{}
 // This will wait forever on an external signal, but even better would be
 // if we could wait on an external signal OR an internal quit channel. TBD.
 //
-func mainloop(conf *Config, apistopper chan struct{}) {
+func mainloop(conf *Config, apistopper chan struct{}, svcstopper <-chan struct{}) {
 	exit := make(chan os.Signal, 1)
 	signal.Notify(exit, syscall.SIGINT, syscall.SIGTERM)
 	hupper := make(chan os.Signal, 1)
 	signal.Notify(hupper, syscall.SIGHUP)
+	reopener := make(chan os.Signal, 1)
+	if len(reopenSignals) > 0 {
+		signal.Notify(reopener, reopenSignals...)
+	}
 
 	log.Println("mainloop: entering signal dispatcher")
 
@@ -63,8 +67,16 @@ func mainloop(conf *Config, apistopper chan struct{}) {
 				time.Sleep(1 * time.Second)
 				// do whatever we need to do to wrap up nicely
 				wg.Done()
+			case <-svcstopper:
+				log.Println("mainloop: Service stop received. Cleaning up.")
+				wg.Done()
 			case <-hupper:
 				log.Println("mainloop: SIGHUP received.")
+				ReloadAPICert()
+				ReopenLogs()
+			case <-reopener:
+				log.Println("mainloop: reopening log files.")
+				ReopenLogs()
 			}
 		}
 	}()
@@ -72,7 +84,11 @@ func mainloop(conf *Config, apistopper chan struct{}) {
 
 	conf.Internal.TokViper.WriteConfig()
 	fmt.Printf("mainloop: saved state of API tokens to disk\n")
+	music.CloseDnsPool()
+	music.FlushErrorReports(5 * time.Second)
+	removePidFile()
 	fmt.Println("mainloop: leaving signal dispatcher")
+	serviceStopped()
 }
 
 func LoadConfig(conf *Config, safemode bool) error {
@@ -100,6 +116,9 @@ func LoadConfig(conf *Config, safemode bool) error {
 	if err != nil {
 		log.Fatalf("Could not load config (%s)", err)
 	}
+	for _, w := range ApplyConfigRenames(viper.GetViper()) {
+		log.Printf("LoadConfig: %s", w)
+	}
 
 	ValidateConfig(nil, DefaultCfgFile, false) // will terminate on error
 
@@ -133,8 +152,27 @@ func main() {
 		flag.PrintDefaults()
 	}
 
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "migrate" {
+		os.Exit(ConfigMigrate(os.Args[3:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		os.Exit(serviceCommand(os.Args[2:]))
+	}
+
+	svcstopper, err := startService() // before LoadConfig, as it may change directory
+	if err != nil {
+		log.Fatalf("Error from startService: %v", err)
+	}
+
 	LoadConfig(&conf, false) // on initial startup a config error should cause an abort.
 
+	if err := SetupLogging(); err != nil {
+		log.Fatalf("Error from SetupLogging: %v", err)
+	}
+	if err := writePidFile(); err != nil {
+		log.Fatalf("Error writing pid file: %v", err)
+	}
+
 	// initialise empty conf.Internal struct
 	conf.Internal = InternalConf{}
 
@@ -186,6 +224,18 @@ func main() {
 	}
 	go ddnsmgr(&conf, done)
 	go FSMEngine(&conf, done)
-
-	mainloop(&conf, apistopper)
+	go Reconciler(&conf, done)
+	go KeyInventoryScanner(&conf, done)
+	go Cleaner(&conf, done)
+	go LabValidator(&conf, done)
+	go ChildScanner(&conf, done)
+	go conf.Internal.MusicDB.RunScheduler(conf.Internal.EngineCheck, done)
+	go conf.Internal.MusicDB.RunTsigRotations(done)
+	go GitOpsLoop(&conf, done)
+	go StateExporter(&conf, done)
+	go MetricsCollector(&conf, done)
+	go ParentProber(&conf, done)
+	go ShardKeeper(&conf, done)
+
+	mainloop(&conf, apistopper, svcstopper)
 }
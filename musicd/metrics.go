@@ -0,0 +1,87 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/spf13/viper"
+)
+
+// Collecting the per-zone metrics requires queries to all signers and parents, so it
+// is done periodically by MetricsCollector rather than on every scrape.
+var zoneMetrics = struct {
+	mu   sync.RWMutex
+	text string
+}{}
+
+func MetricsCollector(conf *Config, stopch chan struct{}) {
+	mdb := conf.Internal.MusicDB
+
+	if !viper.GetBool("metrics.active") {
+		return
+	}
+
+	interval := viper.GetInt("metrics.interval")
+	if interval < 15 {
+		interval = 15
+	}
+	log.Printf("Starting MetricsCollector (will collect zone metrics once every %d seconds)",
+		interval)
+
+	collect := func() {
+		zml, err := mdb.CollectZoneMetrics()
+		if err != nil {
+			log.Printf("MetricsCollector: Error from CollectZoneMetrics: %v", err)
+			return
+		}
+		text := music.FormatZoneMetrics(zml)
+		if viper.GetBool("signers.propagation.active") {
+			stats, err := mdb.ListPropagationStats(nil, "", false)
+			if err != nil {
+				log.Printf("MetricsCollector: Error from ListPropagationStats: %v", err)
+			} else {
+				text += music.FormatPropagationMetrics(stats)
+			}
+		}
+		zoneMetrics.mu.Lock()
+		zoneMetrics.text = text
+		zoneMetrics.mu.Unlock()
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	collect()
+
+	for {
+		select {
+		case <-ticker.C:
+			collect()
+
+		case <-stopch:
+			ticker.Stop()
+			log.Println("MetricsCollector: stop signal received.")
+			return
+		}
+	}
+}
+
+// APImetrics serves the latest collected metrics to Prometheus. The endpoint is not
+// behind the API key, as scrapers generally cannot send custom headers.
+func APImetrics(conf *Config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zoneMetrics.mu.RLock()
+		text := zoneMetrics.text
+		zoneMetrics.mu.RUnlock()
+		text += music.FormatOpQueueMetrics(music.ListOpQueueStats()) // cheap, always current
+		text += music.FormatProcessMetrics()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(text))
+	}
+}
@@ -0,0 +1,49 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ParentProber periodically checks the running parent probes and records the observed
+// capabilities of the parents.
+func ParentProber(conf *Config, stopch chan struct{}) {
+	mdb := conf.Internal.MusicDB
+
+	if !viper.GetBool("parentprobe.active") {
+		return
+	}
+
+	interval := viper.GetInt("parentprobe.interval")
+	if interval < 60 {
+		interval = 60
+	}
+	timeout := viper.GetInt("parentprobe.timeout")
+	if timeout < 1 {
+		timeout = 72
+	}
+	log.Printf("Starting ParentProber (will check parent probes once every %d seconds)", interval)
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+
+	for {
+		select {
+		case <-ticker.C:
+			err := mdb.CheckParentProbes(time.Duration(timeout) * time.Hour)
+			if err != nil {
+				log.Printf("ParentProber: Error from CheckParentProbes: %v", err)
+			}
+
+		case <-stopch:
+			ticker.Stop()
+			log.Println("ParentProber: stop signal received.")
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Reconciler periodically moves all zones that have a desired signer set one step
+// closer to that set. Drift (e.g. a signer removed by hand) is therefore corrected
+// automatically on the next run.
+func Reconciler(conf *Config, stopch chan struct{}) {
+	mdb := conf.Internal.MusicDB
+
+	if !viper.GetBool("reconciler.active") {
+		log.Printf("Reconciler is NOT active. Desired signer sets will not be acted upon.")
+		return
+	}
+
+	interval := viper.GetInt("reconciler.interval")
+	if interval < 30 {
+		interval = 30
+	}
+	log.Printf("Starting Reconciler (will run once every %d seconds)", interval)
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+
+	for {
+		select {
+		case <-ticker.C:
+			result, err := mdb.ReconcileZones(conf.Internal.EngineCheck)
+			if err != nil {
+				log.Printf("Reconciler: Error from ReconcileZones: %v", err)
+			}
+			for zone, msg := range result {
+				log.Printf("Reconciler: %s: %s", zone, msg)
+			}
+
+		case <-stopch:
+			ticker.Stop()
+			log.Println("Reconciler: stop signal received.")
+			return
+		}
+	}
+}
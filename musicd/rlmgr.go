@@ -0,0 +1,160 @@
+/*
+ * Johan Stenstam
+ */
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+)
+
+// rlManagerQueue runs one queue of a rate-limited updater (see ddnsmgr and deSECmgr).
+// Ops arrive on opc and wait in an OpQueue. Whenever an op arrives or an op is done, the
+// manager starts the next queued op for every signer that has no op running, each in a
+// goroutine of its own. Ops to one signer are thus carried out one at a time, as fast as
+// the rate limiter of the backend allows for the signer and class, while a signer that is
+// slow or holding us back does not delay the ops to the other signers. run returns
+// rl=true if the signer asked us to back off for hold seconds, in which case the class is
+// held for the signer and the op is sent again, unless its deadline has passed. An op
+// of a zone whose throttling class has used up its share of the signer's rate stays
+// queued, so the ops of other zones to the signer go first, and the manager wakes up
+// when the share allows the op (see music/throttle.go). Queued ops that pass their
+// deadline (or whose caller has given up) are failed every second (see OpQueue).
+func rlManagerQueue(mgr, name string, mdb *music.MusicDB, limiter *music.RateLimiter, class string,
+	opc chan music.SignerOp, run func(music.SignerOp) (bool, int, error), done <-chan struct{}) {
+	defer music.ReportPanics(mgr, nil)
+
+	queue := music.NewOpQueue(name, mdb)
+	ticker := time.NewTicker(time.Second)
+	busy := map[string]bool{} // signers with an op running
+	finished := make(chan string)
+	var ops int
+
+	wakeup := make(chan struct{}, 1)
+	var throttled time.Duration // until the first throttled op may be started
+
+	eligible := func(op music.SignerOp) bool {
+		if op.Signer == nil {
+			return true
+		}
+		if busy[strings.ToLower(op.Signer.Name)] {
+			return false
+		}
+		if wait := limiter.ThrottleWait(op.Signer.Name, class, op.Throttle); wait > 0 {
+			if throttled == 0 || wait < throttled {
+				throttled = wait
+			}
+			return false
+		}
+		return true
+	}
+	dispatch := func() {
+		throttled = 0
+		defer func() {
+			if throttled > 0 {
+				time.AfterFunc(throttled, func() {
+					select {
+					case wakeup <- struct{}{}:
+					default:
+					}
+				})
+			}
+		}()
+		for {
+			op, ok := queue.PopEligible(eligible)
+			if !ok {
+				return // nothing to do until an op arrives, a signer is done or a class may go on
+			}
+			if op.Signer == nil { // e.g. the test ops sent by "ping"
+				log.Printf("%s: [%s] op without signer ignored", mgr, op.ID)
+				continue
+			}
+			limiter.TakeThrottle(op.Signer.Name, class, op.Throttle)
+			signer := strings.ToLower(op.Signer.Name)
+			busy[signer] = true
+			ops++
+			log.Printf("%s: [%s] %s request to signer %s for '%s %s'%s\n", mgr, op.ID, class,
+				op.Signer.Name, op.Owner, dns.TypeToString[op.RRtype], throttleNote(op.Throttle))
+			go func(op music.SignerOp) {
+				defer music.ReportPanics(mgr, map[string]string{"signer": op.Signer.Name, "op": op.ID})
+				rlManagerRun(mgr, limiter, class, op, run)
+				select {
+				case finished <- signer:
+				case <-done:
+				}
+			}(op)
+		}
+	}
+
+	for {
+		select {
+		case op := <-opc:
+			queue.Push(op)
+			dispatch()
+
+		case signer := <-finished:
+			delete(busy, signer)
+			dispatch()
+
+		case <-wakeup:
+			dispatch()
+
+		case <-ticker.C:
+			if cliconf.Debug && ops > 0 {
+				log.Printf("%s: %s: ops last period: %d. Ops in queue: %d. Signers busy: %d\n", mgr,
+					name, ops, queue.Len(), len(busy))
+			}
+			ops = 0
+			queue.Expire()
+
+		case <-done:
+			ticker.Stop()
+			log.Printf("%s: %s: stop signal received.", mgr, name)
+			return
+		}
+	}
+}
+
+func rlManagerRun(mgr string, limiter *music.RateLimiter, class string, op music.SignerOp,
+	run func(music.SignerOp) (bool, int, error)) {
+	for {
+		limiter.Wait(op.Signer.Name, class)
+		if op.Expired(time.Now()) {
+			log.Printf("%s: [%s] deadline passed while rate-limited, %s request to %s dropped\n",
+				mgr, op.ID, class, op.Signer.Name)
+			op.Respond(music.SignerOpResult{Error: fmt.Errorf("%s: %w", mgr, music.ErrOpDeadline)})
+			return
+		}
+		op.Running()
+		rl, hold, err := run(op)
+		if err != nil {
+			log.Printf("%s: [%s] Error from %s request: rl: %v hold: %d err: %v\n", mgr, op.ID,
+				class, rl, hold, err)
+			music.ReportError(mgr, err, map[string]string{
+				"signer": op.Signer.Name, "zone": op.Zone, "op": op.ID})
+			if !rl { // the op has not been responded to
+				op.Respond(music.SignerOpResult{Error: err})
+			}
+		}
+		if !rl {
+			return
+		}
+		log.Printf("%s: [%s] %s request was rate-limited by %s. Holding for %d seconds.\n", mgr,
+			op.ID, class, op.Signer.Name, hold)
+		op.Held(hold)
+		limiter.Hold(op.Signer.Name, class, hold)
+	}
+}
+
+func throttleNote(throttle string) string {
+	if throttle == "" {
+		return ""
+	}
+	return " (throttling class " + throttle + ")"
+}
@@ -0,0 +1,51 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// Running musicd as a service. Under systemd musicd simply runs in the foreground and
+// logs to stderr (i.e. journald). For other service managers:
+//
+//   rc.d (FreeBSD, OpenBSD, NetBSD): with service.pidfile set musicd writes its pid to
+//        that file at startup and removes it when it stops. SIGUSR1 makes musicd reopen
+//        its log files (log.file, log.subsystems.*.file and querylog.file) so that they
+//        may be rotated by newsyslog; SIGHUP does the same and also reloads the API
+//        certificate. See packaging/ for rc.d scripts and a newsyslog.conf entry.
+//
+//   Windows: when started by the service control manager musicd runs as the service
+//        "musicd", from the directory of the executable (so that the config is found in
+//        ..\etc as usual) and, unless log.file is set, logs to ..\log\musicd.log. The
+//        service is created with "musicd service install" and deleted with "musicd
+//        service remove". See service_windows.go.
+
+// writePidFile writes the pid of musicd to service.pidfile, if set.
+func writePidFile() error {
+	file := viper.GetString("service.pidfile")
+	if file == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+}
+
+func removePidFile() {
+	file := viper.GetString("service.pidfile")
+	if file == "" {
+		return
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing pid file %s: %v", file, err)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// the signals that make musicd reopen its log files (in addition to SIGHUP)
+var reopenSignals = []os.Signal{syscall.SIGUSR1}
+
+// startService returns a channel on which the service manager asks musicd to stop.
+// Outside Windows stopping is done with signals, so the channel is nil.
+func startService() (<-chan struct{}, error) {
+	return nil, nil
+}
+
+// serviceStopped tells the service manager that musicd has stopped.
+func serviceStopped() {}
+
+func serviceCommand(args []string) int {
+	fmt.Printf("musicd service: only supported on Windows. Use the rc.d scripts in packaging/ or systemd.\n")
+	return 1
+}
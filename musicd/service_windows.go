@@ -0,0 +1,172 @@
+//go:build windows
+// +build windows
+
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "musicd"
+
+// no SIGUSR1 on Windows; log files are reopened on SIGHUP only
+var reopenSignals []os.Signal
+
+var winService struct {
+	stopped chan struct{} // closed by serviceStopped
+	done    chan struct{} // closed when svc.Run has returned
+}
+
+type musicService struct {
+	stop chan struct{}
+}
+
+// Execute is called by the service control manager (via svc.Run). It reports musicd as
+// running and passes a stop or shutdown request on to mainloop, then waits for musicd
+// to clean up before it reports the service as stopped.
+func (ms *musicService) Execute(args []string, r <-chan svc.ChangeRequest,
+	status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				ms.stop <- struct{}{}
+				<-winService.stopped
+				return false, 0
+			default:
+				log.Printf("musicService: unexpected control request #%d", c.Cmd)
+			}
+		case <-winService.stopped: // musicd stopped on its own, e.g. via the API
+			return false, 0
+		}
+	}
+}
+
+// startService checks whether musicd was started by the service control manager and,
+// if so, prepares the environment and registers with it. It must be called before the
+// config is loaded.
+func startService() (<-chan struct{}, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return nil, err
+	}
+
+	// Services are started in %SystemRoot%\System32.
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(exe)
+	if err := os.Chdir(dir); err != nil {
+		return nil, err
+	}
+	viper.SetDefault("log.file", filepath.Join(dir, "..", "log", "musicd.log")) // no stderr
+
+	stop := make(chan struct{}, 1)
+	winService.stopped = make(chan struct{})
+	winService.done = make(chan struct{})
+	go func() {
+		defer close(winService.done)
+		if err := svc.Run(serviceName, &musicService{stop: stop}); err != nil {
+			log.Fatalf("Error from svc.Run(%s): %v", serviceName, err)
+		}
+	}()
+	return stop, nil
+}
+
+// serviceStopped tells the service control manager that musicd has stopped.
+func serviceStopped() {
+	if winService.stopped == nil {
+		return // not a service
+	}
+	close(winService.stopped)
+	select {
+	case <-winService.done:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// serviceCommand handles "musicd service install|remove".
+func serviceCommand(args []string) int {
+	var err error
+	switch {
+	case len(args) == 1 && args[0] == "install":
+		err = installService()
+	case len(args) == 1 && args[0] == "remove":
+		err = removeService()
+	default:
+		fmt.Printf("Usage: musicd service install|remove\n")
+		return 1
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func installService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "MUSIC daemon",
+		Description: "Multi-Signer Controller: keeps the signers of multi-signer zones in sync",
+		StartType:   mgr.StartAutomatic,
+	})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	fmt.Printf("Service %s installed (%s). Start it with \"sc start %s\".\n", serviceName, exe,
+		serviceName)
+	return nil
+}
+
+func removeService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	fmt.Printf("Service %s removed.\n", serviceName)
+	return nil
+}
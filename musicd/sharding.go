@@ -0,0 +1,56 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+)
+
+// ShardKeeper keeps this instance a member of the shards sharing the DB (see
+// music/sharding.go) and kicks the FSM engine when the zones are rebalanced, so that the
+// zones this instance takes over are looked at right away.
+func ShardKeeper(conf *Config, stopch chan struct{}) {
+	mdb := conf.Internal.MusicDB
+
+	if !music.ShardingActive() {
+		return
+	}
+
+	self := music.ShardName()
+	interval := music.ShardHeartbeatInterval()
+	log.Printf("Starting ShardKeeper: instance %s (heartbeat every %v)", self, interval)
+
+	heartbeat := func() {
+		changed, err := mdb.ShardHeartbeat(self)
+		if err != nil {
+			log.Printf("ShardKeeper: Error from ShardHeartbeat: %v", err)
+			return
+		}
+		if changed {
+			members := music.ShardMembers()
+			log.Printf("ShardKeeper: %s is one of %d instance(s): %v. Zones rebalanced.",
+				self, len(members), members)
+			conf.Internal.EngineCheck <- music.EngineCheck{}
+		}
+	}
+
+	heartbeat()
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			heartbeat()
+
+		case <-stopch:
+			ticker.Stop()
+			mdb.ShardLeave(self)
+			log.Println("ShardKeeper: stop signal received.")
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+//
+// Johan Stenstam, johan.stenstam@internetstiftelsen.se
+//
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/DNSSEC-Provisioning/music/music"
+	"github.com/spf13/viper"
+)
+
+// StateExporter periodically writes the state of all zones and signers to the file
+// stateexport.file, for monitoring systems that are unable to use the API.
+func StateExporter(conf *Config, stopch chan struct{}) {
+	mdb := conf.Internal.MusicDB
+
+	if !viper.GetBool("stateexport.active") {
+		return
+	}
+
+	file := viper.GetString("stateexport.file")
+	if file == "" {
+		log.Printf("StateExporter: stateexport.file not specified. State will not be exported.")
+		return
+	}
+	interval := viper.GetInt("stateexport.interval")
+	if interval < 10 {
+		interval = 10
+	}
+	probe := viper.GetBool("stateexport.probe")
+	log.Printf("Starting StateExporter (will write %s once every %d seconds)", file, interval)
+
+	export := func() {
+		se, err := mdb.ExportState(probe)
+		if err != nil {
+			log.Printf("StateExporter: Error from ExportState: %v", err)
+			return
+		}
+		if err = music.WriteStateExport(file, se); err != nil {
+			log.Printf("StateExporter: Error writing %s: %v", file, err)
+		}
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	export()
+
+	for {
+		select {
+		case <-ticker.C:
+			export()
+
+		case <-stopch:
+			ticker.Stop()
+			log.Println("StateExporter: stop signal received.")
+			return
+		}
+	}
+}
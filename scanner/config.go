@@ -28,7 +28,7 @@ type Config struct {
 }
 
 type ScannerConf struct {
-	Zones    string `validate:"required", "file"`
+	Zones    string `validate:"required,file"`
 	Interval int
 }
 
@@ -129,19 +129,9 @@ func RunScannerNG(conf *Config, zonesng map[string]ZoneNG) {
 		// for zone, parent := range zonesng {
 		//	for zone, z := range zonesng {
 		log.Printf("Zone %s: \n", zone)
-		dsadd, dsremove := CreateDsUpdateNG(z)
-		for _, rr := range dsadd {
-			rr.Hdr.Rrtype = 43
-		}
-
-		adds := []dns.RR{}
-		for _, value := range dsadd {
-			adds = append(adds, &value.DS)
-		}
-
-		removes := []dns.RR{}
-		for _, value := range dsremove {
-			removes = append(removes, value)
+		adds, removes, err := CreateDsUpdateNG(z)
+		if err != nil {
+			log.Printf("Zone %s: not updating parent DS RRset: %v", zone, err)
 		}
 		log.Printf("value is a %T with value of %v", removes, removes)
 
@@ -179,7 +169,7 @@ func RunScannerNG(conf *Config, zonesng map[string]ZoneNG) {
 		for _, zns := range z.DelegationNS {
 			if zns.CSYNC == "" {
 				log.Printf("Zone %s: No CSYNC at %s, not updating %s NS in %s",
-					zone, zone, z.PName)
+					zone, zns.NSName, zone, z.PName)
 			} else {
 				updateNsFlag++
 			}
@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/miekg/dns"
+
+	"github.com/DNSSEC-Provisioning/music/music"
 )
 
 func GetIP(hostname string, serverport string) string {
@@ -253,7 +255,7 @@ func CreateNsUpdateNG(zone string, zng ZoneNG) ([]dns.RR, []dns.RR, error) {
 			if _, ok := zns.NSes[ckey]; !ok {
 			        // WTF? children?
 				// return nil, nil, fmt.Errorf("children are not in sync send error, ns:%s is not in child:%s", ckey, child.hostname)
-				return nil, nil, fmt.Errorf("Zone %s: nameservers are not in sync send error, ns:%s is not in NS:%s", ckey, zns.NSName)
+				return nil, nil, fmt.Errorf("Zone %s: nameservers are not in sync send error, ns:%s is not in NS:%s", zone, ckey, zns.NSName)
 			}
 		}
 	}
@@ -328,49 +330,25 @@ func CreateDsUpdate(zone string, parent *Parent) ([]*dns.CDS, []*dns.DS) {
 	return dsadd, dsremove
 }
 
-func CreateDsUpdateNG(z ZoneNG) ([]*dns.CDS, []*dns.DS) {
-	dsmap := make(map[uint16]*dns.DS)
-	cdsmap := make(map[uint16]*dns.CDS)
-	var dsremove []*dns.DS
-	var dsadd []*dns.CDS // Gets converte to DS in main.go
+// CreateDsUpdateNG compares the CDS RRsets from the name servers of the zone with the DS
+// RRset in the parent. The comparison is shared with musicd (see music/childds.go).
+func CreateDsUpdateNG(z ZoneNG) ([]dns.RR, []dns.RR, error) {
 	log.Printf("Zone %s: Creating DS Update", z.Name)
 
-	// DSes
-	for _, ds := range z.CurrentDS {
-		// dsmap[fmt.Sprintf("%d %d %d %s", ds.KeyTag, ds.Algorithm,
-		// 	ds.DigestType, ds.Digest)] = ds
-		dsmap[ds.KeyTag] = ds
-	}
-	log.Printf("%s -> DS = %v", z.PName, dsmap)
-
-	// CDSes TODO: Possible bug that the childrens CDS records are not compared to each other.
+	cdsets := map[string][]*dns.CDS{}
 	for _, zns := range z.DelegationNS {
-		for _, cds := range zns.CDS {
-			// cdsmap[fmt.Sprintf("%d %d %d %s", cds.KeyTag,
-			// 			  cds.Algorithm,
-			//	cds.DigestType, cds.Digest)] = cds
-			cdsmap[cds.KeyTag] = cds
-		}
-		log.Printf("%s -> CDS = %v", zns.NSName, cdsmap)
+		cdsets[zns.NSName] = zns.CDS
+		log.Printf("%s -> CDS = %v", zns.NSName, zns.CDS)
 	}
+	log.Printf("%s -> DS = %v", z.PName, z.CurrentDS)
 
-	// if in CDSmap but not in DSmap = add to DS-SET
-	for key, _ := range cdsmap {
-		if _, ok := dsmap[key]; !ok {
-			dsadd = append(dsadd, cdsmap[key])
-		}
-	}
-
-	// if in DSmap but not in CDSmap = Remove from DS-SET
-	for key, _ := range dsmap {
-		if _, ok := cdsmap[key]; !ok {
-			dsremove = append(dsremove, dsmap[key])
-		}
+	dsadd, dsremove, err := music.ComputeDsUpdate(z.Name, z.CurrentDS, cdsets)
+	if err != nil {
+		return nil, nil, err
 	}
-
 	log.Printf("Add to DS set %v", dsadd)
 	log.Printf("Remove from DS set %v", dsremove)
-	return dsadd, dsremove
+	return dsadd, dsremove, nil
 }
 
 /*